@@ -0,0 +1,680 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// Account deletion job types
+const (
+	jobTypeExportAccount       = "account.export"
+	jobTypeEraseAccount        = "account.erase"
+	jobTypeExpireAccountExport = "account.export_expire"
+	jobTypePurgeAccount        = "account.purge"
+)
+
+// Account deletion statuses. An erased account keeps only its issued tax
+// invoices, which are purged with the account once their retention ends.
+const (
+	accountDeletionPending   = "pending"
+	accountDeletionCancelled = "cancelled"
+	accountDeletionErased    = "erased"
+)
+
+// eventUserDeletionRequested and eventUserErased audit account deletion
+const (
+	eventUserDeletionRequested = "user.deletion_requested"
+	eventUserErased            = "user.erased"
+)
+
+// accountMasterTables are exported by their table name and erased with the account
+var accountMasterTables = []string{
+	"companies", "customers", "items", "suppliers", "purchase_orders",
+	"excel_mappings", "invoice_series", "user_settings", "tags",
+	"proforma_documents", "delivery_challans", "purchase_invoices", "invoice_approvals",
+	"transporters", "vehicles",
+}
+
+// DeleteAccountRequest confirms an account deletion. Accounts with a password
+// must give it; accounts created through Google sign-in confirm their email.
+type DeleteAccountRequest struct {
+	Password     string `json:"password"`
+	ConfirmEmail string `json:"confirm_email"`
+}
+
+// createAccountTables creates the table tracking account deletion requests
+func createAccountTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS account_deletions (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			token_hash VARCHAR(64) NOT NULL UNIQUE,
+			export_zip BYTEA,
+			export_ready_at TIMESTAMP,
+			export_expires_at TIMESTAMP NOT NULL,
+			scheduled_for TIMESTAMP NOT NULL,
+			retain_until TIMESTAMP,
+			retained_invoices INTEGER NOT NULL DEFAULT 0,
+			erased_at TIMESTAMP,
+			cancelled_at TIMESTAMP,
+			requested_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create account_deletions table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_account_deletions_pending
+		ON account_deletions (user_id) WHERE status = 'pending'
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create account_deletions index: %v", err)
+	}
+
+	registerJobHandler(jobTypeExportAccount, runExportAccountJob)
+	registerJobHandler(jobTypeEraseAccount, runEraseAccountJob)
+	registerJobHandler(jobTypeExpireAccountExport, runExpireAccountExportJob)
+	registerJobHandler(jobTypePurgeAccount, runPurgeAccountJob)
+}
+
+// getEnvDays reads a whole number of days from the environment
+func getEnvDays(key string, defaultDays int) time.Duration {
+	days, err := strconv.Atoi(getEnvWithDefault(key, strconv.Itoa(defaultDays)))
+	if err != nil || days < 0 {
+		log.Printf("Invalid %s, using %d", key, defaultDays)
+		days = defaultDays
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// getAccountDeletionGrace returns how long a deletion request can be cancelled before erasure
+func getAccountDeletionGrace() time.Duration {
+	return getEnvDays("ACCOUNT_DELETION_GRACE_DAYS", 7)
+}
+
+// getAccountExportTTL returns how long the export download link stays valid after the request
+func getAccountExportTTL() time.Duration {
+	return getEnvDays("ACCOUNT_EXPORT_DAYS", 30)
+}
+
+// getTaxRecordRetentionMonths returns how long issued tax invoices are kept.
+// Section 36 of the CGST Act requires 72 months from the annual return due date.
+func getTaxRecordRetentionMonths() int {
+	months, err := strconv.Atoi(getEnvWithDefault("TAX_RECORD_RETENTION_MONTHS", "72"))
+	if err != nil || months < 0 {
+		log.Printf("Invalid TAX_RECORD_RETENTION_MONTHS, using 72")
+		return 72
+	}
+	return months
+}
+
+// taxRecordRetainUntil returns when an invoice dated docDate may be erased:
+// the retention period counted from 31 December after its financial year,
+// the due date of the annual return for that year
+func taxRecordRetainUntil(docDate time.Time) time.Time {
+	fyEnd := models.FiscalYearOf(docDate).StartYear + 1
+	return time.Date(fyEnd, time.December, 31, 0, 0, 0, 0, istLocation).
+		AddDate(0, getTaxRecordRetentionMonths(), 0)
+}
+
+// hashExportToken returns the stored form of an export download token
+func hashExportToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// handleDeleteAccount schedules erasure of the authenticated user's account.
+// A ZIP export of all their data is prepared first and can be downloaded
+// through the returned link; the request can be cancelled during the grace period.
+func handleDeleteAccount(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req DeleteAccountRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user := models.User{ID: userID}
+	err := dbPool.QueryRow(ctx,
+		"SELECT email, password FROM users WHERE id = $1", userID).Scan(&user.Email, &user.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user.Password != "" {
+		if !user.CheckPassword(req.Password) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Password is incorrect"})
+			return
+		}
+	} else if !strings.EqualFold(strings.TrimSpace(req.ConfirmEmail), user.Email) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "confirm_email must match your account email"})
+		return
+	}
+
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+	token := hex.EncodeToString(tokenBytes)
+	grace := getAccountDeletionGrace()
+
+	var deletionID int
+	var scheduledFor, expiresAt time.Time
+	err = dbPool.QueryRow(ctx, `
+		INSERT INTO account_deletions (user_id, token_hash, scheduled_for, export_expires_at)
+		VALUES ($1, $2, NOW() + $3 * INTERVAL '1 second', NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (user_id) WHERE status = 'pending' DO NOTHING
+		RETURNING id, scheduled_for, export_expires_at
+	`, userID, hashExportToken(token), int64(grace.Seconds()), int64(getAccountExportTTL().Seconds())).
+		Scan(&deletionID, &scheduledFor, &expiresAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Account deletion is already scheduled"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+
+	payload := gin.H{"deletion_id": deletionID}
+	if _, err := enqueueJob(ctx, userID, jobTypeExportAccount, payload); err != nil {
+		log.Printf("Error queueing export for account deletion %d: %v", deletionID, err)
+	}
+	// Erasure runs without an owner so that deleting the user cannot remove its job
+	if _, err := enqueueDelayedJob(ctx, 0, jobTypeEraseAccount, payload, grace); err != nil {
+		dbPool.Exec(ctx, "DELETE FROM account_deletions WHERE id = $1", deletionID)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule account deletion"})
+		return
+	}
+	recordEvent(ctx, dbPool, userID, eventUserDeletionRequested, entityUser, userID,
+		loginAudit{Email: user.Email, IP: c.ClientIP()})
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":           "Account deletion scheduled",
+		"deletion_id":       deletionID,
+		"scheduled_for":     scheduledFor,
+		"download_url":      apiV1Prefix + "/account-export/" + token,
+		"download_expires":  expiresAt,
+		"retention_message": "Issued tax invoices are retained until their statutory retention period ends",
+	})
+}
+
+// handleGetAccountDeletion returns the status of the user's latest deletion request
+func handleGetAccountDeletion(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var id int
+	var status string
+	var exportReadyAt *time.Time
+	var scheduledFor, expiresAt, requestedAt time.Time
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT id, status, export_ready_at, scheduled_for, export_expires_at, requested_at
+		FROM account_deletions
+		WHERE user_id = $1
+		ORDER BY requested_at DESC
+		LIMIT 1
+	`, userID).Scan(&id, &status, &exportReadyAt, &scheduledFor, &expiresAt, &requestedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No account deletion has been requested"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"deletion_id":      id,
+		"status":           status,
+		"export_ready":     exportReadyAt != nil,
+		"scheduled_for":    scheduledFor,
+		"download_expires": expiresAt,
+		"requested_at":     requestedAt,
+	})
+}
+
+// handleCancelAccountDeletion cancels a pending deletion during the grace period
+func handleCancelAccountDeletion(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var id int
+	err := dbPool.QueryRow(c.Request.Context(), `
+		UPDATE account_deletions
+		SET status = $1, cancelled_at = NOW(), export_zip = NULL
+		WHERE user_id = $2 AND status = $3
+		RETURNING id
+	`, accountDeletionCancelled, userID, accountDeletionPending).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No pending account deletion to cancel"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel account deletion"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Account deletion cancelled",
+		"deletion_id": id,
+	})
+}
+
+// handleDownloadAccountExport serves an account export through its download
+// link. The link works without logging in, as the account may already be erased.
+func handleDownloadAccountExport(c *gin.Context) {
+	var zipData []byte
+	var readyAt *time.Time
+	var status string
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT export_zip, export_ready_at, status FROM account_deletions
+		WHERE token_hash = $1 AND export_expires_at > NOW()
+	`, hashExportToken(c.Param("token"))).Scan(&zipData, &readyAt, &status)
+	if errors.Is(err, pgx.ErrNoRows) || status == accountDeletionCancelled {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Export not found or expired"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if readyAt == nil {
+		c.Header("Retry-After", "60")
+		c.JSON(http.StatusAccepted, gin.H{"message": "Export is still being prepared"})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=einvoice-account-export.zip")
+	c.Header("Cache-Control", "private, no-store")
+	c.Data(http.StatusOK, "application/zip", zipData)
+}
+
+// buildAccountExport zips all of a user's invoices with their QR codes, the
+// master data tables and the account profile
+func buildAccountExport(ctx context.Context, userID int) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	add := func(name string, data []byte) error {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+	}
+	addTable := func(name, query string) error {
+		var data []byte
+		if err := dbPool.QueryRow(ctx, query, userID).Scan(&data); err != nil {
+			return fmt.Errorf("failed to export %s: %w", name, err)
+		}
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, data, "", "  "); err != nil {
+			return err
+		}
+		return add(name+".json", pretty.Bytes())
+	}
+
+	err := addTable("profile", `
+		SELECT json_build_object('id', id, 'email', email, 'name', name, 'phone', phone,
+			'sandbox', sandbox, 'created_at', created_at)
+		FROM users WHERE id = $1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	for _, table := range accountMasterTables {
+		err := addTable(table,
+			"SELECT COALESCE(json_agg(t ORDER BY t.id), '[]') FROM "+table+" t WHERE t.user_id = $1")
+		if err != nil {
+			return nil, err
+		}
+	}
+	err = addTable("purchase_invoice_items", `
+		SELECT COALESCE(json_agg(i ORDER BY i.purchase_invoice_id, i.line_no), '[]')
+		FROM purchase_invoice_items i
+		JOIN purchase_invoices p ON p.id = i.purchase_invoice_id
+		WHERE p.user_id = $1
+	`)
+	if err != nil {
+		return nil, err
+	}
+	err = addTable("payments",
+		"SELECT COALESCE(json_agg(t ORDER BY t.id), '[]') FROM payments t WHERE t.user_id = $1")
+	if err != nil {
+		return nil, err
+	}
+	err = addTable("account_members",
+		"SELECT COALESCE(json_agg(t ORDER BY t.id), '[]') FROM account_members t WHERE t.owner_id = $1 OR t.member_id = $1")
+	if err != nil {
+		return nil, err
+	}
+	err = addTable("invoices", `
+		SELECT COALESCE(json_agg(json_build_object(
+			'id', id, 'invoice_no', invoice_no, 'seller_gstin', seller_gstin, 'status', status,
+			'sandbox', sandbox, 'irn', irn, 'ack_no', ack_no, 'ack_dt', ack_dt,
+			'exported_at', exported_at, 'cancel_reason', cancel_reason, 'cancelled_at', cancelled_at,
+			'created_at', created_at) ORDER BY id), '[]')
+		FROM invoices WHERE user_id = $1
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := dbPool.Query(ctx,
+		"SELECT id, invoice_no, invoice_json, total_value FROM invoices WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var invoiceNo string
+		var invoiceJSON []byte
+		var total float64
+		if err := rows.Scan(&id, &invoiceNo, &invoiceJSON, &total); err != nil {
+			return nil, err
+		}
+		name := fmt.Sprintf("%d-%s", id, sanitizeFilename(invoiceNo))
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, invoiceJSON, "", "  "); err != nil {
+			pretty.Reset()
+			pretty.Write(invoiceJSON)
+		}
+		if err := add("invoices/"+name+".json", pretty.Bytes()); err != nil {
+			return nil, err
+		}
+		qr, err := encodeQR(invoiceQRContent(invoiceNo, total))
+		if err != nil {
+			return nil, err
+		}
+		if err := add("qr/"+name+".png", qr); err != nil {
+			return nil, err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// accountDeletionPayload is the payload of all account deletion jobs
+type accountDeletionPayload struct {
+	DeletionID int `json:"deletion_id"`
+}
+
+// loadAccountDeletion reads a deletion job's payload and its request's user and status
+func loadAccountDeletion(ctx context.Context, job *models.Job) (accountDeletionPayload, int, string, error) {
+	var payload accountDeletionPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return payload, 0, "", permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+	var userID int
+	var status string
+	err := dbPool.QueryRow(ctx,
+		"SELECT user_id, status FROM account_deletions WHERE id = $1", payload.DeletionID).
+		Scan(&userID, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return payload, 0, "", permanentError(fmt.Errorf("account deletion %d not found", payload.DeletionID))
+	}
+	return payload, userID, status, err
+}
+
+// runExportAccountJob prepares the data export of an account being deleted
+func runExportAccountJob(ctx context.Context, job *models.Job) error {
+	payload, userID, status, err := loadAccountDeletion(ctx, job)
+	if err != nil {
+		return err
+	}
+	if status != accountDeletionPending {
+		return nil
+	}
+
+	zipData, err := buildAccountExport(ctx, userID)
+	if err != nil {
+		return err
+	}
+	_, err = dbPool.Exec(ctx,
+		"UPDATE account_deletions SET export_zip = $1, export_ready_at = NOW() WHERE id = $2 AND status = $3",
+		zipData, payload.DeletionID, accountDeletionPending)
+	return err
+}
+
+// deleteReturningIDs deletes rows and records a deleted event for each, so
+// projections such as the search index drop them too
+func deleteReturningIDs(ctx context.Context, tx pgx.Tx, userID int, query, eventType, entityType string) error {
+	rows, err := tx.Query(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, id := range ids {
+		recordEvent(ctx, tx, userID, eventType, entityType, id, gin.H{})
+	}
+	return nil
+}
+
+// runEraseAccountJob erases an account at the end of its grace period. Drafts,
+// sandbox invoices and all master data are deleted and the user is anonymized;
+// issued tax invoices are kept until their retention period ends.
+func runEraseAccountJob(ctx context.Context, job *models.Job) error {
+	payload, userID, status, err := loadAccountDeletion(ctx, job)
+	if err != nil {
+		return err
+	}
+	if status != accountDeletionPending {
+		return nil
+	}
+
+	// The user must be able to download their data before it is erased
+	var exportReady bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT export_ready_at IS NOT NULL FROM account_deletions WHERE id = $1",
+		payload.DeletionID).Scan(&exportReady)
+	if err != nil {
+		return err
+	}
+	if !exportReady {
+		return errors.New("account export is not ready yet")
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var email string
+	if err := tx.QueryRow(ctx, "SELECT email FROM users WHERE id = $1 FOR UPDATE", userID).Scan(&email); err != nil {
+		return err
+	}
+
+	deletions := []struct {
+		query, eventType, entityType string
+	}{
+		{"DELETE FROM invoices WHERE user_id = $1 AND (status = 'draft' OR sandbox) RETURNING id", eventInvoiceDeleted, entityInvoice},
+		{"DELETE FROM customers WHERE user_id = $1 RETURNING id", eventCustomerDeleted, entityCustomer},
+		{"DELETE FROM items WHERE user_id = $1 RETURNING id", entityItem + ".deleted", entityItem},
+	}
+	for _, d := range deletions {
+		if err := deleteReturningIDs(ctx, tx, userID, d.query, d.eventType, d.entityType); err != nil {
+			return fmt.Errorf("failed to erase %ss: %w", d.entityType, err)
+		}
+	}
+	for _, table := range append([]string{"invoice_emails", "invoice_whatsapp_messages", "sms_messages", "notification_rules", "digest_runs", "imports", "integrations", "invoice_syncs", "payment_gateways"}, accountMasterTables...) {
+		if table == "customers" || table == "items" {
+			continue
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM "+table+" WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("failed to erase %s: %w", table, err)
+		}
+	}
+	// Members lose access to the account, and the user leaves any account they work on
+	_, err = tx.Exec(ctx, "DELETE FROM account_members WHERE owner_id = $1 OR member_id = $1", userID)
+	if err != nil {
+		return fmt.Errorf("failed to erase account_members: %w", err)
+	}
+
+	// Work out how long the remaining issued invoices must be kept
+	rows, err := tx.Query(ctx,
+		"SELECT COALESCE(invoice_json->'DocDtls'->>'Dt', ''), created_at FROM invoices WHERE user_id = $1", userID)
+	if err != nil {
+		return err
+	}
+	var retained int
+	var retainUntil time.Time
+	for rows.Next() {
+		var docDate string
+		var createdAt time.Time
+		if err := rows.Scan(&docDate, &createdAt); err != nil {
+			rows.Close()
+			return err
+		}
+		date, err := time.Parse(invoiceDateLayout, docDate)
+		if err != nil {
+			date = createdAt
+		}
+		if until := taxRecordRetainUntil(date); until.After(retainUntil) {
+			retainUntil = until
+		}
+		retained++
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users
+		SET email = 'deleted-' || id || '@deleted.invalid', password = '', google_sub = NULL,
+			name = '', phone = '', sandbox = FALSE, is_admin = FALSE, is_super_admin = FALSE, token_version = token_version + 1
+		WHERE id = $1
+	`, userID)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM login_attempts WHERE email = $1", normalizeLoginEmail(email)); err != nil {
+		return err
+	}
+
+	var retainUntilArg *time.Time
+	if retained > 0 {
+		retainUntilArg = &retainUntil
+	}
+	var expiresAt time.Time
+	err = tx.QueryRow(ctx, `
+		UPDATE account_deletions
+		SET status = $1, erased_at = NOW(), retained_invoices = $2, retain_until = $3
+		WHERE id = $4
+		RETURNING export_expires_at
+	`, accountDeletionErased, retained, retainUntilArg, payload.DeletionID).Scan(&expiresAt)
+	if err != nil {
+		return err
+	}
+	recordEvent(ctx, tx, userID, eventUserErased, entityUser, userID, gin.H{"retained_invoices": retained})
+
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("Erased account %d, retaining %d issued invoice(s)", userID, retained)
+
+	// The export outlives erasure until its link expires, and the account
+	// itself is removed with the last retained invoice
+	if _, err := enqueueDelayedJob(ctx, 0, jobTypeExpireAccountExport, payload, time.Until(expiresAt)); err != nil {
+		log.Printf("Error scheduling export expiry for account deletion %d: %v", payload.DeletionID, err)
+	}
+	purgeAt := expiresAt
+	if retainUntil.After(purgeAt) {
+		purgeAt = retainUntil
+	}
+	if _, err := enqueueDelayedJob(ctx, 0, jobTypePurgeAccount, payload, time.Until(purgeAt)); err != nil {
+		log.Printf("Error scheduling purge for account deletion %d: %v", payload.DeletionID, err)
+	}
+	return nil
+}
+
+// runExpireAccountExportJob drops an account export once its download link has expired
+func runExpireAccountExportJob(ctx context.Context, job *models.Job) error {
+	payload, _, _, err := loadAccountDeletion(ctx, job)
+	if err != nil {
+		return err
+	}
+	_, err = dbPool.Exec(ctx,
+		"UPDATE account_deletions SET export_zip = NULL WHERE id = $1 AND export_expires_at <= NOW()",
+		payload.DeletionID)
+	return err
+}
+
+// runPurgeAccountJob removes an erased account once its retained invoices
+// may be deleted, taking the deletion request with it
+func runPurgeAccountJob(ctx context.Context, job *models.Job) error {
+	payload, userID, status, err := loadAccountDeletion(ctx, job)
+	if err != nil {
+		return err
+	}
+	if status != accountDeletionErased {
+		return nil
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	err = deleteReturningIDs(ctx, tx, userID,
+		"DELETE FROM invoices WHERE user_id = $1 RETURNING id", eventInvoiceDeleted, entityInvoice)
+	if err != nil {
+		return err
+	}
+	if err := purgeArchivedInvoices(ctx, tx, userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM payments WHERE user_id = $1", userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx, "DELETE FROM users WHERE id = $1", userID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("Purged erased account %d (deletion %d)", userID, payload.DeletionID)
+	return nil
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// promoteAdminsFromEnv grants admin rights to the users listed in ADMIN_EMAILS
+func promoteAdminsFromEnv() {
+	adminEmails := os.Getenv("ADMIN_EMAILS")
+	if adminEmails == "" {
+		return
+	}
+
+	emails := make([]string, 0)
+	for _, email := range strings.Split(adminEmails, ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+
+	result, err := dbPool.Exec(context.Background(),
+		"UPDATE users SET is_admin = TRUE WHERE email = ANY($1) AND NOT is_admin", emails)
+	if err != nil {
+		log.Printf("Error promoting admin users: %v", err)
+		return
+	}
+	if result.RowsAffected() > 0 {
+		log.Printf("Granted admin rights to %d user(s) from ADMIN_EMAILS", result.RowsAffected())
+	}
+}
+
+// adminMiddleware restricts a route group to users with admin rights.
+// It must run after authMiddleware.
+func adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var isAdmin bool
+		err := dbPool.QueryRow(c.Request.Context(),
+			"SELECT is_admin FROM users WHERE id = $1", c.GetInt("userID")).Scan(&isAdmin)
+		if err != nil || !isAdmin {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Admin access required", "code": errCodeAdminRequired})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// handleAdminGetJobs lists background jobs, defaulting to the dead-letter queue
+func handleAdminGetJobs(c *gin.Context) {
+	status := c.DefaultQuery("status", models.JobStatusDead)
+	jobType := c.Query("type")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT `+jobColumns+`
+		FROM jobs
+		WHERE status = $1 AND ($2 = '' OR type = $2)
+		ORDER BY updated_at DESC
+		LIMIT $3
+	`, status, jobType, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch jobs"})
+		return
+	}
+	defer rows.Close()
+
+	jobs := make([]*models.Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read job data"})
+			return
+		}
+		jobs = append(jobs, job)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"jobs": jobs})
+}
+
+// handleAdminGetJob returns a single job with its full error history
+func handleAdminGetJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := scanJob(dbPool.QueryRow(c.Request.Context(),
+		"SELECT "+jobColumns+" FROM jobs WHERE id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch job"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"job": job})
+}
+
+// handleAdminUpdateJobPayload replaces the payload of a dead job before it is retried
+func handleAdminUpdateJobPayload(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	var payload json.RawMessage
+	if err := c.ShouldBindJSON(&payload); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job payload: " + err.Error()})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE jobs SET payload = $1, updated_at = NOW()
+		WHERE id = $2 AND status = 'dead'
+	`, payload, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update job payload"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job payload updated successfully",
+		"job_id":  id,
+	})
+}
+
+// handleAdminRetryJob moves a dead job back to the queue with a fresh attempt budget
+func handleAdminRetryJob(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE jobs
+		SET status = 'pending', attempts = 0, run_at = NOW(), dead_at = NULL, updated_at = NOW()
+		WHERE id = $1 AND status = 'dead'
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retry job"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Dead job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Job queued for retry",
+		"job_id":  id,
+	})
+}
@@ -0,0 +1,537 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// User event types recorded when a super admin acts on an account; the
+// payload is an adminAction
+const (
+	eventUserDisabled      = "user.disabled"
+	eventUserEnabled       = "user.enabled"
+	eventUserImpersonated  = "user.impersonated"
+	eventUserPasswordReset = "user.password_reset"
+)
+
+// impersonationTTL is how long a support session on a user's account lasts
+const impersonationTTL = time.Hour
+
+// errAccountDisabled is returned for users whose account a super admin disabled
+var errAccountDisabled = errors.New("account is disabled")
+
+// adminAction records a super admin's action on a user's account
+type adminAction struct {
+	AdminID int    `json:"admin_id"`
+	IP      string `json:"ip"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// AdminUserRequest is the optional body of the disable, impersonate and
+// password reset routes
+type AdminUserRequest struct {
+	Reason string `json:"reason"`
+}
+
+// createAdminUserColumns adds the super admin role, which runs the user
+// admin routes, and the disabled and forced password reset states of accounts
+func createAdminUserColumns() {
+	_, err := dbPool.Exec(context.Background(), `
+		ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS is_super_admin BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS disabled_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS disabled_reason TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS password_reset_required BOOLEAN NOT NULL DEFAULT FALSE
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add admin columns to users table: %v", err)
+	}
+	promoteSuperAdminsFromEnv()
+}
+
+// promoteSuperAdminsFromEnv grants the super admin role to the users listed
+// in SUPER_ADMIN_EMAILS
+func promoteSuperAdminsFromEnv() {
+	emails := make([]string, 0)
+	for _, email := range strings.Split(os.Getenv("SUPER_ADMIN_EMAILS"), ",") {
+		if email = strings.TrimSpace(email); email != "" {
+			emails = append(emails, email)
+		}
+	}
+	if len(emails) == 0 {
+		return
+	}
+
+	result, err := dbPool.Exec(context.Background(),
+		"UPDATE users SET is_super_admin = TRUE WHERE email = ANY($1) AND NOT is_super_admin", emails)
+	if err != nil {
+		log.Printf("Error promoting super admin users: %v", err)
+		return
+	}
+	if result.RowsAffected() > 0 {
+		log.Printf("Granted super admin role to %d user(s) from SUPER_ADMIN_EMAILS", result.RowsAffected())
+	}
+}
+
+// superAdminMiddleware restricts a route group to super admins. Impersonated
+// sessions never pass, even on a super admin's account. It must run after
+// authMiddleware.
+func superAdminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var isSuperAdmin bool
+		err := dbPool.QueryRow(c.Request.Context(),
+			"SELECT is_super_admin FROM users WHERE id = $1", c.GetInt("userID")).Scan(&isSuperAdmin)
+		if err != nil || !isSuperAdmin || c.GetInt("impersonatorID") != 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Super admin access required", "code": errCodeAdminRequired})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// noImpersonation keeps support sessions away from routes that change how the
+// user signs in, delete their account, or grant access that outlasts the
+// session: account members, stored provider credentials and connected
+// integrations. It must run after authMiddleware.
+func noImpersonation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetInt("impersonatorID") != 0 {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Not allowed while impersonating a user"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// passwordResetRoutes are the routes users whose password reset is forced may
+// still call, to see their profile and set a new password
+var passwordResetRoutes = map[string]bool{
+	"GET /api/me":          true,
+	"PUT /api/me/password": true,
+}
+
+// adminUserColumns are selected by scanAdminUser
+const adminUserColumns = `u.id, u.email, u.name, u.is_admin, u.is_super_admin, u.disabled_at, u.disabled_reason,
+	u.password_reset_required, u.google_sub IS NOT NULL, u.created_at,
+	(SELECT COUNT(*) FROM invoices i WHERE i.user_id = u.id),
+	(SELECT MAX(l.created_at) FROM login_attempts l WHERE l.email = LOWER(u.email) AND l.success),
+	m.owner_id, m.role`
+
+// adminUserFrom joins the account a member works on to the users table
+const adminUserFrom = ` FROM users u LEFT JOIN account_members m ON m.member_id = u.id`
+
+// scanAdminUser reads a row of adminUserColumns
+func scanAdminUser(row pgx.Row, extra ...interface{}) (gin.H, error) {
+	var id, invoices int
+	var email, name, disabledReason string
+	var isAdmin, isSuperAdmin, resetRequired, googleLinked bool
+	var createdAt time.Time
+	var disabledAt, lastLogin *time.Time
+	var ownerID *int
+	var role *string
+	dest := []interface{}{&id, &email, &name, &isAdmin, &isSuperAdmin, &disabledAt, &disabledReason,
+		&resetRequired, &googleLinked, &createdAt, &invoices, &lastLogin, &ownerID, &role}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	user := gin.H{
+		"id":                      id,
+		"email":                   email,
+		"name":                    name,
+		"is_admin":                isAdmin,
+		"is_super_admin":          isSuperAdmin,
+		"disabled":                disabledAt != nil,
+		"disabled_at":             disabledAt,
+		"password_reset_required": resetRequired,
+		"google_linked":           googleLinked,
+		"created_at":              createdAt,
+		"invoice_count":           invoices,
+		"last_login_at":           lastLogin,
+		"owner_id":                ownerID,
+		"member_role":             role,
+	}
+	if disabledAt != nil {
+		user["disabled_reason"] = disabledReason
+	}
+	return user, nil
+}
+
+// handleAdminGetUsers lists user accounts, optionally filtered by q, matched
+// against email and name, and by status: active or disabled
+func handleAdminGetUsers(c *gin.Context) {
+	status := c.Query("status")
+	if status != "" && status != "active" && status != "disabled" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be active or disabled"})
+		return
+	}
+	page, message := parsePageRequest(c, userSortFields, "-created_at")
+	if page == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	args := []interface{}{strings.TrimSpace(c.Query("q")), status}
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT `+adminUserColumns+`, `+page.keySQL("u.")+adminUserFrom+`
+		WHERE ($1 = '' OR u.email ILIKE '%' || $1 || '%' OR u.name ILIKE '%' || $1 || '%')
+			AND ($2 = '' OR (u.disabled_at IS NOT NULL) = ($2 = 'disabled'))`+
+		page.whereSQL("u.", &args)+page.orderSQL("u."), args...)
+	if err != nil {
+		log.Printf("Error fetching users: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	defer rows.Close()
+
+	users := make([]gin.H, 0)
+	for rows.Next() {
+		var pageKey string
+		user, err := scanAdminUser(rows, &pageKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read users"})
+			return
+		}
+		if !page.next(pageKey, int64(user["id"].(int))) {
+			break
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+
+	c.JSON(http.StatusOK, page.respond(gin.H{"users": users}))
+}
+
+// adminTargetUser reads the :id route parameter and the user it names. Errors
+// are answered on c.
+func adminTargetUser(c *gin.Context) (gin.H, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user ID"})
+		return nil, false
+	}
+	user, err := scanAdminUser(dbPool.QueryRow(c.Request.Context(),
+		"SELECT "+adminUserColumns+adminUserFrom+" WHERE u.id = $1", id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "User not found"})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil, false
+	}
+	return user, true
+}
+
+// handleAdminGetUser returns a user's account with its usage of the service
+func handleAdminGetUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+
+	var finalized, last30Days, registered, companies, members, customers int
+	var lastInvoiceAt *time.Time
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT
+			(SELECT COUNT(*) FROM invoices WHERE user_id = $1 AND status = 'finalized'),
+			(SELECT COUNT(*) FROM invoices WHERE user_id = $1 AND created_at > NOW() - INTERVAL '30 days'),
+			(SELECT COUNT(*) FROM invoices WHERE user_id = $1 AND irn IS NOT NULL),
+			(SELECT MAX(created_at) FROM invoices WHERE user_id = $1),
+			(SELECT COUNT(*) FROM companies WHERE user_id = $1),
+			(SELECT COUNT(*) FROM account_members WHERE owner_id = $1),
+			(SELECT COUNT(*) FROM customers WHERE user_id = $1)
+	`, user["id"]).Scan(&finalized, &last30Days, &registered, &lastInvoiceAt, &companies, &members, &customers)
+	if err != nil {
+		log.Printf("Error fetching usage of user %v: %v", user["id"], err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch usage"})
+		return
+	}
+
+	user["usage"] = gin.H{
+		"invoices_finalized":    finalized,
+		"invoices_last_30_days": last30Days,
+		"invoices_with_irn":     registered,
+		"last_invoice_at":       lastInvoiceAt,
+		"companies":             companies,
+		"members":               members,
+		"customers":             customers,
+	}
+	c.JSON(http.StatusOK, gin.H{"user": user})
+}
+
+// bindAdminUserRequest reads the optional request body
+func bindAdminUserRequest(c *gin.Context) (*AdminUserRequest, bool) {
+	var req AdminUserRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	}
+	req.Reason = strings.TrimSpace(req.Reason)
+	return &req, true
+}
+
+// recordAdminAction audits a super admin's action against the user it was taken on
+func recordAdminAction(c *gin.Context, eventType string, userID int, reason string) {
+	recordEvent(c.Request.Context(), dbPool, userID, eventType, entityUser, userID,
+		adminAction{AdminID: c.GetInt("userID"), IP: c.ClientIP(), Reason: reason})
+}
+
+// handleAdminDisableUser disables an account and signs it out everywhere.
+// Disabled users cannot sign in, and members of a disabled account cannot
+// work on it. Super admins cannot be disabled.
+func handleAdminDisableUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	req, ok := bindAdminUserRequest(c)
+	if !ok {
+		return
+	}
+	if user["is_super_admin"].(bool) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Super admins cannot be disabled"})
+		return
+	}
+	if user["disabled"].(bool) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already disabled"})
+		return
+	}
+
+	// Bumping the token version revokes every token issued so far
+	_, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE users SET disabled_at = NOW(), disabled_reason = $1, token_version = token_version + 1
+		WHERE id = $2
+	`, req.Reason, user["id"])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disable user"})
+		return
+	}
+	recordAdminAction(c, eventUserDisabled, user["id"].(int), req.Reason)
+
+	c.JSON(http.StatusOK, gin.H{"message": "User disabled"})
+}
+
+// handleAdminEnableUser lets a disabled user sign in again
+func handleAdminEnableUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if !user["disabled"].(bool) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is not disabled"})
+		return
+	}
+
+	_, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE users SET disabled_at = NULL, disabled_reason = '' WHERE id = $1", user["id"])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to enable user"})
+		return
+	}
+	recordAdminAction(c, eventUserEnabled, user["id"].(int), "")
+
+	c.JSON(http.StatusOK, gin.H{"message": "User enabled"})
+}
+
+// handleAdminImpersonateUser issues a token for a support session on a user's
+// account. The session lasts impersonationTTL, cannot change the user's
+// password or delete their account, and is recorded in the user's event log.
+func handleAdminImpersonateUser(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	req, ok := bindAdminUserRequest(c)
+	if !ok {
+		return
+	}
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to impersonate a user"})
+		return
+	}
+	if user["is_super_admin"].(bool) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Super admins cannot be impersonated"})
+		return
+	}
+	if user["disabled"].(bool) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is disabled"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID := user["id"].(int)
+	var version int
+	if err := dbPool.QueryRow(ctx, "SELECT token_version FROM users WHERE id = $1", userID).Scan(&version); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	expiresAt := time.Now().Add(impersonationTTL)
+	token, err := jwtKeys.sign(TokenClaims{
+		UserID:         userID,
+		TokenVersion:   version,
+		ImpersonatorID: c.GetInt("userID"),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+	recordAdminAction(c, eventUserImpersonated, userID, req.Reason)
+
+	c.JSON(http.StatusOK, gin.H{
+		"token":      token,
+		"expires_at": expiresAt,
+		"user":       gin.H{"id": userID, "email": user["email"]},
+	})
+}
+
+// handleAdminResetUserPassword replaces a user's password with a temporary
+// one, emailed to the user and never shown to the admin, and signs them out
+// everywhere. After signing in with it the user must set a new password
+// before doing anything else. Super admins cannot be reset, so a super admin
+// cannot take over another's account.
+func handleAdminResetUserPassword(c *gin.Context) {
+	user, ok := adminTargetUser(c)
+	if !ok {
+		return
+	}
+	if user["is_super_admin"].(bool) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Super admins cannot have their password reset"})
+		return
+	}
+	req, ok := bindAdminUserRequest(c)
+	if !ok {
+		return
+	}
+	cfg, err := getSMTPConfig()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email delivery is not configured"})
+		return
+	}
+
+	random := make([]byte, 12)
+	if _, err := rand.Read(random); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate password"})
+		return
+	}
+	temporary := base64.RawURLEncoding.EncodeToString(random)
+	email := user["email"].(string)
+	hashed, err := models.NewUser(email, temporary)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+	message, err := buildMessage(cfg.from, []string{email}, nil, "Your password was reset",
+		"An administrator reset the password of your e-invoice account and signed you out everywhere.\n\n"+
+			"Sign in with this temporary password, then choose a new one:\n\n    "+temporary+"\n\n"+
+			"If you did not ask for this, contact support.\n", nil)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build email"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `
+		UPDATE users SET password = $1, password_reset_required = TRUE, token_version = token_version + 1
+		WHERE id = $2
+	`, hashed.Password, user["id"])
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	// The password only changes once the user has been sent the new one
+	if err := sendMail(cfg, []string{email}, message); err != nil {
+		log.Printf("Error emailing temporary password to user %d: %v", user["id"], err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to email the temporary password; the password was not changed"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset password"})
+		return
+	}
+	recordAdminAction(c, eventUserPasswordReset, user["id"].(int), req.Reason)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password reset; a temporary password was emailed to " + email,
+	})
+}
+
+// handleAdminGetStats reports the usage of the service as a whole
+func handleAdminGetStats(c *gin.Context) {
+	var users, disabled, newUsers, activeUsers, members int
+	var invoices, invoices30Days, finalized, registered int64
+	var pendingJobs, deadJobs int
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT
+			(SELECT COUNT(*) FROM users WHERE email NOT LIKE '%@deleted.invalid'),
+			(SELECT COUNT(*) FROM users WHERE disabled_at IS NOT NULL),
+			(SELECT COUNT(*) FROM users WHERE created_at > NOW() - INTERVAL '30 days'),
+			(SELECT COUNT(DISTINCT email) FROM login_attempts WHERE success AND created_at > NOW() - INTERVAL '30 days'),
+			(SELECT COUNT(*) FROM account_members),
+			(SELECT COUNT(*) FROM invoices),
+			(SELECT COUNT(*) FROM invoices WHERE created_at > NOW() - INTERVAL '30 days'),
+			(SELECT COUNT(*) FROM invoices WHERE status = $1),
+			(SELECT COUNT(*) FROM invoices WHERE irn IS NOT NULL),
+			(SELECT COUNT(*) FROM jobs WHERE status = $2),
+			(SELECT COUNT(*) FROM jobs WHERE status = $3)
+	`, models.InvoiceStatusFinalized, models.JobStatusPending, models.JobStatusDead).Scan(
+		&users, &disabled, &newUsers, &activeUsers, &members,
+		&invoices, &invoices30Days, &finalized, &registered, &pendingJobs, &deadJobs)
+	if err != nil {
+		log.Printf("Error fetching usage stats: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch stats"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"users": gin.H{
+			"total":          users,
+			"disabled":       disabled,
+			"new_30_days":    newUsers,
+			"active_30_days": activeUsers,
+			"members":        members,
+		},
+		"invoices": gin.H{
+			"total":        invoices,
+			"last_30_days": invoices30Days,
+			"finalized":    finalized,
+			"with_irn":     registered,
+		},
+		"jobs": gin.H{
+			"pending": pendingJobs,
+			"dead":    deadJobs,
+		},
+		"db_pool": poolStats(),
+	})
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Error codes of API error responses. Clients branch on these rather than
+// on messages, which may change.
+const (
+	errCodeInvalidRequest        = "invalid_request"
+	errCodeSchemaViolation       = "schema_violation"
+	errCodeUnauthenticated       = "unauthenticated"
+	errCodeInvalidToken          = "invalid_token"
+	errCodeInvalidCredentials    = "invalid_credentials"
+	errCodePasswordResetRequired = "password_reset_required"
+	errCodeForbidden             = "forbidden"
+	errCodeAccountDisabled       = "account_disabled"
+	errCodeAdminRequired         = "admin_required"
+	errCodeOwnerRequired         = "owner_required"
+	errCodeNotFound              = "not_found"
+	errCodeConflict              = "conflict"
+	errCodeInvoiceLocked         = "invoice_locked"
+	errCodePeriodLocked          = "period_locked"
+	errCodePayloadTooLarge       = "payload_too_large"
+	errCodeUnsupportedMediaType  = "unsupported_media_type"
+	errCodeUnprocessable         = "unprocessable"
+	errCodeRateLimited           = "rate_limited"
+	errCodeTooManyLoginAttempts  = "too_many_login_attempts"
+	errCodeInternal              = "internal_error"
+	errCodeUpstreamError         = "upstream_error"
+	errCodeUnavailable           = "unavailable"
+	errCodeTimeout               = "timeout"
+)
+
+// errorCodeInfo describes an error code in the published catalog
+type errorCodeInfo struct {
+	Code        string `json:"code"`
+	Status      int    `json:"status"`
+	Description string `json:"description"`
+}
+
+// errorCatalog lists every error code, with the HTTP status it comes with
+var errorCatalog = []errorCodeInfo{
+	{errCodeInvalidRequest, http.StatusBadRequest, "The request is malformed or a value is invalid"},
+	{errCodeSchemaViolation, http.StatusBadRequest, "Invoice JSON does not match the e-invoice schema; details lists each violation"},
+	{errCodeUnauthenticated, http.StatusUnauthorized, "The request has no valid Authorization header"},
+	{errCodeInvalidToken, http.StatusUnauthorized, "The token is invalid, expired or revoked"},
+	{errCodeInvalidCredentials, http.StatusUnauthorized, "The email or password is wrong"},
+	{errCodePasswordResetRequired, http.StatusForbidden, "An admin reset the password; a new one must be set first"},
+	{errCodeForbidden, http.StatusForbidden, "The user may not do this"},
+	{errCodeAccountDisabled, http.StatusForbidden, "The account, or the account the user is a member of, is disabled"},
+	{errCodeAdminRequired, http.StatusForbidden, "The route needs admin or super admin rights"},
+	{errCodeOwnerRequired, http.StatusForbidden, "Only the account owner can do this"},
+	{errCodeNotFound, http.StatusNotFound, "The resource does not exist or belongs to another account"},
+	{errCodeConflict, http.StatusConflict, "The request conflicts with the resource's current state, such as a duplicate number"},
+	{errCodeInvoiceLocked, http.StatusConflict, "The invoice was exported or has an IRN; correct it with a credit note"},
+	{errCodePeriodLocked, http.StatusConflict, "The invoice is dated in a locked period whose returns were filed"},
+	{errCodePayloadTooLarge, http.StatusRequestEntityTooLarge, "The upload exceeds the size limit"},
+	{errCodeUnsupportedMediaType, http.StatusUnsupportedMediaType, "The upload's file type is not supported"},
+	{errCodeUnprocessable, http.StatusUnprocessableEntity, "The request is well-formed but cannot be carried out"},
+	{errCodeRateLimited, http.StatusTooManyRequests, "Too many requests; retry after the Retry-After header"},
+	{errCodeTooManyLoginAttempts, http.StatusTooManyRequests, "Logins are locked after repeated failures; retry after the Retry-After header"},
+	{errCodeInternal, http.StatusInternalServerError, "An unexpected server error"},
+	{errCodeUpstreamError, http.StatusBadGateway, "A provider the server depends on, such as the IRP, failed"},
+	{errCodeUnavailable, http.StatusServiceUnavailable, "The feature is not configured or temporarily unavailable"},
+	{errCodeTimeout, http.StatusGatewayTimeout, "A provider the server depends on did not answer in time"},
+}
+
+// statusErrorCodes is the code of error responses whose handler gave none
+var statusErrorCodes = map[int]string{
+	http.StatusBadRequest:            errCodeInvalidRequest,
+	http.StatusUnauthorized:          errCodeUnauthenticated,
+	http.StatusForbidden:             errCodeForbidden,
+	http.StatusNotFound:              errCodeNotFound,
+	http.StatusConflict:              errCodeConflict,
+	http.StatusRequestEntityTooLarge: errCodePayloadTooLarge,
+	http.StatusUnsupportedMediaType:  errCodeUnsupportedMediaType,
+	http.StatusUnprocessableEntity:   errCodeUnprocessable,
+	http.StatusTooManyRequests:       errCodeRateLimited,
+	http.StatusBadGateway:            errCodeUpstreamError,
+	http.StatusServiceUnavailable:    errCodeUnavailable,
+	http.StatusGatewayTimeout:        errCodeTimeout,
+}
+
+// errorEnvelopeWriter holds back JSON error bodies so errorEnvelope can
+// complete them. The decision is made on the first write, when the status
+// and content type are known.
+type errorEnvelopeWriter struct {
+	gin.ResponseWriter
+	buf     *bytes.Buffer
+	decided bool
+}
+
+// decide buffers JSON responses with an error status
+func (w *errorEnvelopeWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	if w.Status() >= http.StatusBadRequest && strings.HasPrefix(w.Header().Get("Content-Type"), "application/json") {
+		w.buf = &bytes.Buffer{}
+	}
+}
+
+func (w *errorEnvelopeWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.buf != nil {
+		return w.buf.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *errorEnvelopeWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// envelope completes an error body with code, message and details. The
+// handler's own fields are kept, including error, the message under the
+// name earlier clients read it by. Bodies that are not JSON objects are
+// left as they are.
+func envelope(status int, body []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	var fields map[string]interface{}
+	if err := dec.Decode(&fields); err != nil || fields == nil {
+		return body
+	}
+
+	message, _ := fields["error"].(string)
+	if message == "" {
+		message, _ = fields["message"].(string)
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	if _, ok := fields["error"]; !ok {
+		fields["error"] = message
+	}
+	fields["message"] = message
+
+	if code, _ := fields["code"].(string); code == "" {
+		switch {
+		case fields["locked"] == true:
+			code = errCodeInvoiceLocked
+		case fields["period_locked"] == true:
+			code = errCodePeriodLocked
+		case fields["password_reset_required"] == true:
+			code = errCodePasswordResetRequired
+		case fields["violations"] != nil:
+			code = errCodeSchemaViolation
+		case status >= http.StatusInternalServerError && statusErrorCodes[status] == "":
+			code = errCodeInternal
+		default:
+			code = statusErrorCodes[status]
+			if code == "" {
+				code = errCodeInvalidRequest
+			}
+		}
+		fields["code"] = code
+	}
+
+	// Schema violations are the details of the error, each naming its field
+	if _, ok := fields["details"]; !ok {
+		if violations, ok := fields["violations"].([]interface{}); ok {
+			fields["details"] = violations
+			if _, ok := fields["field"]; !ok && len(violations) == 1 {
+				if v, ok := violations[0].(map[string]interface{}); ok {
+					fields["field"] = v["path"]
+				}
+			}
+		} else {
+			fields["details"] = []interface{}{}
+		}
+	}
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// errorEnvelope gives every JSON error response the same envelope: a
+// machine-readable code from errorCatalog, the message, the offending field
+// when there is one and a details array. Handlers set code, field or
+// details themselves where a more specific value is known; otherwise the
+// code follows from the status.
+func errorEnvelope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		w := &errorEnvelopeWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			c.Writer = w.ResponseWriter
+			if w.buf == nil {
+				return
+			}
+			body := envelope(w.Status(), w.buf.Bytes())
+			w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+			w.ResponseWriter.Write(body)
+		}()
+		c.Next()
+	}
+}
+
+// handleGetErrorCodes publishes the error code catalog
+func handleGetErrorCodes(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"codes": errorCatalog})
+}
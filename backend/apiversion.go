@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiV1Prefix is the path prefix of version 1 of the API. Every route
+// registered under /api is served under it as well.
+const apiV1Prefix = "/api/v1"
+
+// unversionedDeprecatedAt is when the unversioned /api paths were deprecated
+// in favour of /api/v1
+var unversionedDeprecatedAt = time.Date(2026, time.October, 16, 0, 0, 0, 0, time.UTC)
+
+// unversionedPaths are /api paths registered with third parties, such as
+// webhook and OAuth callback URLs, which stay unversioned without deprecation
+var unversionedPaths = []string{
+	"/api/webhooks/",
+	"/api/auth/google",
+	"/api/integrations/",
+}
+
+// apiVersionKey is the request context key of the API version a request was made to
+type apiVersionKey struct{}
+
+// apiVersions serves /api/v1 paths by the routes registered under /api,
+// noting the version in the request context. The path is rewritten before
+// the router sees it, so routes, CORS policies and rate limits apply the same
+// to both paths.
+func apiVersions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rest, ok := strings.CutPrefix(r.URL.Path, apiV1Prefix)
+		if ok && (rest == "" || rest[0] == '/') {
+			r = r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, "v1"))
+			u := *r.URL
+			u.Path = "/api" + rest
+			if u.RawPath != "" {
+				u.RawPath = "/api" + strings.TrimPrefix(u.RawPath, apiV1Prefix)
+			}
+			r.URL = &u
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiVersion returns the API version a request was made to, "" for the unversioned paths
+func apiVersion(c *gin.Context) string {
+	version, _ := c.Request.Context().Value(apiVersionKey{}).(string)
+	return version
+}
+
+// groupBaseKey is the gin context key of the base path of the router group
+// a request's route was registered under
+const groupBaseKey = "groupBase"
+
+// groupBase notes the base path of a router group on the requests to its
+// routes, so handlers can link to the group's other routes with apiLink
+func groupBase(group *gin.RouterGroup) gin.HandlerFunc {
+	base := group.BasePath()
+	return func(c *gin.Context) {
+		c.Set(groupBaseKey, base)
+		c.Next()
+	}
+}
+
+// apiLink returns the path of route in the router group of the request,
+// under the API version the request was made to
+func apiLink(c *gin.Context, route string) string {
+	path := c.GetString(groupBaseKey) + route
+	if version := apiVersion(c); version != "" {
+		if rest, ok := strings.CutPrefix(path, "/api"); ok && (rest == "" || rest[0] == '/') {
+			path = "/api/" + version + rest
+		}
+	}
+	return path
+}
+
+// setDeprecationHeaders marks a response as coming from a deprecated route:
+// Deprecation (RFC 9745) gives the date it was deprecated, Sunset (RFC 8594)
+// the date it will be removed, if decided, and Link the route replacing it.
+func setDeprecationHeaders(c *gin.Context, deprecatedAt, sunset time.Time, successor string) {
+	h := c.Writer.Header()
+	h.Set("Deprecation", "@"+strconv.FormatInt(deprecatedAt.Unix(), 10))
+	if !sunset.IsZero() {
+		h.Set("Sunset", sunset.UTC().Format(http.TimeFormat))
+	}
+	if successor != "" {
+		h.Add("Link", "<"+successor+">; rel=\"successor-version\"")
+	}
+}
+
+// deprecatedRoute marks a route as deprecated in favour of successor, so
+// breaking changes can be made in a new route while clients of the old one
+// are warned before it is removed at sunset. A zero sunset leaves the
+// removal date open.
+func deprecatedRoute(deprecatedAt, sunset time.Time, successor string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		setDeprecationHeaders(c, deprecatedAt, sunset, successor)
+		c.Next()
+	}
+}
+
+// getUnversionedSunset returns when the unversioned /api paths will be
+// removed, from API_UNVERSIONED_SUNSET as YYYY-MM-DD; zero when undecided
+func getUnversionedSunset() time.Time {
+	value := strings.TrimSpace(os.Getenv("API_UNVERSIONED_SUNSET"))
+	if value == "" {
+		return time.Time{}
+	}
+	sunset, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		log.Printf("Invalid API_UNVERSIONED_SUNSET value, expected YYYY-MM-DD; leaving the sunset open")
+		return time.Time{}
+	}
+	return sunset
+}
+
+// unversionedDeprecation adds deprecation headers to requests made to the
+// unversioned /api paths, linking to the same route under /api/v1
+func unversionedDeprecation() gin.HandlerFunc {
+	sunset := getUnversionedSunset()
+	return func(c *gin.Context) {
+		path := c.Request.URL.Path
+		if apiVersion(c) != "" || !strings.HasPrefix(path, "/api/") {
+			c.Next()
+			return
+		}
+		for _, prefix := range unversionedPaths {
+			if strings.HasPrefix(path, prefix) {
+				c.Next()
+				return
+			}
+		}
+		successor := apiV1Prefix + strings.TrimPrefix(path, "/api")
+		if c.Request.URL.RawQuery != "" {
+			successor += "?" + c.Request.URL.RawQuery
+		}
+		setDeprecationHeaders(c, unversionedDeprecatedAt, sunset, successor)
+		c.Next()
+	}
+}
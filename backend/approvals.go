@@ -0,0 +1,324 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// createApprovalTables creates the approval history of invoices
+func createApprovalTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS invoice_approvals (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			actor_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			action VARCHAR(20) NOT NULL,
+			comment TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_approvals table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_invoice_approvals_invoice ON invoice_approvals (invoice_id, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice_approvals index: %v", err)
+	}
+}
+
+// requiresApproval reports whether the invoices the caller issues must wait
+// for an approver: those of accountants, when the account has approval mode on
+func requiresApproval(c *gin.Context, settings *models.UserSettings) bool {
+	return roleRequiresApproval(c.GetString("memberRole"), settings)
+}
+
+// roleRequiresApproval reports whether the invoices a member with role issues
+// must wait for an approver; role is empty for the account owner
+func roleRequiresApproval(role string, settings *models.UserSettings) bool {
+	return settings.ApprovalRequired && role == models.MemberRoleAccountant
+}
+
+// canApprove reports whether the caller may finalize or reject invoices
+// awaiting approval: the account owner and approvers can
+func canApprove(c *gin.Context) bool {
+	role := c.GetString("memberRole")
+	return role == "" || role == models.MemberRoleApprover
+}
+
+// issueStatus returns the status an invoice a member with role creates with
+// status is stored with: invoices that must be approved wait as
+// pending_approval instead of being finalized
+func issueStatus(role string, settings *models.UserSettings, status string) string {
+	if status == models.InvoiceStatusFinalized && roleRequiresApproval(role, settings) {
+		return models.InvoiceStatusPendingApproval
+	}
+	return status
+}
+
+// requireIssueRights keeps accountants in approval mode from routes that
+// issue invoices without a status, such as imports
+func requireIssueRights() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if requiresApproval(c, getUserSettings(c.Request.Context(), c.GetInt("userID"))) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Invoices you issue need approval; create them one by one or ask an approver to import them"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// recordApproval adds a step to an invoice's approval history
+func recordApproval(ctx context.Context, db execer, userID, invoiceID, actorID int, action, comment string) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO invoice_approvals (user_id, invoice_id, actor_id, action, comment)
+		VALUES ($1, $2, $3, $4, $5)
+	`, userID, invoiceID, actorID, action, comment)
+	return err
+}
+
+// submitForApproval moves a draft to pending_approval in place of finalizing
+// it. The invoice keeps its draft number until an approver finalizes it.
+func submitForApproval(c *gin.Context, tx pgx.Tx, userID, id int, invoiceJSON []byte) {
+	ctx := c.Request.Context()
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+	if err := validateForFinalizing(ctx, userID, &invoice); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
+		return
+	}
+
+	_, err := tx.Exec(ctx,
+		"UPDATE invoices SET status = $1, updated_at = NOW() WHERE id = $2 AND user_id = $3",
+		models.InvoiceStatusPendingApproval, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit invoice"})
+		return
+	}
+	if err := recordApproval(ctx, tx, userID, id, c.GetInt("actorID"), models.ApprovalSubmitted, ""); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit invoice"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to submit invoice"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Invoice submitted for approval",
+		"invoice_id": id,
+		"status":     models.InvoiceStatusPendingApproval,
+	})
+}
+
+// handleGetApprovals lists the invoices awaiting approval, oldest submission first
+func handleGetApprovals(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT i.id, i.invoice_no, i.seller_gstin, i.invoice_json, COALESCE(s.actor_email, ''),
+			COALESCE(s.created_at, i.created_at)
+		FROM invoices i
+		LEFT JOIN LATERAL (
+			SELECT u.email AS actor_email, a.created_at
+			FROM invoice_approvals a LEFT JOIN users u ON u.id = a.actor_id
+			WHERE a.invoice_id = i.id AND a.action = $2
+			ORDER BY a.created_at DESC
+			LIMIT 1
+		) s ON TRUE
+		WHERE i.user_id = $1 AND i.status = $3
+		ORDER BY 6, i.id
+	`, userID, models.ApprovalSubmitted, models.InvoiceStatusPendingApproval)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approvals"})
+		return
+	}
+	defer rows.Close()
+
+	approvals := make([]gin.H, 0)
+	for rows.Next() {
+		var id int
+		var invoiceNo, sellerGSTIN, submittedBy string
+		var invoiceJSON []byte
+		var submittedAt time.Time
+		if err := rows.Scan(&id, &invoiceNo, &sellerGSTIN, &invoiceJSON, &submittedBy, &submittedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read approval"})
+			return
+		}
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+			return
+		}
+		approvals = append(approvals, gin.H{
+			"invoice_id":   id,
+			"invoice_no":   invoiceNo,
+			"seller_gstin": sellerGSTIN,
+			"buyer":        invoice.BuyerDtls.LglNm,
+			"date":         invoice.DocDtls.Dt,
+			"total":        invoice.ValDtls.TotInvVal,
+			"submitted_by": submittedBy,
+			"submitted_at": submittedAt,
+		})
+	}
+	if rowsFailed(c, rows, "Failed to fetch approvals") {
+		return
+	}
+
+	c.JSON(http.StatusOK, approvals)
+}
+
+// handleApproveInvoice finalizes an invoice awaiting approval. Like
+// finalizing a draft, it takes an optional invoice_no, and a comment.
+func handleApproveInvoice(c *gin.Context) {
+	if !canApprove(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only approvers can approve invoices"})
+		return
+	}
+	finalizeInvoice(c, true)
+}
+
+// handleRejectInvoice returns an invoice awaiting approval to draft, with a
+// comment telling the accountant what to correct
+func handleRejectInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	if !canApprove(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only approvers can reject invoices"})
+		return
+	}
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		Comment string `json:"comment"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Comment) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A comment is required to reject an invoice"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var status string
+	err = tx.QueryRow(ctx,
+		"SELECT status FROM invoices WHERE id = $1 AND user_id = $2 FOR UPDATE", id, userID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if status != models.InvoiceStatusPendingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only invoices awaiting approval can be rejected; invoice is %s", status)})
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE invoices SET status = $1, updated_at = NOW() WHERE id = $2", models.InvoiceStatusDraft, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject invoice"})
+		return
+	}
+	err = recordApproval(ctx, tx, userID, id, c.GetInt("actorID"), models.ApprovalRejected, strings.TrimSpace(req.Comment))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject invoice"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reject invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Invoice returned to draft",
+		"invoice_id": id,
+		"status":     models.InvoiceStatusDraft,
+	})
+}
+
+// handleGetInvoiceApprovals returns the approval history of an invoice
+func handleGetInvoiceApprovals(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var exists bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT a.id, a.invoice_id, a.actor_id, COALESCE(u.email, ''), a.action, a.comment, a.created_at
+		FROM invoice_approvals a LEFT JOIN users u ON u.id = a.actor_id
+		WHERE a.invoice_id = $1 AND a.user_id = $2
+		ORDER BY a.created_at, a.id
+	`, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch approval history"})
+		return
+	}
+	defer rows.Close()
+
+	history := make([]models.InvoiceApproval, 0)
+	for rows.Next() {
+		var a models.InvoiceApproval
+		if err := rows.Scan(&a.ID, &a.InvoiceID, &a.ActorID, &a.ActorEmail, &a.Action, &a.Comment, &a.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read approval history"})
+			return
+		}
+		history = append(history, a)
+	}
+	if rowsFailed(c, rows, "Failed to fetch approval history") {
+		return
+	}
+
+	c.JSON(http.StatusOK, history)
+}
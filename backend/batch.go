@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"einvoice-app/models"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// failedInvoice identifies the invoice that made a batch roll back, by its
+// position in the batch (from 0) and its number when it has one
+func failedInvoice(index int, invoice *models.EInvoice) gin.H {
+	return gin.H{"index": index, "invoice_no": invoice.DocDtls.No}
+}
+
+// isUniqueViolation reports whether err is a unique constraint violation
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "23505"
+}
+
+// withFailedInvoice marks an import error as caused by the given invoice of
+// a batch that was rolled back. Errors not caused by the uploaded content
+// are logged and reported as a failed import.
+func withFailedInvoice(err error, index int, invoice *models.EInvoice) error {
+	var ie *importError
+	if !errors.As(err, &ie) {
+		log.Printf("Error importing invoice %s: %v", invoice.DocDtls.No, err)
+		ie = &importError{status: http.StatusInternalServerError, message: "Failed to import invoices"}
+	}
+	return &importError{status: ie.status, message: ie.message, failed: failedInvoice(index, invoice)}
+}
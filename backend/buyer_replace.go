@@ -0,0 +1,272 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// eventCustomerDraftsUpdated records that a customer's details were copied
+// to their draft invoices; the payload lists the invoices and fields changed
+const eventCustomerDraftsUpdated = "customer.drafts_updated"
+
+// buyerReplaceFields are the groups of buyer details that can be copied to drafts
+var buyerReplaceFields = []string{"gstin", "legal_name", "trade_name", "address", "contact"}
+
+// replaceBuyerRequest selects the drafts of a customer and the details to copy to them
+type replaceBuyerRequest struct {
+	// MatchGSTIN is the buyer GSTIN on the drafts, such as the one before a
+	// correction; it defaults to the customer's GSTIN
+	MatchGSTIN string `json:"match_gstin"`
+	// MatchName matches drafts by buyer legal name, regardless of case, when
+	// no GSTIN is given and the customer has none
+	MatchName string   `json:"match_name"`
+	Fields    []string `json:"fields"`
+	DryRun    bool     `json:"dry_run"`
+}
+
+// buyerReplacement is one draft changed, or that would be, by a replacement
+type buyerReplacement struct {
+	ID        int              `json:"id"`
+	InvoiceNo string           `json:"invoice_no"`
+	Before    models.BuyerDtls `json:"before"`
+	After     models.BuyerDtls `json:"after"`
+	// Error is why the draft was left unchanged, such as failing validation
+	Error string `json:"error,omitempty"`
+}
+
+// replaceBuyerDetails copies the selected groups of details from the
+// customer's buyer details to those of a draft. The place of supply follows
+// the buyer's state when it was the buyer's state before.
+func replaceBuyerDetails(buyer *models.BuyerDtls, customer *models.BuyerDtls, fields map[string]bool) {
+	if fields["gstin"] {
+		buyer.Gstin = customer.Gstin
+	}
+	if fields["legal_name"] {
+		buyer.LglNm = customer.LglNm
+	}
+	if fields["trade_name"] {
+		buyer.TrdNm = customer.TrdNm
+	}
+	if fields["address"] {
+		if buyer.Pos == buyer.Stcd {
+			buyer.Pos = customer.Stcd
+		}
+		buyer.Addr1, buyer.Addr2 = customer.Addr1, ""
+		buyer.Loc, buyer.Pin, buyer.Stcd = customer.Loc, customer.Pin, customer.Stcd
+	}
+	if fields["contact"] {
+		if customer.Ph != "" {
+			buyer.Ph = customer.Ph
+		}
+		if customer.Em != "" {
+			buyer.Em = customer.Em
+		}
+	}
+}
+
+// handleReplaceBuyerOnDrafts copies a customer's current details to the
+// buyer details of their draft invoices, for example after the customer's
+// GSTIN was corrected. Drafts are found by the buyer GSTIN they carry, or by
+// buyer name for customers without one. Each draft is recalculated and
+// validated; drafts that would fail are listed with the error and left
+// unchanged. With dry_run, nothing is saved and the response previews the
+// changes. Issued invoices are never changed; correct them with credit notes.
+func handleReplaceBuyerOnDrafts(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	customerID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+	var req replaceBuyerRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	if len(req.Fields) == 0 {
+		req.Fields = buyerReplaceFields
+	}
+	fields := make(map[string]bool, len(req.Fields))
+	for _, f := range req.Fields {
+		known := false
+		for _, k := range buyerReplaceFields {
+			known = known || f == k
+		}
+		if !known {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"error": "fields must be among " + strings.Join(buyerReplaceFields, ", "), "field": "fields",
+			})
+			return
+		}
+		fields[f] = true
+	}
+
+	customer, err := buyerFromCustomer(ctx, userID, customerID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customer"})
+		return
+	}
+
+	matchGSTIN := strings.ToUpper(strings.TrimSpace(firstNonEmpty(req.MatchGSTIN, customer.Gstin)))
+	matchName := strings.TrimSpace(firstNonEmpty(req.MatchName, customer.LglNm))
+	if matchGSTIN != "" && !models.IsValidGSTIN(matchGSTIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GSTIN format", "field": "match_gstin"})
+		return
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	drafts, err := replaceBuyerOnDrafts(ctx, tx, userID, customer, matchGSTIN, matchName, fields, req.DryRun)
+	if err != nil {
+		log.Printf("Error replacing buyer on drafts of customer %d: %v", customerID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoices"})
+		return
+	}
+	if len(drafts.updated) > 0 {
+		recordEvent(ctx, tx, userID, eventCustomerDraftsUpdated, entityCustomer, customerID, gin.H{
+			"invoice_ids": drafts.updated,
+			"fields":      req.Fields,
+			"match_gstin": matchGSTIN,
+			"match_name":  matchName,
+		})
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"customer_id": customerID,
+		"dry_run":     req.DryRun,
+		"matched":     len(drafts.invoices),
+		"changed":     drafts.changed,
+		"updated":     len(drafts.updated),
+		"invoices":    drafts.invoices,
+	})
+}
+
+// draftReplacements is the outcome of replacing the buyer on a set of drafts
+type draftReplacements struct {
+	invoices []buyerReplacement
+	// changed counts the drafts whose buyer details differ after the replacement
+	changed int
+	// updated are the IDs of the drafts saved, none on a dry run
+	updated []int
+}
+
+// replaceBuyerOnDrafts copies the selected details of customer to the user's
+// drafts whose buyer carries matchGSTIN, or the legal name matchName when no
+// GSTIN is given. Drafts dated in a locked period are left alone. The drafts
+// are locked, recalculated and validated; those that would fail are reported
+// and left unchanged. Unless dryRun is set, changed drafts are saved with an
+// invoice.updated event each.
+func replaceBuyerOnDrafts(ctx context.Context, tx pgx.Tx, userID int, customer *models.BuyerDtls, matchGSTIN, matchName string, fields map[string]bool, dryRun bool) (*draftReplacements, error) {
+	// Lock the drafts so none is finalized while its buyer is replaced
+	rows, err := tx.Query(ctx, `
+		SELECT id, invoice_no, invoice_json FROM invoices
+		WHERE user_id = $1 AND status = $2
+			AND CASE WHEN $3 <> '' THEN buyer_gstin = $3
+				ELSE LOWER(invoice_json->'BuyerDtls'->>'LglNm') = LOWER($4) END
+			AND to_char(invoice_date, 'YYYY-MM') NOT IN (SELECT period FROM period_locks WHERE user_id = $1)
+		ORDER BY id
+		FOR UPDATE
+	`, userID, models.InvoiceStatusDraft, matchGSTIN, matchName)
+	if err != nil {
+		return nil, err
+	}
+	type draft struct {
+		id        int
+		invoiceNo string
+		json      []byte
+	}
+	drafts := make([]draft, 0)
+	for rows.Next() {
+		var d draft
+		if err := rows.Scan(&d.id, &d.invoiceNo, &d.json); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		drafts = append(drafts, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	result := &draftReplacements{
+		invoices: make([]buyerReplacement, 0, len(drafts)),
+		updated:  make([]int, 0, len(drafts)),
+	}
+	for _, d := range drafts {
+		var invoice models.EInvoice
+		if err := json.Unmarshal(d.json, &invoice); err != nil {
+			result.invoices = append(result.invoices, buyerReplacement{ID: d.id, InvoiceNo: d.invoiceNo, Error: "unreadable invoice data"})
+			continue
+		}
+		r := buyerReplacement{ID: d.id, InvoiceNo: d.invoiceNo, Before: invoice.BuyerDtls}
+		replaceBuyerDetails(&invoice.BuyerDtls, customer, fields)
+		r.After = invoice.BuyerDtls
+
+		// A new state can turn intra-state supply into inter-state and back
+		invoice.CalculateTotalsWithPrecision(getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin))
+		profile := getCompanyValidationProfile(ctx, userID, invoice.SellerDtls.Gstin)
+		if _, err := invoice.ValidateProfile(profile); err != nil {
+			r.Error = err.Error()
+			result.invoices = append(result.invoices, r)
+			continue
+		}
+		result.invoices = append(result.invoices, r)
+		if r.Before == r.After {
+			continue
+		}
+		result.changed++
+		if dryRun {
+			continue
+		}
+
+		invoiceJSON, err := json.Marshal(invoice)
+		if err != nil {
+			return nil, err
+		}
+		agg := aggregatesOf(&invoice, time.Now())
+		_, err = tx.Exec(ctx, `
+			UPDATE invoices
+			SET invoice_json = $1, updated_at = NOW(),
+				total_value = $2, taxable_value = $3, tax_amount = $4, buyer_gstin = $5, gstr1_section = $6,
+				igst_amount = $8, cgst_amount = $9, sgst_amount = $10,
+				customer_id = `+invoiceCustomerSQL("invoices.user_id", "$5", "$1")+`
+			WHERE id = $7
+		`, invoiceJSON, agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.gstr1Section, d.id,
+			agg.igstAmount, agg.cgstAmount, agg.sgstAmount)
+		if err != nil {
+			return nil, fmt.Errorf("invoice %d: %w", d.id, err)
+		}
+		recordInvoiceEvent(ctx, tx, eventInvoiceUpdated, d.id)
+		result.updated = append(result.updated, d.id)
+	}
+	return result, nil
+}
@@ -0,0 +1,461 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// createChallanTables creates the delivery challan table. Challans are
+// numbered in their own series by seq, apart from invoices.
+func createChallanTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS delivery_challans (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			seq INTEGER NOT NULL,
+			challan_no VARCHAR(20) NOT NULL,
+			purpose VARCHAR(20) NOT NULL,
+			invoice_json JSONB NOT NULL,
+			invoice_id INTEGER REFERENCES invoice_keys(id) ON DELETE SET NULL,
+			converted_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, seq)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create delivery_challans table: %v", err)
+	}
+}
+
+// challanColumns are the columns scanned by scanChallan
+const challanColumns = "id, user_id, challan_no, purpose, invoice_json, invoice_id, converted_at, created_at, updated_at"
+
+// scanChallan reads a delivery challan selected with challanColumns
+func scanChallan(row pgx.Row) (*models.DeliveryChallan, error) {
+	var ch models.DeliveryChallan
+	var invoiceJSON []byte
+	err := row.Scan(&ch.ID, &ch.UserID, &ch.ChallanNo, &ch.Purpose, &invoiceJSON,
+		&ch.InvoiceID, &ch.ConvertedAt, &ch.CreatedAt, &ch.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(invoiceJSON, &ch.Challan); err != nil {
+		return nil, err
+	}
+	return &ch, nil
+}
+
+// challanItemRef adds a line from the item master
+type challanItemRef struct {
+	ItemID int     `json:"item_id"`
+	Qty    float64 `json:"qty"`
+}
+
+// challanRequest is the body of a challan create or update. The consignee
+// may be given as a customer and lines as items from the masters; these are
+// filled in on top of the challan's invoice JSON.
+type challanRequest struct {
+	Purpose    string           `json:"purpose"`
+	CustomerID int              `json:"customer_id"`
+	Items      []challanItemRef `json:"items"`
+	Challan    models.EInvoice  `json:"challan"`
+}
+
+// buyerFromCustomer fills in the buyer details of a document from a saved customer
+func buyerFromCustomer(ctx context.Context, userID, customerID int) (*models.BuyerDtls, error) {
+	var cu models.CustomerDetails
+	err := dbPool.QueryRow(ctx, `
+		SELECT name, COALESCE(gstin, ''), COALESCE(address, ''), COALESCE(city, ''),
+			COALESCE(state, ''), COALESCE(pincode, 0), COALESCE(phone, ''), COALESCE(email, '')
+		FROM customers WHERE id = $1 AND user_id = $2
+	`, customerID, userID).Scan(&cu.Name, &cu.GSTIN, &cu.Address, &cu.City, &cu.State, &cu.Pincode, &cu.Phone, &cu.Email)
+	if err != nil {
+		return nil, err
+	}
+	return buyerFromParty(&cu), nil
+}
+
+// buyerFromParty makes the buyer details of a document from a party's details
+func buyerFromParty(p *models.CustomerDetails) *models.BuyerDtls {
+	// A GSTIN starts with the state code of its registration
+	stcd, found := resolveStateCode(p.State)
+	if !found && len(p.GSTIN) >= 2 {
+		stcd = p.GSTIN[:2]
+	}
+	return &models.BuyerDtls{
+		Gstin: p.GSTIN,
+		LglNm: p.Name,
+		TrdNm: p.Name,
+		Pos:   stcd,
+		Addr1: p.Address,
+		Loc:   p.City,
+		Pin:   p.Pincode,
+		Stcd:  stcd,
+		Ph:    p.Phone,
+		Em:    p.Email,
+	}
+}
+
+// itemFromMaster makes a line of the given quantity from a saved item
+func itemFromMaster(ctx context.Context, userID, itemID int, qty float64) (*models.Item, error) {
+	var name, description, hsn, unit string
+	var unitPrice, gstRate float64
+	var isService bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT name, COALESCE(description, ''), hsn_code, unit_price::float8, gst_rate::float8, unit, is_service
+		FROM items WHERE id = $1 AND user_id = $2
+	`, itemID, userID).Scan(&name, &description, &hsn, &unitPrice, &gstRate, &unit, &isService)
+	if err != nil {
+		return nil, err
+	}
+
+	item := &models.Item{
+		PrdDesc:   name,
+		IsServc:   "N",
+		HsnCd:     hsn,
+		Qty:       qty,
+		Unit:      unit,
+		UnitPrice: unitPrice,
+		GstRt:     gstRate,
+	}
+	if description != "" {
+		item.PrdDesc = name + " - " + description
+	}
+	if isService {
+		item.IsServc = "Y"
+	}
+	return item, nil
+}
+
+// bindChallan parses a challan from the request body, filling in the
+// consignee and lines from the masters, and prepares it like a new invoice
+func bindChallan(c *gin.Context, userID int) (*challanRequest, bool) {
+	ctx := c.Request.Context()
+
+	var req challanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid challan data: " + err.Error()})
+		return nil, false
+	}
+	req.Purpose = strings.ToUpper(strings.TrimSpace(req.Purpose))
+	if !models.IsValidChallanPurpose(req.Purpose) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purpose must be one of " + strings.Join(models.ChallanPurposes, ", ")})
+		return nil, false
+	}
+
+	if req.CustomerID != 0 {
+		buyer, err := buyerFromCustomer(ctx, userID, req.CustomerID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Customer %d not found", req.CustomerID)})
+			return nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load customer"})
+			return nil, false
+		}
+		req.Challan.BuyerDtls = *buyer
+	}
+	for _, ref := range req.Items {
+		if ref.Qty <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Quantity of item %d must be greater than zero", ref.ItemID)})
+			return nil, false
+		}
+		item, err := itemFromMaster(ctx, userID, ref.ItemID, ref.Qty)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Item %d not found", ref.ItemID)})
+			return nil, false
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load item"})
+			return nil, false
+		}
+		req.Challan.ItemList = append(req.Challan.ItemList, *item)
+	}
+	if len(req.Challan.ItemList) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Challan must have at least one item"})
+		return nil, false
+	}
+	for i := range req.Challan.ItemList {
+		if req.Challan.ItemList[i].SlNo == "" {
+			req.Challan.ItemList[i].SlNo = strconv.Itoa(i + 1)
+		}
+	}
+
+	if !prepareDocumentInvoice(c, userID, &req.Challan, models.ChallanDocType) {
+		return nil, false
+	}
+	return &req, true
+}
+
+// handleGetChallans lists the user's delivery challans, newest first,
+// optionally only those of one purpose or only those not yet billed with
+// ?pending=true
+func handleGetChallans(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	purpose := c.Query("purpose")
+	if purpose != "" && !models.IsValidChallanPurpose(purpose) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "purpose must be one of " + strings.Join(models.ChallanPurposes, ", ")})
+		return
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT "+challanColumns+` FROM delivery_challans
+		WHERE user_id = $1 AND ($2 = '' OR purpose = $2) AND (NOT $3 OR invoice_id IS NULL)
+		ORDER BY created_at DESC, id DESC`,
+		userID, purpose, c.Query("pending") == "true")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch challans"})
+		return
+	}
+	defer rows.Close()
+
+	challans := make([]*models.DeliveryChallan, 0)
+	for rows.Next() {
+		ch, err := scanChallan(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read challan data"})
+			return
+		}
+		challans = append(challans, ch)
+	}
+	if rowsFailed(c, rows, "Failed to fetch challans") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"challans": challans})
+}
+
+// handleGetChallan returns a delivery challan
+func handleGetChallan(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid challan ID"})
+		return
+	}
+
+	ch, err := scanChallan(dbPool.QueryRow(c.Request.Context(),
+		"SELECT "+challanColumns+" FROM delivery_challans WHERE id = $1 AND user_id = $2",
+		id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Challan not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch challan"})
+		return
+	}
+
+	c.JSON(http.StatusOK, ch)
+}
+
+// handleCreateChallan records a delivery challan, numbered next in the
+// challan series; a number in the body is ignored
+func handleCreateChallan(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	req, ok := bindChallan(c, userID)
+	if !ok {
+		return
+	}
+	req.Challan.DocDtls.No = ""
+	invoiceJSON, err := json.Marshal(req.Challan)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize challan"})
+		return
+	}
+
+	// As with proforma documents, a concurrent create taking the same
+	// number fails the unique constraint and is retried
+	var id int
+	var challanNo string
+	for attempt := 0; attempt < 3; attempt++ {
+		err = dbPool.QueryRow(ctx, `
+			INSERT INTO delivery_challans (user_id, seq, challan_no, purpose, invoice_json)
+			SELECT $1, n, $2 || LPAD(n::text, 5, '0'), $3,
+				jsonb_set($4::jsonb, '{DocDtls,No}', to_jsonb($2 || LPAD(n::text, 5, '0')))
+			FROM (SELECT COALESCE(MAX(seq), 0) + 1 AS n FROM delivery_challans WHERE user_id = $1) next
+			RETURNING id, challan_no
+		`, userID, models.ChallanPrefix, req.Purpose, invoiceJSON).Scan(&id, &challanNo)
+		if !isUniqueViolation(err) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Error storing delivery challan: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store challan"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Challan created successfully",
+		"id":         id,
+		"challan_no": challanNo,
+		"purpose":    req.Purpose,
+	})
+}
+
+// handleUpdateChallan replaces the purpose and lines of a challan that has
+// not been billed yet; its number is kept
+func handleUpdateChallan(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid challan ID"})
+		return
+	}
+
+	req, ok := bindChallan(c, userID)
+	if !ok {
+		return
+	}
+	invoiceJSON, err := json.Marshal(req.Challan)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize challan"})
+		return
+	}
+
+	var challanNo string
+	var invoiceID *int
+	err = dbPool.QueryRow(c.Request.Context(), `
+		UPDATE delivery_challans
+		SET purpose = CASE WHEN invoice_id IS NULL THEN $1 ELSE purpose END,
+			invoice_json = CASE WHEN invoice_id IS NULL
+				THEN jsonb_set($2::jsonb, '{DocDtls,No}', to_jsonb(challan_no)) ELSE invoice_json END,
+			updated_at = CASE WHEN invoice_id IS NULL THEN NOW() ELSE updated_at END
+		WHERE id = $3 AND user_id = $4
+		RETURNING challan_no, invoice_id
+	`, req.Purpose, invoiceJSON, id, userID).Scan(&challanNo, &invoiceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Challan not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update challan"})
+		return
+	}
+	if invoiceID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Challan has been converted to a tax invoice and can no longer be changed", "invoice_id": *invoiceID})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Challan updated successfully",
+		"id":         id,
+		"challan_no": challanNo,
+	})
+}
+
+// handleDeleteChallan deletes a delivery challan; an invoice it was
+// converted to is kept
+func handleDeleteChallan(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid challan ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM delivery_challans WHERE id = $1 AND user_id = $2",
+		id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete challan"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Challan not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Challan deleted successfully",
+		"id":      id,
+	})
+}
+
+// handleConvertChallan bills the goods of a delivery challan, such as those
+// sold at an exhibition or kept after supply on approval. The lines are
+// copied into a tax invoice dated today, finalized and numbered next in the
+// invoice series unless ?status=draft is given, and the challan is linked to
+// it. A challan converts only once, unless the invoice is deleted again.
+func handleConvertChallan(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid challan ID"})
+		return
+	}
+
+	status := c.DefaultQuery("status", models.InvoiceStatusFinalized)
+	if !isValidCreateStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be draft or finalized"})
+		return
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	ch, err := scanChallan(tx.QueryRow(ctx,
+		"SELECT "+challanColumns+" FROM delivery_challans WHERE id = $1 AND user_id = $2 FOR UPDATE",
+		id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Challan not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch challan"})
+		return
+	}
+	if ch.InvoiceID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s has already been converted to a tax invoice", ch.ChallanNo), "invoice_id": *ch.InvoiceID})
+		return
+	}
+
+	invoice := ch.Challan
+	invoice.DocDtls.Typ = "INV"
+	result, ok := issueConvertedInvoice(c, tx, invoice, status)
+	if !ok {
+		return
+	}
+
+	_, err = tx.Exec(ctx,
+		"UPDATE delivery_challans SET invoice_id = $1, converted_at = NOW(), updated_at = NOW() WHERE id = $2",
+		result["id"], id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link challan to invoice"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice"})
+		return
+	}
+
+	result["message"] = fmt.Sprintf("%s converted to tax invoice %s", ch.ChallanNo, result["invoice_no"])
+	result["challan_id"] = id
+	c.JSON(http.StatusCreated, result)
+}
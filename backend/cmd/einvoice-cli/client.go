@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultServer is the API used when neither login nor EINVOICE_SERVER names one
+const defaultServer = "http://localhost:8080"
+
+// config is what login saves between runs
+type config struct {
+	Server string `json:"server"`
+	Token  string `json:"token"`
+	Email  string `json:"email,omitempty"`
+}
+
+// configPath returns where the saved config is kept, EINVOICE_CONFIG when set
+func configPath() (string, error) {
+	if path := os.Getenv("EINVOICE_CONFIG"); path != "" {
+		return path, nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "einvoice-cli", "config.json"), nil
+}
+
+// loadConfig reads the saved config, overridden by EINVOICE_SERVER and
+// EINVOICE_TOKEN. A missing file is an empty config.
+func loadConfig() (*config, error) {
+	cfg := &config{}
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, cfg); err != nil {
+			return nil, fmt.Errorf("unreadable config %s: %w", path, err)
+		}
+	}
+
+	if server := os.Getenv("EINVOICE_SERVER"); server != "" {
+		cfg.Server = server
+	}
+	if token := os.Getenv("EINVOICE_TOKEN"); token != "" {
+		cfg.Token = token
+	}
+	if cfg.Server == "" {
+		cfg.Server = defaultServer
+	}
+	cfg.Server = strings.TrimRight(cfg.Server, "/")
+	return cfg, nil
+}
+
+// saveConfig writes the config readable only by the user, as it holds the token
+func saveConfig(cfg *config) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// apiClient calls the API of a server as the signed-in user
+type apiClient struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// newClient returns a client for the saved or configured server. Commands
+// other than login need a token.
+func newClient(needToken bool) (*apiClient, error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	if needToken && cfg.Token == "" {
+		return nil, errors.New("not signed in; run einvoice-cli login or set EINVOICE_TOKEN")
+	}
+	return &apiClient{
+		server: cfg.Server,
+		token:  cfg.Token,
+		http:   &http.Client{Timeout: 5 * time.Minute},
+	}, nil
+}
+
+// apiError is an error response of the API
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Details []json.RawMessage
+}
+
+func (e *apiError) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("%s (HTTP %d, %s)", e.Message, e.Status, e.Code)
+	}
+	return fmt.Sprintf("%s (HTTP %d)", e.Message, e.Status)
+}
+
+// readAPIError builds an apiError from an error response
+func readAPIError(resp *http.Response) error {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	var body struct {
+		Code    string            `json:"code"`
+		Message string            `json:"message"`
+		Error   string            `json:"error"`
+		Details []json.RawMessage `json:"details"`
+		Errors  []json.RawMessage `json:"errors"`
+	}
+	e := &apiError{Status: resp.StatusCode}
+	if json.Unmarshal(data, &body) == nil {
+		e.Code, e.Message, e.Details = body.Code, body.Message, body.Details
+		if e.Message == "" {
+			e.Message = body.Error
+		}
+		if len(e.Details) == 0 {
+			e.Details = body.Errors
+		}
+	}
+	if e.Message == "" {
+		e.Message = strings.TrimSpace(string(data))
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	return e
+}
+
+// do sends a request to an /api/v1 path and returns the response when it succeeded
+func (c *apiClient) do(method, path string, query url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	u := c.server + "/api/v1" + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	req.Header.Set("User-Agent", "einvoice-cli")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		return nil, readAPIError(resp)
+	}
+	return resp, nil
+}
+
+// doJSON sends a request and decodes the JSON response into out, if given
+func (c *apiClient) doJSON(method, path string, query url.Values, contentType string, body io.Reader, out interface{}) error {
+	resp, err := c.do(method, path, query, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// postJSON sends value as a JSON body
+func (c *apiClient) postJSON(path string, value, out interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return c.doJSON(http.MethodPost, path, nil, "application/json", bytes.NewReader(data), out)
+}
+
+// download saves the response of a GET request to a file, replacing it
+// only once the whole body arrived
+func (c *apiClient) download(path string, query url.Values, dest string) error {
+	resp, err := c.do(http.MethodGet, path, query, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".einvoice-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
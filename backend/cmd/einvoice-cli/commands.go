@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// xlsxContentType is the content type the API requires of Excel uploads
+const xlsxContentType = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// listPageSize is the number of invoices fetched per page when listing a period
+const listPageSize = 200
+
+// periodFlags are the -from, -to and -fy flags selecting invoices by date
+type periodFlags struct {
+	from, to, fy string
+}
+
+func (p *periodFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&p.from, "from", "", "first invoice date, YYYY-MM-DD")
+	fs.StringVar(&p.to, "to", "", "last invoice date, YYYY-MM-DD")
+	fs.StringVar(&p.fy, "fy", "", "financial year such as 2024-25, instead of -from and -to")
+}
+
+// query returns the period as the API's export filters
+func (p *periodFlags) query() url.Values {
+	q := url.Values{}
+	for name, value := range map[string]string{"from": p.from, "to": p.to, "fy": p.fy} {
+		if value != "" {
+			q.Set(name, value)
+		}
+	}
+	return q
+}
+
+// runLogin signs in with an email and password and saves the token. The
+// password is read from EINVOICE_PASSWORD or, without it, from standard input.
+func runLogin(args []string) error {
+	fs := flag.NewFlagSet("login", flag.ExitOnError)
+	server := fs.String("server", "", "API server URL, such as https://einvoice.example.com")
+	email := fs.String("email", "", "account email")
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if *server != "" {
+		cfg.Server = strings.TrimRight(*server, "/")
+	}
+	if *email == "" {
+		*email = cfg.Email
+	}
+	if *email == "" {
+		return errors.New("-email is required")
+	}
+
+	password := os.Getenv("EINVOICE_PASSWORD")
+	if password == "" {
+		fmt.Fprint(os.Stderr, "Password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return errors.New("no password given")
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	client := &apiClient{server: cfg.Server, http: http.DefaultClient}
+	var resp struct {
+		Token                 string `json:"token"`
+		PasswordResetRequired bool   `json:"password_reset_required"`
+	}
+	if err := client.postJSON("/login", map[string]string{"email": *email, "password": password}, &resp); err != nil {
+		return err
+	}
+
+	cfg.Token, cfg.Email = resp.Token, *email
+	if err := saveConfig(cfg); err != nil {
+		return fmt.Errorf("signed in but could not save the token: %w", err)
+	}
+	fmt.Printf("Signed in to %s as %s\n", cfg.Server, *email)
+	if resp.PasswordResetRequired {
+		fmt.Println("Your password was reset by an admin; set a new one in the web app before using the API")
+	}
+	return nil
+}
+
+// runLogout removes the saved token
+func runLogout(args []string) error {
+	fs := flag.NewFlagSet("logout", flag.ExitOnError)
+	fs.Parse(args)
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Token = ""
+	if err := saveConfig(cfg); err != nil {
+		return err
+	}
+	fmt.Println("Signed out")
+	return nil
+}
+
+// runPush imports every .json and .xlsx file of a folder, in name order.
+// Each file is imported on its own, so one rejected file does not stop the
+// others; the command fails if any was rejected.
+func runPush(args []string) error {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	mapping := fs.String("mapping", "", "ID of the saved column mapping for Excel files")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: einvoice-cli push [flags] <folder>")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	client, err := newClient(true)
+	if err != nil {
+		return err
+	}
+	entries, err := os.ReadDir(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+
+	pushed, failed := 0, 0
+	for _, entry := range entries {
+		ext := strings.ToLower(filepath.Ext(entry.Name()))
+		if entry.IsDir() || (ext != ".json" && ext != ".xlsx") {
+			continue
+		}
+		path := filepath.Join(fs.Arg(0), entry.Name())
+
+		var summary string
+		if ext == ".json" {
+			summary, err = pushJSON(client, path)
+		} else {
+			summary, err = pushExcel(client, path, *mapping)
+		}
+		if err != nil {
+			failed++
+			fmt.Printf("%s: %v\n", entry.Name(), err)
+			var apiErr *apiError
+			if errors.As(err, &apiErr) {
+				for _, detail := range apiErr.Details {
+					fmt.Printf("  %s\n", detail)
+				}
+			}
+			continue
+		}
+		pushed++
+		fmt.Printf("%s: %s\n", entry.Name(), summary)
+	}
+
+	if pushed+failed == 0 {
+		return fmt.Errorf("no .json or .xlsx files in %s", fs.Arg(0))
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, pushed+failed)
+	}
+	return nil
+}
+
+// importResponse is the part of an import response the CLI reports
+type importResponse struct {
+	Message  string            `json:"message"`
+	Invoice  json.RawMessage   `json:"invoice"`
+	Invoices []json.RawMessage `json:"invoices"`
+	Errors   []json.RawMessage `json:"errors"`
+}
+
+// summary describes the outcome of an import, listing any rows that were skipped
+func (r *importResponse) summary() string {
+	count := len(r.Invoices)
+	if count == 0 && len(r.Invoice) > 0 {
+		count = 1
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s (%d invoices)", r.Message, count)
+	for _, rowErr := range r.Errors {
+		fmt.Fprintf(&b, "\n  %s", rowErr)
+	}
+	return b.String()
+}
+
+// pushJSON imports a file holding one invoice or an array of them
+func pushJSON(client *apiClient, path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	var resp importResponse
+	if err := client.doJSON(http.MethodPost, "/import-json", nil, "application/json", bytes.NewReader(data), &resp); err != nil {
+		return "", err
+	}
+	return resp.summary(), nil
+}
+
+// pushExcel uploads a spreadsheet in the Excel template layout, or in the
+// layout of a saved column mapping
+func pushExcel(client *apiClient, path, mapping string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename=%q`, filepath.Base(path)))
+	header.Set("Content-Type", xlsxContentType)
+	part, err := w.CreatePart(header)
+	if err != nil {
+		return "", err
+	}
+	part.Write(data)
+	if mapping != "" {
+		w.WriteField("mapping_id", mapping)
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	var resp importResponse
+	if err := client.doJSON(http.MethodPost, "/upload-excel", nil, w.FormDataContentType(), &body, &resp); err != nil {
+		return "", err
+	}
+	return resp.summary(), nil
+}
+
+// runPull exports the finalized invoices of a period
+func runPull(args []string) error {
+	fs := flag.NewFlagSet("pull", flag.ExitOnError)
+	var period periodFlags
+	period.register(fs)
+	format := fs.String("format", "jsonl", "jsonl, xlsx or nic (the NIC bulk generation layout)")
+	seller := fs.String("seller", "", "only invoices of this seller GSTIN")
+	output := fs.String("o", "", "output file; defaults to standard output for jsonl and invoices.xlsx otherwise")
+	fs.Parse(args)
+
+	query := period.query()
+	if *seller != "" {
+		query.Set("seller_gstin", *seller)
+	}
+	path := "/export-jsonl"
+	switch *format {
+	case "jsonl":
+	case "xlsx", "nic":
+		path = "/export-invoices"
+		query.Set("format", *format)
+		if *output == "" {
+			*output = "invoices.xlsx"
+		}
+	default:
+		return errors.New("-format must be jsonl, xlsx or nic")
+	}
+
+	client, err := newClient(true)
+	if err != nil {
+		return err
+	}
+	if *output == "" || *output == "-" {
+		resp, err := client.do(http.MethodGet, path, query, "", nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+	}
+	if err := client.download(path, query, *output); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Saved %s\n", *output)
+	return nil
+}
+
+// invoiceRef names an invoice to download files of
+type invoiceRef struct {
+	ID        int    `json:"id"`
+	InvoiceNo string `json:"invoice_no"`
+}
+
+// fileName returns the base name of the invoice's files; invoice numbers may
+// contain slashes, which are replaced
+func (r invoiceRef) fileName() string {
+	if r.InvoiceNo == "" {
+		return "invoice-" + strconv.Itoa(r.ID)
+	}
+	return strings.NewReplacer("/", "-", "\\", "-", ":", "-").Replace(r.InvoiceNo)
+}
+
+// listInvoices returns the invoices of a period, following the list's pages
+func listInvoices(client *apiClient, query url.Values) ([]invoiceRef, error) {
+	query.Set("limit", strconv.Itoa(listPageSize))
+	var invoices []invoiceRef
+	for {
+		var page struct {
+			Invoices   []invoiceRef `json:"invoices"`
+			HasMore    bool         `json:"has_more"`
+			NextCursor string       `json:"next_cursor"`
+		}
+		if err := client.doJSON(http.MethodGet, "/invoices", query, "", nil, &page); err != nil {
+			return nil, err
+		}
+		invoices = append(invoices, page.Invoices...)
+		if !page.HasMore || page.NextCursor == "" {
+			return invoices, nil
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+}
+
+// runDownload saves the QR code and PDF of the invoices given by ID, or of
+// all invoices of a period, as <invoice no>.png and <invoice no>.pdf
+func runDownload(args []string) error {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	var period periodFlags
+	period.register(fs)
+	dir := fs.String("dir", ".", "folder to save the files in")
+	qr := fs.Bool("qr", true, "download QR codes")
+	pdf := fs.Bool("pdf", true, "download PDFs")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: einvoice-cli download [flags] [invoice ID ...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if !*qr && !*pdf {
+		return errors.New("nothing to download with -qr=false and -pdf=false")
+	}
+
+	client, err := newClient(true)
+	if err != nil {
+		return err
+	}
+
+	var invoices []invoiceRef
+	if fs.NArg() > 0 {
+		for _, arg := range fs.Args() {
+			id, err := strconv.Atoi(arg)
+			if err != nil {
+				return fmt.Errorf("invalid invoice ID %q", arg)
+			}
+			invoices = append(invoices, invoiceRef{ID: id})
+		}
+	} else {
+		if invoices, err = listInvoices(client, period.query()); err != nil {
+			return err
+		}
+	}
+	if len(invoices) == 0 {
+		fmt.Println("No invoices found")
+		return nil
+	}
+	if err := os.MkdirAll(*dir, 0o755); err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, inv := range invoices {
+		base := filepath.Join(*dir, inv.fileName())
+		id := strconv.Itoa(inv.ID)
+		if *qr {
+			if err := client.download("/qr/"+id, nil, base+".png"); err != nil {
+				failed++
+				fmt.Printf("QR code of invoice %s: %v\n", id, err)
+			}
+		}
+		if *pdf {
+			if err := client.download("/invoices/"+id+"/pdf", nil, base+".pdf"); err != nil {
+				failed++
+				fmt.Printf("PDF of invoice %s: %v\n", id, err)
+			}
+		}
+	}
+	fmt.Printf("Downloaded files of %d invoices to %s\n", len(invoices), *dir)
+	if failed > 0 {
+		return fmt.Errorf("%d downloads failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+// Command einvoice-cli scripts the e-invoice API from the command line: sign
+// in once, then push folders of invoice files, pull a period's invoices and
+// download their QR codes and PDFs.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: einvoice-cli <command> [flags]
+
+Commands:
+  login     Sign in and save the token for later commands
+  logout    Forget the saved token
+  push      Import the JSON and Excel (.xlsx) invoice files of a folder
+  pull      Export the invoices of a period as JSON Lines, Excel or the NIC bulk format
+  download  Save the QR codes and PDFs of invoices
+
+Run einvoice-cli <command> -h for the flags of a command.
+
+The server and token are read from EINVOICE_SERVER and EINVOICE_TOKEN when
+set, and otherwise from the file written by login.
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	commands := map[string]func([]string) error{
+		"login":    runLogin,
+		"logout":   runLogout,
+		"push":     runPush,
+		"pull":     runPull,
+		"download": runDownload,
+	}
+	name, args := os.Args[1], os.Args[2:]
+	if name == "help" || name == "-h" || name == "--help" {
+		fmt.Print(usage)
+		return
+	}
+	run, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n%s", name, usage)
+		os.Exit(2)
+	}
+	if err := run(args); err != nil {
+		fmt.Fprintln(os.Stderr, "Error:", err)
+		os.Exit(1)
+	}
+}
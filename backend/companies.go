@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// getCompanyPrecision returns the decimal precision and rounding rule configured
+// for the user's company with the given GSTIN, falling back to the default precision
+func getCompanyPrecision(ctx context.Context, userID int, gstin string) models.Precision {
+	var p models.Precision
+	err := dbPool.QueryRow(ctx,
+		"SELECT qty_decimals, rate_decimals, rounding, rounding_method, round_off FROM companies WHERE user_id = $1 AND gstin = $2",
+		userID, gstin).Scan(&p.Quantity, &p.UnitPrice, &p.Rounding, &p.Method, &p.RoundOff)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error loading company precision: %v", err)
+		}
+		// Sellers without a saved company round tax the way the user prefers
+		p = models.DefaultPrecision
+		p.Rounding = getUserSettings(ctx, userID).RoundingMode
+		return p
+	}
+	return p
+}
+
+// getCompanyValidationProfile returns the validation profile drafts of the user's
+// company with the given GSTIN are saved under, falling back to the default
+// company's profile and then to strict
+func getCompanyValidationProfile(ctx context.Context, userID int, gstin string) string {
+	var profile string
+	err := dbPool.QueryRow(ctx, `
+		SELECT validation_profile FROM companies
+		WHERE user_id = $1 AND (gstin = $2 OR is_default)
+		ORDER BY gstin = $2 DESC
+		LIMIT 1
+	`, userID, gstin).Scan(&profile)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error loading company validation profile: %v", err)
+		}
+		return models.ValidationStrict
+	}
+	return profile
+}
+
+// companyColumns is the column list used when reading companies
+const companyColumns = `id, user_id, name, gstin, address, city, state, pincode,
+	COALESCE(phone, ''), COALESCE(email, ''), is_default,
+	qty_decimals, rate_decimals, rounding, rounding_method, round_off, upi_vpa, upi_payee_name,
+	validation_profile, lut_no, lut_fy, created_at`
+
+// scanCompany reads a company row selected with companyColumns
+func scanCompany(row pgx.Row) (*models.CompanyDetails, error) {
+	var co models.CompanyDetails
+	err := row.Scan(
+		&co.ID, &co.UserID, &co.Name, &co.GSTIN, &co.Address, &co.City, &co.State, &co.Pincode,
+		&co.Phone, &co.Email, &co.IsDefault, &co.QtyDecimals, &co.RateDecimals, &co.Rounding,
+		&co.RoundingMethod, &co.RoundOff, &co.UPIVPA, &co.UPIPayeeName,
+		&co.ValidationProfile, &co.LUTNo, &co.LUTFiscalYear, &co.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &co, nil
+}
+
+// getCompanyByGSTIN returns the user's company with the given GSTIN
+func getCompanyByGSTIN(ctx context.Context, userID int, gstin string) (*models.CompanyDetails, error) {
+	return scanCompany(dbPool.QueryRow(ctx,
+		"SELECT "+companyColumns+" FROM companies WHERE user_id = $1 AND gstin = $2", userID, gstin))
+}
+
+// handleGetCompanies returns all companies for the authenticated user
+func handleGetCompanies(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT `+companyColumns+`
+		FROM companies
+		WHERE user_id = $1
+		ORDER BY is_default DESC, name
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch companies"})
+		return
+	}
+	defer rows.Close()
+
+	companies := make([]models.CompanyDetails, 0)
+	for rows.Next() {
+		co, err := scanCompany(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan company data"})
+			return
+		}
+		companies = append(companies, *co)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"companies": companies})
+}
+
+// validateCompany checks a company's name, GSTIN, precision, UPI VPA, validation
+// profile and LUT, filling in the default precision, rounding and profile where
+// they are unset
+func validateCompany(company *models.CompanyDetails) error {
+	if company.Name == "" {
+		return errors.New("Company name is required")
+	}
+	if !models.IsValidGSTIN(company.GSTIN) {
+		return errors.New("Invalid company GSTIN format")
+	}
+
+	// Unset precision and rounding fall back to the defaults
+	if company.QtyDecimals == 0 {
+		company.QtyDecimals = models.DefaultPrecision.Quantity
+	}
+	if company.RateDecimals == 0 {
+		company.RateDecimals = models.DefaultPrecision.UnitPrice
+	}
+	if company.Rounding == "" {
+		company.Rounding = models.DefaultPrecision.Rounding
+	}
+	if company.RoundingMethod == "" {
+		company.RoundingMethod = models.DefaultPrecision.Method
+	}
+	if err := company.Precision().Validate(); err != nil {
+		return err
+	}
+
+	company.UPIVPA = strings.TrimSpace(company.UPIVPA)
+	if company.UPIVPA != "" && !models.IsValidVPA(company.UPIVPA) {
+		return errors.New("Invalid UPI VPA format")
+	}
+	if len(company.UPIPayeeName) > 100 {
+		return errors.New("UPI payee name must be at most 100 characters")
+	}
+
+	if company.ValidationProfile == "" {
+		company.ValidationProfile = models.ValidationStrict
+	}
+	if !models.IsValidValidationProfile(company.ValidationProfile) {
+		return errors.New("Validation profile must be strict or lenient")
+	}
+
+	company.LUTNo = strings.TrimSpace(company.LUTNo)
+	company.LUTFiscalYear = strings.TrimSpace(company.LUTFiscalYear)
+	if company.LUTNo != "" || company.LUTFiscalYear != "" {
+		if !models.IsValidLUTNo(company.LUTNo) {
+			return errors.New("LUT number must be 1 to 20 characters")
+		}
+		fy, err := models.ParseFiscalYear(company.LUTFiscalYear)
+		if err != nil {
+			return errors.New("LUT financial year must be in YYYY-YY format, such as 2025-26")
+		}
+		company.LUTFiscalYear = fy.String()
+	}
+	return nil
+}
+
+// bindCompany parses and validates a company from the request body
+func bindCompany(c *gin.Context) (models.CompanyDetails, bool) {
+	var company models.CompanyDetails
+	if err := c.ShouldBindJSON(&company); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company data: " + err.Error()})
+		return company, false
+	}
+
+	if err := validateCompany(&company); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return company, false
+	}
+
+	return company, true
+}
+
+// handleCreateCompany creates a new company
+func handleCreateCompany(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	company, ok := bindCompany(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Only one company can be the default
+	if company.IsDefault {
+		if _, err := tx.Exec(ctx,
+			"UPDATE companies SET is_default = FALSE WHERE user_id = $1", userID); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update default company"})
+			return
+		}
+	}
+
+	var id int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO companies (
+			user_id, name, gstin, address, city, state, pincode, phone, email,
+			is_default, qty_decimals, rate_decimals, rounding, rounding_method, round_off,
+			upi_vpa, upi_payee_name, validation_profile, lut_no, lut_fy
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+		RETURNING id
+	`,
+		userID, company.Name, company.GSTIN, company.Address, company.City, company.State,
+		company.Pincode, company.Phone, company.Email, company.IsDefault,
+		company.QtyDecimals, company.RateDecimals, company.Rounding, company.RoundingMethod, company.RoundOff,
+		company.UPIVPA, company.UPIPayeeName, company.ValidationProfile, company.LUTNo, company.LUTFiscalYear,
+	).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A company with this GSTIN already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating company: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create company"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Company created successfully",
+		"company_id": id,
+	})
+}
+
+// handleUpdateCompany updates an existing company
+func handleUpdateCompany(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return
+	}
+
+	company, ok := bindCompany(c)
+	if !ok {
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if company.IsDefault {
+		if _, err := tx.Exec(ctx,
+			"UPDATE companies SET is_default = FALSE WHERE user_id = $1 AND id <> $2", userID, id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update default company"})
+			return
+		}
+	}
+
+	result, err := tx.Exec(ctx, `
+		UPDATE companies
+		SET name = $1, gstin = $2, address = $3, city = $4, state = $5, pincode = $6,
+			phone = $7, email = $8, is_default = $9, qty_decimals = $10, rate_decimals = $11,
+			rounding = $12, rounding_method = $13, round_off = $14, upi_vpa = $15, upi_payee_name = $16,
+			validation_profile = $17, lut_no = $18, lut_fy = $19
+		WHERE id = $20 AND user_id = $21
+	`,
+		company.Name, company.GSTIN, company.Address, company.City, company.State,
+		company.Pincode, company.Phone, company.Email, company.IsDefault,
+		company.QtyDecimals, company.RateDecimals, company.Rounding, company.RoundingMethod, company.RoundOff,
+		company.UPIVPA, company.UPIPayeeName, company.ValidationProfile, company.LUTNo, company.LUTFiscalYear, id, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update company"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Company not found or not authorized"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update company"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Company updated successfully",
+		"company_id": id,
+	})
+}
+
+// handleDeleteCompany deletes a company
+func handleDeleteCompany(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM companies WHERE id = $1 AND user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete company"})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Company not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Company deleted successfully",
+		"company_id": id,
+	})
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gzipMinSize is the smallest response worth compressing, when its size is known up front
+const gzipMinSize = 1024
+
+// gzipWriters reuses compressors between responses
+var gzipWriters = sync.Pool{
+	New: func() interface{} { return gzip.NewWriter(nil) },
+}
+
+// compressibleTypes are the content types compressed; images, ZIP archives
+// and Excel workbooks are compressed already
+var compressibleTypes = []string{
+	"application/json", "application/x-ndjson", "application/javascript", "application/xml", "application/pdf",
+	"image/svg+xml", "text/",
+}
+
+// isCompressible reports whether a response of the given content type benefits from gzip
+func isCompressible(contentType string) bool {
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsGzip reports whether an Accept-Encoding header allows gzip
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q := strings.ReplaceAll(params, " ", "")
+		return q != "q=0" && q != "q=0.0" && q != "q=0.00" && q != "q=0.000"
+	}
+	return false
+}
+
+// gzipWriter compresses a response once its headers show it is worth it.
+// The decision is made on the first write, when the status and content type
+// are known.
+type gzipWriter struct {
+	gin.ResponseWriter
+	gz      *gzip.Writer
+	decided bool
+}
+
+// decide turns on compression for compressible responses with a body
+func (w *gzipWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+
+	h := w.Header()
+	status := w.Status()
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusPartialContent ||
+		status == http.StatusNotModified || h.Get("Content-Encoding") != "" || !isCompressible(h.Get("Content-Type")) {
+		return
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < gzipMinSize {
+		return
+	}
+
+	h.Set("Content-Encoding", "gzip")
+	h.Del("Content-Length")
+	// The compressed body is a different representation, so its validator is
+	// weak; If-None-Match still matches it by weak comparison
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		h.Set("ETag", "W/"+etag)
+	}
+	w.gz = gzipWriters.Get().(*gzip.Writer)
+	w.gz.Reset(w.ResponseWriter)
+}
+
+func (w *gzipWriter) Write(data []byte) (int, error) {
+	w.decide()
+	if w.gz != nil {
+		return w.gz.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *gzipWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *gzipWriter) Flush() {
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+// close finishes the compressed stream, if any
+func (w *gzipWriter) close() {
+	if w.gz == nil {
+		return
+	}
+	w.gz.Close()
+	w.gz.Reset(nil)
+	gzipWriters.Put(w.gz)
+	w.gz = nil
+}
+
+// gzipMiddleware compresses JSON, text and PDF responses for clients that accept gzip
+func gzipMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(c.GetHeader("Accept-Encoding")) {
+			c.Next()
+			return
+		}
+
+		w := &gzipWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+		defer func() {
+			w.close()
+			c.Writer = w.ResponseWriter
+		}()
+		c.Next()
+	}
+}
+
+// contentETag returns a strong entity tag for a response body
+func contentETag(body []byte) string {
+	sum := sha256.Sum256(body)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// serveConditional writes a response body with an ETag of its content, and a
+// Last-Modified date unless modTime is zero, answering If-None-Match and
+// If-Modified-Since with 304 Not Modified. Responses must be revalidated
+// before reuse unless the handler set its own Cache-Control.
+func serveConditional(c *gin.Context, contentType string, modTime time.Time, body []byte) {
+	c.Header("ETag", contentETag(body))
+	if c.Writer.Header().Get("Cache-Control") == "" {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+	c.Header("Content-Type", contentType)
+	http.ServeContent(c.Writer, c.Request, "", modTime, bytes.NewReader(body))
+}
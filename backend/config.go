@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// configBundleVersion is the format version written to configuration bundles
+const configBundleVersion = 1
+
+// configSeries is an invoice number series in a configuration bundle
+type configSeries struct {
+	Prefix     string `json:"prefix"`
+	NextNumber int    `json:"next_number"`
+}
+
+// configPreferences holds the account preferences in a configuration bundle.
+// The default company travels with the companies, so the settings omit it.
+type configPreferences struct {
+	Sandbox  bool                 `json:"sandbox"`
+	Settings *models.UserSettings `json:"settings,omitempty"`
+}
+
+// configBundle is all of an account's non-invoice configuration, used to
+// provision another account or environment from a known-good setup. IDs and
+// timestamps are exported for reference and ignored on import.
+type configBundle struct {
+	Version       int                     `json:"version"`
+	ExportedAt    time.Time               `json:"exported_at"`
+	Companies     []models.CompanyDetails `json:"companies"`
+	Series        []configSeries          `json:"series"`
+	ExcelMappings []*models.ExcelMapping  `json:"excel_mappings"`
+	Preferences   configPreferences       `json:"preferences"`
+}
+
+// loadConfigBundle reads the user's configuration into a bundle
+func loadConfigBundle(ctx context.Context, userID int) (*configBundle, error) {
+	bundle := &configBundle{
+		Version:       configBundleVersion,
+		ExportedAt:    time.Now().UTC(),
+		Companies:     make([]models.CompanyDetails, 0),
+		Series:        make([]configSeries, 0),
+		ExcelMappings: make([]*models.ExcelMapping, 0),
+	}
+
+	rows, err := dbPool.Query(ctx,
+		"SELECT "+companyColumns+" FROM companies WHERE user_id = $1 ORDER BY is_default DESC, name", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		co, err := scanCompany(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bundle.Companies = append(bundle.Companies, *co)
+	}
+	rows.Close()
+
+	rows, err = dbPool.Query(ctx,
+		"SELECT prefix, next_number FROM invoice_series WHERE user_id = $1 ORDER BY prefix", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var s configSeries
+		if err := rows.Scan(&s.Prefix, &s.NextNumber); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bundle.Series = append(bundle.Series, s)
+	}
+	rows.Close()
+
+	rows, err = dbPool.Query(ctx,
+		"SELECT "+excelMappingColumns+" FROM excel_mappings WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		m, err := scanExcelMapping(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		bundle.ExcelMappings = append(bundle.ExcelMappings, m)
+	}
+	rows.Close()
+
+	err = dbPool.QueryRow(ctx, "SELECT sandbox FROM users WHERE id = $1", userID).
+		Scan(&bundle.Preferences.Sandbox)
+	if err != nil {
+		return nil, err
+	}
+
+	settings, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	settings.DefaultCompanyID = nil
+	settings.UpdatedAt = nil
+	bundle.Preferences.Settings = settings
+
+	return bundle, nil
+}
+
+// validate checks every entry of an uploaded bundle before anything is imported
+func (b *configBundle) validate() error {
+	if b.Version != configBundleVersion {
+		return fmt.Errorf("unsupported bundle version %d", b.Version)
+	}
+
+	defaults := 0
+	gstins := make(map[string]bool, len(b.Companies))
+	for i := range b.Companies {
+		co := &b.Companies[i]
+		if err := validateCompany(co); err != nil {
+			return fmt.Errorf("company %d: %v", i+1, err)
+		}
+		if gstins[co.GSTIN] {
+			return fmt.Errorf("company %d: duplicate GSTIN %s", i+1, co.GSTIN)
+		}
+		gstins[co.GSTIN] = true
+		if co.IsDefault {
+			defaults++
+		}
+	}
+	if defaults > 1 {
+		return fmt.Errorf("only one company can be the default")
+	}
+
+	for i, s := range b.Series {
+		if s.Prefix == "" || len(s.Prefix) > 20 {
+			return fmt.Errorf("series %d: prefix must be 1 to 20 characters", i+1)
+		}
+		if s.NextNumber < 1 {
+			return fmt.Errorf("series %s: next_number must be at least 1", s.Prefix)
+		}
+	}
+
+	for _, m := range b.ExcelMappings {
+		if m == nil {
+			return fmt.Errorf("excel mapping must not be null")
+		}
+		if err := m.Validate(); err != nil {
+			return fmt.Errorf("excel mapping %q: %v", m.Name, err)
+		}
+	}
+
+	if b.Preferences.Settings != nil {
+		if err := b.Preferences.Settings.Validate(); err != nil {
+			return fmt.Errorf("settings: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// handleExportConfig downloads the user's configuration as a JSON bundle
+func handleExportConfig(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	bundle, err := loadConfigBundle(c.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Error exporting configuration: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to export configuration"})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename=einvoice-config.json")
+	c.JSON(http.StatusOK, bundle)
+}
+
+// handleImportConfig applies a configuration bundle to the user's account.
+// Entries are matched by company GSTIN, series prefix and mapping name;
+// matching entries are updated and the rest are created. Series never move
+// backwards, so numbers already issued are not handed out again.
+func handleImportConfig(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var bundle configBundle
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid configuration bundle: " + err.Error()})
+		return
+	}
+	if err := bundle.validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	for _, co := range bundle.Companies {
+		if co.IsDefault {
+			if _, err := tx.Exec(ctx,
+				"UPDATE companies SET is_default = FALSE WHERE user_id = $1", userID); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update default company"})
+				return
+			}
+		}
+	}
+
+	for _, co := range bundle.Companies {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO companies (
+				user_id, name, gstin, address, city, state, pincode, phone, email,
+				is_default, qty_decimals, rate_decimals, rounding, rounding_method, round_off,
+				upi_vpa, upi_payee_name, validation_profile, lut_no, lut_fy
+			) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20)
+			ON CONFLICT (user_id, gstin) DO UPDATE
+			SET name = $2, address = $4, city = $5, state = $6, pincode = $7, phone = $8,
+				email = $9, is_default = $10, qty_decimals = $11, rate_decimals = $12, rounding = $13,
+				rounding_method = $14, round_off = $15, upi_vpa = $16, upi_payee_name = $17,
+				validation_profile = $18, lut_no = $19, lut_fy = $20
+		`, userID, co.Name, co.GSTIN, co.Address, co.City, co.State, co.Pincode, co.Phone,
+			co.Email, co.IsDefault, co.QtyDecimals, co.RateDecimals, co.Rounding, co.RoundingMethod, co.RoundOff,
+			co.UPIVPA, co.UPIPayeeName, co.ValidationProfile, co.LUTNo, co.LUTFiscalYear)
+		if err != nil {
+			log.Printf("Error importing company %s: %v", co.GSTIN, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import company " + co.GSTIN})
+			return
+		}
+	}
+
+	for _, s := range bundle.Series {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO invoice_series (user_id, prefix, next_number)
+			VALUES ($1, $2, $3)
+			ON CONFLICT (user_id, prefix) DO UPDATE
+			SET next_number = GREATEST(invoice_series.next_number, $3)
+		`, userID, s.Prefix, s.NextNumber)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import series " + s.Prefix})
+			return
+		}
+	}
+
+	for _, m := range bundle.ExcelMappings {
+		columns, err := json.Marshal(m.Columns)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import excel mapping " + m.Name})
+			return
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO excel_mappings (user_id, name, sheet, header_row, columns)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, name) DO UPDATE
+			SET sheet = $3, header_row = $4, columns = $5, updated_at = NOW()
+		`, userID, m.Name, m.Sheet, m.HeaderRow, columns)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import excel mapping " + m.Name})
+			return
+		}
+	}
+
+	if _, err := tx.Exec(ctx,
+		"UPDATE users SET sandbox = $1 WHERE id = $2", bundle.Preferences.Sandbox, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import preferences"})
+		return
+	}
+	if bundle.Preferences.Settings != nil {
+		if err := saveUserSettings(ctx, tx, userID, bundle.Preferences.Settings); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import preferences"})
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import configuration"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":        "Configuration imported successfully",
+		"companies":      len(bundle.Companies),
+		"series":         len(bundle.Series),
+		"excel_mappings": len(bundle.ExcelMappings),
+	})
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+)
+
+// corsPolicy applies a CORS configuration to requests under a set of path prefixes
+type corsPolicy struct {
+	name     string
+	prefixes []string
+	handler  gin.HandlerFunc
+	wildcard bool
+}
+
+// matches reports whether the policy covers the request path
+func (p *corsPolicy) matches(path string) bool {
+	for _, prefix := range p.prefixes {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseOrigins splits a comma-separated origin list, dropping empty entries
+func parseOrigins(value string) []string {
+	origins := make([]string, 0)
+	for _, origin := range strings.Split(value, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			origins = append(origins, origin)
+		}
+	}
+	return origins
+}
+
+// getCORSMaxAge returns how long browsers may cache preflight responses
+func getCORSMaxAge() time.Duration {
+	seconds, err := strconv.Atoi(getEnvWithDefault("CORS_MAX_AGE", "43200"))
+	if err != nil || seconds < 0 {
+		log.Printf("Invalid CORS_MAX_AGE value, defaulting to 43200")
+		seconds = 43200
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// getAPIOrigins returns the origins allowed to call the authenticated API.
+// CORS_API_ORIGINS overrides the FRONTEND_ORIGIN defaults; the dev origin
+// is only allowed outside production. A binary serving the embedded
+// frontend needs neither, as the app then calls the API from its own origin.
+func getAPIOrigins() []string {
+	if origins := parseOrigins(os.Getenv("CORS_API_ORIGINS")); len(origins) > 0 {
+		return origins
+	}
+
+	frontendOrigin := os.Getenv("FRONTEND_ORIGIN")
+	if frontendOrigin == "" {
+		if spaFiles() != nil {
+			log.Printf("No FRONTEND_ORIGIN or CORS_API_ORIGINS; the API only accepts requests from the embedded frontend")
+			return nil
+		}
+		log.Fatal("FRONTEND_ORIGIN or CORS_API_ORIGINS environment variable is required")
+	}
+	origins := []string{frontendOrigin}
+
+	if os.Getenv("APP_ENV") != "production" {
+		// Get dev origin, default to localhost:3000 if not set
+		frontendDevOrigin := os.Getenv("FRONTEND_ORIGIN_DEV")
+		if frontendDevOrigin == "" {
+			frontendDevOrigin = "http://localhost:3000"
+			log.Printf("FRONTEND_ORIGIN_DEV not set, defaulting to %s", frontendDevOrigin)
+		}
+		origins = append(origins, frontendDevOrigin)
+	}
+
+	return origins
+}
+
+// newCORSPolicy builds a policy, logging its allowed origins for debugging.
+// Policies that allow credentials must list their origins: browsers reject
+// credentialed responses to a wildcard, and reflecting any origin instead
+// would let every site call the API as the signed-in user.
+func newCORSPolicy(name string, prefixes []string, config cors.Config) *corsPolicy {
+	wildcard := false
+	for _, origin := range config.AllowOrigins {
+		wildcard = wildcard || origin == "*"
+	}
+	if wildcard && config.AllowCredentials {
+		log.Fatalf("CORS policy %q allows credentials and cannot allow all origins; list them explicitly", name)
+	}
+	if wildcard && len(config.AllowOrigins) > 1 {
+		log.Fatalf("CORS policy %q mixes * with other origins", name)
+	}
+	if wildcard {
+		config.AllowOrigins = nil
+		config.AllowAllOrigins = true
+	}
+	if !wildcard && len(config.AllowOrigins) == 0 {
+		// Same-origin requests need no CORS headers; cross-origin ones get none
+		log.Printf("CORS policy %q allows no cross-origin requests", name)
+		return &corsPolicy{name: name, prefixes: prefixes, handler: func(c *gin.Context) {}}
+	}
+	log.Printf("CORS policy %q allows origins: %s", name, strings.Join(config.AllowOrigins, ", "))
+	return &corsPolicy{
+		name:     name,
+		prefixes: prefixes,
+		handler:  cors.New(config),
+		wildcard: wildcard,
+	}
+}
+
+// configureCORS installs per-route-group CORS policies:
+//   - public: template and schema downloads, any origin by default, no credentials
+//   - portal: the buyer portal, CORS_PORTAL_ORIGINS (defaults to the API origins)
+//   - api: all other /api routes, CORS_API_ORIGINS with credentials
+//
+// Policies are selected by path in a single router-level middleware so that
+// preflight requests are answered even though no OPTIONS routes are registered.
+func configureCORS(router *gin.Engine) {
+	maxAge := getCORSMaxAge()
+	apiOrigins := getAPIOrigins()
+
+	publicOrigins := parseOrigins(getEnvWithDefault("CORS_PUBLIC_ORIGINS", "*"))
+	portalOrigins := parseOrigins(os.Getenv("CORS_PORTAL_ORIGINS"))
+	if len(portalOrigins) == 0 {
+		portalOrigins = apiOrigins
+	}
+
+	policies := []*corsPolicy{
+		newCORSPolicy("public", []string{"/api/download-template", "/api/public"}, cors.Config{
+			AllowOrigins:  publicOrigins,
+			AllowMethods:  []string{"GET", "HEAD", "OPTIONS"},
+			AllowHeaders:  []string{"Origin", "Accept"},
+			ExposeHeaders: []string{"Content-Disposition", "Content-Length", "Content-Type", "Retry-After", "X-RateLimit-Limit", "X-RateLimit-Remaining", "X-RateLimit-Reset", "Deprecation", "Sunset", "Link"},
+			MaxAge:        maxAge,
+		}),
+		newCORSPolicy("portal", []string{"/api/portal"}, cors.Config{
+			AllowOrigins:     portalOrigins,
+			AllowMethods:     []string{"GET", "POST", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
+			ExposeHeaders:    []string{"Content-Disposition", "Content-Length", "Content-Type", "Deprecation", "Sunset", "Link"},
+			AllowCredentials: true,
+			MaxAge:           maxAge,
+		}),
+		newCORSPolicy("api", []string{"/api"}, cors.Config{
+			AllowOrigins:     apiOrigins,
+			AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+			AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
+			ExposeHeaders:    []string{"Content-Disposition", "Content-Length", "Content-Type", "Retry-After", "X-Import-ID", "X-Imported-Invoices", "X-Failed-Rows", "Deprecation", "Sunset", "Link"},
+			AllowCredentials: true,
+			MaxAge:           maxAge,
+		}),
+	}
+
+	router.Use(func(c *gin.Context) {
+		for _, policy := range policies {
+			if !policy.matches(c.Request.URL.Path) {
+				continue
+			}
+
+			// Responses differ by origin and preflight request headers, so
+			// caches must key on them even for requests without an Origin
+			if !policy.wildcard {
+				c.Writer.Header().Add("Vary", "Origin")
+			}
+			if c.Request.Method == "OPTIONS" {
+				c.Writer.Header().Add("Vary", "Access-Control-Request-Method")
+				c.Writer.Header().Add("Vary", "Access-Control-Request-Headers")
+			}
+
+			policy.handler(c)
+			return
+		}
+	})
+}
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// credentialProviders are the services whose credentials can be stored for a company
+var credentialProviders = map[string]string{
+	"gstn": "GST portal",
+	"irp":  "Invoice Registration Portal",
+	"gsp":  "GST Suvidha Provider",
+}
+
+// companyCredentials are the secrets stored for a company and provider; all
+// of them are encrypted at rest
+type companyCredentials struct {
+	Username     string `json:"username"`
+	Password     string `json:"password"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// credentialAAD binds stored credentials to their company and provider
+func credentialAAD(companyID int, provider string) []byte {
+	return []byte(fmt.Sprintf("company_credentials:%d:%s", companyID, provider))
+}
+
+// createCredentialTables creates the encrypted credential store
+func createCredentialTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS company_credentials (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			company_id INTEGER NOT NULL REFERENCES companies(id) ON DELETE CASCADE,
+			provider VARCHAR(10) NOT NULL,
+			master_key_id VARCHAR(100) NOT NULL,
+			wrapped_key BYTEA NOT NULL,
+			ciphertext BYTEA NOT NULL,
+			last_tested_at TIMESTAMP,
+			last_test_ok BOOLEAN,
+			last_test_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(company_id, provider)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create company_credentials table: %v", err)
+	}
+}
+
+// loadCompanyCredentials decrypts the credentials stored for a company and
+// provider, returning pgx.ErrNoRows when there are none
+func loadCompanyCredentials(ctx context.Context, companyID int, provider string) (*companyCredentials, error) {
+	if vault == nil {
+		return nil, errVaultDisabled
+	}
+	var sealed sealedSecret
+	err := dbPool.QueryRow(ctx,
+		"SELECT master_key_id, wrapped_key, ciphertext FROM company_credentials WHERE company_id = $1 AND provider = $2",
+		companyID, provider).Scan(&sealed.KeyID, &sealed.WrappedKey, &sealed.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := vault.open(&sealed, credentialAAD(companyID, provider))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt credentials: %w", err)
+	}
+	var creds companyCredentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, err
+	}
+	return &creds, nil
+}
+
+// companyCredentialParams reads and checks the company ID and provider of a
+// credentials request, responding with an error when they are invalid. It
+// returns the company's GSTIN.
+func companyCredentialParams(c *gin.Context) (int, string, string, bool) {
+	if vault == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errVaultDisabled.Error()})
+		return 0, "", "", false
+	}
+	companyID, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid company ID"})
+		return 0, "", "", false
+	}
+	provider := c.Param("provider")
+	if provider != "" {
+		if _, ok := credentialProviders[provider]; !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Provider must be gstn, irp or gsp"})
+			return 0, "", "", false
+		}
+	}
+
+	var gstin string
+	err = dbPool.QueryRow(c.Request.Context(),
+		"SELECT gstin FROM companies WHERE id = $1 AND user_id = $2",
+		companyID, c.GetInt("userID")).Scan(&gstin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Company not found or not authorized"})
+		return 0, "", "", false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return 0, "", "", false
+	}
+	return companyID, provider, gstin, true
+}
+
+// handleGetCompanyCredentials lists the credentials stored for a company.
+// Usernames and client IDs are shown; passwords and client secrets never leave the server.
+func handleGetCompanyCredentials(c *gin.Context) {
+	companyID, _, _, ok := companyCredentialParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT provider, master_key_id, wrapped_key, ciphertext, last_tested_at, last_test_ok,
+			COALESCE(last_test_error, ''), updated_at
+		FROM company_credentials WHERE company_id = $1 ORDER BY provider
+	`, companyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credentials"})
+		return
+	}
+	defer rows.Close()
+
+	credentials := make([]gin.H, 0)
+	for rows.Next() {
+		var provider, testError string
+		var sealed sealedSecret
+		var testedAt *time.Time
+		var testOK *bool
+		var updatedAt time.Time
+		if err := rows.Scan(&provider, &sealed.KeyID, &sealed.WrappedKey, &sealed.Ciphertext,
+			&testedAt, &testOK, &testError, &updatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read credentials"})
+			return
+		}
+		entry := gin.H{
+			"provider":       provider,
+			"name":           credentialProviders[provider],
+			"updated_at":     updatedAt,
+			"last_tested_at": testedAt,
+			"last_test_ok":   testOK,
+		}
+		if testError != "" {
+			entry["last_test_error"] = testError
+		}
+
+		var creds companyCredentials
+		plaintext, err := vault.open(&sealed, credentialAAD(companyID, provider))
+		if err == nil {
+			err = json.Unmarshal(plaintext, &creds)
+		}
+		if err != nil {
+			log.Printf("Error decrypting %s credentials of company %d: %v", provider, companyID, err)
+			entry["error"] = "Credentials cannot be decrypted with the configured master keys"
+		} else {
+			entry["username"] = creds.Username
+			entry["client_id"] = creds.ClientID
+			entry["password_set"] = creds.Password != ""
+			entry["client_secret_set"] = creds.ClientSecret != ""
+		}
+		credentials = append(credentials, entry)
+	}
+	if rowsFailed(c, rows, "Failed to fetch credentials") {
+		return
+	}
+
+	c.JSON(http.StatusOK, credentials)
+}
+
+// handleSetCompanyCredentials stores or rotates a company's credentials for a
+// provider. Fields left out keep their stored value, so a password can be
+// rotated on its own.
+func handleSetCompanyCredentials(c *gin.Context) {
+	companyID, provider, _, ok := companyCredentialParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	var req struct {
+		Username     *string `json:"username"`
+		Password     *string `json:"password"`
+		ClientID     *string `json:"client_id"`
+		ClientSecret *string `json:"client_secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	creds, err := loadCompanyCredentials(ctx, companyID, provider)
+	if errors.Is(err, pgx.ErrNoRows) {
+		creds, err = &companyCredentials{}, nil
+	}
+	if err != nil {
+		log.Printf("Error loading %s credentials of company %d: %v", provider, companyID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read stored credentials"})
+		return
+	}
+	for _, field := range []struct {
+		value  *string
+		stored *string
+	}{
+		{req.Username, &creds.Username},
+		{req.Password, &creds.Password},
+		{req.ClientID, &creds.ClientID},
+		{req.ClientSecret, &creds.ClientSecret},
+	} {
+		if field.value != nil {
+			*field.stored = strings.TrimSpace(*field.value)
+		}
+	}
+	if creds.Username == "" || creds.Password == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Username and password are required"})
+		return
+	}
+	if len(creds.Username) > 100 || len(creds.Password) > 200 || len(creds.ClientID) > 200 || len(creds.ClientSecret) > 200 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Credentials are too long"})
+		return
+	}
+
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store credentials"})
+		return
+	}
+	sealed, err := vault.seal(plaintext, credentialAAD(companyID, provider))
+	if err != nil {
+		log.Printf("Error encrypting credentials: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store credentials"})
+		return
+	}
+
+	// Changed credentials have not been tested yet
+	_, err = dbPool.Exec(ctx, `
+		INSERT INTO company_credentials (user_id, company_id, provider, master_key_id, wrapped_key, ciphertext)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (company_id, provider) DO UPDATE
+		SET master_key_id = $4, wrapped_key = $5, ciphertext = $6, updated_at = NOW(),
+			last_tested_at = NULL, last_test_ok = NULL, last_test_error = NULL
+	`, c.GetInt("userID"), companyID, provider, sealed.KeyID, sealed.WrappedKey, sealed.Ciphertext)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  credentialProviders[provider] + " credentials saved",
+		"provider": provider,
+	})
+}
+
+// handleDeleteCompanyCredentials removes a company's credentials for a provider
+func handleDeleteCompanyCredentials(c *gin.Context) {
+	companyID, provider, _, ok := companyCredentialParams(c)
+	if !ok {
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM company_credentials WHERE company_id = $1 AND provider = $2", companyID, provider)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete credentials"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No credentials stored for this provider"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": credentialProviders[provider] + " credentials deleted"})
+}
+
+// irpAuthResponse is the response of the IRP authentication API
+type irpAuthResponse struct {
+	Status       int                `json:"Status"`
+	ErrorDetails []irpErrorResponse `json:"ErrorDetails"`
+}
+
+// testIRPCredentials signs in to the IRP authentication API, through the GSP
+// configured as IRP_API_URL, with a company's credentials
+func testIRPCredentials(ctx context.Context, gstin string, creds *companyCredentials) error {
+	apiURL := os.Getenv("IRP_API_URL")
+	if apiURL == "" {
+		return errIRPDisabled
+	}
+
+	body, err := json.Marshal(gin.H{
+		"UserName":                creds.Username,
+		"Password":                creds.Password,
+		"ForceRefreshAccessToken": true,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(apiURL, "/")+"/auth", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("client_id", creds.ClientID)
+	req.Header.Set("client_secret", creds.ClientSecret)
+	req.Header.Set("gstin", gstin)
+	req.Header.Set("user_name", creds.Username)
+	if apiKey := os.Getenv("IRP_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := irpHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("IRP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read IRP response: %w", err)
+	}
+
+	var result irpAuthResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return fmt.Errorf("IRP returned status %d", resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || result.Status != 1 {
+		if len(result.ErrorDetails) > 0 {
+			return fmt.Errorf("IRP rejected the credentials (%s): %s",
+				result.ErrorDetails[0].ErrorCode, result.ErrorDetails[0].ErrorMessage)
+		}
+		return fmt.Errorf("IRP rejected the credentials (status %d)", resp.StatusCode)
+	}
+	return nil
+}
+
+// handleTestCompanyCredentials checks stored IRP or GSP credentials by signing
+// in with them, and records the outcome
+func handleTestCompanyCredentials(c *gin.Context) {
+	companyID, provider, gstin, ok := companyCredentialParams(c)
+	if !ok {
+		return
+	}
+	ctx := c.Request.Context()
+
+	if provider == "gstn" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "GST portal credentials cannot be tested from here"})
+		return
+	}
+	creds, err := loadCompanyCredentials(ctx, companyID, provider)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No credentials stored for this provider"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading %s credentials of company %d: %v", provider, companyID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read stored credentials"})
+		return
+	}
+
+	testErr := testIRPCredentials(ctx, gstin, creds)
+	if errors.Is(testErr, errIRPDisabled) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": testErr.Error()})
+		return
+	}
+	message := ""
+	if testErr != nil {
+		message = testErr.Error()
+	}
+	_, err = dbPool.Exec(ctx, `
+		UPDATE company_credentials
+		SET last_tested_at = NOW(), last_test_ok = $1, last_test_error = NULLIF($2, '')
+		WHERE company_id = $3 AND provider = $4
+	`, testErr == nil, message, companyID, provider)
+	if err != nil {
+		log.Printf("Error recording credential test: %v", err)
+	}
+
+	if testErr != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": message, "ok": false})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Credentials accepted", "ok": true})
+}
+
+// credentialTables are the tables holding secrets sealed by the vault
+var credentialTables = []string{"company_credentials", "integrations", "payment_gateways"}
+
+// handleAdminRewrapCredentials rewraps every stored data key, of company
+// credentials, integration tokens and payment gateway secrets, with the
+// active master key, after which retired master keys can be removed
+func handleAdminRewrapCredentials(c *gin.Context) {
+	if vault == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errVaultDisabled.Error()})
+		return
+	}
+	ctx := c.Request.Context()
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	type rewrapped struct {
+		table  string
+		id     int
+		sealed *sealedSecret
+	}
+	updates := make([]rewrapped, 0)
+	failed := 0
+	for _, table := range credentialTables {
+		rows, err := tx.Query(ctx, `
+			SELECT id, master_key_id, wrapped_key, ciphertext FROM `+table+`
+			WHERE master_key_id <> $1 FOR UPDATE
+		`, vault.active)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch credentials"})
+			return
+		}
+		for rows.Next() {
+			var id int
+			var sealed sealedSecret
+			if err := rows.Scan(&id, &sealed.KeyID, &sealed.WrappedKey, &sealed.Ciphertext); err != nil {
+				rows.Close()
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read credentials"})
+				return
+			}
+			result, err := vault.rewrap(&sealed)
+			if err != nil {
+				log.Printf("Error rewrapping %s %d: %v", table, id, err)
+				failed++
+				continue
+			}
+			updates = append(updates, rewrapped{table: table, id: id, sealed: result})
+		}
+		rows.Close()
+		if rowsFailed(c, rows, "Failed to fetch credentials") {
+			return
+		}
+	}
+
+	for _, u := range updates {
+		if _, err := tx.Exec(ctx,
+			"UPDATE "+u.table+" SET master_key_id = $1, wrapped_key = $2 WHERE id = $3",
+			u.sealed.KeyID, u.sealed.WrappedKey, u.id); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update credentials"})
+			return
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update credentials"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       fmt.Sprintf("%d credential(s) rewrapped with master key %q", len(updates), vault.active),
+		"rewrapped":     len(updates),
+		"failed":        failed,
+		"master_key_id": vault.active,
+	})
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleGetCustomers returns the authenticated user's customers, a page at a
+// time when a limit is given
+func handleGetCustomers(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	page, message := parsePageRequest(c, partySortFields, "name")
+	if page == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	args := []interface{}{userID}
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, name, COALESCE(gstin, ''), COALESCE(address, ''), COALESCE(city, ''),
+			COALESCE(state, ''), COALESCE(pincode, 0), COALESCE(phone, ''),
+			COALESCE(email, ''), created_at, `+page.keySQL("")+`
+		FROM customers
+		WHERE user_id = $1`+page.whereSQL("", &args)+page.orderSQL(""),
+		args...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch customers"})
+		return
+	}
+	defer rows.Close()
+
+	customers := make([]models.CustomerDetails, 0)
+	for rows.Next() {
+		var cu models.CustomerDetails
+		var pageKey string
+		err := rows.Scan(
+			&cu.ID, &cu.Name, &cu.GSTIN, &cu.Address, &cu.City, &cu.State,
+			&cu.Pincode, &cu.Phone, &cu.Email, &cu.CreatedAt, &pageKey,
+		)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan customer data"})
+			return
+		}
+		if !page.next(pageKey, int64(cu.ID)) {
+			break
+		}
+		cu.UserID = userID
+		customers = append(customers, cu)
+	}
+
+	c.JSON(http.StatusOK, page.respond(gin.H{"customers": customers}))
+}
+
+// handleCreateCustomer creates a new customer, auto-filling details from the GSTIN when available
+func handleCreateCustomer(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	var customer models.CustomerDetails
+	if err := c.ShouldBindJSON(&customer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer data: " + err.Error()})
+		return
+	}
+
+	if customer.GSTIN != "" && !models.IsValidGSTIN(customer.GSTIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer GSTIN format"})
+		return
+	}
+
+	autoFillParty(ctx, customer.GSTIN, &customer.Name, &customer.Address, &customer.City, &customer.State, &customer.Pincode)
+	autoFillFromPincode(ctx, customer.Pincode, &customer.City, &customer.State)
+
+	if customer.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Customer name is required"})
+		return
+	}
+
+	var id int
+	err := dbPool.QueryRow(ctx, `
+		INSERT INTO customers (
+			user_id, name, gstin, address, city, state, pincode, phone, email
+		) VALUES ($1, $2, NULLIF($3, ''), $4, $5, $6, $7, $8, $9)
+		RETURNING id
+	`,
+		userID, customer.Name, customer.GSTIN, customer.Address, customer.City,
+		customer.State, customer.Pincode, customer.Phone, customer.Email,
+	).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A customer with this GSTIN already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating customer: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	customer.ID = id
+	customer.UserID = userID
+	recordEvent(ctx, dbPool, userID, eventCustomerCreated, entityCustomer, id, customer)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Customer created successfully",
+		"customer_id": id,
+		"customer":    customer,
+	})
+}
+
+// handleUpdateCustomer updates an existing customer
+func handleUpdateCustomer(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+
+	var customer models.CustomerDetails
+	if err := c.ShouldBindJSON(&customer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer data"})
+		return
+	}
+
+	if customer.Name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Customer name is required"})
+		return
+	}
+	if customer.GSTIN != "" && !models.IsValidGSTIN(customer.GSTIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer GSTIN format"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE customers
+		SET name = $1, gstin = NULLIF($2, ''), address = $3, city = $4, state = $5,
+			pincode = $6, phone = $7, email = $8
+		WHERE id = $9 AND user_id = $10
+	`,
+		customer.Name, customer.GSTIN, customer.Address, customer.City,
+		customer.State, customer.Pincode, customer.Phone, customer.Email,
+		id, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found or not authorized"})
+		return
+	}
+	customer.ID = id
+	customer.UserID = userID
+	recordEvent(c.Request.Context(), dbPool, userID, eventCustomerUpdated, entityCustomer, id, customer)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Customer updated successfully",
+		"customer_id": id,
+	})
+}
+
+// handleDeleteCustomer deletes a customer
+func handleDeleteCustomer(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid customer ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM customers WHERE id = $1 AND user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer"})
+		return
+	}
+
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found or not authorized"})
+		return
+	}
+	recordEvent(c.Request.Context(), dbPool, userID, eventCustomerDeleted, entityCustomer, id, gin.H{})
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":     "Customer deleted successfully",
+		"customer_id": id,
+	})
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// getPoolSize reads a connection count from the environment
+func getPoolSize(key string, defaultValue int) int32 {
+	conns, err := strconv.Atoi(getEnvWithDefault(key, strconv.Itoa(defaultValue)))
+	if err != nil || conns < 0 {
+		log.Printf("Invalid %s value, defaulting to %d", key, defaultValue)
+		conns = defaultValue
+	}
+	return int32(conns)
+}
+
+// configurePool sizes the connection pool from DB_MAX_CONNS and DB_MIN_CONNS,
+// and sets from DB_MAX_CONN_LIFETIME, DB_MAX_CONN_IDLE_TIME and
+// DB_HEALTH_CHECK_PERIOD, in seconds, how long connections are kept and how
+// often idle ones are checked. A zero lifetime or idle time keeps pgx's default.
+func configurePool(config *pgxpool.Config) {
+	config.MaxConns = getPoolSize("DB_MAX_CONNS", 10)
+	if config.MaxConns < 1 {
+		log.Printf("Invalid DB_MAX_CONNS value, defaulting to 10")
+		config.MaxConns = 10
+	}
+	config.MinConns = getPoolSize("DB_MIN_CONNS", 0)
+	if config.MinConns > config.MaxConns {
+		log.Printf("DB_MIN_CONNS exceeds DB_MAX_CONNS, using %d", config.MaxConns)
+		config.MinConns = config.MaxConns
+	}
+
+	if d := getTimeoutSeconds("DB_MAX_CONN_LIFETIME", "3600"); d > 0 {
+		config.MaxConnLifetime = d
+	}
+	if d := getTimeoutSeconds("DB_MAX_CONN_IDLE_TIME", "1800"); d > 0 {
+		config.MaxConnIdleTime = d
+	}
+	if d := getTimeoutSeconds("DB_HEALTH_CHECK_PERIOD", "60"); d > 0 {
+		config.HealthCheckPeriod = d
+	}
+}
+
+// poolStats reports the connection pool's usage, showing whether requests
+// wait for connections and whether DB_MAX_CONNS suits the load
+func poolStats() gin.H {
+	stat := dbPool.Stat()
+	return gin.H{
+		"max_conns":                  stat.MaxConns(),
+		"total_conns":                stat.TotalConns(),
+		"acquired_conns":             stat.AcquiredConns(),
+		"idle_conns":                 stat.IdleConns(),
+		"constructing_conns":         stat.ConstructingConns(),
+		"acquire_count":              stat.AcquireCount(),
+		"empty_acquire_count":        stat.EmptyAcquireCount(),
+		"canceled_acquire_count":     stat.CanceledAcquireCount(),
+		"acquire_duration_ms":        stat.AcquireDuration().Milliseconds(),
+		"new_conns_count":            stat.NewConnsCount(),
+		"max_lifetime_destroy_count": stat.MaxLifetimeDestroyCount(),
+		"max_idle_destroy_count":     stat.MaxIdleDestroyCount(),
+	}
+}
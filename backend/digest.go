@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"text/template"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+// jobTypeSendDigest is the job type that emails a user's invoice digest
+const jobTypeSendDigest = "digest.email"
+
+// Digest statuses
+const (
+	digestQueued = "queued"
+	digestSent   = "sent"
+	digestFailed = "failed"
+)
+
+// digestListLimit is the most pending and overdue invoices listed in a digest
+const digestListLimit = 20
+
+// digestBody is the text of digest emails
+var digestBody = template.Must(template.New("digest").Parse(`Hello {{.Name}},
+
+Here is your {{.Frequency}} e-invoice summary for {{.Period}}.
+
+Invoices created: {{.Created}}{{if .Drafts}} ({{.Drafts}} still in draft){{end}}
+Taxable value: Rs. {{printf "%.2f" .Taxable}}
+GST: Rs. {{printf "%.2f" .Tax}}
+Invoice value: Rs. {{printf "%.2f" .Total}}
+Payments received: Rs. {{printf "%.2f" .Received}}
+
+Pending IRP submissions: {{.PendingCount}}
+{{range .Pending}}  - {{.InvoiceNo}} dated {{.Date}}, {{.BuyerName}}, Rs. {{printf "%.2f" .Total}}{{if .Error}} (rejected: {{.Error}}){{end}}
+{{end}}
+Overdue payments: {{.OverdueCount}}, Rs. {{printf "%.2f" .OverdueAmount}} outstanding
+{{range .Overdue}}  - {{.InvoiceNo}} to {{.BuyerName}}, Rs. {{printf "%.2f" .Outstanding}} due {{.DueDate}}
+{{end}}
+The attached workbook lists the invoices created in the period, every pending submission and every overdue invoice.
+
+To change how often you get this email, update the email digest in your settings.
+`))
+
+// digestInvoice is an invoice listed in a digest
+type digestInvoice struct {
+	InvoiceNo   string
+	Date        string
+	BuyerName   string
+	Total       float64
+	Outstanding float64
+	DueDate     string
+	Error       string
+}
+
+// digestData is what a digest reports for a period. Pending IRP submissions
+// and overdue payments are as they stand when the digest is sent.
+type digestData struct {
+	Name          string
+	Frequency     string
+	Period        string
+	Created       int
+	Drafts        int
+	Taxable       float64
+	Tax           float64
+	Total         float64
+	Received      float64
+	PendingCount  int
+	Pending       []digestInvoice
+	OverdueCount  int
+	OverdueAmount float64
+	Overdue       []digestInvoice
+}
+
+// createDigestTables creates the log of digests sent, which also keeps a
+// period's digest from being sent twice
+func createDigestTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS digest_runs (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			frequency VARCHAR(10) NOT NULL,
+			period_start TIMESTAMP NOT NULL,
+			period_end TIMESTAMP NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'queued',
+			error TEXT,
+			job_id INTEGER REFERENCES jobs(id) ON DELETE SET NULL,
+			sent_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, frequency, period_start)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create digest_runs table: %v", err)
+	}
+
+	registerJobHandler(jobTypeSendDigest, runSendDigestJob)
+}
+
+// digestSendHour is the hour of the day, Indian time, digests go out after
+// their period ends; DIGEST_SEND_HOUR overrides the default of 8
+func digestSendHour() int {
+	hour, err := strconv.Atoi(getEnvWithDefault("DIGEST_SEND_HOUR", "8"))
+	if err != nil || hour < 0 || hour > 23 {
+		return 8
+	}
+	return hour
+}
+
+// lastDigestPeriod returns the latest period of a frequency whose digest is
+// due at now. Daily periods are calendar days and weekly ones run from
+// Monday, in Indian time.
+func lastDigestPeriod(frequency string, now time.Time, sendHour int) (time.Time, time.Time) {
+	now = now.In(istLocation)
+	end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, istLocation)
+	days := 1
+	if frequency == models.DigestWeekly {
+		days = 7
+		end = end.AddDate(0, 0, -((int(end.Weekday()) + 6) % 7))
+	}
+	if now.Before(end.Add(time.Duration(sendHour) * time.Hour)) {
+		end = end.AddDate(0, 0, -days)
+	}
+	return end.AddDate(0, 0, -days), end
+}
+
+// startDigestScheduler queues the digests that have come due every ten
+// minutes until the context is cancelled
+func startDigestScheduler(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Minute)
+		defer ticker.Stop()
+
+		for {
+			if err := queueDueDigests(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error queueing email digests: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// queueDueDigests queues the digest of the last period for each user who
+// has digests turned on and has not been sent it yet
+func queueDueDigests(ctx context.Context) error {
+	rows, err := dbPool.Query(ctx,
+		"SELECT user_id, email_digest FROM user_settings WHERE email_digest IN ($1, $2)",
+		models.DigestDaily, models.DigestWeekly)
+	if err != nil {
+		return err
+	}
+	type subscriber struct {
+		userID    int
+		frequency string
+	}
+	subscribers := make([]subscriber, 0)
+	for rows.Next() {
+		var s subscriber
+		if err := rows.Scan(&s.userID, &s.frequency); err != nil {
+			rows.Close()
+			return err
+		}
+		subscribers = append(subscribers, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	now, sendHour := time.Now(), digestSendHour()
+	for _, s := range subscribers {
+		start, end := lastDigestPeriod(s.frequency, now, sendHour)
+		var runID int
+		err := dbPool.QueryRow(ctx, `
+			INSERT INTO digest_runs (user_id, frequency, period_start, period_end, status)
+			VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (user_id, frequency, period_start) DO NOTHING
+			RETURNING id
+		`, s.userID, s.frequency, start.UTC(), end.UTC(), digestQueued).Scan(&runID)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		jobID, err := enqueueJob(ctx, s.userID, jobTypeSendDigest, gin.H{"run_id": runID})
+		if err != nil {
+			// Forget the run so the next pass queues it again
+			dbPool.Exec(ctx, "DELETE FROM digest_runs WHERE id = $1", runID)
+			return err
+		}
+		if _, err := dbPool.Exec(ctx, "UPDATE digest_runs SET job_id = $1 WHERE id = $2", jobID, runID); err != nil {
+			log.Printf("Error linking digest %d to job %d: %v", runID, jobID, err)
+		}
+	}
+	return nil
+}
+
+// runSendDigestJob emails a user the digest of a period with the workbook
+// of its invoices attached
+func runSendDigestJob(ctx context.Context, job *models.Job) error {
+	var payload struct {
+		RunID int `json:"run_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	var userID int
+	var frequency, status, email, name string
+	var start, end time.Time
+	err := dbPool.QueryRow(ctx, `
+		SELECT r.user_id, r.frequency, r.period_start, r.period_end, r.status, u.email, u.name
+		FROM digest_runs r JOIN users u ON u.id = r.user_id
+		WHERE r.id = $1
+	`, payload.RunID).Scan(&userID, &frequency, &start, &end, &status, &email, &name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return permanentError(fmt.Errorf("digest %d not found", payload.RunID))
+	}
+	if err != nil {
+		return err
+	}
+	if status == digestSent {
+		return nil
+	}
+
+	fail := func(err error) error {
+		if _, dbErr := dbPool.Exec(ctx,
+			"UPDATE digest_runs SET status = $1, error = $2 WHERE id = $3", digestFailed, err.Error(), payload.RunID); dbErr != nil {
+			log.Printf("Error recording failure of digest %d: %v", payload.RunID, dbErr)
+		}
+		return err
+	}
+
+	cfg, err := getSMTPConfig()
+	if err != nil {
+		return fail(permanentError(err))
+	}
+
+	data, workbook, err := buildDigest(ctx, userID, start, end)
+	if err != nil {
+		return fail(err)
+	}
+	data.Name = firstNonEmpty(name, email)
+	data.Frequency = frequency
+	lastDay := end.In(istLocation).AddDate(0, 0, -1)
+	data.Period = start.In(istLocation).Format(invoiceDateLayout)
+	if frequency == models.DigestWeekly {
+		data.Period += " to " + lastDay.Format(invoiceDateLayout)
+	}
+
+	var body bytes.Buffer
+	if err := digestBody.Execute(&body, data); err != nil {
+		return fail(permanentError(err))
+	}
+	xlsx, err := workbook.WriteToBuffer()
+	if err != nil {
+		return fail(permanentError(fmt.Errorf("failed to write workbook: %w", err)))
+	}
+
+	subject := fmt.Sprintf("Your %s e-invoice summary for %s", frequency, data.Period)
+	filename := fmt.Sprintf("digest_%s.xlsx", lastDay.Format(exportFilterLayout))
+	message, err := buildMessage(cfg.from, []string{email}, nil, subject, body.String(), []mailAttachment{{
+		filename:    filename,
+		contentType: "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet",
+		data:        xlsx.Bytes(),
+	}})
+	if err != nil {
+		return fail(permanentError(err))
+	}
+	if err := sendMail(cfg, []string{email}, message); err != nil {
+		return fail(err)
+	}
+
+	_, err = dbPool.Exec(ctx,
+		"UPDATE digest_runs SET status = $1, error = NULL, sent_at = NOW() WHERE id = $2", digestSent, payload.RunID)
+	return err
+}
+
+// buildDigest gathers the figures of a user's digest for the period from
+// start to end, and the workbook attached to it: the period's invoices on
+// the first sheet and the pending submissions and overdue invoices on their
+// own sheets. Sandbox invoices are left out.
+func buildDigest(ctx context.Context, userID int, start, end time.Time) (*digestData, *excelize.File, error) {
+	data := &digestData{}
+	wb := newInvoiceWorkbook(ctx, userID)
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT invoice_json, status, `+invoiceTagListSQL+` FROM invoices
+		WHERE user_id = $1 AND NOT sandbox AND created_at >= $2 AND created_at < $3
+		ORDER BY id
+	`, userID, start.UTC(), end.UTC())
+	if err != nil {
+		return nil, nil, err
+	}
+	for rows.Next() {
+		var invoiceJSON []byte
+		var status, tags string
+		if err := rows.Scan(&invoiceJSON, &status, &tags); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+			continue
+		}
+		wb.add(&invoice, status, false, tags)
+
+		data.Created++
+		if status == models.InvoiceStatusDraft || status == models.InvoiceStatusPendingApproval {
+			data.Drafts++
+			continue
+		}
+		if status == models.InvoiceStatusCancelled {
+			continue
+		}
+		data.Taxable += invoice.ValDtls.AssVal
+		data.Tax += invoice.TotalTax()
+		data.Total += invoice.ValDtls.TotInvVal
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	err = dbPool.QueryRow(ctx, `
+		SELECT COALESCE(SUM(p.amount), 0)::float8 FROM payments p JOIN invoices i ON i.id = p.invoice_id
+		WHERE p.user_id = $1 AND NOT i.sandbox AND p.created_at >= $2 AND p.created_at < $3
+	`, userID, start.UTC(), end.UTC()).Scan(&data.Received)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pending, err := queryDigestInvoices(ctx, `
+		SELECT invoice_no, COALESCE(invoice_json->'DocDtls'->>'Dt', ''), COALESCE(invoice_json->'BuyerDtls'->>'LglNm', ''),
+			total_value::float8, 0::float8, '', COALESCE(irp_error_message, '')
+		FROM invoices
+		WHERE user_id = $1 AND status = $2 AND NOT sandbox AND COALESCE(irn, '') = ''
+		ORDER BY id
+	`, userID, models.InvoiceStatusFinalized)
+	if err != nil {
+		return nil, nil, err
+	}
+	overdue, err := queryDigestInvoices(ctx, `
+		SELECT invoice_no, COALESCE(invoice_json->'DocDtls'->>'Dt', ''), COALESCE(invoice_json->'BuyerDtls'->>'LglNm', ''),
+			total_value::float8, `+outstandingSQL+`,
+			to_char(`+dueDateSQL+`, 'DD/MM/YYYY'), ''
+		FROM invoices
+		WHERE user_id = $1 AND status = $2 AND NOT sandbox
+			AND `+dueDateSQL+` < CURRENT_DATE AND `+outstandingSQL+` > 0.005
+		ORDER BY `+dueDateSQL+`, id
+	`, userID, models.InvoiceStatusFinalized)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	data.PendingCount, data.OverdueCount = len(pending), len(overdue)
+	for _, inv := range overdue {
+		data.OverdueAmount += inv.Outstanding
+	}
+	data.Pending, data.Overdue = pending, overdue
+	if len(pending) > digestListLimit {
+		data.Pending = pending[:digestListLimit]
+	}
+	if len(overdue) > digestListLimit {
+		data.Overdue = overdue[:digestListLimit]
+	}
+
+	f := wb.file
+	f.SetSheetName("Sheet1", "Invoices")
+	addDigestSheet(f, "Pending IRP", []interface{}{"Invoice No", "Invoice Date", "Buyer Name", "Total Amount", "IRP Error"},
+		pending, func(inv digestInvoice) []interface{} {
+			return []interface{}{inv.InvoiceNo, inv.Date, inv.BuyerName, inv.Total, inv.Error}
+		})
+	addDigestSheet(f, "Overdue", []interface{}{"Invoice No", "Invoice Date", "Buyer Name", "Total Amount", "Outstanding", "Due Date"},
+		overdue, func(inv digestInvoice) []interface{} {
+			return []interface{}{inv.InvoiceNo, inv.Date, inv.BuyerName, inv.Total, models.Round(inv.Outstanding, models.AmountDecimals), inv.DueDate}
+		})
+	return data, f, nil
+}
+
+// queryDigestInvoices reads the invoices a digest lists. The query selects
+// the number, date, buyer, total, outstanding amount, due date and IRP error.
+func queryDigestInvoices(ctx context.Context, sql string, args ...interface{}) ([]digestInvoice, error) {
+	rows, err := dbPool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := make([]digestInvoice, 0)
+	for rows.Next() {
+		var inv digestInvoice
+		var dueDate *string
+		if err := rows.Scan(&inv.InvoiceNo, &inv.Date, &inv.BuyerName, &inv.Total, &inv.Outstanding,
+			&dueDate, &inv.Error); err != nil {
+			return nil, err
+		}
+		if dueDate != nil {
+			inv.DueDate = *dueDate
+		}
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// addDigestSheet adds a sheet listing invoices under a header row
+func addDigestSheet(f *excelize.File, name string, header []interface{}, invoices []digestInvoice, row func(digestInvoice) []interface{}) {
+	f.NewSheet(name)
+	f.SetSheetRow(name, "A1", &header)
+	for i, inv := range invoices {
+		values := row(inv)
+		cell, _ := excelize.CoordinatesToCellName(1, i+2)
+		f.SetSheetRow(name, cell, &values)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Invoice event types. Every invoice event except deletion carries an
+// invoiceSnapshot so projections can be rebuilt from the payload alone.
+const (
+	eventInvoiceCreated   = "invoice.created"
+	eventInvoiceSaved     = "invoice.saved"
+	eventInvoiceUpdated   = "invoice.updated"
+	eventInvoiceFinalized = "invoice.finalized"
+	eventInvoiceCancelled = "invoice.cancelled"
+	eventInvoiceExported  = "invoice.exported"
+	eventInvoiceDeleted   = "invoice.deleted"
+	// eventInvoiceIRNGenerated follows eventInvoiceExported when an invoice
+	// is first registered with the IRP
+	eventInvoiceIRNGenerated = "invoice.irn_generated"
+)
+
+// Payment event types; the payload is the payment
+const (
+	eventPaymentRecorded = "payment.recorded"
+	eventPaymentDeleted  = "payment.deleted"
+)
+
+// Customer event types; the payload is the customer as saved
+const (
+	eventCustomerCreated = "customer.created"
+	eventCustomerUpdated = "customer.updated"
+	eventCustomerDeleted = "customer.deleted"
+	// eventCustomerMerged is recorded against the customer kept by a merge;
+	// the payload also carries the duplicate merged into it
+	eventCustomerMerged = "customer.merged"
+)
+
+// Supplier event types
+const (
+	eventSupplierDeleted = "supplier.deleted"
+	// eventSupplierMerged is recorded against the supplier kept by a merge;
+	// the payload also carries the duplicate merged into it
+	eventSupplierMerged = "supplier.merged"
+)
+
+// Entity types that events are recorded against
+const (
+	entityInvoice  = "invoice"
+	entityCustomer = "customer"
+	entitySupplier = "supplier"
+	entityItem     = "item"
+	entityPayment  = "payment"
+)
+
+// execer is satisfied by both the pool and transactions
+type execer interface {
+	Exec(ctx context.Context, sql string, args ...interface{}) (pgconn.CommandTag, error)
+}
+
+// invoiceSnapshot is the payload of invoice events
+type invoiceSnapshot struct {
+	InvoiceNo   string  `json:"invoice_no"`
+	SellerGSTIN string  `json:"seller_gstin"`
+	BuyerGSTIN  string  `json:"buyer_gstin"`
+	BuyerName   string  `json:"buyer_name"`
+	Date        string  `json:"date"`
+	TotalValue  float64 `json:"total_value"`
+	Status      string  `json:"status"`
+	Sandbox     bool    `json:"sandbox"`
+}
+
+// invoiceSnapshotSQL builds an invoiceSnapshot from an invoices row
+const invoiceSnapshotSQL = `jsonb_build_object(
+	'invoice_no', invoice_no,
+	'seller_gstin', seller_gstin,
+	'buyer_gstin', COALESCE(invoice_json->'BuyerDtls'->>'Gstin', ''),
+	'buyer_name', COALESCE(invoice_json->'BuyerDtls'->>'LglNm', ''),
+	'date', COALESCE(invoice_json->'DocDtls'->>'Dt', ''),
+	'total_value', total_value::float8,
+	'status', status,
+	'sandbox', sandbox
+)`
+
+// createEventTables creates the domain event log and backfills a snapshot
+// event for invoices created before events were recorded
+func createEventTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS events (
+			id BIGSERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+			type VARCHAR(100) NOT NULL,
+			entity_type VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			tx_id BIGINT NOT NULL DEFAULT txid_current()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create events table: %v", err)
+	}
+
+	// Each event records the transaction that wrote it, so readers of the
+	// log can tell which events are settled; earlier events count as written
+	// by transaction 0
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE events ADD COLUMN IF NOT EXISTS tx_id BIGINT NOT NULL DEFAULT 0")
+	if err != nil {
+		log.Fatalf("Failed to add tx_id column to events table: %v", err)
+	}
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE events ALTER COLUMN tx_id SET DEFAULT txid_current()")
+	if err != nil {
+		log.Fatalf("Failed to set tx_id default on events table: %v", err)
+	}
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_events_tx ON events (tx_id, id)")
+	if err != nil {
+		log.Fatalf("Failed to create events transaction index: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_events_entity ON events (entity_type, entity_id)")
+	if err != nil {
+		log.Fatalf("Failed to create events index: %v", err)
+	}
+
+	result, err := dbPool.Exec(context.Background(), `
+		INSERT INTO events (user_id, type, entity_type, entity_id, payload, created_at)
+		SELECT user_id, $1, $2, id, `+invoiceSnapshotSQL+`, created_at
+		FROM invoices
+		WHERE NOT EXISTS (
+			SELECT 1 FROM events WHERE entity_type = $2 AND entity_id = invoices.id
+		)
+		ORDER BY id
+	`, eventInvoiceSaved, entityInvoice)
+	if err != nil {
+		log.Fatalf("Failed to backfill invoice events: %v", err)
+	}
+	if result.RowsAffected() > 0 {
+		log.Printf("Backfilled events for %d existing invoice(s)", result.RowsAffected())
+	}
+}
+
+// eventCursor is a reader's position in the event log, which is read in
+// order of the writing transaction, then of event ID
+type eventCursor struct {
+	txID    int64
+	eventID int64
+}
+
+// cursorAfter returns the position just after event
+func cursorAfter(event *models.Event) eventCursor {
+	return eventCursor{txID: event.TxID, eventID: event.ID}
+}
+
+// readSettledEvents reads up to limit events after the cursor that were
+// written by finished transactions. Event IDs are allocated when written
+// but become visible only at commit, so a long transaction can commit an
+// event below IDs already read. Every transaction older than the oldest one
+// still running has finished, however, so no event can later appear before
+// a position in the settled part of the log.
+func readSettledEvents(ctx context.Context, tx pgx.Tx, after eventCursor, limit int) ([]*models.Event, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, user_id, type, entity_type, entity_id, payload, created_at, tx_id
+		FROM events
+		WHERE (tx_id, id) > ($1, $2) AND tx_id < txid_snapshot_xmin(txid_current_snapshot())
+		ORDER BY tx_id, id
+		LIMIT $3
+	`, after.txID, after.eventID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := make([]*models.Event, 0, limit)
+	for rows.Next() {
+		var e models.Event
+		if err := rows.Scan(&e.ID, &e.UserID, &e.Type, &e.EntityType, &e.EntityID, &e.Payload, &e.CreatedAt, &e.TxID); err != nil {
+			return nil, err
+		}
+		events = append(events, &e)
+	}
+	return events, rows.Err()
+}
+
+// recordEvent appends an event to the log, and to the outbox when its type is
+// published. Failures are logged rather than returned so that event
+// recording never fails the user's request.
+func recordEvent(ctx context.Context, db execer, userID int, eventType, entityType string, entityID int, payload interface{}) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Error serializing %s event for %s %d: %v", eventType, entityType, entityID, err)
+		return
+	}
+
+	var owner *int
+	if userID != 0 {
+		owner = &userID
+	}
+
+	// Changes already made are recorded even when the client went away
+	_, err = db.Exec(context.WithoutCancel(ctx), `
+		WITH e AS (
+			INSERT INTO events (user_id, type, entity_type, entity_id, payload)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id, type
+		)
+		INSERT INTO event_outbox (event_id)
+		SELECT id FROM e WHERE type = ANY($6) OR '*' = ANY($6)
+	`, owner, eventType, entityType, entityID, payloadJSON, outboxEventTypes)
+	if err != nil {
+		log.Printf("Error recording %s event for %s %d: %v", eventType, entityType, entityID, err)
+	}
+}
+
+// invoiceEventSQL records an event of type $1 for the invoice with ID $3,
+// with $2 the invoice entity type and $4 the event types sent to the outbox
+const invoiceEventSQL = `
+	WITH e AS (
+		INSERT INTO events (user_id, type, entity_type, entity_id, payload)
+		SELECT user_id, $1, $2, id, ` + invoiceSnapshotSQL + `
+		FROM invoices WHERE id = $3
+		RETURNING id, type
+	)
+	INSERT INTO event_outbox (event_id)
+	SELECT id FROM e WHERE type = ANY($4) OR '*' = ANY($4)`
+
+// recordInvoiceEvent records an invoice event with a snapshot read from the
+// stored invoice, so the payload always reflects what was committed
+func recordInvoiceEvent(ctx context.Context, db execer, eventType string, invoiceID int) {
+	_, err := db.Exec(context.WithoutCancel(ctx), invoiceEventSQL,
+		eventType, entityInvoice, invoiceID, outboxEventTypes)
+	if err != nil {
+		log.Printf("Error recording %s event for %s %d: %v", eventType, entityInvoice, invoiceID, err)
+	}
+}
+
+// handleGetAuditLog returns the authenticated user's event log, newest first
+// by default, optionally for a single entity
+func handleGetAuditLog(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	page, message := parsePageRequest(c, eventSortFields, "-created_at")
+	if page == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	args := []interface{}{userID, c.Query("entity_type")}
+	entityFilter := ""
+	if value := c.Query("entity_id"); value != "" {
+		entityID, err := strconv.Atoi(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid entity ID"})
+			return
+		}
+		args = append(args, entityID)
+		entityFilter = " AND entity_id = $3"
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, type, entity_type, entity_id, payload, created_at, `+page.keySQL("")+`
+		FROM events
+		WHERE user_id = $1 AND ($2 = '' OR entity_type = $2)`+entityFilter+page.whereSQL("", &args)+page.orderSQL(""),
+		args...)
+	if err != nil {
+		log.Printf("Error fetching audit log: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch audit log"})
+		return
+	}
+	defer rows.Close()
+
+	events := make([]models.Event, 0)
+	for rows.Next() {
+		var e models.Event
+		var pageKey string
+		if err := rows.Scan(&e.ID, &e.Type, &e.EntityType, &e.EntityID, &e.Payload, &e.CreatedAt, &pageKey); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read audit log"})
+			return
+		}
+		if !page.next(pageKey, e.ID) {
+			break
+		}
+		events = append(events, e)
+	}
+
+	c.JSON(http.StatusOK, page.respond(gin.H{"events": events}))
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// pincodeDistancesCSV is the bundled matrix of approximate road distances
+// between major cities, loaded into the pincode_distances table. Set
+// PINCODE_DISTANCE_FILE to load a larger matrix instead.
+//
+//go:embed data/pincode_distances.csv
+var pincodeDistancesCSV []byte
+
+// Sources of a PIN-to-PIN distance
+const (
+	distanceSourceMatrix = "matrix"
+	distanceSourceAPI    = "api"
+)
+
+// ewbSamePincodeMaxKm is the longest distance the e-way bill system accepts
+// when goods are dispatched and delivered within one PIN code
+const ewbSamePincodeMaxKm = 100
+
+// errDistanceAPIDisabled is returned when no distance API is configured
+var errDistanceAPIDisabled = errors.New("distance API is not configured")
+
+// distanceHTTPClient is used for all calls to the distance API
+var distanceHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// createDistanceTables creates the PIN-to-PIN distance matrix and loads it.
+// Distances are symmetric and kept once per pair, with from_pin < to_pin.
+func createDistanceTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS pincode_distances (
+			from_pin INTEGER NOT NULL,
+			to_pin INTEGER NOT NULL,
+			distance_km INTEGER NOT NULL,
+			source VARCHAR(20) NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (from_pin, to_pin)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create pincode_distances table: %v", err)
+	}
+
+	if err := seedDistances(); err != nil {
+		log.Printf("Error seeding PIN code distances: %v", err)
+	}
+}
+
+// seedDistances loads the distance matrix from PINCODE_DISTANCE_FILE or the
+// bundled list at every start. The table also keeps the distances answered
+// by the distance API, so it is never empty after first use: a configured
+// file replaces those for the pairs it lists, while the bundled list only
+// updates distances it loaded before.
+func seedDistances() error {
+	data := pincodeDistancesCSV
+	table := masterTable{
+		Name:    "pincode_distances",
+		Key:     []string{"from_pin", "to_pin"},
+		Columns: []string{"from_pin", "to_pin", "distance_km", "source"},
+		Touch:   "updated_at = NOW()",
+		Only:    "t.source = '" + distanceSourceMatrix + "'",
+	}
+	if path := os.Getenv("PINCODE_DISTANCE_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read PINCODE_DISTANCE_FILE: %w", err)
+		}
+		data = fileData
+		table.Only = ""
+	}
+
+	pairs, err := parseDistanceCSV(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, 0, len(pairs))
+	for pair, km := range pairs {
+		rows = append(rows, []interface{}{pair[0], pair[1], km, distanceSourceMatrix})
+	}
+
+	loaded, err := upsertMasterRows(context.Background(), table, rows)
+	if err != nil {
+		return fmt.Errorf("failed to load PIN code distances: %w", err)
+	}
+
+	if loaded > 0 {
+		log.Printf("Loaded %d new or changed PIN code distances", loaded)
+	}
+	return nil
+}
+
+// parseDistanceCSV reads from_pincode,to_pincode,distance_km rows with a
+// header line into pairs ordered by PIN code
+func parseDistanceCSV(r io.Reader) (map[[2]int]int, error) {
+	reader := csv.NewReader(r)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid PIN code distance CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, errors.New("PIN code distance CSV has no data rows")
+	}
+
+	pairs := make(map[[2]int]int, len(records)-1)
+	for i, record := range records[1:] {
+		if len(record) < 3 {
+			return nil, fmt.Errorf("PIN code distance row %d does not have enough columns", i+2)
+		}
+		from, err1 := strconv.Atoi(strings.TrimSpace(record[0]))
+		to, err2 := strconv.Atoi(strings.TrimSpace(record[1]))
+		km, err3 := strconv.Atoi(strings.TrimSpace(record[2]))
+		if err1 != nil || err2 != nil || err3 != nil || !models.IsValidPincode(from) || !models.IsValidPincode(to) ||
+			from == to || km <= 0 {
+			return nil, fmt.Errorf("PIN code distance row %d is invalid", i+2)
+		}
+		pairs[distancePair(from, to)] = km
+	}
+	return pairs, nil
+}
+
+// distancePair orders two PIN codes as they are keyed in the matrix
+func distancePair(from, to int) [2]int {
+	if from > to {
+		from, to = to, from
+	}
+	return [2]int{from, to}
+}
+
+// findDistance returns the distance between two PIN codes from the matrix
+func findDistance(ctx context.Context, from, to int) (int, string, error) {
+	pair := distancePair(from, to)
+	var km int
+	var source string
+	err := dbPool.QueryRow(ctx,
+		"SELECT distance_km, source FROM pincode_distances WHERE from_pin = $1 AND to_pin = $2",
+		pair[0], pair[1]).Scan(&km, &source)
+	return km, source, err
+}
+
+// lookupDistance returns the distance between two PIN codes from the matrix,
+// asking the distance API for pairs missing from it and keeping the answer
+func lookupDistance(ctx context.Context, from, to int) (int, string, error) {
+	km, source, err := findDistance(ctx, from, to)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return km, source, err
+	}
+
+	km, err = fetchDistance(ctx, from, to)
+	if errors.Is(err, errDistanceAPIDisabled) {
+		return 0, "", pgx.ErrNoRows
+	}
+	if err != nil {
+		return 0, "", err
+	}
+
+	pair := distancePair(from, to)
+	_, err = dbPool.Exec(ctx, `
+		INSERT INTO pincode_distances (from_pin, to_pin, distance_km, source, updated_at)
+		VALUES ($1, $2, $3, $4, NOW())
+		ON CONFLICT (from_pin, to_pin) DO UPDATE
+		SET distance_km = $3, source = $4, updated_at = NOW()
+	`, pair[0], pair[1], km, distanceSourceAPI)
+	if err != nil {
+		log.Printf("Error caching distance %d-%d: %v", from, to, err)
+	}
+	return km, distanceSourceAPI, nil
+}
+
+// fetchDistance calls the configured distance API, such as a GSP's proxy of
+// the NIC PIN-to-PIN distance service. EWB_DISTANCE_API_URL must contain
+// {from} and {to} placeholders and answer with {"distance": <km>}.
+func fetchDistance(ctx context.Context, from, to int) (int, error) {
+	apiURL := os.Getenv("EWB_DISTANCE_API_URL")
+	if apiURL == "" {
+		return 0, errDistanceAPIDisabled
+	}
+	apiURL = strings.NewReplacer("{from}", strconv.Itoa(from), "{to}", strconv.Itoa(to)).Replace(apiURL)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if apiKey := os.Getenv("EWB_DISTANCE_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := distanceHTTPClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("distance API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return 0, pgx.ErrNoRows
+	}
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("distance API returned status %d", resp.StatusCode)
+	}
+
+	var apiResp struct {
+		Distance float64 `json:"distance"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&apiResp); err != nil {
+		return 0, fmt.Errorf("invalid distance API response: %w", err)
+	}
+	if apiResp.Distance <= 0 {
+		return 0, pgx.ErrNoRows
+	}
+	return int(apiResp.Distance + 0.5), nil
+}
+
+// ewbMaxDistance is the longest distance the e-way bill system accepts for
+// two PIN codes: their PIN-to-PIN distance plus 10%
+func ewbMaxDistance(km int) int {
+	return km + km/10
+}
+
+// ewbValidityDays is how many days an e-way bill for the distance stays
+// valid: a day per 200 km or part of it, or per 20 km for over-dimensional cargo
+func ewbValidityDays(km int, overDimensional bool) int {
+	perDay := 200
+	if overDimensional {
+		perDay = 20
+	}
+	if km <= 0 {
+		return 1
+	}
+	return (km + perDay - 1) / perDay
+}
+
+// ewbPincodes returns the PIN codes goods move between: the dispatch address
+// or else the seller's, and the shipping address or else the buyer's
+func ewbPincodes(invoice *models.EInvoice) (int, int) {
+	from, to := invoice.SellerDtls.Pin, invoice.BuyerDtls.Pin
+	if invoice.DispDtls != nil {
+		from = invoice.DispDtls.Pin
+	}
+	if invoice.ShipDtls != nil {
+		to = invoice.ShipDtls.Pin
+	}
+	return from, to
+}
+
+// checkEwbDistance fills a blank e-way bill distance from the distance
+// matrix and returns warnings for a distance the e-way bill system would
+// reject. Only the matrix is used, never the distance API, so saving and
+// integrity scans stay fast.
+func checkEwbDistance(ctx context.Context, invoice *models.EInvoice) []string {
+	ewb := invoice.EwbDtls
+	if ewb == nil {
+		return nil
+	}
+	from, to := ewbPincodes(invoice)
+	if from == models.ForeignPincode || to == models.ForeignPincode || from == 0 || to == 0 {
+		return nil
+	}
+	if from == to {
+		if ewb.Distance > ewbSamePincodeMaxKm {
+			return []string{fmt.Sprintf(
+				"e-way bill distance %d km exceeds the %d km allowed within PIN code %d",
+				ewb.Distance, ewbSamePincodeMaxKm, from)}
+		}
+		return nil
+	}
+
+	km, _, err := findDistance(ctx, from, to)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error looking up distance %d-%d: %v", from, to, err)
+		}
+		return nil
+	}
+	if ewb.Distance == 0 {
+		ewb.Distance = km
+		return nil
+	}
+	if ewb.Distance > ewbMaxDistance(km) {
+		return []string{fmt.Sprintf(
+			"e-way bill distance %d km is more than 10%% over the %d km from PIN code %d to %d; the e-way bill system accepts at most %d km",
+			ewb.Distance, km, from, to, ewbMaxDistance(km))}
+	}
+	return nil
+}
+
+// handleGetDistance returns the e-way bill distance between two PIN codes,
+// the most the e-way bill system accepts for them and how long an e-way bill
+// for the distance stays valid. vehicle_type=O is over-dimensional cargo.
+func handleGetDistance(c *gin.Context) {
+	from, err1 := strconv.Atoi(c.Query("from"))
+	to, err2 := strconv.Atoi(c.Query("to"))
+	if err1 != nil || err2 != nil || !models.IsValidPincode(from) || !models.IsValidPincode(to) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "from and to must be 6-digit PIN codes"})
+		return
+	}
+	overDimensional := strings.EqualFold(c.Query("vehicle_type"), "O")
+
+	if from == to {
+		c.JSON(http.StatusOK, gin.H{
+			"from_pincode":    from,
+			"to_pincode":      to,
+			"distance_km":     nil,
+			"max_distance_km": ewbSamePincodeMaxKm,
+			"validity_days":   ewbValidityDays(ewbSamePincodeMaxKm, overDimensional),
+		})
+		return
+	}
+
+	km, source, err := lookupDistance(c.Request.Context(), from, to)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Distance between these PIN codes is not known"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error looking up distance %d-%d: %v", from, to, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to look up distance"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"from_pincode":    from,
+		"to_pincode":      to,
+		"distance_km":     km,
+		"max_distance_km": ewbMaxDistance(km),
+		"validity_days":   ewbValidityDays(km, overDimensional),
+		"source":          source,
+	})
+}
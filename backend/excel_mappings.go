@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+// excelTemplateLayout lists the fields in the column order of the downloadable
+// template, which is also the layout read when no mapping profile is given
+var excelTemplateLayout = []string{
+	"invoice_no", "invoice_date",
+	"seller_gstin", "seller_legal_name", "seller_trade_name", "seller_address1", "seller_address2",
+	"seller_location", "seller_pin", "seller_state", "seller_phone", "seller_email",
+	"buyer_gstin", "buyer_legal_name", "buyer_trade_name", "buyer_address", "buyer_address2",
+	"buyer_location", "buyer_pin", "buyer_state_code", "buyer_phone", "buyer_email",
+	"item_no", "item_description", "hsn_code", "quantity", "unit",
+	"unit_price", "gst_rate", "is_service",
+	"remarks", "po_number", "po_date",
+}
+
+// defaultExcelMapping maps the template layout by column letter
+var defaultExcelMapping = newTemplateExcelMapping()
+
+// newTemplateExcelMapping builds the mapping for the template layout
+func newTemplateExcelMapping() *models.ExcelMapping {
+	columns := make(map[string]models.ColumnRef, len(excelTemplateLayout))
+	for i, field := range excelTemplateLayout {
+		name, _ := excelize.ColumnNumberToName(i + 1)
+		columns[field] = models.ColumnRef{Column: name}
+	}
+	return &models.ExcelMapping{Name: "Default", HeaderRow: 1, Columns: columns}
+}
+
+// excelTemplateHeaders returns the header row of the template, labelling each column by its field
+func excelTemplateHeaders() []string {
+	labels := make(map[string]string, len(models.ExcelUploadFields))
+	for _, f := range models.ExcelUploadFields {
+		labels[f.Name] = f.Label
+	}
+	headers := make([]string, len(excelTemplateLayout))
+	for i, field := range excelTemplateLayout {
+		headers[i] = labels[field]
+	}
+	return headers
+}
+
+// createExcelMappingTables creates the Excel column mapping profiles table
+func createExcelMappingTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS excel_mappings (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			name VARCHAR(100) NOT NULL,
+			sheet VARCHAR(100) NOT NULL DEFAULT '',
+			header_row INTEGER NOT NULL DEFAULT 1,
+			columns JSONB NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, name)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create excel_mappings table: %v", err)
+	}
+}
+
+// excelMappingColumns is the column list used when reading mapping profiles
+const excelMappingColumns = "id, user_id, name, sheet, header_row, columns, created_at, updated_at"
+
+// scanExcelMapping reads a mapping profile row selected with excelMappingColumns
+func scanExcelMapping(row pgx.Row) (*models.ExcelMapping, error) {
+	var m models.ExcelMapping
+	var columns []byte
+	err := row.Scan(&m.ID, &m.UserID, &m.Name, &m.Sheet, &m.HeaderRow, &columns, &m.CreatedAt, &m.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(columns, &m.Columns); err != nil {
+		return nil, fmt.Errorf("invalid stored columns: %w", err)
+	}
+	return &m, nil
+}
+
+// getExcelMapping returns the user's mapping profile, or the template layout when id is empty
+func getExcelMapping(ctx context.Context, userID int, id string) (*models.ExcelMapping, error) {
+	if id == "" {
+		return defaultExcelMapping, nil
+	}
+	mappingID, err := strconv.Atoi(id)
+	if err != nil {
+		return nil, pgx.ErrNoRows
+	}
+	return scanExcelMapping(dbPool.QueryRow(ctx,
+		"SELECT "+excelMappingColumns+" FROM excel_mappings WHERE id = $1 AND user_id = $2",
+		mappingID, userID))
+}
+
+// resolveExcelColumns turns a mapping into zero-based column indexes,
+// matching header references case-insensitively against the header row
+func resolveExcelColumns(m *models.ExcelMapping, header []string) (map[string]int, error) {
+	headerIndex := make(map[string]int, len(header))
+	for i, h := range header {
+		key := strings.ToLower(strings.TrimSpace(h))
+		if _, dup := headerIndex[key]; !dup && key != "" {
+			headerIndex[key] = i
+		}
+	}
+
+	columns := make(map[string]int, len(m.Columns))
+	for field, ref := range m.Columns {
+		if ref.Column != "" {
+			n, err := excelize.ColumnNameToNumber(strings.ToUpper(ref.Column))
+			if err != nil {
+				return nil, fmt.Errorf("field %s: %v", field, err)
+			}
+			columns[field] = n - 1
+			continue
+		}
+		i, ok := headerIndex[strings.ToLower(strings.TrimSpace(ref.Header))]
+		if !ok {
+			return nil, fmt.Errorf("column %q mapped to %s was not found in the header row", ref.Header, field)
+		}
+		columns[field] = i
+	}
+	return columns, nil
+}
+
+// isNumericUploadField reports whether a field is parsed as a number,
+// which reports its own missing-value errors
+func isNumericUploadField(name string) bool {
+	switch name {
+	case "quantity", "unit_price", "gst_rate":
+		return true
+	}
+	return false
+}
+
+// excelRow reads mapped fields from a spreadsheet row
+type excelRow struct {
+	cells   []string
+	columns map[string]int
+}
+
+// get returns the trimmed value of a field, or "" when unmapped or beyond the row
+func (r excelRow) get(field string) string {
+	col, ok := r.columns[field]
+	if !ok || col >= len(r.cells) {
+		return ""
+	}
+	return strings.TrimSpace(r.cells[col])
+}
+
+// column returns the index of a field's column, or -1 when unmapped
+func (r excelRow) column(field string) int {
+	if col, ok := r.columns[field]; ok {
+		return col
+	}
+	return -1
+}
+
+// firstNonEmpty returns the first of values that is not empty
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// uploadInvoiceHeader builds an invoice's document, seller and buyer details
+// from the first row of the invoice. Blank seller details are filled from the
+// user's company with the same GSTIN, or from the default company when the row
+// has no seller GSTIN. The date format follows the user's settings, as do the
+// supply type and reverse charge flag unless the row gives them, and remarks and
+// the purchase order reference are kept when given. Problems are
+// recorded on the report, in which case nil is returned.
+func uploadInvoiceHeader(ctx context.Context, report *uploadReport, row excelRow, rowNum, userID int,
+	settings *models.UserSettings, defaultCompany *models.CompanyDetails) *models.EInvoice {
+	invoiceNo := row.get("invoice_no")
+	ok := true
+	fail := func(field, message string) {
+		report.add(rowNum, invoiceNo, row.column(field), message)
+		ok = false
+	}
+	pin := func(field string, fallback int) int {
+		value := row.get(field)
+		if value == "" {
+			return fallback
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			fail(field, fmt.Sprintf("%q is not a valid PIN code", value))
+		}
+		return n
+	}
+	state := func(field, fallback string) string {
+		value := row.get(field)
+		if value == "" {
+			return fallback
+		}
+		code, found := resolveStateCode(value)
+		if !found {
+			fail(field, fmt.Sprintf("%q is not a known state name or code", value))
+		}
+		return code
+	}
+
+	sellerGSTIN := row.get("seller_gstin")
+	company := &models.CompanyDetails{}
+	if sellerGSTIN == "" && defaultCompany != nil {
+		sellerGSTIN = defaultCompany.GSTIN
+		company = defaultCompany
+	} else if co, err := getCompanyByGSTIN(ctx, userID, sellerGSTIN); err == nil {
+		company = co
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error loading company %s for upload: %v", sellerGSTIN, err)
+	}
+
+	// A GSTIN starts with the state code of its registration
+	sellerState := ""
+	if code, found := resolveStateCode(company.State); found {
+		sellerState = code
+	} else if len(sellerGSTIN) >= 2 {
+		sellerState = sellerGSTIN[:2]
+	}
+
+	// A blank location, and a blank buyer state, are filled from the PIN code
+	sellerPin := pin("seller_pin", company.Pincode)
+	buyerPin := pin("buyer_pin", models.ForeignPincode)
+	sellerPlace, buyerPlace := pincodePlace(ctx, sellerPin), pincodePlace(ctx, buyerPin)
+
+	sellerLegalName := firstNonEmpty(row.get("seller_legal_name"), company.Name)
+	if sellerLegalName == "" {
+		fail("seller_legal_name", "seller legal name is required unless a company with this GSTIN is saved")
+	}
+	seller := models.SellerDtls{
+		Gstin: sellerGSTIN,
+		LglNm: sellerLegalName,
+		TrdNm: firstNonEmpty(row.get("seller_trade_name"), sellerLegalName),
+		Addr1: firstNonEmpty(row.get("seller_address1"), company.Address),
+		Addr2: row.get("seller_address2"),
+		Loc:   firstNonEmpty(row.get("seller_location"), company.City, sellerPlace.City),
+		Pin:   sellerPin,
+		Stcd:  state("seller_state", sellerState),
+		Ph:    firstNonEmpty(row.get("seller_phone"), company.Phone),
+		Em:    firstNonEmpty(row.get("seller_email"), company.Email),
+	}
+
+	buyerState := state("buyer_state_code", firstNonEmpty(buyerPlace.StateCode, models.ForeignStateCode))
+	buyer := models.BuyerDtls{
+		Gstin: row.get("buyer_gstin"),
+		LglNm: row.get("buyer_legal_name"),
+		TrdNm: firstNonEmpty(row.get("buyer_trade_name"), row.get("buyer_legal_name")),
+		Pos:   state("place_of_supply", buyerState),
+		Addr1: row.get("buyer_address"),
+		Addr2: row.get("buyer_address2"),
+		Loc:   firstNonEmpty(row.get("buyer_location"), buyerPlace.City),
+		Pin:   buyerPin,
+		Stcd:  buyerState,
+		Ph:    row.get("buyer_phone"),
+		Em:    row.get("buyer_email"),
+	}
+
+	date, err := settings.NormalizeDate(row.get("invoice_date"))
+	if err != nil {
+		fail("invoice_date", err.Error())
+	}
+
+	supplyType := settings.SupplyType
+	if value := strings.ToUpper(row.get("supply_type")); value != "" {
+		if !models.IsValidSupplyType(value) {
+			fail("supply_type", fmt.Sprintf("%q is not one of %s", row.get("supply_type"), strings.Join(models.SupplyTypes, ", ")))
+		}
+		supplyType = value
+	}
+
+	reverseCharge := settings.ReverseCharge()
+	if value := strings.ToUpper(row.get("reverse_charge")); value != "" {
+		if value != "Y" && value != "N" {
+			fail("reverse_charge", fmt.Sprintf("%q is not Y or N", row.get("reverse_charge")))
+		}
+		reverseCharge = value
+	}
+
+	// Remarks and the buyer's purchase order go in the reference details
+	var refs *models.RefDtls
+	remarks, poNumber := row.get("remarks"), row.get("po_number")
+	if remarks != "" || poNumber != "" {
+		refs = &models.RefDtls{InvRm: remarks}
+	}
+	if poNumber != "" {
+		contract := models.ContrDtls{PORefr: poNumber}
+		if value := row.get("po_date"); value != "" {
+			poDate, err := settings.NormalizeDate(value)
+			if err != nil {
+				fail("po_date", err.Error())
+			}
+			contract.PORefDt = poDate
+		}
+		refs.ContrDtls = []models.ContrDtls{contract}
+	} else if row.get("po_date") != "" {
+		fail("po_date", "PO date requires a PO number")
+	}
+
+	if !ok {
+		return nil
+	}
+	return &models.EInvoice{
+		Version: "1.1",
+		TranDtls: models.TranDtls{
+			TaxSch: "GST",
+			SupTyp: supplyType,
+			RegRev: reverseCharge,
+		},
+		DocDtls: models.DocDtls{
+			Typ: "INV",
+			No:  invoiceNo,
+			Dt:  date,
+		},
+		SellerDtls: seller,
+		BuyerDtls:  buyer,
+		RefDtls:    refs,
+		ExpDtls: models.ExpDtls{
+			ForCur:  nil,
+			CntCode: nil,
+		},
+	}
+}
+
+// bindExcelMapping parses and validates a mapping profile from the request body
+func bindExcelMapping(c *gin.Context) (*models.ExcelMapping, bool) {
+	var m models.ExcelMapping
+	if err := c.ShouldBindJSON(&m); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping data: " + err.Error()})
+		return nil, false
+	}
+	m.Name = strings.TrimSpace(m.Name)
+	if m.HeaderRow == 0 {
+		m.HeaderRow = 1
+	}
+	if err := m.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &m, true
+}
+
+// handleGetExcelMappings returns the user's mapping profiles and the fields they can map
+func handleGetExcelMappings(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT "+excelMappingColumns+" FROM excel_mappings WHERE user_id = $1 ORDER BY name", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch mappings"})
+		return
+	}
+	defer rows.Close()
+
+	mappings := make([]*models.ExcelMapping, 0)
+	for rows.Next() {
+		m, err := scanExcelMapping(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read mapping data"})
+			return
+		}
+		mappings = append(mappings, m)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mappings": mappings,
+		"fields":   models.ExcelUploadFields,
+		"default":  defaultExcelMapping,
+	})
+}
+
+// handleCreateExcelMapping saves a new mapping profile
+func handleCreateExcelMapping(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	m, ok := bindExcelMapping(c)
+	if !ok {
+		return
+	}
+	columns, err := json.Marshal(m.Columns)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize mapping"})
+		return
+	}
+
+	var id int
+	err = dbPool.QueryRow(c.Request.Context(), `
+		INSERT INTO excel_mappings (user_id, name, sheet, header_row, columns)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, name) DO NOTHING
+		RETURNING id
+	`, userID, m.Name, m.Sheet, m.HeaderRow, columns).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A mapping with this name already exists"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create mapping: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Mapping created successfully",
+		"mapping_id": id,
+	})
+}
+
+// handleUpdateExcelMapping replaces an existing mapping profile
+func handleUpdateExcelMapping(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping ID"})
+		return
+	}
+
+	m, ok := bindExcelMapping(c)
+	if !ok {
+		return
+	}
+	columns, err := json.Marshal(m.Columns)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize mapping"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE excel_mappings
+		SET name = $1, sheet = $2, header_row = $3, columns = $4, updated_at = NOW()
+		WHERE id = $5 AND user_id = $6
+	`, m.Name, m.Sheet, m.HeaderRow, columns, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update mapping: " + err.Error()})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Mapping updated successfully",
+		"mapping_id": id,
+	})
+}
+
+// handleDeleteExcelMapping deletes a mapping profile
+func handleDeleteExcelMapping(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid mapping ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM excel_mappings WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete mapping"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Mapping deleted successfully",
+		"mapping_id": id,
+	})
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// exchangeRateMaxAge is how far before an invoice date the latest published
+// rate may be; sources publish no rates on weekends and holidays
+const exchangeRateMaxAge = 7 * 24 * time.Hour
+
+// exchangeRateHTTPClient is used to download published exchange rates
+var exchangeRateHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// exchangeRate is the rupee value of one unit of a currency on a day
+type exchangeRate struct {
+	currency string
+	date     time.Time
+	rate     float64
+}
+
+// exchangeRateSource returns the source set by FX_RATE_SOURCE, rbi or ecb;
+// ECB is the default as it publishes a machine-readable feed
+func exchangeRateSource() string {
+	source := strings.ToLower(strings.TrimSpace(os.Getenv("FX_RATE_SOURCE")))
+	if source == "" {
+		return models.FxSourceECB
+	}
+	return source
+}
+
+// fetchExchangeRates downloads the recent rates published by a source
+func fetchExchangeRates(ctx context.Context, source string) ([]exchangeRate, error) {
+	switch source {
+	case models.FxSourceECB:
+		return fetchECBRates(ctx)
+	case models.FxSourceRBI:
+		return fetchRBIRates(ctx)
+	}
+	return nil, fmt.Errorf("unknown FX_RATE_SOURCE %q; use rbi or ecb", source)
+}
+
+// getExchangeRateFeed downloads a rate feed
+func getExchangeRateFeed(ctx context.Context, feedURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, feedURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := exchangeRateHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download exchange rates: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("exchange rate feed returned HTTP %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+}
+
+// fetchECBRates reads the ECB euro reference rates of the last 90 days from
+// ECB_RATES_URL. The ECB quotes currencies per euro, so rupee rates are
+// derived through the euro rate of the rupee.
+func fetchECBRates(ctx context.Context) ([]exchangeRate, error) {
+	body, err := getExchangeRateFeed(ctx,
+		getEnvWithDefault("ECB_RATES_URL", "https://www.ecb.europa.eu/stats/eurofxref/eurofxref-hist-90d.xml"))
+	if err != nil {
+		return nil, err
+	}
+
+	var feed struct {
+		Days []struct {
+			Time  string `xml:"time,attr"`
+			Rates []struct {
+				Currency string  `xml:"currency,attr"`
+				Rate     float64 `xml:"rate,attr"`
+			} `xml:"Cube"`
+		} `xml:"Cube>Cube"`
+	}
+	if err := xml.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("invalid ECB feed: %w", err)
+	}
+
+	rates := make([]exchangeRate, 0)
+	for _, day := range feed.Days {
+		date, err := time.Parse(exportFilterLayout, day.Time)
+		if err != nil {
+			continue
+		}
+		var inrPerEUR float64
+		for _, r := range day.Rates {
+			if r.Currency == "INR" {
+				inrPerEUR = r.Rate
+			}
+		}
+		if inrPerEUR <= 0 {
+			continue
+		}
+		rates = append(rates, exchangeRate{currency: "EUR", date: date, rate: inrPerEUR})
+		for _, r := range day.Rates {
+			if r.Currency == "INR" || r.Rate <= 0 {
+				continue
+			}
+			rates = append(rates, exchangeRate{currency: r.Currency, date: date, rate: inrPerEUR / r.Rate})
+		}
+	}
+	return rates, nil
+}
+
+// fetchRBIRates reads RBI reference rates from RBI_RATES_URL. The RBI does
+// not offer an API, so the URL must serve the rates as a JSON array of
+// {"date": "YYYY-MM-DD", "currency": "USD", "rate": 83.2125}, such as a
+// mirror of the published reference rates.
+func fetchRBIRates(ctx context.Context) ([]exchangeRate, error) {
+	feedURL := os.Getenv("RBI_RATES_URL")
+	if feedURL == "" {
+		return nil, errors.New("RBI_RATES_URL is required for RBI reference rates")
+	}
+	body, err := getExchangeRateFeed(ctx, feedURL)
+	if err != nil {
+		return nil, err
+	}
+
+	var feed []struct {
+		Date     string  `json:"date"`
+		Currency string  `json:"currency"`
+		Rate     float64 `json:"rate"`
+	}
+	if err := json.Unmarshal(body, &feed); err != nil {
+		return nil, fmt.Errorf("invalid RBI rate feed: %w", err)
+	}
+	rates := make([]exchangeRate, 0, len(feed))
+	for _, r := range feed {
+		date, err := time.Parse(exportFilterLayout, r.Date)
+		if err != nil || r.Rate <= 0 {
+			continue
+		}
+		rates = append(rates, exchangeRate{currency: strings.ToUpper(r.Currency), date: date, rate: r.Rate})
+	}
+	return rates, nil
+}
+
+// createExchangeRateTables creates the cache of published exchange rates
+func createExchangeRateTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS exchange_rates (
+			source VARCHAR(10) NOT NULL,
+			currency VARCHAR(3) NOT NULL,
+			rate_date DATE NOT NULL,
+			rate NUMERIC(14,4) NOT NULL,
+			fetched_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (source, currency, rate_date)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create exchange_rates table: %v", err)
+	}
+}
+
+// refreshExchangeRates downloads the configured source's recent rates into
+// the cache and returns how many it stored
+func refreshExchangeRates(ctx context.Context) (int, error) {
+	source := exchangeRateSource()
+	rates, err := fetchExchangeRates(ctx, source)
+	if err != nil {
+		return 0, err
+	}
+
+	batch := &pgx.Batch{}
+	for _, r := range rates {
+		batch.Queue(`
+			INSERT INTO exchange_rates (source, currency, rate_date, rate)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (source, currency, rate_date) DO UPDATE SET rate = $4, fetched_at = NOW()
+		`, source, r.currency, r.date, models.Round(r.rate, models.ExchangeRateDecimals))
+	}
+	if err := dbPool.SendBatch(ctx, batch).Close(); err != nil {
+		return 0, err
+	}
+	return len(rates), nil
+}
+
+// startExchangeRateUpdater refreshes the cached rates every six hours until
+// the context is cancelled
+func startExchangeRateUpdater(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(6 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			if _, err := refreshExchangeRates(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error refreshing exchange rates: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// lookupExchangeRate returns the latest cached rate of a currency published
+// on or before date, within exchangeRateMaxAge, or pgx.ErrNoRows
+func lookupExchangeRate(ctx context.Context, source, currency string, date time.Time) (float64, time.Time, error) {
+	var rate float64
+	var rateDate time.Time
+	err := dbPool.QueryRow(ctx, `
+		SELECT rate::float8, rate_date FROM exchange_rates
+		WHERE source = $1 AND currency = $2 AND rate_date <= $3 AND rate_date >= $4
+		ORDER BY rate_date DESC
+		LIMIT 1
+	`, source, currency, date, date.Add(-exchangeRateMaxAge)).Scan(&rate, &rateDate)
+	return rate, rateDate, err
+}
+
+// exchangeRateFor returns the rate of a currency for a date, downloading
+// the source's rates when the cache has none
+func exchangeRateFor(ctx context.Context, currency string, date time.Time) (float64, time.Time, error) {
+	source := exchangeRateSource()
+	rate, rateDate, err := lookupExchangeRate(ctx, source, currency, date)
+	if errors.Is(err, pgx.ErrNoRows) {
+		if _, err := refreshExchangeRates(ctx); err != nil {
+			return 0, time.Time{}, err
+		}
+		rate, rateDate, err = lookupExchangeRate(ctx, source, currency, date)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, time.Time{}, fmt.Errorf("no %s exchange rate from %s for %s",
+			currency, strings.ToUpper(source), date.Format(invoiceDateLayout))
+	}
+	return rate, rateDate, err
+}
+
+// resolveExchangeRate sets the exchange rate of an invoice priced in a
+// foreign currency to the configured source's rate for the invoice date, and
+// converts its line prices to rupees. Rates given on the invoice are kept.
+func resolveExchangeRate(ctx context.Context, invoice *models.EInvoice, p models.Precision) error {
+	fx := invoice.FxDtls
+	if fx == nil {
+		return nil
+	}
+	fx.NormalizeCurrency()
+	if fx.Source != models.FxSourceManual {
+		date := time.Now().In(istLocation)
+		if invoice.DocDtls.Dt != "" {
+			d, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+			if err != nil {
+				return fmt.Errorf("invoice date must be in DD/MM/YYYY format")
+			}
+			date = d
+		}
+		rate, rateDate, err := exchangeRateFor(ctx, fx.Currency, date)
+		if err != nil {
+			return err
+		}
+		fx.Rate = models.Round(rate, models.ExchangeRateDecimals)
+		fx.RateDt = rateDate.Format(invoiceDateLayout)
+		fx.Source = exchangeRateSource()
+	}
+	invoice.ApplyExchangeRate(p)
+	return nil
+}
+
+// handleGetExchangeRate returns the rate an invoice in a currency dated
+// date (DD/MM/YYYY, default today) would be converted at
+func handleGetExchangeRate(c *gin.Context) {
+	currency := strings.ToUpper(strings.TrimSpace(c.Query("currency")))
+	if len(currency) != 3 || currency == "INR" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "currency must be a three-letter currency code other than INR"})
+		return
+	}
+	date := time.Now().In(istLocation)
+	if value := c.Query("date"); value != "" {
+		d, err := time.Parse(invoiceDateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be in DD/MM/YYYY format"})
+			return
+		}
+		date = d
+	}
+
+	rate, rateDate, err := exchangeRateFor(c.Request.Context(), currency, date)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"currency":  currency,
+		"rate":      models.Round(rate, models.ExchangeRateDecimals),
+		"rate_date": rateDate.Format(invoiceDateLayout),
+		"source":    exchangeRateSource(),
+	})
+}
+
+// handleAdminRefreshExchangeRates downloads the latest rates now
+func handleAdminRefreshExchangeRates(c *gin.Context) {
+	n, err := refreshExchangeRates(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to refresh exchange rates: " + err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Exchange rates refreshed",
+		"source":  exchangeRateSource(),
+		"rates":   n,
+	})
+}
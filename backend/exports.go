@@ -0,0 +1,569 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/xuri/excelize/v2"
+)
+
+// invoiceDateLayout is the DD/MM/YYYY format of invoice document dates
+const invoiceDateLayout = "02/01/2006"
+
+// exportFilterLayout is the YYYY-MM-DD format of the from and to query parameters
+const exportFilterLayout = "2006-01-02"
+
+// exportFilterSQL narrows an export query by seller, exported status, buyer,
+// invoice date and tags, keeping invoices carrying every tag given; it
+// expects the arguments returned by exportFilter.args as $3 to $8
+const exportFilterSQL = `($3 = '' OR seller_gstin = $3)
+	AND ($4::boolean IS NULL OR exported = $4)
+	AND ($5 = '' OR buyer_gstin = UPPER($5)
+		OR invoice_json->'BuyerDtls'->>'LglNm' ILIKE '%' || $5 || '%')
+	AND ($6::date IS NULL OR invoice_date >= $6)
+	AND ($7::date IS NULL OR invoice_date <= $7)
+	AND (COALESCE(cardinality($8::text[]), 0) = 0 OR invoices.id IN (
+		SELECT it.invoice_id FROM invoice_tags it JOIN tags t ON t.id = it.tag_id
+		WHERE LOWER(t.name) = ANY($8::text[])
+		GROUP BY it.invoice_id HAVING COUNT(*) = cardinality($8::text[])))`
+
+// exportFilter narrows an invoice export by period, seller, buyer, exported status and tags
+type exportFilter struct {
+	from        *time.Time
+	to          *time.Time
+	sellerGSTIN string
+	buyer       string
+	exported    *bool
+	// tags are lowercase tag names the invoices must all carry
+	tags []string
+}
+
+// parseExportFilter reads the from, to, seller_gstin, buyer, exported and tag query parameters
+func parseExportFilter(c *gin.Context) (*exportFilter, error) {
+	f := &exportFilter{
+		sellerGSTIN: strings.ToUpper(strings.TrimSpace(c.Query("seller_gstin"))),
+		buyer:       strings.TrimSpace(c.Query("buyer")),
+		tags:        parseTagFilter(c),
+	}
+
+	for _, p := range []struct {
+		name string
+		dst  **time.Time
+	}{{"from", &f.from}, {"to", &f.to}} {
+		value := c.Query(p.name)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(exportFilterLayout, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s must be a date in YYYY-MM-DD format", p.name)
+		}
+		*p.dst = &t
+	}
+	if f.from != nil && f.to != nil && f.to.Before(*f.from) {
+		return nil, fmt.Errorf("to must not be before from")
+	}
+
+	// A financial year such as 2024-25 stands for 1 April 2024 to 31 March 2025
+	if value := c.Query("fy"); value != "" {
+		if f.from != nil || f.to != nil {
+			return nil, fmt.Errorf("fy cannot be combined with from or to")
+		}
+		fy, err := models.ParseFiscalYear(value)
+		if err != nil {
+			return nil, err
+		}
+		start, end := fy.Start(), fy.End()
+		f.from, f.to = &start, &end
+	}
+
+	switch c.Query("exported") {
+	case "":
+	case "true":
+		exported := true
+		f.exported = &exported
+	case "false":
+		exported := false
+		f.exported = &exported
+	default:
+		return nil, fmt.Errorf("exported must be true or false")
+	}
+
+	return f, nil
+}
+
+// args returns the query arguments for exportFilterSQL
+func (f *exportFilter) args() []interface{} {
+	return []interface{}{f.sellerGSTIN, f.exported, f.buyer, f.from, f.to, f.tags}
+}
+
+// matchesDate reports whether an invoice date falls within the period.
+// Invoices with unreadable dates are left out whenever a period is set.
+func (f *exportFilter) matchesDate(date string) bool {
+	if f.from == nil && f.to == nil {
+		return true
+	}
+	t, err := time.Parse(invoiceDateLayout, date)
+	if err != nil {
+		return false
+	}
+	if f.from != nil && t.Before(*f.from) {
+		return false
+	}
+	if f.to != nil && t.After(*f.to) {
+		return false
+	}
+	return true
+}
+
+// filename names a download after the selected period, e.g.
+// invoices_2024-04-01_to_2024-06-30.xlsx
+func (f *exportFilter) filename(base, ext string) string {
+	switch {
+	case f.from != nil && f.to != nil:
+		return fmt.Sprintf("%s_%s_to_%s.%s", base, f.from.Format(exportFilterLayout), f.to.Format(exportFilterLayout), ext)
+	case f.from != nil:
+		return fmt.Sprintf("%s_from_%s.%s", base, f.from.Format(exportFilterLayout), ext)
+	case f.to != nil:
+		return fmt.Sprintf("%s_to_%s.%s", base, f.to.Format(exportFilterLayout), ext)
+	}
+	return base + "." + ext
+}
+
+// exportHeaders are the column headers of Excel invoice exports
+var exportHeaders = []string{
+	"GSTIN", "Invoice No", "Invoice Date", "Buyer GSTIN", "Buyer Name",
+	"Item Description", "HSN Code", "Quantity", "Unit", "Unit Price",
+	"GST Rate", "IGST Amount", "CGST Amount", "SGST Amount", "Total Amount", "Reverse Charge", "Status", "Tags",
+}
+
+// maxSelectedExport is the most invoices that can be picked for one export
+const maxSelectedExport = 1000
+
+// invoiceWorkbook writes invoices to an Excel export, one row per line item,
+// rounding values to each seller's configured precision and rounding rule
+type invoiceWorkbook struct {
+	ctx        context.Context
+	file       *excelize.File
+	userID     int
+	row        int
+	precisions map[string]models.Precision
+}
+
+// newInvoiceWorkbook creates an export workbook with its header row
+func newInvoiceWorkbook(ctx context.Context, userID int) *invoiceWorkbook {
+	f := excelize.NewFile()
+	for i, header := range exportHeaders {
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue("Sheet1", cell, header)
+	}
+	return &invoiceWorkbook{
+		ctx:        ctx,
+		file:       f,
+		userID:     userID,
+		row:        2,
+		precisions: make(map[string]models.Precision),
+	}
+}
+
+// add writes a row for each line item of the invoice, with its tags as a
+// comma-separated list
+func (w *invoiceWorkbook) add(invoice *models.EInvoice, status string, sandbox bool, tags string) {
+	precision, ok := w.precisions[invoice.SellerDtls.Gstin]
+	if !ok {
+		precision = getCompanyPrecision(w.ctx, w.userID, invoice.SellerDtls.Gstin)
+		w.precisions[invoice.SellerDtls.Gstin] = precision
+	}
+
+	// Drafts are recalculated on finalization, so export them with the totals
+	// the seller's current rounding rule gives; issued invoices keep their amounts
+	if status == models.InvoiceStatusDraft || status == models.InvoiceStatusPendingApproval {
+		invoice.CalculateTotalsWithPrecision(precision)
+	}
+
+	// Mark test data so it cannot be mistaken for real filings
+	if sandbox {
+		status += " (sandbox)"
+	}
+
+	reverseCharge := "N"
+	if invoice.IsReverseCharge() {
+		reverseCharge = "Y"
+	}
+
+	for _, item := range invoice.ItemList {
+		igst, cgst, sgst := invoice.ItemTax(&item)
+		values := []interface{}{
+			invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoice.DocDtls.Dt,
+			invoice.BuyerDtls.Gstin, invoice.BuyerDtls.LglNm,
+			item.PrdDesc, item.HsnCd, models.Round(item.Qty, precision.Quantity), item.Unit,
+			models.Round(item.UnitPrice, precision.UnitPrice), item.GstRt,
+			models.Round(igst, models.AmountDecimals), models.Round(cgst, models.AmountDecimals),
+			models.Round(sgst, models.AmountDecimals),
+			models.Round(item.TotItemVal, models.AmountDecimals), reverseCharge, status, tags,
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, w.row)
+		w.file.SetSheetRow("Sheet1", cell, &values)
+		w.row++
+	}
+}
+
+// selectedInvoice is an invoice picked for a selective export
+type selectedInvoice struct {
+	id        int
+	invoiceNo string
+	json      []byte
+	status    string
+	sandbox   bool
+	tags      string
+}
+
+// handleExportSelectedInvoices exports exactly the invoices listed in the
+// request body as an Excel file, a JSON array, or a zip holding one JSON file
+// per invoice together with the Excel summary
+func handleExportSelectedInvoices(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	var req struct {
+		InvoiceIDs []int  `json:"invoice_ids" binding:"required"`
+		Format     string `json:"format"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invoice_ids is required"})
+		return
+	}
+	format := strings.ToLower(firstNonEmpty(c.Query("format"), req.Format, "xlsx"))
+	if format != "xlsx" && format != "json" && format != "zip" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be xlsx, json or zip"})
+		return
+	}
+	if len(req.InvoiceIDs) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No invoices selected"})
+		return
+	}
+	if len(req.InvoiceIDs) > maxSelectedExport {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("At most %d invoices can be exported at once", maxSelectedExport)})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, invoice_no, invoice_json, status, sandbox, `+invoiceTagListSQL+`
+		FROM invoices
+		WHERE user_id = $1 AND id = ANY($2)
+	`, userID, req.InvoiceIDs)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	found := make(map[int]*selectedInvoice, len(req.InvoiceIDs))
+	for rows.Next() {
+		var inv selectedInvoice
+		if err := rows.Scan(&inv.id, &inv.invoiceNo, &inv.json, &inv.status, &inv.sandbox, &inv.tags); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+			return
+		}
+		found[inv.id] = &inv
+	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
+		return
+	}
+
+	// Keep the order the invoices were picked in, dropping repeated IDs
+	selected := make([]*selectedInvoice, 0, len(found))
+	missing := make([]int, 0)
+	seen := make(map[int]bool, len(req.InvoiceIDs))
+	for _, id := range req.InvoiceIDs {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if inv, ok := found[id]; ok {
+			selected = append(selected, inv)
+		} else {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) > 0 {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error":       "Some invoices were not found or not authorized",
+			"invoice_ids": missing,
+		})
+		return
+	}
+
+	filename := fmt.Sprintf("invoices-selected-%s", time.Now().Format(exportFilterLayout))
+	switch format {
+	case "json":
+		invoices := make([]json.RawMessage, len(selected))
+		for i, inv := range selected {
+			invoices[i] = inv.json
+		}
+		result, err := json.Marshal(invoices)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoices"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.json", filename))
+		c.Data(http.StatusOK, "application/json", result)
+
+	case "xlsx":
+		wb, err := selectedWorkbook(ctx, userID, selected)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		defer wb.file.Close()
+		buf, err := wb.file.WriteToBuffer()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate Excel file"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.xlsx", filename))
+		c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+
+	case "zip":
+		wb, err := selectedWorkbook(ctx, userID, selected)
+		if err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return
+		}
+		defer wb.file.Close()
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, inv := range selected {
+			var pretty bytes.Buffer
+			if err := json.Indent(&pretty, inv.json, "", "  "); err != nil {
+				pretty.Reset()
+				pretty.Write(inv.json)
+			}
+			name := fmt.Sprintf("invoice-%s.json", strings.ReplaceAll(inv.invoiceNo, "/", "-"))
+			w, err := zw.Create(name)
+			if err == nil {
+				_, err = w.Write(pretty.Bytes())
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build zip file"})
+				return
+			}
+		}
+		w, err := zw.Create("invoices.xlsx")
+		if err == nil {
+			err = wb.file.Write(w)
+		}
+		if err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build zip file"})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.zip", filename))
+		c.Data(http.StatusOK, "application/zip", buf.Bytes())
+	}
+}
+
+// selectedWorkbook builds the Excel export of the selected invoices
+func selectedWorkbook(ctx context.Context, userID int, selected []*selectedInvoice) (*invoiceWorkbook, error) {
+	wb := newInvoiceWorkbook(ctx, userID)
+	for _, inv := range selected {
+		var invoice models.EInvoice
+		if err := json.Unmarshal(inv.json, &invoice); err != nil {
+			wb.file.Close()
+			return nil, fmt.Errorf("invoice %s has unreadable data; run an integrity scan", inv.invoiceNo)
+		}
+		wb.add(&invoice, inv.status, inv.sandbox, inv.tags)
+	}
+	return wb, nil
+}
+
+// exportInvoiceZip streams the same invoices as the JSON export as a ZIP
+// archive with one <invoice_no>.json per invoice, the layout the bulk upload
+// tools of the e-invoice portal take. With withQR, each invoice's QR code is
+// added as <invoice_no>.png. Invoice numbers are made safe for file names.
+func exportInvoiceZip(c *gin.Context, userID int, withQR bool) {
+	ctx := exportContext(c)
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, invoice_no, invoice_json, total_value FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status NOT IN ('draft', 'pending_approval') AND (NOT sandbox OR $2)
+		ORDER BY created_at DESC`,
+		userID, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	// The archive is written as rows arrive, so errors past this point can
+	// only cut the download short
+	filename := fmt.Sprintf("all-invoices-%s.zip", time.Now().Format(exportFilterLayout))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Header("Content-Type", "application/zip")
+	c.Status(http.StatusOK)
+	zw := zip.NewWriter(c.Writer)
+
+	add := func(name string, data []byte) error {
+		w, err := zw.Create(name)
+		if err == nil {
+			_, err = w.Write(data)
+		}
+		return err
+	}
+	used := make(map[string]bool)
+	for rows.Next() {
+		var id int
+		var invoiceNo string
+		var invoiceJSON []byte
+		var total float64
+		if err := rows.Scan(&id, &invoiceNo, &invoiceJSON, &total); err != nil {
+			log.Printf("Error reading invoice for ZIP export: %v", err)
+			return
+		}
+
+		// Distinct invoice numbers may sanitize to the same name
+		base := sanitizeFilename(invoiceNo)
+		if used[base] {
+			base = fmt.Sprintf("%s-%d", base, id)
+		}
+		used[base] = true
+
+		var pretty bytes.Buffer
+		if err := json.Indent(&pretty, invoiceJSON, "", "  "); err != nil {
+			pretty.Reset()
+			pretty.Write(invoiceJSON)
+		}
+		err := add(base+".json", pretty.Bytes())
+		if err == nil && withQR {
+			var qrCode []byte
+			if qrCode, err = encodeQR(invoiceQRContent(invoiceNo, total)); err == nil {
+				err = add(base+".png", qrCode)
+			}
+		}
+		if err != nil {
+			log.Printf("Error writing ZIP export: %v", err)
+			return
+		}
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Error reading invoices for ZIP export: %v", err)
+		return
+	}
+	if err := zw.Close(); err != nil {
+		log.Printf("Error writing ZIP export: %v", err)
+	}
+}
+
+// jsonlBatchSize is the number of invoices fetched from the cursor at a time
+const jsonlBatchSize = 500
+
+// handleExportJSONL streams the non-draft invoices matching the export
+// filters as JSON Lines, one invoice per line. Invoices are read from a
+// server-side cursor a batch at a time and flushed as they are written, so
+// memory use stays flat however large the account, and each batch gets the
+// export time limit rather than the whole download.
+func handleExportJSONL(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Cursors only live within a transaction
+	tx, err := dbPool.BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`DECLARE invoice_export NO SCROLL CURSOR FOR
+		SELECT invoice_json FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status NOT IN ('draft', 'pending_approval') AND (NOT sandbox OR $2) AND `+exportFilterSQL+`
+		ORDER BY created_at, id`,
+		append([]interface{}{userID, includeSandbox(c)}, filter.args()...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+
+	// Lines are written as batches arrive, so errors past the first batch
+	// can only cut the download short
+	started := false
+	var line bytes.Buffer
+	for {
+		rows, err := tx.Query(ctx, fmt.Sprintf("FETCH %d FROM invoice_export", jsonlBatchSize))
+		if err != nil {
+			if !started {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+			}
+			log.Printf("Error fetching invoices for JSON Lines export: %v", err)
+			return
+		}
+
+		fetched := 0
+		line.Reset()
+		for rows.Next() {
+			var invoiceJSON []byte
+			if err := rows.Scan(&invoiceJSON); err != nil {
+				break
+			}
+			fetched++
+
+			var invoice models.EInvoice
+			if err := json.Unmarshal(invoiceJSON, &invoice); err != nil || !filter.matchesDate(invoice.DocDtls.Dt) {
+				continue
+			}
+			// Stored JSON may be indented; each invoice must fit on one line
+			if err := json.Compact(&line, invoiceJSON); err != nil {
+				continue
+			}
+			line.WriteByte('\n')
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			if !started {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+			}
+			log.Printf("Error reading invoices for JSON Lines export: %v", err)
+			return
+		}
+
+		if !started {
+			filename := filter.filename("invoices", "jsonl")
+			c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(http.StatusOK)
+			started = true
+		}
+		if line.Len() > 0 {
+			if _, err := c.Writer.Write(line.Bytes()); err != nil {
+				log.Printf("Error writing JSON Lines export: %v", err)
+				return
+			}
+			c.Writer.Flush()
+		}
+		if fetched < jsonlBatchSize {
+			break
+		}
+	}
+	// Headers are sent even when nothing matched
+	c.Writer.WriteHeaderNow()
+}
@@ -0,0 +1,258 @@
+package main
+
+//go:generate buf generate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	einvoicev1 "einvoice-app/proto/einvoice/v1"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	_ "google.golang.org/grpc/encoding/gzip"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/reflection"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMaxMessageBytes is the largest request message accepted, compressed or not
+const grpcMaxMessageBytes = 32 << 20
+
+// grpcCodes maps the HTTP statuses of importErrors from the service layer to
+// gRPC status codes
+var grpcCodes = map[int]codes.Code{
+	http.StatusBadRequest:          codes.InvalidArgument,
+	http.StatusUnauthorized:        codes.Unauthenticated,
+	http.StatusForbidden:           codes.PermissionDenied,
+	http.StatusNotFound:            codes.NotFound,
+	http.StatusConflict:            codes.AlreadyExists,
+	http.StatusUnprocessableEntity: codes.FailedPrecondition,
+	http.StatusTooManyRequests:     codes.ResourceExhausted,
+}
+
+// toGRPCError converts a service error to a gRPC status. The invoice that
+// made a batch fail is named in the message, as gRPC has no error body.
+func toGRPCError(err error) error {
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	var ie *importError
+	if errors.As(err, &ie) {
+		code, ok := grpcCodes[ie.status]
+		if !ok {
+			code = codes.Internal
+		}
+		message := ie.message
+		if ie.failed != nil {
+			message = fmt.Sprintf("invoice %v", ie.failed["index"])
+			if no, _ := ie.failed["invoice_no"].(string); no != "" {
+				message += " (" + no + ")"
+			}
+			message += ": " + ie.message
+		}
+		return status.Error(code, message)
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Error(codes.DeadlineExceeded, "Deadline exceeded")
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.Error(codes.Canceled, "Call cancelled")
+	}
+	log.Printf("Error serving gRPC call: %v", err)
+	return status.Error(codes.Internal, "Internal error")
+}
+
+// startGRPCServer serves the gRPC API on GRPC_PORT, with TLS when
+// GRPC_TLS_CERT_FILE and GRPC_TLS_KEY_FILE are set and over cleartext HTTP/2
+// otherwise. Without GRPC_PORT only the REST API is served. Beside
+// InvoiceService, the standard health service reports whether the database
+// is reachable and the reflection service describes the API to tools such as
+// grpcurl.
+func startGRPCServer() {
+	port := os.Getenv("GRPC_PORT")
+	if port == "" {
+		return
+	}
+
+	options := []grpc.ServerOption{
+		grpc.MaxRecvMsgSize(grpcMaxMessageBytes),
+		grpc.ChainUnaryInterceptor(grpcUnaryInterceptor),
+		grpc.ChainStreamInterceptor(grpcStreamInterceptor),
+		// Drop connections of clients that vanished, and let clients ping
+		// idle connections no more than every 30 seconds
+		grpc.KeepaliveParams(keepalive.ServerParameters{
+			MaxConnectionIdle: 15 * time.Minute,
+			Time:              2 * time.Minute,
+			Timeout:           20 * time.Second,
+		}),
+		grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             30 * time.Second,
+			PermitWithoutStream: true,
+		}),
+	}
+	if certFile, keyFile := os.Getenv("GRPC_TLS_CERT_FILE"), os.Getenv("GRPC_TLS_KEY_FILE"); certFile != "" {
+		creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+		if err != nil {
+			log.Fatalf("Failed to load gRPC TLS certificate: %v", err)
+		}
+		options = append(options, grpc.Creds(creds))
+	}
+
+	server := grpc.NewServer(options...)
+	einvoicev1.RegisterInvoiceServiceServer(server, &invoiceServer{})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(server, healthServer)
+	reflection.Register(server)
+
+	listener, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		log.Fatalf("Failed to start gRPC server: %v", err)
+	}
+	go watchGRPCHealth(healthServer)
+	go func() {
+		log.Println("Starting gRPC server on port :" + port)
+		if err := server.Serve(listener); err != nil {
+			log.Fatalf("Failed to start gRPC server: %v", err)
+		}
+	}()
+}
+
+// watchGRPCHealth reports the gRPC API as serving while the database
+// answers pings
+func watchGRPCHealth(healthServer *health.Server) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		serving := healthpb.HealthCheckResponse_SERVING
+		if err := dbPool.Ping(ctx); err != nil {
+			serving = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+		cancel()
+		healthServer.SetServingStatus("", serving)
+		healthServer.SetServingStatus(einvoicev1.InvoiceService_ServiceDesc.ServiceName, serving)
+		<-ticker.C
+	}
+}
+
+// grpcCallerKey is the context key of the invoiceCaller of a gRPC call
+type grpcCallerKey struct{}
+
+// grpcCallerFrom returns the caller authenticated by the interceptors
+func grpcCallerFrom(ctx context.Context) invoiceCaller {
+	caller, _ := ctx.Value(grpcCallerKey{}).(invoiceCaller)
+	return caller
+}
+
+// grpcPublicServices are the services served without an access token
+var grpcPublicServices = []string{
+	"/grpc.health.v1.Health/",
+	"/grpc.reflection.v1.ServerReflection/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+}
+
+// isPublicGRPCMethod reports whether a method is served without an access token
+func isPublicGRPCMethod(fullMethod string) bool {
+	for _, prefix := range grpcPublicServices {
+		if strings.HasPrefix(fullMethod, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateGRPC adds the caller to the context of calls to the API,
+// authenticated by the access token in the authorization metadata
+func authenticateGRPC(ctx context.Context, fullMethod string) (context.Context, error) {
+	if isPublicGRPCMethod(fullMethod) {
+		return ctx, nil
+	}
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+	caller, err := grpcCaller(ctx, authorization)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(ctx, grpcCallerKey{}, caller), nil
+}
+
+// grpcUnaryInterceptor authenticates unary calls, converts service errors
+// to gRPC statuses and turns panics into internal errors
+func grpcUnaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = toGRPCError(fmt.Errorf("panic in %s: %v", info.FullMethod, p))
+		}
+	}()
+	ctx, err = authenticateGRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	resp, err = handler(ctx, req)
+	if err != nil {
+		return nil, toGRPCError(err)
+	}
+	return resp, nil
+}
+
+// callerStream passes the authenticated context to stream handlers
+type callerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *callerStream) Context() context.Context { return s.ctx }
+
+// grpcStreamInterceptor does for streaming calls what grpcUnaryInterceptor
+// does for unary ones
+func grpcStreamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = toGRPCError(fmt.Errorf("panic in %s: %v", info.FullMethod, p))
+		}
+	}()
+	ctx, err := authenticateGRPC(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	if err := handler(srv, &callerStream{ServerStream: ss, ctx: ctx}); err != nil {
+		return toGRPCError(err)
+	}
+	return nil
+}
+
+// grpcCaller authenticates a call by the access token in its authorization
+// metadata, as authMiddleware and accountMiddleware do for the REST API
+func grpcCaller(ctx context.Context, authorization string) (invoiceCaller, error) {
+	if len(authorization) < 8 || authorization[:7] != "Bearer " {
+		return invoiceCaller{}, status.Error(codes.Unauthenticated, "Missing or invalid authorization metadata")
+	}
+	claims, err := verifyToken(ctx, authorization[7:])
+	if err != nil {
+		return invoiceCaller{}, status.Error(codes.Unauthenticated, "Invalid or expired token")
+	}
+	ownerID, role, err := lookupAccount(ctx, claims.UserID)
+	if errors.Is(err, errAccountDisabled) {
+		return invoiceCaller{}, status.Error(codes.PermissionDenied, "The account you are a member of is disabled")
+	}
+	if err != nil {
+		return invoiceCaller{}, status.Error(codes.Internal, "Database error")
+	}
+	return invoiceCaller{userID: ownerID, actorID: claims.UserID, role: role}, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+	einvoicev1 "einvoice-app/proto/einvoice/v1"
+
+	"github.com/jackc/pgx/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// invoiceServer implements InvoiceService over the invoice service layer
+// shared with the REST API. The interceptors authenticate each call, so
+// methods read the caller from the context.
+type invoiceServer struct {
+	einvoicev1.UnimplementedInvoiceServiceServer
+}
+
+// invoiceToProto converts a stored invoice to an Invoice message
+func invoiceToProto(r *invoiceRecord) *einvoicev1.Invoice {
+	return &einvoicev1.Invoice{
+		Id:          int64(r.ID),
+		InvoiceNo:   r.InvoiceNo,
+		SellerGstin: r.SellerGSTIN,
+		Status:      r.Status,
+		Irn:         r.IRN,
+		Exported:    r.Exported,
+		Sandbox:     r.Sandbox,
+		CreatedAt:   r.CreatedAt.UTC().Format(time.RFC3339),
+		UpdatedAt:   r.UpdatedAt.UTC().Format(time.RFC3339),
+		QrUrl:       qrURL(r.ID, r.QRVersion),
+		InvoiceJson: r.InvoiceJSON,
+	}
+}
+
+// generatedInvoiceToProto converts a result of generateInvoices to a
+// GeneratedInvoice message
+func generatedInvoiceToProto(result map[string]interface{}) *einvoicev1.GeneratedInvoice {
+	m := &einvoicev1.GeneratedInvoice{}
+	if id, ok := result["id"].(int); ok {
+		m.Id = int64(id)
+	}
+	m.InvoiceNo, _ = result["invoice_no"].(string)
+	m.Status, _ = result["status"].(string)
+	m.QrUrl, _ = result["qr_url"].(string)
+	m.Warnings, _ = result["warnings"].([]string)
+	return m
+}
+
+// GenerateInvoice creates invoices through generateInvoices, the same path
+// POST /api/generate-invoice takes
+func (s *invoiceServer) GenerateInvoice(ctx context.Context, req *einvoicev1.GenerateInvoiceRequest) (*einvoicev1.GenerateInvoiceResponse, error) {
+	invoices := make([]models.EInvoice, len(req.GetInvoiceJson()))
+	for i, doc := range req.GetInvoiceJson() {
+		if err := json.Unmarshal(doc, &invoices[i]); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invoice %d: invalid invoice JSON: %v", i, err)
+		}
+	}
+
+	invoiceStatus := models.InvoiceStatusFinalized
+	if req.GetDraft() {
+		invoiceStatus = models.InvoiceStatusDraft
+	}
+	results, err := generateInvoices(ctx, grpcCallerFrom(ctx), invoices, invoiceStatus)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &einvoicev1.GenerateInvoiceResponse{
+		Invoices: make([]*einvoicev1.GeneratedInvoice, 0, len(results)),
+	}
+	for _, result := range results {
+		resp.Invoices = append(resp.Invoices, generatedInvoiceToProto(result))
+	}
+	return resp, nil
+}
+
+// GetInvoice returns one invoice of the caller's account
+func (s *invoiceServer) GetInvoice(ctx context.Context, req *einvoicev1.GetInvoiceRequest) (*einvoicev1.Invoice, error) {
+	if req.GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "Invalid invoice ID")
+	}
+
+	record, err := getInvoice(ctx, grpcCallerFrom(ctx).userID, int(req.GetId()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, status.Error(codes.NotFound, "Invoice not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return invoiceToProto(record), nil
+}
+
+// ListInvoices streams the caller's invoices as they are read
+func (s *invoiceServer) ListInvoices(req *einvoicev1.ListInvoicesRequest, stream grpc.ServerStreamingServer[einvoicev1.Invoice]) error {
+	ctx := stream.Context()
+
+	filter := &exportFilter{sellerGSTIN: strings.ToUpper(strings.TrimSpace(req.GetSellerGstin()))}
+	for _, p := range []struct {
+		name  string
+		value string
+		dst   **time.Time
+	}{{"from", req.GetFrom(), &filter.from}, {"to", req.GetTo(), &filter.to}} {
+		if p.value == "" {
+			continue
+		}
+		t, err := time.Parse(exportFilterLayout, p.value)
+		if err != nil {
+			return status.Errorf(codes.InvalidArgument, "%s must be a date in YYYY-MM-DD format", p.name)
+		}
+		*p.dst = &t
+	}
+	if filter.from != nil && filter.to != nil && filter.to.Before(*filter.from) {
+		return status.Error(codes.InvalidArgument, "to must not be before from")
+	}
+	if req.GetAfterId() < 0 {
+		return status.Error(codes.InvalidArgument, "Invalid after_id")
+	}
+
+	return listInvoices(ctx, grpcCallerFrom(ctx).userID, filter, req.GetStatus(), int(req.GetAfterId()), func(r *invoiceRecord) error {
+		return stream.Send(invoiceToProto(r))
+	})
+}
@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// gstRatesCSV is the bundled GST rate history of HSN codes whose rate has
+// changed since GST began, used to seed the gst_rates table. Set
+// GST_RATE_FILE to load another schedule instead.
+//
+//go:embed data/gst_rates.csv
+var gstRatesCSV []byte
+
+// createGSTRateTables creates the GST rate master and seeds it if empty
+func createGSTRateTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS gst_rates (
+			id SERIAL PRIMARY KEY,
+			hsn_prefix VARCHAR(8) NOT NULL,
+			rate DECIMAL(5,2) NOT NULL,
+			effective_from DATE NOT NULL,
+			notification VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (hsn_prefix, effective_from)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create gst_rates table: %v", err)
+	}
+
+	var count int
+	if err := dbPool.QueryRow(context.Background(), "SELECT COUNT(*) FROM gst_rates").Scan(&count); err != nil {
+		log.Fatalf("Failed to count GST rates: %v", err)
+	}
+	if count > 0 {
+		return
+	}
+
+	if err := seedGSTRates(); err != nil {
+		log.Printf("Error seeding GST rate master: %v", err)
+	}
+}
+
+// seedGSTRates loads the GST rate master from GST_RATE_FILE or the bundled list
+func seedGSTRates() error {
+	data := gstRatesCSV
+	if path := os.Getenv("GST_RATE_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read GST_RATE_FILE: %w", err)
+		}
+		data = fileData
+	}
+
+	rates, err := parseGSTRateCSV(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, 0, len(rates))
+	for _, r := range rates {
+		from, _ := time.Parse(invoiceDateLayout, r.EffectiveFrom)
+		rows = append(rows, []interface{}{r.HSNPrefix, r.Rate, from, r.Notification})
+	}
+
+	copied, err := dbPool.CopyFrom(context.Background(),
+		pgx.Identifier{"gst_rates"},
+		[]string{"hsn_prefix", "rate", "effective_from", "notification"},
+		pgx.CopyFromRows(rows))
+	if err != nil {
+		return fmt.Errorf("failed to insert GST rates: %w", err)
+	}
+
+	log.Printf("Seeded %d GST rates", copied)
+	return nil
+}
+
+// parseGSTRateCSV reads hsn_prefix,rate,effective_from,notification rows with
+// a header line; effective dates are DD/MM/YYYY
+func parseGSTRateCSV(r io.Reader) ([]models.GSTRate, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid GST rate CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, errors.New("GST rate CSV has no data rows")
+	}
+
+	rates := make([]models.GSTRate, 0, len(records)-1)
+	for i, record := range records[1:] {
+		if len(record) < 3 {
+			return nil, fmt.Errorf("GST rate row %d does not have enough columns", i+2)
+		}
+		rate, err := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("GST rate row %d has an invalid rate", i+2)
+		}
+		r := models.GSTRate{
+			HSNPrefix:     strings.TrimSpace(record[0]),
+			Rate:          rate,
+			EffectiveFrom: strings.TrimSpace(record[2]),
+		}
+		if len(record) > 3 {
+			r.Notification = strings.TrimSpace(record[3])
+		}
+		if err := r.Validate(); err != nil {
+			return nil, fmt.Errorf("GST rate row %d: %w", i+2, err)
+		}
+		rates = append(rates, r)
+	}
+
+	return rates, nil
+}
+
+// gstRateSchedule is the GST rate master in memory, for checking many items
+// without a query each
+type gstRateSchedule struct {
+	// byPrefix holds the rates of each HSN prefix, latest first
+	byPrefix map[string][]gstRateEntry
+}
+
+// gstRateEntry is a rate of the schedule with its parsed effective date
+type gstRateEntry struct {
+	rate *models.GSTRate
+	from time.Time
+}
+
+// loadGSTRateSchedule reads the GST rate master
+func loadGSTRateSchedule(ctx context.Context) (*gstRateSchedule, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, hsn_prefix, rate::float8, effective_from, notification, created_at
+		FROM gst_rates ORDER BY hsn_prefix, effective_from DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	s := &gstRateSchedule{byPrefix: make(map[string][]gstRateEntry)}
+	for rows.Next() {
+		var r models.GSTRate
+		var from time.Time
+		if err := rows.Scan(&r.ID, &r.HSNPrefix, &r.Rate, &from, &r.Notification, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		r.EffectiveFrom = from.Format(invoiceDateLayout)
+		s.byPrefix[r.HSNPrefix] = append(s.byPrefix[r.HSNPrefix], gstRateEntry{rate: &r, from: from})
+	}
+	return s, rows.Err()
+}
+
+// rateOn returns the rate in force for an HSN code on a day: that of the
+// longest prefix of the code with a rate effective on or before the day
+func (s *gstRateSchedule) rateOn(hsn string, date time.Time) *models.GSTRate {
+	day := time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC)
+	for n := len(hsn); n >= 2; n-- {
+		for _, e := range s.byPrefix[hsn[:n]] {
+			if !e.from.After(day) {
+				return e.rate
+			}
+		}
+	}
+	return nil
+}
+
+// gstRateDate returns the day an invoice's rates are checked for: its
+// invoice date, or today when it has no readable date
+func gstRateDate(invoice *models.EInvoice) time.Time {
+	if date, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt); err == nil {
+		return date
+	}
+	return time.Now().In(istLocation)
+}
+
+// uploadGSTRate returns the rate in force for the HSN code of an upload row
+// on the invoice date, for rows leaving the GST rate blank
+func uploadGSTRate(report *uploadReport, schedule *gstRateSchedule, row excelRow, rowNum int, invoiceNo string, invoice *models.EInvoice) (float64, bool) {
+	hsn := row.get("hsn_code")
+	r := schedule.rateOn(hsn, gstRateDate(invoice))
+	if r == nil {
+		report.add(rowNum, invoiceNo, row.column("gst_rate"),
+			"value is required; the GST rate master has no rate for HSN "+hsn)
+		return 0, false
+	}
+	return r.Rate, true
+}
+
+// gstRateMismatch is an invoice line taxed at a rate other than the one in
+// force for its HSN code on the invoice date
+type gstRateMismatch struct {
+	InvoiceID     int     `json:"invoice_id"`
+	InvoiceNo     string  `json:"invoice_no"`
+	Date          string  `json:"date"`
+	Status        string  `json:"status"`
+	SlNo          string  `json:"item_no"`
+	HsnCd         string  `json:"hsn_code"`
+	Rate          float64 `json:"rate"`
+	ExpectedRate  float64 `json:"expected_rate"`
+	HSNPrefix     string  `json:"hsn_prefix"`
+	EffectiveFrom string  `json:"effective_from"`
+	Notification  string  `json:"notification"`
+	Link          string  `json:"link"`
+}
+
+// handleGetGSTRates lists the GST rate master, latest first for each prefix.
+// With hsn, only the rates of that code's prefixes are listed, and with a
+// date (DD/MM/YYYY, default today) the rate in force for it is returned too.
+func handleGetGSTRates(c *gin.Context) {
+	ctx := c.Request.Context()
+	hsn := strings.TrimSpace(c.Query("hsn"))
+	if hsn != "" && !models.IsValidHSNFormat(hsn) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "HSN code must be 4, 6 or 8 digits"})
+		return
+	}
+	date := time.Now().In(istLocation)
+	if value := c.Query("date"); value != "" {
+		d, err := time.Parse(invoiceDateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be in DD/MM/YYYY format"})
+			return
+		}
+		date = d
+	}
+
+	schedule, err := loadGSTRateSchedule(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch GST rates"})
+		return
+	}
+
+	prefixes := make([]string, 0, len(schedule.byPrefix))
+	for prefix := range schedule.byPrefix {
+		if hsn == "" || strings.HasPrefix(hsn, prefix) {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	rates := make([]*models.GSTRate, 0)
+	for _, prefix := range prefixes {
+		for _, e := range schedule.byPrefix[prefix] {
+			rates = append(rates, e.rate)
+		}
+	}
+
+	response := gin.H{"rates": rates}
+	if hsn != "" {
+		response["hsn"] = hsn
+		response["date"] = date.Format(invoiceDateLayout)
+		response["rate"] = schedule.rateOn(hsn, date)
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// handleAdminCreateGSTRate adds a rate to the master, such as a rate change
+// notified by the GST Council, taking effect on its effective date
+func handleAdminCreateGSTRate(c *gin.Context) {
+	var r models.GSTRate
+	if err := c.ShouldBindJSON(&r); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GST rate data: " + err.Error()})
+		return
+	}
+	r.HSNPrefix = strings.TrimSpace(r.HSNPrefix)
+	r.Notification = strings.TrimSpace(r.Notification)
+	if err := r.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	from, _ := time.Parse(invoiceDateLayout, r.EffectiveFrom)
+
+	var id int
+	err := dbPool.QueryRow(c.Request.Context(),
+		"INSERT INTO gst_rates (hsn_prefix, rate, effective_from, notification) VALUES ($1, $2, $3, $4) RETURNING id",
+		r.HSNPrefix, r.Rate, from, r.Notification).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A rate for this HSN prefix already takes effect on this date"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating GST rate: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create GST rate"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "GST rate created successfully",
+		"id":      id,
+	})
+}
+
+// handleAdminDeleteGSTRate removes a rate from the master; the previous rate
+// of its prefix applies again from its effective date
+func handleAdminDeleteGSTRate(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GST rate ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), "DELETE FROM gst_rates WHERE id = $1", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete GST rate"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "GST rate not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "GST rate deleted successfully",
+		"id":      id,
+	})
+}
+
+// handleGSTRateMismatchReport lists the lines of draft and finalized invoices
+// taxed at a rate other than the one in force for their HSN code on the
+// invoice date, such as drafts prepared before a rate change. Accepts the
+// filters of the exports.
+func handleGSTRateMismatchReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schedule, err := loadGSTRateSchedule(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch GST rates"})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, invoice_json, status FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status <> 'cancelled' AND (NOT sandbox OR $2) AND `+exportFilterSQL+`
+		ORDER BY invoice_date, id`,
+		append([]interface{}{userID, includeSandbox(c)}, filter.args()...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	mismatches := make([]gstRateMismatch, 0)
+	checked := 0
+	for rows.Next() {
+		var id int
+		var invoiceJSON []byte
+		var status string
+		if err := rows.Scan(&id, &invoiceJSON, &status); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+			return
+		}
+		// Unreadable invoices are reported by the integrity scan
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+			continue
+		}
+		checked++
+
+		date := gstRateDate(&invoice)
+		for _, item := range invoice.ItemList {
+			r := schedule.rateOn(item.HsnCd, date)
+			if r == nil || r.Rate == item.GstRt {
+				continue
+			}
+			mismatches = append(mismatches, gstRateMismatch{
+				InvoiceID:     id,
+				InvoiceNo:     invoice.DocDtls.No,
+				Date:          invoice.DocDtls.Dt,
+				Status:        status,
+				SlNo:          item.SlNo,
+				HsnCd:         item.HsnCd,
+				Rate:          item.GstRt,
+				ExpectedRate:  r.Rate,
+				HSNPrefix:     r.HSNPrefix,
+				EffectiveFrom: r.EffectiveFrom,
+				Notification:  r.Notification,
+				Link:          fmt.Sprintf("/api/v1/invoices/%d", id),
+			})
+		}
+	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"mismatches": mismatches,
+		"summary": gin.H{
+			"invoices_checked": checked,
+			"mismatches":       len(mismatches),
+		},
+	})
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// errGSTINLookupDisabled is returned when no GSTIN verification API is configured
+var errGSTINLookupDisabled = errors.New("GSTIN verification is not configured")
+
+// errGSTINNotFound is returned when the API has no record of the GSTIN
+var errGSTINNotFound = errors.New("GSTIN not found")
+
+// gstinHTTPClient is used for all calls to the GSTIN verification API
+var gstinHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// gstinAPIResponse mirrors the taxpayer search response of the GST common portal
+type gstinAPIResponse struct {
+	Gstin    string `json:"gstin"`
+	LglNm    string `json:"lgnm"`
+	TradeNam string `json:"tradeNam"`
+	Sts      string `json:"sts"`
+	Pradr    struct {
+		Addr struct {
+			Bno  string `json:"bno"`
+			Flno string `json:"flno"`
+			Bnm  string `json:"bnm"`
+			St   string `json:"st"`
+			Loc  string `json:"loc"`
+			Dst  string `json:"dst"`
+			Stcd string `json:"stcd"`
+			Pncd string `json:"pncd"`
+		} `json:"addr"`
+	} `json:"pradr"`
+}
+
+// createGSTINTables creates the GSTIN verification cache table
+func createGSTINTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS gstin_verifications (
+			gstin VARCHAR(15) PRIMARY KEY,
+			legal_name VARCHAR(255) NOT NULL,
+			trade_name VARCHAR(255),
+			address TEXT,
+			city VARCHAR(100),
+			state VARCHAR(100),
+			pincode INTEGER,
+			status VARCHAR(50),
+			fetched_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create gstin_verifications table: %v", err)
+	}
+}
+
+// getGSTINCacheTTL returns how long cached verifications are considered fresh
+func getGSTINCacheTTL() time.Duration {
+	hours, err := strconv.Atoi(getEnvWithDefault("GSTIN_CACHE_TTL_HOURS", "24"))
+	if err != nil || hours < 0 {
+		log.Printf("Invalid GSTIN_CACHE_TTL_HOURS value, defaulting to 24")
+		hours = 24
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// lookupGSTIN returns taxpayer details for a GSTIN, using the cache when fresh
+func lookupGSTIN(ctx context.Context, gstin string) (*models.GSTINDetails, error) {
+	var d models.GSTINDetails
+	err := dbPool.QueryRow(ctx, `
+		SELECT gstin, legal_name, COALESCE(trade_name, ''), COALESCE(address, ''),
+			COALESCE(city, ''), COALESCE(state, ''), COALESCE(pincode, 0),
+			COALESCE(status, ''), fetched_at
+		FROM gstin_verifications WHERE gstin = $1
+	`, gstin).Scan(&d.GSTIN, &d.LegalName, &d.TradeName, &d.Address,
+		&d.City, &d.State, &d.Pincode, &d.Status, &d.FetchedAt)
+	if err == nil && time.Since(d.FetchedAt) < getGSTINCacheTTL() {
+		// Earlier versions cached the state code in place of its name
+		d.State = stateName(d.State)
+		return &d, nil
+	}
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error reading GSTIN cache: %v", err)
+	}
+
+	fetched, err := fetchGSTINDetails(ctx, gstin)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		INSERT INTO gstin_verifications (
+			gstin, legal_name, trade_name, address, city, state, pincode, status, fetched_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (gstin) DO UPDATE
+		SET legal_name = $2, trade_name = $3, address = $4, city = $5, state = $6,
+			pincode = $7, status = $8, fetched_at = $9
+	`, fetched.GSTIN, fetched.LegalName, fetched.TradeName, fetched.Address,
+		fetched.City, fetched.State, fetched.Pincode, fetched.Status, fetched.FetchedAt)
+	if err != nil {
+		log.Printf("Error caching GSTIN verification: %v", err)
+	}
+
+	return fetched, nil
+}
+
+// fetchGSTINDetails calls the configured GSTIN verification API.
+// GSTIN_API_URL must contain a {gstin} placeholder, e.g. https://gsp.example.com/taxpayer/{gstin}
+func fetchGSTINDetails(ctx context.Context, gstin string) (*models.GSTINDetails, error) {
+	apiURL := os.Getenv("GSTIN_API_URL")
+	if apiURL == "" {
+		return nil, errGSTINLookupDisabled
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(apiURL, "{gstin}", gstin), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if apiKey := os.Getenv("GSTIN_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := gstinHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("GSTIN API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errGSTINNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GSTIN API returned status %d", resp.StatusCode)
+	}
+
+	var apiResp gstinAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&apiResp); err != nil {
+		return nil, fmt.Errorf("invalid GSTIN API response: %w", err)
+	}
+	if apiResp.LglNm == "" {
+		return nil, errGSTINNotFound
+	}
+
+	addr := apiResp.Pradr.Addr
+	parts := make([]string, 0, 4)
+	for _, part := range []string{addr.Bno, addr.Flno, addr.Bnm, addr.St} {
+		if part != "" {
+			parts = append(parts, part)
+		}
+	}
+	pincode, _ := strconv.Atoi(addr.Pncd)
+	city := addr.Loc
+	if city == "" {
+		city = addr.Dst
+	}
+
+	return &models.GSTINDetails{
+		GSTIN:     gstin,
+		LegalName: apiResp.LglNm,
+		TradeName: apiResp.TradeNam,
+		Address:   strings.Join(parts, ", "),
+		City:      city,
+		State:     stateName(addr.Stcd),
+		Pincode:   pincode,
+		Status:    apiResp.Sts,
+		FetchedAt: time.Now(),
+	}, nil
+}
+
+// autoFillParty fills empty party fields from the GSTIN verification API.
+// Lookup failures are logged and otherwise ignored so creation is never blocked.
+func autoFillParty(ctx context.Context, gstin string, name, address, city, state *string, pincode *int) {
+	if gstin == "" || !models.IsValidGSTIN(gstin) {
+		return
+	}
+	if *name != "" && *address != "" && *city != "" && *state != "" && *pincode != 0 {
+		return
+	}
+
+	details, err := lookupGSTIN(ctx, gstin)
+	if err != nil {
+		if !errors.Is(err, errGSTINLookupDisabled) {
+			log.Printf("GSTIN auto-fill failed for %s: %v", gstin, err)
+		}
+		return
+	}
+
+	if *name == "" {
+		*name = details.LegalName
+	}
+	if *address == "" {
+		*address = details.Address
+	}
+	if *city == "" {
+		*city = details.City
+	}
+	if *state == "" {
+		*state = details.State
+	}
+	if *pincode == 0 {
+		*pincode = details.Pincode
+	}
+}
+
+// handleVerifyGSTIN returns verified taxpayer details for a GSTIN
+func handleVerifyGSTIN(c *gin.Context) {
+	gstin := strings.ToUpper(c.Param("gstin"))
+	if !models.IsValidGSTIN(gstin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid GSTIN format"})
+		return
+	}
+
+	details, err := lookupGSTIN(c.Request.Context(), gstin)
+	if err != nil {
+		switch {
+		case errors.Is(err, errGSTINLookupDisabled):
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		case errors.Is(err, errGSTINNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "GSTIN not found"})
+		default:
+			log.Printf("Error verifying GSTIN %s: %v", gstin, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to verify GSTIN"})
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"gstin":  details,
+		"active": details.IsActive(),
+	})
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// gstr1Rate holds the values of an invoice's items at one GST rate
+type gstr1Rate struct {
+	Rate float64 `json:"rate"`
+	taxHeads
+}
+
+// gstr1Rates splits an invoice's taxable items by GST rate, in ascending rate
+// order, subtracting them when sign is negative. Nil-rated, exempt and
+// non-GST items are reported in Table 8 instead.
+func gstr1Rates(invoice *models.EInvoice, sign float64) []*gstr1Rate {
+	byRate := make(map[float64]*gstr1Rate)
+	for _, item := range invoice.ItemList {
+		if item.IsExempt() {
+			continue
+		}
+		heads := taxHeads{TaxableValue: item.AssAmt, TotalTax: item.TaxAmt()}
+		heads.IGST, heads.CGST, heads.SGST = invoice.ItemTax(&item)
+		r, ok := byRate[item.GstRt]
+		if !ok {
+			r = &gstr1Rate{Rate: item.GstRt}
+			byRate[item.GstRt] = r
+		}
+		r.add(heads, sign)
+	}
+	rates := make([]*gstr1Rate, 0, len(byRate))
+	for _, r := range byRate {
+		rates = append(rates, r)
+	}
+	sort.Slice(rates, func(i, j int) bool { return rates[i].Rate < rates[j].Rate })
+	return rates
+}
+
+// gstr1InvoiceTypes are the GSTR-1 invoice types of B2B supplies
+var gstr1InvoiceTypes = map[string]string{
+	models.SupplyB2B:    "Regular B2B",
+	models.SupplySEZWP:  "SEZ supplies with payment",
+	models.SupplySEZWOP: "SEZ supplies without payment",
+	models.SupplyDEXP:   "Deemed Exp",
+}
+
+// gstr1Document is an invoice or note reported one by one in GSTR-1
+type gstr1Document struct {
+	ID         int    `json:"id"`
+	DocumentNo string `json:"document_no"`
+	Date       string `json:"date"`
+	// NoteType is C for credit notes and D for debit notes
+	NoteType      string       `json:"note_type,omitempty"`
+	BuyerGSTIN    string       `json:"buyer_gstin,omitempty"`
+	BuyerName     string       `json:"buyer_name"`
+	PlaceOfSupply string       `json:"place_of_supply"`
+	Value         float64      `json:"value"`
+	ReverseCharge bool         `json:"reverse_charge"`
+	InvoiceType   string       `json:"invoice_type,omitempty"`
+	ExportType    string       `json:"export_type,omitempty"`
+	Port          string       `json:"port,omitempty"`
+	ShippingBill  string       `json:"shipping_bill_no,omitempty"`
+	ShippingDate  string       `json:"shipping_bill_date,omitempty"`
+	Rates         []*gstr1Rate `json:"rates"`
+	Link          string       `json:"link"`
+}
+
+// gstr1B2CSEntry is the B2CS supplies of one place of supply and rate, net
+// of notes
+type gstr1B2CSEntry struct {
+	PlaceOfSupply string  `json:"place_of_supply"`
+	Rate          float64 `json:"rate"`
+	taxHeads
+}
+
+// gstr1NilEntry is a row of GSTR-1 Table 8: the value of nil-rated, exempt
+// and non-GST supplies of one kind, net of notes
+type gstr1NilEntry struct {
+	// SupplyType is INTRB2B, INTRAB2B, INTRB2C or INTRAB2C, as in the GSTR-1 JSON
+	SupplyType  string  `json:"supply_type"`
+	Description string  `json:"description"`
+	NilRated    float64 `json:"nil_rated"`
+	Exempt      float64 `json:"exempt"`
+	NonGST      float64 `json:"non_gst"`
+}
+
+// newGSTR1NilTable returns the rows of GSTR-1 Table 8 in filing order
+func newGSTR1NilTable() []*gstr1NilEntry {
+	return []*gstr1NilEntry{
+		{SupplyType: "INTRB2B", Description: "Inter-state supplies to registered persons"},
+		{SupplyType: "INTRAB2B", Description: "Intra-state supplies to registered persons"},
+		{SupplyType: "INTRB2C", Description: "Inter-state supplies to unregistered persons"},
+		{SupplyType: "INTRAB2C", Description: "Intra-state supplies to unregistered persons"},
+	}
+}
+
+// gstr1Report is the GSTR-1 sections built from a period's finalized
+// invoices and notes
+type gstr1Report struct {
+	B2B   []*gstr1Document  `json:"b2b"`
+	B2CL  []*gstr1Document  `json:"b2cl"`
+	B2CS  []*gstr1B2CSEntry `json:"b2cs"`
+	EXP   []*gstr1Document  `json:"exp"`
+	CDNR  []*gstr1Document  `json:"cdnr"`
+	CDNUR []*gstr1Document  `json:"cdnur"`
+	// Nil is Table 8, the nil-rated, exempt and non-GST supplies
+	Nil []*gstr1NilEntry `json:"nil"`
+	// Totals holds each section's values, notes subtracting credit
+	Totals map[string]*taxHeads `json:"totals"`
+}
+
+// addNilSupplies adds the invoice's nil-rated, exempt and non-GST items to
+// Table 8, subtracting them when sign is negative
+func (r *gstr1Report) addNilSupplies(invoice *models.EInvoice, sign float64) {
+	row := 0
+	if !invoice.IsInterState() {
+		row++
+	}
+	if !invoice.HasRegisteredBuyer() {
+		row += 2
+	}
+	e := r.Nil[row]
+	for _, item := range invoice.ItemList {
+		value := sign * item.AssAmt
+		switch item.Exemption {
+		case models.ExemptionNilRated:
+			e.NilRated = models.Round(e.NilRated+value, models.AmountDecimals)
+		case models.ExemptionExempt:
+			e.Exempt = models.Round(e.Exempt+value, models.AmountDecimals)
+		case models.ExemptionNonGST:
+			e.NonGST = models.Round(e.NonGST+value, models.AmountDecimals)
+		}
+	}
+}
+
+// total returns the running total of a section
+func (r *gstr1Report) total(section string) *taxHeads {
+	t, ok := r.Totals[section]
+	if !ok {
+		t = &taxHeads{}
+		r.Totals[section] = t
+	}
+	return t
+}
+
+// buildGSTR1Report reads the finalized invoices of the filter's period and
+// reports each in the GSTR-1 section stored when it was saved. Credit and
+// debit notes to registered buyers go to CDNR, notes on B2CL supplies and
+// exports to CDNUR, and notes on B2CS supplies are netted into B2CS. Nil-rated,
+// exempt and non-GST items go to Table 8; invoices with only such items are
+// reported there alone.
+func buildGSTR1Report(ctx context.Context, userID int, filter *exportFilter, withSandbox bool) (*gstr1Report, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, invoice_json, gstr1_section FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status = 'finalized' AND (NOT sandbox OR $2) AND `+exportFilterSQL+`
+		ORDER BY invoice_date, id`,
+		append([]interface{}{userID, withSandbox}, filter.args()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &gstr1Report{
+		B2B:    make([]*gstr1Document, 0),
+		B2CL:   make([]*gstr1Document, 0),
+		B2CS:   make([]*gstr1B2CSEntry, 0),
+		EXP:    make([]*gstr1Document, 0),
+		CDNR:   make([]*gstr1Document, 0),
+		CDNUR:  make([]*gstr1Document, 0),
+		Nil:    newGSTR1NilTable(),
+		Totals: make(map[string]*taxHeads),
+	}
+	b2cs := make(map[string]*gstr1B2CSEntry)
+	for rows.Next() {
+		var id int
+		var invoiceJSON []byte
+		var section string
+		if err := rows.Scan(&id, &invoiceJSON, &section); err != nil {
+			return nil, err
+		}
+
+		// Unreadable invoices are reported by the integrity scan
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+			continue
+		}
+
+		noteType := ""
+		sign := 1.0
+		switch invoice.DocDtls.Typ {
+		case "CRN":
+			noteType, sign = "C", -1
+		case "DBN":
+			noteType = "D"
+		}
+
+		report.addNilSupplies(&invoice, sign)
+		rates := gstr1Rates(&invoice, 1)
+		if len(rates) == 0 {
+			continue
+		}
+
+		if section == models.GSTR1B2CS {
+			for _, r := range rates {
+				key := fmt.Sprintf("%s|%g", invoice.BuyerDtls.Pos, r.Rate)
+				e, ok := b2cs[key]
+				if !ok {
+					e = &gstr1B2CSEntry{PlaceOfSupply: invoice.BuyerDtls.Pos, Rate: r.Rate}
+					b2cs[key] = e
+					report.B2CS = append(report.B2CS, e)
+				}
+				e.add(r.taxHeads, sign)
+			}
+			report.total("b2cs").add(invoiceTaxHeads(&invoice), sign)
+			continue
+		}
+
+		doc := &gstr1Document{
+			ID:            id,
+			DocumentNo:    invoice.DocDtls.No,
+			Date:          invoice.DocDtls.Dt,
+			NoteType:      noteType,
+			BuyerName:     invoice.BuyerDtls.LglNm,
+			PlaceOfSupply: invoice.BuyerDtls.Pos,
+			Value:         invoice.ValDtls.TotInvVal,
+			ReverseCharge: invoice.IsReverseCharge(),
+			Rates:         rates,
+			Link:          fmt.Sprintf("/api/v1/invoices/%d", id),
+		}
+		if invoice.HasRegisteredBuyer() {
+			doc.BuyerGSTIN = invoice.BuyerDtls.Gstin
+		}
+		if section == models.GSTR1EXP {
+			doc.ExportType = "WPAY"
+			if invoice.IsWithoutPayment() {
+				doc.ExportType = "WOPAY"
+			}
+			doc.Port = invoice.ExpDtls.Port
+			doc.ShippingBill = invoice.ExpDtls.ShipBNo
+			doc.ShippingDate = invoice.ExpDtls.ShipBDt
+		}
+
+		var name string
+		switch {
+		case noteType != "" && section == models.GSTR1B2B:
+			doc.InvoiceType = gstr1InvoiceTypes[invoice.TranDtls.SupTyp]
+			report.CDNR = append(report.CDNR, doc)
+			name = "cdnr"
+		case noteType != "":
+			// Unregistered notes are typed by the supply they amend
+			doc.InvoiceType = section
+			if section == models.GSTR1EXP {
+				doc.InvoiceType = "EXP" + doc.ExportType
+			}
+			report.CDNUR = append(report.CDNUR, doc)
+			name = "cdnur"
+		case section == models.GSTR1B2B:
+			doc.InvoiceType = gstr1InvoiceTypes[invoice.TranDtls.SupTyp]
+			report.B2B = append(report.B2B, doc)
+			name = "b2b"
+		case section == models.GSTR1B2CL:
+			report.B2CL = append(report.B2CL, doc)
+			name = "b2cl"
+		default:
+			report.EXP = append(report.EXP, doc)
+			name = "exp"
+		}
+		report.total(name).add(invoiceTaxHeads(&invoice), sign)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report.B2CS, func(i, j int) bool {
+		if report.B2CS[i].PlaceOfSupply != report.B2CS[j].PlaceOfSupply {
+			return report.B2CS[i].PlaceOfSupply < report.B2CS[j].PlaceOfSupply
+		}
+		return report.B2CS[i].Rate < report.B2CS[j].Rate
+	})
+	return report, nil
+}
+
+// handleGSTR1Report returns the period's finalized invoices and notes
+// arranged in the GSTR-1 sections they are filed in
+func handleGSTR1Report(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := buildGSTR1Report(ctx, userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build GSTR-1 report"})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
@@ -0,0 +1,595 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// gstr2bPeriodLayout is the MMYYYY format of return periods in GSTR-2B JSON
+const gstr2bPeriodLayout = "012006"
+
+// gstr2bDateLayouts are the document date formats of GSTR-2B downloads
+var gstr2bDateLayouts = []string{"02-01-2006", "02/01/2006", "02-Jan-2006", "2006-01-02"}
+
+// gstr2bFields are the columns read from the B2B sheet of the GSTR-2B Excel
+// download, matched like partyImportFields
+var gstr2bFields = []partyImportField{
+	{name: "gstin", label: "GSTIN of supplier", required: true, aliases: []string{"supplier gstin", "ctin"}},
+	{name: "name", label: "Trade/Legal name", aliases: []string{"trade/legal name of the supplier", "supplier name", "trdnm"}},
+	{name: "doc_no", label: "Invoice number", required: true, aliases: []string{"invoice no", "inum"}},
+	{name: "date", label: "Invoice Date", required: true, aliases: []string{"invoice dt", "dt"}},
+	{name: "value", label: "Invoice Value", aliases: []string{"invoice value(₹)", "val"}},
+	{name: "pos", label: "Place of supply", aliases: []string{"pos"}},
+	{name: "reverse_charge", label: "Supply Attract Reverse Charge", aliases: []string{"reverse charge", "rev"}},
+	{name: "taxable_value", label: "Taxable Value", required: true, aliases: []string{"taxable value (₹)", "txval"}},
+	{name: "igst", label: "Integrated Tax", aliases: []string{"integrated tax(₹)", "igst"}},
+	{name: "cgst", label: "Central Tax", aliases: []string{"central tax(₹)", "cgst"}},
+	{name: "sgst", label: "State/UT Tax", aliases: []string{"state/ut tax(₹)", "sgst"}},
+	{name: "cess", label: "Cess", aliases: []string{"cess(₹)"}},
+	{name: "itc_available", label: "ITC Availability", aliases: []string{"itc available", "itcavl"}},
+	{name: "reason", label: "Reason", aliases: []string{"rsn"}},
+}
+
+// gstr2bEntry is a B2B invoice reported by a supplier in GSTR-2B
+type gstr2bEntry struct {
+	row           int
+	SupplierGSTIN string  `json:"supplier_gstin"`
+	SupplierName  string  `json:"supplier_name"`
+	DocNo         string  `json:"invoice_no"`
+	Date          string  `json:"date"`
+	Value         float64 `json:"invoice_value"`
+	TaxableValue  float64 `json:"taxable_value"`
+	IGST          float64 `json:"igst"`
+	CGST          float64 `json:"cgst"`
+	SGST          float64 `json:"sgst"`
+	Cess          float64 `json:"cess"`
+	ReverseCharge bool    `json:"reverse_charge"`
+	ITCAvailable  bool    `json:"itc_available"`
+	Reason        string  `json:"reason,omitempty"`
+}
+
+// tax returns the entry's total tax
+func (e *gstr2bEntry) tax() float64 {
+	return models.Round(e.IGST+e.CGST+e.SGST+e.Cess, models.AmountDecimals)
+}
+
+// gstr2bStatement is a parsed GSTR-2B download
+type gstr2bStatement struct {
+	gstin   string
+	period  *time.Time
+	entries []*gstr2bEntry
+	// skipped counts documents other than B2B invoices, such as credit notes
+	skipped int
+}
+
+// gstr2bDocNoKey reduces an invoice number to upper-case letters and digits
+// without leading zeros, as suppliers and recipients often write the same
+// number with different separators or padding
+func gstr2bDocNoKey(docNo string) string {
+	var b strings.Builder
+	for _, r := range strings.ToUpper(docNo) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return strings.TrimLeft(b.String(), "0")
+}
+
+// parseGSTR2BDate reads a document date into the DD/MM/YYYY format of the app
+func parseGSTR2BDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	for _, layout := range gstr2bDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.Format(invoiceDateLayout), nil
+		}
+	}
+	return "", fmt.Errorf("%q is not a valid date", value)
+}
+
+// parseGSTR2BJSON reads the B2B invoices of a GSTR-2B JSON download
+func parseGSTR2BJSON(data []byte) (*gstr2bStatement, error) {
+	type item struct {
+		Txval float64 `json:"txval"`
+		Igst  float64 `json:"igst"`
+		Cgst  float64 `json:"cgst"`
+		Sgst  float64 `json:"sgst"`
+		Cess  float64 `json:"cess"`
+	}
+	type docdata struct {
+		B2B []struct {
+			Ctin  string `json:"ctin"`
+			Trdnm string `json:"trdnm"`
+			Inv   []struct {
+				Inum   string  `json:"inum"`
+				Dt     string  `json:"dt"`
+				Val    float64 `json:"val"`
+				Rev    string  `json:"rev"`
+				Itcavl string  `json:"itcavl"`
+				Rsn    string  `json:"rsn"`
+				Items  []item  `json:"items"`
+			} `json:"inv"`
+		} `json:"b2b"`
+		B2BA []json.RawMessage `json:"b2ba"`
+		CDNR []struct {
+			Nt []json.RawMessage `json:"nt"`
+		} `json:"cdnr"`
+	}
+	var doc struct {
+		Gstin   string  `json:"gstin"`
+		Rtnprd  string  `json:"rtnprd"`
+		Docdata docdata `json:"docdata"`
+		Data    *struct {
+			Gstin   string  `json:"gstin"`
+			Rtnprd  string  `json:"rtnprd"`
+			Docdata docdata `json:"docdata"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, newImportError(http.StatusBadRequest, "Invalid JSON format: %v", err)
+	}
+	// The portal wraps the statement in "data"
+	if doc.Data != nil {
+		doc.Gstin, doc.Rtnprd, doc.Docdata = doc.Data.Gstin, doc.Data.Rtnprd, doc.Data.Docdata
+	}
+
+	stmt := &gstr2bStatement{gstin: strings.ToUpper(doc.Gstin)}
+	if t, err := time.Parse(gstr2bPeriodLayout, doc.Rtnprd); err == nil {
+		stmt.period = &t
+	}
+	stmt.skipped = len(doc.Docdata.B2BA)
+	for _, cdnr := range doc.Docdata.CDNR {
+		stmt.skipped += len(cdnr.Nt)
+	}
+
+	row := 0
+	for _, supplier := range doc.Docdata.B2B {
+		for _, inv := range supplier.Inv {
+			row++
+			entry := &gstr2bEntry{
+				row:           row,
+				SupplierGSTIN: strings.ToUpper(supplier.Ctin),
+				SupplierName:  supplier.Trdnm,
+				DocNo:         inv.Inum,
+				Value:         inv.Val,
+				ReverseCharge: strings.EqualFold(inv.Rev, "Y"),
+				ITCAvailable:  !strings.EqualFold(inv.Itcavl, "N"),
+				Reason:        inv.Rsn,
+			}
+			date, err := parseGSTR2BDate(inv.Dt)
+			if err != nil {
+				return nil, newImportError(http.StatusBadRequest, "Invoice %s of %s: %v", inv.Inum, supplier.Ctin, err)
+			}
+			entry.Date = date
+			for _, it := range inv.Items {
+				entry.TaxableValue += it.Txval
+				entry.IGST += it.Igst
+				entry.CGST += it.Cgst
+				entry.SGST += it.Sgst
+				entry.Cess += it.Cess
+			}
+			entry.roundValues()
+			stmt.entries = append(stmt.entries, entry)
+		}
+	}
+	return stmt, nil
+}
+
+// roundValues rounds the summed amounts of an entry to paise
+func (e *gstr2bEntry) roundValues() {
+	for _, v := range []*float64{&e.Value, &e.TaxableValue, &e.IGST, &e.CGST, &e.SGST, &e.Cess} {
+		*v = models.Round(*v, models.AmountDecimals)
+	}
+}
+
+// resolveGSTR2BColumns finds the column of each field in the header row
+func resolveGSTR2BColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, h := range header {
+		key := normalizeHeader(h)
+		if key == "" {
+			continue
+		}
+		for _, f := range gstr2bFields {
+			if _, found := columns[f.name]; found {
+				continue
+			}
+			match := key == normalizeHeader(f.name) || key == normalizeHeader(f.label)
+			for _, alias := range f.aliases {
+				match = match || key == normalizeHeader(alias)
+			}
+			if match {
+				columns[f.name] = i
+				break
+			}
+		}
+	}
+	for _, f := range gstr2bFields {
+		if _, found := columns[f.name]; f.required && !found {
+			return nil, fmt.Errorf("no %q column found in the header row", f.label)
+		}
+	}
+	return columns, nil
+}
+
+// parseGSTR2BExcel reads the B2B sheet of a GSTR-2B Excel download. The
+// portal heads the sheet with notes and splits the header over two rows,
+// grouping e.g. the tax heads under "Tax Amount", so the header is found by
+// its "GSTIN of supplier" cell and each column is named by the lower row
+// where it has a name. Rows that cannot be read are listed on the report.
+func parseGSTR2BExcel(data []byte) (*gstr2bStatement, *uploadReport, error) {
+	xlsx, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, newImportError(http.StatusBadRequest, "Failed to parse Excel file")
+	}
+	defer xlsx.Close()
+
+	sheet := ""
+	for _, name := range xlsx.GetSheetList() {
+		if strings.EqualFold(strings.TrimSpace(name), "B2B") {
+			sheet = name
+		}
+	}
+	if sheet == "" {
+		return nil, nil, newImportError(http.StatusBadRequest, "No B2B sheet found; upload the GSTR-2B Excel downloaded from the GST portal")
+	}
+	rows, err := xlsx.GetRows(sheet)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read sheet: %w", err)
+	}
+
+	headerRow := -1
+	for i, cells := range rows {
+		for _, cell := range cells {
+			if normalizeHeader(cell) == normalizeHeader("GSTIN of supplier") {
+				headerRow = i
+			}
+		}
+		if headerRow >= 0 {
+			break
+		}
+	}
+	if headerRow < 0 {
+		return nil, nil, newImportError(http.StatusBadRequest, "No \"GSTIN of supplier\" header found in the B2B sheet")
+	}
+	header := append([]string{}, rows[headerRow]...)
+	dataStart := headerRow + 1
+	if headerRow+1 < len(rows) {
+		sub := rows[headerRow+1]
+		named := false
+		for i, cell := range sub {
+			if strings.TrimSpace(cell) == "" {
+				continue
+			}
+			named = true
+			for len(header) <= i {
+				header = append(header, "")
+			}
+			header[i] = cell
+		}
+		// The second header row holds names, not a GSTIN
+		if named && (len(sub) == 0 || !models.IsValidGSTIN(strings.TrimSpace(sub[0]))) {
+			dataStart++
+		}
+	}
+	columns, err := resolveGSTR2BColumns(header)
+	if err != nil {
+		return nil, nil, newImportError(http.StatusBadRequest, "Header row does not match the GSTR-2B format: %v", err)
+	}
+
+	report := newUploadReport(header, headerRow+1)
+	stmt := &gstr2bStatement{}
+	for i := dataStart; i < len(rows); i++ {
+		cells := rows[i]
+		if isBlankRow(cells) {
+			continue
+		}
+		rowNum := i + 1
+		get := func(field string) string {
+			col, found := columns[field]
+			if !found || col >= len(cells) {
+				return ""
+			}
+			return strings.TrimSpace(cells[col])
+		}
+		amount := func(field string) float64 {
+			value := strings.ReplaceAll(get(field), ",", "")
+			if value == "" {
+				return 0
+			}
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+				report.add(rowNum, get("doc_no"), columns[field], fmt.Sprintf("%q is not a number", get(field)))
+				return 0
+			}
+			return n
+		}
+
+		gstin := strings.ToUpper(get("gstin"))
+		if !models.IsValidGSTIN(gstin) {
+			// Totals and notes below the data
+			continue
+		}
+		entry := &gstr2bEntry{
+			row:           rowNum,
+			SupplierGSTIN: gstin,
+			SupplierName:  get("name"),
+			DocNo:         get("doc_no"),
+			Value:         amount("value"),
+			TaxableValue:  amount("taxable_value"),
+			IGST:          amount("igst"),
+			CGST:          amount("cgst"),
+			SGST:          amount("sgst"),
+			Cess:          amount("cess"),
+			ReverseCharge: strings.EqualFold(get("reverse_charge"), "Y") || strings.EqualFold(get("reverse_charge"), "Yes"),
+			ITCAvailable:  !strings.EqualFold(get("itc_available"), "N") && !strings.EqualFold(get("itc_available"), "No"),
+			Reason:        get("reason"),
+		}
+		date, err := parseGSTR2BDate(get("date"))
+		if err != nil {
+			report.add(rowNum, entry.DocNo, columns["date"], err.Error())
+			continue
+		}
+		entry.Date = date
+		entry.roundValues()
+		stmt.entries = append(stmt.entries, entry)
+	}
+	return stmt, report, nil
+}
+
+// itcDifference is an amount that differs between the books and GSTR-2B
+type itcDifference struct {
+	Field  string  `json:"field"`
+	Books  float64 `json:"books"`
+	GSTR2B float64 `json:"gstr2b"`
+}
+
+// itcMatch pairs a recorded purchase bill with its GSTR-2B entry
+type itcMatch struct {
+	PurchaseInvoiceID int             `json:"purchase_invoice_id"`
+	BillNo            string          `json:"bill_no"`
+	BillDate          string          `json:"bill_date"`
+	ITCClaimed        float64         `json:"itc_claimed"`
+	GSTR2B            *gstr2bEntry    `json:"gstr2b"`
+	Differences       []itcDifference `json:"differences,omitempty"`
+	// ITCBlocked is set when GSTR-2B marks the credit as not available
+	ITCBlocked bool   `json:"itc_blocked,omitempty"`
+	Link       string `json:"link"`
+}
+
+// itcBill is a recorded purchase bill as compared with GSTR-2B
+type itcBill struct {
+	ID            int     `json:"purchase_invoice_id"`
+	SupplierGSTIN string  `json:"supplier_gstin"`
+	SupplierName  string  `json:"supplier_name"`
+	BillNo        string  `json:"bill_no"`
+	BillDate      string  `json:"bill_date"`
+	TaxableValue  float64 `json:"taxable_value"`
+	IGST          float64 `json:"igst"`
+	CGST          float64 `json:"cgst"`
+	SGST          float64 `json:"sgst"`
+	Cess          float64 `json:"cess"`
+	ITCClaimed    float64 `json:"itc_claimed"`
+	billDate      time.Time
+	matched       bool
+}
+
+// loadITCBills reads the user's purchase bills from suppliers with a GSTIN,
+// received by recipientGSTIN when it is given
+func loadITCBills(ctx context.Context, userID int, recipientGSTIN string) ([]*itcBill, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, supplier_gstin, supplier_name, bill_no, bill_date, taxable_value::float8, igst::float8,
+			cgst::float8, sgst::float8, cess::float8, itc_available::float8
+		FROM purchase_invoices
+		WHERE user_id = $1 AND supplier_gstin <> '' AND ($2 = '' OR recipient_gstin = $2)
+		ORDER BY bill_date, id
+	`, userID, recipientGSTIN)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	bills := make([]*itcBill, 0)
+	for rows.Next() {
+		b := &itcBill{}
+		err := rows.Scan(&b.ID, &b.SupplierGSTIN, &b.SupplierName, &b.BillNo, &b.billDate, &b.TaxableValue,
+			&b.IGST, &b.CGST, &b.SGST, &b.Cess, &b.ITCClaimed)
+		if err != nil {
+			return nil, err
+		}
+		b.BillDate = b.billDate.Format(invoiceDateLayout)
+		bills = append(bills, b)
+	}
+	return bills, rows.Err()
+}
+
+// itcReconciliation sorts GSTR-2B entries and purchase bills into matches,
+// mismatches and either side's missing documents
+type itcReconciliation struct {
+	Matched        []*itcMatch    `json:"matched"`
+	Mismatched     []*itcMatch    `json:"mismatched"`
+	MissingInBooks []*gstr2bEntry `json:"missing_in_books"`
+	MissingIn2B    []*itcBill     `json:"missing_in_gstr2b"`
+}
+
+// reconcileITC matches each GSTR-2B entry to a purchase bill by supplier
+// GSTIN and invoice number. Amounts differing by more than TotalsTolerance
+// are mismatches. Bills that match no entry are listed as missing from
+// GSTR-2B when dated in or before its period, as the supplier has then not
+// reported them yet.
+func reconcileITC(stmt *gstr2bStatement, bills []*itcBill) *itcReconciliation {
+	r := &itcReconciliation{
+		Matched:        make([]*itcMatch, 0),
+		Mismatched:     make([]*itcMatch, 0),
+		MissingInBooks: make([]*gstr2bEntry, 0),
+		MissingIn2B:    make([]*itcBill, 0),
+	}
+	byKey := make(map[string]*itcBill, len(bills))
+	for _, b := range bills {
+		byKey[b.SupplierGSTIN+"|"+gstr2bDocNoKey(b.BillNo)] = b
+	}
+
+	for _, e := range stmt.entries {
+		b, found := byKey[e.SupplierGSTIN+"|"+gstr2bDocNoKey(e.DocNo)]
+		if !found || b.matched {
+			r.MissingInBooks = append(r.MissingInBooks, e)
+			continue
+		}
+		b.matched = true
+
+		m := &itcMatch{
+			PurchaseInvoiceID: b.ID,
+			BillNo:            b.BillNo,
+			BillDate:          b.BillDate,
+			ITCClaimed:        b.ITCClaimed,
+			GSTR2B:            e,
+			ITCBlocked:        !e.ITCAvailable && b.ITCClaimed > 0,
+			Link:              fmt.Sprintf("/api/v1/purchase-invoices/%d", b.ID),
+		}
+		compare := func(field string, books, gstr2b float64) {
+			if math.Abs(books-gstr2b) > models.TotalsTolerance {
+				m.Differences = append(m.Differences, itcDifference{Field: field, Books: books, GSTR2B: gstr2b})
+			}
+		}
+		compare("taxable_value", b.TaxableValue, e.TaxableValue)
+		compare("igst", b.IGST, e.IGST)
+		compare("cgst", b.CGST, e.CGST)
+		compare("sgst", b.SGST, e.SGST)
+		compare("cess", b.Cess, e.Cess)
+
+		if len(m.Differences) > 0 || m.ITCBlocked {
+			r.Mismatched = append(r.Mismatched, m)
+		} else {
+			r.Matched = append(r.Matched, m)
+		}
+	}
+
+	for _, b := range bills {
+		if b.matched {
+			continue
+		}
+		if stmt.period != nil && b.billDate.After(stmt.period.AddDate(0, 1, -1)) {
+			continue
+		}
+		r.MissingIn2B = append(r.MissingIn2B, b)
+	}
+	return r
+}
+
+// handleReconcileGSTR2B compares a GSTR-2B statement downloaded from the
+// GST portal, as JSON or Excel, with the recorded purchase bills. The
+// recipient GSTIN and return period are read from JSON downloads; for Excel
+// they may be given as the gstin and period (YYYY-MM) form fields. Without a
+// period, every unmatched bill is listed as missing from GSTR-2B. Only B2B
+// invoices are compared; amendments and credit notes are counted as skipped.
+func handleReconcileGSTR2B(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	file, data, ok := readUploadedFile(c, "file")
+	if !ok {
+		return
+	}
+
+	var stmt *gstr2bStatement
+	var report *uploadReport
+	var err error
+	switch strings.ToLower(filepath.Ext(file.Filename)) {
+	case ".json":
+		stmt, err = parseGSTR2BJSON(data)
+	case ".xlsx":
+		stmt, report, err = parseGSTR2BExcel(data)
+	default:
+		err = newImportError(http.StatusBadRequest, "Only GSTR-2B JSON (.json) and Excel (.xlsx) files are supported")
+	}
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	if gstin := strings.ToUpper(strings.TrimSpace(c.PostForm("gstin"))); gstin != "" {
+		stmt.gstin = gstin
+	}
+	if period := c.PostForm("period"); period != "" {
+		t, err := time.Parse(taxMonthLayout, period)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "period must be a month in YYYY-MM format"})
+			return
+		}
+		stmt.period = &t
+	}
+
+	bills, err := loadITCBills(ctx, userID, stmt.gstin)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase bills"})
+		return
+	}
+	result := reconcileITC(stmt, bills)
+
+	// Credit claimed in the books against what GSTR-2B makes available
+	var itcBooks, itc2B, overClaim int64
+	for _, m := range append(append([]*itcMatch{}, result.Matched...), result.Mismatched...) {
+		itcBooks += models.Paise(m.ITCClaimed)
+		if m.GSTR2B.ITCAvailable {
+			itc2B += models.Paise(m.GSTR2B.tax())
+			if excess := models.Paise(m.ITCClaimed) - models.Paise(m.GSTR2B.tax()); excess > 0 {
+				overClaim += excess
+			}
+		} else {
+			overClaim += models.Paise(m.ITCClaimed)
+		}
+	}
+	for _, b := range result.MissingIn2B {
+		itcBooks += models.Paise(b.ITCClaimed)
+		overClaim += models.Paise(b.ITCClaimed)
+	}
+	var unclaimed int64
+	for _, e := range result.MissingInBooks {
+		if e.ITCAvailable {
+			itc2B += models.Paise(e.tax())
+			unclaimed += models.Paise(e.tax())
+		}
+	}
+
+	period := ""
+	if stmt.period != nil {
+		period = stmt.period.Format(taxMonthLayout)
+	}
+	errors := []uploadRowError{}
+	if report != nil {
+		errors = report.sorted()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"gstin":             stmt.gstin,
+		"period":            period,
+		"matched":           result.Matched,
+		"mismatched":        result.Mismatched,
+		"missing_in_books":  result.MissingInBooks,
+		"missing_in_gstr2b": result.MissingIn2B,
+		"errors":            errors,
+		"summary": gin.H{
+			"gstr2b_invoices":   len(stmt.entries),
+			"skipped_documents": stmt.skipped,
+			"matched":           len(result.Matched),
+			"mismatched":        len(result.Mismatched),
+			"missing_in_books":  len(result.MissingInBooks),
+			"missing_in_gstr2b": len(result.MissingIn2B),
+			"itc_in_books":      models.Rupees(itcBooks),
+			"itc_in_gstr2b":     models.Rupees(itc2B),
+			"itc_over_claimed":  models.Rupees(overClaim),
+			"itc_unclaimed":     models.Rupees(unclaimed),
+		},
+	})
+}
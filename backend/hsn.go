@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// hsnMasterCSV is the bundled HSN/SAC master used to fill the hsn_codes
+// table: the chapters, the common headings and the SAC codes of services,
+// with the GST rate in force for each where one rate applies. Set
+// HSN_MASTER_FILE to load the full CBIC list instead.
+//
+//go:embed data/hsn_master.csv
+var hsnMasterCSV []byte
+
+// createHSNTables creates the HSN/SAC master table and loads the master
+func createHSNTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS hsn_codes (
+			code VARCHAR(8) PRIMARY KEY,
+			description TEXT NOT NULL,
+			gst_rate DECIMAL(5,2),
+			is_service BOOLEAN NOT NULL DEFAULT FALSE
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create hsn_codes table: %v", err)
+	}
+
+	if err := seedHSNCodes(); err != nil {
+		log.Printf("Error seeding HSN master: %v", err)
+	}
+}
+
+// seedHSNCodes loads the HSN/SAC master from HSN_MASTER_FILE or the bundled
+// list at every start, so a file configured later, or a newer bundled list,
+// updates codes already in the table
+func seedHSNCodes() error {
+	data := hsnMasterCSV
+	if path := os.Getenv("HSN_MASTER_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read HSN_MASTER_FILE: %w", err)
+		}
+		data = fileData
+	}
+
+	codes, err := parseHSNCSV(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, 0, len(codes))
+	for _, code := range codes {
+		rows = append(rows, []interface{}{code.Code, code.Description, code.GSTRate, code.IsService})
+	}
+
+	loaded, err := upsertMasterRows(context.Background(), masterTable{
+		Name:    "hsn_codes",
+		Key:     []string{"code"},
+		Columns: []string{"code", "description", "gst_rate", "is_service"},
+	}, rows)
+	if err != nil {
+		return fmt.Errorf("failed to load HSN codes: %w", err)
+	}
+
+	if loaded > 0 {
+		log.Printf("Loaded %d new or changed HSN/SAC codes", loaded)
+	}
+	return nil
+}
+
+// parseHSNCSV reads code,description,gst_rate,is_service rows with a header line
+func parseHSNCSV(r io.Reader) ([]models.HSNCode, error) {
+	reader := csv.NewReader(r)
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid HSN master CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, errors.New("HSN master CSV has no data rows")
+	}
+
+	codes := make([]models.HSNCode, 0, len(records)-1)
+	for i, record := range records[1:] {
+		if len(record) < 4 {
+			return nil, fmt.Errorf("HSN master row %d does not have enough columns", i+2)
+		}
+
+		code := models.HSNCode{
+			Code:        strings.TrimSpace(record[0]),
+			Description: strings.TrimSpace(record[1]),
+			IsService:   strings.EqualFold(strings.TrimSpace(record[3]), "Y"),
+		}
+		if rate := strings.TrimSpace(record[2]); rate != "" {
+			parsed, err := strconv.ParseFloat(rate, 64)
+			if err != nil {
+				return nil, fmt.Errorf("HSN master row %d has an invalid GST rate", i+2)
+			}
+			code.GSTRate = &parsed
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// findHSNCode returns the most specific master entry matching the given code
+func findHSNCode(ctx context.Context, code string) (*models.HSNCode, error) {
+	var h models.HSNCode
+	err := dbPool.QueryRow(ctx, `
+		SELECT code, description, gst_rate::float8, is_service
+		FROM hsn_codes
+		WHERE $1 LIKE code || '%'
+		ORDER BY LENGTH(code) DESC
+		LIMIT 1
+	`, code).Scan(&h.Code, &h.Description, &h.GSTRate, &h.IsService)
+	if err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// checkHSNCodes validates item HSN codes against the master and returns
+// warnings for items whose GST rate differs from the rate in force for the
+// HSN code on the invoice date, or else from the suggested rate
+func checkHSNCodes(ctx context.Context, invoice *models.EInvoice) ([]string, error) {
+	schedule, err := loadGSTRateSchedule(ctx)
+	if err != nil {
+		log.Printf("Error loading GST rate master: %v", err)
+		schedule = &gstRateSchedule{}
+	}
+	date := gstRateDate(invoice)
+
+	warnings := make([]string, 0)
+	for _, item := range invoice.ItemList {
+		hsn, err := findHSNCode(ctx, item.HsnCd)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("item %s: HSN code %s not found in HSN master", item.SlNo, item.HsnCd)
+		}
+		if err != nil {
+			log.Printf("Error looking up HSN code %s: %v", item.HsnCd, err)
+			continue
+		}
+
+		if r := schedule.rateOn(item.HsnCd, date); r != nil {
+			if r.Rate != item.GstRt {
+				warnings = append(warnings, fmt.Sprintf(
+					"item %s: GST rate %.2f%% differs from %.2f%% in force for HSN %s from %s",
+					item.SlNo, item.GstRt, r.Rate, r.HSNPrefix, r.EffectiveFrom))
+			}
+			continue
+		}
+		if hsn.GSTRate != nil && *hsn.GSTRate != item.GstRt {
+			warnings = append(warnings, fmt.Sprintf(
+				"item %s: GST rate %.2f%% differs from %.2f%% suggested for HSN %s",
+				item.SlNo, item.GstRt, *hsn.GSTRate, hsn.Code))
+		}
+	}
+	return warnings, nil
+}
+
+// handleSearchHSN searches the HSN/SAC master by code prefix or description
+func handleSearchHSN(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if len(query) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query must be at least 2 characters"})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT code, description, gst_rate::float8, is_service
+		FROM hsn_codes
+		WHERE code LIKE $1 || '%' OR description ILIKE '%' || $1 || '%'
+		ORDER BY (code LIKE $1 || '%') DESC, LENGTH(code), code
+		LIMIT $2
+	`, query, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search HSN codes"})
+		return
+	}
+	defer rows.Close()
+
+	codes := make([]models.HSNCode, 0)
+	for rows.Next() {
+		var h models.HSNCode
+		if err := rows.Scan(&h.Code, &h.Description, &h.GSTRate, &h.IsService); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read HSN data"})
+			return
+		}
+		codes = append(codes, h)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": codes})
+}
+
+// handleGetHSN returns the best matching master entry and suggested GST rate
+// for a code: the rate in force on date (DD/MM/YYYY, default today) in the
+// GST rate master, or else the HSN master's rate
+func handleGetHSN(c *gin.Context) {
+	ctx := c.Request.Context()
+	code := c.Param("code")
+	if !models.IsValidHSNFormat(code) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "HSN code must be 4, 6 or 8 digits"})
+		return
+	}
+	date := time.Now().In(istLocation)
+	if value := c.Query("date"); value != "" {
+		d, err := time.Parse(invoiceDateLayout, value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "date must be in DD/MM/YYYY format"})
+			return
+		}
+		date = d
+	}
+
+	hsn, err := findHSNCode(ctx, code)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "HSN code not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up HSN code"})
+		return
+	}
+
+	schedule, err := loadGSTRateSchedule(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up GST rate"})
+		return
+	}
+	response := gin.H{
+		"hsn":            hsn,
+		"suggested_rate": hsn.GSTRate,
+	}
+	if r := schedule.rateOn(code, date); r != nil {
+		response["suggested_rate"] = r.Rate
+		response["rate"] = r
+	}
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// htmlThemes are the colour schemes of the HTML invoice view. mono is best
+// for thermal and black and white printers.
+var htmlThemes = map[string]template.CSS{
+	"classic": "--accent: #1f4e79; --muted: #5f6b7a; --rule: #c9d3df; --band: #eef3f8;",
+	"modern":  "--accent: #0f766e; --muted: #6b7280; --rule: #d1d5db; --band: #f0fdfa;",
+	"mono":    "--accent: #000; --muted: #000; --rule: #000; --band: #fff;",
+}
+
+// htmlInvoiceStyles holds the CSS shared by all layouts
+const htmlInvoiceStyles = `{{define "styles"}}
+:root { {{.Theme}} }
+* { box-sizing: border-box; }
+body { margin: 0; font-family: "Helvetica Neue", Arial, sans-serif; color: #111; }
+h1 { margin: 0; color: var(--accent); letter-spacing: 0.05em; }
+table { width: 100%; border-collapse: collapse; }
+th { text-align: left; color: var(--accent); border-bottom: 1px solid var(--rule); }
+td { vertical-align: top; }
+.num { text-align: right; white-space: nowrap; }
+.muted { color: var(--muted); }
+.status { display: inline-block; padding: 2px 8px; border: 1px solid currentColor; text-transform: uppercase; }
+.total td { font-weight: bold; border-top: 1px solid var(--rule); }
+@media print { .no-print { display: none; } }
+{{end}}`
+
+// htmlInvoiceA4 is the full page layout for A4 printers and PDF printing from the browser
+const htmlInvoiceA4 = `{{define "a4"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Invoice.DocDtls.No}}</title>
+<style>
+{{template "styles" .}}
+@page { size: A4; margin: 12mm; }
+body { font-size: 12px; padding: 12mm; }
+@media print { body { padding: 0; } }
+header { display: flex; justify-content: space-between; align-items: flex-start; }
+header img { width: 120px; height: 120px; }
+.parties { display: flex; gap: 24px; margin: 16px 0; padding: 12px; background: var(--band); }
+.parties > div { flex: 1; }
+.items th, .items td { padding: 6px 4px; }
+.items tbody tr { border-bottom: 1px solid var(--rule); }
+.totals { width: 45%; margin-left: auto; margin-top: 12px; }
+.totals td { padding: 3px 4px; }
+footer { margin-top: 24px; font-size: 10px; }
+</style>
+</head>
+<body>
+<header>
+  <div>
+    <h1>{{.Title}}</h1>
+    <p>No: <strong>{{.Invoice.DocDtls.No}}</strong><br>
+    Date: {{.Invoice.DocDtls.Dt}}<br>
+    Supply type: {{.Invoice.TranDtls.SupTyp}}{{if .Invoice.IsReverseCharge}}<br>
+    <strong>Tax payable on reverse charge</strong>{{end}}</p>
+    {{if .Irn}}<p class="muted">IRN: {{.Irn}}{{if .AckNo}}<br>Ack No: {{.AckNo}}{{end}}{{if .AckDt}} &middot; Ack Date: {{.AckDt}}{{end}}</p>{{end}}
+    {{if ne .Status "finalized"}}<span class="status">{{.Status}}</span>{{end}}
+  </div>
+  {{if .QRCode}}<img src="{{.QRCode}}" alt="QR code">{{end}}
+</header>
+<section class="parties">
+  <div>
+    <strong>Seller</strong><br>
+    {{with .Invoice.SellerDtls}}{{.LglNm}}<br>{{.Addr1}}{{if .Addr2}}<br>{{.Addr2}}{{end}}<br>{{.Loc}} {{.Pin}}<br>
+    GSTIN: {{.Gstin}}<br>State code: {{.Stcd}}{{end}}
+  </div>
+  <div>
+    <strong>Buyer</strong><br>
+    {{with .Invoice.BuyerDtls}}{{.LglNm}}<br>{{.Addr1}}{{if .Addr2}}<br>{{.Addr2}}{{end}}<br>{{.Loc}} {{.Pin}}<br>{{end}}
+    GSTIN: {{.BuyerGSTIN}}<br>State code: {{.Invoice.BuyerDtls.Stcd}} &middot; Place of supply: {{.Invoice.BuyerDtls.Pos}}
+  </div>
+</section>
+<table class="items">
+  <thead><tr>
+    <th>#</th><th>Description</th><th>HSN</th><th class="num">Qty</th><th>Unit</th>
+    <th class="num">Rate</th><th class="num">Taxable</th><th class="num">GST %</th><th class="num">Total</th>
+  </tr></thead>
+  <tbody>
+  {{range .Invoice.ItemList}}<tr>
+    <td>{{.SlNo}}</td><td>{{.PrdDesc}}</td><td>{{.HsnCd}}</td><td class="num">{{number .Qty}}</td><td>{{.Unit}}</td>
+    <td class="num">{{number .UnitPrice}}</td><td class="num">{{amount .AssAmt}}</td><td class="num">{{if .IsExempt}}{{.ExemptionLabel}}{{else}}{{number .GstRt}}{{end}}</td><td class="num">{{amount .TotItemVal}}</td>
+  </tr>{{end}}
+  </tbody>
+</table>
+<table class="totals">
+  <tr><td>Taxable value</td><td class="num">{{amount .Tax.TaxableValue}}</td></tr>
+  {{with .Invoice.ExemptValue}}<tr><td>Nil-rated, exempt and non-GST value</td><td class="num">{{amount .}}</td></tr>{{end}}
+  {{if .IntraState}}<tr><td>CGST</td><td class="num">{{amount .Tax.CGST}}</td></tr>
+  <tr><td>SGST</td><td class="num">{{amount .Tax.SGST}}</td></tr>
+  {{else}}<tr><td>IGST</td><td class="num">{{amount .Tax.IGST}}</td></tr>{{end}}
+  {{with .Invoice.ValDtls.RndOffAmt}}<tr><td>Round off</td><td class="num">{{amount .}}</td></tr>{{end}}
+  <tr class="total"><td>Invoice value</td><td class="num">{{amount .Invoice.ValDtls.TotInvVal}}</td></tr>
+  {{if .Invoice.IsReverseCharge}}<tr class="total"><td>Amount payable (excl. reverse charge tax)</td><td class="num">{{amount .Invoice.AmountPayable}}</td></tr>{{end}}
+</table>
+<p><strong>{{.AmountInWords}}</strong></p>
+{{with .LUTDeclaration}}<p>{{.}}</p>{{end}}
+{{with .Invoice.RefDtls}}{{if .InvRm}}<p>Remarks: {{.InvRm}}</p>{{end}}{{end}}
+<footer class="muted">This is a computer generated document.</footer>
+<p class="no-print"><button onclick="window.print()">Print</button></p>
+</body>
+</html>
+{{end}}`
+
+// htmlInvoiceThermal is a narrow single column layout for 80mm receipt printers
+const htmlInvoiceThermal = `{{define "thermal"}}<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Title}} {{.Invoice.DocDtls.No}}</title>
+<style>
+{{template "styles" .}}
+@page { size: 80mm auto; margin: 3mm; }
+body { width: 74mm; font-size: 11px; font-family: "Courier New", monospace; }
+h1 { font-size: 14px; text-align: center; }
+.center { text-align: center; }
+hr { border: 0; border-top: 1px dashed var(--rule); }
+td, th { padding: 1px 0; }
+img { width: 40mm; height: 40mm; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p class="center">{{with .Invoice.SellerDtls}}<strong>{{firstNonEmpty .TrdNm .LglNm}}</strong><br>{{.Addr1}}<br>{{.Loc}} {{.Pin}}<br>GSTIN: {{.Gstin}}{{end}}</p>
+<hr>
+<p>No: {{.Invoice.DocDtls.No}}<br>Date: {{.Invoice.DocDtls.Dt}}<br>
+Buyer: {{.Invoice.BuyerDtls.LglNm}}<br>GSTIN: {{.BuyerGSTIN}}<br>Place of supply: {{.Invoice.BuyerDtls.Pos}}
+{{if ne .Status "finalized"}}<br><span class="status">{{.Status}}</span>{{end}}</p>
+<hr>
+<table>
+  {{range .Invoice.ItemList}}<tr><td colspan="2">{{.PrdDesc}}</td></tr>
+  <tr><td class="muted">{{number .Qty}} {{.Unit}} x {{number .UnitPrice}} @ {{if .IsExempt}}{{.ExemptionLabel}}{{else}}{{number .GstRt}}%{{end}}</td><td class="num">{{amount .TotItemVal}}</td></tr>{{end}}
+</table>
+<hr>
+<table>
+  <tr><td>Taxable value</td><td class="num">{{amount .Tax.TaxableValue}}</td></tr>
+  {{with .Invoice.ExemptValue}}<tr><td>Nil-rated, exempt and non-GST value</td><td class="num">{{amount .}}</td></tr>{{end}}
+  {{if .IntraState}}<tr><td>CGST</td><td class="num">{{amount .Tax.CGST}}</td></tr>
+  <tr><td>SGST</td><td class="num">{{amount .Tax.SGST}}</td></tr>
+  {{else}}<tr><td>IGST</td><td class="num">{{amount .Tax.IGST}}</td></tr>{{end}}
+  {{with .Invoice.ValDtls.RndOffAmt}}<tr><td>Round off</td><td class="num">{{amount .}}</td></tr>{{end}}
+  <tr class="total"><td>Total</td><td class="num">{{amount .Invoice.ValDtls.TotInvVal}}</td></tr>
+  {{if .Invoice.IsReverseCharge}}<tr class="total"><td>Payable (excl. RCM tax)</td><td class="num">{{amount .Invoice.AmountPayable}}</td></tr>{{end}}
+</table>
+<p>{{.AmountInWords}}</p>
+{{with .LUTDeclaration}}<p class="muted">{{.}}</p>{{end}}
+{{if .QRCode}}<p class="center"><img src="{{.QRCode}}" alt="QR code"></p>{{end}}
+{{if .Irn}}<p class="muted" style="word-break: break-all">IRN: {{.Irn}}</p>{{end}}
+<p class="center muted">This is a computer generated document.</p>
+</body>
+</html>
+{{end}}`
+
+// htmlInvoiceTemplates holds the layouts selectable with the template parameter
+var htmlInvoiceTemplates = template.Must(template.New("invoice").Funcs(template.FuncMap{
+	"amount":        func(v float64) string { return fmt.Sprintf("%.2f", v) },
+	"number":        func(v float64) string { return strconv.FormatFloat(v, 'f', -1, 64) },
+	"firstNonEmpty": firstNonEmpty,
+}).Parse(htmlInvoiceStyles + htmlInvoiceA4 + htmlInvoiceThermal))
+
+// htmlInvoiceView is the data rendered by the HTML invoice templates
+type htmlInvoiceView struct {
+	Invoice       *models.EInvoice
+	Title         string
+	Status        string
+	Irn           string
+	AckNo         string
+	AckDt         string
+	BuyerGSTIN    string
+	QRCode        template.URL
+	Tax           taxHeads
+	IntraState    bool
+	AmountInWords string
+	// LUTDeclaration is printed on supplies without payment of tax
+	LUTDeclaration string
+	Theme          template.CSS
+}
+
+// handleGetInvoiceHTML renders a print-optimized HTML copy of an invoice.
+// template selects the a4 or thermal layout and theme its colour scheme.
+func handleGetInvoiceHTML(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	layout := c.DefaultQuery("template", "a4")
+	if layout != "a4" && layout != "thermal" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "template must be a4 or thermal"})
+		return
+	}
+	defaultTheme := "classic"
+	if layout == "thermal" {
+		defaultTheme = "mono"
+	}
+	theme, ok := htmlThemes[c.DefaultQuery("theme", defaultTheme)]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "theme must be classic, modern or mono"})
+		return
+	}
+	wordsLang := c.DefaultQuery("words_lang", models.WordsLangEnglish)
+	if wordsLang != models.WordsLangEnglish && wordsLang != models.WordsLangHindi {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "words_lang must be en or hi"})
+		return
+	}
+
+	var invoiceJSON []byte
+	var view htmlInvoiceView
+	var ackDt *time.Time
+	err = dbPool.QueryRow(c.Request.Context(), `
+		SELECT invoice_json, status, COALESCE(irn, ''), COALESCE(ack_no, ''), ack_dt
+		FROM invoices WHERE id = $1 AND user_id = $2
+	`, id, userID).Scan(&invoiceJSON, &view.Status, &view.Irn, &view.AckNo, &ackDt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+
+	view.Invoice = &invoice
+	view.Theme = theme
+	view.Title = pdfDocumentTitles[invoice.DocDtls.Typ]
+	if view.Title == "" {
+		view.Title = "TAX INVOICE"
+	}
+	if ackDt != nil {
+		view.AckDt = ackDt.In(istLocation).Format(irpTimeLayout)
+	}
+	view.BuyerGSTIN = firstNonEmpty(invoice.BuyerDtls.Gstin, "URP")
+	view.Tax = invoiceTaxHeads(&invoice)
+	view.IntraState = !invoice.IsInterState()
+	view.LUTDeclaration = lutDeclaration(&invoice)
+	view.AmountInWords = models.AmountInWords(invoice.ValDtls.TotInvVal, wordsLang)
+
+	// Drafts have no QR code until they are finalized
+	if view.Status != models.InvoiceStatusDraft && view.Status != models.InvoiceStatusPendingApproval {
+		png, err := generateInvoiceQR(&invoice)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+			return
+		}
+		view.QRCode = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+	}
+
+	var out bytes.Buffer
+	if err := htmlInvoiceTemplates.ExecuteTemplate(&out, layout, view); err != nil {
+		log.Printf("Error rendering invoice %d as HTML: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render invoice"})
+		return
+	}
+
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Content-Security-Policy", htmlViewCSP)
+	c.Data(http.StatusOK, "text/html; charset=utf-8", out.Bytes())
+}
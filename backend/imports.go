@@ -0,0 +1,366 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// jobTypeRetryImport is the job type that re-runs an import from its stored file
+const jobTypeRetryImport = "import.retry"
+
+// importColumns is the column list used when reading import records, without the file itself
+const importColumns = `id, user_id, kind, filename, content_type, size, sha256, status,
+	imported_count, failed_rows, attempts, result, job_id, created_at, updated_at`
+
+// importError is an import failure caused by the uploaded content, reported
+// to the client with the given HTTP status and, when the import was rolled
+// back, the invoice that failed
+type importError struct {
+	status     int
+	message    string
+	failed     gin.H
+	violations []schemaViolation
+	// period is the locked period the failed invoice is dated in
+	period string
+}
+
+func (e *importError) Error() string { return e.message }
+
+// newImportError returns an importError with a formatted message
+func newImportError(status int, format string, args ...interface{}) error {
+	return &importError{status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// importOutcome is the result of an import run, kept on the import record
+type importOutcome struct {
+	Invoices   []gin.H           `json:"invoices"`
+	Errors     []uploadRowError  `json:"errors,omitempty"`
+	Summary    gin.H             `json:"summary,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	Violations []schemaViolation `json:"violations,omitempty"`
+}
+
+// createImportTables creates the table holding uploaded files and their import outcome
+func createImportTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS imports (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			kind VARCHAR(20) NOT NULL,
+			filename VARCHAR(255) NOT NULL,
+			content_type VARCHAR(255) NOT NULL,
+			size INTEGER NOT NULL,
+			sha256 VARCHAR(64) NOT NULL,
+			data BYTEA NOT NULL,
+			mapping JSONB,
+			status VARCHAR(20) NOT NULL DEFAULT 'processing',
+			imported_count INTEGER NOT NULL DEFAULT 0,
+			failed_rows INTEGER NOT NULL DEFAULT 0,
+			attempts INTEGER NOT NULL DEFAULT 1,
+			result JSONB,
+			job_id INTEGER REFERENCES jobs(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create imports table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_imports_user ON imports (user_id, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create imports index: %v", err)
+	}
+
+	registerJobHandler(jobTypeRetryImport, runRetryImportJob)
+}
+
+// scanImport reads an import row selected with importColumns
+func scanImport(row pgx.Row) (*models.Import, error) {
+	var imp models.Import
+	var result []byte
+	err := row.Scan(&imp.ID, &imp.UserID, &imp.Kind, &imp.Filename, &imp.ContentType, &imp.Size,
+		&imp.SHA256, &imp.Status, &imp.ImportedCount, &imp.FailedRows, &imp.Attempts, &result,
+		&imp.JobID, &imp.CreatedAt, &imp.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	imp.Result = result
+	return &imp, nil
+}
+
+// saveImport stores an uploaded file before it is imported, together with the
+// column mapping it was read with, and returns the import ID
+func saveImport(ctx context.Context, userID int, kind, filename, contentType string, data []byte, mapping *models.ExcelMapping) (int, error) {
+	var mappingJSON []byte
+	if mapping != nil {
+		var err error
+		if mappingJSON, err = json.Marshal(mapping); err != nil {
+			return 0, err
+		}
+	}
+
+	sum := sha256.Sum256(data)
+	var id int
+	err := dbPool.QueryRow(ctx, `
+		INSERT INTO imports (user_id, kind, filename, content_type, size, sha256, data, mapping)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, userID, kind, filename, contentType, len(data), hex.EncodeToString(sum[:]), data, mappingJSON).Scan(&id)
+	return id, err
+}
+
+// finishImport records the outcome of an import run
+func finishImport(ctx context.Context, id, imported, failedRows int, outcome *importOutcome) {
+	status := models.ImportStatusCompleted
+	switch {
+	case imported == 0:
+		status = models.ImportStatusFailed
+	case outcome.Error != "" || failedRows > 0:
+		status = models.ImportStatusPartial
+	}
+
+	result, err := json.Marshal(outcome)
+	if err != nil {
+		log.Printf("Error serializing outcome of import %d: %v", id, err)
+		return
+	}
+	// Invoices already stored must be accounted for even when the client went away
+	_, err = dbPool.Exec(context.WithoutCancel(ctx), `
+		UPDATE imports
+		SET status = $1, imported_count = $2, failed_rows = $3, result = $4, updated_at = NOW()
+		WHERE id = $5
+	`, status, imported, failedRows, result, id)
+	if err != nil {
+		log.Printf("Error recording outcome of import %d: %v", id, err)
+	}
+}
+
+// respondImportError writes the response for a failed import
+func respondImportError(c *gin.Context, err error) {
+	var ie *importError
+	if errors.As(err, &ie) {
+		body := gin.H{"error": ie.message}
+		if ie.failed != nil {
+			body["failed_invoice"] = ie.failed
+		}
+		if ie.violations != nil {
+			body["violations"] = ie.violations
+		}
+		if ie.period != "" {
+			body["period_locked"], body["period"] = true, ie.period
+		}
+		c.JSON(ie.status, body)
+		return
+	}
+	log.Printf("Error importing invoices: %v", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import invoices"})
+}
+
+// runImport imports a stored file, returning the outcome and the number of
+// invoices imported and rows that failed
+func runImport(ctx context.Context, userID int, kind string, data, mappingJSON []byte) (*importOutcome, int, int, error) {
+	switch kind {
+	case models.ImportKindExcel:
+		mapping := defaultExcelMapping
+		if len(mappingJSON) > 0 {
+			mapping = &models.ExcelMapping{}
+			if err := json.Unmarshal(mappingJSON, mapping); err != nil {
+				return nil, 0, 0, fmt.Errorf("invalid stored mapping: %w", err)
+			}
+		}
+		result, err := importExcel(ctx, userID, data, mapping)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		return result.outcome(), len(result.results), len(result.report.failed), nil
+	case models.ImportKindJSON:
+		results, _, err := importJSONInvoices(ctx, userID, data)
+		outcome := &importOutcome{Invoices: results}
+		var ie *importError
+		if errors.As(err, &ie) {
+			outcome.Error = ie.message
+			outcome.Violations = ie.violations
+			return outcome, len(results), 0, nil
+		}
+		return outcome, len(results), 0, err
+	}
+	return nil, 0, 0, fmt.Errorf("unknown import kind %q", kind)
+}
+
+// runRetryImportJob re-runs an import from the file stored with it
+func runRetryImportJob(ctx context.Context, job *models.Job) error {
+	var payload struct {
+		ImportID int `json:"import_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	var userID int
+	var kind string
+	var data, mappingJSON []byte
+	err := dbPool.QueryRow(ctx,
+		"SELECT user_id, kind, data, mapping FROM imports WHERE id = $1",
+		payload.ImportID).Scan(&userID, &kind, &data, &mappingJSON)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return permanentError(fmt.Errorf("import %d not found", payload.ImportID))
+	}
+	if err != nil {
+		return err
+	}
+
+	outcome, imported, failedRows, err := runImport(ctx, userID, kind, data, mappingJSON)
+	// Problems with the file fail for good; a batch rolled back by a database
+	// error is retried
+	var ie *importError
+	if errors.As(err, &ie) && ie.status < http.StatusInternalServerError {
+		finishImport(ctx, payload.ImportID, 0, 0, &importOutcome{Invoices: []gin.H{}, Error: ie.message})
+		return permanentError(err)
+	}
+	if err != nil {
+		return err
+	}
+	finishImport(ctx, payload.ImportID, imported, failedRows, outcome)
+	return nil
+}
+
+// handleGetImports lists the user's uploads, newest first
+func handleGetImports(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "50"))
+	if err != nil || limit < 1 || limit > 500 {
+		limit = 50
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT `+importColumns+`
+		FROM imports
+		WHERE user_id = $1
+		ORDER BY created_at DESC
+		LIMIT $2
+	`, userID, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch imports"})
+		return
+	}
+	defer rows.Close()
+
+	imports := make([]*models.Import, 0)
+	for rows.Next() {
+		imp, err := scanImport(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read import data"})
+			return
+		}
+		// The outcome can be large; it is returned by the single import endpoint
+		imp.Result = nil
+		imports = append(imports, imp)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"imports": imports})
+}
+
+// getImportParam loads the import named by the :id route parameter, writing
+// the error response and returning nil when it is invalid or not the user's
+func getImportParam(c *gin.Context) *models.Import {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid import ID"})
+		return nil
+	}
+
+	imp, err := scanImport(dbPool.QueryRow(c.Request.Context(),
+		"SELECT "+importColumns+" FROM imports WHERE id = $1 AND user_id = $2", id, c.GetInt("userID")))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import not found or not authorized"})
+		return nil
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return nil
+	}
+	return imp
+}
+
+// handleGetImport returns an upload with the outcome of its last import run
+func handleGetImport(c *gin.Context) {
+	imp := getImportParam(c)
+	if imp == nil {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"import": imp})
+}
+
+// handleDownloadImportFile returns the file exactly as it was uploaded
+func handleDownloadImportFile(c *gin.Context) {
+	imp := getImportParam(c)
+	if imp == nil {
+		return
+	}
+
+	var data []byte
+	err := dbPool.QueryRow(c.Request.Context(),
+		"SELECT data FROM imports WHERE id = $1", imp.ID).Scan(&data)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read uploaded file"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", imp.Filename))
+	c.Header("ETag", `"`+imp.SHA256+`"`)
+	c.Data(http.StatusOK, imp.ContentType, data)
+}
+
+// handleRetryImport queues an import to be run again from its stored file
+func handleRetryImport(c *gin.Context) {
+	imp := getImportParam(c)
+	if imp == nil {
+		return
+	}
+	if imp.Status == models.ImportStatusQueued {
+		c.JSON(http.StatusConflict, gin.H{"error": "A retry is already queued for this import"})
+		return
+	}
+	if imp.Status == models.ImportStatusCompleted {
+		c.JSON(http.StatusConflict, gin.H{"error": "Import already completed without errors"})
+		return
+	}
+
+	jobID, err := enqueueJob(c.Request.Context(), imp.UserID, jobTypeRetryImport,
+		gin.H{"import_id": imp.ID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue retry"})
+		return
+	}
+
+	_, err = dbPool.Exec(c.Request.Context(), `
+		UPDATE imports
+		SET status = 'queued', job_id = $1, attempts = attempts + 1, updated_at = NOW()
+		WHERE id = $2
+	`, jobID, imp.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update import"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":   "Import retry queued",
+		"import_id": imp.ID,
+		"job_id":    jobID,
+	})
+}
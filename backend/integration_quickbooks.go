@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+)
+
+// quickBooksMinorVersion is the QuickBooks Online API minor version requested
+const quickBooksMinorVersion = "75"
+
+// quickBooksTaxRateRegex finds the rate in tax code names such as "IGST 18%"
+var quickBooksTaxRateRegex = regexp.MustCompile(`(\d+(?:\.\d+)?)\s*%`)
+
+// quickBooksProvider returns QuickBooks Online. QUICKBOOKS_API_URL selects
+// the sandbox API for development companies.
+func quickBooksProvider() *integrationProvider {
+	return &integrationProvider{
+		name:         models.IntegrationQuickBooks,
+		label:        "QuickBooks Online",
+		authURL:      "https://appcenter.intuit.com/connect/oauth2",
+		tokenURL:     "https://oauth.platform.intuit.com/oauth2/v1/tokens/bearer",
+		apiURL:       strings.TrimRight(getEnvWithDefault("QUICKBOOKS_API_URL", "https://quickbooks.api.intuit.com"), "/") + "/v3/company",
+		scope:        "com.intuit.quickbooks.accounting",
+		clientID:     os.Getenv("QUICKBOOKS_CLIENT_ID"),
+		clientSecret: os.Getenv("QUICKBOOKS_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("QUICKBOOKS_REDIRECT_URL"),
+		authScheme:   "Bearer",
+		basicAuth:    true,
+		organization: quickBooksRealm,
+		push:         quickBooksPushInvoice,
+		errorMessage: quickBooksErrorMessage,
+	}
+}
+
+// quickBooksRef references another QuickBooks entity by ID
+type quickBooksRef struct {
+	Value string `json:"value"`
+}
+
+// quickBooksEntity holds the fields read from entities found by a query
+type quickBooksEntity struct {
+	ID          string `json:"Id"`
+	Name        string `json:"Name"`
+	DisplayName string `json:"DisplayName"`
+}
+
+// quickBooksErrorMessage reads the message of a QuickBooks Online fault
+func quickBooksErrorMessage(body []byte) string {
+	var resp struct {
+		Fault struct {
+			Error []struct {
+				Message string `json:"Message"`
+				Detail  string `json:"Detail"`
+			} `json:"Error"`
+		} `json:"Fault"`
+	}
+	if json.Unmarshal(body, &resp) == nil && len(resp.Fault.Error) > 0 {
+		e := resp.Fault.Error[0]
+		if e.Detail != "" {
+			return e.Message + ": " + e.Detail
+		}
+		return e.Message
+	}
+	return "unexpected response"
+}
+
+// quickBooksRealm returns the company the user picked on the consent
+// screen, which Intuit passes to the callback as realmId
+func quickBooksRealm(_ context.Context, _ *integrationConn, callback url.Values) (string, error) {
+	realm := callback.Get("realmId")
+	if realm == "" {
+		return "", errors.New("QuickBooks returned no company")
+	}
+	return realm, nil
+}
+
+// quickBooksPath returns the API path of a resource of the connected company
+func quickBooksPath(conn *integrationConn, resource string) string {
+	return "/" + url.PathEscape(conn.orgID) + "/" + resource
+}
+
+// quickBooksParams returns the query parameters of every API call
+func quickBooksParams() url.Values {
+	return url.Values{"minorversion": {quickBooksMinorVersion}}
+}
+
+// quickBooksQuote quotes a string literal of a QuickBooks query
+func quickBooksQuote(s string) string {
+	return "'" + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), "'", `\'`) + "'"
+}
+
+// quickBooksQuery runs a query for entities of a type
+func quickBooksQuery(ctx context.Context, conn *integrationConn, entity, query string) ([]quickBooksEntity, error) {
+	params := quickBooksParams()
+	params.Set("query", query)
+	var resp struct {
+		QueryResponse map[string]json.RawMessage `json:"QueryResponse"`
+	}
+	if err := conn.call(ctx, http.MethodGet, quickBooksPath(conn, "query"), params, nil, &resp); err != nil {
+		return nil, err
+	}
+	var entities []quickBooksEntity
+	if raw, ok := resp.QueryResponse[entity]; ok {
+		if err := json.Unmarshal(raw, &entities); err != nil {
+			return nil, fmt.Errorf("invalid %s query response: %w", entity, err)
+		}
+	}
+	return entities, nil
+}
+
+// quickBooksCreate creates an entity and returns its ID
+func quickBooksCreate(ctx context.Context, conn *integrationConn, entity string, body interface{}) (string, error) {
+	var resp map[string]quickBooksEntity
+	err := conn.call(ctx, http.MethodPost, quickBooksPath(conn, strings.ToLower(entity)), quickBooksParams(), body, &resp)
+	if err != nil {
+		return "", err
+	}
+	id := resp[entity].ID
+	if id == "" {
+		return "", fmt.Errorf("QuickBooks returned no %s ID", strings.ToLower(entity))
+	}
+	return id, nil
+}
+
+// quickBooksLoadTaxes returns the GST and IGST tax codes set up in QuickBooks,
+// whose names carry their rate
+func quickBooksLoadTaxes(ctx context.Context, conn *integrationConn) ([]integrationTax, error) {
+	codes, err := quickBooksQuery(ctx, conn, "TaxCode", "SELECT * FROM TaxCode WHERE Active = true")
+	if err != nil {
+		return nil, err
+	}
+	taxes := make([]integrationTax, 0, len(codes))
+	for _, code := range codes {
+		name := strings.ToUpper(strings.TrimSpace(code.Name))
+		m := quickBooksTaxRateRegex.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		rate, _ := strconv.ParseFloat(m[1], 64)
+		switch {
+		case strings.HasPrefix(name, "IGST"):
+			taxes = append(taxes, integrationTax{id: code.ID, rate: rate, interState: true})
+		case strings.HasPrefix(name, "GST"):
+			taxes = append(taxes, integrationTax{id: code.ID, rate: rate})
+		}
+	}
+	return taxes, nil
+}
+
+// quickBooksGSTRegistration returns the QuickBooks GST registration type of a buyer
+func quickBooksGSTRegistration(invoice *models.EInvoice) string {
+	switch {
+	case strings.HasPrefix(invoice.TranDtls.SupTyp, "EXP"):
+		return "OVERSEAS"
+	case strings.HasPrefix(invoice.TranDtls.SupTyp, "SEZ"):
+		return "SEZ"
+	case invoice.BuyerDtls.Gstin == "" || invoice.BuyerDtls.Gstin == "URP":
+		return "CONSUMER"
+	}
+	return "GST_REG_REG"
+}
+
+// quickBooksCustomer returns the QuickBooks customer of an invoice's buyer,
+// creating it when no customer has the buyer's name
+func quickBooksCustomer(ctx context.Context, conn *integrationConn, invoice *models.EInvoice) (string, error) {
+	buyer := &invoice.BuyerDtls
+	name := truncate(buyer.LglNm, 100)
+	key := "customer:" + name
+	if id, ok := conn.ids[key]; ok {
+		return id, nil
+	}
+
+	found, err := quickBooksQuery(ctx, conn, "Customer",
+		"SELECT * FROM Customer WHERE DisplayName = "+quickBooksQuote(name))
+	if err != nil {
+		return "", err
+	}
+	if len(found) > 0 {
+		conn.ids[key] = found[0].ID
+		return found[0].ID, nil
+	}
+
+	customer := map[string]interface{}{
+		"DisplayName":         name,
+		"CompanyName":         name,
+		"GSTRegistrationType": quickBooksGSTRegistration(invoice),
+		"BillAddr": map[string]string{
+			"Line1":      buyer.Addr1,
+			"Line2":      buyer.Addr2,
+			"City":       buyer.Loc,
+			"PostalCode": strconv.Itoa(buyer.Pin),
+		},
+	}
+	if buyer.Gstin != "" && buyer.Gstin != "URP" {
+		customer["GSTIN"] = buyer.Gstin
+	}
+	if buyer.Em != "" {
+		customer["PrimaryEmailAddr"] = map[string]string{"Address": buyer.Em}
+	}
+	id, err := quickBooksCreate(ctx, conn, "Customer", customer)
+	if err != nil {
+		return "", err
+	}
+	conn.ids[key] = id
+	return id, nil
+}
+
+// quickBooksIncomeAccount returns the income account new items are booked to
+func quickBooksIncomeAccount(ctx context.Context, conn *integrationConn) (string, error) {
+	if id, ok := conn.ids["income_account"]; ok {
+		return id, nil
+	}
+	accounts, err := quickBooksQuery(ctx, conn, "Account",
+		"SELECT * FROM Account WHERE AccountType = 'Income' AND Active = true MAXRESULTS 1")
+	if err != nil {
+		return "", err
+	}
+	if len(accounts) == 0 {
+		return "", errors.New("no income account is set up in QuickBooks Online")
+	}
+	conn.ids["income_account"] = accounts[0].ID
+	return accounts[0].ID, nil
+}
+
+// quickBooksItem returns the QuickBooks item of an invoice line, creating it
+// when no item has the line's name
+func quickBooksItem(ctx context.Context, conn *integrationConn, item *models.Item) (string, error) {
+	// Colons separate parent and sub-item names in QuickBooks
+	name := truncate(strings.ReplaceAll(item.PrdDesc, ":", "-"), 100)
+	key := "item:" + name
+	if id, ok := conn.ids[key]; ok {
+		return id, nil
+	}
+
+	found, err := quickBooksQuery(ctx, conn, "Item", "SELECT * FROM Item WHERE Name = "+quickBooksQuote(name))
+	if err != nil {
+		return "", err
+	}
+	if len(found) > 0 {
+		conn.ids[key] = found[0].ID
+		return found[0].ID, nil
+	}
+
+	accountID, err := quickBooksIncomeAccount(ctx, conn)
+	if err != nil {
+		return "", err
+	}
+	itemType := "NonInventory"
+	if item.IsServc == "Y" {
+		itemType = "Service"
+	}
+	id, err := quickBooksCreate(ctx, conn, "Item", map[string]interface{}{
+		"Name":             name,
+		"Type":             itemType,
+		"UnitPrice":        item.UnitPrice,
+		"IncomeAccountRef": quickBooksRef{Value: accountID},
+	})
+	if err != nil {
+		return "", err
+	}
+	conn.ids[key] = id
+	return id, nil
+}
+
+// quickBooksPushInvoice creates an invoice in QuickBooks Online under its own
+// number. Lines are booked at their assessable value, net of discounts.
+func quickBooksPushInvoice(ctx context.Context, conn *integrationConn, invoice *models.EInvoice) (string, error) {
+	date, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+	if err != nil {
+		return "", errors.New("invoice has an invalid date")
+	}
+	customerID, err := quickBooksCustomer(ctx, conn, invoice)
+	if err != nil {
+		return "", err
+	}
+
+	interState := invoice.IsInterState()
+	lines := make([]map[string]interface{}, 0, len(invoice.ItemList))
+	for i := range invoice.ItemList {
+		item := &invoice.ItemList[i]
+		itemID, err := quickBooksItem(ctx, conn, item)
+		if err != nil {
+			return "", err
+		}
+		taxID, err := conn.findTax(ctx, item.GstRt, interState, quickBooksLoadTaxes)
+		if err != nil {
+			return "", err
+		}
+		detail := map[string]interface{}{
+			"ItemRef": quickBooksRef{Value: itemID},
+			"Qty":     item.Qty,
+		}
+		if item.Qty != 0 {
+			detail["UnitPrice"] = item.AssAmt / item.Qty
+		}
+		if taxID != "" {
+			detail["TaxCodeRef"] = quickBooksRef{Value: taxID}
+		}
+		lines = append(lines, map[string]interface{}{
+			"DetailType":          "SalesItemLineDetail",
+			"Amount":              models.Round(item.AssAmt, models.AmountDecimals),
+			"Description":         item.PrdDesc,
+			"SalesItemLineDetail": detail,
+		})
+	}
+
+	return quickBooksCreate(ctx, conn, "Invoice", map[string]interface{}{
+		"DocNumber":            invoice.DocDtls.No,
+		"TxnDate":              date.Format("2006-01-02"),
+		"CustomerRef":          quickBooksRef{Value: customerID},
+		"GlobalTaxCalculation": "TaxExcluded",
+		"Line":                 lines,
+	})
+}
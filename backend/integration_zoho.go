@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+)
+
+// zohoStateCodes maps GST state codes to the codes Zoho Books uses for places of supply
+var zohoStateCodes = map[string]string{
+	"01": "JK", "02": "HP", "03": "PB", "04": "CH", "05": "UK", "06": "HR", "07": "DL",
+	"08": "RJ", "09": "UP", "10": "BR", "11": "SK", "12": "AR", "13": "NL", "14": "MN",
+	"15": "MZ", "16": "TR", "17": "ML", "18": "AS", "19": "WB", "20": "JH", "21": "OD",
+	"22": "CG", "23": "MP", "24": "GJ", "26": "DN", "27": "MH", "29": "KA", "30": "GA",
+	"31": "LD", "32": "KL", "33": "TN", "34": "PY", "35": "AN", "36": "TS", "37": "AP",
+	"38": "LA", "97": "OT",
+}
+
+// zohoBooksProvider returns Zoho Books. It uses Zoho's India data centre
+// unless ZOHO_ACCOUNTS_URL and ZOHO_BOOKS_API_URL point to another one.
+func zohoBooksProvider() *integrationProvider {
+	accounts := strings.TrimRight(getEnvWithDefault("ZOHO_ACCOUNTS_URL", "https://accounts.zoho.in"), "/")
+	return &integrationProvider{
+		name:         models.IntegrationZohoBooks,
+		label:        "Zoho Books",
+		authURL:      accounts + "/oauth/v2/auth",
+		tokenURL:     accounts + "/oauth/v2/token",
+		apiURL:       strings.TrimRight(getEnvWithDefault("ZOHO_BOOKS_API_URL", "https://www.zohoapis.in/books/v3"), "/"),
+		scope:        "ZohoBooks.fullaccess.all",
+		clientID:     os.Getenv("ZOHO_CLIENT_ID"),
+		clientSecret: os.Getenv("ZOHO_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("ZOHO_REDIRECT_URL"),
+		authScheme:   "Zoho-oauthtoken",
+		// Offline access returns a refresh token, which Zoho only issues on consent
+		authParams:   url.Values{"access_type": {"offline"}, "prompt": {"consent"}},
+		organization: zohoOrganization,
+		push:         zohoPushInvoice,
+		errorMessage: zohoErrorMessage,
+	}
+}
+
+// zohoErrorMessage reads the message of a Zoho Books error response
+func zohoErrorMessage(body []byte) string {
+	var resp struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Message != "" {
+		return resp.Message
+	}
+	return "unexpected response"
+}
+
+// zohoParams returns the query parameters of a call on the connected organization
+func zohoParams(conn *integrationConn) url.Values {
+	return url.Values{"organization_id": {conn.orgID}}
+}
+
+// zohoGSTTreatment returns how Zoho Books treats the GST of a buyer
+func zohoGSTTreatment(invoice *models.EInvoice) string {
+	switch {
+	case strings.HasPrefix(invoice.TranDtls.SupTyp, "EXP"):
+		return "overseas"
+	case strings.HasPrefix(invoice.TranDtls.SupTyp, "SEZ"):
+		return "business_sez"
+	case invoice.BuyerDtls.Gstin == "" || invoice.BuyerDtls.Gstin == "URP":
+		return "consumer"
+	}
+	return "business_gst"
+}
+
+// zohoOrganization returns the user's default Zoho Books organization
+func zohoOrganization(ctx context.Context, conn *integrationConn, _ url.Values) (string, error) {
+	var resp struct {
+		Organizations []struct {
+			OrganizationID string `json:"organization_id"`
+			IsDefaultOrg   bool   `json:"is_default_org"`
+		} `json:"organizations"`
+	}
+	if err := conn.call(ctx, http.MethodGet, "/organizations", nil, nil, &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Organizations) == 0 {
+		return "", errors.New("no Zoho Books organization found")
+	}
+	for _, org := range resp.Organizations {
+		if org.IsDefaultOrg {
+			return org.OrganizationID, nil
+		}
+	}
+	return resp.Organizations[0].OrganizationID, nil
+}
+
+// zohoLoadTaxes returns the IGST taxes and the CGST and SGST tax groups set up in Zoho Books
+func zohoLoadTaxes(ctx context.Context, conn *integrationConn) ([]integrationTax, error) {
+	var resp struct {
+		Taxes []struct {
+			TaxID           string  `json:"tax_id"`
+			TaxPercentage   float64 `json:"tax_percentage"`
+			TaxType         string  `json:"tax_type"`
+			TaxSpecificType string  `json:"tax_specific_type"`
+		} `json:"taxes"`
+	}
+	if err := conn.call(ctx, http.MethodGet, "/settings/taxes", zohoParams(conn), nil, &resp); err != nil {
+		return nil, err
+	}
+	taxes := make([]integrationTax, 0, len(resp.Taxes))
+	for _, t := range resp.Taxes {
+		switch {
+		case t.TaxSpecificType == "igst":
+			taxes = append(taxes, integrationTax{id: t.TaxID, rate: t.TaxPercentage, interState: true})
+		case t.TaxType == "tax_group":
+			taxes = append(taxes, integrationTax{id: t.TaxID, rate: t.TaxPercentage})
+		}
+	}
+	return taxes, nil
+}
+
+// zohoCustomer returns the Zoho Books contact of an invoice's buyer, creating
+// it when no contact has the buyer's GSTIN or, for unregistered buyers, name
+func zohoCustomer(ctx context.Context, conn *integrationConn, invoice *models.EInvoice) (string, error) {
+	buyer := &invoice.BuyerDtls
+	key := "customer:" + buyer.Gstin + ":" + buyer.LglNm
+	if id, ok := conn.ids[key]; ok {
+		return id, nil
+	}
+
+	params := zohoParams(conn)
+	params.Set("search_text", buyer.LglNm)
+	var found struct {
+		Contacts []struct {
+			ContactID   string `json:"contact_id"`
+			ContactName string `json:"contact_name"`
+			GSTNo       string `json:"gst_no"`
+		} `json:"contacts"`
+	}
+	if err := conn.call(ctx, http.MethodGet, "/contacts", params, nil, &found); err != nil {
+		return "", err
+	}
+	registered := zohoGSTTreatment(invoice) == "business_gst"
+	for _, contact := range found.Contacts {
+		if (registered && strings.EqualFold(contact.GSTNo, buyer.Gstin)) ||
+			(!registered && strings.EqualFold(contact.ContactName, buyer.LglNm)) {
+			conn.ids[key] = contact.ContactID
+			return contact.ContactID, nil
+		}
+	}
+
+	contact := map[string]interface{}{
+		"contact_name":     buyer.LglNm,
+		"company_name":     buyer.LglNm,
+		"contact_type":     "customer",
+		"gst_treatment":    zohoGSTTreatment(invoice),
+		"place_of_contact": zohoStateCodes[buyer.Pos],
+		"billing_address": map[string]string{
+			"address": buyer.Addr1,
+			"street2": buyer.Addr2,
+			"city":    buyer.Loc,
+			"zip":     strconv.Itoa(buyer.Pin),
+		},
+	}
+	if registered || strings.HasPrefix(invoice.TranDtls.SupTyp, "SEZ") {
+		contact["gst_no"] = buyer.Gstin
+	}
+	var created struct {
+		Contact struct {
+			ContactID string `json:"contact_id"`
+		} `json:"contact"`
+	}
+	if err := conn.call(ctx, http.MethodPost, "/contacts", zohoParams(conn), contact, &created); err != nil {
+		return "", err
+	}
+	conn.ids[key] = created.Contact.ContactID
+	return created.Contact.ContactID, nil
+}
+
+// zohoItem returns the Zoho Books item of an invoice line, creating it when
+// no item has the line's name
+func zohoItem(ctx context.Context, conn *integrationConn, item *models.Item) (string, error) {
+	name := truncate(item.PrdDesc, 100)
+	key := "item:" + name
+	if id, ok := conn.ids[key]; ok {
+		return id, nil
+	}
+
+	params := zohoParams(conn)
+	params.Set("search_text", name)
+	var found struct {
+		Items []struct {
+			ItemID string `json:"item_id"`
+			Name   string `json:"name"`
+		} `json:"items"`
+	}
+	if err := conn.call(ctx, http.MethodGet, "/items", params, nil, &found); err != nil {
+		return "", err
+	}
+	for _, existing := range found.Items {
+		if strings.EqualFold(existing.Name, name) {
+			conn.ids[key] = existing.ItemID
+			return existing.ItemID, nil
+		}
+	}
+
+	productType := "goods"
+	if item.IsServc == "Y" {
+		productType = "service"
+	}
+	var created struct {
+		Item struct {
+			ItemID string `json:"item_id"`
+		} `json:"item"`
+	}
+	err := conn.call(ctx, http.MethodPost, "/items", zohoParams(conn), map[string]interface{}{
+		"name":         name,
+		"rate":         item.UnitPrice,
+		"unit":         item.Unit,
+		"hsn_or_sac":   item.HsnCd,
+		"product_type": productType,
+	}, &created)
+	if err != nil {
+		return "", err
+	}
+	conn.ids[key] = created.Item.ItemID
+	return created.Item.ItemID, nil
+}
+
+// zohoLineItem is a line of a Zoho Books invoice
+type zohoLineItem struct {
+	ItemID      string  `json:"item_id"`
+	Description string  `json:"description,omitempty"`
+	HSNOrSAC    string  `json:"hsn_or_sac,omitempty"`
+	Quantity    float64 `json:"quantity"`
+	Rate        float64 `json:"rate"`
+	Unit        string  `json:"unit,omitempty"`
+	Discount    float64 `json:"discount,omitempty"`
+	TaxID       string  `json:"tax_id,omitempty"`
+}
+
+// zohoPushInvoice creates an invoice in Zoho Books under its own number
+func zohoPushInvoice(ctx context.Context, conn *integrationConn, invoice *models.EInvoice) (string, error) {
+	date, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+	if err != nil {
+		return "", errors.New("invoice has an invalid date")
+	}
+	customerID, err := zohoCustomer(ctx, conn, invoice)
+	if err != nil {
+		return "", err
+	}
+
+	interState := invoice.IsInterState()
+	lines := make([]zohoLineItem, 0, len(invoice.ItemList))
+	for i := range invoice.ItemList {
+		item := &invoice.ItemList[i]
+		itemID, err := zohoItem(ctx, conn, item)
+		if err != nil {
+			return "", err
+		}
+		taxID, err := conn.findTax(ctx, item.GstRt, interState, zohoLoadTaxes)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, zohoLineItem{
+			ItemID:      itemID,
+			Description: item.PrdDesc,
+			HSNOrSAC:    item.HsnCd,
+			Quantity:    item.Qty,
+			Rate:        item.UnitPrice,
+			Unit:        item.Unit,
+			Discount:    models.Round(item.TotAmt-item.AssAmt, models.AmountDecimals),
+			TaxID:       taxID,
+		})
+	}
+
+	body := map[string]interface{}{
+		"customer_id":            customerID,
+		"invoice_number":         invoice.DocDtls.No,
+		"date":                   date.Format("2006-01-02"),
+		"place_of_supply":        zohoStateCodes[invoice.BuyerDtls.Pos],
+		"gst_treatment":          zohoGSTTreatment(invoice),
+		"discount_type":          "item_level",
+		"is_discount_before_tax": true,
+		"line_items":             lines,
+	}
+	if gstin := invoice.BuyerDtls.Gstin; gstin != "" && gstin != "URP" {
+		body["gst_no"] = gstin
+	}
+
+	// Keep the invoice's own number rather than Zoho's series
+	params := zohoParams(conn)
+	params.Set("ignore_auto_number_generation", "true")
+	var created struct {
+		Invoice struct {
+			InvoiceID string `json:"invoice_id"`
+		} `json:"invoice"`
+	}
+	if err := conn.call(ctx, http.MethodPost, "/invoices", params, body, &created); err != nil {
+		return "", err
+	}
+	if created.Invoice.InvoiceID == "" {
+		return "", errors.New("Zoho Books returned no invoice ID")
+	}
+	return created.Invoice.InvoiceID, nil
+}
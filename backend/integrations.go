@@ -0,0 +1,919 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// jobTypeSyncIntegration pushes a user's finalized invoices to an accounting service
+const jobTypeSyncIntegration = "integration.sync"
+
+// integrationStateTTL is how long a user has to authorize a connection
+const integrationStateTTL = 10 * time.Minute
+
+// integrationSyncBatch is the most invoices one sync pushes; the rest wait
+// for the next sync
+const integrationSyncBatch = 50
+
+// integrationMaxAttempts is how many syncs retry a failed invoice before it
+// waits for a manual sync
+const integrationMaxAttempts = 5
+
+// errUnknownIntegration is returned for providers other than Zoho Books and QuickBooks
+var errUnknownIntegration = errors.New("Unknown integration; use zoho_books or quickbooks")
+
+// errIntegrationRejected is returned when a service no longer accepts a
+// connection's tokens; the user has to connect it again
+var errIntegrationRejected = errors.New("rejected the authorization; connect the integration again")
+
+// integrationHTTPClient is used for all calls to accounting services
+var integrationHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// integrationProvider is an accounting service finalized invoices are pushed
+// to, connected with OAuth 2.0
+type integrationProvider struct {
+	name         string
+	label        string
+	authURL      string
+	tokenURL     string
+	apiURL       string
+	scope        string
+	clientID     string
+	clientSecret string
+	redirectURL  string
+	// authScheme prefixes the access token in the Authorization header
+	authScheme string
+	// basicAuth sends the client credentials to the token endpoint in an
+	// Authorization header instead of the form
+	basicAuth bool
+	// authParams are added to the authorization URL
+	authParams url.Values
+	// organization returns the organization invoices are pushed to, from the
+	// callback's query parameters or the service's API
+	organization func(ctx context.Context, conn *integrationConn, callback url.Values) (string, error)
+	// push creates an invoice in the service and returns its ID there
+	push func(ctx context.Context, conn *integrationConn, invoice *models.EInvoice) (string, error)
+	// errorMessage reads the message of an API error response
+	errorMessage func(body []byte) string
+}
+
+// integrationProviderNames lists the supported services in display order
+var integrationProviderNames = []string{models.IntegrationZohoBooks, models.IntegrationQuickBooks}
+
+// newIntegrationProvider returns the provider with the given name, reading
+// its OAuth client from the environment
+func newIntegrationProvider(name string) (*integrationProvider, bool) {
+	switch name {
+	case models.IntegrationZohoBooks:
+		return zohoBooksProvider(), true
+	case models.IntegrationQuickBooks:
+		return quickBooksProvider(), true
+	}
+	return nil, false
+}
+
+// configured reports whether the provider's OAuth client is set up
+func (p *integrationProvider) configured() bool {
+	return p.clientID != "" && p.clientSecret != "" && p.redirectURL != ""
+}
+
+// authorizationURL returns the service's consent screen for a connection
+func (p *integrationProvider) authorizationURL(state string) string {
+	params := url.Values{}
+	for key, values := range p.authParams {
+		params[key] = values
+	}
+	params.Set("client_id", p.clientID)
+	params.Set("redirect_uri", p.redirectURL)
+	params.Set("response_type", "code")
+	params.Set("scope", p.scope)
+	params.Set("state", state)
+	return p.authURL + "?" + params.Encode()
+}
+
+// integrationTokens are the OAuth tokens of a connection; they are encrypted at rest
+type integrationTokens struct {
+	AccessToken  string    `json:"access_token"`
+	RefreshToken string    `json:"refresh_token"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// requestTokens calls the provider's token endpoint with an authorization
+// code or refresh token grant
+func (p *integrationProvider) requestTokens(ctx context.Context, form url.Values) (*integrationTokens, error) {
+	if !p.basicAuth {
+		form.Set("client_id", p.clientID)
+		form.Set("client_secret", p.clientSecret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.basicAuth {
+		req.SetBasicAuth(p.clientID, p.clientSecret)
+	}
+
+	resp, err := integrationHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", p.label, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	var token struct {
+		AccessToken  string `json:"access_token"`
+		RefreshToken string `json:"refresh_token"`
+		ExpiresIn    int    `json:"expires_in"`
+		Error        string `json:"error"`
+	}
+	_ = json.Unmarshal(body, &token)
+	if resp.StatusCode != http.StatusOK || token.AccessToken == "" {
+		// Zoho reports rejected grants with HTTP 200 and an error field
+		if token.Error != "" || resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized {
+			return nil, fmt.Errorf("%s %w (%s)", p.label, errIntegrationRejected, token.Error)
+		}
+		return nil, fmt.Errorf("%s token endpoint returned HTTP %d", p.label, resp.StatusCode)
+	}
+
+	expiresIn := time.Duration(token.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = time.Hour
+	}
+	return &integrationTokens{
+		AccessToken:  token.AccessToken,
+		RefreshToken: token.RefreshToken,
+		ExpiresAt:    time.Now().Add(expiresIn),
+	}, nil
+}
+
+// integrationAAD binds stored tokens to their user and provider
+func integrationAAD(userID int, provider string) []byte {
+	return []byte(fmt.Sprintf("integrations:%d:%s", userID, provider))
+}
+
+// integrationTax is a tax set up in an accounting service, matched to the
+// GST rate of invoice lines
+type integrationTax struct {
+	id         string
+	rate       float64
+	interState bool
+}
+
+// integrationConn is a connected integration with its decrypted tokens
+type integrationConn struct {
+	id       int
+	userID   int
+	provider *integrationProvider
+	orgID    string
+	tokens   integrationTokens
+	// ids caches the service's IDs of customers, items and accounts during a sync
+	ids map[string]string
+	// taxes are loaded from the service by the first line that needs one
+	taxes       []integrationTax
+	taxesLoaded bool
+}
+
+// createIntegrationTables creates accounting service connections and the
+// sync status of each invoice pushed to them
+func createIntegrationTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS integrations (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			provider VARCHAR(20) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			oauth_state VARCHAR(64) UNIQUE,
+			state_expires_at TIMESTAMP,
+			organization_id VARCHAR(100) NOT NULL DEFAULT '',
+			master_key_id VARCHAR(100),
+			wrapped_key BYTEA,
+			ciphertext BYTEA,
+			connected_at TIMESTAMP,
+			last_sync_at TIMESTAMP,
+			last_error TEXT,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, provider)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create integrations table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS invoice_syncs (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			provider VARCHAR(20) NOT NULL,
+			status VARCHAR(20) NOT NULL,
+			external_id VARCHAR(100) NOT NULL DEFAULT '',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			synced_at TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(invoice_id, provider)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_syncs table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_invoice_syncs_user ON invoice_syncs (user_id, provider, status)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice_syncs index: %v", err)
+	}
+
+	registerJobHandler(jobTypeSyncIntegration, runIntegrationSyncJob)
+}
+
+// getIntegrationSyncInterval returns how often connected integrations push new invoices
+func getIntegrationSyncInterval() time.Duration {
+	minutes, err := strconv.Atoi(getEnvWithDefault("INTEGRATION_SYNC_MINUTES", "15"))
+	if err != nil || minutes < 1 {
+		log.Printf("Invalid INTEGRATION_SYNC_MINUTES, using 15")
+		minutes = 15
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// getIntegrationsRedirect returns the frontend page the OAuth callback sends
+// users back to, with the result in the URL fragment
+func getIntegrationsRedirect() string {
+	return getEnvWithDefault("INTEGRATIONS_REDIRECT_URL", strings.TrimRight(os.Getenv("FRONTEND_ORIGIN"), "/")+"/integrations")
+}
+
+// sealIntegrationTokens encrypts a connection's tokens for storage
+func sealIntegrationTokens(userID int, provider string, tokens *integrationTokens) (*sealedSecret, error) {
+	plaintext, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	return vault.seal(plaintext, integrationAAD(userID, provider))
+}
+
+// loadIntegration returns a user's connected integration with its tokens,
+// or pgx.ErrNoRows when the provider is not connected
+func loadIntegration(ctx context.Context, userID int, p *integrationProvider) (*integrationConn, error) {
+	if vault == nil {
+		return nil, errVaultDisabled
+	}
+	conn := &integrationConn{userID: userID, provider: p, ids: make(map[string]string)}
+	var sealed sealedSecret
+	err := dbPool.QueryRow(ctx, `
+		SELECT id, organization_id, master_key_id, wrapped_key, ciphertext
+		FROM integrations WHERE user_id = $1 AND provider = $2 AND status = $3
+	`, userID, p.name, models.IntegrationConnected).Scan(&conn.id, &conn.orgID, &sealed.KeyID, &sealed.WrappedKey, &sealed.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := vault.open(&sealed, integrationAAD(userID, p.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt integration tokens: %w", err)
+	}
+	if err := json.Unmarshal(plaintext, &conn.tokens); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// accessToken returns a current access token, refreshing and storing the
+// connection's tokens when it is about to expire
+func (conn *integrationConn) accessToken(ctx context.Context) (string, error) {
+	if time.Until(conn.tokens.ExpiresAt) > time.Minute {
+		return conn.tokens.AccessToken, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", conn.tokens.RefreshToken)
+	tokens, err := conn.provider.requestTokens(ctx, form)
+	if err != nil {
+		return "", err
+	}
+	// Zoho keeps the refresh token, while QuickBooks rotates it
+	if tokens.RefreshToken == "" {
+		tokens.RefreshToken = conn.tokens.RefreshToken
+	}
+
+	sealed, err := sealIntegrationTokens(conn.userID, conn.provider.name, tokens)
+	if err != nil {
+		return "", err
+	}
+	_, err = dbPool.Exec(ctx, `
+		UPDATE integrations SET master_key_id = $1, wrapped_key = $2, ciphertext = $3, updated_at = NOW()
+		WHERE id = $4
+	`, sealed.KeyID, sealed.WrappedKey, sealed.Ciphertext, conn.id)
+	if err != nil {
+		return "", fmt.Errorf("failed to store refreshed tokens: %w", err)
+	}
+	conn.tokens = *tokens
+	return tokens.AccessToken, nil
+}
+
+// call sends a request to the service's API, with body encoded as JSON, and
+// decodes the response into out
+func (conn *integrationConn) call(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	token, err := conn.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+	endpoint := conn.provider.apiURL + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", conn.provider.authScheme+" "+token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := integrationHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", conn.provider.label, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("%s %w", conn.provider.label, errIntegrationRejected)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned HTTP %d: %s", conn.provider.label, resp.StatusCode, conn.provider.errorMessage(respBody))
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("invalid response from %s: %w", conn.provider.label, err)
+		}
+	}
+	return nil
+}
+
+// findTax returns the ID of the service's tax for a GST rate, loading the
+// service's taxes with load the first time
+func (conn *integrationConn) findTax(ctx context.Context, rate float64, interState bool,
+	load func(ctx context.Context, conn *integrationConn) ([]integrationTax, error)) (string, error) {
+	if !conn.taxesLoaded {
+		taxes, err := load(ctx, conn)
+		if err != nil {
+			return "", err
+		}
+		conn.taxes, conn.taxesLoaded = taxes, true
+	}
+	for _, tax := range conn.taxes {
+		if tax.interState == interState && math.Abs(tax.rate-rate) < 0.001 {
+			return tax.id, nil
+		}
+	}
+	// Nil-rated lines may go without a tax when none is set up
+	if rate == 0 {
+		return "", nil
+	}
+	kind := "intra-state GST"
+	if interState {
+		kind = "IGST"
+	}
+	return "", fmt.Errorf("no %s tax at %s%% is set up in %s", kind, strconv.FormatFloat(rate, 'f', -1, 64), conn.provider.label)
+}
+
+// integrationSyncPayload is the payload of integration sync jobs. Repeating
+// syncs schedule the next one for as long as the integration stays connected.
+type integrationSyncPayload struct {
+	Provider string `json:"provider"`
+	Repeat   bool   `json:"repeat"`
+}
+
+// scheduleIntegrationSync starts the repeating sync of an integration unless
+// one is already scheduled
+func scheduleIntegrationSync(ctx context.Context, userID int, provider string, delay time.Duration) error {
+	var scheduled bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM jobs
+			WHERE type = $1 AND user_id = $2 AND status = $3
+				AND payload->>'provider' = $4 AND payload->>'repeat' = 'true'
+		)
+	`, jobTypeSyncIntegration, userID, models.JobStatusPending, provider).Scan(&scheduled)
+	if err != nil || scheduled {
+		return err
+	}
+	_, err = enqueueDelayedJob(ctx, userID, jobTypeSyncIntegration,
+		integrationSyncPayload{Provider: provider, Repeat: true}, delay)
+	return err
+}
+
+// runIntegrationSyncJob pushes a batch of invoices to a connected integration
+func runIntegrationSyncJob(ctx context.Context, job *models.Job) error {
+	var payload integrationSyncPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+	if job.UserID == nil {
+		return permanentError(errors.New("integration sync has no user"))
+	}
+	userID := *job.UserID
+
+	p, ok := newIntegrationProvider(payload.Provider)
+	if !ok {
+		return permanentError(errUnknownIntegration)
+	}
+	if !p.configured() {
+		return permanentError(fmt.Errorf("%s integration is not configured", p.label))
+	}
+	conn, err := loadIntegration(ctx, userID, p)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Disconnected, or waiting to be connected again
+		return nil
+	}
+	if errors.Is(err, errVaultDisabled) {
+		return permanentError(err)
+	}
+	if err != nil {
+		return err
+	}
+
+	pushed, err := syncIntegration(ctx, conn)
+	status, lastError := models.IntegrationConnected, ""
+	if err != nil {
+		lastError = err.Error()
+		log.Printf("Sync of %s for user %d failed: %v", p.label, userID, err)
+	}
+	if errors.Is(err, errIntegrationRejected) {
+		status = models.IntegrationExpired
+	}
+	_, dbErr := dbPool.Exec(ctx, `
+		UPDATE integrations SET status = $1, last_sync_at = NOW(), last_error = NULLIF($2, ''), updated_at = NOW()
+		WHERE id = $3 AND status = $4
+	`, status, lastError, conn.id, models.IntegrationConnected)
+	if dbErr != nil {
+		log.Printf("Error recording sync of integration %d: %v", conn.id, dbErr)
+	}
+
+	if status == models.IntegrationExpired {
+		return permanentError(err)
+	}
+	if !payload.Repeat {
+		return err
+	}
+	// A full batch leaves invoices waiting, so the next sync starts right away
+	delay := getIntegrationSyncInterval()
+	if pushed == integrationSyncBatch {
+		delay = 0
+	}
+	return scheduleIntegrationSync(ctx, userID, p.name, delay)
+}
+
+// syncIntegration pushes the user's finalized invoices that are not yet in
+// the service, retrying failed ones up to integrationMaxAttempts times. It
+// returns how many invoices it tried to push.
+func syncIntegration(ctx context.Context, conn *integrationConn) (int, error) {
+	// Refresh the token up front, so a revoked connection fails the sync
+	// rather than every invoice
+	if _, err := conn.accessToken(ctx); err != nil {
+		return 0, err
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT i.id, i.invoice_json
+		FROM invoices i
+		LEFT JOIN invoice_syncs s ON s.invoice_id = i.id AND s.provider = $2
+		WHERE i.user_id = $1 AND i.status = $3 AND NOT i.sandbox AND NOT i.quarantined
+			AND i.invoice_json->'DocDtls'->>'Typ' = 'INV'
+			AND (s.id IS NULL OR (s.status = $4 AND s.attempts < $5))
+		ORDER BY i.id
+		LIMIT $6
+	`, conn.userID, conn.provider.name, models.InvoiceStatusFinalized, models.InvoiceSyncFailed,
+		integrationMaxAttempts, integrationSyncBatch)
+	if err != nil {
+		return 0, err
+	}
+	type pendingInvoice struct {
+		id          int
+		invoiceJSON []byte
+	}
+	pending := make([]pendingInvoice, 0)
+	for rows.Next() {
+		var p pendingInvoice
+		if err := rows.Scan(&p.id, &p.invoiceJSON); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range pending {
+		var externalID string
+		var invoice models.EInvoice
+		pushErr := json.Unmarshal(p.invoiceJSON, &invoice)
+		if pushErr == nil {
+			externalID, pushErr = conn.provider.push(ctx, conn, &invoice)
+		}
+		if errors.Is(pushErr, errIntegrationRejected) {
+			return len(pending), pushErr
+		}
+		if err := recordInvoiceSync(ctx, conn, p.id, externalID, pushErr); err != nil {
+			return len(pending), err
+		}
+	}
+	return len(pending), nil
+}
+
+// recordInvoiceSync stores the result of pushing an invoice
+func recordInvoiceSync(ctx context.Context, conn *integrationConn, invoiceID int, externalID string, pushErr error) error {
+	status, lastError := models.InvoiceSyncSynced, ""
+	if pushErr != nil {
+		status, lastError = models.InvoiceSyncFailed, pushErr.Error()
+	}
+	_, err := dbPool.Exec(ctx, `
+		INSERT INTO invoice_syncs (user_id, invoice_id, provider, status, external_id, attempts, last_error, synced_at)
+		VALUES ($1, $2, $3, $4, $5, 1, NULLIF($6, ''), CASE WHEN $7 THEN NOW() END)
+		ON CONFLICT (invoice_id, provider) DO UPDATE SET
+			status = EXCLUDED.status,
+			external_id = EXCLUDED.external_id,
+			attempts = invoice_syncs.attempts + 1,
+			last_error = EXCLUDED.last_error,
+			synced_at = EXCLUDED.synced_at,
+			updated_at = NOW()
+	`, conn.userID, invoiceID, conn.provider.name, status, externalID, lastError, pushErr == nil)
+	return err
+}
+
+// integrationProviderParam reads the provider of an integration request,
+// responding with an error when it is unknown or, if configured is set, not
+// set up on this server
+func integrationProviderParam(c *gin.Context, configured bool) (*integrationProvider, bool) {
+	p, ok := newIntegrationProvider(c.Param("provider"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUnknownIntegration.Error()})
+		return nil, false
+	}
+	if !configured {
+		return p, true
+	}
+	if !p.configured() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": p.label + " integration is not configured"})
+		return nil, false
+	}
+	if vault == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errVaultDisabled.Error()})
+		return nil, false
+	}
+	return p, true
+}
+
+// handleGetIntegrations lists the supported accounting services with the
+// authenticated user's connection to each and the count of invoices synced
+// to it by status
+func handleGetIntegrations(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, provider, status, organization_id, connected_at, last_sync_at, COALESCE(last_error, ''), created_at
+		FROM integrations WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch integrations"})
+		return
+	}
+	defer rows.Close()
+
+	connected := make(map[string]*models.Integration)
+	for rows.Next() {
+		var i models.Integration
+		if err := rows.Scan(&i.ID, &i.Provider, &i.Status, &i.OrganizationID, &i.ConnectedAt,
+			&i.LastSyncAt, &i.LastError, &i.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read integration"})
+			return
+		}
+		connected[i.Provider] = &i
+	}
+	if rowsFailed(c, rows, "Failed to fetch integrations") {
+		return
+	}
+
+	counts := make(map[string]map[string]int)
+	countRows, err := dbPool.Query(ctx,
+		"SELECT provider, status, COUNT(*) FROM invoice_syncs WHERE user_id = $1 GROUP BY provider, status", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sync status"})
+		return
+	}
+	defer countRows.Close()
+	for countRows.Next() {
+		var provider, status string
+		var count int
+		if err := countRows.Scan(&provider, &status, &count); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read sync status"})
+			return
+		}
+		if counts[provider] == nil {
+			counts[provider] = make(map[string]int)
+		}
+		counts[provider][status] = count
+	}
+	if rowsFailed(c, countRows, "Failed to fetch sync status") {
+		return
+	}
+
+	integrations := make([]gin.H, 0, len(integrationProviderNames))
+	for _, name := range integrationProviderNames {
+		p, _ := newIntegrationProvider(name)
+		integrations = append(integrations, gin.H{
+			"provider":    name,
+			"label":       p.label,
+			"configured":  p.configured() && vault != nil,
+			"integration": connected[name],
+			"synced":      counts[name][models.InvoiceSyncSynced],
+			"failed":      counts[name][models.InvoiceSyncFailed],
+		})
+	}
+	c.JSON(http.StatusOK, integrations)
+}
+
+// handleConnectIntegration starts connecting an accounting service and
+// returns the URL of its consent screen. The service sends the user back
+// to handleIntegrationCallback.
+func handleConnectIntegration(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	p, ok := integrationProviderParam(c, true)
+	if !ok {
+		return
+	}
+
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start connection"})
+		return
+	}
+	stateHex := hex.EncodeToString(state)
+
+	// Reconnecting keeps the current connection until the new one is authorized
+	_, err := dbPool.Exec(c.Request.Context(), `
+		INSERT INTO integrations (user_id, provider, oauth_state, state_expires_at)
+		VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second')
+		ON CONFLICT (user_id, provider) DO UPDATE SET
+			oauth_state = EXCLUDED.oauth_state,
+			state_expires_at = EXCLUDED.state_expires_at,
+			updated_at = NOW()
+	`, userID, p.name, stateHex, int64(integrationStateTTL.Seconds()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start connection"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"authorization_url": p.authorizationURL(stateHex),
+		"expires_in":        int(integrationStateTTL.Seconds()),
+	})
+}
+
+// handleIntegrationCallback completes connecting an accounting service and
+// redirects back to the frontend. The state ties the callback to the user
+// who started the connection.
+func handleIntegrationCallback(c *gin.Context) {
+	p, ok := integrationProviderParam(c, true)
+	if !ok {
+		return
+	}
+	redirect := func(fragment url.Values) {
+		c.Redirect(http.StatusFound, getIntegrationsRedirect()+"#"+fragment.Encode())
+	}
+	fail := func(message string) {
+		redirect(url.Values{"provider": {p.name}, "error": {message}})
+	}
+	ctx := c.Request.Context()
+
+	// The state can be used once
+	var id, userID int
+	state := c.Query("state")
+	err := dbPool.QueryRow(ctx, `
+		UPDATE integrations SET oauth_state = NULL, state_expires_at = NULL, updated_at = NOW()
+		WHERE provider = $1 AND oauth_state = $2 AND state_expires_at > NOW()
+		RETURNING id, user_id
+	`, p.name, state).Scan(&id, &userID)
+	if err != nil || state == "" {
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error checking %s connection state: %v", p.label, err)
+		}
+		fail(p.label + " connection expired; please try again")
+		return
+	}
+	if c.Query("error") != "" {
+		fail(p.label + " connection was cancelled")
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		fail(p.label + " returned no authorization code")
+		return
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.redirectURL)
+	tokens, err := p.requestTokens(ctx, form)
+	if err != nil {
+		log.Printf("%s connection failed: %v", p.label, err)
+		fail(p.label + " connection failed")
+		return
+	}
+
+	conn := &integrationConn{id: id, userID: userID, provider: p, tokens: *tokens, ids: make(map[string]string)}
+	orgID, err := p.organization(ctx, conn, c.Request.URL.Query())
+	if err != nil {
+		log.Printf("%s connection failed: %v", p.label, err)
+		fail("Could not find your " + p.label + " organization")
+		return
+	}
+
+	sealed, err := sealIntegrationTokens(userID, p.name, tokens)
+	if err != nil {
+		log.Printf("Error encrypting %s tokens: %v", p.label, err)
+		fail(p.label + " connection failed")
+		return
+	}
+	_, err = dbPool.Exec(ctx, `
+		UPDATE integrations SET status = $1, organization_id = $2, master_key_id = $3, wrapped_key = $4,
+			ciphertext = $5, connected_at = NOW(), last_error = NULL, updated_at = NOW()
+		WHERE id = $6
+	`, models.IntegrationConnected, orgID, sealed.KeyID, sealed.WrappedKey, sealed.Ciphertext, id)
+	if err != nil {
+		log.Printf("Error storing %s connection: %v", p.label, err)
+		fail(p.label + " connection failed")
+		return
+	}
+	if err := scheduleIntegrationSync(ctx, userID, p.name, 0); err != nil {
+		log.Printf("Error scheduling %s sync for user %d: %v", p.label, userID, err)
+	}
+
+	redirect(url.Values{"provider": {p.name}, "connected": {"true"}})
+}
+
+// handleSyncIntegration queues an immediate sync of a connected integration.
+// Invoices that failed are retried, even past integrationMaxAttempts.
+func handleSyncIntegration(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	p, ok := integrationProviderParam(c, true)
+	if !ok {
+		return
+	}
+
+	var status string
+	err := dbPool.QueryRow(ctx,
+		"SELECT status FROM integrations WHERE user_id = $1 AND provider = $2", userID, p.name).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Integration not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if status != models.IntegrationConnected {
+		c.JSON(http.StatusConflict, gin.H{"error": p.label + " is not connected; connect it again"})
+		return
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		UPDATE invoice_syncs SET attempts = 0, updated_at = NOW()
+		WHERE user_id = $1 AND provider = $2 AND status = $3
+	`, userID, p.name, models.InvoiceSyncFailed)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue sync"})
+		return
+	}
+	jobID, err := enqueueJob(ctx, userID, jobTypeSyncIntegration, integrationSyncPayload{Provider: p.name})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue sync"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{"message": "Sync queued", "job_id": jobID})
+}
+
+// handleDisconnectIntegration removes a connection and its tokens. The sync
+// status of invoices already pushed is kept.
+func handleDisconnectIntegration(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	p, ok := integrationProviderParam(c, false)
+	if !ok {
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM integrations WHERE user_id = $1 AND provider = $2", userID, p.name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to disconnect integration"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Integration not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": p.label + " disconnected", "provider": p.name})
+}
+
+// handleGetInvoiceSyncs returns the sync status of an invoice with each accounting service
+func handleGetInvoiceSyncs(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var exists bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, invoice_id, provider, status, external_id, attempts, COALESCE(last_error, ''), synced_at, updated_at
+		FROM invoice_syncs WHERE invoice_id = $1 AND user_id = $2
+		ORDER BY provider
+	`, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch sync status"})
+		return
+	}
+	defer rows.Close()
+
+	syncs := make([]models.InvoiceSync, 0)
+	for rows.Next() {
+		var s models.InvoiceSync
+		if err := rows.Scan(&s.ID, &s.InvoiceID, &s.Provider, &s.Status, &s.ExternalID, &s.Attempts,
+			&s.LastError, &s.SyncedAt, &s.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read sync status"})
+			return
+		}
+		syncs = append(syncs, s)
+	}
+	if rowsFailed(c, rows, "Failed to fetch sync status") {
+		return
+	}
+
+	c.JSON(http.StatusOK, syncs)
+}
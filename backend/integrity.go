@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Integrity problem kinds reported by the scanner
+const (
+	integrityCorruptJSON      = "corrupt_json"
+	integrityValidationFailed = "validation_failed"
+	integrityMasterData       = "master_data"
+	integrityTotals           = "totals_mismatch"
+)
+
+// integrityIssue describes a stored invoice that no longer passes checks
+type integrityIssue struct {
+	InvoiceID     int        `json:"invoice_id"`
+	InvoiceNo     string     `json:"invoice_no"`
+	Problem       string     `json:"problem"`
+	Error         string     `json:"error"`
+	Quarantined   bool       `json:"quarantined"`
+	QuarantinedAt *time.Time `json:"quarantined_at,omitempty"`
+}
+
+// checkStoredInvoice re-parses and re-validates stored invoice JSON,
+// returning the problem kind and error or empty strings when it is valid.
+// Drafts are validated under their company's validation profile.
+func checkStoredInvoice(ctx context.Context, userID int, status string, invoiceJSON []byte) (string, string) {
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		return integrityCorruptJSON, err.Error()
+	}
+	profile := models.ValidationStrict
+	if status == models.InvoiceStatusDraft {
+		profile = getCompanyValidationProfile(ctx, userID, invoice.SellerDtls.Gstin)
+	}
+	if _, err := invoice.ValidateProfile(profile); err != nil {
+		return integrityValidationFailed, err.Error()
+	}
+	if _, err := checkMasterData(ctx, &invoice); err != nil && profile != models.ValidationLenient {
+		return integrityMasterData, err.Error()
+	}
+	// Totals calculated in floating point before amounts were kept in paise
+	// can be off by more than the IRP accepts
+	if err := invoice.CheckTotals(); err != nil {
+		return integrityTotals, err.Error()
+	}
+	return "", ""
+}
+
+// handleIntegrityScan reports every invoice whose stored JSON fails to parse or validate
+func handleIntegrityScan(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, invoice_no, status, invoice_json, quarantined, quarantined_at
+		FROM invoices WHERE user_id = $1 ORDER BY id
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	scanned := 0
+	issues := make([]integrityIssue, 0)
+	for rows.Next() {
+		var issue integrityIssue
+		var status string
+		var invoiceJSON []byte
+		if err := rows.Scan(&issue.InvoiceID, &issue.InvoiceNo, &status, &invoiceJSON, &issue.Quarantined, &issue.QuarantinedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+			return
+		}
+		scanned++
+
+		issue.Problem, issue.Error = checkStoredInvoice(ctx, userID, status, invoiceJSON)
+		if issue.Problem != "" {
+			issues = append(issues, issue)
+		}
+	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"scanned": scanned,
+		"issues":  issues,
+	})
+}
+
+// handleRevalidateInvoice re-runs integrity checks on one invoice and
+// releases it from quarantine when it passes
+func handleRevalidateInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var status string
+	var invoiceJSON []byte
+	err = dbPool.QueryRow(ctx,
+		"SELECT status, invoice_json FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&status, &invoiceJSON)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
+		return
+	}
+
+	problem, message := checkStoredInvoice(ctx, userID, status, invoiceJSON)
+	if problem != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"invoice_id": id,
+			"valid":      false,
+			"problem":    problem,
+			"error":      message,
+		})
+		return
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		UPDATE invoices
+		SET quarantined = FALSE, quarantine_reason = NULL, quarantined_at = NULL
+		WHERE id = $1 AND user_id = $2 AND quarantined
+	`, id, userID)
+	if err != nil {
+		log.Printf("Error releasing invoice %d from quarantine: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoice_id": id,
+		"valid":      true,
+	})
+}
+
+// handleQuarantineInvoice flags an invoice as quarantined so it is left out of exports
+func handleQuarantineInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if req.Reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Quarantine reason is required"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE invoices
+		SET quarantined = TRUE, quarantine_reason = $1, quarantined_at = NOW()
+		WHERE id = $2 AND user_id = $3
+	`, req.Reason, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to quarantine invoice"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Invoice quarantined successfully",
+		"invoice_id": id,
+	})
+}
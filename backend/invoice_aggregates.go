@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// invoiceAggregateBatchSize is the number of invoices backfilled per query
+const invoiceAggregateBatchSize = 500
+
+// invoiceAggregates are the totals, tax heads, buyer, date, type and GSTR-1
+// section of an invoice, kept in columns of the invoices row so lists and reports can
+// filter, sort and sum them without reading invoice_json. Every write of
+// invoice_json writes them in the same statement.
+type invoiceAggregates struct {
+	totalValue   float64
+	taxableValue float64
+	taxAmount    float64
+	igstAmount   float64
+	cgstAmount   float64
+	sgstAmount   float64
+	buyerGSTIN   string
+	invoiceDate  time.Time
+	gstr1Section string
+	docType      string
+	// reverseCharge marks supplies whose tax the recipient pays
+	reverseCharge bool
+}
+
+// aggregatesOf returns the aggregates of an invoice. An invoice without a
+// readable date is dated fallback, the day it was stored.
+func aggregatesOf(invoice *models.EInvoice, fallback time.Time) invoiceAggregates {
+	date, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+	if err != nil {
+		date = fallback.In(istLocation)
+	}
+	igst, cgst, sgst := invoice.TaxVals()
+	return invoiceAggregates{
+		totalValue:    models.Round(invoice.ValDtls.TotInvVal, models.AmountDecimals),
+		taxableValue:  models.Round(invoice.TaxableValue(), models.AmountDecimals),
+		taxAmount:     models.Round(invoice.TotalTax(), models.AmountDecimals),
+		igstAmount:    models.Round(igst, models.AmountDecimals),
+		cgstAmount:    models.Round(cgst, models.AmountDecimals),
+		sgstAmount:    models.Round(sgst, models.AmountDecimals),
+		buyerGSTIN:    strings.ToUpper(strings.TrimSpace(invoice.BuyerDtls.Gstin)),
+		invoiceDate:   time.Date(date.Year(), date.Month(), date.Day(), 0, 0, 0, 0, time.UTC),
+		gstr1Section:  invoice.GSTR1Section(),
+		docType:       invoice.DocDtls.Typ,
+		reverseCharge: invoice.IsReverseCharge(),
+	}
+}
+
+// createInvoiceAggregateColumns adds the aggregate columns to invoices and
+// fills them in for invoices stored before they were added
+func createInvoiceAggregateColumns() {
+	ctx := context.Background()
+	_, err := dbPool.Exec(ctx, `
+		ALTER TABLE invoices
+		ADD COLUMN IF NOT EXISTS total_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS taxable_value NUMERIC(15,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS tax_amount NUMERIC(15,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS igst_amount NUMERIC(15,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS cgst_amount NUMERIC(15,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS sgst_amount NUMERIC(15,2) NOT NULL DEFAULT 0,
+		ADD COLUMN IF NOT EXISTS buyer_gstin TEXT NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS invoice_date DATE,
+		ADD COLUMN IF NOT EXISTS gstr1_section VARCHAR(4),
+		ADD COLUMN IF NOT EXISTS doc_type VARCHAR(3),
+		ADD COLUMN IF NOT EXISTS reverse_charge BOOLEAN NOT NULL DEFAULT FALSE
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add aggregate columns to invoices table: %v", err)
+	}
+
+	n, err := backfillInvoiceAggregates(ctx)
+	if err != nil {
+		log.Fatalf("Failed to backfill invoice aggregates: %v", err)
+	}
+	if n > 0 {
+		log.Printf("Backfilled aggregates of %d invoices", n)
+	}
+
+	// Every invoice has a date, GSTR-1 section and type once backfilled; keep it that way
+	if _, err := dbPool.Exec(ctx, "ALTER TABLE invoices ALTER COLUMN invoice_date SET NOT NULL"); err != nil {
+		log.Fatalf("Failed to require invoice_date on invoices: %v", err)
+	}
+	if _, err := dbPool.Exec(ctx, "ALTER TABLE invoices ALTER COLUMN gstr1_section SET NOT NULL"); err != nil {
+		log.Fatalf("Failed to require gstr1_section on invoices: %v", err)
+	}
+	if _, err := dbPool.Exec(ctx, "ALTER TABLE invoices ALTER COLUMN doc_type SET NOT NULL"); err != nil {
+		log.Fatalf("Failed to require doc_type on invoices: %v", err)
+	}
+	_, err = dbPool.Exec(ctx,
+		"CREATE INDEX IF NOT EXISTS idx_invoices_user_buyer ON invoices (user_id, buyer_gstin)")
+	if err != nil {
+		log.Fatalf("Failed to create index idx_invoices_user_buyer: %v", err)
+	}
+	// Reports sum the tax heads of a period
+	_, err = dbPool.Exec(ctx,
+		"CREATE INDEX IF NOT EXISTS idx_invoices_user_date ON invoices (user_id, invoice_date)")
+	if err != nil {
+		log.Fatalf("Failed to create index idx_invoices_user_date: %v", err)
+	}
+}
+
+// backfillInvoiceAggregates fills in the aggregates of invoices without an
+// invoice_date, GSTR-1 section or type and returns how many it updated. Invoices whose JSON cannot
+// be read get zero totals, as the list showed them before, and are reported
+// by the integrity scan.
+func backfillInvoiceAggregates(ctx context.Context) (int, error) {
+	total := 0
+	for {
+		rows, err := dbPool.Query(ctx, `
+			SELECT id, invoice_json, created_at FROM invoices
+			WHERE invoice_date IS NULL OR gstr1_section IS NULL OR doc_type IS NULL
+			ORDER BY id
+			LIMIT $1
+		`, invoiceAggregateBatchSize)
+		if err != nil {
+			return total, err
+		}
+
+		batch := &pgx.Batch{}
+		for rows.Next() {
+			var id int
+			var invoiceJSON []byte
+			var createdAt time.Time
+			if err := rows.Scan(&id, &invoiceJSON, &createdAt); err != nil {
+				rows.Close()
+				return total, err
+			}
+			var invoice models.EInvoice
+			if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+				invoice = models.EInvoice{}
+			}
+			agg := aggregatesOf(&invoice, createdAt)
+			batch.Queue(`
+				UPDATE invoices
+				SET total_value = $1, taxable_value = $2, tax_amount = $3, buyer_gstin = $4, invoice_date = $5,
+					gstr1_section = $6, igst_amount = $7, cgst_amount = $8, sgst_amount = $9, doc_type = $10,
+					reverse_charge = $11
+				WHERE id = $12
+			`, agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.invoiceDate, agg.gstr1Section,
+				agg.igstAmount, agg.cgstAmount, agg.sgstAmount, agg.docType, agg.reverseCharge, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return total, err
+		}
+		if batch.Len() == 0 {
+			return total, nil
+		}
+		if err := dbPool.SendBatch(ctx, batch).Close(); err != nil {
+			return total, err
+		}
+		total += batch.Len()
+	}
+}
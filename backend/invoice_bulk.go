@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// bulkInsertChunk is the number of invoices sent to the database in one batch
+const bulkInsertChunk = 500
+
+// importInvoiceSQL stores an imported invoice, replacing a draft of the same
+// number; it returns no row when the number belongs to an invoice that is not
+// a draft. Invoice numbers are unique in invoice_keys rather than in the
+// partitioned invoices table, so the number is looked up and locked there.
+var importInvoiceSQL = `WITH existing AS (
+		SELECT k.id, i.status FROM invoice_keys k LEFT JOIN invoices i ON i.id = k.id
+		WHERE k.user_id = $1 AND k.invoice_no = $3
+		FOR UPDATE OF k
+	), updated AS (
+		UPDATE invoices
+		SET invoice_json = $4, qr_code = NULL, qr_object_key = NULL, updated_at = NOW(),
+			total_value = $5, taxable_value = $6, tax_amount = $7, buyer_gstin = $8, invoice_date = $9,
+			gstr1_section = $10, igst_amount = $11, cgst_amount = $12, sgst_amount = $13, doc_type = $14,
+			reverse_charge = $15, customer_id = ` + invoiceCustomerSQL("$1", "$8", "$4") + `
+		WHERE id IN (SELECT id FROM existing WHERE status = 'draft') AND status = 'draft'
+		RETURNING id
+	), inserted AS (
+		INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, sandbox, created_at,
+			total_value, taxable_value, tax_amount, buyer_gstin, invoice_date, gstr1_section,
+			igst_amount, cgst_amount, sgst_amount, doc_type, reverse_charge, customer_id)
+		SELECT $1, $2::varchar, $3, $4, ` + sandboxFlagSQL + `, NOW(), $5, $6, $7, $8, $9, $10,
+			$11, $12, $13, $14, $15, ` + invoiceCustomerSQL("$1", "$8", "$4") + `
+		WHERE NOT EXISTS (SELECT 1 FROM existing)
+		RETURNING id
+	)
+	SELECT id FROM updated UNION ALL SELECT id FROM inserted`
+
+// importedInvoice is a validated invoice of an import, ready to be stored
+type importedInvoice struct {
+	invoice     *models.EInvoice
+	invoiceJSON []byte
+}
+
+// bulkStoreError is a database failure while storing the invoice at index
+// of an import; index is -1 when no single invoice caused it
+type bulkStoreError struct {
+	index int
+	err   error
+}
+
+func (e *bulkStoreError) Error() string { return e.err.Error() }
+
+func (e *bulkStoreError) Unwrap() error { return e.err }
+
+// storeImportedInvoices stores the invoices of an import within its
+// transaction and records their saved events. Invoices are sent in batches
+// of bulkInsertChunk statements instead of one round trip each, so large
+// files import in seconds. It returns the ID of each invoice in order, or 0
+// where its number belongs to an invoice that is not a draft. Database
+// failures are returned as a *bulkStoreError; the transaction is then aborted.
+func storeImportedInvoices(ctx context.Context, tx pgx.Tx, userID int, invoices []importedInvoice) ([]int, error) {
+	ids := make([]int, len(invoices))
+	now := time.Now()
+	for start := 0; start < len(invoices); start += bulkInsertChunk {
+		end := min(start+bulkInsertChunk, len(invoices))
+
+		batch := &pgx.Batch{}
+		for _, imp := range invoices[start:end] {
+			agg := aggregatesOf(imp.invoice, now)
+			batch.Queue(importInvoiceSQL,
+				userID, imp.invoice.SellerDtls.Gstin, imp.invoice.DocDtls.No, imp.invoiceJSON,
+				agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.invoiceDate, agg.gstr1Section,
+				agg.igstAmount, agg.cgstAmount, agg.sgstAmount, agg.docType, agg.reverseCharge)
+		}
+		results := tx.SendBatch(ctx, batch)
+		for i := start; i < end; i++ {
+			err := results.QueryRow().Scan(&ids[i])
+			if errors.Is(err, pgx.ErrNoRows) {
+				ids[i] = 0
+				continue
+			}
+			if err != nil {
+				results.Close()
+				return nil, &bulkStoreError{index: i, err: err}
+			}
+		}
+		if err := results.Close(); err != nil {
+			return nil, &bulkStoreError{index: -1, err: err}
+		}
+
+		// Record the saved events in one more round trip
+		events := &pgx.Batch{}
+		for _, id := range ids[start:end] {
+			if id != 0 {
+				events.Queue(invoiceEventSQL, eventInvoiceSaved, entityInvoice, id, outboxEventTypes)
+			}
+		}
+		if err := tx.SendBatch(context.WithoutCancel(ctx), events).Close(); err != nil {
+			return nil, &bulkStoreError{index: -1, err: err}
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// handleCloneInvoice copies an invoice of any status into a new draft for
+// repeat billing. The copy takes the next number in the user's series and
+// today's date; details tied to the original shipment, such as the shipping
+// bill and the transport document, are left out.
+func handleCloneInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var invoiceJSON []byte
+	var sandbox bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT invoice_json, sandbox FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceJSON, &sandbox)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+
+	// The copy is dated today, which may fall in a locked period
+	invoice.DocDtls.Dt = todayInvoiceDate()
+	periodOverride, ok := checkPeriodLock(c, userID, invoiceDocDate(&invoice))
+	if !ok {
+		return
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	invoiceNo, err := nextInvoiceNumber(ctx, tx, userID,
+		invoiceSeriesPrefix(getUserSettings(ctx, userID), invoice.DocDtls.Dt))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign invoice number"})
+		return
+	}
+	invoice.DocDtls.No = invoiceNo
+	invoice.ExpDtls.ShipBNo = ""
+	invoice.ExpDtls.ShipBDt = ""
+	if invoice.EwbDtls != nil {
+		invoice.EwbDtls.TransDocNo = ""
+		invoice.EwbDtls.TransDocDt = ""
+	}
+	// The copy is converted at the rate for its own date
+	precision := getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+	if err := resolveExchangeRate(ctx, &invoice, precision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	invoice.CalculateTotalsWithPrecision(precision)
+
+	invoiceJSON, err = json.Marshal(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
+		return
+	}
+
+	// The copy stays in the sandbox when the original was test data
+	var cloneID int
+	agg := aggregatesOf(&invoice, time.Now())
+	err = tx.QueryRow(ctx,
+		`INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, status, sandbox, created_at,
+			total_value, taxable_value, tax_amount, buyer_gstin, invoice_date, gstr1_section,
+			igst_amount, cgst_amount, sgst_amount, doc_type, reverse_charge, customer_id)
+		VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7, $8, $9, $10, $11, $12,
+			$13, $14, $15, $16, $17, `+invoiceCustomerSQL("$1", "$10", "$4")+`)
+		RETURNING id`,
+		userID, invoice.SellerDtls.Gstin, invoiceNo, invoiceJSON, models.InvoiceStatusDraft, sandbox,
+		agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.invoiceDate, agg.gstr1Section,
+		agg.igstAmount, agg.cgstAmount, agg.sgstAmount, agg.docType, agg.reverseCharge).Scan(&cloneID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Invoice %s already exists", invoiceNo)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing clone of invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice"})
+		return
+	}
+	recordInvoiceEvent(ctx, tx, eventInvoiceCreated, cloneID)
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice"})
+		return
+	}
+	periodOverride.InvoiceID, periodOverride.InvoiceNo, periodOverride.Action = cloneID, invoiceNo, "create"
+	recordPeriodLockOverride(ctx, dbPool, userID, periodOverride)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     "Invoice cloned successfully",
+		"id":          cloneID,
+		"invoice_no":  invoiceNo,
+		"status":      models.InvoiceStatusDraft,
+		"cloned_from": id,
+		"qr_url":      qrURL(cloneID, invoiceQRHash(&invoice)),
+	})
+}
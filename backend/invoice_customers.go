@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"log"
+)
+
+// invoiceCustomerSQL returns the customer an invoice is made out to: the
+// user's customer with the buyer GSTIN, or with the buyer's legal name when
+// the buyer has none. It takes the placeholders, or columns, holding the
+// user ID, the buyer GSTIN and the invoice JSON of the statement.
+func invoiceCustomerSQL(userID, buyerGSTIN, invoiceJSON string) string {
+	return `(SELECT c.id FROM customers c
+		WHERE c.user_id = ` + userID + ` AND CASE WHEN ` + buyerGSTIN + `::text <> ''
+			THEN c.gstin = ` + buyerGSTIN + `::text
+			ELSE LOWER(c.name) = LOWER(` + invoiceJSON + `::jsonb->'BuyerDtls'->>'LglNm') END
+		ORDER BY c.id LIMIT 1)`
+}
+
+// createInvoiceCustomerColumn adds the reference from invoices to the
+// customer they are made out to, and links the invoices stored before it
+// was added. Deleting a customer clears the reference; merging customers
+// moves it to the customer kept.
+func createInvoiceCustomerColumn() {
+	ctx := context.Background()
+
+	var exists bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = 'invoices' AND column_name = 'customer_id'
+		)
+	`).Scan(&exists)
+	if err != nil {
+		log.Fatalf("Failed to check invoices for customer_id: %v", err)
+	}
+
+	if !exists {
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			log.Fatalf("Failed to add customer_id to invoices table: %v", err)
+		}
+		defer tx.Rollback(ctx)
+
+		_, err = tx.Exec(ctx,
+			"ALTER TABLE invoices ADD COLUMN customer_id INTEGER REFERENCES customers(id) ON DELETE SET NULL")
+		if err != nil {
+			log.Fatalf("Failed to add customer_id to invoices table: %v", err)
+		}
+		result, err := tx.Exec(ctx, `
+			UPDATE invoices
+			SET customer_id = `+invoiceCustomerSQL("invoices.user_id", "invoices.buyer_gstin", "invoices.invoice_json"))
+		if err != nil {
+			log.Fatalf("Failed to link invoices to customers: %v", err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			log.Fatalf("Failed to add customer_id to invoices table: %v", err)
+		}
+		log.Printf("Linked %d invoices to their customers", result.RowsAffected())
+	}
+
+	_, err = dbPool.Exec(ctx, "CREATE INDEX IF NOT EXISTS idx_invoices_customer ON invoices (customer_id)")
+	if err != nil {
+		log.Fatalf("Failed to create index idx_invoices_customer: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// jsonFilterPrefix marks the query parameters that filter invoices by a field
+// of their e-invoice JSON, e.g. json.BuyerDtls.Stcd=07
+const jsonFilterPrefix = "json."
+
+// maxJSONFilters caps the JSON field conditions of one query
+const maxJSONFilters = 10
+
+// createInvoiceJSONIndexes indexes invoice_json for containment queries and
+// the paths most often queried directly
+func createInvoiceJSONIndexes() {
+	indexes := map[string]string{
+		"idx_invoices_json":             "invoices USING GIN (invoice_json jsonb_path_ops)",
+		"idx_invoices_json_buyer_gstin": "invoices (user_id, (invoice_json->'BuyerDtls'->>'Gstin'))",
+		"idx_invoices_json_doc_date":    "invoices (user_id, (invoice_json->'DocDtls'->>'Dt'))",
+	}
+	for name, def := range indexes {
+		_, err := dbPool.Exec(context.Background(), "CREATE INDEX IF NOT EXISTS "+name+" ON "+def)
+		if err != nil {
+			log.Fatalf("Failed to create index %s: %v", name, err)
+		}
+	}
+}
+
+// jsonFilters are conditions on invoice_json, each a JSON document the
+// invoice must contain, so they are answered from the GIN index
+type jsonFilters [][]byte
+
+// parseJSONFilters reads the json.<path>=<value> query parameters. A path is
+// the dot-separated field names of the e-invoice JSON; fields within lists
+// such as ItemList.HsnCd match when any entry has the value.
+func parseJSONFilters(c *gin.Context) (jsonFilters, error) {
+	query := c.Request.URL.Query()
+	paths := make([]string, 0)
+	for key := range query {
+		if strings.HasPrefix(key, jsonFilterPrefix) {
+			paths = append(paths, strings.TrimPrefix(key, jsonFilterPrefix))
+		}
+	}
+	if len(paths) > maxJSONFilters {
+		return nil, fmt.Errorf("at most %d json. filters can be given", maxJSONFilters)
+	}
+	sort.Strings(paths)
+
+	filters := make(jsonFilters, 0, len(paths))
+	for _, path := range paths {
+		for _, value := range query[jsonFilterPrefix+path] {
+			doc, err := jsonContainment(reflect.TypeOf(models.EInvoice{}), strings.Split(path, "."), value)
+			if err != nil {
+				return nil, fmt.Errorf("json.%s: %v", path, err)
+			}
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, data)
+		}
+	}
+	return filters, nil
+}
+
+// sql returns the conditions of the filters, adding their arguments to args.
+// alias qualifies the column, e.g. "i.".
+func (f jsonFilters) sql(alias string, args *[]interface{}) string {
+	var sb strings.Builder
+	for _, doc := range f {
+		*args = append(*args, string(doc))
+		fmt.Fprintf(&sb, " AND %sinvoice_json @> $%d::jsonb", alias, len(*args))
+	}
+	return sb.String()
+}
+
+// jsonContainment returns the JSON value containing value at the path of
+// field names below a field of type t, checking the path against the
+// invoice model and converting value to the field's JSON type
+func jsonContainment(t reflect.Type, names []string, value string) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonContainment(t.Elem(), names, value)
+	case reflect.Slice:
+		inner, err := jsonContainment(t.Elem(), names, value)
+		if err != nil {
+			return nil, err
+		}
+		return []interface{}{inner}, nil
+	case reflect.Struct:
+		if len(names) == 0 || names[0] == "" {
+			return nil, fmt.Errorf("the path must end at a single field")
+		}
+		field, ok := jsonFieldByName(t, names[0])
+		if !ok {
+			return nil, fmt.Errorf("unknown field %s", names[0])
+		}
+		inner, err := jsonContainment(field.Type, names[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{names[0]: inner}, nil
+	}
+
+	if len(names) > 0 {
+		return nil, fmt.Errorf("unknown field %s", names[0])
+	}
+	switch t.Kind() {
+	case reflect.String, reflect.Interface:
+		return value, nil
+	case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, fmt.Errorf("value must be a number")
+		}
+		return n, nil
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("value must be true or false")
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("the field cannot be filtered")
+}
+
+// jsonFieldByName returns the field of a struct with the given JSON name
+func jsonFieldByName(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if tag == name && field.IsExported() {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// eventUserLockOverridden audits the account owner changing or deleting an invoice
+// locked by its IRN or export; the payload is a lockOverride
+const eventUserLockOverridden = "user.invoice_lock_overridden"
+
+// lockedInvoiceError explains why registered invoices cannot be changed
+const lockedInvoiceError = "Invoice has been exported or registered with the IRP and can no longer be changed; issue a credit note to correct it"
+
+// isInvoiceLocked reports whether an invoice has been exported or given an
+// IRN. A registered tax document may not be edited or deleted; it is
+// corrected with a credit note.
+func isInvoiceLocked(exported bool, irn string) bool {
+	return exported || irn != ""
+}
+
+// lockOverride records the account owner's override of the invoice lock or, with
+// Period set, of a period lock
+type lockOverride struct {
+	InvoiceID int    `json:"invoice_id"`
+	InvoiceNo string `json:"invoice_no"`
+	Period    string `json:"period,omitempty"`
+	Action    string `json:"action"`
+	Reason    string `json:"reason"`
+	AdminID   int    `json:"admin_id"`
+}
+
+// lockOverrideReason returns the reason given in the override_reason query
+// parameter to change a locked invoice. Only the account owner may override
+// the lock, so members cannot change registered invoices; for anyone else, or without a reason, the lock is answered on c and ok is
+// false.
+func lockOverrideReason(c *gin.Context) (reason string, ok bool) {
+	reason = strings.TrimSpace(c.Query("override_reason"))
+	if reason == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": lockedInvoiceError, "locked": true})
+		return "", false
+	}
+
+	if !isAccountOwner(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the account owner can override the invoice lock", "code": errCodeOwnerRequired})
+		return "", false
+	}
+	return reason, true
+}
+
+// recordLockOverride audits an override of the invoice lock against the owner
+func recordLockOverride(ctx context.Context, db execer, userID int, override lockOverride) {
+	recordEvent(ctx, db, userID, eventUserLockOverridden, entityUser, override.AdminID, override)
+}
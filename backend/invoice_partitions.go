@@ -0,0 +1,457 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// invoicePartitionLockKey is the advisory lock held while invoice partitions
+// are created or detached, so that several app instances do not race
+const invoicePartitionLockKey = 7415002
+
+// invoicePartitionLayout names the monthly partitions, as in invoices_2025_04
+const invoicePartitionLayout = "2006_01"
+
+// invoiceArchivePrefix names detached partitions, as in invoices_archive_2019_04
+const invoiceArchivePrefix = "invoices_archive_"
+
+// getInvoicePartitionsAhead returns how many months of partitions are kept
+// ready after the current one
+func getInvoicePartitionsAhead() int {
+	months, err := strconv.Atoi(getEnvWithDefault("INVOICE_PARTITIONS_AHEAD", "3"))
+	if err != nil || months < 1 {
+		log.Printf("Invalid INVOICE_PARTITIONS_AHEAD value, defaulting to 3")
+		months = 3
+	}
+	return months
+}
+
+// getInvoiceArchiveMonths returns the age in months after which invoice
+// partitions are detached for archival; 0 keeps every partition attached
+func getInvoiceArchiveMonths() int {
+	months, err := strconv.Atoi(getEnvWithDefault("INVOICE_ARCHIVE_AFTER_MONTHS", "0"))
+	if err != nil || months < 0 {
+		log.Printf("Invalid INVOICE_ARCHIVE_AFTER_MONTHS value, defaulting to 0")
+		months = 0
+	}
+	return months
+}
+
+// createInvoicePartitions partitions the invoices table by month of
+// created_at, which never changes once an invoice is stored. PostgreSQL
+// requires the keys of a partitioned table to include the partition key, so
+// invoices are keyed by (id, created_at) and invoice_keys holds the ID and
+// number of every invoice: tables that reference invoices reference it, and
+// it keeps invoice numbers unique within an account. A trigger keeps it in
+// step with invoices, and deleting an invoice deletes its key, which cascades
+// to the rows referencing it as before. Invoices created outside the
+// monthly partitions, such as under a skewed clock, go to invoices_default.
+//
+// Earlier versions kept invoices in one table, which is converted on the
+// first start: its rows are copied into monthly partitions and the foreign
+// keys onto it are moved to invoice_keys. The partitions of the coming
+// months are created before the server accepts requests.
+func createInvoicePartitions() {
+	ctx := context.Background()
+
+	_, err := dbPool.Exec(ctx, `
+		CREATE TABLE IF NOT EXISTS invoice_keys (
+			id INTEGER PRIMARY KEY,
+			user_id INTEGER NOT NULL,
+			invoice_no VARCHAR(50) NOT NULL,
+			UNIQUE (user_id, invoice_no)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_keys table: %v", err)
+	}
+
+	// Invoice numbers are unique within an account, which numbers its own
+	// series; earlier versions required them to be unique across accounts
+	_, err = dbPool.Exec(ctx,
+		"CREATE UNIQUE INDEX IF NOT EXISTS invoice_keys_user_id_invoice_no_key ON invoice_keys (user_id, invoice_no)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice number index: %v", err)
+	}
+	_, err = dbPool.Exec(ctx, "ALTER TABLE invoice_keys DROP CONSTRAINT IF EXISTS invoice_keys_invoice_no_key")
+	if err != nil {
+		log.Fatalf("Failed to drop global invoice number constraint: %v", err)
+	}
+
+	var unpartitioned bool
+	err = dbPool.QueryRow(ctx, "SELECT relkind = 'r' FROM pg_class WHERE oid = 'invoices'::regclass").Scan(&unpartitioned)
+	if err != nil {
+		log.Fatalf("Failed to check invoices table: %v", err)
+	}
+	if unpartitioned {
+		if err := partitionInvoicesTable(ctx); err != nil {
+			log.Fatalf("Failed to partition invoices table: %v", err)
+		}
+	}
+
+	_, err = dbPool.Exec(ctx, "CREATE TABLE IF NOT EXISTS invoices_default PARTITION OF invoices DEFAULT")
+	if err != nil {
+		log.Fatalf("Failed to create default invoice partition: %v", err)
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		log.Fatalf("Failed to create invoice key trigger: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	for _, stmt := range []string{`
+		CREATE OR REPLACE FUNCTION sync_invoice_keys() RETURNS trigger LANGUAGE plpgsql AS $$
+		BEGIN
+			IF TG_OP = 'INSERT' THEN
+				INSERT INTO invoice_keys (id, user_id, invoice_no) VALUES (NEW.id, NEW.user_id, NEW.invoice_no);
+			ELSIF TG_OP = 'UPDATE' THEN
+				UPDATE invoice_keys SET user_id = NEW.user_id, invoice_no = NEW.invoice_no WHERE id = NEW.id;
+			ELSE
+				DELETE FROM invoice_keys WHERE id = OLD.id;
+			END IF;
+			RETURN NULL;
+		END
+		$$`,
+		"DROP TRIGGER IF EXISTS invoices_sync_keys ON invoices", `
+		CREATE TRIGGER invoices_sync_keys
+		AFTER INSERT OR DELETE OR UPDATE OF user_id, invoice_no ON invoices
+		FOR EACH ROW EXECUTE FUNCTION sync_invoice_keys()`,
+	} {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			log.Fatalf("Failed to create invoice key trigger: %v", err)
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		log.Fatalf("Failed to create invoice key trigger: %v", err)
+	}
+
+	// Invoice number lookups within an account
+	_, err = dbPool.Exec(ctx,
+		"CREATE INDEX IF NOT EXISTS idx_invoices_user_invoice_no ON invoices (user_id, invoice_no)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice number index: %v", err)
+	}
+
+	if err := maintainInvoicePartitions(ctx); err != nil {
+		log.Fatalf("Failed to create invoice partitions: %v", err)
+	}
+}
+
+// partitionInvoicesTable converts the unpartitioned invoices table of an
+// earlier version in one transaction
+func partitionInvoicesTable(ctx context.Context) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", invoicePartitionLockKey); err != nil {
+		return err
+	}
+	// Another instance may have converted the table while this one waited
+	var unpartitioned bool
+	if err := tx.QueryRow(ctx, "SELECT relkind = 'r' FROM pg_class WHERE oid = 'invoices'::regclass").Scan(&unpartitioned); err != nil {
+		return err
+	}
+	if !unpartitioned {
+		return nil
+	}
+	if _, err := tx.Exec(ctx, "LOCK TABLE invoices IN ACCESS EXCLUSIVE MODE"); err != nil {
+		return err
+	}
+
+	var first time.Time
+	var count int64
+	err = tx.QueryRow(ctx,
+		"SELECT COALESCE(date_trunc('month', MIN(created_at)), date_trunc('month', NOW()::timestamp)), COUNT(*) FROM invoices").
+		Scan(&first, &count)
+	if err != nil {
+		return err
+	}
+	log.Printf("Partitioning the invoices table by month; copying %d invoice(s)...", count)
+
+	// The table's own foreign keys, those of other tables onto it, its
+	// other indexes and its columns are read before it is renamed
+	ownKeys, err := queryConstraints(ctx, tx,
+		"SELECT '', conname, pg_get_constraintdef(oid) FROM pg_constraint WHERE conrelid = 'invoices'::regclass AND contype = 'f'")
+	if err != nil {
+		return err
+	}
+	references, err := queryConstraints(ctx, tx,
+		"SELECT conrelid::regclass::text, conname, pg_get_constraintdef(oid) FROM pg_constraint WHERE confrelid = 'invoices'::regclass AND contype = 'f'")
+	if err != nil {
+		return err
+	}
+	indexes, err := queryConstraints(ctx, tx,
+		"SELECT '', '', pg_get_indexdef(indexrelid) FROM pg_index WHERE indrelid = 'invoices'::regclass AND NOT indisunique")
+	if err != nil {
+		return err
+	}
+	var columns, sequence, primaryKey string
+	err = tx.QueryRow(ctx, `
+		SELECT
+			(SELECT string_agg(quote_ident(attname), ', ' ORDER BY attnum) FROM pg_attribute
+			 WHERE attrelid = 'invoices'::regclass AND attnum > 0 AND NOT attisdropped AND attgenerated = ''),
+			COALESCE(pg_get_serial_sequence('invoices', 'id'), ''),
+			COALESCE((SELECT conname FROM pg_constraint WHERE conrelid = 'invoices'::regclass AND contype = 'p'), '')
+	`).Scan(&columns, &sequence, &primaryKey)
+	if err != nil {
+		return err
+	}
+
+	stmts := make([]string, 0)
+	for _, ref := range references {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT %s", ref.table, pgx.Identifier{ref.name}.Sanitize()))
+	}
+	stmts = append(stmts, "ALTER TABLE invoices RENAME TO invoices_unpartitioned")
+	if primaryKey != "" {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE invoices_unpartitioned RENAME CONSTRAINT %s TO invoices_unpartitioned_pkey",
+			pgx.Identifier{primaryKey}.Sanitize()))
+	}
+	stmts = append(stmts, `
+		CREATE TABLE invoices (
+			LIKE invoices_unpartitioned INCLUDING DEFAULTS INCLUDING CONSTRAINTS INCLUDING GENERATED,
+			PRIMARY KEY (id, created_at)
+		) PARTITION BY RANGE (created_at)`)
+	for _, key := range ownKeys {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE invoices ADD CONSTRAINT %s %s", pgx.Identifier{key.name}.Sanitize(), key.def))
+	}
+	if sequence != "" {
+		stmts = append(stmts, "ALTER SEQUENCE "+sequence+" OWNED BY invoices.id")
+	}
+	for _, month := range monthsBetween(first, currentMonth().AddDate(0, getInvoicePartitionsAhead(), 0)) {
+		stmts = append(stmts, createInvoicePartitionSQL(month))
+	}
+	stmts = append(stmts,
+		"CREATE TABLE IF NOT EXISTS invoices_default PARTITION OF invoices DEFAULT",
+		"INSERT INTO invoices ("+columns+") SELECT "+columns+" FROM invoices_unpartitioned",
+		"INSERT INTO invoice_keys (id, user_id, invoice_no) SELECT id, user_id, invoice_no FROM invoices ON CONFLICT (id) DO NOTHING",
+		"DROP TABLE invoices_unpartitioned",
+	)
+	// Index definitions name the table, which is invoices again
+	for _, index := range indexes {
+		stmts = append(stmts, index.def)
+	}
+	for _, ref := range references {
+		stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s %s", ref.table, pgx.Identifier{ref.name}.Sanitize(),
+			strings.Replace(ref.def, "REFERENCES invoices(id)", "REFERENCES invoice_keys(id)", 1)))
+	}
+
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(ctx, stmt); err != nil {
+			return fmt.Errorf("%w (in %s)", err, strings.TrimSpace(stmt))
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+	log.Printf("Partitioned the invoices table by month")
+	return nil
+}
+
+// catalogConstraint is a constraint or index read from the catalog
+type catalogConstraint struct {
+	table string
+	name  string
+	def   string
+}
+
+// queryConstraints reads table, name and definition rows
+func queryConstraints(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) ([]catalogConstraint, error) {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	constraints := make([]catalogConstraint, 0)
+	for rows.Next() {
+		var c catalogConstraint
+		if err := rows.Scan(&c.table, &c.name, &c.def); err != nil {
+			return nil, err
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, rows.Err()
+}
+
+// currentMonth returns the first day of the current month
+func currentMonth() time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// monthsBetween returns the first day of each month from first to last
+func monthsBetween(first, last time.Time) []time.Time {
+	months := make([]time.Time, 0)
+	for m := time.Date(first.Year(), first.Month(), 1, 0, 0, 0, 0, time.UTC); !m.After(last); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+	return months
+}
+
+// createInvoicePartitionSQL creates the partition of a month's invoices
+func createInvoicePartitionSQL(month time.Time) string {
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS invoices_%s PARTITION OF invoices FOR VALUES FROM ('%s') TO ('%s')",
+		month.Format(invoicePartitionLayout), month.Format(time.DateOnly), month.AddDate(0, 1, 0).Format(time.DateOnly))
+}
+
+// maintainInvoicePartitions creates the partitions of the coming
+// INVOICE_PARTITIONS_AHEAD months and, with INVOICE_ARCHIVE_AFTER_MONTHS
+// set, detaches partitions older than that for archival. A month whose
+// invoices already went to invoices_default keeps them there, as its
+// partition cannot be created over them.
+//
+// Detached partitions stay in the database as invoices_archive_YYYY_MM
+// tables, out of the app's sight, and can be dumped and dropped or attached
+// again. Their invoice keys are kept, so their numbers stay taken and the
+// rows that reference them stay valid. They lose their foreign keys, so that
+// purging an account is not blocked by its archived invoices; the purge
+// deletes those too (see purgeArchivedInvoices).
+func maintainInvoicePartitions(ctx context.Context) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", invoicePartitionLockKey).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		// Another instance is maintaining the partitions
+		return nil
+	}
+
+	month := currentMonth()
+	for _, m := range monthsBetween(month, month.AddDate(0, getInvoicePartitionsAhead(), 0)) {
+		var misplaced bool
+		err := tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM invoices_default WHERE created_at >= $1 AND created_at < $2)",
+			m, m.AddDate(0, 1, 0)).Scan(&misplaced)
+		if err != nil {
+			return err
+		}
+		if misplaced {
+			log.Printf("Invoices of %s are in the default invoice partition; not creating its partition", m.Format("2006-01"))
+			continue
+		}
+		if _, err := tx.Exec(ctx, createInvoicePartitionSQL(m)); err != nil {
+			return fmt.Errorf("failed to create invoice partition for %s: %w", m.Format("2006-01"), err)
+		}
+	}
+
+	if archiveMonths := getInvoiceArchiveMonths(); archiveMonths > 0 {
+		cutoff := month.AddDate(0, -archiveMonths, 0)
+		rows, err := tx.Query(ctx, `
+			SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid
+			WHERE i.inhparent = 'invoices'::regclass AND c.relname ~ '^invoices_[0-9]{4}_[0-9]{2}$'
+			ORDER BY c.relname
+		`)
+		if err != nil {
+			return err
+		}
+		partitions := make([]string, 0)
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return err
+			}
+			partitions = append(partitions, name)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, name := range partitions {
+			m, err := time.Parse(invoicePartitionLayout, strings.TrimPrefix(name, "invoices_"))
+			if err != nil || !m.Before(cutoff) {
+				continue
+			}
+			archived := invoiceArchivePrefix + m.Format(invoicePartitionLayout)
+			if _, err := tx.Exec(ctx, "ALTER TABLE invoices DETACH PARTITION "+name); err != nil {
+				return fmt.Errorf("failed to detach invoice partition %s: %w", name, err)
+			}
+			if _, err := tx.Exec(ctx, "ALTER TABLE "+name+" RENAME TO "+archived); err != nil {
+				return fmt.Errorf("failed to rename invoice partition %s: %w", name, err)
+			}
+			keys, err := queryConstraints(ctx, tx,
+				"SELECT '', conname, '' FROM pg_constraint WHERE conrelid = $1::regclass AND contype = 'f'", archived)
+			if err != nil {
+				return err
+			}
+			for _, key := range keys {
+				if _, err := tx.Exec(ctx, "ALTER TABLE "+archived+" DROP CONSTRAINT "+pgx.Identifier{key.name}.Sanitize()); err != nil {
+					return fmt.Errorf("failed to drop foreign key of %s: %w", archived, err)
+				}
+			}
+			log.Printf("Detached invoice partition %s for archival as %s", name, archived)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// purgeArchivedInvoices deletes an account's invoices from the archived
+// partitions and the keys of all its invoices, with the rows that reference
+// them, when the account is purged. Archived invoices are older than any
+// attached one, so their tax record retention has ended by then.
+func purgeArchivedInvoices(ctx context.Context, tx pgx.Tx, userID int) error {
+	rows, err := tx.Query(ctx,
+		"SELECT relname FROM pg_class WHERE relkind = 'r' AND relname LIKE 'invoices\\_archive\\_%' ORDER BY relname")
+	if err != nil {
+		return err
+	}
+	archives := make([]string, 0)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		archives = append(archives, name)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, name := range archives {
+		if _, err := tx.Exec(ctx, "DELETE FROM "+pgx.Identifier{name}.Sanitize()+" WHERE user_id = $1", userID); err != nil {
+			return fmt.Errorf("failed to purge %s: %w", name, err)
+		}
+	}
+	_, err = tx.Exec(ctx, "DELETE FROM invoice_keys WHERE user_id = $1", userID)
+	return err
+}
+
+// startInvoicePartitionMaintainer keeps invoice partitions ready ahead of
+// time, and archives old ones, once a day
+func startInvoicePartitionMaintainer(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			if err := maintainInvoicePartitions(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error maintaining invoice partitions: %v", err)
+			}
+		}
+	}()
+}
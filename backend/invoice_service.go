@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// invoiceCaller is who an invoice operation runs for: the account, the user
+// acting on it and, for members, their role. The HTTP handlers and the gRPC
+// service both call the service functions below with one.
+type invoiceCaller struct {
+	userID  int
+	actorID int
+	role    string
+	// periodOverride is an admin's reason for creating invoices dated in a
+	// locked period; without one they are refused
+	periodOverride string
+}
+
+// callerFromContext returns the caller set up by authMiddleware and accountMiddleware
+func callerFromContext(c *gin.Context) invoiceCaller {
+	return invoiceCaller{userID: c.GetInt("userID"), actorID: c.GetInt("actorID"), role: c.GetString("memberRole")}
+}
+
+// callerWithOverride returns the caller of a request creating invoices. The
+// account owner may date them in a locked period, giving a reason in
+// override_reason; anyone else giving one is answered on c and ok is false.
+func callerWithOverride(c *gin.Context) (caller invoiceCaller, ok bool) {
+	caller = callerFromContext(c)
+	if reason := strings.TrimSpace(c.Query("override_reason")); reason != "" {
+		if !isAccountOwner(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the account owner can override the period lock", "code": errCodeOwnerRequired})
+			return caller, false
+		}
+		caller.periodOverride = reason
+	}
+	return caller, true
+}
+
+// generateInvoices numbers, validates, totals and stores a batch of invoices
+// with status, draft or finalized. The batch is created in one transaction:
+// if any invoice fails, none are stored, invoice numbers are not used up, and
+// an importError identifies the failed one.
+func generateInvoices(ctx context.Context, caller invoiceCaller, invoices []models.EInvoice, status string) ([]gin.H, error) {
+	if !isValidCreateStatus(status) {
+		return nil, newImportError(http.StatusBadRequest, "Status must be draft or finalized")
+	}
+	if len(invoices) == 0 {
+		return nil, newImportError(http.StatusBadRequest, "No invoice data provided")
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback(ctx)
+
+	results, err := storeNewInvoices(ctx, tx, caller, invoices, status)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Failed to store invoices")
+	}
+	return results, nil
+}
+
+// storeNewInvoices numbers, validates, totals and stores invoices as
+// generateInvoices does, within tx, so callers can store what the invoices
+// were made from in the same transaction. Invoices without a number are
+// numbered next in the invoice series when finalized.
+func storeNewInvoices(ctx context.Context, tx pgx.Tx, caller invoiceCaller, invoices []models.EInvoice, status string) ([]gin.H, error) {
+	userID := caller.userID
+	results := make([]gin.H, 0, len(invoices))
+	settings := getUserSettings(ctx, userID)
+
+	// Invoices of accountants in approval mode wait for an approver
+	status = issueStatus(caller.role, settings, status)
+
+	// failed reports the invoice that stopped the batch
+	failed := func(status, i int, invoice *models.EInvoice, message string) error {
+		return &importError{status: status, message: message, failed: failedInvoice(i, invoice)}
+	}
+
+	locks, err := getPeriodLocks(ctx, userID)
+	if err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Failed to check locked periods")
+	}
+
+	for i, invoice := range invoices {
+		// Issue invoices that name no seller from the default company
+		if invoice.SellerDtls.Gstin == "" {
+			if co := getDefaultCompany(ctx, userID, settings); co != nil {
+				invoice.SellerDtls = sellerFromCompany(co)
+			}
+		}
+
+		// Fill in the user's defaults and convert the date from their date format
+		if err := settings.ApplyDefaults(&invoice); err != nil {
+			return nil, failed(http.StatusBadRequest, i, &invoice, err.Error())
+		}
+
+		// Invoices dated in a locked period need an admin's override
+		period := locks.find(invoiceDocDate(&invoice))
+		if period != "" && caller.periodOverride == "" {
+			return nil, &importError{
+				status: http.StatusConflict, message: lockedPeriodError(period),
+				failed: failedInvoice(i, &invoice), period: period,
+			}
+		}
+
+		// Convert foreign currency prices at the rate for the invoice date
+		precision := getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+		if err := resolveExchangeRate(ctx, &invoice, precision); err != nil {
+			return nil, failed(http.StatusBadRequest, i, &invoice, err.Error())
+		}
+
+		// Assign a number to invoices submitted without one; invoices awaiting
+		// approval are numbered when they are approved
+		if invoice.DocDtls.No == "" {
+			if status != models.InvoiceStatusFinalized {
+				invoice.DocDtls.No = fmt.Sprintf("%s%d-%d", draftNumberPrefix, userID, time.Now().UnixNano())
+			} else {
+				invoiceNo, err := nextInvoiceNumber(ctx, tx, userID, invoiceSeriesPrefix(settings, invoice.DocDtls.Dt))
+				if err != nil {
+					return nil, failed(http.StatusInternalServerError, i, &invoice, "Failed to assign invoice number")
+				}
+				invoice.DocDtls.No = invoiceNo
+			}
+		}
+
+		// Validate invoice data and check state codes and HSN codes against
+		// the masters; drafts are held to their company's validation profile
+		warnings, err := validateInvoice(ctx, userID, &invoice, status)
+		if err != nil {
+			return nil, failed(http.StatusBadRequest, i, &invoice, err.Error())
+		}
+
+		// Calculate totals
+		invoice.CalculateTotalsWithPrecision(precision)
+
+		invoiceJSON, err := json.Marshal(invoice)
+		if err != nil {
+			return nil, failed(http.StatusInternalServerError, i, &invoice, "Failed to serialize invoice")
+		}
+
+		var invoiceID int
+		agg := aggregatesOf(&invoice, time.Now())
+		err = tx.QueryRow(ctx,
+			`INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, status, finalized_at, sandbox, created_at,
+				total_value, taxable_value, tax_amount, buyer_gstin, invoice_date, gstr1_section,
+				igst_amount, cgst_amount, sgst_amount, doc_type, reverse_charge, customer_id)
+			VALUES ($1, $2, $3, $4, $5, CASE WHEN $5 = 'finalized' THEN NOW() END, `+sandboxFlagSQL+`, NOW(),
+				$6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, `+invoiceCustomerSQL("$1", "$9", "$4")+`)
+			RETURNING id`,
+			userID, invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoiceJSON, status,
+			agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.invoiceDate, agg.gstr1Section,
+			agg.igstAmount, agg.cgstAmount, agg.sgstAmount, agg.docType, agg.reverseCharge).Scan(&invoiceID)
+		if isUniqueViolation(err) {
+			return nil, failed(http.StatusConflict, i, &invoice, fmt.Sprintf("Invoice %s already exists", invoice.DocDtls.No))
+		}
+		if err != nil {
+			log.Printf("Error storing invoice %s: %v", invoice.DocDtls.No, err)
+			return nil, failed(http.StatusInternalServerError, i, &invoice, "Failed to store invoice")
+		}
+		recordInvoiceEvent(ctx, tx, eventInvoiceCreated, invoiceID)
+		if period != "" {
+			recordPeriodLockOverride(ctx, tx, userID, lockOverride{
+				InvoiceID: invoiceID, InvoiceNo: invoice.DocDtls.No, Period: period, Action: "create",
+				Reason: caller.periodOverride, AdminID: caller.actorID,
+			})
+		}
+		if status == models.InvoiceStatusPendingApproval {
+			if err := recordApproval(ctx, tx, userID, invoiceID, caller.actorID, models.ApprovalSubmitted, ""); err != nil {
+				return nil, failed(http.StatusInternalServerError, i, &invoice, "Failed to submit invoice for approval")
+			}
+		}
+
+		results = append(results, gin.H{
+			"id":         invoiceID,
+			"invoice_no": invoice.DocDtls.No,
+			"status":     status,
+			"qr_url":     qrURL(invoiceID, invoiceQRHash(&invoice)),
+			"warnings":   warnings,
+		})
+	}
+	return results, nil
+}
+
+// invoiceRecord is a stored invoice with the columns kept beside its JSON
+type invoiceRecord struct {
+	ID          int
+	InvoiceNo   string
+	SellerGSTIN string
+	Status      string
+	IRN         string
+	Exported    bool
+	Sandbox     bool
+	QRVersion   string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	InvoiceJSON []byte
+}
+
+// invoiceRecordColumns are selected by scanInvoiceRecord
+const invoiceRecordColumns = `id, invoice_no, seller_gstin, status, COALESCE(irn, ''), exported, sandbox, ` + qrHashSQL + `,
+	created_at, updated_at, invoice_json`
+
+// scanInvoiceRecord reads a row of invoiceRecordColumns
+func scanInvoiceRecord(row pgx.Row) (*invoiceRecord, error) {
+	var r invoiceRecord
+	err := row.Scan(&r.ID, &r.InvoiceNo, &r.SellerGSTIN, &r.Status, &r.IRN, &r.Exported, &r.Sandbox, &r.QRVersion,
+		&r.CreatedAt, &r.UpdatedAt, &r.InvoiceJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// getInvoice returns one of the account's invoices, or pgx.ErrNoRows
+func getInvoice(ctx context.Context, userID, id int) (*invoiceRecord, error) {
+	return scanInvoiceRecord(dbPool.QueryRow(ctx,
+		"SELECT "+invoiceRecordColumns+" FROM invoices WHERE id = $1 AND user_id = $2", id, userID))
+}
+
+// invoiceListBatch is how many invoices listInvoices reads per query
+const invoiceListBatch = 500
+
+// listInvoices calls fn for each of the account's invoices with an ID above
+// afterID that matches the filter and, when set, status, in ID order. It
+// reads invoiceListBatch invoices at a time, so fn can stream them out; it
+// stops at the first error fn returns.
+func listInvoices(ctx context.Context, userID int, filter *exportFilter, status string, afterID int, fn func(*invoiceRecord) error) error {
+	for {
+		args := append([]interface{}{userID, afterID}, filter.args()...)
+		args = append(args, status, invoiceListBatch)
+		rows, err := dbPool.Query(ctx, `
+			SELECT `+invoiceRecordColumns+`
+			FROM invoices
+			WHERE user_id = $1 AND id > $2 AND NOT quarantined AND `+exportFilterSQL+`
+				AND ($7 = '' OR status = $7)
+			ORDER BY id
+			LIMIT $8
+		`, args...)
+		if err != nil {
+			return err
+		}
+		batch := make([]*invoiceRecord, 0, invoiceListBatch)
+		for rows.Next() {
+			r, err := scanInvoiceRecord(rows)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			batch = append(batch, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, r := range batch {
+			afterID = r.ID
+			if filter.from != nil || filter.to != nil {
+				var doc struct {
+					DocDtls struct {
+						Dt string `json:"Dt"`
+					} `json:"DocDtls"`
+				}
+				if json.Unmarshal(r.InvoiceJSON, &doc) != nil || !filter.matchesDate(doc.DocDtls.Dt) {
+					continue
+				}
+			}
+			if err := fn(r); err != nil {
+				return err
+			}
+		}
+		if len(batch) < invoiceListBatch {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// cancellationWindow is how long after registration with the IRP an invoice may be cancelled
+const cancellationWindow = 24 * time.Hour
+
+// isValidCreateStatus reports whether invoices can be created with the given status
+func isValidCreateStatus(status string) bool {
+	return status == models.InvoiceStatusDraft || status == models.InvoiceStatusFinalized
+}
+
+// handleFinalizeInvoice freezes a draft invoice, assigning its number if it
+// has none. Drafts of accountants in approval mode are submitted for approval
+// instead, and approvers may finalize invoices awaiting approval.
+func handleFinalizeInvoice(c *gin.Context) {
+	finalizeInvoice(c, false)
+}
+
+// finalizeInvoice finalizes an invoice for handleFinalizeInvoice, or with
+// approving for handleApproveInvoice, which only finalizes invoices awaiting
+// approval and records the approver's comment
+func finalizeInvoice(c *gin.Context, approving bool) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	// An explicit invoice number is optional
+	var req struct {
+		InvoiceNo string `json:"invoice_no"`
+		Comment   string `json:"comment"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var status, invoiceNo string
+	var invoiceJSON []byte
+	err = tx.QueryRow(ctx, `
+		SELECT status, invoice_no, invoice_json FROM invoices
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE
+	`, id, userID).Scan(&status, &invoiceNo, &invoiceJSON)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	settings := getUserSettings(ctx, userID)
+	switch {
+	case approving && status != models.InvoiceStatusPendingApproval:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only invoices awaiting approval can be approved; invoice is %s", status)})
+		return
+	case status == models.InvoiceStatusPendingApproval && !canApprove(c):
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invoice is awaiting approval"})
+		return
+	case status == models.InvoiceStatusDraft && requiresApproval(c, settings):
+		submitForApproval(c, tx, userID, id, invoiceJSON)
+		return
+	case status != models.InvoiceStatusDraft && status != models.InvoiceStatusPendingApproval:
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only draft invoices can be finalized; invoice is %s", status)})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+
+	// Invoices dated in a locked period can only be issued with an admin override
+	periodOverride, ok := checkPeriodLock(c, userID, invoiceDocDate(&invoice))
+	if !ok {
+		return
+	}
+
+	// Assign the final invoice number
+	switch {
+	case req.InvoiceNo != "":
+		invoiceNo = req.InvoiceNo
+	case strings.HasPrefix(invoiceNo, draftNumberPrefix):
+		invoiceNo, err = nextInvoiceNumber(ctx, tx, userID, invoiceSeriesPrefix(settings, invoice.DocDtls.Dt))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	invoice.DocDtls.No = invoiceNo
+
+	// Drafts take the exchange rate of the date they are issued with
+	precision := getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+	if err := resolveExchangeRate(ctx, &invoice, precision); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
+		return
+	}
+	// Drafts saved under the lenient profile must be compliant by now
+	if err := validateForFinalizing(ctx, userID, &invoice); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
+		return
+	}
+	invoice.CalculateTotalsWithPrecision(precision)
+
+	invoiceJSON, err = json.Marshal(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
+		return
+	}
+
+	agg := aggregatesOf(&invoice, time.Now())
+	_, err = tx.Exec(ctx, `
+		UPDATE invoices
+		SET status = 'finalized', finalized_at = NOW(), invoice_no = $1, invoice_json = $2,
+			qr_code = NULL, qr_object_key = NULL, updated_at = NOW(),
+			total_value = $5, taxable_value = $6, tax_amount = $7, buyer_gstin = $8, invoice_date = $9,
+			gstr1_section = $10, igst_amount = $11, cgst_amount = $12, sgst_amount = $13, doc_type = $14,
+			reverse_charge = $15
+		WHERE id = $3 AND user_id = $4
+	`, invoiceNo, invoiceJSON, id, userID,
+		agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.invoiceDate, agg.gstr1Section,
+		agg.igstAmount, agg.cgstAmount, agg.sgstAmount, agg.docType, agg.reverseCharge)
+	if err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Failed to finalize invoice: " + err.Error()})
+		return
+	}
+	if status == models.InvoiceStatusPendingApproval {
+		err := recordApproval(ctx, tx, userID, id, c.GetInt("actorID"), models.ApprovalApproved, strings.TrimSpace(req.Comment))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record approval"})
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to finalize invoice"})
+		return
+	}
+	recordInvoiceEvent(ctx, dbPool, eventInvoiceFinalized, id)
+	periodOverride.InvoiceID, periodOverride.InvoiceNo, periodOverride.Action = id, invoiceNo, "finalize"
+	recordPeriodLockOverride(ctx, dbPool, userID, periodOverride)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Invoice finalized successfully",
+		"invoice_id": id,
+		"invoice_no": invoiceNo,
+	})
+}
+
+// handleCancelInvoice cancels a finalized invoice. Invoices already registered
+// with the IRP can only be cancelled within 24 hours of registration.
+func handleCancelInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		Reason string `json:"reason"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if strings.TrimSpace(req.Reason) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cancellation reason is required"})
+		return
+	}
+
+	var status, irn string
+	var exportedAt *time.Time
+	ctx := c.Request.Context()
+	err = dbPool.QueryRow(ctx,
+		"SELECT status, COALESCE(irn, ''), exported_at FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&status, &irn, &exportedAt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	switch status {
+	case models.InvoiceStatusDraft:
+		c.JSON(http.StatusConflict, gin.H{"error": "Draft invoices cannot be cancelled; delete the draft instead"})
+		return
+	case models.InvoiceStatusPendingApproval:
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoices awaiting approval cannot be cancelled; reject the invoice instead"})
+		return
+	case models.InvoiceStatusCancelled:
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice is already cancelled"})
+		return
+	}
+
+	// Invoices with an IRN must also be cancelled at the IRP
+	if irn != "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice has an IRN; cancel it with /cancel-irn instead"})
+		return
+	}
+
+	if exportedAt != nil && time.Since(*exportedAt) > cancellationWindow {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "Invoices registered with the IRP can only be cancelled within 24 hours; issue a credit note instead",
+		})
+		return
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		UPDATE invoices
+		SET status = 'cancelled', cancel_reason = $1, cancelled_at = NOW(), updated_at = NOW()
+		WHERE id = $2 AND user_id = $3
+	`, req.Reason, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel invoice"})
+		return
+	}
+	recordInvoiceEvent(ctx, dbPool, eventInvoiceCancelled, id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Invoice cancelled successfully",
+		"invoice_id": id,
+	})
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleValidateInvoice runs an invoice through the checks of POST
+// /generate-invoice without storing it or using up an invoice number, so
+// forms can validate as the user types. The response carries the invoice
+// with its defaults and computed totals, the error that would reject it, the
+// e-invoice schema violations and the master data warnings. An invoice
+// without a number is checked as if one were assigned. Pass ?status=draft to
+// check it as a draft under the seller company's validation profile.
+func handleValidateInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	status := c.DefaultQuery("status", models.InvoiceStatusFinalized)
+	if !isValidCreateStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be draft or finalized"})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := c.ShouldBindJSON(&invoice); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	respond := func(message string, violations []schemaViolation, warnings []string) {
+		if violations == nil {
+			violations = []schemaViolation{}
+		}
+		if warnings == nil {
+			warnings = []string{}
+		}
+		body := gin.H{
+			"valid":      message == "" && len(violations) == 0,
+			"invoice":    invoice,
+			"totals":     invoice.ValDtls,
+			"violations": violations,
+			"warnings":   warnings,
+		}
+		if message != "" {
+			body["error"] = message
+		}
+		c.JSON(http.StatusOK, body)
+	}
+
+	// Fill in the seller and defaults as generating the invoice would
+	settings := getUserSettings(ctx, userID)
+	if invoice.SellerDtls.Gstin == "" {
+		if co := getDefaultCompany(ctx, userID, settings); co != nil {
+			invoice.SellerDtls = sellerFromCompany(co)
+		}
+	}
+	if err := settings.ApplyDefaults(&invoice); err != nil {
+		respond(err.Error(), nil, nil)
+		return
+	}
+	precision := getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+	if err := resolveExchangeRate(ctx, &invoice, precision); err != nil {
+		respond(err.Error(), nil, nil)
+		return
+	}
+
+	// Totals are computed even for invoices that fail the checks below
+	invoice.CalculateTotalsWithPrecision(precision)
+
+	// The number is checked against the schema only when it was given
+	invoiceJSON, err := json.Marshal(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
+		return
+	}
+	schemaViolations, err := validateInvoiceJSON(invoiceJSON)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check invoice schema"})
+		return
+	}
+	violations := make([]schemaViolation, 0, len(schemaViolations))
+	for _, v := range schemaViolations {
+		if invoice.DocDtls.No == "" && v.Path == "/DocDtls/No" {
+			continue
+		}
+		violations = append(violations, v)
+	}
+
+	warnings, err := validateInvoice(ctx, userID, &invoice, status)
+	if err != nil {
+		respond(err.Error(), violations, nil)
+		return
+	}
+
+	if invoice.DocDtls.No != "" {
+		var exists bool
+		err := dbPool.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM invoice_keys WHERE user_id = $1 AND invoice_no = $2)",
+			userID, invoice.DocDtls.No).Scan(&exists)
+		if err != nil {
+			log.Printf("Error checking invoice number %s: %v", invoice.DocDtls.No, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if exists {
+			respond(fmt.Sprintf("Invoice %s already exists", invoice.DocDtls.No), violations, warnings)
+			return
+		}
+	}
+
+	respond("", violations, warnings)
+}
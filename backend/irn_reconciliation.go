@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// signedQRData is the invoice summary the IRP signs into the QR code JWT
+type signedQRData struct {
+	SellerGstin string  `json:"SellerGstin"`
+	BuyerGstin  string  `json:"BuyerGstin"`
+	DocNo       string  `json:"DocNo"`
+	DocTyp      string  `json:"DocTyp"`
+	DocDt       string  `json:"DocDt"`
+	TotInvVal   float64 `json:"TotInvVal"`
+	ItemCnt     int     `json:"ItemCnt"`
+	MainHsnCode string  `json:"MainHsnCode"`
+	Irn         string  `json:"Irn"`
+	IrnDt       string  `json:"IrnDt"`
+}
+
+// decodeSignedQR reads the invoice summary from a signed QR code. The
+// signature is not verified; the code was stored as received from the IRP.
+func decodeSignedQR(token string) (*signedQRData, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("not a JWT")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, err
+	}
+	var claims struct {
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, err
+	}
+	var data signedQRData
+	if err := json.Unmarshal([]byte(claims.Data), &data); err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+// irnMismatch is a field whose registered value differs from the stored invoice
+type irnMismatch struct {
+	Field string `json:"field"`
+	Local string `json:"local"`
+	IRP   string `json:"irp"`
+}
+
+// irnMismatches compares a stored invoice with the summary signed by the IRP
+func irnMismatches(invoice *models.EInvoice, irn string, signed *signedQRData) []irnMismatch {
+	mismatches := make([]irnMismatch, 0)
+	compare := func(field, local, irp string) {
+		if !strings.EqualFold(strings.TrimSpace(local), strings.TrimSpace(irp)) {
+			mismatches = append(mismatches, irnMismatch{Field: field, Local: local, IRP: irp})
+		}
+	}
+	compare("Irn", irn, signed.Irn)
+	compare("DocNo", invoice.DocDtls.No, signed.DocNo)
+	compare("DocDt", invoice.DocDtls.Dt, signed.DocDt)
+	compare("SellerGstin", invoice.SellerDtls.Gstin, signed.SellerGstin)
+	if invoice.BuyerDtls.Gstin != "" || signed.BuyerGstin != "" {
+		compare("BuyerGstin", invoice.BuyerDtls.Gstin, signed.BuyerGstin)
+	}
+	if models.Paise(invoice.ValDtls.TotInvVal) != models.Paise(signed.TotInvVal) {
+		mismatches = append(mismatches, irnMismatch{
+			Field: "TotInvVal",
+			Local: fmt.Sprintf("%.2f", invoice.ValDtls.TotInvVal),
+			IRP:   fmt.Sprintf("%.2f", signed.TotInvVal),
+		})
+	}
+	return mismatches
+}
+
+// irnReconciliationEntry is an invoice listed in the IRN reconciliation report
+type irnReconciliationEntry struct {
+	ID             int           `json:"id"`
+	InvoiceNo      string        `json:"invoice_no"`
+	Date           string        `json:"date"`
+	SellerGSTIN    string        `json:"seller_gstin"`
+	BuyerName      string        `json:"buyer_name"`
+	TotalValue     float64       `json:"total_value"`
+	Status         string        `json:"status"`
+	IRN            string        `json:"irn,omitempty"`
+	IRNCancelledAt *time.Time    `json:"irn_cancelled_at,omitempty"`
+	Mismatches     []irnMismatch `json:"mismatches,omitempty"`
+	Sandbox        bool          `json:"sandbox"`
+	Link           string        `json:"link"`
+}
+
+// irnReconciliation groups the invoices whose local state disagrees with the IRP
+type irnReconciliation struct {
+	// MissingIRN are finalized invoices never registered with the IRP
+	MissingIRN []*irnReconciliationEntry
+	// ValueMismatches are registered invoices whose stored details differ
+	// from the ones the IRP signed, or whose signed QR code is unreadable
+	ValueMismatches []*irnReconciliationEntry
+	// CancelledIRNActive are invoices whose IRN was cancelled on the portal
+	// but which are still active here
+	CancelledIRNActive []*irnReconciliationEntry
+}
+
+// queryIRNReconciliation checks the non-draft invoices of the filter's period against their IRN registration
+func queryIRNReconciliation(ctx context.Context, userID int, filter *exportFilter, withSandbox bool) (*irnReconciliation, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, status, invoice_json, COALESCE(irn, ''), COALESCE(signed_qr_code, ''), irn_cancelled_at, sandbox
+		FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status NOT IN ('draft', 'pending_approval') AND (NOT sandbox OR $2) AND `+exportFilterSQL+`
+		ORDER BY id`,
+		append([]interface{}{userID, withSandbox}, filter.args()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	report := &irnReconciliation{
+		MissingIRN:         make([]*irnReconciliationEntry, 0),
+		ValueMismatches:    make([]*irnReconciliationEntry, 0),
+		CancelledIRNActive: make([]*irnReconciliationEntry, 0),
+	}
+	for rows.Next() {
+		var id int
+		var status, irn, signedQRCode string
+		var invoiceJSON []byte
+		var irnCancelledAt *time.Time
+		var sandbox bool
+		if err := rows.Scan(&id, &status, &invoiceJSON, &irn, &signedQRCode, &irnCancelledAt, &sandbox); err != nil {
+			return nil, err
+		}
+
+		// Unreadable invoices are reported by the integrity scan
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil || !filter.matchesDate(invoice.DocDtls.Dt) {
+			continue
+		}
+		entry := &irnReconciliationEntry{
+			ID:             id,
+			InvoiceNo:      invoice.DocDtls.No,
+			Date:           invoice.DocDtls.Dt,
+			SellerGSTIN:    invoice.SellerDtls.Gstin,
+			BuyerName:      invoice.BuyerDtls.LglNm,
+			TotalValue:     invoice.ValDtls.TotInvVal,
+			Status:         status,
+			IRN:            irn,
+			IRNCancelledAt: irnCancelledAt,
+			Sandbox:        sandbox,
+			Link:           fmt.Sprintf("/api/v1/invoices/%d", id),
+		}
+
+		switch {
+		case irn == "":
+			if status == models.InvoiceStatusFinalized {
+				report.MissingIRN = append(report.MissingIRN, entry)
+			}
+		case irnCancelledAt != nil:
+			if status != models.InvoiceStatusCancelled {
+				report.CancelledIRNActive = append(report.CancelledIRNActive, entry)
+			}
+		case signedQRCode != "":
+			signed, err := decodeSignedQR(signedQRCode)
+			if err != nil {
+				entry.Mismatches = []irnMismatch{{Field: "SignedQRCode", Local: "unreadable", IRP: err.Error()}}
+			} else {
+				entry.Mismatches = irnMismatches(&invoice, irn, signed)
+			}
+			if len(entry.Mismatches) > 0 {
+				report.ValueMismatches = append(report.ValueMismatches, entry)
+			}
+		}
+	}
+	return report, rows.Err()
+}
+
+// handleIRNReconciliationReport lists the invoices of a period that need
+// attention before filing: finalized invoices without an IRN, registered
+// invoices whose details differ from what the IRP signed, and invoices whose
+// IRN was cancelled on the portal while they are still active here.
+// Registrations are compared using the signed QR codes recorded on export or
+// imported from the portal's acknowledgement files.
+func handleIRNReconciliationReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	report, err := queryIRNReconciliation(exportContext(c), userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build IRN reconciliation"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"missing_irn":          report.MissingIRN,
+		"value_mismatches":     report.ValueMismatches,
+		"cancelled_irn_active": report.CancelledIRNActive,
+		"summary": gin.H{
+			"missing_irn":          len(report.MissingIRN),
+			"value_mismatches":     len(report.ValueMismatches),
+			"cancelled_irn_active": len(report.CancelledIRNActive),
+		},
+	})
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// errIRPDisabled is returned when no IRP API is configured
+var errIRPDisabled = errors.New("IRP integration is not configured")
+
+// irpHTTPClient is used for all calls to the Invoice Registration Portal
+var irpHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// istLocation is Indian Standard Time, used by the IRP for all timestamps
+var istLocation = time.FixedZone("IST", 5*60*60+30*60)
+
+// irpTimeLayout is the timestamp format used in IRP responses (AckDt, CancelDate)
+const irpTimeLayout = "2006-01-02 15:04:05"
+
+// parseIRPTime parses an IST timestamp from the IRP into local time
+func parseIRPTime(value string) (time.Time, error) {
+	t, err := time.ParseInLocation(irpTimeLayout, value, istLocation)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Local(), nil
+}
+
+// irnCancelReasons maps the IRP cancellation reason codes to their descriptions
+var irnCancelReasons = map[int]string{
+	1: "Duplicate",
+	2: "Data entry mistake",
+	3: "Order cancelled",
+	4: "Others",
+}
+
+// irnCancelRemarksMaxLen is the longest cancellation remark the IRP accepts
+const irnCancelRemarksMaxLen = 100
+
+// irpCancelRequest is the body of the IRP cancel IRN API
+type irpCancelRequest struct {
+	Irn    string `json:"Irn"`
+	CnlRsn string `json:"CnlRsn"`
+	CnlRem string `json:"CnlRem"`
+}
+
+// irpCancelResponse is the success response of the IRP cancel IRN API
+type irpCancelResponse struct {
+	Irn        string `json:"Irn"`
+	CancelDate string `json:"CancelDate"`
+}
+
+// irpAcknowledgement is the part of the IRP generate IRN response that
+// identifies a registered invoice
+type irpAcknowledgement struct {
+	AckNo        string `json:"AckNo"`
+	AckDt        string `json:"AckDt,omitempty"`
+	Irn          string `json:"Irn"`
+	SignedQRCode string `json:"SignedQRCode,omitempty"`
+}
+
+// embed adds the acknowledgement fields to an invoice payload, keeping the
+// payload's own field order. AckNo is a number in the IRP response schema.
+func (a irpAcknowledgement) embed(payload []byte) ([]byte, error) {
+	payload = bytes.TrimSpace(payload)
+	if len(payload) < 2 || payload[0] != '{' || payload[len(payload)-1] != '}' {
+		return nil, errors.New("invoice payload is not a JSON object")
+	}
+
+	fields, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := strconv.ParseInt(a.AckNo, 10, 64); err == nil {
+		fields = bytes.Replace(fields, []byte(`"AckNo":"`+a.AckNo+`"`), []byte(`"AckNo":`+a.AckNo), 1)
+	}
+
+	var out bytes.Buffer
+	out.Write(fields[:len(fields)-1])
+	if body := bytes.TrimSpace(payload[1 : len(payload)-1]); len(body) > 0 {
+		out.WriteByte(',')
+		out.Write(body)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
+
+// irpErrorResponse carries the error details returned by the IRP
+type irpErrorResponse struct {
+	ErrorCode    string `json:"ErrorCode"`
+	ErrorMessage string `json:"ErrorMessage"`
+}
+
+// cancelIRN asks the IRP to cancel a registered IRN.
+// IRP_API_URL is the base URL of the e-invoice API, e.g. https://gsp.example.com/eivital/v1.04
+func cancelIRN(ctx context.Context, irn string, reasonCode int, remarks string) (*irpCancelResponse, error) {
+	apiURL := os.Getenv("IRP_API_URL")
+	if apiURL == "" {
+		return nil, errIRPDisabled
+	}
+
+	body, err := json.Marshal(irpCancelRequest{
+		Irn:    irn,
+		CnlRsn: strconv.Itoa(reasonCode),
+		CnlRem: remarks,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(apiURL, "/")+"/Invoice/Cancel", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if apiKey := os.Getenv("IRP_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := irpHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("IRP request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read IRP response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var irpErr irpErrorResponse
+		if json.Unmarshal(respBody, &irpErr) == nil && irpErr.ErrorMessage != "" {
+			return nil, fmt.Errorf("IRP rejected cancellation (%s): %s", irpErr.ErrorCode, irpErr.ErrorMessage)
+		}
+		return nil, fmt.Errorf("IRP returned status %d", resp.StatusCode)
+	}
+
+	var result irpCancelResponse
+	if err := json.Unmarshal(respBody, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse IRP response: %w", err)
+	}
+	return &result, nil
+}
+
+// handleCancelIRN cancels an invoice's IRN with the IRP and marks the invoice cancelled.
+// The IRP only allows cancellation within 24 hours of the IRN being generated.
+func handleCancelIRN(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		ReasonCode int    `json:"reason_code"`
+		Remarks    string `json:"remarks"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	reason, ok := irnCancelReasons[req.ReasonCode]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "reason_code must be 1 (duplicate), 2 (data entry mistake), 3 (order cancelled) or 4 (others)"})
+		return
+	}
+	req.Remarks = strings.TrimSpace(req.Remarks)
+	if req.Remarks == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cancellation remarks are required"})
+		return
+	}
+	if len(req.Remarks) > irnCancelRemarksMaxLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Cancellation remarks must be at most %d characters", irnCancelRemarksMaxLen)})
+		return
+	}
+
+	var status, irn string
+	var registeredAt, irnCancelledAt *time.Time
+	var sandbox bool
+	ctx := c.Request.Context()
+	err = dbPool.QueryRow(ctx, `
+		SELECT status, COALESCE(irn, ''), COALESCE(ack_dt, exported_at), irn_cancelled_at, sandbox
+		FROM invoices WHERE id = $1 AND user_id = $2
+	`, id, userID).Scan(&status, &irn, &registeredAt, &irnCancelledAt, &sandbox)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	if status == models.InvoiceStatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice is already cancelled"})
+		return
+	}
+	if irn == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice has no IRN; record the IRN when marking it exported"})
+		return
+	}
+	if irnCancelledAt == nil && registeredAt != nil && time.Since(*registeredAt) > cancellationWindow {
+		c.JSON(http.StatusConflict, gin.H{
+			"error": "IRNs can only be cancelled within 24 hours of generation; issue a credit note instead",
+		})
+		return
+	}
+
+	// Sandbox IRNs were never registered, and IRNs already cancelled on the
+	// portal are reported by acknowledgement files, so both are cancelled
+	// locally only
+	result := &irpCancelResponse{Irn: irn}
+	if irnCancelledAt != nil {
+		result.CancelDate = irnCancelledAt.In(istLocation).Format(irpTimeLayout)
+	} else if !sandbox {
+		result, err = cancelIRN(ctx, irn, req.ReasonCode, req.Remarks)
+	}
+	if errors.Is(err, errIRPDisabled) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The IRP reports the cancellation time in IST; fall back to now when it is missing
+	cancelledAt := time.Now()
+	if t, err := parseIRPTime(result.CancelDate); err == nil {
+		cancelledAt = t
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		UPDATE invoices
+		SET status = 'cancelled', cancel_reason = $1, cancelled_at = $2,
+			irn_cancel_remarks = $3, irn_cancelled_at = $2, updated_at = NOW()
+		WHERE id = $4 AND user_id = $5
+	`, reason, cancelledAt, req.Remarks, id, userID)
+	if err != nil {
+		// The IRN is already cancelled at the IRP, so surface this loudly
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "IRN cancelled at the IRP but failed to update invoice: " + err.Error()})
+		return
+	}
+	recordInvoiceEvent(ctx, dbPool, eventInvoiceCancelled, id)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":      "IRN cancelled successfully",
+		"invoice_id":   id,
+		"irn":          irn,
+		"cancelled_at": cancelledAt,
+	})
+}
@@ -0,0 +1,467 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// eventInvoiceIRPRejected is recorded when the IRP reports an error for an invoice
+const eventInvoiceIRPRejected = "invoice.irp_rejected"
+
+// irpAckFields are the columns read from an IRP acknowledgement file, matched
+// like partyImportFields. The aliases cover the headers of the e-invoice
+// portal's bulk upload results and the keys of its JSON downloads.
+var irpAckFields = []partyImportField{
+	{name: "gstin", label: "Seller GSTIN", required: true, aliases: []string{"gstin", "supplier gstin", "sellergstin", "suppgstin", "user gstin"}},
+	{name: "doc_no", label: "Document No", required: true, aliases: []string{"docno", "doc no", "document number", "invoice no", "invoice number", "no"}},
+	{name: "irn", label: "IRN", aliases: []string{"irn no", "invoice reference number"}},
+	{name: "ack_no", label: "Ack No", aliases: []string{"ackno", "acknowledgement no", "acknowledgement number", "ack number"}},
+	{name: "ack_dt", label: "Ack Date", aliases: []string{"ackdt", "ack dt", "acknowledgement date", "ack date time"}},
+	{name: "status", label: "Status", aliases: []string{"irn status", "irp status"}},
+	{name: "error_code", label: "Error Code", aliases: []string{"errorcode", "error codes", "err code"}},
+	{name: "error_message", label: "Error Message", aliases: []string{"errormessage", "error description", "error details", "error", "errors", "remarks"}},
+	{name: "signed_qr_code", label: "Signed QR Code", aliases: []string{"signedqrcode", "signed qr"}},
+}
+
+// irpAckStatuses maps the statuses reported by the portal to whether the
+// invoice was registered. A blank status is decided by the presence of an IRN.
+var irpAckStatuses = map[string]bool{
+	"act":       true,
+	"active":    true,
+	"success":   true,
+	"generated": true,
+	"1":         true,
+	"failed":    false,
+	"failure":   false,
+	"error":     false,
+	"rejected":  false,
+	"0":         false,
+}
+
+// irpAckCancelledStatuses are the statuses of IRNs cancelled on the portal.
+// The cancellation is recorded without cancelling the invoice, which the IRN
+// reconciliation report then lists until it is cancelled here too.
+var irpAckCancelledStatuses = map[string]bool{
+	"cnl":       true,
+	"cancelled": true,
+	"canceled":  true,
+}
+
+// irpAckTimeLayouts are the acknowledgement date formats accepted, all in IST
+var irpAckTimeLayouts = []string{
+	irpTimeLayout,
+	"02/01/2006 15:04:05",
+	"02-01-2006 15:04:05",
+	"02/01/2006 15:04",
+	"2006-01-02T15:04:05",
+}
+
+// irpAck is one row of an acknowledgement file
+type irpAck struct {
+	row          int
+	gstin        string
+	docNo        string
+	irn          string
+	ackNo        string
+	ackDt        *time.Time
+	registered   bool
+	cancelled    bool
+	errorCode    string
+	errorMessage string
+	signedQRCode string
+}
+
+// createIRPAckColumns adds the last IRP error reported for an invoice
+func createIRPAckColumns() {
+	_, err := dbPool.Exec(context.Background(), `
+		ALTER TABLE invoices
+		ADD COLUMN IF NOT EXISTS irp_error_code VARCHAR(100),
+		ADD COLUMN IF NOT EXISTS irp_error_message TEXT
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add IRP error columns to invoices table: %v", err)
+	}
+}
+
+// resolveIRPAckColumns finds the column of each field in the header row
+func resolveIRPAckColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, h := range header {
+		key := normalizeHeader(h)
+		if key == "" {
+			continue
+		}
+		for _, f := range irpAckFields {
+			if _, found := columns[f.name]; found {
+				continue
+			}
+			match := key == normalizeHeader(f.name) || key == normalizeHeader(f.label)
+			for _, alias := range f.aliases {
+				match = match || key == normalizeHeader(alias)
+			}
+			if match {
+				columns[f.name] = i
+				break
+			}
+		}
+	}
+	for _, f := range irpAckFields {
+		if _, found := columns[f.name]; f.required && !found {
+			return nil, fmt.Errorf("no %q column found in the header row", f.label)
+		}
+	}
+	return columns, nil
+}
+
+// irpAckJSONRows flattens the records of a JSON acknowledgement download into
+// a header row and data rows. The file may hold one record, an array of
+// records, or an object with the array under "data". Error details given as
+// an array of {ErrorCode, ErrorMessage} are joined, and the document number
+// and seller GSTIN are also read from DocDtls and SellerDtls.
+func irpAckJSONRows(data []byte) ([][]string, error) {
+	var records []map[string]interface{}
+	if err := json.Unmarshal(data, &records); err != nil {
+		var single map[string]interface{}
+		if err := json.Unmarshal(data, &single); err != nil {
+			return nil, newImportError(http.StatusBadRequest, "Invalid JSON format: %v", err)
+		}
+		records = []map[string]interface{}{single}
+		if list, ok := single["data"].([]interface{}); ok {
+			records = records[:0]
+			for _, item := range list {
+				if record, ok := item.(map[string]interface{}); ok {
+					records = append(records, record)
+				}
+			}
+		}
+	}
+
+	header := []string{}
+	position := make(map[string]int)
+	rows := make([][]string, 0, len(records))
+	set := func(row []string, key, value string) []string {
+		col, found := position[key]
+		if !found {
+			col = len(header)
+			position[key] = col
+			header = append(header, key)
+		}
+		for len(row) <= col {
+			row = append(row, "")
+		}
+		row[col] = value
+		return row
+	}
+	for _, record := range records {
+		var row []string
+		if doc, ok := record["DocDtls"].(map[string]interface{}); ok {
+			row = set(row, "DocNo", fmt.Sprint(doc["No"]))
+		}
+		if seller, ok := record["SellerDtls"].(map[string]interface{}); ok {
+			row = set(row, "Gstin", fmt.Sprint(seller["Gstin"]))
+		}
+		for key, value := range record {
+			switch v := value.(type) {
+			case string:
+				row = set(row, key, v)
+			case float64:
+				row = set(row, key, fmt.Sprintf("%.0f", v))
+			case []interface{}:
+				var codes, messages []string
+				for _, item := range v {
+					detail, ok := item.(map[string]interface{})
+					if !ok {
+						continue
+					}
+					if code, ok := detail["ErrorCode"]; ok {
+						codes = append(codes, fmt.Sprint(code))
+					}
+					if message, ok := detail["ErrorMessage"]; ok {
+						messages = append(messages, fmt.Sprint(message))
+					}
+				}
+				row = set(row, "ErrorCode", strings.Join(codes, ", "))
+				row = set(row, "ErrorMessage", strings.Join(messages, "; "))
+			}
+		}
+		rows = append(rows, row)
+	}
+	return append([][]string{header}, rows...), nil
+}
+
+// readIRPAckFile returns the rows of an uploaded .json, .xlsx or .csv acknowledgement file
+func readIRPAckFile(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return irpAckJSONRows(data)
+	case ".xlsx", ".csv":
+		return readPartySheet(filename, data)
+	default:
+		return nil, newImportError(http.StatusBadRequest, "Only JSON (.json), Excel (.xlsx) and CSV (.csv) files are supported")
+	}
+}
+
+// parseIRPAckTime parses an acknowledgement date in one of irpAckTimeLayouts
+func parseIRPAckTime(value string) (time.Time, error) {
+	for _, layout := range irpAckTimeLayouts {
+		if t, err := time.ParseInLocation(layout, value, istLocation); err == nil {
+			return t.Local(), nil
+		}
+	}
+	return time.Time{}, errors.New("unrecognised date format")
+}
+
+// parseIRPAckRow reads an acknowledgement from a data row, recording problems on the report
+func parseIRPAckRow(report *uploadReport, cells []string, columns map[string]int, rowNum int) (*irpAck, bool) {
+	get := func(field string) string {
+		col, found := columns[field]
+		if !found || col >= len(cells) {
+			return ""
+		}
+		return strings.TrimSpace(cells[col])
+	}
+	column := func(field string) int {
+		if col, found := columns[field]; found {
+			return col
+		}
+		return -1
+	}
+
+	ack := &irpAck{
+		row:          rowNum,
+		gstin:        strings.ToUpper(get("gstin")),
+		docNo:        get("doc_no"),
+		irn:          get("irn"),
+		ackNo:        get("ack_no"),
+		errorCode:    get("error_code"),
+		errorMessage: get("error_message"),
+		signedQRCode: get("signed_qr_code"),
+	}
+	if ack.docNo == "" || ack.gstin == "" {
+		report.add(rowNum, ack.docNo, column("doc_no"), "document number and seller GSTIN are required")
+		return nil, false
+	}
+
+	status := strings.ToLower(get("status"))
+	if irpAckCancelledStatuses[status] {
+		if ack.irn == "" {
+			report.add(rowNum, ack.docNo, column("irn"), "IRN is required for cancelled IRNs")
+			return nil, false
+		}
+		ack.cancelled = true
+		return ack, true
+	}
+	registered, known := irpAckStatuses[status]
+	switch {
+	case status == "":
+		registered = ack.irn != "" && ack.errorCode == "" && ack.errorMessage == ""
+	case !known:
+		report.add(rowNum, ack.docNo, column("status"), fmt.Sprintf("unknown status %q", get("status")))
+		return nil, false
+	}
+	ack.registered = registered
+
+	if registered {
+		if len(ack.irn) != 64 {
+			report.add(rowNum, ack.docNo, column("irn"), "IRN must be 64 characters")
+			return nil, false
+		}
+		if len(ack.ackNo) > 20 {
+			report.add(rowNum, ack.docNo, column("ack_no"), "Ack No must be at most 20 characters")
+			return nil, false
+		}
+		if value := get("ack_dt"); value != "" {
+			t, err := parseIRPAckTime(value)
+			if err != nil {
+				report.add(rowNum, ack.docNo, column("ack_dt"), fmt.Sprintf("%q is not a valid acknowledgement date", value))
+				return nil, false
+			}
+			ack.ackDt = &t
+		}
+	} else if ack.errorCode == "" && ack.errorMessage == "" {
+		ack.errorMessage = "Rejected by the IRP"
+	}
+	return ack, true
+}
+
+// applyIRPAcks stores the outcome of each acknowledgement on the matching
+// invoice, found by seller GSTIN and document number, in one transaction.
+// Registered invoices are marked exported with their IRN; rejected ones keep
+// the IRP's error so they can be corrected and uploaded again, and cancelled
+// IRNs are recorded as such.
+func applyIRPAcks(ctx context.Context, userID int, report *uploadReport, acks []*irpAck) ([]gin.H, error) {
+	results := make([]gin.H, 0, len(acks))
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, ack := range acks {
+		var id int
+		var status, irn string
+		err := tx.QueryRow(ctx, `
+			SELECT id, status, COALESCE(irn, '') FROM invoices
+			WHERE user_id = $1 AND seller_gstin = $2 AND invoice_no = $3
+			FOR UPDATE
+		`, userID, ack.gstin, ack.docNo).Scan(&id, &status, &irn)
+		if errors.Is(err, pgx.ErrNoRows) {
+			report.add(ack.row, ack.docNo, -1, fmt.Sprintf("no invoice %s from seller %s", ack.docNo, ack.gstin))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if ack.cancelled {
+			if irn != ack.irn {
+				report.add(ack.row, ack.docNo, -1, "IRN does not match the IRN recorded for the invoice")
+				continue
+			}
+			_, err = tx.Exec(ctx,
+				"UPDATE invoices SET irn_cancelled_at = COALESCE(irn_cancelled_at, NOW()), updated_at = NOW() WHERE id = $1",
+				id)
+			if err != nil {
+				return nil, err
+			}
+			results = append(results, gin.H{
+				"invoice_id": id,
+				"invoice_no": ack.docNo,
+				"outcome":    "irn_cancelled",
+				"irn":        ack.irn,
+			})
+			continue
+		}
+
+		if !ack.registered {
+			_, err = tx.Exec(ctx, `
+				UPDATE invoices SET irp_error_code = NULLIF($1, ''), irp_error_message = $2, updated_at = NOW()
+				WHERE id = $3
+			`, ack.errorCode, ack.errorMessage, id)
+			if err != nil {
+				return nil, err
+			}
+			recordInvoiceEvent(ctx, tx, eventInvoiceIRPRejected, id)
+			results = append(results, gin.H{
+				"invoice_id":    id,
+				"invoice_no":    ack.docNo,
+				"outcome":       "rejected",
+				"error_code":    ack.errorCode,
+				"error_message": ack.errorMessage,
+			})
+			continue
+		}
+
+		if status != models.InvoiceStatusFinalized {
+			report.add(ack.row, ack.docNo, -1, fmt.Sprintf("invoice is %s; only finalized invoices can be registered", status))
+			continue
+		}
+		if irn != "" && irn != ack.irn {
+			report.add(ack.row, ack.docNo, -1, "invoice is already registered with a different IRN")
+			continue
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE invoices SET exported = true, exported_at = COALESCE(exported_at, NOW()), updated_at = NOW(),
+				irn = $1, ack_no = COALESCE(NULLIF($2, ''), ack_no), ack_dt = COALESCE($3, ack_dt),
+				signed_qr_code = COALESCE(NULLIF($4, ''), signed_qr_code),
+				irp_error_code = NULL, irp_error_message = NULL
+			WHERE id = $5
+		`, ack.irn, ack.ackNo, ack.ackDt, ack.signedQRCode, id)
+		if err != nil {
+			return nil, err
+		}
+		recordInvoiceEvent(ctx, tx, eventInvoiceExported, id)
+		if irn == "" {
+			recordInvoiceEvent(ctx, tx, eventInvoiceIRNGenerated, id)
+		}
+		results = append(results, gin.H{
+			"invoice_id": id,
+			"invoice_no": ack.docNo,
+			"outcome":    "registered",
+			"irn":        ack.irn,
+		})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// handleImportIRPAcks updates invoices from an acknowledgement file downloaded
+// from the e-invoice portal, as JSON, Excel or CSV. Rows that cannot be
+// matched to a finalized invoice are reported and skipped.
+func handleImportIRPAcks(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	file, data, ok := readUploadedFile(c, "file")
+	if !ok {
+		return
+	}
+	rows, err := readIRPAckFile(file.Filename, data)
+	if err == nil && len(rows) < 2 {
+		err = newImportError(http.StatusBadRequest, "File does not contain any acknowledgements")
+	}
+	var columns map[string]int
+	if err == nil {
+		if columns, err = resolveIRPAckColumns(rows[0]); err != nil {
+			err = newImportError(http.StatusBadRequest, "Header row does not match the portal format: %v", err)
+		}
+	}
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	// JSON records are numbered from 1; sheet rows by their row number
+	firstRow := 2
+	if strings.ToLower(filepath.Ext(file.Filename)) == ".json" {
+		firstRow = 1
+	}
+	report := newUploadReport(rows[0], firstRow-1)
+	acks := make([]*irpAck, 0, len(rows)-1)
+	for i, cells := range rows[1:] {
+		if isBlankRow(cells) {
+			continue
+		}
+		if ack, ok := parseIRPAckRow(report, cells, columns, i+firstRow); ok {
+			acks = append(acks, ack)
+		}
+	}
+
+	results, err := applyIRPAcks(c.Request.Context(), userID, report, acks)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	counts := map[string]int{}
+	for _, result := range results {
+		counts[result["outcome"].(string)]++
+	}
+	summary := gin.H{
+		"registered":    counts["registered"],
+		"rejected":      counts["rejected"],
+		"irn_cancelled": counts["irn_cancelled"],
+		"failed_rows":   len(report.failed),
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":  fmt.Sprintf("%d invoice(s) updated from the acknowledgement file", len(results)),
+		"invoices": results,
+		"errors":   report.sorted(),
+		"summary":  summary,
+	})
+}
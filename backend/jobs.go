@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// jobHandler processes a single job; returning an error schedules a retry
+type jobHandler func(ctx context.Context, job *models.Job) error
+
+// jobHandlers maps job types to the function that processes them
+var jobHandlers = make(map[string]jobHandler)
+
+// jobStaleAfter is how long a running job may hold its lock before it is
+// assumed to belong to a crashed worker and becomes claimable again
+const jobStaleAfter = 15 * time.Minute
+
+// jobHeartbeatInterval is how often a running job refreshes its lock, so
+// jobs running longer than jobStaleAfter are not claimed a second time
+const jobHeartbeatInterval = time.Minute
+
+// permanentJobError marks a failure that should not be retried
+type permanentJobError struct {
+	err error
+}
+
+func (e permanentJobError) Error() string { return e.err.Error() }
+func (e permanentJobError) Unwrap() error { return e.err }
+
+// permanentError wraps err so the job moves straight to the dead-letter state
+func permanentError(err error) error {
+	return permanentJobError{err: err}
+}
+
+// jobColumns is the column list used when reading jobs
+const jobColumns = `id, user_id, type, payload, status, attempts, max_attempts, run_at, locked_at,
+	COALESCE(last_error, ''), error_log, dead_at, created_at, updated_at`
+
+// createJobTables creates the background jobs table
+func createJobTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS jobs (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+			type VARCHAR(100) NOT NULL,
+			payload JSONB NOT NULL DEFAULT '{}'::jsonb,
+			status VARCHAR(20) NOT NULL DEFAULT 'pending',
+			attempts INTEGER NOT NULL DEFAULT 0,
+			max_attempts INTEGER NOT NULL DEFAULT 5,
+			run_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			locked_at TIMESTAMP,
+			last_error TEXT,
+			error_log JSONB NOT NULL DEFAULT '[]'::jsonb,
+			dead_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create jobs table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_jobs_status_run_at ON jobs (status, run_at)")
+	if err != nil {
+		log.Fatalf("Failed to create jobs index: %v", err)
+	}
+}
+
+// registerJobHandler registers the function that processes jobs of the given type
+func registerJobHandler(jobType string, handler jobHandler) {
+	jobHandlers[jobType] = handler
+}
+
+// enqueueJob schedules a job for background processing and returns its ID
+func enqueueJob(ctx context.Context, userID int, jobType string, payload interface{}) (int, error) {
+	return enqueueDelayedJob(ctx, userID, jobType, payload, 0)
+}
+
+// enqueueDelayedJob schedules a job to run once delay has passed and returns its ID
+func enqueueDelayedJob(ctx context.Context, userID int, jobType string, payload interface{}, delay time.Duration) (int, error) {
+	payloadJSON, err := json.Marshal(payload)
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize job payload: %w", err)
+	}
+
+	var owner *int
+	if userID != 0 {
+		owner = &userID
+	}
+
+	var id int
+	err = dbPool.QueryRow(ctx,
+		"INSERT INTO jobs (user_id, type, payload, run_at) VALUES ($1, $2, $3, NOW() + $4 * INTERVAL '1 second') RETURNING id",
+		owner, jobType, payloadJSON, int64(delay.Seconds())).Scan(&id)
+	return id, err
+}
+
+// scanJob reads a job row selected with jobColumns
+func scanJob(row pgx.Row) (*models.Job, error) {
+	var job models.Job
+	var errorLog []byte
+	err := row.Scan(&job.ID, &job.UserID, &job.Type, &job.Payload, &job.Status, &job.Attempts,
+		&job.MaxAttempts, &job.RunAt, &job.LockedAt, &job.LastError, &errorLog, &job.DeadAt,
+		&job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(errorLog, &job.ErrorLog); err != nil {
+		job.ErrorLog = nil
+	}
+	return &job, nil
+}
+
+// getJobWorkerCount returns the number of job workers to run
+func getJobWorkerCount() int {
+	workers, err := strconv.Atoi(getEnvWithDefault("JOB_WORKERS", "2"))
+	if err != nil || workers < 0 {
+		log.Printf("Invalid JOB_WORKERS value, defaulting to 2")
+		workers = 2
+	}
+	return workers
+}
+
+// startJobWorkers launches the background workers that process queued jobs
+func startJobWorkers(ctx context.Context) {
+	workers := getJobWorkerCount()
+	for i := 0; i < workers; i++ {
+		go runJobWorker(ctx)
+	}
+	log.Printf("Started %d job worker(s)", workers)
+}
+
+// runJobWorker polls for and processes jobs until the context is cancelled
+func runJobWorker(ctx context.Context) {
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+
+	for {
+		// Drain all runnable jobs before waiting for the next tick
+		for {
+			job, err := claimJob(ctx)
+			if err != nil {
+				if !errors.Is(err, pgx.ErrNoRows) && ctx.Err() == nil {
+					log.Printf("Error claiming job: %v", err)
+				}
+				break
+			}
+			processJob(ctx, job)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimJob locks the next runnable job, including stale jobs from crashed workers
+func claimJob(ctx context.Context) (*models.Job, error) {
+	return scanJob(dbPool.QueryRow(ctx, `
+		UPDATE jobs
+		SET status = 'running', attempts = attempts + 1, locked_at = NOW(), updated_at = NOW()
+		WHERE id = (
+			SELECT id FROM jobs
+			WHERE (status = 'pending' AND run_at <= NOW())
+				OR (status = 'running' AND locked_at < $1)
+			ORDER BY run_at, id
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1
+		)
+		RETURNING `+jobColumns,
+		time.Now().Add(-jobStaleAfter)))
+}
+
+// processJob runs the handler for a claimed job and records the outcome.
+// While the handler runs, the job's lock is refreshed; the outcome is only
+// recorded while the worker still holds the lock.
+func processJob(ctx context.Context, job *models.Job) {
+	handler, ok := jobHandlers[job.Type]
+	var err error
+	if !ok {
+		err = permanentError(fmt.Errorf("no handler registered for job type %q", job.Type))
+	} else {
+		jobCtx, cancel := context.WithCancel(ctx)
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			heartbeatJob(jobCtx, cancel, job)
+		}()
+		err = runJobHandler(jobCtx, handler, job)
+		cancel()
+		<-stopped
+	}
+
+	if err == nil {
+		result, err := dbPool.Exec(ctx, `
+			UPDATE jobs SET status = 'completed', locked_at = NULL, updated_at = NOW()
+			WHERE id = $1 AND status = 'running' AND locked_at = $2
+		`, job.ID, job.LockedAt)
+		if err != nil {
+			log.Printf("Error completing job %d: %v", job.ID, err)
+		} else if result.RowsAffected() == 0 {
+			log.Printf("Job %d (%s) completed after losing its lock; the outcome was not recorded", job.ID, job.Type)
+		}
+		return
+	}
+
+	failJob(ctx, job, err)
+}
+
+// heartbeatJob refreshes the lock of a running job every
+// jobHeartbeatInterval until ctx is done. When the lock is no longer held,
+// as when the worker stalled past jobStaleAfter and another worker claimed
+// the job, cancel stops the handler.
+func heartbeatJob(ctx context.Context, cancel context.CancelFunc, job *models.Job) {
+	ticker := time.NewTicker(jobHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// The refresh is not cancelled with the job, so the lock held is
+		// always the one last recorded on job
+		var lockedAt time.Time
+		err := dbPool.QueryRow(context.WithoutCancel(ctx), `
+			UPDATE jobs SET locked_at = NOW()
+			WHERE id = $1 AND status = 'running' AND locked_at = $2
+			RETURNING locked_at
+		`, job.ID, job.LockedAt).Scan(&lockedAt)
+		if errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Job %d (%s) lost its lock; stopping it", job.ID, job.Type)
+			cancel()
+			return
+		}
+		if err != nil {
+			log.Printf("Error refreshing lock of job %d: %v", job.ID, err)
+			continue
+		}
+		job.LockedAt = &lockedAt
+	}
+}
+
+// runJobHandler runs a handler, converting panics into job errors
+func runJobHandler(ctx context.Context, handler jobHandler, job *models.Job) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("job panicked: %v", r)
+		}
+	}()
+	return handler(ctx, job)
+}
+
+// failJob records a failed attempt and either schedules a retry with
+// exponential backoff or moves the job to the dead-letter state, unless the
+// worker no longer holds the job's lock
+func failJob(ctx context.Context, job *models.Job, jobErr error) {
+	entry, _ := json.Marshal([]models.JobError{{
+		Attempt: job.Attempts,
+		Error:   jobErr.Error(),
+		At:      time.Now(),
+	}})
+
+	var permanent permanentJobError
+	dead := errors.As(jobErr, &permanent) || job.Attempts >= job.MaxAttempts
+
+	var result pgconn.CommandTag
+	var err error
+	if dead {
+		log.Printf("Job %d (%s) moved to dead-letter queue: %v", job.ID, job.Type, jobErr)
+		result, err = dbPool.Exec(ctx, `
+			UPDATE jobs
+			SET status = 'dead', locked_at = NULL, last_error = $1,
+				error_log = error_log || $2::jsonb, dead_at = NOW(), updated_at = NOW()
+			WHERE id = $3 AND status = 'running' AND locked_at = $4
+		`, jobErr.Error(), entry, job.ID, job.LockedAt)
+	} else {
+		backoff := time.Duration(job.Attempts*job.Attempts) * 30 * time.Second
+		log.Printf("Job %d (%s) failed on attempt %d, retrying in %s: %v", job.ID, job.Type, job.Attempts, backoff, jobErr)
+		result, err = dbPool.Exec(ctx, `
+			UPDATE jobs
+			SET status = 'pending', locked_at = NULL, last_error = $1,
+				error_log = error_log || $2::jsonb, run_at = $3, updated_at = NOW()
+			WHERE id = $4 AND status = 'running' AND locked_at = $5
+		`, jobErr.Error(), entry, time.Now().Add(backoff), job.ID, job.LockedAt)
+	}
+	if err != nil {
+		log.Printf("Error recording failure for job %d: %v", job.ID, err)
+	} else if result.RowsAffected() == 0 {
+		log.Printf("Job %d (%s) failed after losing its lock; the failure was not recorded", job.ID, job.Type)
+	}
+}
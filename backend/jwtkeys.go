@@ -0,0 +1,240 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwtKey is a key that verifies tokens, and signs them when its private key is known
+type jwtKey struct {
+	id      string
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// jwtKeyring holds every key tokens may be signed with, by key ID. Rotating
+// keys means adding a new key, making it the signing key and keeping the old
+// one for verification until the tokens it signed have expired.
+type jwtKeyring struct {
+	keys    map[string]*jwtKey
+	signing *jwtKey
+}
+
+// jwtKeys is loaded at startup by initJWTKeys
+var jwtKeys *jwtKeyring
+
+// parseJWTKey reads an RSA or Ed25519 key from PEM. Private keys may be
+// PKCS#8 or PKCS#1; public keys are PKIX.
+func parseJWTKey(id string, data []byte) (*jwtKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM data found")
+	}
+
+	var parsed interface{}
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	key := &jwtKey{id: id}
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case ed25519.PrivateKey:
+		key.method, key.private, key.public = jwt.SigningMethodEdDSA, k, k.Public()
+	case *rsa.PublicKey:
+		key.method, key.public = jwt.SigningMethodRS256, k
+	case ed25519.PublicKey:
+		key.method, key.public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("unsupported key type %T; use RSA or Ed25519", parsed)
+	}
+	if k, ok := key.public.(*rsa.PublicKey); ok && k.N.BitLen() < 2048 {
+		return nil, errors.New("RSA keys must be at least 2048 bits")
+	}
+	return key, nil
+}
+
+// loadJWTKeys builds the keyring from the environment. JWT_KEYS_DIR holds one
+// PEM file per key named <key id>.pem, and JWT_PRIVATE_KEY a single PEM key
+// with the ID JWT_KEY_ID. JWT_SIGNING_KEY_ID picks the signing key; it
+// defaults to the private key with the highest ID, so date-based IDs rotate
+// by adding a file.
+func loadJWTKeys() (*jwtKeyring, error) {
+	ring := &jwtKeyring{keys: make(map[string]*jwtKey)}
+	add := func(id string, data []byte) error {
+		if _, ok := ring.keys[id]; ok {
+			return fmt.Errorf("duplicate key ID %q", id)
+		}
+		key, err := parseJWTKey(id, data)
+		if err != nil {
+			return fmt.Errorf("key %q: %w", id, err)
+		}
+		ring.keys[id] = key
+		return nil
+	}
+
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			if err := add(strings.TrimSuffix(filepath.Base(path), ".pem"), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if pemKey := os.Getenv("JWT_PRIVATE_KEY"); pemKey != "" {
+		// Allow the PEM to be given on one line with escaped newlines
+		pemKey = strings.ReplaceAll(pemKey, `\n`, "\n")
+		if err := add(getEnvWithDefault("JWT_KEY_ID", "default"), []byte(pemKey)); err != nil {
+			return nil, err
+		}
+	}
+	if len(ring.keys) == 0 {
+		return nil, errors.New("no JWT keys configured; set JWT_KEYS_DIR or JWT_PRIVATE_KEY")
+	}
+
+	signingID := os.Getenv("JWT_SIGNING_KEY_ID")
+	if signingID == "" {
+		ids := make([]string, 0, len(ring.keys))
+		for id, key := range ring.keys {
+			if key.private != nil {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			return nil, errors.New("no JWT private key to sign tokens with")
+		}
+		sort.Strings(ids)
+		signingID = ids[len(ids)-1]
+	}
+	ring.signing = ring.keys[signingID]
+	if ring.signing == nil || ring.signing.private == nil {
+		return nil, fmt.Errorf("JWT_SIGNING_KEY_ID %q is not a configured private key", signingID)
+	}
+	return ring, nil
+}
+
+// initJWTKeys loads the token signing keys. Outside production a temporary
+// key is generated when none are configured, which logs everyone out on restart.
+func initJWTKeys() {
+	ring, err := loadJWTKeys()
+	if err == nil {
+		jwtKeys = ring
+		log.Printf("Loaded %d JWT key(s); signing with %q", len(ring.keys), ring.signing.id)
+		return
+	}
+	if os.Getenv("APP_ENV") == "production" || os.Getenv("JWT_KEYS_DIR") != "" || os.Getenv("JWT_PRIVATE_KEY") != "" {
+		log.Fatalf("Failed to load JWT keys: %v", err)
+	}
+
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatalf("Failed to generate JWT key: %v", err)
+	}
+	key := &jwtKey{id: "dev", method: jwt.SigningMethodEdDSA, private: private, public: public}
+	jwtKeys = &jwtKeyring{keys: map[string]*jwtKey{key.id: key}, signing: key}
+	log.Println("Warning: No JWT keys configured; using a temporary key. Set JWT_KEYS_DIR or JWT_PRIVATE_KEY in production.")
+}
+
+// sign signs claims with the current signing key, naming it in the kid header
+func (r *jwtKeyring) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(r.signing.method, claims)
+	token.Header["kid"] = r.signing.id
+	return token.SignedString(r.signing.private)
+}
+
+// keyFunc finds the key a token was signed with, refusing tokens whose
+// algorithm does not match the key's type
+func (r *jwtKeyring) keyFunc(token *jwt.Token) (interface{}, error) {
+	id, _ := token.Header["kid"].(string)
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown key ID %q", id)
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+	}
+	return key.public, nil
+}
+
+// parse verifies a token and reads its claims
+func (r *jwtKeyring) parse(tokenString string, claims jwt.Claims) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, r.keyFunc,
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+		jwt.WithExpirationRequired())
+	return err
+}
+
+// jwk is a public key in JSON Web Key format
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// jwks returns the public keys of the keyring as a JWK set, sorted by key ID
+func (r *jwtKeyring) jwks() []jwk {
+	keys := make([]jwk, 0, len(r.keys))
+	for _, key := range r.keys {
+		k := jwk{Kid: key.id, Use: "sig", Alg: key.method.Alg()}
+		switch pub := key.public.(type) {
+		case *rsa.PublicKey:
+			k.Kty = "RSA"
+			k.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			k.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		case ed25519.PublicKey:
+			k.Kty, k.Crv = "OKP", "Ed25519"
+			k.X = base64.RawURLEncoding.EncodeToString(pub)
+		}
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Kid < keys[j].Kid })
+	return keys
+}
+
+// handleJWKS publishes the token verification keys so other services can verify tokens
+func handleJWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, gin.H{"keys": jwtKeys.jwks()})
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// User event types recorded for security auditing; the payload is a loginAudit
+const (
+	eventUserLoggedIn    = "user.logged_in"
+	eventUserLoginFailed = "user.login_failed"
+	eventUserLocked      = "user.locked"
+)
+
+// entityUser is the entity type of events recorded against user accounts
+const entityUser = "user"
+
+// loginAttemptRetention is how long login attempts are kept for throttling
+const loginAttemptRetention = 24 * time.Hour
+
+// loginAudit is the payload of user login events
+type loginAudit struct {
+	Email       string     `json:"email"`
+	IP          string     `json:"ip"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+}
+
+// createLoginTables creates the log of login attempts used to lock out
+// accounts and throttle clients after repeated failures
+func createLoginTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS login_attempts (
+			id BIGSERIAL PRIMARY KEY,
+			email VARCHAR(255) NOT NULL,
+			ip VARCHAR(45) NOT NULL,
+			success BOOLEAN NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create login_attempts table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_login_attempts_email ON login_attempts (email, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create login_attempts email index: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_login_attempts_ip ON login_attempts (ip, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create login_attempts ip index: %v", err)
+	}
+}
+
+// getLoginMaxFailures returns the consecutive failures after which an account is locked
+func getLoginMaxFailures() int {
+	limit, err := strconv.Atoi(getEnvWithDefault("LOGIN_MAX_FAILURES", "5"))
+	if err != nil || limit < 1 {
+		log.Printf("Invalid LOGIN_MAX_FAILURES, using 5")
+		return 5
+	}
+	return limit
+}
+
+// getLoginIPMaxFailures returns the failures allowed from one IP within the lockout period
+func getLoginIPMaxFailures() int {
+	limit, err := strconv.Atoi(getEnvWithDefault("LOGIN_IP_MAX_FAILURES", "20"))
+	if err != nil || limit < 1 {
+		log.Printf("Invalid LOGIN_IP_MAX_FAILURES, using 20")
+		return 20
+	}
+	return limit
+}
+
+// getLoginLockout returns how long an account or IP stays locked
+func getLoginLockout() time.Duration {
+	minutes, err := strconv.Atoi(getEnvWithDefault("LOGIN_LOCKOUT_MINUTES", "15"))
+	if err != nil || minutes < 1 {
+		log.Printf("Invalid LOGIN_LOCKOUT_MINUTES, using 15")
+		return 15 * time.Minute
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// normalizeLoginEmail is the form of an email address that attempts are counted against
+func normalizeLoginEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// loginLockedUntil returns when the lockout of an email address or IP ends,
+// or nil when logins are allowed. An email is locked after maxFailures failures
+// since its last successful login, and an IP after ipMaxFailures failures
+// across all emails, both counted within the lockout period. Unknown emails
+// are locked the same way so lockouts do not reveal which accounts exist.
+func loginLockedUntil(ctx context.Context, email, ip string) (*time.Time, error) {
+	lockout := getLoginLockout()
+	checks := []struct {
+		query string
+		key   string
+		limit int
+	}{
+		{`
+			SELECT created_at FROM login_attempts
+			WHERE email = $1 AND NOT success AND created_at > NOW() - $2 * INTERVAL '1 second'
+				AND created_at > COALESCE(
+					(SELECT MAX(created_at) FROM login_attempts WHERE email = $1 AND success),
+					'-infinity')
+			ORDER BY created_at DESC
+			LIMIT $3
+		`, email, getLoginMaxFailures()},
+		{`
+			SELECT created_at FROM login_attempts
+			WHERE ip = $1 AND NOT success AND created_at > NOW() - $2 * INTERVAL '1 second'
+			ORDER BY created_at DESC
+			LIMIT $3
+		`, ip, getLoginIPMaxFailures()},
+	}
+
+	var lockedUntil *time.Time
+	for _, check := range checks {
+		rows, err := dbPool.Query(ctx, check.query, check.key, int(lockout.Seconds()), check.limit)
+		if err != nil {
+			return nil, err
+		}
+		var count int
+		var oldest time.Time
+		for rows.Next() {
+			if err := rows.Scan(&oldest); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			count++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+
+		// The lock lifts once the oldest of the counted failures leaves the period
+		if count >= check.limit {
+			until := oldest.Add(lockout)
+			if lockedUntil == nil || until.After(*lockedUntil) {
+				lockedUntil = &until
+			}
+		}
+	}
+	return lockedUntil, nil
+}
+
+// recordLoginAttempt logs a login attempt and the matching audit event for
+// known users. A failure that reaches the limit also records a lockout event.
+func recordLoginAttempt(ctx context.Context, userID int, email, ip string, success bool) {
+	// Attempts count even when the client hangs up before the response, or
+	// guesses could be made without ever being locked out
+	ctx = context.WithoutCancel(ctx)
+	_, err := dbPool.Exec(ctx,
+		"INSERT INTO login_attempts (email, ip, success) VALUES ($1, $2, $3)",
+		email, ip, success)
+	if err != nil {
+		log.Printf("Error recording login attempt for %s: %v", email, err)
+		return
+	}
+
+	if success {
+		// Old attempts no longer count towards any lockout
+		_, err := dbPool.Exec(ctx,
+			"DELETE FROM login_attempts WHERE created_at < NOW() - $1 * INTERVAL '1 second'",
+			int(loginAttemptRetention.Seconds()))
+		if err != nil {
+			log.Printf("Error pruning login attempts: %v", err)
+		}
+	}
+
+	if userID == 0 {
+		return
+	}
+	audit := loginAudit{Email: email, IP: ip}
+	if success {
+		recordEvent(ctx, dbPool, userID, eventUserLoggedIn, entityUser, userID, audit)
+		return
+	}
+	recordEvent(ctx, dbPool, userID, eventUserLoginFailed, entityUser, userID, audit)
+
+	lockedUntil, err := loginLockedUntil(ctx, email, ip)
+	if err != nil {
+		log.Printf("Error checking lockout of %s: %v", email, err)
+		return
+	}
+	if lockedUntil != nil {
+		audit.LockedUntil = lockedUntil
+		recordEvent(ctx, dbPool, userID, eventUserLocked, entityUser, userID, audit)
+		log.Printf("Login for user %d locked until %s after repeated failures", userID, lockedUntil.Format(time.RFC3339))
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// lookupSource is a master that can be looked up for typeahead. Matches are
+// by name, or by the code column (GSTIN or HSN code) from its first character.
+type lookupSource struct {
+	table      string
+	codeColumn string
+	codeField  string
+}
+
+// lookupSources are the masters served by /api/lookup/:entity
+var lookupSources = map[string]lookupSource{
+	"customers": {table: "customers", codeColumn: "gstin", codeField: "gstin"},
+	"suppliers": {table: "suppliers", codeColumn: "gstin", codeField: "gstin"},
+	"items":     {table: "items", codeColumn: "hsn_code", codeField: "hsn"},
+}
+
+// lookupMaxLimit caps the number of matches returned by a lookup
+const lookupMaxLimit = 25
+
+// createLookupIndexes adds trigram indexes on master names so lookups stay
+// fast on large masters. Must run after createSearchTables has checked for pg_trgm.
+func createLookupIndexes() {
+	if !searchTrigram {
+		return
+	}
+	for _, source := range lookupSources {
+		_, err := dbPool.Exec(context.Background(), fmt.Sprintf(
+			"CREATE INDEX IF NOT EXISTS idx_%s_name_trgm ON %s USING GIN (lower(name) gin_trgm_ops)",
+			source.table, source.table))
+		if err != nil {
+			log.Fatalf("Failed to create %s lookup index: %v", source.table, err)
+		}
+	}
+}
+
+// escapeLike escapes the LIKE wildcards in a value matched literally
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// handleLookup returns the best matches for a typeahead query against the
+// user's customers, suppliers or items. Names and codes starting with the
+// query come first, followed by similar names when pg_trgm is available.
+func handleLookup(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	source, ok := lookupSources[c.Param("entity")]
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lookup must be customers, suppliers or items"})
+		return
+	}
+
+	query := strings.ToLower(strings.TrimSpace(c.Query("q")))
+	if query == "" {
+		c.JSON(http.StatusOK, gin.H{"results": []gin.H{}})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	if err != nil || limit < 1 || limit > lookupMaxLimit {
+		limit = 10
+	}
+
+	args := []interface{}{userID, escapeLike(query) + "%", limit}
+	prefix := "(lower(name) LIKE $2 OR lower(" + source.codeColumn + ") LIKE $2)"
+	match := prefix
+	rank := "0::float8"
+	if searchTrigram && len([]rune(query)) >= 3 {
+		args = append(args, query)
+		match = "(" + prefix + " OR $4 <% lower(name))"
+		rank = "word_similarity($4, lower(name))::float8"
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, name, COALESCE(`+source.codeColumn+`, '')
+		FROM `+source.table+`
+		WHERE user_id = $1 AND `+match+`
+		ORDER BY `+prefix+` DESC, `+rank+` DESC, name, id
+		LIMIT $3
+	`, args...)
+	if err != nil {
+		log.Printf("Error looking up %s: %v", source.table, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up " + source.table})
+		return
+	}
+	defer rows.Close()
+
+	results := make([]gin.H, 0, limit)
+	for rows.Next() {
+		var id int
+		var name, code string
+		if err := rows.Scan(&id, &name, &code); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read lookup results"})
+			return
+		}
+		results = append(results, gin.H{"id": id, "name": name, source.codeField: code})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
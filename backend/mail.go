@@ -0,0 +1,519 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// errSMTPDisabled is returned when no SMTP server is configured
+var errSMTPDisabled = errors.New("email delivery is not configured")
+
+// jobTypeSendInvoiceEmail is the job type that emails an invoice to its buyer
+const jobTypeSendInvoiceEmail = "invoice.email"
+
+// Invoice email statuses
+const (
+	invoiceEmailQueued = "queued"
+	invoiceEmailSent   = "sent"
+	invoiceEmailFailed = "failed"
+)
+
+// Default subject and body of invoice emails, overridable with
+// INVOICE_EMAIL_SUBJECT and INVOICE_EMAIL_BODY
+const (
+	defaultInvoiceEmailSubject = "Invoice {{.InvoiceNo}} from {{.SellerName}}"
+	defaultInvoiceEmailBody    = `Dear {{.BuyerName}},
+
+Please find attached invoice {{.InvoiceNo}} dated {{.Date}} for Rs. {{printf "%.2f" .Total}}.
+{{if .PaymentLink}}
+Pay online: {{.PaymentLink}}
+{{end}}
+Regards,
+{{.SellerName}}
+`
+)
+
+// invoiceEmailData is the data available to subject and body templates
+type invoiceEmailData struct {
+	InvoiceNo  string
+	Date       string
+	Total      float64
+	SellerName string
+	BuyerName  string
+	// PaymentLink is the invoice's online payment page, if it has one
+	PaymentLink string
+}
+
+// smtpConfig holds the SMTP server settings read from the environment
+type smtpConfig struct {
+	host     string
+	port     string
+	username string
+	password string
+	from     string
+}
+
+// getSMTPConfig reads SMTP_HOST, SMTP_PORT, SMTP_USERNAME, SMTP_PASSWORD and SMTP_FROM
+func getSMTPConfig() (*smtpConfig, error) {
+	cfg := &smtpConfig{
+		host:     os.Getenv("SMTP_HOST"),
+		port:     getEnvWithDefault("SMTP_PORT", "587"),
+		username: os.Getenv("SMTP_USERNAME"),
+		password: os.Getenv("SMTP_PASSWORD"),
+		from:     firstNonEmpty(os.Getenv("SMTP_FROM"), os.Getenv("SMTP_USERNAME")),
+	}
+	if cfg.host == "" || cfg.from == "" {
+		return nil, errSMTPDisabled
+	}
+	return cfg, nil
+}
+
+// mailAttachment is a file attached to an email
+type mailAttachment struct {
+	filename    string
+	contentType string
+	data        []byte
+}
+
+// buildMessage writes a multipart MIME message with a plain text body and attachments
+func buildMessage(from string, to, cc []string, subject, body string, attachments []mailAttachment) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(to, ", "))
+	if len(cc) > 0 {
+		fmt.Fprintf(&buf, "Cc: %s\r\n", strings.Join(cc, ", "))
+	}
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"base64"},
+	})
+	if err != nil {
+		return nil, err
+	}
+	writeBase64Lines(part, []byte(body))
+
+	for _, a := range attachments {
+		part, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {a.contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", a.filename)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		writeBase64Lines(part, a.data)
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeBase64Lines writes data base64 encoded in lines of 76 characters
+func writeBase64Lines(w interface{ Write([]byte) (int, error) }, data []byte) {
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		w.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	w.Write([]byte(encoded + "\r\n"))
+}
+
+// sendMail delivers a message through the configured SMTP server,
+// using STARTTLS when the server offers it
+func sendMail(cfg *smtpConfig, recipients []string, message []byte) error {
+	var auth smtp.Auth
+	if cfg.username != "" {
+		auth = smtp.PlainAuth("", cfg.username, cfg.password, cfg.host)
+	}
+	from, err := mail.ParseAddress(cfg.from)
+	if err != nil {
+		return fmt.Errorf("invalid SMTP_FROM: %w", err)
+	}
+	return smtp.SendMail(cfg.host+":"+cfg.port, auth, from.Address, recipients, message)
+}
+
+// renderEmailTemplate executes a subject or body template
+func renderEmailTemplate(name, text string, data *invoiceEmailData) (string, error) {
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// createMailTables creates the per-invoice log of emails sent to buyers
+func createMailTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS invoice_emails (
+			id SERIAL PRIMARY KEY,
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			recipients TEXT[] NOT NULL,
+			cc TEXT[] NOT NULL DEFAULT '{}',
+			subject TEXT NOT NULL,
+			body TEXT NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'queued',
+			error TEXT,
+			job_id INTEGER REFERENCES jobs(id) ON DELETE SET NULL,
+			sent_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_emails table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_invoice_emails_invoice ON invoice_emails (invoice_id, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice_emails index: %v", err)
+	}
+
+	registerJobHandler(jobTypeSendInvoiceEmail, runSendInvoiceEmailJob)
+}
+
+// runSendInvoiceEmailJob sends a logged invoice email with the invoice PDF and JSON attached
+func runSendInvoiceEmailJob(ctx context.Context, job *models.Job) error {
+	var payload struct {
+		EmailID int `json:"email_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	var recipients, cc []string
+	var subject, body, irn string
+	var invoiceJSON []byte
+	var invoiceID int
+	err := dbPool.QueryRow(ctx, `
+		SELECT e.recipients, e.cc, e.subject, e.body, i.id, i.invoice_json, COALESCE(i.irn, '')
+		FROM invoice_emails e
+		JOIN invoices i ON i.id = e.invoice_id
+		WHERE e.id = $1
+	`, payload.EmailID).Scan(&recipients, &cc, &subject, &body, &invoiceID, &invoiceJSON, &irn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return permanentError(fmt.Errorf("invoice email %d not found", payload.EmailID))
+	}
+	if err != nil {
+		return err
+	}
+
+	fail := func(err error) error {
+		if _, dbErr := dbPool.Exec(ctx,
+			"UPDATE invoice_emails SET status = $1, error = $2 WHERE id = $3",
+			invoiceEmailFailed, err.Error(), payload.EmailID); dbErr != nil {
+			log.Printf("Error recording failure of invoice email %d: %v", payload.EmailID, dbErr)
+		}
+		return err
+	}
+
+	cfg, err := getSMTPConfig()
+	if err != nil {
+		return fail(permanentError(err))
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		return fail(permanentError(fmt.Errorf("unreadable invoice data: %w", err)))
+	}
+	pdf, err := renderInvoicePDF(&invoice, irn, activePaymentLinkURL(ctx, invoiceID))
+	if err != nil {
+		return fail(permanentError(fmt.Errorf("failed to render PDF: %w", err)))
+	}
+
+	filename := sanitizeFilename(invoice.DocDtls.No)
+	message, err := buildMessage(cfg.from, recipients, cc, subject, body, []mailAttachment{
+		{filename: filename + ".pdf", contentType: "application/pdf", data: pdf},
+		{filename: filename + ".json", contentType: "application/json", data: invoiceJSON},
+	})
+	if err != nil {
+		return fail(permanentError(err))
+	}
+
+	if err := sendMail(cfg, append(append([]string{}, recipients...), cc...), message); err != nil {
+		return fail(err)
+	}
+
+	_, err = dbPool.Exec(ctx,
+		"UPDATE invoice_emails SET status = $1, error = NULL, sent_at = NOW() WHERE id = $2",
+		invoiceEmailSent, payload.EmailID)
+	return err
+}
+
+// sanitizeFilename replaces characters that are unsafe in attachment names
+func sanitizeFilename(name string) string {
+	return strings.Map(func(r rune) rune {
+		if strings.ContainsRune(`/\:*?"<>|`, r) || r < 32 {
+			return '_'
+		}
+		return r
+	}, name)
+}
+
+// parseEmailList validates addresses, returning them without display names
+func parseEmailList(values []string) ([]string, error) {
+	addresses := make([]string, 0, len(values))
+	seen := make(map[string]bool)
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid email address %q", v)
+		}
+		key := strings.ToLower(addr.Address)
+		if !seen[key] {
+			seen[key] = true
+			addresses = append(addresses, addr.Address)
+		}
+	}
+	return addresses, nil
+}
+
+// handleEmailInvoice queues the invoice to be emailed to the buyer as PDF and
+// JSON attachments, copying the seller. Recipients, subject and body default
+// to the invoice's buyer and seller emails and the configured templates.
+func handleEmailInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		To      []string `json:"to"`
+		CC      []string `json:"cc"`
+		Subject string   `json:"subject"`
+		Body    string   `json:"body"`
+		// PaymentLink creates a payment link when the invoice has none,
+		// through Gateway if the user has set up several
+		PaymentLink bool   `json:"payment_link"`
+		Gateway     string `json:"gateway"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	if _, err := getSMTPConfig(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Email delivery is not configured"})
+		return
+	}
+
+	var invoiceJSON []byte
+	var status string
+	err = dbPool.QueryRow(ctx,
+		"SELECT invoice_json, status FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceJSON, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if status == models.InvoiceStatusDraft || status == models.InvoiceStatusPendingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "Draft invoices cannot be emailed; finalize the invoice first"})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+
+	if len(req.To) == 0 {
+		req.To = []string{invoice.BuyerDtls.Em}
+	}
+	to, err := parseEmailList(req.To)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if len(to) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The buyer has no email address; provide one in to"})
+		return
+	}
+
+	// The seller is copied from the invoice, or from the saved company
+	sellerEmail := invoice.SellerDtls.Em
+	if sellerEmail == "" {
+		if co, err := getCompanyByGSTIN(ctx, userID, invoice.SellerDtls.Gstin); err == nil {
+			sellerEmail = co.Email
+		}
+	}
+	cc, err := parseEmailList(append(req.CC, sellerEmail))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	paymentLink := activePaymentLinkURL(ctx, id)
+	if paymentLink == "" && req.PaymentLink {
+		link, err := createPaymentLink(ctx, userID, id, strings.ToLower(strings.TrimSpace(req.Gateway)))
+		if err != nil {
+			respondImportError(c, err)
+			return
+		}
+		paymentLink = link.URL
+	}
+
+	data := &invoiceEmailData{
+		InvoiceNo:   invoice.DocDtls.No,
+		Date:        invoice.DocDtls.Dt,
+		Total:       invoice.ValDtls.TotInvVal,
+		SellerName:  firstNonEmpty(invoice.SellerDtls.TrdNm, invoice.SellerDtls.LglNm),
+		BuyerName:   firstNonEmpty(invoice.BuyerDtls.TrdNm, invoice.BuyerDtls.LglNm),
+		PaymentLink: paymentLink,
+	}
+	subject, err := renderEmailTemplate("subject",
+		firstNonEmpty(req.Subject, os.Getenv("INVOICE_EMAIL_SUBJECT"), defaultInvoiceEmailSubject), data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid subject template: " + err.Error()})
+		return
+	}
+	body, err := renderEmailTemplate("body",
+		firstNonEmpty(req.Body, os.Getenv("INVOICE_EMAIL_BODY"), defaultInvoiceEmailBody), data)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid body template: " + err.Error()})
+		return
+	}
+	subject = strings.Join(strings.Fields(subject), " ")
+
+	var emailID int
+	err = dbPool.QueryRow(ctx, `
+		INSERT INTO invoice_emails (invoice_id, user_id, recipients, cc, subject, body, status)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id
+	`, id, userID, to, cc, subject, body, invoiceEmailQueued).Scan(&emailID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record email"})
+		return
+	}
+
+	jobID, err := enqueueJob(ctx, userID, jobTypeSendInvoiceEmail, gin.H{"email_id": emailID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue email"})
+		return
+	}
+	if _, err := dbPool.Exec(ctx,
+		"UPDATE invoice_emails SET job_id = $1 WHERE id = $2", jobID, emailID); err != nil {
+		log.Printf("Error linking invoice email %d to job %d: %v", emailID, jobID, err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Invoice email queued",
+		"email_id":   emailID,
+		"job_id":     jobID,
+		"recipients": to,
+		"cc":         cc,
+	})
+}
+
+// handleGetInvoiceEmails returns the log of emails sent for an invoice, newest first
+func handleGetInvoiceEmails(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var exists bool
+	err = dbPool.QueryRow(c.Request.Context(),
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, recipients, cc, subject, status, COALESCE(error, ''), job_id, sent_at, created_at
+		FROM invoice_emails
+		WHERE invoice_id = $1
+		ORDER BY created_at DESC
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch emails"})
+		return
+	}
+	defer rows.Close()
+
+	emails := make([]gin.H, 0)
+	for rows.Next() {
+		var emailID int
+		var recipients, cc []string
+		var subject, status, emailErr string
+		var jobID *int
+		var sentAt *time.Time
+		var createdAt time.Time
+		if err := rows.Scan(&emailID, &recipients, &cc, &subject, &status, &emailErr, &jobID, &sentAt, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read email data"})
+			return
+		}
+		email := gin.H{
+			"id":         emailID,
+			"recipients": recipients,
+			"cc":         cc,
+			"subject":    subject,
+			"status":     status,
+			"job_id":     jobID,
+			"sent_at":    sentAt,
+			"created_at": createdAt,
+		}
+		if emailErr != "" {
+			email["error"] = emailErr
+		}
+		emails = append(emails, email)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"emails": emails})
+}
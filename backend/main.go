@@ -10,16 +10,16 @@ import (
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"einvoice-app/models"
 
-	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/joho/godotenv"
-	"github.com/skip2/go-qrcode"
 	"github.com/xuri/excelize/v2"
 )
 
@@ -29,6 +29,13 @@ var dbPool *pgxpool.Pool
 // TokenClaims represents JWT claims
 type TokenClaims struct {
 	UserID int `json:"user_id"`
+	// TokenVersion must match the user's token_version for the token to be accepted
+	TokenVersion int `json:"ver"`
+	// ImpersonatorID is the super admin running a support session as the user
+	ImpersonatorID int `json:"imp,omitempty"`
+	// PasswordResetRequired is set by verifyToken from the user's account; it
+	// is not part of the token
+	PasswordResetRequired bool `json:"-"`
 	jwt.RegisteredClaims
 }
 
@@ -66,6 +73,24 @@ func main() {
 		log.Println("No .env file found or error loading it. Using environment variables.")
 	}
 	
+	// Load the keys tokens are signed with
+	initJWTKeys()
+
+	// Load the master keys company credentials are encrypted with
+	initCredentialVault()
+
+	// Load the NIC certificates signed QR codes are verified against
+	loadNICQRKeys()
+
+	// Configure the message broker domain events are published to
+	initEventPublisher()
+
+	// Configure the provider SMS notifications are sent through
+	initSMSProvider()
+
+	// Configure the object storage signed QR code links point to
+	initObjectStore()
+
 	// Initialize database connection
 	initDB()
 	defer dbPool.Close()
@@ -73,39 +98,291 @@ func main() {
 	// Create tables if they don't exist
 	createTables()
 
+	// Start background job workers
+	startJobWorkers(context.Background())
+
+	// Keep event projections up to date
+	startProjectionUpdater(context.Background())
+
+	// Publish queued events to the message broker
+	startOutboxRelay(context.Background())
+
+	// Send SMS notifications for invoice events and overdue payments
+	startNotifier(context.Background())
+
+	// Email users their daily or weekly digests
+	startDigestScheduler(context.Background())
+
+	// Keep the cached exchange rates for export invoices current
+	startExchangeRateUpdater(context.Background())
+
+	// Create invoice partitions ahead of time and detach old ones
+	startInvoicePartitionMaintainer(context.Background())
+
+	// Serve the gRPC API for ERP integrations
+	startGRPCServer()
+
 	// Initialize Gin router
 	router := gin.Default()
 
-	// Configure CORS with environment variables
+	// Keep uploads within the size limit in memory rather than spilling to temp files
+	router.MaxMultipartMemory = getMaxUploadBytes() + multipartOverhead
+
+	// Configure per-route-group CORS policies from environment variables
 	configureCORS(router)
 
+	// Add HSTS, nosniff and framing protection to all responses
+	router.Use(securityHeaders())
+
+	// Compress JSON and text responses for clients that accept gzip
+	router.Use(gzipMiddleware())
+
+	// Give JSON error responses a machine-readable code and a common shape
+	router.Use(errorEnvelope())
+
+	// Limit each query of a request and cancel them when the client disconnects
+	router.Use(queryTimeouts())
+
+	// Warn clients of the unversioned /api paths to move to /api/v1
+	router.Use(unversionedDeprecation())
+
 	// Public routes - MUST be defined BEFORE the authMiddleware
 	router.POST("/api/register", handleRegister)
 	router.POST("/api/login", handleLogin)
-	router.GET("/api/download-template", handleDownloadExcelTemplate)
+	router.GET("/api/auth/google", handleGoogleLogin)
+	router.GET("/api/auth/google/callback", handleGoogleCallback)
+	router.GET("/api/integrations/:provider/callback", handleIntegrationCallback)
+	// Payment gateways notify paid links; the token in the URL names the account
+	router.POST("/api/webhooks/payments/:gateway/:token", handlePaymentWebhook)
+	// Delivery status of invoices sent on WhatsApp
+	router.GET("/api/webhooks/whatsapp", handleWhatsAppWebhookVerify)
+	router.POST("/api/webhooks/whatsapp", handleWhatsAppWebhook)
+	// Public schema and template downloads share one per-IP rate limit
+	publicLimit := rateLimitMiddleware(getPublicRateLimit())
+	router.GET("/api/download-template", publicLimit, handleDownloadExcelTemplate)
+	router.GET("/.well-known/jwks.json", publicLimit, handleJWKS)
+	// Account export links work without a session, as the account may already be erased
+	router.GET("/api/account-export/:token", publicLimit, handleDownloadAccountExport)
+	// Buyers verify the QR codes of invoices they received without an account
+	router.POST("/api/verify-qr", publicLimit, handleVerifyQR)
+	public := router.Group("/api/public")
+	public.Use(publicLimit)
+	{
+		public.GET("/template-schema", handlePublicTemplateSchema)
+		public.GET("/einvoice-schema", handlePublicEInvoiceSchema)
+		public.GET("/error-codes", handleGetErrorCodes)
+	}
 
 	// Protected routes group
 	auth := router.Group("/api")
-	auth.Use(authMiddleware())
+	auth.Use(groupBase(auth), authMiddleware(), accountMiddleware())
 	{
 		auth.POST("/generate-invoice", handleGenerateInvoice)
-		auth.POST("/upload-excel", handleUploadExcel)
+		auth.POST("/validate-invoice", handleValidateInvoice)
+		auth.POST("/upload-excel", requireIssueRights(), handleUploadExcel)
+		auth.GET("/excel-mappings", handleGetExcelMappings)
+		auth.POST("/excel-mappings", handleCreateExcelMapping)
+		auth.PUT("/excel-mappings/:id", handleUpdateExcelMapping)
+		auth.DELETE("/excel-mappings/:id", handleDeleteExcelMapping)
 		auth.GET("/export-invoices", handleExportInvoices)
+		auth.POST("/export-invoices", handleExportSelectedInvoices)
 		auth.GET("/invoices", handleGetInvoices)
 		auth.GET("/invoices/:id", handleGetInvoiceById)
 		auth.PUT("/invoices/:id", handleUpdateInvoice)
 		auth.DELETE("/invoices/:id", handleDeleteInvoice)
+		auth.POST("/invoices/:id/finalize", handleFinalizeInvoice)
+		auth.POST("/invoices/:id/clone", handleCloneInvoice)
+		auth.POST("/invoices/:id/cancel", handleCancelInvoice)
+		auth.POST("/invoices/:id/cancel-irn", handleCancelIRN)
+		auth.GET("/invoices/:id/approvals", handleGetInvoiceApprovals)
+		auth.GET("/invoices/:id/syncs", handleGetInvoiceSyncs)
+		auth.GET("/approvals", handleGetApprovals)
+		auth.POST("/approvals/:id/approve", handleApproveInvoice)
+		auth.POST("/approvals/:id/reject", handleRejectInvoice)
+		auth.POST("/irp-acknowledgements", handleImportIRPAcks)
+		auth.POST("/invoices/:id/email", handleEmailInvoice)
+		auth.GET("/invoices/:id/emails", handleGetInvoiceEmails)
+		auth.POST("/invoices/:id/whatsapp", handleSendInvoiceWhatsApp)
+		auth.GET("/invoices/:id/whatsapp", handleGetInvoiceWhatsAppMessages)
+		auth.GET("/invoices/:id/sms", handleGetInvoiceSMSMessages)
+		auth.GET("/invoices/:id/html", handleGetInvoiceHTML)
+		auth.GET("/invoices/:id/pdf", handleGetInvoicePDF)
+		auth.GET("/invoices/:id/tags", handleGetInvoiceTags)
+		auth.PUT("/invoices/:id/tags", handleSetInvoiceTags)
+		auth.GET("/tags", handleGetTags)
+		auth.POST("/tags", handleCreateTag)
+		auth.PUT("/tags/:id", handleUpdateTag)
+		auth.DELETE("/tags/:id", handleDeleteTag)
+		auth.POST("/tags/:id/invoices", handleTagInvoices)
+		auth.GET("/invoices/:id/payments", handleGetInvoicePayments)
+		auth.POST("/invoices/:id/payments", handleCreatePayment)
+		auth.DELETE("/invoices/:id/payments/:paymentId", handleDeletePayment)
+		auth.GET("/invoices/:id/payment-links", handleGetPaymentLinks)
+		auth.POST("/invoices/:id/payment-links", handleCreatePaymentLink)
+		auth.DELETE("/invoices/:id/payment-links/:linkId", handleCancelPaymentLink)
 		auth.GET("/qr/:id", handleGetQRCode)
-		auth.POST("/import-json", handleImportJSON)
+		auth.GET("/qr/:id/url", handleGetQRCodeURL)
+		auth.POST("/import-json", requireIssueRights(), handleImportJSON)
+		auth.GET("/imports", handleGetImports)
+		auth.GET("/imports/:id", handleGetImport)
+		auth.GET("/imports/:id/file", handleDownloadImportFile)
+		auth.POST("/imports/:id/retry", requireIssueRights(), handleRetryImport)
 		auth.GET("/export-json/:id", handleExportJSON)
 		auth.GET("/export-all-json", handleExportAllJSON)
+		auth.GET("/export-jsonl", handleExportJSONL)
 		auth.PUT("/invoices/:id/mark-exported", handleMarkInvoiceExported)
 		auth.GET("/suppliers", handleGetSuppliers)
 		auth.POST("/suppliers", handleCreateSupplier)
 		auth.PUT("/suppliers/:id", handleUpdateSupplier)
 		auth.DELETE("/suppliers/:id", handleDeleteSupplier)
+		auth.POST("/suppliers/import", handleImportParties(supplierParties))
+		auth.GET("/suppliers/import/template", handleDownloadPartyTemplate(supplierParties))
+		auth.POST("/suppliers/merge", handleMergeParties(supplierParties))
+		auth.GET("/companies", handleGetCompanies)
+		auth.POST("/companies", handleCreateCompany)
+		auth.PUT("/companies/:id", handleUpdateCompany)
+		auth.DELETE("/companies/:id", handleDeleteCompany)
+		auth.GET("/companies/:id/credentials", ownerMiddleware(), handleGetCompanyCredentials)
+		auth.PUT("/companies/:id/credentials/:provider", ownerMiddleware(), noImpersonation(), handleSetCompanyCredentials)
+		auth.DELETE("/companies/:id/credentials/:provider", ownerMiddleware(), noImpersonation(), handleDeleteCompanyCredentials)
+		auth.POST("/companies/:id/credentials/:provider/test", ownerMiddleware(), handleTestCompanyCredentials)
+		auth.GET("/customers", handleGetCustomers)
+		auth.POST("/customers", handleCreateCustomer)
+		auth.PUT("/customers/:id", handleUpdateCustomer)
+		auth.DELETE("/customers/:id", handleDeleteCustomer)
+		auth.POST("/customers/:id/replace-buyer", handleReplaceBuyerOnDrafts)
+		auth.POST("/customers/import", handleImportParties(customerParties))
+		auth.GET("/customers/import/template", handleDownloadPartyTemplate(customerParties))
+		auth.POST("/customers/merge", handleMergeParties(customerParties))
+		auth.GET("/gstin/:gstin/verify", handleVerifyGSTIN)
+		auth.GET("/hsn/search", handleSearchHSN)
+		auth.GET("/hsn/:code", handleGetHSN)
+		auth.GET("/gst-rates", handleGetGSTRates)
+		auth.GET("/states", handleGetStates)
+		auth.GET("/pincode/:pin", handleGetPincode)
+		auth.GET("/ewb/distance", handleGetDistance)
+		auth.GET("/transporters", handleGetTransporters)
+		auth.POST("/transporters", handleCreateTransporter)
+		auth.PUT("/transporters/:id", handleUpdateTransporter)
+		auth.DELETE("/transporters/:id", handleDeleteTransporter)
+		auth.GET("/vehicles", handleGetVehicles)
+		auth.POST("/vehicles", handleCreateVehicle)
+		auth.PUT("/vehicles/:id", handleUpdateVehicle)
+		auth.DELETE("/vehicles/:id", handleDeleteVehicle)
+		auth.PUT("/invoices/:id/ewb", handleSetInvoiceEwbDetails)
+		auth.POST("/invoices/:id/ewb/vehicle", handleUpdateEwbVehicle)
+		auth.GET("/invoices/:id/ewb/vehicles", handleGetEwbVehicleUpdates)
+		auth.GET("/search", handleSearch)
+		auth.GET("/lookup/:entity", handleLookup)
+		auth.GET("/audit-log", handleGetAuditLog)
+		auth.GET("/sandbox", handleGetSandbox)
+		auth.PUT("/sandbox", ownerMiddleware(), handleUpdateSandbox)
+		auth.DELETE("/sandbox/invoices", ownerMiddleware(), handlePurgeSandbox)
+		auth.GET("/settings", handleGetSettings)
+		auth.PUT("/settings", ownerMiddleware(), handleUpdateSettings)
+		auth.GET("/period-locks", handleGetPeriodLocks)
+		auth.POST("/period-locks", ownerMiddleware(), handleLockPeriod)
+		auth.DELETE("/period-locks/:period", ownerMiddleware(), handleUnlockPeriod)
+		auth.GET("/config/export", handleExportConfig)
+		auth.POST("/config/import", ownerMiddleware(), handleImportConfig)
+		auth.GET("/exchange-rates", handleGetExchangeRate)
+		auth.GET("/members", ownerMiddleware(), handleGetMembers)
+		auth.POST("/members", ownerMiddleware(), noImpersonation(), handleAddMember)
+		auth.PUT("/members/:id", ownerMiddleware(), noImpersonation(), handleUpdateMember)
+		auth.DELETE("/members/:id", ownerMiddleware(), noImpersonation(), handleRemoveMember)
+		auth.GET("/integrations", handleGetIntegrations)
+		auth.POST("/integrations/:provider/connect", ownerMiddleware(), noImpersonation(), handleConnectIntegration)
+		auth.POST("/integrations/:provider/sync", handleSyncIntegration)
+		auth.DELETE("/integrations/:provider", ownerMiddleware(), noImpersonation(), handleDisconnectIntegration)
+		auth.GET("/payment-gateways", handleGetPaymentGateways)
+		auth.PUT("/payment-gateways/:gateway", ownerMiddleware(), handleSavePaymentGateway)
+		auth.DELETE("/payment-gateways/:gateway", ownerMiddleware(), handleDeletePaymentGateway)
+		auth.GET("/notification-rules", handleGetNotificationRules)
+		auth.POST("/notification-rules", ownerMiddleware(), handleSaveNotificationRule)
+		auth.DELETE("/notification-rules/:id", ownerMiddleware(), handleDeleteNotificationRule)
+		auth.GET("/integrity/scan", handleIntegrityScan)
+		auth.POST("/integrity/invoices/:id/revalidate", handleRevalidateInvoice)
+		auth.POST("/integrity/invoices/:id/quarantine", handleQuarantineInvoice)
+		auth.GET("/purchase-orders", handleGetPurchaseOrders)
+		auth.POST("/purchase-orders", handleCreatePurchaseOrder)
+		auth.PUT("/purchase-orders/:id", handleUpdatePurchaseOrder)
+		auth.DELETE("/purchase-orders/:id", handleDeletePurchaseOrder)
+		auth.GET("/purchase-orders/:id/invoices", handleGetPurchaseOrderInvoices)
+		auth.GET("/proforma-documents", handleGetProformaDocuments)
+		auth.POST("/proforma-documents", handleCreateProformaDocument)
+		auth.GET("/proforma-documents/:id", handleGetProformaDocument)
+		auth.PUT("/proforma-documents/:id", handleUpdateProformaDocument)
+		auth.DELETE("/proforma-documents/:id", handleDeleteProformaDocument)
+		auth.POST("/proforma-documents/:id/convert", handleConvertProformaDocument)
+		auth.GET("/delivery-challans", handleGetChallans)
+		auth.POST("/delivery-challans", handleCreateChallan)
+		auth.GET("/delivery-challans/:id", handleGetChallan)
+		auth.PUT("/delivery-challans/:id", handleUpdateChallan)
+		auth.DELETE("/delivery-challans/:id", handleDeleteChallan)
+		auth.POST("/delivery-challans/:id/convert", handleConvertChallan)
+		auth.GET("/purchase-invoices", handleGetPurchaseInvoices)
+		auth.POST("/purchase-invoices", handleCreatePurchaseInvoice)
+		auth.GET("/purchase-invoices/:id", handleGetPurchaseInvoice)
+		auth.PUT("/purchase-invoices/:id", handleUpdatePurchaseInvoice)
+		auth.DELETE("/purchase-invoices/:id", handleDeletePurchaseInvoice)
+		auth.GET("/reports/po-tracking", handlePOTrackingReport)
+		auth.GET("/reports/tax-summary", handleTaxSummaryReport)
+		auth.GET("/reports/tax-summary/:month", handleTaxSummaryMonth)
+		auth.GET("/reports/fy-summary", handleFiscalYearSummary)
+		auth.GET("/reports/number-gaps", handleNumberGapsReport)
+		auth.GET("/reports/gstr1", handleGSTR1Report)
+		auth.GET("/reports/sales-register", handleSalesRegisterReport)
+		auth.GET("/reports/irn-reconciliation", handleIRNReconciliationReport)
+		auth.GET("/reports/gst-rate-mismatches", handleGSTRateMismatchReport)
+		auth.GET("/reports/itc-summary", handleITCSummaryReport)
+		auth.POST("/gstr2b/reconcile", handleReconcileGSTR2B)
+	}
+
+	// Profile routes act on the signed-in user rather than the account they work on
+	me := router.Group("/api/me")
+	me.Use(authMiddleware())
+	{
+		me.GET("", handleGetProfile)
+		me.PUT("", handleUpdateProfile)
+		me.PUT("/password", noImpersonation(), handleChangePassword)
+		me.DELETE("", noImpersonation(), handleDeleteAccount)
+		me.GET("/deletion", handleGetAccountDeletion)
+		me.DELETE("/deletion", handleCancelAccountDeletion)
+	}
+
+	// Admin routes group
+	admin := router.Group("/api/admin")
+	admin.Use(authMiddleware(), adminMiddleware())
+	{
+		admin.GET("/jobs", handleAdminGetJobs)
+		admin.GET("/jobs/:id", handleAdminGetJob)
+		admin.PUT("/jobs/:id/payload", handleAdminUpdateJobPayload)
+		admin.POST("/jobs/:id/retry", handleAdminRetryJob)
+		admin.GET("/projections", handleAdminGetProjections)
+		admin.POST("/projections/:name/rebuild", handleAdminRebuildProjection)
+		admin.GET("/outbox", handleAdminGetOutbox)
+		admin.POST("/credentials/rewrap", handleAdminRewrapCredentials)
+		admin.POST("/exchange-rates/refresh", handleAdminRefreshExchangeRates)
+		admin.POST("/gst-rates", handleAdminCreateGSTRate)
+		admin.DELETE("/gst-rates/:id", handleAdminDeleteGSTRate)
+	}
+
+	// User management routes need the separate super admin role
+	superAdmin := router.Group("/api/admin")
+	superAdmin.Use(authMiddleware(), superAdminMiddleware())
+	{
+		superAdmin.GET("/users", handleAdminGetUsers)
+		superAdmin.GET("/users/:id", handleAdminGetUser)
+		superAdmin.POST("/users/:id/disable", handleAdminDisableUser)
+		superAdmin.POST("/users/:id/enable", handleAdminEnableUser)
+		superAdmin.POST("/users/:id/impersonate", handleAdminImpersonateUser)
+		superAdmin.POST("/users/:id/reset-password", handleAdminResetUserPassword)
+		superAdmin.GET("/stats", handleAdminGetStats)
 	}
 
+	// Serve the embedded frontend, when the binary has one, for all other paths
+	serveSPA(router)
+
 	// Get port from environment variable or use default for Render compatibility
 	port := os.Getenv("PORT")
 	if port == "" {
@@ -114,7 +391,8 @@ func main() {
 	
 	// Start server
 	log.Println("Starting server on port :" + port)
-	if err := router.Run(":" + port); err != nil {
+	// Routes are registered under /api and served under /api/v1 as well
+	if err := http.ListenAndServe(":"+port, apiVersions(router)); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -142,7 +420,11 @@ func initDB() {
 	}
 	
 	// Set connection pool parameters
-	config.MaxConns = 10
+	configurePool(config)
+
+	// Enforce the per-query time limits of request contexts
+	loadQueryTimeouts()
+	config.ConnConfig.Tracer = queryTimeoutTracer{}
 	
 	dbPool, err = pgxpool.NewWithConfig(context.Background(), config)
 	if err != nil {
@@ -166,17 +448,6 @@ func getEnvWithDefault(key, defaultValue string) string {
 	return value
 }
 
-// Get JWT secret from environment variable or use default
-func getJWTSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// In production, this should log a warning
-		log.Println("Warning: Using default JWT secret. Set JWT_SECRET environment variable in production.")
-		return "einvoice-app-secret-key"
-	}
-	return secret
-}
-
 // createTables creates the necessary tables if they don't exist
 func createTables() {
 	// Create users table
@@ -185,6 +456,7 @@ func createTables() {
 			id SERIAL PRIMARY KEY,
 			email VARCHAR(255) UNIQUE NOT NULL,
 			password VARCHAR(255) NOT NULL,
+			is_admin BOOLEAN NOT NULL DEFAULT FALSE,
 			created_at TIMESTAMP NOT NULL DEFAULT NOW()
 		)
 	`)
@@ -192,27 +464,116 @@ func createTables() {
 		log.Fatalf("Failed to create users table: %v", err)
 	}
 
-	// Create invoices table with exported status
+	// Ensure admin flag exists on users tables created before it was added
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS is_admin BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		log.Fatalf("Failed to add is_admin column to users table: %v", err)
+	}
+	promoteAdminsFromEnv()
+
+	// Link users to their Google account for Google sign-in
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS google_sub VARCHAR(255) UNIQUE")
+	if err != nil {
+		log.Fatalf("Failed to add google_sub column to users table: %v", err)
+	}
+
+	// Create invoices table with exported status, partitioned by month of
+	// created_at (see createInvoicePartitions)
 	_, err = dbPool.Exec(context.Background(), `
 		CREATE TABLE IF NOT EXISTS invoices (
-			id SERIAL PRIMARY KEY,
+			id SERIAL,
 			user_id INTEGER NOT NULL REFERENCES users(id),
 			seller_gstin VARCHAR(15) NOT NULL,
-			invoice_no VARCHAR(50) UNIQUE NOT NULL,
+			invoice_no VARCHAR(50) NOT NULL,
 			invoice_json JSONB NOT NULL,
 			qr_code BYTEA,
 			exported BOOLEAN NOT NULL DEFAULT FALSE,
 			exported_at TIMESTAMP,
+			quarantined BOOLEAN NOT NULL DEFAULT FALSE,
+			quarantine_reason TEXT,
+			quarantined_at TIMESTAMP,
+			status VARCHAR(20) NOT NULL DEFAULT 'finalized',
+			finalized_at TIMESTAMP,
+			cancel_reason TEXT,
+			cancelled_at TIMESTAMP,
+			irn VARCHAR(64),
+			ack_no VARCHAR(20),
+			ack_dt TIMESTAMP,
+			signed_qr_code TEXT,
+			irn_cancel_remarks VARCHAR(100),
+			irn_cancelled_at TIMESTAMP,
 			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
-			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
-		)
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (id, created_at)
+		) PARTITION BY RANGE (created_at)
 	`)
 	if err != nil {
 		log.Fatalf("Failed to create invoices table: %v", err)
 	}
+
+	// Partition the invoices table of earlier versions and keep partitions ready
+	createInvoicePartitions()
 	
 	// Ensure exported columns exist
 	addExportedColumnsIfNeeded()
+
+	// Ensure quarantine columns exist
+	_, err = dbPool.Exec(context.Background(), `
+		ALTER TABLE invoices
+		ADD COLUMN IF NOT EXISTS quarantined BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS quarantine_reason TEXT,
+		ADD COLUMN IF NOT EXISTS quarantined_at TIMESTAMP
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add quarantine columns to invoices table: %v", err)
+	}
+
+	// Ensure status workflow columns exist; invoices created before the
+	// workflow was introduced are treated as finalized
+	_, err = dbPool.Exec(context.Background(), `
+		ALTER TABLE invoices
+		ADD COLUMN IF NOT EXISTS status VARCHAR(20) NOT NULL DEFAULT 'finalized',
+		ADD COLUMN IF NOT EXISTS finalized_at TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS cancel_reason TEXT,
+		ADD COLUMN IF NOT EXISTS cancelled_at TIMESTAMP
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add status columns to invoices table: %v", err)
+	}
+
+	// Ensure IRN registration and cancellation columns exist
+	_, err = dbPool.Exec(context.Background(), `
+		ALTER TABLE invoices
+		ADD COLUMN IF NOT EXISTS irn VARCHAR(64),
+		ADD COLUMN IF NOT EXISTS ack_no VARCHAR(20),
+		ADD COLUMN IF NOT EXISTS ack_dt TIMESTAMP,
+		ADD COLUMN IF NOT EXISTS signed_qr_code TEXT,
+		ADD COLUMN IF NOT EXISTS irn_cancel_remarks VARCHAR(100),
+		ADD COLUMN IF NOT EXISTS irn_cancelled_at TIMESTAMP
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add IRN columns to invoices table: %v", err)
+	}
+	createIRPAckColumns()
+
+	// QR codes are generated on request; qr_code and qr_object_key hold those
+	// stored by earlier versions and are cleared as invoices are rewritten
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE invoices ADD COLUMN IF NOT EXISTS qr_object_key TEXT")
+	if err != nil {
+		log.Fatalf("Failed to add qr_object_key column to invoices table: %v", err)
+	}
+
+	// Keep invoice totals, buyer and date in columns for filtering and sums
+	createInvoiceAggregateColumns()
+
+	// Index invoice JSON for filters on its fields
+	createInvoiceJSONIndexes()
+
+	// Create invoice number series table
+	createNumberingTables()
 	
 	// Create companies table for sellers
 	_, err = dbPool.Exec(context.Background(), `
@@ -228,6 +589,13 @@ func createTables() {
 			phone VARCHAR(20),
 			email VARCHAR(255),
 			is_default BOOLEAN NOT NULL DEFAULT FALSE,
+			qty_decimals SMALLINT NOT NULL DEFAULT 2,
+			rate_decimals SMALLINT NOT NULL DEFAULT 2,
+			rounding VARCHAR(10) NOT NULL DEFAULT 'line',
+			rounding_method VARCHAR(10) NOT NULL DEFAULT 'half_up',
+			round_off BOOLEAN NOT NULL DEFAULT FALSE,
+			upi_vpa VARCHAR(100) NOT NULL DEFAULT '',
+			upi_payee_name VARCHAR(100) NOT NULL DEFAULT '',
 			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
 			UNIQUE(user_id, gstin)
 		)
@@ -235,6 +603,24 @@ func createTables() {
 	if err != nil {
 		log.Fatalf("Failed to create companies table: %v", err)
 	}
+
+	// Ensure precision, rounding, UPI, validation and LUT columns exist on companies created before they were added
+	_, err = dbPool.Exec(context.Background(), `
+		ALTER TABLE companies
+		ADD COLUMN IF NOT EXISTS qty_decimals SMALLINT NOT NULL DEFAULT 2,
+		ADD COLUMN IF NOT EXISTS rate_decimals SMALLINT NOT NULL DEFAULT 2,
+		ADD COLUMN IF NOT EXISTS rounding VARCHAR(10) NOT NULL DEFAULT 'line',
+		ADD COLUMN IF NOT EXISTS rounding_method VARCHAR(10) NOT NULL DEFAULT 'half_up',
+		ADD COLUMN IF NOT EXISTS round_off BOOLEAN NOT NULL DEFAULT FALSE,
+		ADD COLUMN IF NOT EXISTS upi_vpa VARCHAR(100) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS upi_payee_name VARCHAR(100) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS validation_profile VARCHAR(10) NOT NULL DEFAULT 'strict',
+		ADD COLUMN IF NOT EXISTS lut_no VARCHAR(20) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS lut_fy VARCHAR(7) NOT NULL DEFAULT ''
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add settings columns to companies table: %v", err)
+	}
 	
 	// Create customers table for buyers
 	_, err = dbPool.Exec(context.Background(), `
@@ -297,6 +683,114 @@ func createTables() {
 		log.Fatalf("Failed to create suppliers table: %v", err)
 	}
 
+	// Create GSTIN verification cache
+	createGSTINTables()
+
+	// Create and seed HSN/SAC master
+	createHSNTables()
+
+	// Create and seed the GST rate master with effective dates
+	createGSTRateTables()
+
+	// Create and seed state code master
+	createStateTables()
+
+	// Create and seed the PIN code directory; states must be loaded first
+	createPincodeTables()
+
+	// Create and seed the PIN-to-PIN distance matrix for e-way bills
+	createDistanceTables()
+
+	// Add sandbox flags to accounts and invoices
+	createSandboxColumns()
+
+	// Add profile details and session invalidation to accounts
+	createProfileColumns()
+
+	// Add the super admin role and disabled and forced password reset states to accounts
+	createAdminUserColumns()
+
+	// Create purchase orders table
+	createPurchaseOrderTables()
+
+	// Create proforma invoices and quotations, numbered apart from invoices
+	createProformaTables()
+
+	// Create delivery challans, numbered apart from invoices
+	createChallanTables()
+
+	// Create purchase bills for input tax credit tracking
+	createPurchaseTables()
+
+	// Link invoices to the customers they are made out to
+	createInvoiceCustomerColumn()
+
+	// Create Excel column mapping profiles table
+	createExcelMappingTables()
+
+	// Create tags and the tags put on invoices
+	createTagTables()
+
+	// Create transporter and vehicle masters and e-way bill vehicle updates
+	createTransportTables()
+	createPeriodLockTables()
+
+	// Create background jobs table
+	createJobTables()
+
+	// Create table keeping uploaded files for download and retry
+	createImportTables()
+
+	// Create the log of invoices emailed to buyers
+	createMailTables()
+
+	// Create the log of invoices sent to buyers on WhatsApp
+	createWhatsAppTables()
+
+	// Create the log of email digests sent to users
+	createDigestTables()
+
+	// Create the cache of exchange rates for export invoices
+	createExchangeRateTables()
+
+	// Create payments received against invoices
+	createPaymentTables()
+
+	// Create per-user defaults for new invoices
+	createSettingsTables()
+
+	// Create account members and the approval history of their invoices
+	createMemberTables()
+	createApprovalTables()
+
+	// Create the log of login attempts used for account lockout
+	createLoginTables()
+
+	// Create account deletion requests and their export, erase and purge jobs
+	createAccountTables()
+
+	// Create the encrypted store of GSTN, IRP and GSP credentials
+	createCredentialTables()
+
+	// Create accounting service connections and the sync status of invoices pushed to them
+	createIntegrationTables()
+
+	// Create payment gateway accounts and the payment links of invoices
+	createPaymentLinkTables()
+
+	// Create domain event log, the outbox of events to publish and the projections rebuilt from it
+	createEventTables()
+	createOutboxTables()
+	createSearchTables()
+	createLookupIndexes()
+	createProjectionTables()
+
+	// Create SMS notification rules and the log of messages sent, checkpointed against the event log
+	createNotificationTables()
+
+	// Add indexes backing keyset pagination of list endpoints
+	createPageIndexes()
+
 	log.Println("Database tables created")
 }
 
@@ -367,26 +861,31 @@ func authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" || len(authHeader) < 8 || authHeader[:7] != "Bearer " {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid authorization header"})
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Missing or invalid authorization header", "code": errCodeUnauthenticated})
 			c.Abort()
 			return
 		}
 
 		tokenString := authHeader[7:]
-		claims := &TokenClaims{}
-
-		token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-			return []byte(getJWTSecret()), nil
-		})
+		claims, err := verifyToken(c.Request.Context(), tokenString)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token", "code": errCodeInvalidToken})
+			c.Abort()
+			return
+		}
 
-		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid or expired token"})
+		// Users whose password reset an admin forced must set a new one first
+		if claims.PasswordResetRequired && !passwordResetRoutes[c.Request.Method+" "+c.FullPath()] {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Password reset required", "password_reset_required": true})
 			c.Abort()
 			return
 		}
 
 		// Set user ID in context
 		c.Set("userID", claims.UserID)
+		if claims.ImpersonatorID != 0 {
+			c.Set("impersonatorID", claims.ImpersonatorID)
+		}
 		c.Next()
 	}
 }
@@ -401,7 +900,7 @@ func handleRegister(c *gin.Context) {
 
 	// Check if email already exists
 	var count int
-	err := dbPool.QueryRow(context.Background(), "SELECT COUNT(*) FROM users WHERE email = $1", req.Email).Scan(&count)
+	err := dbPool.QueryRow(c.Request.Context(), "SELECT COUNT(*) FROM users WHERE email = $1", req.Email).Scan(&count)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
 		return
@@ -421,7 +920,7 @@ func handleRegister(c *gin.Context) {
 
 	// Insert user into database
 	var userID int
-	err = dbPool.QueryRow(context.Background(),
+	err = dbPool.QueryRow(c.Request.Context(),
 		"INSERT INTO users (email, password, created_at) VALUES ($1, $2, $3) RETURNING id",
 		user.Email, user.Password, user.CreatedAt).Scan(&userID)
 	if err != nil {
@@ -432,6 +931,32 @@ func handleRegister(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "User registered successfully"})
 }
 
+// issueToken signs the JWT that authenticates a user's API requests until
+// it expires or the user's sessions are invalidated
+func issueToken(ctx context.Context, userID int) (string, error) {
+	var version int
+	var disabled bool
+	err := dbPool.QueryRow(ctx,
+		"SELECT token_version, disabled_at IS NOT NULL FROM users WHERE id = $1", userID).Scan(&version, &disabled)
+	if err != nil {
+		return "", err
+	}
+	if disabled {
+		return "", errAccountDisabled
+	}
+
+	claims := TokenClaims{
+		UserID:       userID,
+		TokenVersion: version,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return jwtKeys.sign(claims)
+}
+
 // handleLogin handles user login
 func handleLogin(c *gin.Context) {
 	var req LoginRequest
@@ -440,33 +965,46 @@ func handleLogin(c *gin.Context) {
 		return
 	}
 
+	// Refuse logins while the email or client IP is locked out
+	ctx := c.Request.Context()
+	email, ip := normalizeLoginEmail(req.Email), c.ClientIP()
+	lockedUntil, err := loginLockedUntil(ctx, email, ip)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if lockedUntil != nil {
+		c.Header("Retry-After", strconv.Itoa(int(time.Until(*lockedUntil).Seconds())+1))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts; try again later", "code": errCodeTooManyLoginAttempts})
+		return
+	}
+
 	// Retrieve user from database
 	var user models.User
-	err := dbPool.QueryRow(context.Background(),
-		"SELECT id, email, password, created_at FROM users WHERE email = $1",
-		req.Email).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt)
+	var resetRequired bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT id, email, password, created_at, password_reset_required FROM users WHERE email = $1",
+		req.Email).Scan(&user.ID, &user.Email, &user.Password, &user.CreatedAt, &resetRequired)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		recordLoginAttempt(ctx, 0, email, ip, false)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password", "code": errCodeInvalidCredentials})
 		return
 	}
 
 	// Verify password
 	if !user.CheckPassword(req.Password) {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password"})
+		recordLoginAttempt(ctx, user.ID, email, ip, false)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid email or password", "code": errCodeInvalidCredentials})
 		return
 	}
+	recordLoginAttempt(ctx, user.ID, email, ip, true)
 
 	// Generate JWT token
-	claims := TokenClaims{
-		UserID: user.ID,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+	tokenString, err := issueToken(ctx, user.ID)
+	if errors.Is(err, errAccountDisabled) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Account is disabled", "code": errCodeAccountDisabled})
+		return
 	}
-
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString([]byte(getJWTSecret()))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
@@ -478,12 +1016,18 @@ func handleLogin(c *gin.Context) {
 			"id":    user.ID,
 			"email": user.Email,
 		},
+		"password_reset_required": resetRequired,
 	})
 }
 
-// handleGenerateInvoice handles the generation of a new invoice
+// handleGenerateInvoice handles the generation of a new invoice.
+// Pass ?status=draft to save editable drafts instead of finalized invoices.
 func handleGenerateInvoice(c *gin.Context) {
-	userID := c.GetInt("userID")
+	status := c.DefaultQuery("status", models.InvoiceStatusFinalized)
+	if !isValidCreateStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be draft or finalized"})
+		return
+	}
 
 	// Parse invoice data
 	var invoices []models.EInvoice
@@ -492,56 +1036,16 @@ func handleGenerateInvoice(c *gin.Context) {
 		return
 	}
 
-	if len(invoices) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No invoice data provided"})
+	// The account owner may create invoices dated in a locked period, giving a reason
+	caller, ok := callerWithOverride(c)
+	if !ok {
 		return
 	}
 
-	results := make([]gin.H, 0, len(invoices))
-
-	// Process each invoice
-	for _, invoice := range invoices {
-		// Validate invoice data
-		if err := invoice.Validate(); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-
-		// Calculate totals
-		invoice.CalculateTotals()
-
-		// Create QR code (invoice_no + TotInvVal)
-		qrContent := fmt.Sprintf("%s:%.2f", invoice.DocDtls.No, invoice.ValDtls.TotInvVal)
-		qrCode, err := qrcode.Encode(qrContent, qrcode.Medium, 256)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
-			return
-		}
-
-		// Convert invoice to JSON
-		invoiceJSON, err := json.Marshal(invoice)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
-			return
-		}
-
-		// Store in database
-		var invoiceID int
-		err = dbPool.QueryRow(context.Background(),
-			`INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, qr_code, created_at)
-			VALUES ($1, $2, $3, $4, $5, NOW())
-			RETURNING id`,
-			userID, invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoiceJSON, qrCode).Scan(&invoiceID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice"})
-			return
-		}
-
-		results = append(results, gin.H{
-			"id":         invoiceID,
-			"invoice_no": invoice.DocDtls.No,
-			"qr_url":     fmt.Sprintf("/api/qr/%d", invoiceID),
-		})
+	results, err := generateInvoices(c.Request.Context(), caller, invoices, status)
+	if err != nil {
+		respondImportError(c, err)
+		return
 	}
 
 	c.JSON(http.StatusCreated, gin.H{
@@ -550,158 +1054,284 @@ func handleGenerateInvoice(c *gin.Context) {
 	})
 }
 
-// handleUploadExcel handles the upload and processing of an Excel file
+// handleUploadExcel handles the upload and processing of an Excel file. The file
+// is kept with an import record so it can be downloaded or re-imported later.
 func handleUploadExcel(c *gin.Context) {
 	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
 
-	// Get uploaded file
-	file, err := c.FormFile("file")
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+	// Read the uploaded file into memory within the upload size limit
+	file, data, ok := readUploadedFile(c, "file")
+	if !ok {
 		return
 	}
 
 	// Check file type
-	if file.Header.Get("Content-Type") != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
+	contentType := file.Header.Get("Content-Type")
+	if contentType != "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Only Excel files (.xlsx) are supported"})
 		return
 	}
 
-	// Open file
-	src, err := file.Open()
+	// Use the requested column mapping profile, or the default layout
+	mapping, err := getExcelMapping(ctx, userID, c.DefaultPostForm("mapping_id", c.Query("mapping_id")))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Mapping not found or not authorized"})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load mapping"})
 		return
 	}
-	defer src.Close()
 
-	// Create temp file
-	tempFile, err := os.CreateTemp("", "upload-*.xlsx")
+	// Keep the file as uploaded before importing it
+	importID, err := saveImport(ctx, userID, models.ImportKindExcel, file.Filename, contentType, data, mapping)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temp file"})
+		log.Printf("Error storing uploaded file: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store uploaded file"})
 		return
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	// Copy to temp file
-	fileBytes := make([]byte, file.Size)
-	if _, err = src.Read(fileBytes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+	result, err := importExcel(ctx, userID, data, mapping)
+	if err != nil {
+		message := "Failed to import invoices"
+		var ie *importError
+		if errors.As(err, &ie) {
+			message = ie.message
+		}
+		finishImport(ctx, importID, 0, 0, &importOutcome{Invoices: []gin.H{}, Error: message})
+		respondImportError(c, err)
 		return
 	}
-	if _, err = tempFile.Write(fileBytes); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write temp file"})
+	finishImport(ctx, importID, len(result.results), len(result.report.failed), result.outcome())
+
+	results := result.results
+	report := result.report
+	rowErrors := report.sorted()
+	summary := result.summary()
+
+	// Optionally return the uploaded sheet with failed rows highlighted
+	if len(rowErrors) > 0 && c.Query("error_report") == "xlsx" {
+		wb, err := report.errorWorkbook(result.rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build error report"})
+			return
+		}
+		defer wb.Close()
+
+		c.Header("Content-Disposition", "attachment; filename=upload-errors.xlsx")
+		c.Header("X-Import-ID", strconv.Itoa(importID))
+		c.Header("X-Imported-Invoices", strconv.Itoa(len(results)))
+		c.Header("X-Failed-Rows", strconv.Itoa(len(report.failed)))
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if err := wb.Write(c.Writer); err != nil {
+			log.Printf("Error writing upload error report: %v", err)
+		}
 		return
 	}
 
-	// Open Excel file
-	xlsx, err := excelize.OpenFile(tempFile.Name())
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse Excel file"})
+	if len(results) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":     "No invoices were imported; see errors for details",
+			"errors":    rowErrors,
+			"summary":   summary,
+			"import_id": importID,
+		})
 		return
 	}
+
+	message := "Invoices imported successfully"
+	if len(rowErrors) > 0 {
+		message = "Invoices imported with errors"
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   message,
+		"invoices":  results,
+		"errors":    rowErrors,
+		"summary":   summary,
+		"import_id": importID,
+	})
+}
+
+// excelImport is the result of importing a spreadsheet
+type excelImport struct {
+	results  []gin.H
+	report   *uploadReport
+	rows     [][]string
+	invoices int
+}
+
+// summary counts the imported and failed invoices and the failed rows
+func (r *excelImport) summary() gin.H {
+	return gin.H{
+		"imported_invoices": len(r.results),
+		"failed_invoices":   r.invoices - len(r.results),
+		"failed_rows":       len(r.report.failed),
+	}
+}
+
+// outcome returns the result to keep on the import record
+func (r *excelImport) outcome() *importOutcome {
+	return &importOutcome{Invoices: r.results, Errors: r.report.sorted(), Summary: r.summary()}
+}
+
+// importExcel reads invoices from a workbook using a column mapping and stores
+// every invoice whose rows are all valid, in a single transaction. Problems
+// with individual rows are collected in the report; problems with the file as
+// a whole, or a store failure that rolled back the batch, are returned as an
+// importError.
+func importExcel(ctx context.Context, userID int, data []byte, mapping *models.ExcelMapping) (*excelImport, error) {
+	// Parse the workbook straight from memory
+	xlsx, err := excelize.OpenReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, newImportError(http.StatusBadRequest, "Failed to parse Excel file")
+	}
 	defer xlsx.Close()
 
 	// Get sheet names
 	sheets := xlsx.GetSheetList()
 	if len(sheets) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No sheets found in Excel file"})
-		return
+		return nil, newImportError(http.StatusBadRequest, "No sheets found in Excel file")
+	}
+	sheet := sheets[0]
+	if mapping.Sheet != "" {
+		if idx, err := xlsx.GetSheetIndex(mapping.Sheet); err != nil || idx < 0 {
+			return nil, newImportError(http.StatusBadRequest, "Sheet %q not found in Excel file", mapping.Sheet)
+		}
+		sheet = mapping.Sheet
 	}
 
 	// Group items by invoice number
 	invoiceMap := make(map[string]*models.EInvoice)
 	itemMap := make(map[string][]models.Item)
 
-	// Read rows from the first sheet
-	rows, err := xlsx.GetRows(sheets[0])
+	// Read rows from the selected sheet
+	rows, err := xlsx.GetRows(sheet)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read sheet"})
-		return
+		return nil, fmt.Errorf("failed to read sheet: %w", err)
 	}
 
-	// Skip header row
-	if len(rows) < 2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Excel file does not contain enough data"})
-		return
+	// Skip rows up to and including the header row
+	headerIdx := mapping.HeaderRow - 1
+	if len(rows) < headerIdx+2 {
+		return nil, newImportError(http.StatusBadRequest, "Excel file does not contain enough data")
+	}
+
+	columns, err := resolveExcelColumns(mapping, rows[headerIdx])
+	if err != nil {
+		return nil, newImportError(http.StatusBadRequest, "Mapping does not match the file: %v", err)
+	}
+
+	// Collect row-level errors instead of aborting on the first bad row
+	report := newUploadReport(rows[headerIdx], mapping.HeaderRow)
+	settings := getUserSettings(ctx, userID)
+	defaultCompany := getDefaultCompany(ctx, userID, settings)
+	rateSchedule, err := loadGSTRateSchedule(ctx)
+	if err != nil {
+		log.Printf("Error loading GST rate master: %v", err)
+		rateSchedule = &gstRateSchedule{}
 	}
+	invoiceRows := make(map[string][]int)
+	invoiceOrder := make([]string, 0)
+	failedInvoices := make(map[string]bool)
 
 	// Process data rows
-	for i, row := range rows {
-		if i == 0 {
-			// Header row, skip
+	for i, cells := range rows {
+		if i <= headerIdx {
+			// Header and preamble rows, skip
 			continue
 		}
-
-		if len(row) < 12 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Row %d does not have enough columns", i+1)})
-			return
+		rowNum := i + 1
+		if isBlankRow(cells) {
+			continue
 		}
+		row := excelRow{cells: cells, columns: columns}
 
 		// Parse row data
-		invoiceNo := row[1]
-		qty, _ := strconv.ParseFloat(row[7], 64)
-		unitPrice, _ := strconv.ParseFloat(row[9], 64)
-		gstRate, _ := strconv.ParseFloat(row[10], 64)
-
-		// Create or get invoice
-		invoice, exists := invoiceMap[invoiceNo]
-		if !exists {
-			invoice = &models.EInvoice{
-				Version: "1.1",
-				TranDtls: models.TranDtls{
-					TaxSch: "GST",
-					SupTyp: "EXPWP",
-					RegRev: "N",
-				},
-				DocDtls: models.DocDtls{
-					Typ: "INV",
-					No:  invoiceNo,
-					Dt:  row[2], // Invoice date
-				},
-				SellerDtls: models.SellerDtls{
-					Gstin: row[0], // Seller GSTIN
-					LglNm: "SELLER COMPANY NAME",
-					TrdNm: "SELLER TRADE NAME",
-					Addr1: "SELLER ADDRESS LINE 1",
-					Addr2: "SELLER ADDRESS LINE 2",
-					Loc:   "SELLER CITY",
-					Pin:   110001,
-					Stcd:  "07", // Delhi
-				},
-				BuyerDtls: models.BuyerDtls{
-					Gstin: row[3], // Buyer GSTIN
-					LglNm: row[4], // Buyer legal name
-					TrdNm: row[4], // Buyer trade name
-					Pos:   "96",   // Place of supply
-					Addr1: row[5], // Buyer address
-					Addr2: "",
-					Loc:   row[6], // Buyer location
-					Pin:   999999,
-					Stcd:  "96", // Outside India
-				},
-				ExpDtls: models.ExpDtls{
-					ForCur:  nil,
-					CntCode: nil,
-				},
+		invoiceNo := row.get("invoice_no")
+		if invoiceNo == "" {
+			report.add(rowNum, "", row.column("invoice_no"), "invoice number is required")
+			continue
+		}
+		_, seen := invoiceRows[invoiceNo]
+		if !seen {
+			invoiceOrder = append(invoiceOrder, invoiceNo)
+		}
+		invoiceRows[invoiceNo] = append(invoiceRows[invoiceNo], rowNum)
+
+		// Invoice-level fields are only read from the first row of each invoice
+		rowOK := true
+		for _, f := range models.ExcelUploadFields {
+			if f.InvoiceLevel && seen {
+				continue
+			}
+			// Rows without a seller GSTIN are issued from the default company
+			if f.Name == "seller_gstin" && defaultCompany != nil {
+				continue
+			}
+			if f.Required && row.get(f.Name) == "" && !isNumericUploadField(f.Name) {
+				report.add(rowNum, invoiceNo, row.column(f.Name), "value is required")
+				rowOK = false
+			}
+		}
+		qty, okQty := report.parseNumber(cells, rowNum, invoiceNo, row.column("quantity"))
+		unitPrice, okPrice := report.parseNumber(cells, rowNum, invoiceNo, row.column("unit_price"))
+		// A blank GST rate takes the rate in force for the HSN code on the invoice date
+		var gstRate float64
+		okRate := true
+		rateBlank := row.get("gst_rate") == "" && row.get("hsn_code") != ""
+		if !rateBlank {
+			gstRate, okRate = report.parseNumber(cells, rowNum, invoiceNo, row.column("gst_rate"))
+		}
+
+		var invoice *models.EInvoice
+		if !seen && rowOK {
+			if invoice = uploadInvoiceHeader(ctx, report, row, rowNum, userID, settings, defaultCompany); invoice == nil {
+				rowOK = false
+			}
+		}
+		if rateBlank && rowOK {
+			header := invoice
+			if header == nil {
+				header = invoiceMap[invoiceNo]
+			}
+			if header != nil {
+				gstRate, okRate = uploadGSTRate(report, rateSchedule, row, rowNum, invoiceNo, header)
 			}
+		}
+
+		if !rowOK || !okQty || !okPrice || !okRate {
+			failedInvoices[invoiceNo] = true
+			continue
+		}
+		if invoice != nil {
 			invoiceMap[invoiceNo] = invoice
 			itemMap[invoiceNo] = []models.Item{}
 		}
 
-		// Create item
+		isService := "N"
+		if strings.EqualFold(row.get("is_service"), "Y") {
+			isService = "Y"
+		}
+
+		// Create item, numbering it by position unless the sheet gives an item number
+		slNo := row.get("item_no")
+		if slNo == "" {
+			slNo = strconv.Itoa(len(itemMap[invoiceNo]) + 1)
+		}
 		item := models.Item{
-			SlNo:       strconv.Itoa(len(itemMap[invoiceNo]) + 1),
-			PrdDesc:    row[5], // Product description
-			IsServc:    "N",
-			HsnCd:      row[6], // HSN code
+			SlNo:       slNo,
+			PrdDesc:    row.get("item_description"),
+			IsServc:    isService,
+			HsnCd:      row.get("hsn_code"),
 			Qty:        qty,
-			Unit:       row[8], // Unit
+			Unit:       row.get("unit"),
 			UnitPrice:  unitPrice,
 			TotAmt:     qty * unitPrice,
 			AssAmt:     qty * unitPrice,
 			GstRt:      gstRate,
+			Exemption:  strings.ToUpper(row.get("exemption")),
 			IgstAmt:    (qty * unitPrice) * gstRate / 100,
 			TotItemVal: (qty * unitPrice) + ((qty * unitPrice) * gstRate / 100),
 		}
@@ -710,71 +1340,151 @@ func handleUploadExcel(c *gin.Context) {
 		itemMap[invoiceNo] = append(itemMap[invoiceNo], item)
 	}
 
-	// Process all invoices
-	results := make([]gin.H, 0, len(invoiceMap))
-	for invoiceNo, invoice := range invoiceMap {
+	// Process all invoices in the order they appear in the sheet; an invoice
+	// with any bad row is skipped entirely so no line items are silently dropped.
+	// The invoices are stored in one transaction, so a database failure midway
+	// leaves none of them imported rather than part of the file.
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Invoices dated in a locked period cannot be imported
+	locks, err := getPeriodLocks(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Valid invoices are collected and stored together in batches
+	pending := make([]importedInvoice, 0, len(invoiceMap))
+	pendingWarnings := make([][]string, 0, len(invoiceMap))
+	precisions := make(map[string]models.Precision)
+	for _, invoiceNo := range invoiceOrder {
+		invoiceRowNums := invoiceRows[invoiceNo]
+		if failedInvoices[invoiceNo] {
+			for _, rowNum := range invoiceRowNums {
+				if !report.failed[rowNum] {
+					report.add(rowNum, invoiceNo, -1, "not imported because other rows of this invoice have errors")
+				}
+			}
+			continue
+		}
+		invoice := invoiceMap[invoiceNo]
+		if period := locks.find(invoiceDocDate(invoice)); period != "" {
+			report.addInvoice(invoiceRowNums, invoiceNo, lockedPeriodError(period))
+			continue
+		}
+
 		// Add items to invoice
 		invoice.ItemList = itemMap[invoiceNo]
 
-		// Calculate totals
-		invoice.CalculateTotals()
+		// Calculate totals, looking up each seller's precision once per file
+		precision, ok := precisions[invoice.SellerDtls.Gstin]
+		if !ok {
+			precision = getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+			precisions[invoice.SellerDtls.Gstin] = precision
+		}
+		invoice.CalculateTotalsWithPrecision(precision)
 
-		// Validate invoice
+		// Validate invoice, recording the company's LUT on supplies without payment of tax
+		applyCompanyLUT(ctx, userID, invoice)
 		if err := invoice.Validate(); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invoice %s: %s", invoiceNo, err.Error())})
-			return
+			report.addInvoice(invoiceRowNums, invoiceNo, "validation failed: "+err.Error())
+			continue
 		}
 
-		// Create QR code
-		qrContent := fmt.Sprintf("%s:%.2f", invoice.DocDtls.No, invoice.ValDtls.TotInvVal)
-		qrCode, err := qrcode.Encode(qrContent, qrcode.Medium, 256)
+		// Check state codes and HSN codes against the masters
+		warnings, err := checkMasterData(ctx, invoice)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
-			return
+			report.addInvoice(invoiceRowNums, invoiceNo, err.Error())
+			continue
 		}
 
 		// Convert invoice to JSON
 		invoiceJSON, err := json.Marshal(invoice)
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
-			return
+			report.addInvoice(invoiceRowNums, invoiceNo, "failed to serialize invoice")
+			continue
 		}
 
-		// Store in database
-		var invoiceID int
-		err = dbPool.QueryRow(context.Background(),
-			`INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, qr_code, created_at)
-			VALUES ($1, $2, $3, $4, $5, NOW())
-			ON CONFLICT (invoice_no) DO UPDATE
-			SET invoice_json = $4, qr_code = $5
-			RETURNING id`,
-			userID, invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoiceJSON, qrCode).Scan(&invoiceID)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to store invoice %s: %s", invoiceNo, err.Error())})
-			return
+		pending = append(pending, importedInvoice{invoice: invoice, invoiceJSON: invoiceJSON})
+		pendingWarnings = append(pendingWarnings, warnings)
+	}
+
+	// Store in database
+	ids, err := storeImportedInvoices(ctx, tx, userID, pending)
+	if err != nil {
+		// The transaction is aborted, so the whole file is rolled back
+		var bse *bulkStoreError
+		if errors.As(err, &bse) && bse.index >= 0 {
+			invoiceNo := pending[bse.index].invoice.DocDtls.No
+			log.Printf("Error storing uploaded invoice %s: %v", invoiceNo, err)
+			return nil, &importError{
+				status:  http.StatusInternalServerError,
+				message: fmt.Sprintf("Failed to store invoice %s; no invoices were imported", invoiceNo),
+				failed:  gin.H{"invoice_no": invoiceNo, "rows": invoiceRows[invoiceNo]},
+			}
 		}
+		log.Printf("Error storing uploaded invoices: %v", err)
+		return nil, newImportError(http.StatusInternalServerError, "Failed to store invoices; no invoices were imported")
+	}
 
+	results := make([]gin.H, 0, len(pending))
+	for i, imp := range pending {
+		invoiceNo := imp.invoice.DocDtls.No
+		if ids[i] == 0 {
+			report.addInvoice(invoiceRows[invoiceNo], invoiceNo, "invoice already exists and is not a draft")
+			continue
+		}
 		results = append(results, gin.H{
-			"id":         invoiceID,
-			"invoice_no": invoice.DocDtls.No,
-			"qr_url":     fmt.Sprintf("/api/qr/%d", invoiceID),
+			"id":         ids[i],
+			"invoice_no": invoiceNo,
+			"qr_url":     qrURL(ids[i], invoiceQRHash(imp.invoice)),
+			"warnings":   pendingWarnings[i],
 		})
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message":  "Invoices imported successfully",
-		"invoices": results,
-	})
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	return &excelImport{
+		results:  results,
+		report:   report,
+		rows:     rows,
+		invoices: len(invoiceOrder),
+	}, nil
 }
 
-// handleExportInvoices exports user's invoices to Excel
+// handleExportInvoices exports user's invoices to Excel, or with format=nic
+// in the layout of the portal's bulk generation offline utility
 func handleExportInvoices(c *gin.Context) {
 	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch c.DefaultQuery("format", "xlsx") {
+	case "xlsx":
+	case "nic":
+		exportNICBulkWorkbook(c, userID, filter)
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be xlsx or nic"})
+		return
+	}
 
-	// Fetch user's invoices
-	rows, err := dbPool.Query(context.Background(),
-		"SELECT invoice_json FROM invoices WHERE user_id = $1 ORDER BY created_at DESC",
-		userID)
+	// Fetch user's invoices matching the filters
+	rows, err := dbPool.Query(ctx,
+		`SELECT invoice_json, status, sandbox, `+invoiceTagListSQL+` FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status NOT IN ('draft', 'pending_approval') AND (NOT sandbox OR $2) AND `+exportFilterSQL+`
+		ORDER BY created_at DESC`,
+		append([]interface{}{userID, includeSandbox(c)}, filter.args()...)...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
 		return
@@ -782,25 +1492,14 @@ func handleExportInvoices(c *gin.Context) {
 	defer rows.Close()
 
 	// Create Excel file
-	f := excelize.NewFile()
-	defer f.Close()
-
-	// Set headers
-	headers := []string{
-		"GSTIN", "Invoice No", "Invoice Date", "Buyer GSTIN", "Buyer Name",
-		"Item Description", "HSN Code", "Quantity", "Unit", "Unit Price",
-		"GST Rate", "IGST Amount", "Total Amount",
-	}
-	for i, header := range headers {
-		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
-		f.SetCellValue("Sheet1", cell, header)
-	}
+	wb := newInvoiceWorkbook(ctx, userID)
+	defer wb.file.Close()
 
-	// Process invoices
-	rowIndex := 2
 	for rows.Next() {
 		var invoiceJSON []byte
-		if err := rows.Scan(&invoiceJSON); err != nil {
+		var status, tags string
+		var sandbox bool
+		if err := rows.Scan(&invoiceJSON, &status, &sandbox, &tags); err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
 			return
 		}
@@ -809,73 +1508,73 @@ func handleExportInvoices(c *gin.Context) {
 		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
 			continue
 		}
-
-		// Add invoice items to Excel
-		for _, item := range invoice.ItemList {
-			f.SetCellValue("Sheet1", fmt.Sprintf("A%d", rowIndex), invoice.SellerDtls.Gstin)
-			f.SetCellValue("Sheet1", fmt.Sprintf("B%d", rowIndex), invoice.DocDtls.No)
-			f.SetCellValue("Sheet1", fmt.Sprintf("C%d", rowIndex), invoice.DocDtls.Dt)
-			f.SetCellValue("Sheet1", fmt.Sprintf("D%d", rowIndex), invoice.BuyerDtls.Gstin)
-			f.SetCellValue("Sheet1", fmt.Sprintf("E%d", rowIndex), invoice.BuyerDtls.LglNm)
-			f.SetCellValue("Sheet1", fmt.Sprintf("F%d", rowIndex), item.PrdDesc)
-			f.SetCellValue("Sheet1", fmt.Sprintf("G%d", rowIndex), item.HsnCd)
-			f.SetCellValue("Sheet1", fmt.Sprintf("H%d", rowIndex), item.Qty)
-			f.SetCellValue("Sheet1", fmt.Sprintf("I%d", rowIndex), item.Unit)
-			f.SetCellValue("Sheet1", fmt.Sprintf("J%d", rowIndex), item.UnitPrice)
-			f.SetCellValue("Sheet1", fmt.Sprintf("K%d", rowIndex), item.GstRt)
-			f.SetCellValue("Sheet1", fmt.Sprintf("L%d", rowIndex), item.IgstAmt)
-			f.SetCellValue("Sheet1", fmt.Sprintf("M%d", rowIndex), item.TotItemVal)
-			rowIndex++
+		if !filter.matchesDate(invoice.DocDtls.Dt) {
+			continue
 		}
-	}
 
-	// Generate temporary file
-	tempFile, err := os.CreateTemp("", "invoices-*.xlsx")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temporary file"})
+		wb.add(&invoice, status, sandbox, tags)
+	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
 		return
 	}
-	defer os.Remove(tempFile.Name())
 
-	// Save Excel file
-	if err := f.SaveAs(tempFile.Name()); err != nil {
+	// Generate the workbook in memory, so unchanged exports can be answered
+	// with 304 Not Modified
+	buf, err := wb.file.WriteToBuffer()
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate Excel file"})
 		return
 	}
 
 	// Serve file
-	c.FileAttachment(tempFile.Name(), "invoices.xlsx")
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filter.filename("invoices", "xlsx")))
+	serveConditional(c, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", time.Time{}, buf.Bytes())
 }
 
-// handleGetInvoices returns all invoices for the authenticated user
+// handleGetInvoices returns the authenticated user's invoices, a page at a
+// time when a limit is given
 func handleGetInvoices(c *gin.Context) {
 	userID := c.GetInt("userID")
 
-	// First check if the user has any invoices
-	var count int
-	err := dbPool.QueryRow(context.Background(), 
-		"SELECT COUNT(*) FROM invoices WHERE user_id = $1", 
-		userID).Scan(&count)
-	
+	paymentStatus, ok := parsePaymentStatusFilter(c)
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "payment_status must be unpaid, partial or paid"})
+		return
+	}
+	filter, err := parseExportFilter(c)
 	if err != nil {
-		log.Printf("Error checking invoice count: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	fields, err := parseJSONFilters(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// If no invoices, return empty array
-	if count == 0 {
-		c.JSON(http.StatusOK, gin.H{
-			"invoices": []gin.H{},
-		})
+	page, message := parsePageRequest(c, invoiceSortFields, "-created_at")
+	if page == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
 		return
 	}
 
-	// Fetch invoices
-	rows, err := dbPool.Query(context.Background(),
-		`SELECT id, invoice_no, seller_gstin, created_at, invoice_json, exported, exported_at
-		FROM invoices WHERE user_id = $1 ORDER BY created_at DESC`,
-		userID)
+	// Fetch invoices; the list reads the aggregate columns rather than
+	// decoding each invoice's JSON
+	args := append([]interface{}{userID, paymentStatus}, filter.args()...)
+	rows, err := dbPool.Query(c.Request.Context(),
+		`SELECT id, invoice_no, seller_gstin, created_at, exported, exported_at, quarantined, status,
+			COALESCE(irn, ''), COALESCE(irp_error_message, ''), cancelled_at, `+qrHashSQL+`, sandbox, `+paidAmountSQL+`,
+			total_value::float8, taxable_value::float8, tax_amount::float8, buyer_gstin, invoice_date, gstr1_section,
+			`+payableSQL+`, COALESCE(invoice_json->'TranDtls'->>'RegRev', '') = 'Y',
+			COALESCE(invoice_json->'BuyerDtls'->>'LglNm', ''), COALESCE(invoice_json->'RefDtls'->>'InvRm', ''),
+			COALESCE((SELECT ct->>'PORefr' FROM jsonb_array_elements(
+				CASE WHEN jsonb_typeof(invoice_json->'RefDtls'->'ContrDtls') = 'array'
+				THEN invoice_json->'RefDtls'->'ContrDtls' ELSE '[]'::jsonb END) ct
+				WHERE COALESCE(ct->>'PORefr', '') <> '' LIMIT 1), ''),
+			`+invoiceTagNamesSQL+`, `+page.keySQL("")+`
+		FROM invoices WHERE user_id = $1 AND ($2 = '' OR `+paymentStatusSQL+` = $2) AND `+exportFilterSQL+
+			fields.sql("", &args)+page.whereSQL("", &args)+page.orderSQL(""),
+		args...)
 	if err != nil {
 		log.Printf("Error fetching invoices: %v", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
@@ -888,54 +1587,81 @@ func handleGetInvoices(c *gin.Context) {
 		var id int
 		var invoiceNo, sellerGSTIN string
 		var createdAt time.Time
-		var invoiceJSON []byte
 		var exported bool
 		var exportedAt *time.Time
-
-		if err := rows.Scan(&id, &invoiceNo, &sellerGSTIN, &createdAt, &invoiceJSON, &exported, &exportedAt); err != nil {
+		var quarantined bool
+		var status, irn, irpError, qrVersion string
+		var cancelledAt *time.Time
+		var sandbox bool
+		var paid, totalValue, taxableValue, taxAmount, payable float64
+		var reverseCharge bool
+		var buyerGSTIN, buyerName, remarks, poNumber, gstr1Section string
+		var invoiceDate time.Time
+		var tags []string
+		var pageKey string
+
+		if err := rows.Scan(&id, &invoiceNo, &sellerGSTIN, &createdAt, &exported, &exportedAt, &quarantined, &status,
+			&irn, &irpError, &cancelledAt, &qrVersion, &sandbox, &paid,
+			&totalValue, &taxableValue, &taxAmount, &buyerGSTIN, &invoiceDate, &gstr1Section,
+			&payable, &reverseCharge, &buyerName, &remarks, &poNumber, &tags, &pageKey); err != nil {
 			log.Printf("Error scanning invoice row: %v", err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
 			return
 		}
+		if !page.next(pageKey, int64(id)) {
+			break
+		}
 
-		// Create basic invoice data without unmarshaling JSON
+		payment := models.NewPaymentSummary(payable, paid)
 		invoiceMap := gin.H{
-			"id":           id,
-			"invoice_no":   invoiceNo,
-			"seller_gstin": sellerGSTIN,
-			"created_at":   createdAt,
-			"qr_url":       fmt.Sprintf("/api/qr/%d", id),
-			"exported":     exported,
+			"id":             id,
+			"invoice_no":     invoiceNo,
+			"seller_gstin":   sellerGSTIN,
+			"buyer_gstin":    buyerGSTIN,
+			"buyer_name":     buyerName,
+			"date":           invoiceDate.Format(invoiceDateLayout),
+			"taxable_value":  taxableValue,
+			"tax_amount":     taxAmount,
+			"total_value":    totalValue,
+			"reverse_charge": reverseCharge,
+			"gstr1_section":  gstr1Section,
+			"remarks":        remarks,
+			"po_number":      poNumber,
+			"amount_paid":    payment.Paid,
+			"outstanding":    payment.Outstanding,
+			"payment_status": payment.Status,
+			"created_at":     createdAt,
+			"qr_url":         qrURL(id, qrVersion),
+			"exported":       exported,
+			"quarantined":    quarantined,
+			"status":         status,
+			"cancelled":      status == models.InvoiceStatusCancelled,
+			"sandbox":        sandbox,
+			"tags":           tags,
 		}
 		
 		if exportedAt != nil {
 			invoiceMap["exported_at"] = *exportedAt
 		}
-
-		// Try to extract buyer and invoice details from JSON
-		var invoiceData models.EInvoice
-		if err := json.Unmarshal(invoiceJSON, &invoiceData); err != nil {
-			log.Printf("Error unmarshaling invoice JSON: %v", err)
-			// Add placeholder values for fields that would come from JSON
-			invoiceMap["buyer_name"] = "Unknown"
-			invoiceMap["date"] = ""
-			invoiceMap["total_value"] = 0
-		} else {
-			// Successfully parsed JSON, add the data
-			invoiceMap["buyer_name"] = invoiceData.BuyerDtls.LglNm
-			invoiceMap["date"] = invoiceData.DocDtls.Dt
-			invoiceMap["total_value"] = invoiceData.ValDtls.TotInvVal
+		if irn != "" {
+			invoiceMap["irn"] = irn
 		}
-		
+		if irpError != "" {
+			invoiceMap["irp_error"] = irpError
+		}
+		if cancelledAt != nil {
+			invoiceMap["cancelled_at"] = *cancelledAt
+		}
+
 		invoices = append(invoices, invoiceMap)
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	c.JSON(http.StatusOK, page.respond(gin.H{
 		"invoices": invoices,
-	})
+	}))
 }
 
-// handleGetQRCode returns the QR code for a specific invoice
+// handleGetQRCode returns the e-invoice or UPI QR code for a specific invoice
 func handleGetQRCode(c *gin.Context) {
 	userID := c.GetInt("userID")
 	idStr := c.Param("id")
@@ -946,168 +1672,236 @@ func handleGetQRCode(c *gin.Context) {
 		return
 	}
 
-	// Fetch QR code
-	var qrCode []byte
-	err = dbPool.QueryRow(context.Background(),
-		"SELECT qr_code FROM invoices WHERE id = $1 AND user_id = $2",
-		id, userID).Scan(&qrCode)
+	// type=upi returns the payment QR of B2C invoices instead of the e-invoice QR
+	switch c.DefaultQuery("type", "einvoice") {
+	case "einvoice":
+	case "upi":
+		serveUPIQRCode(c, id, userID)
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "type must be einvoice or upi"})
+		return
+	}
+
+	// The QR code is generated from the stored number and total, so it
+	// always matches the invoice as last saved
+	var invoiceNo string
+	var total float64
+	var updatedAt time.Time
+	err = dbPool.QueryRow(c.Request.Context(),
+		"SELECT invoice_no, total_value, updated_at FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceNo, &total, &updatedAt)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "QR code not found"})
 		return
 	}
+	content := invoiceQRContent(invoiceNo, total)
+	qrCode, err := qrCache.get(content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
 
-	// Set content type and serve QR code
-	c.Data(http.StatusOK, "image/png", qrCode)
+	// URLs carrying the current content hash never change, so they can be
+	// cached for good; anything else must be revalidated with the ETag
+	hash := qrHash(content)
+	c.Header("ETag", `"`+hash+`"`)
+	if c.Query("v") == hash {
+		c.Header("Cache-Control", "private, max-age=31536000, immutable")
+	} else {
+		c.Header("Cache-Control", "private, no-cache")
+	}
+	c.Header("Content-Type", "image/png")
+
+	// ServeContent answers If-None-Match / If-Modified-Since with 304
+	http.ServeContent(c.Writer, c.Request, "", updatedAt, bytes.NewReader(qrCode))
 }
 
-// handleImportJSON imports an Indian GST-compliant JSON invoice
+// handleImportJSON imports Indian GST-compliant JSON invoices, either a single
+// invoice or an array. The request body is kept with an import record so it can be downloaded or
+// re-imported later.
 func handleImportJSON(c *gin.Context) {
 	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
 
-	// Try parsing as a single invoice first
-	var singleInvoice models.EInvoice
-	if err := c.ShouldBindJSON(&singleInvoice); err != nil {
-		// If that fails, try parsing as an array
-		var invoiceArray []models.EInvoice
-		if err := c.BindJSON(&invoiceArray); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
-			return
-		}
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
 
-		// Check if array is empty
-		if len(invoiceArray) == 0 {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "No invoice data provided"})
-			return
+	// Keep the body as uploaded before importing it
+	importID, err := saveImport(ctx, userID, models.ImportKindJSON, "import.json", "application/json", body, nil)
+	if err != nil {
+		log.Printf("Error storing uploaded JSON: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store uploaded file"})
+		return
+	}
+
+	results, single, err := importJSONInvoices(ctx, userID, body)
+	outcome := &importOutcome{Invoices: results}
+	if err != nil {
+		outcome.Error = "Failed to import invoices"
+		var ie *importError
+		if errors.As(err, &ie) {
+			outcome.Error = ie.message
+			outcome.Violations = ie.violations
 		}
+		finishImport(ctx, importID, len(results), 0, outcome)
+		respondImportError(c, err)
+		return
+	}
+	finishImport(ctx, importID, len(results), 0, outcome)
 
-		// Process all invoices in the array
-		results := make([]gin.H, 0, len(invoiceArray))
-		for _, invoice := range invoiceArray {
-			// Validate invoice data
-			if err := invoice.Validate(); err != nil {
-				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
-				return
-			}
+	if single {
+		c.JSON(http.StatusCreated, gin.H{
+			"message":   "Invoice imported successfully",
+			"invoice":   results[0],
+			"import_id": importID,
+		})
+		return
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message":   fmt.Sprintf("%d invoice(s) imported successfully", len(results)),
+		"invoices":  results,
+		"import_id": importID,
+	})
+}
 
-			// Calculate totals
-			invoice.CalculateTotals()
+// importJSONInvoices imports a single invoice or an array of invoices in one
+// transaction, so either all are imported or, at the first invalid one, none
+// are. It returns the invoices imported and whether the body held a single invoice.
+func importJSONInvoices(ctx context.Context, userID int, body []byte) ([]gin.H, bool, error) {
+	results := make([]gin.H, 0)
 
-			// Create QR code
-			qrContent := fmt.Sprintf("%s:%.2f", invoice.DocDtls.No, invoice.ValDtls.TotInvVal)
-			qrCode, err := qrcode.Encode(qrContent, qrcode.Medium, 256)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
-				return
-			}
+	// Check the payload against the e-invoice schema, reporting every violation at once
+	violations, err := validateInvoiceJSON(body)
+	if err != nil {
+		return results, false, newImportError(http.StatusBadRequest, "Invalid JSON format: %v", err)
+	}
+	if len(violations) > 0 {
+		return results, false, &importError{
+			status:     http.StatusBadRequest,
+			message:    "Invoice JSON does not match the e-invoice schema",
+			violations: violations,
+		}
+	}
 
-			// Serialize the invoice
-			invoiceJSON, err := json.Marshal(invoice)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
-				return
-			}
+	// Try parsing as a single invoice first
+	var invoices []models.EInvoice
+	var singleInvoice models.EInvoice
+	single := json.Unmarshal(body, &singleInvoice) == nil
+	if single {
+		invoices = []models.EInvoice{singleInvoice}
+	} else if err := json.Unmarshal(body, &invoices); err != nil {
+		// If that fails, try parsing as an array
+		return results, false, newImportError(http.StatusBadRequest, "Invalid JSON format: %v", err)
+	}
 
-			// Store in database
-			var invoiceID int
-			err = dbPool.QueryRow(context.Background(),
-				`INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, qr_code, created_at)
-				VALUES ($1, $2, $3, $4, $5, NOW())
-				ON CONFLICT (invoice_no) DO UPDATE
-				SET invoice_json = $4, qr_code = $5
-				RETURNING id`,
-				userID, invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoiceJSON, qrCode).Scan(&invoiceID)
-			
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice: " + err.Error()})
-				return
-			}
+	// Check if array is empty
+	if len(invoices) == 0 {
+		return results, false, newImportError(http.StatusBadRequest, "No invoice data provided")
+	}
 
-			results = append(results, gin.H{
-				"id":         invoiceID,
-				"invoice_no": invoice.DocDtls.No,
-				"qr_url":     fmt.Sprintf("/api/qr/%d", invoiceID),
-			})
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return results, single, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Invoices dated in a locked period cannot be imported
+	locks, err := getPeriodLocks(ctx, userID)
+	if err != nil {
+		return results, single, err
+	}
+
+	// Valid invoices are collected and stored together in batches
+	pending := make([]importedInvoice, 0, len(invoices))
+	pendingWarnings := make([][]string, 0, len(invoices))
+	for i := range invoices {
+		if period := locks.find(invoiceDocDate(&invoices[i])); period != "" {
+			return []gin.H{}, single, withFailedInvoice(newImportError(http.StatusConflict, "%s", lockedPeriodError(period)), i, &invoices[i])
+		}
+		imp, warnings, err := prepareJSONInvoice(ctx, userID, &invoices[i])
+		if err != nil {
+			return []gin.H{}, single, withFailedInvoice(err, i, &invoices[i])
 		}
+		pending = append(pending, imp)
+		pendingWarnings = append(pendingWarnings, warnings)
+	}
 
-		c.JSON(http.StatusCreated, gin.H{
-			"message": fmt.Sprintf("%d invoice(s) imported successfully", len(invoiceArray)),
-			"invoices": results,
+	// Store in database
+	ids, err := storeImportedInvoices(ctx, tx, userID, pending)
+	if err != nil {
+		var bse *bulkStoreError
+		if errors.As(err, &bse) && bse.index >= 0 {
+			return []gin.H{}, single, withFailedInvoice(fmt.Errorf("failed to store invoice: %w", err), bse.index, &invoices[bse.index])
+		}
+		return []gin.H{}, single, fmt.Errorf("failed to store invoices: %w", err)
+	}
+	for i, imp := range pending {
+		if ids[i] == 0 {
+			return []gin.H{}, single, withFailedInvoice(
+				newImportError(http.StatusConflict, "Invoice %s already exists and is not a draft", imp.invoice.DocDtls.No),
+				i, &invoices[i])
+		}
+		results = append(results, gin.H{
+			"id":         ids[i],
+			"invoice_no": imp.invoice.DocDtls.No,
+			"qr_url":     qrURL(ids[i], invoiceQRHash(imp.invoice)),
+			"warnings":   pendingWarnings[i],
 		})
-		return
 	}
-
-	// Process single invoice
-	// Validate invoice data
-	if err := singleInvoice.Validate(); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
-		return
+	if err := tx.Commit(ctx); err != nil {
+		return []gin.H{}, single, err
 	}
+	return results, single, nil
+}
 
-	// Calculate totals
-	singleInvoice.CalculateTotals()
+// prepareJSONInvoice validates one imported invoice and builds what is stored for it,
+// returning the master data warnings it raised
+func prepareJSONInvoice(ctx context.Context, userID int, invoice *models.EInvoice) (importedInvoice, []string, error) {
+	precision := getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+	if err := resolveExchangeRate(ctx, invoice, precision); err != nil {
+		return importedInvoice{}, nil, newImportError(http.StatusBadRequest, "Invalid invoice data: %v", err)
+	}
 
-	// Create QR code
-	qrContent := fmt.Sprintf("%s:%.2f", singleInvoice.DocDtls.No, singleInvoice.ValDtls.TotInvVal)
-	qrCode, err := qrcode.Encode(qrContent, qrcode.Medium, 256)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
-		return
+	// Validate invoice data, recording the company's LUT on supplies without payment of tax
+	applyCompanyLUT(ctx, userID, invoice)
+	if err := invoice.Validate(); err != nil {
+		return importedInvoice{}, nil, newImportError(http.StatusBadRequest, "Invalid invoice data: %v", err)
 	}
 
-	// Serialize the invoice
-	invoiceJSON, err := json.Marshal(singleInvoice)
+	// Check state codes and HSN codes against the masters
+	warnings, err := checkMasterData(ctx, invoice)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
-		return
+		return importedInvoice{}, nil, newImportError(http.StatusBadRequest, "Invalid invoice data: %v", err)
 	}
 
-	// Store in database
-	var invoiceID int
-	err = dbPool.QueryRow(context.Background(),
-		`INSERT INTO invoices (user_id, seller_gstin, invoice_no, invoice_json, qr_code, created_at)
-		VALUES ($1, $2, $3, $4, $5, NOW())
-		ON CONFLICT (invoice_no) DO UPDATE
-		SET invoice_json = $4, qr_code = $5
-		RETURNING id`,
-		userID, singleInvoice.SellerDtls.Gstin, singleInvoice.DocDtls.No, invoiceJSON, qrCode).Scan(&invoiceID)
-	
+	// Calculate totals
+	invoice.CalculateTotalsWithPrecision(precision)
+
+	// Serialize the invoice
+	invoiceJSON, err := json.Marshal(invoice)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice: " + err.Error()})
-		return
+		return importedInvoice{}, nil, fmt.Errorf("failed to serialize invoice: %w", err)
 	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"message": "Invoice imported successfully",
-		"invoice": gin.H{
-			"id":         invoiceID,
-			"invoice_no": singleInvoice.DocDtls.No,
-			"qr_url":     fmt.Sprintf("/api/qr/%d", invoiceID),
-		},
-	})
+	return importedInvoice{invoice: invoice, invoiceJSON: invoiceJSON}, warnings, nil
 }
 
 // validateTokenFromQuery validates a JWT token from query parameters and returns the user ID
-func validateTokenFromQuery(tokenString string) (int, error) {
+func validateTokenFromQuery(ctx context.Context, tokenString string) (int, error) {
 	// Parse the token
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		// Validate the algorithm
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		
-		// Return the secret key
-		return []byte(getJWTSecret()), nil
-	})
-	
+	claims, err := verifyToken(ctx, tokenString)
 	if err != nil {
 		return 0, err
 	}
-	
-	// Validate and extract claims
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if userID, ok := claims["user_id"].(float64); ok {
-			return int(userID), nil
-		}
+	if claims.PasswordResetRequired {
+		return 0, errors.New("password reset required")
+	}
+	if claims.UserID != 0 {
+		return claims.UserID, nil
 	}
 	
 	return 0, errors.New("invalid token")
@@ -1115,6 +1909,7 @@ func validateTokenFromQuery(tokenString string) (int, error) {
 
 // handleExportJSON exports a specific invoice in JSON format
 func handleExportJSON(c *gin.Context) {
+	ctx := c.Request.Context()
 	// Check if there's a token in the query parameters (for direct downloads)
 	tokenParam := c.Query("token")
 	userID := c.GetInt("userID")
@@ -1123,7 +1918,7 @@ func handleExportJSON(c *gin.Context) {
 	if tokenParam != "" && userID == 0 {
 		// Validate the token and extract userID
 		var err error
-		userID, err = validateTokenFromQuery(tokenParam)
+		userID, err = validateTokenFromQuery(ctx, tokenParam)
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
 			return
@@ -1148,51 +1943,79 @@ func handleExportJSON(c *gin.Context) {
 	// Fetch invoice
 	var invoiceJSON []byte
 	var invoiceNo string
-	err = dbPool.QueryRow(context.Background(),
-		`SELECT invoice_json, invoice_no FROM invoices WHERE id = $1 AND user_id = $2`,
-		id, userID).Scan(&invoiceJSON, &invoiceNo)
+	var ack irpAcknowledgement
+	var ackDt *time.Time
+	var updatedAt time.Time
+	err = dbPool.QueryRow(ctx,
+		`SELECT invoice_json, invoice_no, COALESCE(irn, ''), COALESCE(ack_no, ''), ack_dt,
+			COALESCE(signed_qr_code, ''), updated_at
+		FROM invoices WHERE id = $1 AND user_id = $2`,
+		id, userID).Scan(&invoiceJSON, &invoiceNo, &ack.Irn, &ack.AckNo, &ackDt, &ack.SignedQRCode, &updatedAt)
 	
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found: " + err.Error()})
 		return
 	}
 
+	// Invoices the IRP would reject are not exported unless force=true is given
+	if c.Query("force") != "true" {
+		violations, err := validateInvoiceJSON(invoiceJSON)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice JSON"})
+			return
+		}
+		if len(violations) > 0 {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{
+				"error":      "Invoice does not match the e-invoice schema",
+				"violations": violations,
+			})
+			return
+		}
+	}
+
+	// Registered invoices are exported as the complete legal document with
+	// the IRP acknowledgement alongside the request payload
+	if ack.Irn != "" {
+		if ackDt != nil {
+			ack.AckDt = ackDt.In(istLocation).Format(irpTimeLayout)
+		}
+		if invoiceJSON, err = ack.embed(invoiceJSON); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build invoice JSON"})
+			return
+		}
+	}
+
 	// Pretty print the JSON for better readability
 	var prettyJSON bytes.Buffer
 	if err := json.Indent(&prettyJSON, invoiceJSON, "", "  "); err == nil {
 		invoiceJSON = prettyJSON.Bytes()
 	}
 
-	// Add necessary CORS headers for cross-origin downloads
-	c.Header("Access-Control-Allow-Origin", "*")
-	c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization")
-	c.Header("Access-Control-Allow-Methods", "GET, OPTIONS")
-	c.Header("Access-Control-Expose-Headers", "Content-Disposition, Content-Length, Content-Type")
-	
-	// Set headers for file download
+	// Set headers for file download; unchanged invoices are answered with 304
 	filename := fmt.Sprintf("invoice-%s.json", invoiceNo)
-	c.Header("Content-Type", "application/json; charset=utf-8")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
-	c.Header("Content-Length", fmt.Sprintf("%d", len(invoiceJSON)))
-	c.Header("Cache-Control", "no-store, no-cache, must-revalidate, max-age=0")
-	c.Header("Cache-Control", "post-check=0, pre-check=0")
-	c.Header("Pragma", "no-cache")
-	
-	// Ensure status is set before writing content
-	c.Status(http.StatusOK)
-	
-	// Write the JSON data directly
-	c.Writer.Write(invoiceJSON)
+	serveConditional(c, "application/json; charset=utf-8", updatedAt, invoiceJSON)
 }
 
-// handleExportAllJSON exports all invoices as a JSON array
+// handleExportAllJSON exports all invoices as a JSON array, or with format=zip
+// as a ZIP archive of per-invoice files
 func handleExportAllJSON(c *gin.Context) {
 	userID := c.GetInt("userID")
 
+	switch c.DefaultQuery("format", "json") {
+	case "json":
+	case "zip":
+		exportInvoiceZip(c, userID, c.Query("qr") == "true")
+		return
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or zip"})
+		return
+	}
+
 	// Fetch invoices
-	rows, err := dbPool.Query(context.Background(),
-		`SELECT invoice_json FROM invoices WHERE user_id = $1 ORDER BY created_at DESC`,
-		userID)
+	rows, err := dbPool.Query(exportContext(c),
+		`SELECT invoice_json FROM invoices WHERE user_id = $1 AND NOT quarantined AND status NOT IN ('draft', 'pending_approval') AND (NOT sandbox OR $2) ORDER BY created_at DESC`,
+		userID, includeSandbox(c))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices: " + err.Error()})
 		return
@@ -1209,6 +2032,9 @@ func handleExportAllJSON(c *gin.Context) {
 		}
 		invoices = append(invoices, invoiceJSON)
 	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
+		return
+	}
 
 	// Return as JSON array
 	result, err := json.Marshal(invoices)
@@ -1219,13 +2045,8 @@ func handleExportAllJSON(c *gin.Context) {
 
 	// Set headers for file download
 	filename := fmt.Sprintf("all-invoices-%s.json", time.Now().Format("2006-01-02"))
-	c.Header("Content-Type", "application/json")
 	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
-	c.Header("Content-Length", fmt.Sprintf("%d", len(result)))
-	c.Header("Cache-Control", "no-cache")
-	
-	// Write the JSON data directly
-	c.Writer.Write(result)
+	serveConditional(c, "application/json", time.Time{}, result)
 }
 
 // handleMarkInvoiceExported marks an invoice as exported to GST portal
@@ -1240,28 +2061,63 @@ func handleMarkInvoiceExported(c *gin.Context) {
 		return
 	}
 
+	// The IRN, acknowledgement and signed QR code returned by the IRP are optional
+	var req struct {
+		Irn          string `json:"irn"`
+		AckNo        string `json:"ack_no"`
+		AckDt        string `json:"ack_dt"`
+		SignedQRCode string `json:"signed_qr_code"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+	var ackDt *time.Time
+	if req.AckDt != "" {
+		t, err := parseIRPTime(req.AckDt)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "ack_dt must be in YYYY-MM-DD HH:MM:SS format"})
+			return
+		}
+		ackDt = &t
+	}
+
 	// Check if invoice exists and belongs to user
-	var count int
-	err = dbPool.QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM invoices WHERE id = $1 AND user_id = $2",
-		id, userID).Scan(&count)
+	var status, previousIRN string
+	ctx := c.Request.Context()
+	err = dbPool.QueryRow(ctx,
+		"SELECT status, COALESCE(irn, '') FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&status, &previousIRN)
 	
-	if err != nil || count == 0 {
+	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
 		return
 	}
 
-	// Update invoice to mark as exported
+	if status != models.InvoiceStatusFinalized {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only finalized invoices can be exported; invoice is %s", status)})
+		return
+	}
+
+	// Update invoice to mark as exported, keeping any previously recorded IRN
 	now := time.Now()
-	_, err = dbPool.Exec(context.Background(),
-		`UPDATE invoices SET exported = true, exported_at = $1, updated_at = $1
+	_, err = dbPool.Exec(ctx,
+		`UPDATE invoices SET exported = true, exported_at = $1, updated_at = $1,
+			irn = COALESCE(NULLIF($4, ''), irn), ack_no = COALESCE(NULLIF($5, ''), ack_no),
+			ack_dt = COALESCE($6, ack_dt), signed_qr_code = COALESCE(NULLIF($7, ''), signed_qr_code)
 		WHERE id = $2 AND user_id = $3`,
-		now, id, userID)
+		now, id, userID, req.Irn, req.AckNo, ackDt, req.SignedQRCode)
 	
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice"})
 		return
 	}
+	recordInvoiceEvent(ctx, dbPool, eventInvoiceExported, id)
+	if previousIRN == "" && req.Irn != "" {
+		recordInvoiceEvent(ctx, dbPool, eventInvoiceIRNGenerated, id)
+	}
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Invoice marked as exported successfully",
@@ -1282,12 +2138,15 @@ func handleGetInvoiceById(c *gin.Context) {
 		return
 	}
 
+	// The total is also spelled out, in English unless words_lang=hi
+	wordsLang := c.DefaultQuery("words_lang", models.WordsLangEnglish)
+	if wordsLang != models.WordsLangEnglish && wordsLang != models.WordsLangHindi {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "words_lang must be en or hi"})
+		return
+	}
+
 	// Fetch invoice data
-	var invoiceJSON []byte
-	err = dbPool.QueryRow(context.Background(),
-		`SELECT invoice_json FROM invoices WHERE id = $1 AND user_id = $2`,
-		id, userID).Scan(&invoiceJSON)
-	
+	record, err := getInvoice(c.Request.Context(), userID, id)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found"})
 		return
@@ -1295,20 +2154,30 @@ func handleGetInvoiceById(c *gin.Context) {
 
 	// Parse invoice JSON
 	var invoice models.EInvoice
-	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+	if err := json.Unmarshal(record.InvoiceJSON, &invoice); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	body, err := json.Marshal(gin.H{
 		"invoice": invoice,
 		"id": id,
+		"amount_in_words": models.AmountInWords(invoice.ValDtls.TotInvVal, wordsLang),
 	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
+		return
+	}
+
+	// Clients revalidate with If-None-Match or If-Modified-Since and get 304
+	// while the invoice is unchanged
+	serveConditional(c, "application/json; charset=utf-8", record.UpdatedAt, body)
 }
 
 // handleUpdateInvoice updates an existing invoice
 func handleUpdateInvoice(c *gin.Context) {
 	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
 	invoiceID := c.Param("id")
 
 	// Validate ID
@@ -1325,39 +2194,63 @@ func handleUpdateInvoice(c *gin.Context) {
 		return
 	}
 
-	// Validate invoice data
-	if err := invoice.Validate(); err != nil {
+	// Convert foreign currency prices at the rate for the invoice date
+	precision := getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin)
+	if err := resolveExchangeRate(ctx, &invoice, precision); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
 		return
 	}
 
-	// Calculate totals
-	invoice.CalculateTotals()
-
-	// Check if invoice exists and belongs to user
-	var count int
-	err = dbPool.QueryRow(context.Background(),
-		"SELECT COUNT(*) FROM invoices WHERE id = $1 AND user_id = $2",
-		id, userID).Scan(&count)
+	// Check if invoice exists, belongs to user, and is still editable
+	var status, irn, invoiceNo string
+	var exported bool
+	var invoiceDate time.Time
+	err = dbPool.QueryRow(ctx,
+		"SELECT status, exported, COALESCE(irn, ''), invoice_no, invoice_date FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&status, &exported, &irn, &invoiceNo, &invoiceDate)
+	
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
 	
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error: " + err.Error()})
 		return
 	}
-	
-	if count == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+
+	// Exported and registered invoices are locked unless an admin overrides it
+	overrideReason := ""
+	if isInvoiceLocked(exported, irn) {
+		reason, ok := lockOverrideReason(c)
+		if !ok {
+			return
+		}
+		overrideReason = reason
+	} else if status != models.InvoiceStatusDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only draft invoices can be edited; invoice is %s", status)})
 		return
 	}
 
-	// Update QR code
-	qrContent := fmt.Sprintf("%s:%.2f", invoice.DocDtls.No, invoice.ValDtls.TotInvVal)
-	qrCode, err := qrcode.Encode(qrContent, qrcode.Medium, 256)
+	// Invoices dated in a locked period, before or after the edit, can only
+	// be changed with an admin override
+	periodOverride, ok := checkPeriodLock(c, userID, invoiceDate, invoiceDocDate(&invoice))
+	if !ok {
+		return
+	}
+
+	// Validate invoice data and check state codes and HSN codes against the
+	// masters; drafts are held to their company's validation profile, and
+	// invoices edited under a lock override strictly
+	warnings, err := validateInvoice(ctx, userID, &invoice, status)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code: " + err.Error()})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
 		return
 	}
 
+	// Calculate totals
+	invoice.CalculateTotalsWithPrecision(precision)
+
 	// Serialize invoice to JSON
 	invoiceJSON, err := json.Marshal(invoice)
 	if err != nil {
@@ -1365,34 +2258,56 @@ func handleUpdateInvoice(c *gin.Context) {
 		return
 	}
 
-	// Update in database - without using updated_at
-	_, err = dbPool.Exec(context.Background(),
+	// Update in database; the status guard prevents racing a finalization
+	agg := aggregatesOf(&invoice, time.Now())
+	_, err = dbPool.Exec(ctx,
 		`UPDATE invoices 
-		SET seller_gstin = $1, invoice_no = $2, invoice_json = $3, qr_code = $4
-		WHERE id = $5 AND user_id = $6`,
-		invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoiceJSON, qrCode, id, userID)
+		SET seller_gstin = $1, invoice_no = $2, invoice_json = $3, qr_code = NULL, qr_object_key = NULL, updated_at = NOW(),
+			total_value = $7, taxable_value = $8, tax_amount = $9, buyer_gstin = $10, invoice_date = $11,
+			gstr1_section = $12, igst_amount = $13, cgst_amount = $14, sgst_amount = $15, doc_type = $16,
+			reverse_charge = $17, customer_id = `+invoiceCustomerSQL("$5", "$10", "$3")+`
+		WHERE id = $4 AND user_id = $5 AND (status = 'draft' OR $6)`,
+		invoice.SellerDtls.Gstin, invoice.DocDtls.No, invoiceJSON, id, userID, overrideReason != "",
+		agg.totalValue, agg.taxableValue, agg.taxAmount, agg.buyerGSTIN, agg.invoiceDate, agg.gstr1Section,
+		agg.igstAmount, agg.cgstAmount, agg.sgstAmount, agg.docType, agg.reverseCharge)
 	
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice: " + err.Error()})
 		return
 	}
+	recordInvoiceEvent(ctx, dbPool, eventInvoiceUpdated, id)
+	if overrideReason != "" {
+		recordLockOverride(ctx, dbPool, userID, lockOverride{
+			InvoiceID: id, InvoiceNo: invoiceNo, Action: "update", Reason: overrideReason, AdminID: c.GetInt("actorID"),
+		})
+	}
+	periodOverride.InvoiceID, periodOverride.InvoiceNo, periodOverride.Action = id, invoiceNo, "update"
+	recordPeriodLockOverride(ctx, dbPool, userID, periodOverride)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Invoice updated successfully",
 		"invoice_id": id,
+		"warnings": warnings,
 	})
 }
 
-// handleGetSuppliers returns all suppliers for the authenticated user
+// handleGetSuppliers returns the authenticated user's suppliers, a page at a
+// time when a limit is given
 func handleGetSuppliers(c *gin.Context) {
 	userID := c.GetInt("userID")
 
-	rows, err := dbPool.Query(context.Background(), `
-		SELECT id, name, gstin, address, city, state, pincode, phone, email, created_at
+	page, message := parsePageRequest(c, partySortFields, "-created_at")
+	if page == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return
+	}
+
+	args := []interface{}{userID}
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, name, gstin, address, city, state, pincode, phone, email, created_at, `+page.keySQL("")+`
 		FROM suppliers
-		WHERE user_id = $1
-		ORDER BY created_at DESC
-	`, userID)
+		WHERE user_id = $1`+page.whereSQL("", &args)+page.orderSQL(""),
+		args...)
 	
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch suppliers"})
@@ -1403,24 +2318,29 @@ func handleGetSuppliers(c *gin.Context) {
 	var suppliers []Supplier
 	for rows.Next() {
 		var s Supplier
+		var pageKey string
 		err := rows.Scan(
 			&s.ID, &s.Name, &s.GSTIN, &s.Address, &s.City, &s.State,
-			&s.Pincode, &s.Phone, &s.Email, &s.CreatedAt,
+			&s.Pincode, &s.Phone, &s.Email, &s.CreatedAt, &pageKey,
 		)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to scan supplier data"})
 			return
 		}
+		if !page.next(pageKey, int64(s.ID)) {
+			break
+		}
 		s.UserID = userID
 		suppliers = append(suppliers, s)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"suppliers": suppliers})
+	c.JSON(http.StatusOK, page.respond(gin.H{"suppliers": suppliers}))
 }
 
 // handleCreateSupplier creates a new supplier
 func handleCreateSupplier(c *gin.Context) {
 	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
 
 	var supplier Supplier
 	if err := c.ShouldBindJSON(&supplier); err != nil {
@@ -1428,6 +2348,10 @@ func handleCreateSupplier(c *gin.Context) {
 		return
 	}
 
+	// Fill in missing details from the GSTIN verification API
+	autoFillParty(ctx, supplier.GSTIN, &supplier.Name, &supplier.Address, &supplier.City, &supplier.State, &supplier.Pincode)
+	autoFillFromPincode(ctx, supplier.Pincode, &supplier.City, &supplier.State)
+
 	// Validate required fields
 	if supplier.Name == "" {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Supplier name is required"})
@@ -1436,7 +2360,7 @@ func handleCreateSupplier(c *gin.Context) {
 
 	// Insert supplier
 	var id int
-	err := dbPool.QueryRow(context.Background(), `
+	err := dbPool.QueryRow(ctx, `
 		INSERT INTO suppliers (
 			user_id, name, gstin, address, city, state, pincode, phone, email
 		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
@@ -1483,7 +2407,7 @@ func handleUpdateSupplier(c *gin.Context) {
 
 	// Check if supplier exists and belongs to user
 	var count int
-	err = dbPool.QueryRow(context.Background(),
+	err = dbPool.QueryRow(c.Request.Context(),
 		"SELECT COUNT(*) FROM suppliers WHERE id = $1 AND user_id = $2",
 		id, userID).Scan(&count)
 	
@@ -1493,7 +2417,7 @@ func handleUpdateSupplier(c *gin.Context) {
 	}
 
 	// Update supplier
-	_, err = dbPool.Exec(context.Background(), `
+	_, err = dbPool.Exec(c.Request.Context(), `
 		UPDATE suppliers
 		SET name = $1, gstin = $2, address = $3, city = $4, state = $5,
 			pincode = $6, phone = $7, email = $8
@@ -1528,7 +2452,7 @@ func handleDeleteSupplier(c *gin.Context) {
 	}
 
 	// Delete supplier
-	result, err := dbPool.Exec(context.Background(),
+	result, err := dbPool.Exec(c.Request.Context(),
 		"DELETE FROM suppliers WHERE id = $1 AND user_id = $2",
 		id, userID,
 	)
@@ -1543,6 +2467,7 @@ func handleDeleteSupplier(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Supplier not found or not authorized"})
 		return
 	}
+	recordEvent(c.Request.Context(), dbPool, userID, eventSupplierDeleted, entitySupplier, id, gin.H{})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Supplier deleted successfully",
@@ -1562,10 +2487,42 @@ func handleDeleteInvoice(c *gin.Context) {
 		return
 	}
 
-	// Delete invoice
-	result, err := dbPool.Exec(context.Background(),
-		"DELETE FROM invoices WHERE id = $1 AND user_id = $2",
-		id, userID,
+	ctx := c.Request.Context()
+	var exported bool
+	var irn, invoiceNo string
+	var invoiceDate time.Time
+	err = dbPool.QueryRow(ctx,
+		"SELECT exported, COALESCE(irn, ''), invoice_no, invoice_date FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&exported, &irn, &invoiceNo, &invoiceDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	// Exported and registered invoices are locked unless an admin overrides it
+	overrideReason := ""
+	if isInvoiceLocked(exported, irn) {
+		reason, ok := lockOverrideReason(c)
+		if !ok {
+			return
+		}
+		overrideReason = reason
+	}
+
+	// Invoices dated in a locked period can only be deleted with an admin override
+	periodOverride, ok := checkPeriodLock(c, userID, invoiceDate)
+	if !ok {
+		return
+	}
+
+	// Delete invoice; the lock guard prevents racing an export
+	result, err := dbPool.Exec(ctx,
+		"DELETE FROM invoices WHERE id = $1 AND user_id = $2 AND ((NOT exported AND COALESCE(irn, '') = '') OR $3)",
+		id, userID, overrideReason != "",
 	)
 
 	if err != nil {
@@ -1575,9 +2532,17 @@ func handleDeleteInvoice(c *gin.Context) {
 
 	// Check if any row was deleted
 	if result.RowsAffected() == 0 {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		c.JSON(http.StatusConflict, gin.H{"error": lockedInvoiceError, "locked": true})
 		return
 	}
+	recordEvent(ctx, dbPool, userID, eventInvoiceDeleted, entityInvoice, id, gin.H{})
+	if overrideReason != "" {
+		recordLockOverride(ctx, dbPool, userID, lockOverride{
+			InvoiceID: id, InvoiceNo: invoiceNo, Action: "delete", Reason: overrideReason, AdminID: c.GetInt("actorID"),
+		})
+	}
+	periodOverride.InvoiceID, periodOverride.InvoiceNo, periodOverride.Action = id, invoiceNo, "delete"
+	recordPeriodLockOverride(ctx, dbPool, userID, periodOverride)
 
 	c.JSON(http.StatusOK, gin.H{
 		"message": "Invoice deleted successfully",
@@ -1595,27 +2560,18 @@ func handleDownloadExcelTemplate(c *gin.Context) {
 		}
 	}()
 
-	// Create the headers
-	headers := []string{
-		"Invoice No", "Date (DD/MM/YYYY)", "Seller GSTIN", "Seller Legal Name", 
-		"Seller Trade Name", "Seller Address1", "Seller Address2", "Seller Location", 
-		"Seller PIN", "Seller State", "Seller Phone", "Seller Email",
-		"Buyer GSTIN", "Buyer Legal Name", "Buyer Trade Name", 
-		"Buyer Address1", "Buyer Address2", "Buyer Location", 
-		"Buyer PIN", "Buyer State", "Buyer Phone", "Buyer Email",
-		"Item No", "Item Description", "HSN Code", "Quantity", "Unit", 
-		"Unit Price", "GST Rate (%)", "Is Service (Y/N)",
-	}
+	// The headers follow the layout the upload parser reads by default
+	headers := excelTemplateHeaders()
 
 	// Set active sheet
 	f.SetActiveSheet(0)
-	sheetName := "Invoice Template"
+	sheetName := templateSheetName
 	f.SetSheetName("Sheet1", sheetName)
 
 	// Set column headers
 	for i, header := range headers {
-		col := string(rune('A' + i))
-		f.SetCellValue(sheetName, col+"1", header)
+		cell, _ := excelize.CoordinatesToCellName(i+1, 1)
+		f.SetCellValue(sheetName, cell, header)
 	}
 
 	// Add sample data (row 2)
@@ -1628,11 +2584,12 @@ func handleDownloadExcelTemplate(c *gin.Context) {
 		"110001", "Delhi", "8765432109", "buyer@example.com",
 		"1", "Computer Monitor", "8471", "2", "PCS", 
 		"15000", "18", "N",
+		"Deliver to the Andheri warehouse", "PO-4521", "20/03/2023",
 	}
 
 	for i, value := range sampleData {
-		col := string(rune('A' + i))
-		f.SetCellValue(sheetName, col+"2", value)
+		cell, _ := excelize.CoordinatesToCellName(i+1, 2)
+		f.SetCellValue(sheetName, cell, value)
 	}
 
 	// Add a second sample item (row 3)
@@ -1641,11 +2598,12 @@ func handleDownloadExcelTemplate(c *gin.Context) {
 		"", "", "", "", "", "", "", "", "", "",
 		"2", "Software Service", "9983", "1", "SAC", 
 		"25000", "18", "Y",
+		"", "", "",
 	}
 
 	for i, value := range secondItem {
-		col := string(rune('A' + i))
-		f.SetCellValue(sheetName, col+"3", value)
+		cell, _ := excelize.CoordinatesToCellName(i+1, 3)
+		f.SetCellValue(sheetName, cell, value)
 	}
 
 	// Add helper text in a new worksheet
@@ -1653,13 +2611,16 @@ func handleDownloadExcelTemplate(c *gin.Context) {
 	instructions := []string{
 		"Instructions for filling the Excel template:",
 		"1. Each row represents an invoice line item",
-		"2. For multi-item invoices, repeat the invoice header information with different item details",
+		"2. For multi-item invoices, repeat the Invoice No on each item row; the seller and buyer details are read from the first row and may be left blank on the rest",
 		"3. Date format should be DD/MM/YYYY",
 		"4. GST Rate should be a number (e.g., 18 for 18%)",
 		"5. Is Service should be 'Y' for services or 'N' for goods",
-		"6. All required fields must be filled",
-		"7. Save the file as Excel (.xlsx) format",
-		"8. Upload the completed file through the 'Upload Excel' page",
+		"6. Seller State and Buyer State may be a state name or a GST state code (e.g., 27 for Maharashtra)",
+		"7. Seller details may be left blank for a GSTIN saved under Companies; a blank Buyer State and PIN mean a buyer outside India",
+		"8. Remarks, PO Number and PO Date are optional and read from the first row of each invoice; PO Date uses DD/MM/YYYY",
+		"9. All required fields must be filled",
+		"10. Save the file as Excel (.xlsx) format",
+		"11. Upload the completed file through the 'Upload Excel' page",
 	}
 
 	for i, text := range instructions {
@@ -1682,32 +2643,3 @@ func handleDownloadExcelTemplate(c *gin.Context) {
 	// Write the Excel data
 	c.Writer.Write(buf.Bytes())
 }
-
-// configureCORS sets up CORS middleware with environment variables
-func configureCORS(router *gin.Engine) {
-	// Get frontend origins from environment variables
-	frontendOrigin := os.Getenv("FRONTEND_ORIGIN")
-	if frontendOrigin == "" {
-		log.Fatal("FRONTEND_ORIGIN environment variable is required")
-	}
-
-	// Get dev origin, default to localhost:3000 if not set
-	frontendDevOrigin := os.Getenv("FRONTEND_ORIGIN_DEV")
-	if frontendDevOrigin == "" {
-		frontendDevOrigin = "http://localhost:3000"
-		log.Printf("FRONTEND_ORIGIN_DEV not set, defaulting to %s", frontendDevOrigin)
-	}
-
-	// Log allowed origins for debugging
-	log.Printf("CORS configured to allow origins: %s, %s", frontendOrigin, frontendDevOrigin)
-
-	// Configure CORS middleware
-	router.Use(cors.New(cors.Config{
-		AllowOrigins:     []string{frontendOrigin, frontendDevOrigin},
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Accept"},
-		ExposeHeaders:    []string{"Content-Length", "Content-Type"},
-		AllowCredentials: true,
-		MaxAge:           12 * time.Hour,
-	}))
-} 
\ No newline at end of file
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// masterTable describes how rows are merged into a master data table, such
+// as the HSN master or the PIN code directory
+type masterTable struct {
+	Name string
+	// Key holds the columns of the table's primary key or unique constraint
+	Key []string
+	// Columns holds the columns loaded, the key included
+	Columns []string
+	// Touch is set on rows that change, such as "updated_at = NOW()"
+	Touch string
+	// Only restricts the rows replaced, with the table aliased as t, such as
+	// "t.source = 'matrix'"; other rows with the same key are kept
+	Only string
+}
+
+// upsertMasterRows merges rows into a master data table: rows with a new key
+// are inserted and rows whose columns differ are replaced. The rows are
+// copied into a temporary table and merged in one statement, so large
+// directories load quickly and repeated loads leave unchanged rows alone.
+// When a key is given more than once, its last row is kept. It returns the
+// number of rows inserted or replaced.
+func upsertMasterRows(ctx context.Context, table masterTable, rows [][]interface{}) (int64, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, fmt.Sprintf(
+		"CREATE TEMP TABLE master_load (LIKE %s INCLUDING DEFAULTS) ON COMMIT DROP", table.Name)); err != nil {
+		return 0, err
+	}
+	if _, err := tx.Exec(ctx, "ALTER TABLE master_load ADD COLUMN load_seq BIGSERIAL"); err != nil {
+		return 0, err
+	}
+	if _, err := tx.CopyFrom(ctx, pgx.Identifier{"master_load"}, table.Columns, pgx.CopyFromRows(rows)); err != nil {
+		return 0, err
+	}
+
+	isKey := make(map[string]bool, len(table.Key))
+	for _, k := range table.Key {
+		isKey[k] = true
+	}
+	var set, current, loaded []string
+	for _, col := range table.Columns {
+		if isKey[col] {
+			continue
+		}
+		set = append(set, fmt.Sprintf("%s = EXCLUDED.%s", col, col))
+		current = append(current, "t."+col)
+		loaded = append(loaded, "EXCLUDED."+col)
+	}
+	if table.Touch != "" {
+		set = append(set, table.Touch)
+	}
+	where := fmt.Sprintf("(%s) IS DISTINCT FROM (%s)", strings.Join(current, ", "), strings.Join(loaded, ", "))
+	if table.Only != "" {
+		where += " AND " + table.Only
+	}
+
+	columns, key := strings.Join(table.Columns, ", "), strings.Join(table.Key, ", ")
+	result, err := tx.Exec(ctx, fmt.Sprintf(`
+		INSERT INTO %s AS t (%s)
+		SELECT DISTINCT ON (%s) %s FROM master_load ORDER BY %s, load_seq DESC
+		ON CONFLICT (%s) DO UPDATE SET %s WHERE %s
+	`, table.Name, columns, key, columns, key, key, strings.Join(set, ", "), where))
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return result.RowsAffected(), nil
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// createMemberTables creates the table of users working on other users' accounts
+func createMemberTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS account_members (
+			id SERIAL PRIMARY KEY,
+			owner_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			member_id INTEGER NOT NULL UNIQUE REFERENCES users(id) ON DELETE CASCADE,
+			role VARCHAR(20) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create account_members table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_account_members_owner ON account_members (owner_id)")
+	if err != nil {
+		log.Fatalf("Failed to create account_members index: %v", err)
+	}
+}
+
+// accountMiddleware lets members work on the account they belong to. For a
+// member, userID becomes the owner's ID, actorID the member's own ID and
+// memberRole their role; for everyone else actorID is userID and memberRole
+// is empty. It must run after authMiddleware.
+func accountMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actorID := c.GetInt("userID")
+		c.Set("actorID", actorID)
+
+		ownerID, role, err := lookupAccount(c.Request.Context(), actorID)
+		if errors.Is(err, errAccountDisabled) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "The account you are a member of is disabled", "code": errCodeAccountDisabled})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			c.Abort()
+			return
+		}
+
+		c.Set("userID", ownerID)
+		c.Set("memberRole", role)
+		c.Next()
+	}
+}
+
+// lookupAccount returns the account a user works in and their member role
+// in it; users who are nobody's member work in their own account, with no
+// role. It returns errAccountDisabled when the owner's account is disabled.
+func lookupAccount(ctx context.Context, userID int) (int, string, error) {
+	var ownerID int
+	var role string
+	var disabled bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT m.owner_id, m.role, o.disabled_at IS NOT NULL
+		FROM account_members m JOIN users o ON o.id = m.owner_id
+		WHERE m.member_id = $1
+	`, userID).Scan(&ownerID, &role, &disabled)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return userID, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	if disabled {
+		return 0, "", errAccountDisabled
+	}
+	return ownerID, role, nil
+}
+
+// ownerMiddleware restricts a route to account owners, so members cannot
+// change the account's settings, credentials or members. It must run after
+// accountMiddleware.
+func ownerMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !isAccountOwner(c) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the account owner can do this", "code": errCodeOwnerRequired})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// isAccountOwner reports whether the user making the request owns the
+// account rather than working in it as a member. It must run after
+// accountMiddleware.
+func isAccountOwner(c *gin.Context) bool {
+	return c.GetString("memberRole") == ""
+}
+
+// memberColumns are selected by scanMember
+const memberColumns = "m.id, m.owner_id, m.member_id, u.email, u.name, m.role, m.created_at"
+
+// scanMember reads an account member selected with memberColumns
+func scanMember(row pgx.Row) (*models.AccountMember, error) {
+	m := &models.AccountMember{}
+	err := row.Scan(&m.ID, &m.OwnerID, &m.MemberID, &m.Email, &m.Name, &m.Role, &m.CreatedAt)
+	return m, err
+}
+
+// handleGetMembers lists the members of the authenticated user's account
+func handleGetMembers(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT `+memberColumns+`
+		FROM account_members m JOIN users u ON u.id = m.member_id
+		WHERE m.owner_id = $1
+		ORDER BY m.created_at
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch members"})
+		return
+	}
+	defer rows.Close()
+
+	members := make([]*models.AccountMember, 0)
+	for rows.Next() {
+		m, err := scanMember(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read member"})
+			return
+		}
+		members = append(members, m)
+	}
+	if rowsFailed(c, rows, "Failed to fetch members") {
+		return
+	}
+
+	c.JSON(http.StatusOK, members)
+}
+
+// handleAddMember adds a registered user to the authenticated user's account
+// by email. A user can belong to one account, and owners with members of
+// their own cannot join another account.
+func handleAddMember(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	var req struct {
+		Email string `json:"email" binding:"required,email"`
+		Role  string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !models.IsValidMemberRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be accountant or approver"})
+		return
+	}
+
+	var memberID int
+	var hasMembers bool
+	err := dbPool.QueryRow(ctx, `
+		SELECT id, EXISTS (SELECT 1 FROM account_members WHERE owner_id = users.id)
+		FROM users WHERE email = $1
+	`, strings.TrimSpace(req.Email)).Scan(&memberID, &hasMembers)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No user is registered with this email"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if memberID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "You cannot add yourself as a member"})
+		return
+	}
+	if hasMembers {
+		c.JSON(http.StatusConflict, gin.H{"error": "User has members of their own account"})
+		return
+	}
+
+	var id int
+	err = dbPool.QueryRow(ctx,
+		"INSERT INTO account_members (owner_id, member_id, role) VALUES ($1, $2, $3) RETURNING id",
+		userID, memberID, req.Role).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "User is already a member of an account"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to add member"})
+		return
+	}
+
+	member, err := scanMember(dbPool.QueryRow(ctx,
+		"SELECT "+memberColumns+" FROM account_members m JOIN users u ON u.id = m.member_id WHERE m.id = $1", id))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch member"})
+		return
+	}
+	c.JSON(http.StatusCreated, member)
+}
+
+// handleUpdateMember changes the role of a member of the authenticated user's account
+func handleUpdateMember(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid member ID"})
+		return
+	}
+
+	var req struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	if !models.IsValidMemberRole(req.Role) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Role must be accountant or approver"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE account_members SET role = $1 WHERE id = $2 AND owner_id = $3", req.Role, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update member"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member updated successfully", "id": id, "role": req.Role})
+}
+
+// handleRemoveMember removes a member from the authenticated user's account.
+// The user keeps their login and returns to their own account.
+func handleRemoveMember(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid member ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM account_members WHERE id = $1 AND owner_id = $2", id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove member"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Member not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully", "id": id})
+}
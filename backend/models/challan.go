@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// ChallanPrefix is the number prefix of the delivery challan series
+const ChallanPrefix = "DC-"
+
+// ChallanDocType is the document type of delivery challans, as named on e-way bills
+const ChallanDocType = "CHL"
+
+// ChallanPurposes are the reasons goods move under a delivery challan
+// rather than a tax invoice
+var ChallanPurposes = []string{"JOB_WORK", "EXHIBITION", "BRANCH_TRANSFER", "SUPPLY_ON_APPROVAL", "OTHER"}
+
+// IsValidChallanPurpose reports whether purpose is one of ChallanPurposes
+func IsValidChallanPurpose(purpose string) bool {
+	for _, p := range ChallanPurposes {
+		if purpose == p {
+			return true
+		}
+	}
+	return false
+}
+
+// DeliveryChallan records goods sent without a sale, such as for job work,
+// an exhibition or to another branch. Its lines are kept as invoice JSON so
+// a challan can later be billed, which links it to the invoice by ID.
+type DeliveryChallan struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	ChallanNo   string     `json:"challan_no" db:"challan_no"`
+	Purpose     string     `json:"purpose" db:"purpose"`
+	Challan     EInvoice   `json:"challan" db:"invoice_json"`
+	InvoiceID   *int       `json:"invoice_id,omitempty" db:"invoice_id"`
+	ConvertedAt *time.Time `json:"converted_at,omitempty" db:"converted_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
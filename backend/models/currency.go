@@ -0,0 +1,75 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// Sources of the exchange rates foreign currency invoices are converted at
+const (
+	FxSourceRBI = "rbi"
+	FxSourceECB = "ecb"
+	// FxSourceManual rates are given on the invoice and never replaced
+	FxSourceManual = "manual"
+)
+
+// ExchangeRateDecimals is the precision exchange rates are kept to
+const ExchangeRateDecimals = 4
+
+// currencyCodeRegex matches ISO 4217 currency codes
+var currencyCodeRegex = regexp.MustCompile(`^[A-Z]{3}$`)
+
+// FxDtls records the conversion of an export invoice priced in a foreign
+// currency: line prices are given in UnitPriceFc and converted to rupees at
+// Rate, the rupees per unit of Currency published by Source for RateDt. It
+// is not part of the NIC schema and is kept on the invoice for audit.
+type FxDtls struct {
+	Currency string  `json:"Currency"`
+	Rate     float64 `json:"Rate,omitempty"`
+	RateDt   string  `json:"RateDt,omitempty"`
+	Source   string  `json:"Source,omitempty"`
+}
+
+// validate checks the currency, the rate and that every line is priced in the currency
+func (f *FxDtls) validate(items []Item) error {
+	if !currencyCodeRegex.MatchString(f.Currency) || f.Currency == "INR" {
+		return errors.New("foreign currency must be a three-letter currency code other than INR")
+	}
+	if f.Rate <= 0 {
+		return errors.New("exchange rate must be greater than zero")
+	}
+	for _, item := range items {
+		if item.UnitPriceFc <= 0 {
+			return errors.New("foreign currency unit price must be greater than zero on every line")
+		}
+	}
+	return nil
+}
+
+// NormalizeCurrency upper-cases the currency code and marks rates given
+// without a source as manual
+func (f *FxDtls) NormalizeCurrency() {
+	f.Currency = strings.ToUpper(strings.TrimSpace(f.Currency))
+	if f.Rate > 0 && f.Source == "" {
+		f.Source = FxSourceManual
+	}
+}
+
+// ApplyExchangeRate converts the foreign currency line prices of the invoice
+// to rupees, rounded to the unit price precision. It does nothing without
+// FxDtls or a rate.
+func (i *EInvoice) ApplyExchangeRate(p Precision) {
+	if i.FxDtls == nil || i.FxDtls.Rate <= 0 {
+		return
+	}
+	for j := range i.ItemList {
+		item := &i.ItemList[j]
+		if item.UnitPriceFc > 0 {
+			item.UnitPrice = Round(item.UnitPriceFc*i.FxDtls.Rate, p.UnitPrice)
+		}
+	}
+	if i.ExpDtls.ForCur == nil || i.ExpDtls.ForCur == "" {
+		i.ExpDtls.ForCur = i.FxDtls.Currency
+	}
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is an append-only record of something that happened to an entity.
+// Events are the source from which reporting projections are rebuilt.
+type Event struct {
+	ID         int64           `json:"id" db:"id"`
+	UserID     *int            `json:"user_id,omitempty" db:"user_id"`
+	Type       string          `json:"type" db:"type"`
+	EntityType string          `json:"entity_type" db:"entity_type"`
+	EntityID   int             `json:"entity_id" db:"entity_id"`
+	Payload    json.RawMessage `json:"payload" db:"payload"`
+	CreatedAt  time.Time       `json:"created_at" db:"created_at"`
+	// TxID is the transaction that wrote the event
+	TxID int64 `json:"-" db:"tx_id"`
+}
+
+// ProjectionStatus reports how far a projection has caught up with the event log
+type ProjectionStatus struct {
+	Name              string     `json:"name"`
+	LastEventID       int64      `json:"last_event_id"`
+	Processed         int64      `json:"processed"`
+	Total             int64      `json:"total"`
+	Rebuilding        bool       `json:"rebuilding"`
+	RebuildStartedAt  *time.Time `json:"rebuild_started_at,omitempty"`
+	RebuildFinishedAt *time.Time `json:"rebuild_finished_at,omitempty"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
@@ -0,0 +1,112 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// ExcelUploadField is an invoice field that can be read from an uploaded spreadsheet.
+// Invoice-level fields are read from the first row of each invoice, so the
+// following rows of a multi-item invoice may leave them blank.
+type ExcelUploadField struct {
+	Name         string `json:"name"`
+	Label        string `json:"label"`
+	Required     bool   `json:"required"`
+	InvoiceLevel bool   `json:"invoice_level"`
+}
+
+// ExcelUploadFields lists the fields a mapping profile can map to columns,
+// in the column order of the downloadable template
+var ExcelUploadFields = []ExcelUploadField{
+	{Name: "invoice_no", Label: "Invoice No", Required: true},
+	{Name: "invoice_date", Label: "Date (DD/MM/YYYY)", Required: true, InvoiceLevel: true},
+	{Name: "seller_gstin", Label: "Seller GSTIN", Required: true, InvoiceLevel: true},
+	{Name: "seller_legal_name", Label: "Seller Legal Name", InvoiceLevel: true},
+	{Name: "seller_trade_name", Label: "Seller Trade Name", InvoiceLevel: true},
+	{Name: "seller_address1", Label: "Seller Address1", InvoiceLevel: true},
+	{Name: "seller_address2", Label: "Seller Address2", InvoiceLevel: true},
+	{Name: "seller_location", Label: "Seller Location", InvoiceLevel: true},
+	{Name: "seller_pin", Label: "Seller PIN", InvoiceLevel: true},
+	{Name: "seller_state", Label: "Seller State", InvoiceLevel: true},
+	{Name: "seller_phone", Label: "Seller Phone", InvoiceLevel: true},
+	{Name: "seller_email", Label: "Seller Email", InvoiceLevel: true},
+	{Name: "buyer_gstin", Label: "Buyer GSTIN", InvoiceLevel: true},
+	{Name: "buyer_legal_name", Label: "Buyer Legal Name", Required: true, InvoiceLevel: true},
+	{Name: "buyer_trade_name", Label: "Buyer Trade Name", InvoiceLevel: true},
+	{Name: "buyer_address", Label: "Buyer Address1", InvoiceLevel: true},
+	{Name: "buyer_address2", Label: "Buyer Address2", InvoiceLevel: true},
+	{Name: "buyer_location", Label: "Buyer Location", InvoiceLevel: true},
+	{Name: "buyer_pin", Label: "Buyer PIN", InvoiceLevel: true},
+	{Name: "buyer_state_code", Label: "Buyer State", InvoiceLevel: true},
+	{Name: "buyer_phone", Label: "Buyer Phone", InvoiceLevel: true},
+	{Name: "buyer_email", Label: "Buyer Email", InvoiceLevel: true},
+	{Name: "item_no", Label: "Item No"},
+	{Name: "item_description", Label: "Item Description", Required: true},
+	{Name: "hsn_code", Label: "HSN Code", Required: true},
+	{Name: "quantity", Label: "Quantity", Required: true},
+	{Name: "unit", Label: "Unit"},
+	{Name: "unit_price", Label: "Unit Price", Required: true},
+	{Name: "gst_rate", Label: "GST Rate (%)", Required: true},
+	{Name: "is_service", Label: "Is Service (Y/N)"},
+	{Name: "remarks", Label: "Remarks", InvoiceLevel: true},
+	{Name: "po_number", Label: "PO Number", InvoiceLevel: true},
+	{Name: "po_date", Label: "PO Date (DD/MM/YYYY)", InvoiceLevel: true},
+	{Name: "exemption", Label: "Exemption (NIL/EXEMPT/NONGST)"},
+	{Name: "place_of_supply", Label: "Place of Supply", InvoiceLevel: true},
+	{Name: "reverse_charge", Label: "Reverse Charge (Y/N)", InvoiceLevel: true},
+	{Name: "supply_type", Label: "Supply Type", InvoiceLevel: true},
+}
+
+// ColumnRef locates a spreadsheet column either by letter or by header text
+type ColumnRef struct {
+	Column string `json:"column,omitempty"`
+	Header string `json:"header,omitempty"`
+}
+
+// ExcelMapping is a saved profile mapping spreadsheet columns to invoice fields
+type ExcelMapping struct {
+	ID        int                  `json:"id" db:"id"`
+	UserID    int                  `json:"user_id" db:"user_id"`
+	Name      string               `json:"name" db:"name"`
+	Sheet     string               `json:"sheet,omitempty" db:"sheet"`
+	HeaderRow int                  `json:"header_row" db:"header_row"`
+	Columns   map[string]ColumnRef `json:"columns" db:"columns"`
+	CreatedAt time.Time            `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time            `json:"updated_at" db:"updated_at"`
+}
+
+var columnLetterRegex = regexp.MustCompile(`^[A-Za-z]{1,3}$`)
+
+// Validate checks the profile name, header row and that every required field is mapped
+func (m *ExcelMapping) Validate() error {
+	if m.Name == "" {
+		return errors.New("mapping name is required")
+	}
+	if m.HeaderRow < 1 {
+		return errors.New("header_row must be at least 1")
+	}
+
+	known := make(map[string]bool, len(ExcelUploadFields))
+	for _, f := range ExcelUploadFields {
+		known[f.Name] = true
+		if _, ok := m.Columns[f.Name]; f.Required && !ok {
+			return fmt.Errorf("required field %s is not mapped", f.Name)
+		}
+	}
+
+	for field, ref := range m.Columns {
+		if !known[field] {
+			return fmt.Errorf("unknown field %s", field)
+		}
+		if (ref.Column == "") == (ref.Header == "") {
+			return fmt.Errorf("field %s must set exactly one of column or header", field)
+		}
+		if ref.Column != "" && !columnLetterRegex.MatchString(ref.Column) {
+			return fmt.Errorf("field %s: invalid column letter %q", field, ref.Column)
+		}
+	}
+
+	return nil
+}
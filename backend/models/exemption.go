@@ -0,0 +1,82 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Line item exemptions: nil-rated supplies are taxed at 0%, exempt supplies
+// are not taxed by notification, and non-GST supplies (such as alcohol for
+// human consumption or petroleum) are outside GST altogether. Each is
+// reported apart from taxable supplies, in GSTR-1 Table 8.
+const (
+	ExemptionNilRated = "NIL"
+	ExemptionExempt   = "EXEMPT"
+	ExemptionNonGST   = "NONGST"
+)
+
+// Exemptions are the accepted line item exemptions
+var Exemptions = []string{ExemptionNilRated, ExemptionExempt, ExemptionNonGST}
+
+// IsValidExemption reports whether exemption is a known line item exemption
+func IsValidExemption(exemption string) bool {
+	for _, e := range Exemptions {
+		if e == exemption {
+			return true
+		}
+	}
+	return false
+}
+
+// IsExempt reports whether the item is nil-rated, exempt or non-GST
+func (item *Item) IsExempt() bool {
+	return item.Exemption != ""
+}
+
+// exemptionLabels are the exemptions as printed in place of the GST rate
+var exemptionLabels = map[string]string{
+	ExemptionNilRated: "Nil",
+	ExemptionExempt:   "Exempt",
+	ExemptionNonGST:   "Non-GST",
+}
+
+// ExemptionLabel is the item's exemption as printed on invoice copies, or
+// empty for taxable items
+func (item *Item) ExemptionLabel() string {
+	return exemptionLabels[item.Exemption]
+}
+
+// ExemptValue is the value of the invoice's nil-rated, exempt and non-GST
+// items, which carries no tax
+func (i *EInvoice) ExemptValue() float64 {
+	var total int64
+	for _, item := range i.ItemList {
+		if item.IsExempt() {
+			total += Paise(item.AssAmt)
+		}
+	}
+	return Rupees(total)
+}
+
+// TaxableValue is the assessable value of the invoice's taxable items,
+// leaving out nil-rated, exempt and non-GST items
+func (i *EInvoice) TaxableValue() float64 {
+	return Rupees(Paise(i.ValDtls.AssVal) - Paise(i.ExemptValue()))
+}
+
+// validateExemptions checks that items flagged nil-rated, exempt or non-GST
+// carry a known flag and a GST rate of 0, so they add no tax
+func (i *EInvoice) validateExemptions() error {
+	for _, item := range i.ItemList {
+		if !item.IsExempt() {
+			continue
+		}
+		if !IsValidExemption(item.Exemption) {
+			return fmt.Errorf("item %s: exemption must be NIL, EXEMPT or NONGST", item.SlNo)
+		}
+		if item.GstRt != 0 {
+			return errors.New("nil-rated, exempt and non-GST items must have a GST rate of 0")
+		}
+	}
+	return nil
+}
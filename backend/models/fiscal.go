@@ -0,0 +1,62 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// FiscalYearStartMonth is the first month of the Indian financial year,
+// which runs from 1 April to 31 March
+const FiscalYearStartMonth = time.April
+
+// fiscalYearRegex matches financial years written as 2024-25
+var fiscalYearRegex = regexp.MustCompile(`^(\d{4})-(\d{2})$`)
+
+// FiscalYear is an Indian financial year, named by the calendar year it starts in
+type FiscalYear struct {
+	StartYear int
+}
+
+// FiscalYearOf returns the financial year a date falls in
+func FiscalYearOf(t time.Time) FiscalYear {
+	if t.Month() < FiscalYearStartMonth {
+		return FiscalYear{StartYear: t.Year() - 1}
+	}
+	return FiscalYear{StartYear: t.Year()}
+}
+
+// ParseFiscalYear reads a financial year written as 2024-25
+func ParseFiscalYear(value string) (FiscalYear, error) {
+	m := fiscalYearRegex.FindStringSubmatch(value)
+	if m == nil {
+		return FiscalYear{}, fmt.Errorf("%q is not a financial year in YYYY-YY format, such as 2024-25", value)
+	}
+	start, _ := strconv.Atoi(m[1])
+	end, _ := strconv.Atoi(m[2])
+	if end != (start+1)%100 {
+		return FiscalYear{}, fmt.Errorf("%q is not a financial year: %02d does not follow %d", value, end, start)
+	}
+	return FiscalYear{StartYear: start}, nil
+}
+
+// String writes the financial year as 2024-25
+func (fy FiscalYear) String() string {
+	return fmt.Sprintf("%d-%02d", fy.StartYear, (fy.StartYear+1)%100)
+}
+
+// Short writes the financial year as 24-25, as used in invoice numbers
+func (fy FiscalYear) Short() string {
+	return fmt.Sprintf("%02d-%02d", fy.StartYear%100, (fy.StartYear+1)%100)
+}
+
+// Start returns 1 April of the financial year
+func (fy FiscalYear) Start() time.Time {
+	return time.Date(fy.StartYear, FiscalYearStartMonth, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// End returns 31 March, the last day of the financial year
+func (fy FiscalYear) End() time.Time {
+	return fy.Start().AddDate(1, 0, -1)
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"time"
+	"unicode/utf8"
+)
+
+// hsnPrefixRegex matches the HSN/SAC prefixes rates are set for: a chapter,
+// heading, subheading or tariff item of 2, 4, 6 or 8 digits
+var hsnPrefixRegex = regexp.MustCompile(`^[0-9]{2}([0-9]{2}){0,3}$`)
+
+// GSTRate is the GST rate of the HSN/SAC codes starting with HSNPrefix from
+// EffectiveFrom, until a later rate for the prefix takes effect
+type GSTRate struct {
+	ID        int     `json:"id" db:"id"`
+	HSNPrefix string  `json:"hsn_prefix" db:"hsn_prefix" binding:"required"`
+	Rate      float64 `json:"rate" db:"rate"`
+	// EffectiveFrom is the first day the rate applies, DD/MM/YYYY
+	EffectiveFrom string `json:"effective_from" db:"effective_from" binding:"required"`
+	// Notification is the rate notification that set the rate, for reference
+	Notification string    `json:"notification" db:"notification"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate checks the HSN prefix, rate, effective date and notification
+func (r *GSTRate) Validate() error {
+	if !hsnPrefixRegex.MatchString(r.HSNPrefix) {
+		return errors.New("HSN prefix must be 2, 4, 6 or 8 digits")
+	}
+	if r.Rate < 0 || r.Rate > 100 {
+		return errors.New("GST rate must be between 0 and 100")
+	}
+	if _, err := time.Parse("02/01/2006", r.EffectiveFrom); err != nil {
+		return errors.New("effective date must be in DD/MM/YYYY format")
+	}
+	if utf8.RuneCountInString(r.Notification) > 100 {
+		return errors.New("notification must be at most 100 characters")
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package models
+
+import "time"
+
+// GSTINDetails holds taxpayer details returned by the GST common portal or a GSP
+type GSTINDetails struct {
+	GSTIN     string    `json:"gstin" db:"gstin"`
+	LegalName string    `json:"legal_name" db:"legal_name"`
+	TradeName string    `json:"trade_name" db:"trade_name"`
+	Address   string    `json:"address" db:"address"`
+	City      string    `json:"city" db:"city"`
+	State     string    `json:"state" db:"state"`
+	Pincode   int       `json:"pincode" db:"pincode"`
+	Status    string    `json:"status" db:"status"`
+	FetchedAt time.Time `json:"fetched_at" db:"fetched_at"`
+}
+
+// IsActive reports whether the taxpayer registration is active
+func (d *GSTINDetails) IsActive() bool {
+	return d.Status == "Active"
+}
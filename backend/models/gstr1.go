@@ -0,0 +1,63 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// GSTR-1 sections an invoice is reported in: supplies to registered buyers
+// (including SEZ units and deemed exports), large inter-state supplies to
+// unregistered buyers, other supplies to unregistered buyers, and exports
+const (
+	GSTR1B2B  = "B2B"
+	GSTR1B2CL = "B2CL"
+	GSTR1B2CS = "B2CS"
+	GSTR1EXP  = "EXP"
+)
+
+// GSTR1Sections are the sections invoices are classified into
+var GSTR1Sections = []string{GSTR1B2B, GSTR1B2CL, GSTR1B2CS, GSTR1EXP}
+
+// Invoices to unregistered buyers above the B2CL limit are reported one by
+// one; the limit came down from 2,50,000 to 1,00,000 rupees from 1 August
+// 2024 (Notification 12/2024-Central Tax)
+var (
+	b2clLimitChange  = time.Date(2024, time.August, 1, 0, 0, 0, 0, time.UTC)
+	b2clLimitBefore  = 250000.0
+	b2clLimitCurrent = 100000.0
+)
+
+// B2CLLimit returns the invoice value above which an inter-state supply to an
+// unregistered buyer dated date is a B2CL supply
+func B2CLLimit(date time.Time) float64 {
+	if date.Before(b2clLimitChange) {
+		return b2clLimitBefore
+	}
+	return b2clLimitCurrent
+}
+
+// HasRegisteredBuyer reports whether the invoice names a buyer GSTIN
+func (i *EInvoice) HasRegisteredBuyer() bool {
+	gstin := strings.ToUpper(strings.TrimSpace(i.BuyerDtls.Gstin))
+	return gstin != "" && gstin != "URP"
+}
+
+// GSTR1Section classifies the invoice into the GSTR-1 section it is reported
+// in, from its supply type, buyer GSTIN, place of supply and value. Credit
+// and debit notes are classified the same way, on their own value.
+func (i *EInvoice) GSTR1Section() string {
+	switch {
+	case strings.HasPrefix(i.TranDtls.SupTyp, "EXP"):
+		return GSTR1EXP
+	case i.HasRegisteredBuyer():
+		return GSTR1B2B
+	}
+	date, err := time.Parse("02/01/2006", i.DocDtls.Dt)
+	if err != nil {
+		date = time.Now()
+	}
+	if i.IsInterState() && i.ValDtls.TotInvVal > B2CLLimit(date) {
+		return GSTR1B2CL
+	}
+	return GSTR1B2CS
+}
@@ -0,0 +1,22 @@
+package models
+
+import "regexp"
+
+// HSNPattern matches 4, 6 or 8 digit HSN/SAC codes
+const HSNPattern = `^[0-9]{4}([0-9]{2}){0,2}$`
+
+// hsnRegex matches 4, 6 or 8 digit HSN/SAC codes
+var hsnRegex = regexp.MustCompile(HSNPattern)
+
+// HSNCode represents an entry in the HSN/SAC master
+type HSNCode struct {
+	Code        string   `json:"code" db:"code"`
+	Description string   `json:"description" db:"description"`
+	GSTRate     *float64 `json:"gst_rate" db:"gst_rate"`
+	IsService   bool     `json:"is_service" db:"is_service"`
+}
+
+// IsValidHSNFormat reports whether the given string is a well-formed HSN or SAC code
+func IsValidHSNFormat(code string) bool {
+	return hsnRegex.MatchString(code)
+}
@@ -0,0 +1,41 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Import kinds
+const (
+	ImportKindExcel = "excel"
+	ImportKindJSON  = "json"
+)
+
+// Import statuses
+const (
+	ImportStatusProcessing = "processing"
+	ImportStatusQueued     = "queued"
+	ImportStatusCompleted  = "completed"
+	ImportStatusPartial    = "partial"
+	ImportStatusFailed     = "failed"
+)
+
+// Import records an uploaded file, kept as uploaded, and the outcome of
+// importing it
+type Import struct {
+	ID            int             `json:"id" db:"id"`
+	UserID        int             `json:"user_id" db:"user_id"`
+	Kind          string          `json:"kind" db:"kind"`
+	Filename      string          `json:"filename" db:"filename"`
+	ContentType   string          `json:"content_type" db:"content_type"`
+	Size          int             `json:"size" db:"size"`
+	SHA256        string          `json:"sha256" db:"sha256"`
+	Status        string          `json:"status" db:"status"`
+	ImportedCount int             `json:"imported_count" db:"imported_count"`
+	FailedRows    int             `json:"failed_rows" db:"failed_rows"`
+	Attempts      int             `json:"attempts" db:"attempts"`
+	Result        json.RawMessage `json:"result,omitempty" db:"result"`
+	JobID         *int            `json:"job_id,omitempty" db:"job_id"`
+	CreatedAt     time.Time       `json:"created_at" db:"created_at"`
+	UpdatedAt     time.Time       `json:"updated_at" db:"updated_at"`
+}
@@ -0,0 +1,52 @@
+package models
+
+import "time"
+
+// Accounting services finalized invoices can be pushed to
+const (
+	IntegrationZohoBooks  = "zoho_books"
+	IntegrationQuickBooks = "quickbooks"
+)
+
+// Statuses of an integration
+const (
+	// IntegrationPending connections wait for the user to authorize them
+	IntegrationPending = "pending"
+	// IntegrationConnected connections push finalized invoices
+	IntegrationConnected = "connected"
+	// IntegrationExpired connections were revoked by the service and must be
+	// authorized again
+	IntegrationExpired = "expired"
+)
+
+// Statuses of an invoice's sync to an accounting service
+const (
+	InvoiceSyncSynced = "synced"
+	InvoiceSyncFailed = "failed"
+)
+
+// Integration is a user's connection to an accounting service. Its OAuth
+// tokens are stored encrypted and never returned.
+type Integration struct {
+	ID             int        `json:"id" db:"id"`
+	Provider       string     `json:"provider" db:"provider"`
+	Status         string     `json:"status" db:"status"`
+	OrganizationID string     `json:"organization_id" db:"organization_id"`
+	ConnectedAt    *time.Time `json:"connected_at" db:"connected_at"`
+	LastSyncAt     *time.Time `json:"last_sync_at" db:"last_sync_at"`
+	LastError      string     `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt      time.Time  `json:"created_at" db:"created_at"`
+}
+
+// InvoiceSync is the state of pushing an invoice to an accounting service
+type InvoiceSync struct {
+	ID         int        `json:"id" db:"id"`
+	InvoiceID  int        `json:"invoice_id" db:"invoice_id"`
+	Provider   string     `json:"provider" db:"provider"`
+	Status     string     `json:"status" db:"status"`
+	ExternalID string     `json:"external_id" db:"external_id"`
+	Attempts   int        `json:"attempts" db:"attempts"`
+	LastError  string     `json:"last_error,omitempty" db:"last_error"`
+	SyncedAt   *time.Time `json:"synced_at" db:"synced_at"`
+	UpdatedAt  time.Time  `json:"updated_at" db:"updated_at"`
+}
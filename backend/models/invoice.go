@@ -17,10 +17,29 @@ type Invoice struct {
 	QRCode     []byte    `json:"qr_code" db:"qr_code"`
 	Exported   bool      `json:"exported" db:"exported"`
 	ExportedAt *time.Time `json:"exported_at,omitempty" db:"exported_at"`
+	Status      string     `json:"status" db:"status"`
+	FinalizedAt *time.Time `json:"finalized_at,omitempty" db:"finalized_at"`
+	CancelReason string    `json:"cancel_reason,omitempty" db:"cancel_reason"`
+	CancelledAt *time.Time `json:"cancelled_at,omitempty" db:"cancelled_at"`
+	Irn          string     `json:"irn,omitempty" db:"irn"`
+	AckNo        string     `json:"ack_no,omitempty" db:"ack_no"`
+	AckDt        *time.Time `json:"ack_dt,omitempty" db:"ack_dt"`
+	SignedQRCode string     `json:"signed_qr_code,omitempty" db:"signed_qr_code"`
+	IrnCancelRemarks string `json:"irn_cancel_remarks,omitempty" db:"irn_cancel_remarks"`
+	IrnCancelledAt *time.Time `json:"irn_cancelled_at,omitempty" db:"irn_cancelled_at"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// Invoice statuses
+const (
+	InvoiceStatusDraft     = "draft"
+	InvoiceStatusFinalized = "finalized"
+	InvoiceStatusCancelled = "cancelled"
+	// Invoices awaiting an approver, when an accountant issues them in approval mode
+	InvoiceStatusPendingApproval = "pending_approval"
+)
+
 // CompanyDetails represents company information for sellers
 type CompanyDetails struct {
 	ID      int    `json:"id" db:"id"`
@@ -34,9 +53,33 @@ type CompanyDetails struct {
 	Phone   string `json:"phone" db:"phone"`
 	Email   string `json:"email" db:"email"`
 	IsDefault bool `json:"is_default" db:"is_default"`
+	QtyDecimals  int `json:"qty_decimals" db:"qty_decimals"`
+	RateDecimals int `json:"rate_decimals" db:"rate_decimals"`
+	Rounding     string `json:"rounding" db:"rounding"`
+	RoundingMethod string `json:"rounding_method" db:"rounding_method"`
+	RoundOff     bool   `json:"round_off" db:"round_off"`
+	UPIVPA       string `json:"upi_vpa" db:"upi_vpa"`
+	UPIPayeeName string `json:"upi_payee_name" db:"upi_payee_name"`
+	// ValidationProfile is the profile drafts are validated under, strict or lenient
+	ValidationProfile string `json:"validation_profile" db:"validation_profile"`
+	// LUTNo is the letter of undertaking exports and SEZ supplies without
+	// payment of tax are made under in the financial year LUTFiscalYear
+	LUTNo         string `json:"lut_no" db:"lut_no"`
+	LUTFiscalYear string `json:"lut_fy" db:"lut_fy"`
 	CreatedAt time.Time `json:"created_at" db:"created_at"`
 }
 
+// Precision returns the decimal precision configured for the company
+func (c *CompanyDetails) Precision() Precision {
+	return Precision{
+		Quantity:  c.QtyDecimals,
+		UnitPrice: c.RateDecimals,
+		Rounding:  c.Rounding,
+		Method:    c.RoundingMethod,
+		RoundOff:  c.RoundOff,
+	}
+}
+
 // CustomerDetails represents customer information for buyers
 type CustomerDetails struct {
 	ID      int    `json:"id" db:"id"`
@@ -76,6 +119,13 @@ type EInvoice struct {
 	ItemList  []Item    `json:"ItemList"`
 	ValDtls   ValDtls   `json:"ValDtls"`
 	ExpDtls   ExpDtls   `json:"ExpDtls"`
+	RefDtls   *RefDtls  `json:"RefDtls,omitempty"`
+	DispDtls  *DispDtls `json:"DispDtls,omitempty"`
+	ShipDtls  *ShipDtls `json:"ShipDtls,omitempty"`
+	PayDtls   *PayDtls  `json:"PayDtls,omitempty"`
+	AddlDocDtls []AddlDocDtls `json:"AddlDocDtls,omitempty"`
+	EwbDtls   *EwbDtls  `json:"EwbDtls,omitempty"`
+	FxDtls    *FxDtls   `json:"FxDtls,omitempty"`
 }
 
 // TranDtls contains transaction details
@@ -83,6 +133,8 @@ type TranDtls struct {
 	TaxSch  string `json:"TaxSch"`
 	SupTyp  string `json:"SupTyp"`
 	RegRev  string `json:"RegRev"`
+	EcmGstin string `json:"EcmGstin,omitempty"`
+	IgstOnIntra string `json:"IgstOnIntra,omitempty"`
 }
 
 // DocDtls contains document details
@@ -102,6 +154,8 @@ type SellerDtls struct {
 	Loc   string `json:"Loc"`
 	Pin   int    `json:"Pin"`
 	Stcd  string `json:"Stcd"`
+	Ph    string `json:"Ph,omitempty"`
+	Em    string `json:"Em,omitempty"`
 }
 
 // BuyerDtls contains buyer details
@@ -115,6 +169,8 @@ type BuyerDtls struct {
 	Loc   string `json:"Loc"`
 	Pin   int    `json:"Pin"`
 	Stcd  string `json:"Stcd"`
+	Ph    string `json:"Ph,omitempty"`
+	Em    string `json:"Em,omitempty"`
 }
 
 // Item represents an invoice line item
@@ -126,70 +182,268 @@ type Item struct {
 	Qty       float64 `json:"Qty"`
 	Unit      string  `json:"Unit"`
 	UnitPrice float64 `json:"UnitPrice"`
+	// UnitPriceFc is the price in the invoice's foreign currency, see FxDtls
+	UnitPriceFc float64 `json:"UnitPriceFc,omitempty"`
 	TotAmt    float64 `json:"TotAmt"`
 	AssAmt    float64 `json:"AssAmt"`
 	GstRt     float64 `json:"GstRt"`
+	// Exemption flags a 0% item as nil-rated, exempt or non-GST, see ExemptionNilRated
+	Exemption string  `json:"Exemption,omitempty"`
 	IgstAmt   float64 `json:"IgstAmt"`
+	CgstAmt   float64 `json:"CgstAmt,omitempty"`
+	SgstAmt   float64 `json:"SgstAmt,omitempty"`
 	TotItemVal float64 `json:"TotItemVal"`
+	PrdSlNo   string  `json:"PrdSlNo,omitempty"`
+	Barcde    string  `json:"Barcde,omitempty"`
+	FreeQty   float64 `json:"FreeQty,omitempty"`
+	OrdLineRef string `json:"OrdLineRef,omitempty"`
+	OrgCntry  string  `json:"OrgCntry,omitempty"`
+	BchDtls   *BchDtls `json:"BchDtls,omitempty"`
+	AttribDtls []AttribDtls `json:"AttribDtls,omitempty"`
 }
 
 // ValDtls contains value details
 type ValDtls struct {
 	AssVal    float64 `json:"AssVal"`
 	IgstVal   float64 `json:"IgstVal"`
+	CgstVal   float64 `json:"CgstVal,omitempty"`
+	SgstVal   float64 `json:"SgstVal,omitempty"`
 	TotInvVal float64 `json:"TotInvVal"`
+	RndOffAmt float64 `json:"RndOffAmt,omitempty"`
+	TotInvValFc float64 `json:"TotInvValFc,omitempty"`
 }
 
 // ExpDtls contains export details
 type ExpDtls struct {
+	ShipBNo string      `json:"ShipBNo,omitempty"`
+	ShipBDt string      `json:"ShipBDt,omitempty"`
+	Port    string      `json:"Port,omitempty"`
+	RefClm  string      `json:"RefClm,omitempty"`
 	ForCur  interface{} `json:"ForCur"`
 	CntCode interface{} `json:"CntCode"`
+	ExpDuty float64     `json:"ExpDuty,omitempty"`
 }
 
-// Validate checks if the invoice data is valid
-func (i *EInvoice) Validate() error {
-	// Validate GSTIN format (15 characters)
-	gstinRegex := regexp.MustCompile(`^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z]{1}[0-9A-Z]{1}Z[0-9A-Z]{1}$`)
-	if !gstinRegex.MatchString(i.SellerDtls.Gstin) {
-		return errors.New("invalid seller GSTIN format")
+// GSTINPattern matches the 15 character GSTIN format
+const GSTINPattern = `^[0-9]{2}[A-Z]{5}[0-9]{4}[A-Z]{1}[0-9A-Z]{1}Z[0-9A-Z]{1}$`
+
+// gstinRegex matches the 15 character GSTIN format
+var gstinRegex = regexp.MustCompile(GSTINPattern)
+
+// IsValidGSTIN reports whether the given string is a well-formed GSTIN
+func IsValidGSTIN(gstin string) bool {
+	return gstinRegex.MatchString(gstin)
+}
+
+// RefDtls contains remarks and references to preceding documents and contracts
+type RefDtls struct {
+	InvRm       string        `json:"InvRm,omitempty"`
+	DocPerdDtls *DocPerdDtls  `json:"DocPerdDtls,omitempty"`
+	PrecDocDtls []PrecDocDtls `json:"PrecDocDtls,omitempty"`
+	ContrDtls   []ContrDtls   `json:"ContrDtls,omitempty"`
+}
+
+// DocPerdDtls contains the period the invoice covers
+type DocPerdDtls struct {
+	InvStDt  string `json:"InvStDt"`
+	InvEndDt string `json:"InvEndDt"`
+}
+
+// PrecDocDtls contains details of a preceding document
+type PrecDocDtls struct {
+	InvNo    string `json:"InvNo"`
+	InvDt    string `json:"InvDt"`
+	OthRefNo string `json:"OthRefNo,omitempty"`
+}
+
+// ContrDtls contains purchase order and contract references
+type ContrDtls struct {
+	RecAdvRefr string `json:"RecAdvRefr,omitempty"`
+	RecAdvDt   string `json:"RecAdvDt,omitempty"`
+	TendRefr   string `json:"TendRefr,omitempty"`
+	ContrRefr  string `json:"ContrRefr,omitempty"`
+	ExtRefr    string `json:"ExtRefr,omitempty"`
+	ProjRefr   string `json:"ProjRefr,omitempty"`
+	PORefr     string `json:"PORefr,omitempty"`
+	PORefDt    string `json:"PORefDt,omitempty"`
+}
+
+// Remarks returns the invoice remarks, if any
+func (i *EInvoice) Remarks() string {
+	if i.RefDtls == nil {
+		return ""
 	}
+	return i.RefDtls.InvRm
+}
 
-	// Validate items
-	for _, item := range i.ItemList {
-		if item.Qty < 0 {
-			return errors.New("quantity cannot be negative")
+// PONumber returns the first purchase order reference on the invoice, if any
+func (i *EInvoice) PONumber() string {
+	if i.RefDtls == nil {
+		return ""
+	}
+	for _, contr := range i.RefDtls.ContrDtls {
+		if contr.PORefr != "" {
+			return contr.PORefr
 		}
-		if item.UnitPrice <= 0 {
-			return errors.New("unit price must be greater than zero")
+	}
+	return ""
+}
+
+// PODate returns the date of the first purchase order reference on the invoice, if any
+func (i *EInvoice) PODate() string {
+	if i.RefDtls == nil {
+		return ""
+	}
+	for _, contr := range i.RefDtls.ContrDtls {
+		if contr.PORefr != "" {
+			return contr.PORefDt
 		}
 	}
+	return ""
+}
 
+// validate checks reference fields against the NIC schema length limits
+func (r *RefDtls) validate() error {
+	if len(r.InvRm) > 100 {
+		return errors.New("remarks cannot exceed 100 characters")
+	}
+	for _, prec := range r.PrecDocDtls {
+		if prec.InvNo == "" || len(prec.InvNo) > 16 {
+			return errors.New("preceding document number must be 1 to 16 characters")
+		}
+	}
+	for _, contr := range r.ContrDtls {
+		if len(contr.PORefr) > 16 {
+			return errors.New("PO reference cannot exceed 16 characters")
+		}
+		for _, ref := range []string{contr.RecAdvRefr, contr.TendRefr, contr.ContrRefr, contr.ExtRefr, contr.ProjRefr} {
+			if len(ref) > 20 {
+				return errors.New("contract references cannot exceed 20 characters")
+			}
+		}
+	}
 	return nil
 }
 
-// CalculateTotals calculates and updates all totals in the invoice
+// Validate checks if the invoice data is valid, returning the first issue found
+func (i *EInvoice) Validate() error {
+	if issues := i.Issues(); len(issues) > 0 {
+		return errors.New(issues[0].Message)
+	}
+	return nil
+}
+
+// CalculateTotals calculates and updates all totals in the invoice using the default precision
 func (i *EInvoice) CalculateTotals() {
-	var totalAssVal float64
-	var totalIgstVal float64
+	i.CalculateTotalsWithPrecision(DefaultPrecision)
+}
+
+// CalculateTotalsWithPrecision calculates and updates all totals in the invoice,
+// rounding quantities and rates to the given precision and amounts to two decimals.
+// Inter-state supplies are taxed as IGST, and supplies within the state as
+// equal CGST and SGST, each at half the GST rate.
+// Tax is rounded per line or on the invoice totals according to the rounding rule,
+// halves by the rounding method, and the total to whole rupees with RoundOff.
+// Amounts are calculated in whole paise, so the totals add up exactly.
+// Foreign currency prices are converted at the invoice's exchange rate first.
+// Supplies without payment of tax keep their GST rates but carry no tax.
+func (i *EInvoice) CalculateTotalsWithPrecision(p Precision) {
+	i.ApplyExchangeRate(p)
+
+	interState := i.IsInterState()
+	assAmts := make([]int64, len(i.ItemList))
+	// taxAmts are the lines' IGST or, within the state, their CGST, which SGST equals
+	taxAmts := make([]int64, len(i.ItemList))
 
 	for j := range i.ItemList {
+		item := &i.ItemList[j]
+
+		// Round inputs to the configured precision
+		qty := scaledBy(item.Qty, p.Quantity, p.Method)
+		unitPrice := scaledBy(item.UnitPrice, p.UnitPrice, p.Method)
+		item.Qty = unscaled(qty, p.Quantity)
+		item.UnitPrice = unscaled(unitPrice, p.UnitPrice)
+
 		// Calculate total amount
-		i.ItemList[j].TotAmt = i.ItemList[j].Qty * i.ItemList[j].UnitPrice
-		i.ItemList[j].AssAmt = i.ItemList[j].TotAmt
-		
-		// Calculate IGST amount
-		i.ItemList[j].IgstAmt = i.ItemList[j].AssAmt * i.ItemList[j].GstRt / 100
-		
+		assAmts[j] = lineAmount(qty, p.Quantity, unitPrice, p.UnitPrice, p.Method)
+
+		// Calculate the tax of each head
+		if !i.IsWithoutPayment() {
+			taxAmts[j] = taxAmount(assAmts[j], headRate(item.GstRt, interState), p.Method)
+		}
+	}
+
+	if p.Rounding == RoundingInvoice && !i.IsWithoutPayment() {
+		i.roundTaxOnTotals(assAmts, taxAmts, interState, p.Method)
+	}
+
+	var totalAssVal, totalIgstVal, totalCgstVal, totalTax int64
+	for j := range i.ItemList {
+		item := &i.ItemList[j]
+		item.TotAmt = Rupees(assAmts[j])
+		item.AssAmt = item.TotAmt
+		lineTax := taxAmts[j]
+		if interState {
+			item.IgstAmt, item.CgstAmt, item.SgstAmt = Rupees(taxAmts[j]), 0, 0
+			totalIgstVal += taxAmts[j]
+		} else {
+			item.IgstAmt, item.CgstAmt, item.SgstAmt = 0, Rupees(taxAmts[j]), Rupees(taxAmts[j])
+			totalCgstVal += taxAmts[j]
+			lineTax *= 2
+		}
+
 		// Calculate total item value
-		i.ItemList[j].TotItemVal = i.ItemList[j].AssAmt + i.ItemList[j].IgstAmt
-		
+		item.TotItemVal = Rupees(assAmts[j] + lineTax)
+
 		// Add to invoice totals
-		totalAssVal += i.ItemList[j].AssAmt
-		totalIgstVal += i.ItemList[j].IgstAmt
+		totalAssVal += assAmts[j]
+		totalTax += lineTax
 	}
 
 	// Update invoice value details
-	i.ValDtls.AssVal = totalAssVal
-	i.ValDtls.IgstVal = totalIgstVal
-	i.ValDtls.TotInvVal = totalAssVal + totalIgstVal
-} 
\ No newline at end of file
+	i.ValDtls.AssVal = Rupees(totalAssVal)
+	i.ValDtls.IgstVal = Rupees(totalIgstVal)
+	i.ValDtls.CgstVal = Rupees(totalCgstVal)
+	i.ValDtls.SgstVal = Rupees(totalCgstVal)
+	var roundOff int64
+	if p.RoundOff {
+		roundOff = roundOffAmount(totalAssVal+totalTax, p.Method)
+	}
+	i.ValDtls.RndOffAmt = Rupees(roundOff)
+	i.ValDtls.TotInvVal = Rupees(totalAssVal + totalTax + roundOff)
+	i.ValDtls.TotInvValFc = 0
+	if i.FxDtls != nil && i.FxDtls.Rate > 0 {
+		i.ValDtls.TotInvValFc = Round(i.ValDtls.TotInvVal/i.FxDtls.Rate, AmountDecimals)
+	}
+}
+
+// roundTaxOnTotals recalculates the tax on the total assessable value of each
+// GST rate and moves the rounding difference to the largest line of that rate,
+// so the line taxes still add up to the invoice tax. Amounts are in paise, and
+// taxAmts are of one head as in CalculateTotalsWithPrecision.
+func (i *EInvoice) roundTaxOnTotals(assAmts, taxAmts []int64, interState bool, method string) {
+	type rateGroup struct {
+		assVal  int64
+		taxVal  int64
+		largest int
+	}
+
+	groups := make(map[int64]*rateGroup)
+	for j, item := range i.ItemList {
+		rate := headRate(item.GstRt, interState)
+		g, ok := groups[rate]
+		if !ok {
+			g = &rateGroup{largest: j}
+			groups[rate] = g
+		}
+		g.assVal += assAmts[j]
+		g.taxVal += taxAmts[j]
+		if assAmts[j] > assAmts[g.largest] {
+			g.largest = j
+		}
+	}
+
+	for rate, g := range groups {
+		taxAmts[g.largest] += taxAmount(g.assVal, rate, method) - g.taxVal
+	}
+}
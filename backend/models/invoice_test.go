@@ -0,0 +1,158 @@
+package models
+
+import "testing"
+
+// testInvoice returns an invoice from a seller in Maharashtra (27) to a buyer
+// whose place of supply is pos, with n identical items
+func testInvoice(typ, pos string, n int, unitPrice, gstRate float64) *EInvoice {
+	invoice := &EInvoice{
+		Version:    "1.1",
+		TranDtls:   TranDtls{TaxSch: "GST", SupTyp: SupplyB2B},
+		DocDtls:    DocDtls{Typ: typ, No: "T/1", Dt: "01/04/2025"},
+		SellerDtls: SellerDtls{Gstin: "27AADCS0472N1Z1", Stcd: "27"},
+		BuyerDtls:  BuyerDtls{Gstin: "29AABCS1234Z1Z1", Pos: pos, Stcd: pos},
+	}
+	for j := 0; j < n; j++ {
+		invoice.ItemList = append(invoice.ItemList, Item{
+			SlNo: string(rune('1' + j)), HsnCd: "5310", Qty: 1, UnitPrice: unitPrice, GstRt: gstRate,
+		})
+	}
+	return invoice
+}
+
+func TestCalculateTotalsWithPrecision(t *testing.T) {
+	line := DefaultPrecision
+	lineEven := Precision{Quantity: 2, UnitPrice: 2, Rounding: RoundingLine, Method: RoundHalfEven}
+	invoiceRounding := Precision{Quantity: 2, UnitPrice: 2, Rounding: RoundingInvoice, Method: RoundHalfUp}
+	roundOff := Precision{Quantity: 2, UnitPrice: 2, Rounding: RoundingLine, Method: RoundHalfEven, RoundOff: true}
+
+	tests := []struct {
+		name      string
+		invoice   *EInvoice
+		precision Precision
+		igst      float64
+		cgst      float64
+		sgst      float64
+		roundOff  float64
+		total     float64
+		// lineTax is the tax of each item over all heads
+		lineTax []float64
+	}{
+		{
+			name: "inter-state", invoice: testInvoice("INV", "29", 1, 100.50, 18), precision: line,
+			igst: 18.09, total: 118.59, lineTax: []float64{18.09},
+		},
+		{
+			name: "within the state, half up", invoice: testInvoice("INV", "27", 1, 100.50, 18), precision: line,
+			cgst: 9.05, sgst: 9.05, total: 118.60, lineTax: []float64{18.10},
+		},
+		{
+			name: "within the state, half even", invoice: testInvoice("INV", "27", 1, 100.50, 18), precision: lineEven,
+			cgst: 9.04, sgst: 9.04, total: 118.58, lineTax: []float64{18.08},
+		},
+		{
+			name: "round off", invoice: testInvoice("INV", "27", 1, 100.50, 18), precision: roundOff,
+			cgst: 9.04, sgst: 9.04, roundOff: 0.42, total: 119, lineTax: []float64{18.08},
+		},
+		{
+			name: "line rounding, inter-state", invoice: testInvoice("INV", "29", 3, 0.03, 18), precision: line,
+			igst: 0.03, total: 0.12, lineTax: []float64{0.01, 0.01, 0.01},
+		},
+		{
+			name: "invoice rounding, inter-state", invoice: testInvoice("INV", "29", 3, 0.03, 18), precision: invoiceRounding,
+			igst: 0.02, total: 0.11, lineTax: []float64{0, 0.01, 0.01},
+		},
+		{
+			name: "line rounding, within the state", invoice: testInvoice("INV", "27", 3, 0.03, 18), precision: line,
+			total: 0.09, lineTax: []float64{0, 0, 0},
+		},
+		{
+			name: "invoice rounding, within the state", invoice: testInvoice("INV", "27", 3, 0.03, 18), precision: invoiceRounding,
+			cgst: 0.01, sgst: 0.01, total: 0.11, lineTax: []float64{0.02, 0, 0},
+		},
+		{
+			name: "credit note within the state", invoice: testInvoice("CRN", "27", 2, 100.50, 18), precision: line,
+			cgst: 18.10, sgst: 18.10, total: 237.20, lineTax: []float64{18.10, 18.10},
+		},
+		{
+			name: "credit note, invoice rounding", invoice: testInvoice("CRN", "29", 3, 0.03, 18), precision: invoiceRounding,
+			igst: 0.02, total: 0.11, lineTax: []float64{0, 0.01, 0.01},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.invoice
+			i.CalculateTotalsWithPrecision(tt.precision)
+
+			v := i.ValDtls
+			if v.IgstVal != tt.igst || v.CgstVal != tt.cgst || v.SgstVal != tt.sgst {
+				t.Errorf("IGST, CGST, SGST = %v, %v, %v, want %v, %v, %v",
+					v.IgstVal, v.CgstVal, v.SgstVal, tt.igst, tt.cgst, tt.sgst)
+			}
+			if v.RndOffAmt != tt.roundOff || v.TotInvVal != tt.total {
+				t.Errorf("RndOffAmt, TotInvVal = %v, %v, want %v, %v", v.RndOffAmt, v.TotInvVal, tt.roundOff, tt.total)
+			}
+			for j, item := range i.ItemList {
+				if got := item.TaxAmt(); got != tt.lineTax[j] {
+					t.Errorf("item %d tax = %v, want %v", j+1, got, tt.lineTax[j])
+				}
+				if item.CgstAmt != item.SgstAmt {
+					t.Errorf("item %d CGST %v differs from SGST %v", j+1, item.CgstAmt, item.SgstAmt)
+				}
+			}
+			if err := i.CheckTotals(); err != nil {
+				t.Errorf("CheckTotals() = %v", err)
+			}
+		})
+	}
+}
+
+func TestCalculateTotalsWithoutPayment(t *testing.T) {
+	invoice := testInvoice("INV", "27", 1, 100.50, 18)
+	invoice.TranDtls.SupTyp = SupplySEZWOP
+	invoice.CalculateTotals()
+	if tax := invoice.TotalTax(); tax != 0 || invoice.ValDtls.TotInvVal != 100.50 {
+		t.Errorf("tax, TotInvVal = %v, %v, want 0, 100.5", tax, invoice.ValDtls.TotInvVal)
+	}
+}
+
+func TestTaxVals(t *testing.T) {
+	tests := []struct {
+		name             string
+		pos              string
+		valDtls          ValDtls
+		igst, cgst, sgst float64
+		total            float64
+	}{
+		{"inter-state", "29", ValDtls{IgstVal: 18.09}, 18.09, 0, 0, 18.09},
+		{"within the state", "27", ValDtls{CgstVal: 9.05, SgstVal: 9.05}, 0, 9.05, 9.05, 18.10},
+		// Stored before CGST and SGST were calculated
+		{"earlier invoice within the state", "27", ValDtls{IgstVal: 18.09}, 0, 9.05, 9.04, 18.09},
+		{"earlier credit note within the state", "27", ValDtls{IgstVal: 0.01}, 0, 0.01, 0, 0.01},
+		{"no tax", "27", ValDtls{}, 0, 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := testInvoice("INV", tt.pos, 1, 100, 18)
+			invoice.ValDtls = tt.valDtls
+			igst, cgst, sgst := invoice.TaxVals()
+			if igst != tt.igst || cgst != tt.cgst || sgst != tt.sgst {
+				t.Errorf("TaxVals() = %v, %v, %v, want %v, %v, %v", igst, cgst, sgst, tt.igst, tt.cgst, tt.sgst)
+			}
+			if got := invoice.TotalTax(); got != tt.total {
+				t.Errorf("TotalTax() = %v, want %v", got, tt.total)
+			}
+		})
+	}
+}
+
+func TestAmountPayableUnderReverseCharge(t *testing.T) {
+	for _, pos := range []string{"27", "29"} {
+		invoice := testInvoice("CRN", pos, 1, 100.50, 18)
+		invoice.TranDtls.RegRev = "Y"
+		invoice.CalculateTotals()
+		if got := invoice.AmountPayable(); got != 100.50 {
+			t.Errorf("place of supply %s: AmountPayable() = %v, want 100.5", pos, got)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Job statuses
+const (
+	JobStatusPending   = "pending"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusDead      = "dead"
+)
+
+// Job represents a unit of background work such as an import or webhook delivery
+type Job struct {
+	ID          int             `json:"id" db:"id"`
+	UserID      *int            `json:"user_id,omitempty" db:"user_id"`
+	Type        string          `json:"type" db:"type"`
+	Payload     json.RawMessage `json:"payload" db:"payload"`
+	Status      string          `json:"status" db:"status"`
+	Attempts    int             `json:"attempts" db:"attempts"`
+	MaxAttempts int             `json:"max_attempts" db:"max_attempts"`
+	RunAt       time.Time       `json:"run_at" db:"run_at"`
+	// LockedAt is when the worker running the job last refreshed its lock
+	LockedAt  *time.Time `json:"locked_at,omitempty" db:"locked_at"`
+	LastError string     `json:"last_error,omitempty" db:"last_error"`
+	ErrorLog  []JobError `json:"error_log" db:"error_log"`
+	DeadAt    *time.Time `json:"dead_at,omitempty" db:"dead_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// JobError records the failure of a single job attempt
+type JobError struct {
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
+}
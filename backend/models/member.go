@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Roles of account members. Owners have every right of both roles.
+const (
+	// MemberRoleAccountant members prepare invoices; in approval mode the
+	// invoices they issue wait for an approver
+	MemberRoleAccountant = "accountant"
+	// MemberRoleApprover members finalize or reject invoices awaiting approval
+	MemberRoleApprover = "approver"
+)
+
+// IsValidMemberRole reports whether role is a member role
+func IsValidMemberRole(role string) bool {
+	return role == MemberRoleAccountant || role == MemberRoleApprover
+}
+
+// AccountMember is a user who works on another user's account. Members see
+// and change the owner's invoices and masters, but not the owner's profile,
+// settings or credentials.
+type AccountMember struct {
+	ID        int       `json:"id" db:"id"`
+	OwnerID   int       `json:"owner_id" db:"owner_id"`
+	MemberID  int       `json:"member_id" db:"member_id"`
+	Email     string    `json:"email" db:"email"`
+	Name      string    `json:"name" db:"name"`
+	Role      string    `json:"role" db:"role"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Approval actions recorded on an invoice
+const (
+	ApprovalSubmitted = "submitted"
+	ApprovalApproved  = "approved"
+	ApprovalRejected  = "rejected"
+)
+
+// InvoiceApproval is a step of an invoice's approval history
+type InvoiceApproval struct {
+	ID         int       `json:"id" db:"id"`
+	InvoiceID  int       `json:"invoice_id" db:"invoice_id"`
+	ActorID    *int      `json:"actor_id" db:"actor_id"`
+	ActorEmail string    `json:"actor_email" db:"actor_email"`
+	Action     string    `json:"action" db:"action"`
+	Comment    string    `json:"comment" db:"comment"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
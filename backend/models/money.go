@@ -0,0 +1,176 @@
+package models
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// Amounts are calculated as integers in units of 10^-places (paise for
+// amounts, thousandths for quantities, rates and GST rates) so totals are
+// exact, and only converted back to float64 for the JSON schema.
+
+// TotalsTolerance is the difference in rupees the IRP accepts between a total
+// and the sum of its parts
+const TotalsTolerance = 1.0
+
+// rateDecimals is the precision of GST rates, e.g. 0.25 or 0.1
+const rateDecimals = 3
+
+// pow10 returns 10^n for small n
+func pow10(n int) int64 {
+	p := int64(1)
+	for ; n > 0; n-- {
+		p *= 10
+	}
+	return p
+}
+
+// scaled returns value as an integer number of 10^-places units, rounded half
+// away from zero. It starts from the shortest decimal that represents the float,
+// so 1.005 rounds to 1.01 as written rather than to the 1.00 that float
+// arithmetic gives for its binary approximation 1.00499999...
+func scaled(value float64, places int) int64 {
+	return scaledBy(value, places, RoundHalfUp)
+}
+
+// scaledBy is scaled with halves rounded by the given method
+func scaledBy(value float64, places int, method string) int64 {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0
+	}
+	s := strconv.FormatFloat(math.Abs(value), 'f', -1, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	for len(frac) <= places {
+		frac += "0"
+	}
+	n, err := strconv.ParseInt(whole+frac[:places], 10, 64)
+	if err != nil {
+		// Beyond int64; no invoice amount comes near this
+		n = math.MaxInt64 / 2
+	}
+	if roundsUp(frac[places], strings.Trim(frac[places+1:], "0") != "", n, method) {
+		n++
+	}
+	if value < 0 {
+		n = -n
+	}
+	return n
+}
+
+// roundsUp reports whether a truncated magnitude n is rounded up, given the
+// first dropped digit and whether any later dropped digit is nonzero
+func roundsUp(digit byte, more bool, n int64, method string) bool {
+	if digit != '5' || more {
+		return digit >= '5'
+	}
+	// An exact half
+	return method != RoundHalfEven || n%2 != 0
+}
+
+// unscaled converts a number of 10^-places units back to a float, the one
+// nearest to the exact decimal
+func unscaled(n int64, places int) float64 {
+	return float64(n) / float64(pow10(places))
+}
+
+// mulDivRound returns a*b/d rounded half away from zero, without overflow in a*b
+func mulDivRound(a, b, d int64) int64 {
+	return mulDivRoundBy(a, b, d, RoundHalfUp)
+}
+
+// mulDivRoundBy is mulDivRound with halves rounded by the given method
+func mulDivRoundBy(a, b, d int64, method string) int64 {
+	num := new(big.Int).Mul(big.NewInt(a), big.NewInt(b))
+	den := big.NewInt(d)
+	q, r := new(big.Int).QuoRem(num, den, new(big.Int))
+	// Round away from zero when the remainder is more than half the divisor,
+	// and for exactly half unless rounding to even keeps an even quotient
+	cmp := new(big.Int).Abs(new(big.Int).Mul(r, big.NewInt(2))).Cmp(den)
+	if cmp > 0 || cmp == 0 && (method != RoundHalfEven || q.Bit(0) != 0) {
+		if num.Sign() < 0 {
+			q.Sub(q, big.NewInt(1))
+		} else {
+			q.Add(q, big.NewInt(1))
+		}
+	}
+	return q.Int64()
+}
+
+// Paise returns an amount in rupees as a whole number of paise
+func Paise(amount float64) int64 {
+	return scaled(amount, AmountDecimals)
+}
+
+// Rupees converts a number of paise to rupees
+func Rupees(paise int64) float64 {
+	return unscaled(paise, AmountDecimals)
+}
+
+// lineAmount returns quantity times unit price in paise, from a quantity and
+// rate given in 10^-qtyPlaces and 10^-pricePlaces units
+func lineAmount(qty int64, qtyPlaces int, price int64, pricePlaces int, method string) int64 {
+	return mulDivRoundBy(qty, price, pow10(qtyPlaces+pricePlaces-AmountDecimals), method)
+}
+
+// taxAmount returns the tax in paise at a GST rate on an assessable value in paise
+func taxAmount(assessable, rate int64, method string) int64 {
+	return mulDivRoundBy(assessable, rate, 100*pow10(rateDecimals), method)
+}
+
+// roundOffAmount returns the paise to add to a total to round it to whole
+// rupees, the RndOffAmt of paise-less invoices
+func roundOffAmount(total int64, method string) int64 {
+	rupees := pow10(AmountDecimals)
+	return mulDivRoundBy(total, 1, rupees, method)*rupees - total
+}
+
+// withinTolerance reports whether two amounts in paise differ by at most TotalsTolerance
+func withinTolerance(a, b int64) bool {
+	diff := a - b
+	if diff < 0 {
+		diff = -diff
+	}
+	return diff <= Paise(TotalsTolerance)
+}
+
+// CheckTotals reports the first line or invoice total that differs from the
+// sum of its parts by more than the IRP's tolerance of one rupee, as the
+// portal rejects such invoices
+func (i *EInvoice) CheckTotals() error {
+	var assVal, igstVal, cgstVal, sgstVal int64
+	for _, item := range i.ItemList {
+		tot := lineAmount(scaled(item.Qty, rateDecimals), rateDecimals, scaled(item.UnitPrice, rateDecimals), rateDecimals, RoundHalfUp)
+		if !withinTolerance(Paise(item.TotAmt), tot) {
+			return fmt.Errorf("item %s: TotAmt %.2f does not match quantity times unit price %.2f", item.SlNo, item.TotAmt, Rupees(tot))
+		}
+		if !withinTolerance(Paise(item.TotItemVal), Paise(item.AssAmt)+Paise(item.TaxAmt())) {
+			return fmt.Errorf("item %s: TotItemVal %.2f does not match AssAmt plus tax", item.SlNo, item.TotItemVal)
+		}
+		assVal += Paise(item.AssAmt)
+		igstVal += Paise(item.IgstAmt)
+		cgstVal += Paise(item.CgstAmt)
+		sgstVal += Paise(item.SgstAmt)
+	}
+	if !withinTolerance(Paise(i.ValDtls.AssVal), assVal) {
+		return fmt.Errorf("AssVal %.2f does not match the items' total %.2f", i.ValDtls.AssVal, Rupees(assVal))
+	}
+	for _, head := range []struct {
+		name       string
+		total, sum int64
+	}{
+		{"IgstVal", Paise(i.ValDtls.IgstVal), igstVal},
+		{"CgstVal", Paise(i.ValDtls.CgstVal), cgstVal},
+		{"SgstVal", Paise(i.ValDtls.SgstVal), sgstVal},
+	} {
+		if !withinTolerance(head.total, head.sum) {
+			return fmt.Errorf("%s %.2f does not match the items' total %.2f", head.name, Rupees(head.total), Rupees(head.sum))
+		}
+	}
+	if !withinTolerance(Paise(i.ValDtls.TotInvVal), Paise(i.ValDtls.AssVal)+Paise(i.TotalTax())+Paise(i.ValDtls.RndOffAmt)) {
+		return fmt.Errorf("TotInvVal %.2f does not match AssVal plus tax and RndOffAmt", i.ValDtls.TotInvVal)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestScaledBy(t *testing.T) {
+	tests := []struct {
+		value  float64
+		places int
+		method string
+		want   int64
+	}{
+		{1.005, 2, RoundHalfUp, 101},
+		{1.005, 2, RoundHalfEven, 100},
+		{2.345, 2, RoundHalfUp, 235},
+		{2.345, 2, RoundHalfEven, 234},
+		{2.355, 2, RoundHalfEven, 236},
+		{2.3451, 2, RoundHalfEven, 235},
+		{-2.345, 2, RoundHalfUp, -235},
+		{-2.345, 2, RoundHalfEven, -234},
+		{-2.355, 2, RoundHalfEven, -236},
+		{0.0049, 2, RoundHalfUp, 0},
+		{12.5, 0, RoundHalfEven, 12},
+		{13.5, 0, RoundHalfEven, 14},
+		{1.2345, 3, RoundHalfUp, 1235},
+	}
+	for _, tt := range tests {
+		if got := scaledBy(tt.value, tt.places, tt.method); got != tt.want {
+			t.Errorf("scaledBy(%v, %d, %s) = %d, want %d", tt.value, tt.places, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestMulDivRoundBy(t *testing.T) {
+	tests := []struct {
+		a, b, d int64
+		method  string
+		want    int64
+	}{
+		{5, 1, 10, RoundHalfUp, 1},
+		{5, 1, 10, RoundHalfEven, 0},
+		{15, 1, 10, RoundHalfEven, 2},
+		{7, 1, 10, RoundHalfEven, 1},
+		{4, 1, 10, RoundHalfUp, 0},
+		{-5, 1, 10, RoundHalfUp, -1},
+		{-5, 1, 10, RoundHalfEven, 0},
+		{-15, 1, 10, RoundHalfEven, -2},
+		{-7, 1, 10, RoundHalfUp, -1},
+		{-4, 1, 10, RoundHalfUp, 0},
+		// 100.50 at 9% is 904.5 paise
+		{10050, 9000, 100000, RoundHalfUp, 905},
+		{10050, 9000, 100000, RoundHalfEven, 904},
+	}
+	for _, tt := range tests {
+		if got := mulDivRoundBy(tt.a, tt.b, tt.d, tt.method); got != tt.want {
+			t.Errorf("mulDivRoundBy(%d, %d, %d, %s) = %d, want %d", tt.a, tt.b, tt.d, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestPaiseAndRupees(t *testing.T) {
+	tests := []struct {
+		rupees float64
+		paise  int64
+	}{
+		{0, 0},
+		{0.01, 1},
+		{118.59, 11859},
+		{-0.005, -1},
+		{-18.09, -1809},
+		{-118.594, -11859},
+	}
+	for _, tt := range tests {
+		if got := Paise(tt.rupees); got != tt.paise {
+			t.Errorf("Paise(%v) = %d, want %d", tt.rupees, got, tt.paise)
+		}
+	}
+	if got := Rupees(-1809); got != -18.09 {
+		t.Errorf("Rupees(-1809) = %v, want -18.09", got)
+	}
+}
+
+func TestRoundOffAmount(t *testing.T) {
+	tests := []struct {
+		total  int64
+		method string
+		want   int64
+	}{
+		{11859, RoundHalfUp, 41},
+		{11849, RoundHalfUp, -49},
+		{11850, RoundHalfUp, 50},
+		{11850, RoundHalfEven, -50},
+		{11950, RoundHalfEven, 50},
+		{-11850, RoundHalfUp, -50},
+		{-11859, RoundHalfUp, -41},
+		{11900, RoundHalfUp, 0},
+	}
+	for _, tt := range tests {
+		if got := roundOffAmount(tt.total, tt.method); got != tt.want {
+			t.Errorf("roundOffAmount(%d, %s) = %d, want %d", tt.total, tt.method, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTotals(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(i *EInvoice)
+		want   string
+	}{
+		{"calculated", func(i *EInvoice) {}, ""},
+		{"within tolerance", func(i *EInvoice) { i.ValDtls.TotInvVal += 0.5 }, ""},
+		{"line total", func(i *EInvoice) { i.ItemList[0].TotItemVal += 2 }, "TotItemVal"},
+		{"line amount", func(i *EInvoice) { i.ItemList[0].TotAmt += 5 }, "TotAmt"},
+		{"assessable value", func(i *EInvoice) { i.ValDtls.AssVal += 5 }, "AssVal"},
+		{"CGST", func(i *EInvoice) { i.ValDtls.CgstVal += 5 }, "CgstVal"},
+		{"SGST", func(i *EInvoice) { i.ValDtls.SgstVal -= 5 }, "SgstVal"},
+		{"IGST on a supply within the state", func(i *EInvoice) { i.ValDtls.IgstVal = 18.10 }, "IgstVal"},
+		{"invoice value", func(i *EInvoice) { i.ValDtls.TotInvVal -= 2 }, "TotInvVal"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			invoice := testInvoice("INV", "27", 1, 100.50, 18)
+			invoice.CalculateTotals()
+			tt.change(invoice)
+			err := invoice.CheckTotals()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("CheckTotals() = %v, want nil", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("CheckTotals() = %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
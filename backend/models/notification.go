@@ -0,0 +1,89 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Invoice events SMS notifications are sent on
+const (
+	NotifyInvoiceIssued   = "invoice_issued"
+	NotifyPaymentReceived = "payment_received"
+	NotifyPaymentOverdue  = "payment_overdue"
+)
+
+// NotificationEvents lists the events notification rules can be set up for
+var NotificationEvents = []string{NotifyInvoiceIssued, NotifyPaymentReceived, NotifyPaymentOverdue}
+
+// Recipients of SMS notifications
+const (
+	// NotifyBuyer notifications go to the phone number of the invoice's buyer
+	NotifyBuyer = "buyer"
+	// NotifyOwner notifications go to the phone number on the account owner's profile
+	NotifyOwner = "owner"
+)
+
+// Statuses of an SMS message
+const (
+	SMSQueued = "queued"
+	SMSSent   = "sent"
+	SMSFailed = "failed"
+)
+
+// NotificationRule sends an SMS to a recipient when an event happens to one
+// of the account's invoices
+type NotificationRule struct {
+	ID        int    `json:"id" db:"id"`
+	Event     string `json:"event" db:"event"`
+	Recipient string `json:"recipient" db:"recipient"`
+	// OverdueDays is how many days past the due date overdue reminders wait
+	OverdueDays int `json:"overdue_days" db:"overdue_days"`
+	// Template overrides the default message text
+	Template string `json:"template" db:"template"`
+	// TemplateID is the provider's ID of a registered (DLT) template, which
+	// providers such as MSG91 send instead of the text
+	TemplateID string    `json:"template_id" db:"template_id"`
+	Enabled    bool      `json:"enabled" db:"enabled"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// Validate checks the event, recipient and limits of a rule
+func (r *NotificationRule) Validate() error {
+	validEvent := false
+	for _, e := range NotificationEvents {
+		validEvent = validEvent || r.Event == e
+	}
+	if !validEvent {
+		return errors.New("event must be invoice_issued, payment_received or payment_overdue")
+	}
+	if r.Recipient != NotifyBuyer && r.Recipient != NotifyOwner {
+		return errors.New("recipient must be buyer or owner")
+	}
+	if r.OverdueDays < 0 || r.OverdueDays > 365 {
+		return errors.New("overdue_days must be between 0 and 365")
+	}
+	if len(r.Template) > 500 {
+		return errors.New("template must be at most 500 characters")
+	}
+	if len(r.TemplateID) > 100 {
+		return errors.New("template_id must be at most 100 characters")
+	}
+	return nil
+}
+
+// SMSMessage is a notification sent, or waiting to be sent, by SMS
+type SMSMessage struct {
+	ID        int        `json:"id" db:"id"`
+	InvoiceID int        `json:"invoice_id" db:"invoice_id"`
+	RuleID    *int       `json:"rule_id" db:"rule_id"`
+	Event     string     `json:"event" db:"event"`
+	Recipient string     `json:"recipient" db:"recipient"`
+	Phone     string     `json:"phone" db:"phone"`
+	Body      string     `json:"body" db:"body"`
+	Status    string     `json:"status" db:"status"`
+	Attempts  int        `json:"attempts" db:"attempts"`
+	Error     string     `json:"error,omitempty" db:"error"`
+	SentAt    *time.Time `json:"sent_at" db:"sent_at"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+}
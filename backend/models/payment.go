@@ -0,0 +1,93 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// Payment modes
+const (
+	PaymentModeCash   = "cash"
+	PaymentModeUPI    = "upi"
+	PaymentModeNEFT   = "neft"
+	PaymentModeRTGS   = "rtgs"
+	PaymentModeIMPS   = "imps"
+	PaymentModeCheque = "cheque"
+	PaymentModeCard   = "card"
+	PaymentModeOther  = "other"
+	// PaymentModeOnline payments were received through a payment link
+	PaymentModeOnline = "online"
+)
+
+// PaymentModes lists the accepted payment modes
+var PaymentModes = []string{
+	PaymentModeCash, PaymentModeUPI, PaymentModeNEFT, PaymentModeRTGS,
+	PaymentModeIMPS, PaymentModeCheque, PaymentModeCard, PaymentModeOther, PaymentModeOnline,
+}
+
+// Invoice payment statuses
+const (
+	PaymentStatusUnpaid  = "unpaid"
+	PaymentStatusPartial = "partial"
+	PaymentStatusPaid    = "paid"
+)
+
+// Payment is a full or partial payment received against an invoice
+type Payment struct {
+	ID          int       `json:"id" db:"id"`
+	InvoiceID   int       `json:"invoice_id" db:"invoice_id"`
+	UserID      int       `json:"user_id" db:"user_id"`
+	Amount      float64   `json:"amount" db:"amount"`
+	PaymentDate string    `json:"payment_date" db:"payment_date"`
+	Mode        string    `json:"mode" db:"mode"`
+	Reference   string    `json:"reference" db:"reference"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate checks the amount, the DD/MM/YYYY payment date and the mode
+func (p *Payment) Validate() error {
+	if p.Amount <= 0 {
+		return errors.New("amount must be greater than zero")
+	}
+	if Round(p.Amount, AmountDecimals) != p.Amount {
+		return errors.New("amount must have at most two decimals")
+	}
+	if _, err := time.Parse("02/01/2006", p.PaymentDate); err != nil {
+		return errors.New("payment_date must be in DD/MM/YYYY format")
+	}
+	if len(p.Reference) > 100 {
+		return errors.New("reference must be at most 100 characters")
+	}
+	for _, mode := range PaymentModes {
+		if p.Mode == mode {
+			return nil
+		}
+	}
+	return errors.New("invalid payment mode")
+}
+
+// PaymentSummary reports how much of an invoice has been paid
+type PaymentSummary struct {
+	Total       float64 `json:"total"`
+	Paid        float64 `json:"paid"`
+	Outstanding float64 `json:"outstanding"`
+	Status      string  `json:"status"`
+}
+
+// NewPaymentSummary computes the outstanding balance and payment status of an invoice
+func NewPaymentSummary(total, paid float64) PaymentSummary {
+	s := PaymentSummary{
+		Total:       Round(total, AmountDecimals),
+		Paid:        Round(paid, AmountDecimals),
+		Outstanding: Round(total-paid, AmountDecimals),
+	}
+	switch {
+	case s.Outstanding <= 0:
+		s.Status = PaymentStatusPaid
+	case s.Paid > 0:
+		s.Status = PaymentStatusPartial
+	default:
+		s.Status = PaymentStatusUnpaid
+	}
+	return s
+}
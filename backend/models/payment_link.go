@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// Payment gateways invoices can be paid through
+const (
+	PaymentGatewayRazorpay = "razorpay"
+	PaymentGatewayStripe   = "stripe"
+)
+
+// PaymentGateways lists the supported payment gateways
+var PaymentGateways = []string{PaymentGatewayRazorpay, PaymentGatewayStripe}
+
+// Statuses of a payment link
+const (
+	// PaymentLinkActive links can be paid
+	PaymentLinkActive = "active"
+	// PaymentLinkPaid links were paid, and the payment recorded against the invoice
+	PaymentLinkPaid = "paid"
+	// PaymentLinkCancelled links were replaced or withdrawn
+	PaymentLinkCancelled = "cancelled"
+)
+
+// PaymentGateway is a user's account with a payment gateway. Its secrets are
+// stored encrypted and never returned.
+type PaymentGateway struct {
+	ID         int    `json:"id" db:"id"`
+	Gateway    string `json:"gateway" db:"gateway"`
+	KeyID      string `json:"key_id,omitempty" db:"key_id"`
+	WebhookURL string `json:"webhook_url" db:"-"`
+	// WebhookConfigured is set once the webhook signing secret is stored;
+	// until then paid links are not reconciled
+	WebhookConfigured bool      `json:"webhook_configured" db:"webhook_configured"`
+	CreatedAt         time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// PaymentLink is a hosted page on a payment gateway where the buyer pays an invoice
+type PaymentLink struct {
+	ID        int        `json:"id" db:"id"`
+	InvoiceID int        `json:"invoice_id" db:"invoice_id"`
+	Gateway   string     `json:"gateway" db:"gateway"`
+	GatewayID string     `json:"gateway_id" db:"gateway_id"`
+	URL       string     `json:"url" db:"url"`
+	Amount    float64    `json:"amount" db:"amount"`
+	Status    string     `json:"status" db:"status"`
+	PaymentID *int       `json:"payment_id" db:"payment_id"`
+	CreatedAt time.Time  `json:"created_at" db:"created_at"`
+	PaidAt    *time.Time `json:"paid_at" db:"paid_at"`
+}
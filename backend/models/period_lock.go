@@ -0,0 +1,34 @@
+package models
+
+import (
+	"errors"
+	"time"
+	"unicode/utf8"
+)
+
+// PeriodLayout is the YYYY-MM format of locked periods
+const PeriodLayout = "2006-01"
+
+// PeriodLock locks a month whose returns have been filed: invoices dated in
+// it can no longer be created, edited or deleted without an admin override
+type PeriodLock struct {
+	ID     int `json:"id" db:"id"`
+	UserID int `json:"user_id" db:"user_id"`
+	// Period is the locked month, YYYY-MM
+	Period string `json:"period" db:"period" binding:"required"`
+	// Note records why the period was locked, such as the return filed
+	Note      string    `json:"note" db:"note"`
+	LockedBy  int       `json:"locked_by" db:"locked_by"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate checks the period and note
+func (l *PeriodLock) Validate() error {
+	if _, err := time.Parse(PeriodLayout, l.Period); err != nil {
+		return errors.New("period must be in YYYY-MM format")
+	}
+	if utf8.RuneCountInString(l.Note) > 255 {
+		return errors.New("note must be at most 255 characters")
+	}
+	return nil
+}
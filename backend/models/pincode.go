@@ -0,0 +1,25 @@
+package models
+
+// Sources of a PIN code lookup
+const (
+	// PincodeSourceDirectory is an entry of the PIN code directory
+	PincodeSourceDirectory = "directory"
+	// PincodeSourceRange is a PIN code missing from the directory whose
+	// state is known only from the state's PIN code ranges
+	PincodeSourceRange = "range"
+)
+
+// Pincode is the place a PIN code belongs to
+type Pincode struct {
+	Pincode   int    `json:"pincode" db:"pincode"`
+	City      string `json:"city" db:"city"`
+	District  string `json:"district" db:"district"`
+	StateCode string `json:"state_code" db:"state_code"`
+	StateName string `json:"state_name"`
+	Source    string `json:"source"`
+}
+
+// IsValidPincode reports whether pin is a six-digit Indian PIN code
+func IsValidPincode(pin int) bool {
+	return pin >= 110000 && pin <= 999999
+}
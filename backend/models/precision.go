@@ -0,0 +1,60 @@
+package models
+
+import "errors"
+
+// AmountDecimals is the number of decimal places used for all monetary amounts
+const AmountDecimals = 2
+
+// Rounding rules for tax amounts
+const (
+	// RoundingLine rounds the tax of each line item and adds up the rounded amounts
+	RoundingLine = "line"
+	// RoundingInvoice rounds the tax on the total assessable value of each GST rate
+	RoundingInvoice = "invoice"
+)
+
+// Rounding methods for amounts exactly half way between two values
+const (
+	// RoundHalfUp rounds halves away from zero, as the IRP does
+	RoundHalfUp = "half_up"
+	// RoundHalfEven rounds halves to the even neighbour (bankers' rounding)
+	RoundHalfEven = "half_even"
+)
+
+// Precision holds the number of decimal places applied to quantities and unit
+// rates, and the rules used to round tax amounts and the invoice total
+type Precision struct {
+	Quantity  int    `json:"qty_decimals"`
+	UnitPrice int    `json:"rate_decimals"`
+	Rounding  string `json:"rounding"`
+	Method    string `json:"rounding_method"`
+	// RoundOff rounds the invoice total to whole rupees, recording the
+	// difference as RndOffAmt
+	RoundOff bool `json:"round_off"`
+}
+
+// DefaultPrecision is used when a company has not configured its own precision
+var DefaultPrecision = Precision{Quantity: 2, UnitPrice: 2, Rounding: RoundingLine, Method: RoundHalfUp}
+
+// Validate checks that the precision is one supported by the e-invoice schema
+func (p Precision) Validate() error {
+	if p.Quantity < 2 || p.Quantity > 3 {
+		return errors.New("quantity decimals must be 2 or 3")
+	}
+	if p.UnitPrice < 2 || p.UnitPrice > 3 {
+		return errors.New("rate decimals must be 2 or 3")
+	}
+	if p.Rounding != RoundingLine && p.Rounding != RoundingInvoice {
+		return errors.New("rounding must be line or invoice")
+	}
+	if p.Method != RoundHalfUp && p.Method != RoundHalfEven {
+		return errors.New("rounding method must be half_up or half_even")
+	}
+	return nil
+}
+
+// Round rounds a value half away from zero to the given number of decimal
+// places, as the value is written in decimal
+func Round(value float64, places int) float64 {
+	return unscaled(scaled(value, places), places)
+}
@@ -0,0 +1,46 @@
+package models
+
+import "testing"
+
+func TestRound(t *testing.T) {
+	tests := []struct {
+		value  float64
+		places int
+		want   float64
+	}{
+		{1.005, 2, 1.01},
+		{2.675, 2, 2.68},
+		{1.2345, 3, 1.235},
+		{0.004999, 2, 0},
+		{-2.345, 2, -2.35},
+		{-0.005, 2, -0.01},
+		{-1.004, 2, -1},
+	}
+	for _, tt := range tests {
+		if got := Round(tt.value, tt.places); got != tt.want {
+			t.Errorf("Round(%v, %d) = %v, want %v", tt.value, tt.places, got, tt.want)
+		}
+	}
+}
+
+func TestPrecisionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		precision Precision
+		valid     bool
+	}{
+		{"default", DefaultPrecision, true},
+		{"three decimals, invoice rounding, half even", Precision{3, 3, RoundingInvoice, RoundHalfEven, true}, true},
+		{"one quantity decimal", Precision{1, 2, RoundingLine, RoundHalfUp, false}, false},
+		{"four rate decimals", Precision{2, 4, RoundingLine, RoundHalfUp, false}, false},
+		{"unknown rounding", Precision{2, 2, "item", RoundHalfUp, false}, false},
+		{"unknown method", Precision{2, 2, RoundingLine, "half_down", false}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.precision.Validate(); (err == nil) != tt.valid {
+				t.Errorf("Validate() = %v, want valid %v", err, tt.valid)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// Kinds of proforma documents. They are offers, not tax invoices: they are
+// numbered in their own series and never reported to the GST portal.
+const (
+	ProformaKindProforma = "PROFORMA"
+	ProformaKindQuote    = "QUOTE"
+)
+
+// ProformaPrefixes are the number prefixes of each kind's series
+var ProformaPrefixes = map[string]string{
+	ProformaKindProforma: "PI-",
+	ProformaKindQuote:    "QT-",
+}
+
+// IsValidProformaKind reports whether kind is PROFORMA or QUOTE
+func IsValidProformaKind(kind string) bool {
+	_, ok := ProformaPrefixes[kind]
+	return ok
+}
+
+// ProformaDocument is a proforma invoice or quotation. It holds the lines of
+// the tax invoice it may later be converted to, which is then linked by ID.
+type ProformaDocument struct {
+	ID          int        `json:"id" db:"id"`
+	UserID      int        `json:"user_id" db:"user_id"`
+	Kind        string     `json:"kind" db:"kind"`
+	DocNo       string     `json:"doc_no" db:"doc_no"`
+	Invoice     EInvoice   `json:"invoice" db:"invoice_json"`
+	InvoiceID   *int       `json:"invoice_id,omitempty" db:"invoice_id"`
+	ConvertedAt *time.Time `json:"converted_at,omitempty" db:"converted_at"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at" db:"updated_at"`
+}
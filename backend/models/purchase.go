@@ -0,0 +1,150 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// PurchaseItem is a line of a purchase bill. Its tax is split into IGST, or
+// CGST and SGST for supplies within the state, like the bill itself.
+type PurchaseItem struct {
+	ID           int     `json:"id" db:"id"`
+	Description  string  `json:"description" db:"description"`
+	HsnCd        string  `json:"hsn_code" db:"hsn_code"`
+	Qty          float64 `json:"qty" db:"qty"`
+	Unit         string  `json:"unit" db:"unit"`
+	UnitPrice    float64 `json:"unit_price" db:"unit_price"`
+	TaxableValue float64 `json:"taxable_value" db:"taxable_value"`
+	GstRt        float64 `json:"gst_rate" db:"gst_rate"`
+	IGST         float64 `json:"igst" db:"igst"`
+	CGST         float64 `json:"cgst" db:"cgst"`
+	SGST         float64 `json:"sgst" db:"sgst"`
+	Cess         float64 `json:"cess" db:"cess"`
+	ITCEligible  bool    `json:"itc_eligible" db:"itc_eligible"`
+}
+
+// PurchaseInvoice is a bill received from a supplier, recorded to track the
+// input tax credit it gives against the tax on sales
+type PurchaseInvoice struct {
+	ID             int            `json:"id" db:"id"`
+	UserID         int            `json:"user_id" db:"user_id"`
+	SupplierID     int            `json:"supplier_id" db:"supplier_id"`
+	SupplierGSTIN  string         `json:"supplier_gstin" db:"supplier_gstin"`
+	SupplierName   string         `json:"supplier_name" db:"supplier_name"`
+	RecipientGSTIN string         `json:"recipient_gstin" db:"recipient_gstin"`
+	BillNo         string         `json:"bill_no" db:"bill_no"`
+	BillDate       string         `json:"bill_date" db:"bill_date"`
+	PlaceOfSupply  string         `json:"place_of_supply" db:"place_of_supply"`
+	ReverseCharge  bool           `json:"reverse_charge" db:"reverse_charge"`
+	Items          []PurchaseItem `json:"items"`
+	TaxableValue   float64        `json:"taxable_value" db:"taxable_value"`
+	IGST           float64        `json:"igst" db:"igst"`
+	CGST           float64        `json:"cgst" db:"cgst"`
+	SGST           float64        `json:"sgst" db:"sgst"`
+	Cess           float64        `json:"cess" db:"cess"`
+	TotalValue     float64        `json:"total_value" db:"total_value"`
+	ITCAvailable   float64        `json:"itc_available" db:"itc_available"`
+	Notes          string         `json:"notes" db:"notes"`
+	CreatedAt      time.Time      `json:"created_at" db:"created_at"`
+	UpdatedAt      time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// Validate checks the bill as entered, before totals are calculated
+func (p *PurchaseInvoice) Validate() error {
+	if p.SupplierGSTIN != "" && !IsValidGSTIN(p.SupplierGSTIN) {
+		return errors.New("invalid supplier GSTIN format")
+	}
+	if p.RecipientGSTIN != "" && !IsValidGSTIN(p.RecipientGSTIN) {
+		return errors.New("invalid recipient GSTIN format")
+	}
+	if p.BillNo == "" || len(p.BillNo) > 16 {
+		return errors.New("bill number must be 1 to 16 characters")
+	}
+	if _, err := time.Parse("02/01/2006", p.BillDate); err != nil {
+		return errors.New("bill date must be in DD/MM/YYYY format")
+	}
+	if len(p.PlaceOfSupply) != 2 {
+		return errors.New("place of supply must be a 2 digit state code")
+	}
+	if len(p.Items) == 0 {
+		return errors.New("bill must have at least one item")
+	}
+	for i, item := range p.Items {
+		switch {
+		case item.Description == "":
+			return fmt.Errorf("item %d: description is required", i+1)
+		case !IsValidHSNFormat(item.HsnCd):
+			return fmt.Errorf("item %d: HSN code must be 4, 6 or 8 digits", i+1)
+		case item.Qty <= 0:
+			return fmt.Errorf("item %d: quantity must be greater than zero", i+1)
+		case item.UnitPrice < 0:
+			return fmt.Errorf("item %d: unit price cannot be negative", i+1)
+		case item.GstRt < 0 || item.GstRt > 100:
+			return fmt.Errorf("item %d: GST rate must be between 0 and 100", i+1)
+		case item.Cess < 0:
+			return fmt.Errorf("item %d: cess cannot be negative", i+1)
+		}
+	}
+	return nil
+}
+
+// IsIntraState reports whether the supplier is registered in the state of
+// supply, so tax is charged as CGST and SGST rather than IGST
+func (p *PurchaseInvoice) IsIntraState() bool {
+	return len(p.SupplierGSTIN) >= 2 && p.SupplierGSTIN[:2] == p.PlaceOfSupply
+}
+
+// CalculateTotals works out each line's taxable value and tax, and the bill
+// totals, in paise. Cess is taken as entered. Input tax credit is the tax of
+// the lines it may be claimed on; none is available on a bill from an
+// unregistered supplier, unless the tax was paid under reverse charge.
+func (p *PurchaseInvoice) CalculateTotals() {
+	intraState := p.IsIntraState()
+	var taxable, igst, cgst, sgst, cess, itc int64
+	for j := range p.Items {
+		item := &p.Items[j]
+		if p.SupplierGSTIN == "" && !p.ReverseCharge {
+			item.ITCEligible = false
+		}
+
+		value := lineAmount(scaled(item.Qty, rateDecimals), rateDecimals, scaled(item.UnitPrice, rateDecimals), rateDecimals, RoundHalfUp)
+		tax := taxAmount(value, scaled(item.GstRt, rateDecimals), RoundHalfUp)
+		var lineIGST, lineCGST, lineSGST int64
+		if intraState {
+			lineCGST = tax / 2
+			lineSGST = tax - lineCGST
+		} else {
+			lineIGST = tax
+		}
+		lineCess := Paise(item.Cess)
+
+		item.TaxableValue = Rupees(value)
+		item.IGST = Rupees(lineIGST)
+		item.CGST = Rupees(lineCGST)
+		item.SGST = Rupees(lineSGST)
+		item.Cess = Rupees(lineCess)
+
+		taxable += value
+		igst += lineIGST
+		cgst += lineCGST
+		sgst += lineSGST
+		cess += lineCess
+		if item.ITCEligible {
+			itc += tax + lineCess
+		}
+	}
+
+	p.TaxableValue = Rupees(taxable)
+	p.IGST = Rupees(igst)
+	p.CGST = Rupees(cgst)
+	p.SGST = Rupees(sgst)
+	p.Cess = Rupees(cess)
+	p.ITCAvailable = Rupees(itc)
+
+	// Under reverse charge the supplier charges no tax; the buyer pays it
+	p.TotalValue = Rupees(taxable + igst + cgst + sgst + cess)
+	if p.ReverseCharge {
+		p.TotalValue = Rupees(taxable)
+	}
+}
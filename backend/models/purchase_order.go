@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+// PurchaseOrder represents a buyer's purchase order that invoices are billed against
+type PurchaseOrder struct {
+	ID         int       `json:"id" db:"id"`
+	UserID     int       `json:"user_id" db:"user_id"`
+	PONumber   string    `json:"po_number" db:"po_number"`
+	PODate     string    `json:"po_date" db:"po_date"`
+	BuyerGSTIN string    `json:"buyer_gstin" db:"buyer_gstin"`
+	BuyerName  string    `json:"buyer_name" db:"buyer_name"`
+	POValue    float64   `json:"po_value" db:"po_value"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
+}
+
+// POSummary reports how much of a purchase order has been invoiced
+type POSummary struct {
+	PurchaseOrder
+	InvoiceCount   int     `json:"invoice_count"`
+	InvoicedValue  float64 `json:"invoiced_value"`
+	RemainingValue float64 `json:"remaining_value"`
+}
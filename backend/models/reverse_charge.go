@@ -0,0 +1,41 @@
+package models
+
+import (
+	"errors"
+	"strings"
+)
+
+// IsReverseCharge reports whether the recipient pays the invoice's tax under
+// reverse charge (RCM) rather than the supplier
+func (i *EInvoice) IsReverseCharge() bool {
+	return i.TranDtls.RegRev == "Y"
+}
+
+// AmountPayable is what the buyer owes the seller. Under reverse charge the
+// tax is still shown on the invoice, and included in TotInvVal as the IRP
+// requires, but the buyer pays it to the government rather than the seller.
+func (i *EInvoice) AmountPayable() float64 {
+	if i.IsReverseCharge() {
+		return Rupees(Paise(i.ValDtls.TotInvVal) - Paise(i.TotalTax()))
+	}
+	return i.ValDtls.TotInvVal
+}
+
+// validateReverseCharge checks the reverse charge flag, which the IRP does
+// not accept on exports or on supplies without payment of tax
+func (i *EInvoice) validateReverseCharge() error {
+	switch i.TranDtls.RegRev {
+	case "", "N":
+		return nil
+	case "Y":
+		if strings.HasPrefix(i.TranDtls.SupTyp, "EXP") {
+			return errors.New("reverse charge does not apply to exports")
+		}
+		if i.IsWithoutPayment() {
+			return errors.New("reverse charge does not apply to supplies without payment of tax")
+		}
+		return nil
+	default:
+		return errors.New("reverse charge flag must be Y or N")
+	}
+}
@@ -0,0 +1,187 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// Optional sections of the NIC e-invoice schema v1.1. Fields the schema
+// requires within a section are always written; optional ones are omitted
+// when empty, so government JSON round-trips without gaining empty fields.
+
+// DispDtls contains the address goods are dispatched from, when it differs
+// from the seller's
+type DispDtls struct {
+	Nm    string `json:"Nm"`
+	Addr1 string `json:"Addr1"`
+	Addr2 string `json:"Addr2,omitempty"`
+	Loc   string `json:"Loc"`
+	Pin   int    `json:"Pin"`
+	Stcd  string `json:"Stcd"`
+}
+
+// ShipDtls contains the address goods are shipped to, when it differs from
+// the buyer's
+type ShipDtls struct {
+	Gstin string `json:"Gstin,omitempty"`
+	LglNm string `json:"LglNm"`
+	TrdNm string `json:"TrdNm,omitempty"`
+	Addr1 string `json:"Addr1"`
+	Addr2 string `json:"Addr2,omitempty"`
+	Loc   string `json:"Loc"`
+	Pin   int    `json:"Pin"`
+	Stcd  string `json:"Stcd"`
+}
+
+// PayDtls contains the payee's bank details and payment terms
+type PayDtls struct {
+	Nm       string  `json:"Nm,omitempty"`
+	AccDet   string  `json:"AccDet,omitempty"`
+	Mode     string  `json:"Mode,omitempty"`
+	FinInsBr string  `json:"FinInsBr,omitempty"`
+	PayTerm  string  `json:"PayTerm,omitempty"`
+	PayInstr string  `json:"PayInstr,omitempty"`
+	CrTrn    string  `json:"CrTrn,omitempty"`
+	DirDr    string  `json:"DirDr,omitempty"`
+	CrDay    int     `json:"CrDay,omitempty"`
+	PaidAmt  float64 `json:"PaidAmt,omitempty"`
+	PaymtDue float64 `json:"PaymtDue,omitempty"`
+}
+
+// AddlDocDtls links a supporting document
+type AddlDocDtls struct {
+	Url  string `json:"Url,omitempty"`
+	Docs string `json:"Docs,omitempty"`
+	Info string `json:"Info,omitempty"`
+}
+
+// EwbDtls contains the transport details used to generate an e-way bill with the IRN
+type EwbDtls struct {
+	TransId    string `json:"TransId,omitempty"`
+	TransName  string `json:"TransName,omitempty"`
+	TransMode  string `json:"TransMode,omitempty"`
+	Distance   int    `json:"Distance"`
+	TransDocNo string `json:"TransDocNo,omitempty"`
+	TransDocDt string `json:"TransDocDt,omitempty"`
+	VehNo      string `json:"VehNo,omitempty"`
+	VehType    string `json:"VehType,omitempty"`
+}
+
+// BchDtls contains the batch an item belongs to
+type BchDtls struct {
+	Nm    string `json:"Nm"`
+	ExpDt string `json:"ExpDt,omitempty"`
+	WrDt  string `json:"WrDt,omitempty"`
+}
+
+// AttribDtls is a named attribute of an item
+type AttribDtls struct {
+	Nm  string `json:"Nm,omitempty"`
+	Val string `json:"Val,omitempty"`
+}
+
+// Transport modes of EwbDtls.TransMode: road, rail, air and ship
+var TransModes = []string{"1", "2", "3", "4"}
+
+// vehicleNoRegex matches vehicle registration numbers as accepted by the e-way bill system
+var vehicleNoRegex = regexp.MustCompile(`^[A-Z0-9]{4,20}$`)
+
+// maxEwbDistance is the largest distance in km accepted for an e-way bill
+const maxEwbDistance = 4000
+
+// validateAddress checks the fields shared by dispatch and shipping addresses
+func validateAddress(section, name, addr1, loc string, pin int, stcd string) error {
+	switch {
+	case name == "" || len(name) > 100:
+		return fmt.Errorf("%s name must be 1 to 100 characters", section)
+	case addr1 == "" || len(addr1) > 100:
+		return fmt.Errorf("%s address must be 1 to 100 characters", section)
+	case loc == "" || len(loc) > 100:
+		return fmt.Errorf("%s location must be 1 to 100 characters", section)
+	case pin < 100000 || pin > 999999:
+		return fmt.Errorf("%s PIN code must be 6 digits", section)
+	case len(stcd) < 1 || len(stcd) > 2:
+		return fmt.Errorf("%s state code must be 1 or 2 digits", section)
+	}
+	return nil
+}
+
+func (d *DispDtls) validate() error {
+	return validateAddress("dispatch", d.Nm, d.Addr1, d.Loc, d.Pin, d.Stcd)
+}
+
+func (s *ShipDtls) validate() error {
+	if s.Gstin != "" && s.Gstin != "URP" && !IsValidGSTIN(s.Gstin) {
+		return errors.New("invalid shipping GSTIN format")
+	}
+	return validateAddress("shipping", s.LglNm, s.Addr1, s.Loc, s.Pin, s.Stcd)
+}
+
+func (p *PayDtls) validate() error {
+	if p.CrDay < 0 || p.CrDay > 9999 {
+		return errors.New("credit days must be between 0 and 9999")
+	}
+	if p.PaidAmt < 0 || p.PaymtDue < 0 {
+		return errors.New("paid and due amounts cannot be negative")
+	}
+	return nil
+}
+
+func (e *EwbDtls) validate() error {
+	if e.Distance < 0 || e.Distance > maxEwbDistance {
+		return fmt.Errorf("e-way bill distance must be between 0 and %d km", maxEwbDistance)
+	}
+	if e.TransMode != "" {
+		valid := false
+		for _, mode := range TransModes {
+			valid = valid || e.TransMode == mode
+		}
+		if !valid {
+			return errors.New("transport mode must be 1 (road), 2 (rail), 3 (air) or 4 (ship)")
+		}
+	}
+	if e.VehType != "" && e.VehType != "R" && e.VehType != "O" {
+		return errors.New("vehicle type must be R (regular) or O (over-dimensional cargo)")
+	}
+	if e.VehNo != "" && !vehicleNoRegex.MatchString(e.VehNo) {
+		return errors.New("invalid vehicle number")
+	}
+	return nil
+}
+
+// validateSections checks the optional schema sections present on the invoice
+func (i *EInvoice) validateSections() error {
+	if i.DispDtls != nil {
+		if err := i.DispDtls.validate(); err != nil {
+			return err
+		}
+	}
+	if i.ShipDtls != nil {
+		if err := i.ShipDtls.validate(); err != nil {
+			return err
+		}
+	}
+	if i.PayDtls != nil {
+		if err := i.PayDtls.validate(); err != nil {
+			return err
+		}
+	}
+	if i.EwbDtls != nil {
+		if err := i.EwbDtls.validate(); err != nil {
+			return err
+		}
+	}
+	for _, item := range i.ItemList {
+		if item.FreeQty < 0 {
+			return fmt.Errorf("item %s: free quantity cannot be negative", item.SlNo)
+		}
+		if len(item.OrdLineRef) > 50 {
+			return fmt.Errorf("item %s: order line reference cannot exceed 50 characters", item.SlNo)
+		}
+		if item.BchDtls != nil && (item.BchDtls.Nm == "" || len(item.BchDtls.Nm) > 20) {
+			return fmt.Errorf("item %s: batch name must be 1 to 20 characters", item.SlNo)
+		}
+	}
+	return nil
+}
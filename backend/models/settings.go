@@ -0,0 +1,161 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// SupplyTypes are the supply types of the e-invoice schema
+var SupplyTypes = []string{SupplyB2B, SupplySEZWP, SupplySEZWOP, SupplyEXPWP, SupplyEXPWOP, SupplyDEXP}
+
+// GST treatments decide whether tax is paid by the supplier or, under reverse
+// charge, by the recipient
+const (
+	GSTTreatmentRegular       = "regular"
+	GSTTreatmentReverseCharge = "reverse_charge"
+)
+
+// DateFormats maps the supported invoice date formats to their Go layouts
+var DateFormats = map[string]string{
+	"DD/MM/YYYY": "02/01/2006",
+	"DD-MM-YYYY": "02-01-2006",
+	"DD.MM.YYYY": "02.01.2006",
+	"MM/DD/YYYY": "01/02/2006",
+	"YYYY-MM-DD": "2006-01-02",
+}
+
+// Invoice number series either run on, or restart at 1 each financial year
+// with the year in the number, as in INV-24-25/00001
+const (
+	SeriesResetNever      = "never"
+	SeriesResetFiscalYear = "fiscal_year"
+)
+
+// Email digests summarise the account's invoices by email each day or week
+const (
+	DigestOff    = "off"
+	DigestDaily  = "daily"
+	DigestWeekly = "weekly"
+)
+
+// fiscalSeriesMaxPrefix is the longest prefix that keeps numbers of a yearly
+// series, with the year and five digits, within the 16 characters allowed
+const fiscalSeriesMaxPrefix = 5
+
+// InvoiceDateFormat is the date format of the e-invoice schema
+const InvoiceDateFormat = "DD/MM/YYYY"
+
+// invoicePrefixRegex limits series prefixes to characters allowed in invoice numbers
+var invoicePrefixRegex = regexp.MustCompile(`^[A-Za-z0-9/-]{1,10}$`)
+
+// UserSettings holds a user's defaults for new invoices. The default company
+// is the company marked is_default. With ApprovalRequired, invoices issued
+// by accountant members wait for an approver. SeriesReset decides whether
+// the invoice number series restarts each financial year. EmailDigest sets
+// how often the summary email is sent.
+type UserSettings struct {
+	InvoicePrefix    string     `json:"invoice_prefix"`
+	SupplyType       string     `json:"supply_type"`
+	GSTTreatment     string     `json:"gst_treatment"`
+	RoundingMode     string     `json:"rounding_mode"`
+	DateFormat       string     `json:"date_format"`
+	DefaultCompanyID *int       `json:"default_company_id"`
+	ApprovalRequired bool       `json:"approval_required"`
+	SeriesReset      string     `json:"series_reset"`
+	EmailDigest      string     `json:"email_digest"`
+	UpdatedAt        *time.Time `json:"updated_at,omitempty"`
+}
+
+// DefaultUserSettings apply until a user saves their own
+var DefaultUserSettings = UserSettings{
+	InvoicePrefix: "INV-",
+	SupplyType:    SupplyB2B,
+	GSTTreatment:  GSTTreatmentRegular,
+	RoundingMode:  RoundingLine,
+	DateFormat:    InvoiceDateFormat,
+	SeriesReset:   SeriesResetNever,
+	EmailDigest:   DigestOff,
+}
+
+// Validate checks the settings against the supported values
+func (s *UserSettings) Validate() error {
+	if !invoicePrefixRegex.MatchString(s.InvoicePrefix) {
+		return errors.New("invoice prefix must be 1 to 10 letters, digits, '/' or '-'")
+	}
+	if !IsValidSupplyType(s.SupplyType) {
+		return fmt.Errorf("supply type must be one of %s", strings.Join(SupplyTypes, ", "))
+	}
+	if s.GSTTreatment != GSTTreatmentRegular && s.GSTTreatment != GSTTreatmentReverseCharge {
+		return errors.New("GST treatment must be regular or reverse_charge")
+	}
+	if s.RoundingMode != RoundingLine && s.RoundingMode != RoundingInvoice {
+		return errors.New("rounding mode must be line or invoice")
+	}
+	if _, ok := DateFormats[s.DateFormat]; !ok {
+		return errors.New("date format must be DD/MM/YYYY, DD-MM-YYYY, DD.MM.YYYY, MM/DD/YYYY or YYYY-MM-DD")
+	}
+	switch s.SeriesReset {
+	case "":
+		s.SeriesReset = SeriesResetNever
+	case SeriesResetNever:
+	case SeriesResetFiscalYear:
+		if len(s.InvoicePrefix) > fiscalSeriesMaxPrefix {
+			return fmt.Errorf("invoice prefix must be at most %d characters when the series resets each financial year", fiscalSeriesMaxPrefix)
+		}
+	default:
+		return errors.New("series reset must be never or fiscal_year")
+	}
+	switch s.EmailDigest {
+	case "":
+		s.EmailDigest = DigestOff
+	case DigestOff, DigestDaily, DigestWeekly:
+	default:
+		return errors.New("email digest must be off, daily or weekly")
+	}
+	return nil
+}
+
+// ReverseCharge returns the RegRev flag of the settings' GST treatment
+func (s *UserSettings) ReverseCharge() string {
+	if s.GSTTreatment == GSTTreatmentReverseCharge {
+		return "Y"
+	}
+	return "N"
+}
+
+// NormalizeDate converts a date in the user's date format to DD/MM/YYYY.
+// Empty dates are returned unchanged.
+func (s *UserSettings) NormalizeDate(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || s.DateFormat == InvoiceDateFormat {
+		return value, nil
+	}
+	t, err := time.Parse(DateFormats[s.DateFormat], value)
+	if err != nil {
+		return "", fmt.Errorf("%q is not a date in %s format", value, s.DateFormat)
+	}
+	return t.Format(DateFormats[InvoiceDateFormat]), nil
+}
+
+// ApplyDefaults fills in the transaction details and date an invoice was
+// submitted without
+func (s *UserSettings) ApplyDefaults(invoice *EInvoice) error {
+	if invoice.TranDtls.TaxSch == "" {
+		invoice.TranDtls.TaxSch = "GST"
+	}
+	if invoice.TranDtls.SupTyp == "" {
+		invoice.TranDtls.SupTyp = s.SupplyType
+	}
+	if invoice.TranDtls.RegRev == "" {
+		invoice.TranDtls.RegRev = s.ReverseCharge()
+	}
+	date, err := s.NormalizeDate(invoice.DocDtls.Dt)
+	if err != nil {
+		return err
+	}
+	invoice.DocDtls.Dt = date
+	return nil
+}
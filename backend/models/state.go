@@ -0,0 +1,34 @@
+package models
+
+// ForeignStateCode is the GST state code used for buyers outside India
+const ForeignStateCode = "96"
+
+// ForeignPincode is the placeholder PIN code required for buyers outside India
+const ForeignPincode = 999999
+
+// State represents a GST state or union territory code
+type State struct {
+	Code   string         `json:"code" db:"code"`
+	Name   string         `json:"name" db:"name"`
+	Ranges []PincodeRange `json:"pincode_ranges,omitempty"`
+}
+
+// PincodeRange is an inclusive range of PIN codes belonging to a state
+type PincodeRange struct {
+	From int `json:"from" db:"pin_from"`
+	To   int `json:"to" db:"pin_to"`
+}
+
+// HasPincode reports whether the PIN code falls within one of the state's ranges.
+// States without any ranges accept every PIN code.
+func (s *State) HasPincode(pin int) bool {
+	if len(s.Ranges) == 0 {
+		return true
+	}
+	for _, r := range s.Ranges {
+		if pin >= r.From && pin <= r.To {
+			return true
+		}
+	}
+	return false
+}
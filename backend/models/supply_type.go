@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Supply types of the e-invoice schema: registered buyers (B2B), SEZ units
+// with and without payment of tax, exports with and without payment of tax,
+// and deemed exports
+const (
+	SupplyB2B    = "B2B"
+	SupplySEZWP  = "SEZWP"
+	SupplySEZWOP = "SEZWOP"
+	SupplyEXPWP  = "EXPWP"
+	SupplyEXPWOP = "EXPWOP"
+	SupplyDEXP   = "DEXP"
+)
+
+// LUTDocument is the AddlDocDtls document name an invoice carries the letter
+// of undertaking (LUT) it is supplied under in, with the LUT number as Info
+const LUTDocument = "LUT"
+
+// maxLUTNoLen is the longest LUT number accepted; LUT ARNs are 15 characters
+const maxLUTNoLen = 20
+
+// IsValidSupplyType reports whether t is a supply type of the e-invoice schema
+func IsValidSupplyType(t string) bool {
+	for _, s := range SupplyTypes {
+		if s == t {
+			return true
+		}
+	}
+	return false
+}
+
+// IsWithoutPayment reports whether the invoice is an export or SEZ supply
+// made under an LUT without payment of IGST
+func (i *EInvoice) IsWithoutPayment() bool {
+	return i.TranDtls.SupTyp == SupplySEZWOP || i.TranDtls.SupTyp == SupplyEXPWOP
+}
+
+// IsInterState reports whether the invoice is taxed with IGST rather than
+// CGST and SGST. Supplies to SEZ units and exports are inter-state whatever
+// the buyer's state, as are supplies charged IGST on intra-state supply.
+func (i *EInvoice) IsInterState() bool {
+	if strings.HasPrefix(i.TranDtls.SupTyp, "SEZ") || strings.HasPrefix(i.TranDtls.SupTyp, "EXP") {
+		return true
+	}
+	return i.BuyerDtls.Pos != i.SellerDtls.Stcd || i.TranDtls.IgstOnIntra == "Y"
+}
+
+// LUTNo returns the LUT number the invoice carries, or "" without one
+func (i *EInvoice) LUTNo() string {
+	for _, doc := range i.AddlDocDtls {
+		if doc.Docs == LUTDocument {
+			return doc.Info
+		}
+	}
+	return ""
+}
+
+// SetLUTNo records the LUT the invoice is supplied under, replacing any
+// recorded before
+func (i *EInvoice) SetLUTNo(lutNo string) {
+	for j := range i.AddlDocDtls {
+		if i.AddlDocDtls[j].Docs == LUTDocument {
+			i.AddlDocDtls[j].Info = lutNo
+			return
+		}
+	}
+	i.AddlDocDtls = append(i.AddlDocDtls, AddlDocDtls{Docs: LUTDocument, Info: lutNo})
+}
+
+// IsValidLUTNo reports whether an LUT number is of a length that can be kept
+func IsValidLUTNo(lutNo string) bool {
+	return lutNo != "" && len(lutNo) <= maxLUTNoLen
+}
+
+// validateSupplyType checks the supply type and the buyer it needs. SEZ units
+// and deemed export recipients are registered, so their GSTIN is required.
+func (i *EInvoice) validateSupplyType() error {
+	if !IsValidSupplyType(i.TranDtls.SupTyp) {
+		return fmt.Errorf("supply type must be one of %s", strings.Join(SupplyTypes, ", "))
+	}
+	switch i.TranDtls.SupTyp {
+	case SupplySEZWP, SupplySEZWOP, SupplyDEXP:
+		if !IsValidGSTIN(i.BuyerDtls.Gstin) {
+			return fmt.Errorf("%s supplies need the buyer's GSTIN", i.TranDtls.SupTyp)
+		}
+	}
+	return nil
+}
+
+// validateLUT checks that a supply without payment of tax names the LUT it is
+// made under, as its invoice must
+func (i *EInvoice) validateLUT() error {
+	if !i.IsWithoutPayment() {
+		return nil
+	}
+	lutNo := i.LUTNo()
+	if lutNo == "" {
+		return fmt.Errorf("%s supplies need the number of the letter of undertaking (LUT) they are made under", i.TranDtls.SupTyp)
+	}
+	if !IsValidLUTNo(lutNo) {
+		return errors.New("LUT number must be at most 20 characters")
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TagNameMaxLength is the longest tag name, in characters
+const TagNameMaxLength = 50
+
+var tagColorRegex = regexp.MustCompile(`^#[0-9A-Fa-f]{6}$`)
+
+// Tag is a label users put on invoices, such as "disputed" or "FY24-Q4 audit".
+// Names are unique per account regardless of case.
+type Tag struct {
+	ID           int       `json:"id" db:"id"`
+	UserID       int       `json:"user_id" db:"user_id"`
+	Name         string    `json:"name" db:"name" binding:"required"`
+	Color        string    `json:"color" db:"color"`
+	InvoiceCount int       `json:"invoice_count" db:"-"`
+	CreatedAt    time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate trims the name and checks it and the optional #RRGGBB colour.
+// Commas are not allowed, as filters list tags separated by commas.
+func (t *Tag) Validate() error {
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" {
+		return errors.New("tag name is required")
+	}
+	if utf8.RuneCountInString(t.Name) > TagNameMaxLength {
+		return errors.New("tag name must be at most 50 characters")
+	}
+	if strings.Contains(t.Name, ",") {
+		return errors.New("tag name cannot contain commas")
+	}
+	if t.Color != "" && !tagColorRegex.MatchString(t.Color) {
+		return errors.New("color must be a hex colour such as #FF8800")
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package models
+
+// headRate returns the rate of one tax head in thousandths of a percent: the
+// GST rate for IGST, and half of it for each of CGST and SGST
+func headRate(gstRate float64, interState bool) int64 {
+	rate := scaled(gstRate, rateDecimals)
+	if !interState {
+		rate /= 2
+	}
+	return rate
+}
+
+// legacyTax reports whether the invoice was stored before CGST and SGST were
+// calculated, carrying the whole tax of a supply within the state as IGST
+func (i *EInvoice) legacyTax() bool {
+	return i.ValDtls.CgstVal == 0 && i.ValDtls.SgstVal == 0 && i.ValDtls.IgstVal != 0 && !i.IsInterState()
+}
+
+// splitLegacyTax splits the tax of a supply within the state stored as IGST
+// into equal CGST and SGST, giving SGST the odd paisa
+func splitLegacyTax(tax float64) (cgst, sgst float64) {
+	cgst = Round(tax/2, AmountDecimals)
+	return cgst, Rupees(Paise(tax) - Paise(cgst))
+}
+
+// TaxVals returns the invoice's IGST, CGST and SGST as stored. The tax of
+// invoices stored before CGST and SGST were calculated is split as
+// splitLegacyTax does.
+func (i *EInvoice) TaxVals() (igst, cgst, sgst float64) {
+	if i.legacyTax() {
+		cgst, sgst = splitLegacyTax(i.ValDtls.IgstVal)
+		return 0, cgst, sgst
+	}
+	return i.ValDtls.IgstVal, i.ValDtls.CgstVal, i.ValDtls.SgstVal
+}
+
+// ItemTax returns the IGST, CGST and SGST of one of the invoice's items as
+// stored, with the tax of earlier invoices split as in TaxVals
+func (i *EInvoice) ItemTax(item *Item) (igst, cgst, sgst float64) {
+	if i.legacyTax() {
+		cgst, sgst = splitLegacyTax(item.IgstAmt)
+		return 0, cgst, sgst
+	}
+	return item.IgstAmt, item.CgstAmt, item.SgstAmt
+}
+
+// TotalTax returns the invoice's tax over all heads
+func (i *EInvoice) TotalTax() float64 {
+	return Rupees(Paise(i.ValDtls.IgstVal) + Paise(i.ValDtls.CgstVal) + Paise(i.ValDtls.SgstVal))
+}
+
+// TaxAmt returns the item's tax over all heads
+func (item *Item) TaxAmt() float64 {
+	return Rupees(Paise(item.IgstAmt) + Paise(item.CgstAmt) + Paise(item.SgstAmt))
+}
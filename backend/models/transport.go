@@ -0,0 +1,127 @@
+package models
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// transporterIDRegex matches transporter IDs: the transporter's GSTIN or the
+// TRANSIN the e-way bill portal enrols unregistered transporters with, both
+// 15 characters starting with a state code
+var transporterIDRegex = regexp.MustCompile(`^[0-9]{2}[A-Z0-9]{13}$`)
+
+// vehicleNoFormats are the vehicle number formats the e-way bill system
+// accepts: state registrations such as KA12AB1234, DL1CAB1234 or KA121234,
+// older three-letter series such as ABC1234, Bharat series such as
+// 22BH1234AA, and temporary (TR) and defence (DF) numbers
+var vehicleNoFormats = []*regexp.Regexp{
+	regexp.MustCompile(`^[A-Z]{2}[0-9]{1,2}[A-Z]{0,3}[0-9]{4}$`),
+	regexp.MustCompile(`^[A-Z]{3}[0-9]{4}$`),
+	regexp.MustCompile(`^[0-9]{2}BH[0-9]{4}[A-Z]{1,2}$`),
+	regexp.MustCompile(`^(TR|DF)[A-Z0-9]{6,13}$`),
+}
+
+// vehicleNoSeparators are dropped from vehicle numbers as written on plates
+var vehicleNoSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "/", "")
+
+// NormalizeVehicleNo upper-cases a vehicle number and drops its separators,
+// so "ka-12 ab 1234" becomes "KA12AB1234"
+func NormalizeVehicleNo(vehicleNo string) string {
+	return vehicleNoSeparators.Replace(strings.ToUpper(strings.TrimSpace(vehicleNo)))
+}
+
+// IsValidVehicleNo reports whether a normalized vehicle number is in a
+// format the e-way bill system accepts
+func IsValidVehicleNo(vehicleNo string) bool {
+	for _, format := range vehicleNoFormats {
+		if format.MatchString(vehicleNo) {
+			return true
+		}
+	}
+	return false
+}
+
+// Transporter is a transporter goods are handed to, named on e-way bills
+type Transporter struct {
+	ID            int       `json:"id" db:"id"`
+	UserID        int       `json:"user_id" db:"user_id"`
+	TransporterID string    `json:"transporter_id" db:"transporter_id" binding:"required"`
+	Name          string    `json:"name" db:"name" binding:"required"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate normalizes the transporter ID and checks it and the name, which
+// the e-way bill system takes as 3 to 100 characters
+func (t *Transporter) Validate() error {
+	t.TransporterID = strings.ToUpper(strings.TrimSpace(t.TransporterID))
+	t.Name = strings.TrimSpace(t.Name)
+	if !transporterIDRegex.MatchString(t.TransporterID) {
+		return errors.New("transporter ID must be the transporter's GSTIN or 15-character TRANSIN")
+	}
+	if n := utf8.RuneCountInString(t.Name); n < 3 || n > 100 {
+		return errors.New("transporter name must be 3 to 100 characters")
+	}
+	return nil
+}
+
+// Vehicle is a vehicle goods travel in, named in Part-B of e-way bills
+type Vehicle struct {
+	ID        int    `json:"id" db:"id"`
+	UserID    int    `json:"user_id" db:"user_id"`
+	VehicleNo string `json:"vehicle_no" db:"vehicle_no" binding:"required"`
+	// VehicleType is R for regular or O for over-dimensional cargo
+	VehicleType string    `json:"vehicle_type" db:"vehicle_type"`
+	Description string    `json:"description" db:"description"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// Validate normalizes the vehicle number and type and checks them
+func (v *Vehicle) Validate() error {
+	v.VehicleNo = NormalizeVehicleNo(v.VehicleNo)
+	v.VehicleType = strings.ToUpper(strings.TrimSpace(v.VehicleType))
+	v.Description = strings.TrimSpace(v.Description)
+	if v.VehicleType == "" {
+		v.VehicleType = "R"
+	}
+	if !IsValidVehicleNo(v.VehicleNo) {
+		return errors.New("vehicle number must be in a format the e-way bill system accepts, such as KA12AB1234 or 22BH1234AA")
+	}
+	if v.VehicleType != "R" && v.VehicleType != "O" {
+		return errors.New("vehicle type must be R (regular) or O (over-dimensional cargo)")
+	}
+	if utf8.RuneCountInString(v.Description) > 255 {
+		return errors.New("description must be at most 255 characters")
+	}
+	return nil
+}
+
+// EwbVehicleReasons are the reason codes of a Part-B vehicle update
+var EwbVehicleReasons = map[int]string{
+	1: "Due to break down",
+	2: "Due to transhipment",
+	3: "Others",
+	4: "First time",
+}
+
+// EwbVehicleUpdate is a change of vehicle recorded in Part-B of an
+// invoice's e-way bill while the goods are in transit
+type EwbVehicleUpdate struct {
+	ID            int        `json:"id" db:"id"`
+	InvoiceID     int        `json:"invoice_id" db:"invoice_id"`
+	EwbNo         string     `json:"ewb_no" db:"ewb_no"`
+	VehicleNo     string     `json:"vehicle_no" db:"vehicle_no"`
+	VehicleType   string     `json:"vehicle_type" db:"vehicle_type"`
+	FromPlace     string     `json:"from_place" db:"from_place"`
+	FromState     string     `json:"from_state" db:"from_state"`
+	ReasonCode    int        `json:"reason_code" db:"reason_code"`
+	ReasonRemarks string     `json:"reason_remarks" db:"reason_remarks"`
+	TransMode     string     `json:"trans_mode" db:"trans_mode"`
+	TransDocNo    string     `json:"trans_doc_no" db:"trans_doc_no"`
+	TransDocDt    string     `json:"trans_doc_dt" db:"trans_doc_dt"`
+	Submitted     bool       `json:"submitted" db:"submitted"`
+	ValidUntil    *time.Time `json:"valid_until,omitempty" db:"valid_until"`
+	CreatedAt     time.Time  `json:"created_at" db:"created_at"`
+}
@@ -0,0 +1,16 @@
+package models
+
+import "regexp"
+
+// vpaRegex matches a UPI virtual payment address such as name@bank
+var vpaRegex = regexp.MustCompile(`^[a-zA-Z0-9.\-_]{2,256}@[a-zA-Z][a-zA-Z0-9]{1,63}$`)
+
+// IsValidVPA reports whether the given string is a well-formed UPI VPA
+func IsValidVPA(vpa string) bool {
+	return vpaRegex.MatchString(vpa)
+}
+
+// IsB2C reports whether the invoice is a supply to an unregistered buyer
+func (i *EInvoice) IsB2C() bool {
+	return i.BuyerDtls.Gstin == "" || i.BuyerDtls.Gstin == "URP"
+}
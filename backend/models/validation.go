@@ -0,0 +1,122 @@
+package models
+
+import "errors"
+
+// Validation profiles a company saves draft invoices under. Strict applies
+// every rule the IRP enforces; lenient reports the rules an incomplete draft
+// can still be missing as warnings. Invoices are always finalized strictly.
+const (
+	ValidationStrict  = "strict"
+	ValidationLenient = "lenient"
+)
+
+// ValidationProfiles are the accepted validation profiles
+var ValidationProfiles = []string{ValidationStrict, ValidationLenient}
+
+// IsValidValidationProfile reports whether profile is a known validation profile
+func IsValidValidationProfile(profile string) bool {
+	for _, p := range ValidationProfiles {
+		if p == profile {
+			return true
+		}
+	}
+	return false
+}
+
+// Validation rules an issue can be raised under
+const (
+	RuleSellerGSTIN   = "seller_gstin"
+	RuleSupplyType    = "supply_type"
+	RuleLUT           = "lut"
+	RuleReverseCharge = "reverse_charge"
+	RuleHSNCode       = "hsn_code"
+	RuleQuantity      = "quantity"
+	RuleUnitPrice     = "unit_price"
+	RuleExemption     = "exemption"
+	RuleFx            = "fx"
+	RuleReferences    = "references"
+	RuleSections      = "sections"
+	RuleMasterData    = "master_data"
+)
+
+// lenientRules are the rules the lenient profile reports as warnings. Negative
+// quantities, inconsistent exchange rates, an unknown supply type, an unknown
+// reverse charge flag and taxed exempt items would corrupt the totals, so
+// they stay errors under every profile.
+var lenientRules = map[string]bool{
+	RuleSellerGSTIN: true,
+	RuleLUT:         true,
+	RuleHSNCode:     true,
+	RuleUnitPrice:   true,
+	RuleReferences:  true,
+	RuleSections:    true,
+	RuleMasterData:  true,
+}
+
+// IsLenientRule reports whether the lenient profile downgrades rule to a warning
+func IsLenientRule(rule string) bool {
+	return lenientRules[rule]
+}
+
+// ValidationIssue is a rule an invoice breaks
+type ValidationIssue struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Issues checks the invoice against every validation rule, returning the
+// issues found in the order Validate checks them
+func (i *EInvoice) Issues() []ValidationIssue {
+	var issues []ValidationIssue
+	add := func(rule string, err error) {
+		if err != nil {
+			issues = append(issues, ValidationIssue{Rule: rule, Message: err.Error()})
+		}
+	}
+
+	if !IsValidGSTIN(i.SellerDtls.Gstin) {
+		add(RuleSellerGSTIN, errors.New("invalid seller GSTIN format"))
+	}
+	add(RuleSupplyType, i.validateSupplyType())
+	add(RuleLUT, i.validateLUT())
+	add(RuleReverseCharge, i.validateReverseCharge())
+	for _, item := range i.ItemList {
+		if !IsValidHSNFormat(item.HsnCd) {
+			add(RuleHSNCode, errors.New("HSN code must be 4, 6 or 8 digits"))
+		}
+		if item.Qty < 0 {
+			add(RuleQuantity, errors.New("quantity cannot be negative"))
+		}
+		if item.UnitPrice <= 0 {
+			add(RuleUnitPrice, errors.New("unit price must be greater than zero"))
+		}
+	}
+	add(RuleExemption, i.validateExemptions())
+	if i.FxDtls != nil {
+		add(RuleFx, i.FxDtls.validate(i.ItemList))
+	}
+	if i.RefDtls != nil {
+		add(RuleReferences, i.RefDtls.validate())
+	}
+	add(RuleSections, i.validateSections())
+	return issues
+}
+
+// ValidateProfile validates the invoice under a validation profile. Under the
+// lenient profile the issues of lenient rules are returned as warnings, each
+// message once; every other issue, and any issue under the strict profile,
+// is an error.
+func (i *EInvoice) ValidateProfile(profile string) ([]string, error) {
+	var warnings []string
+	seen := make(map[string]bool)
+	for _, issue := range i.Issues() {
+		if profile != ValidationLenient || !IsLenientRule(issue.Rule) {
+			return nil, errors.New(issue.Message)
+		}
+		if !seen[issue.Message] {
+			seen[issue.Message] = true
+			warnings = append(warnings, issue.Message)
+		}
+	}
+	return warnings, nil
+}
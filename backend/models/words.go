@@ -0,0 +1,146 @@
+package models
+
+import (
+	"math"
+	"strings"
+)
+
+// Languages supported by AmountInWords
+const (
+	WordsLangEnglish = "en"
+	WordsLangHindi   = "hi"
+)
+
+var englishOnes = []string{
+	"Zero", "One", "Two", "Three", "Four", "Five", "Six", "Seven", "Eight", "Nine",
+	"Ten", "Eleven", "Twelve", "Thirteen", "Fourteen", "Fifteen", "Sixteen",
+	"Seventeen", "Eighteen", "Nineteen",
+}
+
+var englishTens = []string{
+	"", "", "Twenty", "Thirty", "Forty", "Fifty", "Sixty", "Seventy", "Eighty", "Ninety",
+}
+
+// hindiNumbers are the Hindi words for 0 to 99, which unlike English do not
+// follow a regular tens and units pattern
+var hindiNumbers = []string{
+	"शून्य", "एक", "दो", "तीन", "चार", "पाँच", "छह", "सात", "आठ", "नौ",
+	"दस", "ग्यारह", "बारह", "तेरह", "चौदह", "पंद्रह", "सोलह", "सत्रह", "अठारह", "उन्नीस",
+	"बीस", "इक्कीस", "बाईस", "तेईस", "चौबीस", "पच्चीस", "छब्बीस", "सत्ताईस", "अट्ठाईस", "उनतीस",
+	"तीस", "इकतीस", "बत्तीस", "तैंतीस", "चौंतीस", "पैंतीस", "छत्तीस", "सैंतीस", "अड़तीस", "उनतालीस",
+	"चालीस", "इकतालीस", "बयालीस", "तैंतालीस", "चौवालीस", "पैंतालीस", "छियालीस", "सैंतालीस", "अड़तालीस", "उनचास",
+	"पचास", "इक्यावन", "बावन", "तिरपन", "चौवन", "पचपन", "छप्पन", "सत्तावन", "अट्ठावन", "उनसठ",
+	"साठ", "इकसठ", "बासठ", "तिरसठ", "चौंसठ", "पैंसठ", "छियासठ", "सड़सठ", "अड़सठ", "उनहत्तर",
+	"सत्तर", "इकहत्तर", "बहत्तर", "तिहत्तर", "चौहत्तर", "पचहत्तर", "छिहत्तर", "सतहत्तर", "अठहत्तर", "उन्यासी",
+	"अस्सी", "इक्यासी", "बयासी", "तिरासी", "चौरासी", "पचासी", "छियासी", "सत्तासी", "अट्ठासी", "नवासी",
+	"नब्बे", "इक्यानवे", "बानवे", "तिरानवे", "चौरानवे", "पचानवे", "छियानवे", "सत्तानवे", "अट्ठानवे", "निन्यानवे",
+}
+
+// numberWords holds the vocabulary of one language for the Indian numbering system
+type numberWords struct {
+	belowHundred func(n int64) string
+	hundred      string
+	thousand     string
+	lakh         string
+	crore        string
+	minus        string
+}
+
+var englishWords = numberWords{
+	belowHundred: func(n int64) string {
+		if n < 20 {
+			return englishOnes[n]
+		}
+		if n%10 == 0 {
+			return englishTens[n/10]
+		}
+		return englishTens[n/10] + "-" + englishOnes[n%10]
+	},
+	hundred:  "Hundred",
+	thousand: "Thousand",
+	lakh:     "Lakh",
+	crore:    "Crore",
+	minus:    "Minus",
+}
+
+var hindiWords = numberWords{
+	belowHundred: func(n int64) string { return hindiNumbers[n] },
+	hundred:      "सौ",
+	thousand:     "हज़ार",
+	lakh:         "लाख",
+	crore:        "करोड़",
+	minus:        "ऋण",
+}
+
+// spell writes out a whole number in the Indian system of lakhs and crores.
+// Amounts of a hundred crore and above are spelled as a number of crores.
+func (w numberWords) spell(n int64) string {
+	if n < 100 {
+		return w.belowHundred(n)
+	}
+
+	var parts []string
+	groups := []struct {
+		size int64
+		name string
+	}{
+		{10000000, w.crore},
+		{100000, w.lakh},
+		{1000, w.thousand},
+		{100, w.hundred},
+	}
+	for _, g := range groups {
+		if n < g.size {
+			continue
+		}
+		count := n / g.size
+		if g.size == 10000000 {
+			parts = append(parts, w.spell(count), g.name)
+		} else {
+			parts = append(parts, w.belowHundred(count), g.name)
+		}
+		n %= g.size
+	}
+	if n > 0 {
+		parts = append(parts, w.belowHundred(n))
+	}
+	return strings.Join(parts, " ")
+}
+
+// AmountInWords spells out a rupee amount in the Indian numbering system, as
+// printed on invoices, e.g. "Rupees Twelve Lakh Thirty-Four Thousand Five
+// Hundred Sixty-Seven and Eighty-Nine Paise Only". lang is WordsLangEnglish
+// or WordsLangHindi; any other value falls back to English.
+func AmountInWords(amount float64, lang string) string {
+	paise := int64(math.Round(math.Abs(amount) * 100))
+	rupees, paise := paise/100, paise%100
+
+	var prefix string
+	if amount < 0 && (rupees > 0 || paise > 0) {
+		prefix = englishWords.minus + " "
+		if lang == WordsLangHindi {
+			prefix = hindiWords.minus + " "
+		}
+	}
+
+	// Amounts under a rupee are spelled in paise alone
+	if lang == WordsLangHindi {
+		if rupees == 0 && paise > 0 {
+			return prefix + hindiWords.spell(paise) + " पैसे मात्र"
+		}
+		s := prefix + hindiWords.spell(rupees) + " रुपये"
+		if paise > 0 {
+			s += " और " + hindiWords.spell(paise) + " पैसे"
+		}
+		return s + " मात्र"
+	}
+
+	if rupees == 0 && paise > 0 {
+		return prefix + englishWords.spell(paise) + " Paise Only"
+	}
+	s := prefix + "Rupees " + englishWords.spell(rupees)
+	if paise > 0 {
+		s += " and " + englishWords.spell(paise) + " Paise"
+	}
+	return s + " Only"
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// nicBulkLine is a line item with the invoice it belongs to, the unit of one
+// row in the bulk upload workbook
+type nicBulkLine struct {
+	invoice *models.EInvoice
+	item    *models.Item
+	igst    float64
+	cgst    float64
+	sgst    float64
+}
+
+// nicBulkColumn is a column of the bulk upload workbook
+type nicBulkColumn struct {
+	header string
+	value  func(l *nicBulkLine) interface{}
+}
+
+// nicBulkColumns are the columns of the e-invoice portal's bulk generation
+// offline utility, in its order. Invoice level values are repeated on each
+// item row; the utility groups rows into invoices by document number.
+var nicBulkColumns = []nicBulkColumn{
+	{"Supply Type Code", func(l *nicBulkLine) interface{} { return l.invoice.TranDtls.SupTyp }},
+	{"Reverse Charge", func(l *nicBulkLine) interface{} { return nicFlag(l.invoice.TranDtls.RegRev) }},
+	{"e-Commerce GSTIN", func(l *nicBulkLine) interface{} { return l.invoice.TranDtls.EcmGstin }},
+	{"IGST on Intra", func(l *nicBulkLine) interface{} { return nicFlag(l.invoice.TranDtls.IgstOnIntra) }},
+	{"Document Type", func(l *nicBulkLine) interface{} { return l.invoice.DocDtls.Typ }},
+	{"Document Number", func(l *nicBulkLine) interface{} { return l.invoice.DocDtls.No }},
+	{"Document Date (DD/MM/YYYY)", func(l *nicBulkLine) interface{} { return l.invoice.DocDtls.Dt }},
+
+	{"Buyer GSTIN", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Gstin }},
+	{"Buyer Legal Name", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.LglNm }},
+	{"Buyer Trade Name", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.TrdNm }},
+	{"Buyer POS", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Pos }},
+	{"Buyer Addr1", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Addr1 }},
+	{"Buyer Addr2", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Addr2 }},
+	{"Buyer Location", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Loc }},
+	{"Buyer Pin Code", func(l *nicBulkLine) interface{} { return nicPin(l.invoice.BuyerDtls.Pin) }},
+	{"Buyer State", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Stcd }},
+	{"Buyer Phone Number", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Ph }},
+	{"Buyer Email Id", func(l *nicBulkLine) interface{} { return l.invoice.BuyerDtls.Em }},
+
+	{"Dispatch Name", func(l *nicBulkLine) interface{} { return nicDispatch(l).Nm }},
+	{"Dispatch Addr1", func(l *nicBulkLine) interface{} { return nicDispatch(l).Addr1 }},
+	{"Dispatch Addr2", func(l *nicBulkLine) interface{} { return nicDispatch(l).Addr2 }},
+	{"Dispatch Location", func(l *nicBulkLine) interface{} { return nicDispatch(l).Loc }},
+	{"Dispatch Pin Code", func(l *nicBulkLine) interface{} { return nicPin(nicDispatch(l).Pin) }},
+	{"Dispatch State", func(l *nicBulkLine) interface{} { return nicDispatch(l).Stcd }},
+
+	{"Shipping GSTIN", func(l *nicBulkLine) interface{} { return nicShipping(l).Gstin }},
+	{"Shipping Legal Name", func(l *nicBulkLine) interface{} { return nicShipping(l).LglNm }},
+	{"Shipping Trade Name", func(l *nicBulkLine) interface{} { return nicShipping(l).TrdNm }},
+	{"Shipping Addr1", func(l *nicBulkLine) interface{} { return nicShipping(l).Addr1 }},
+	{"Shipping Addr2", func(l *nicBulkLine) interface{} { return nicShipping(l).Addr2 }},
+	{"Shipping Location", func(l *nicBulkLine) interface{} { return nicShipping(l).Loc }},
+	{"Shipping Pin Code", func(l *nicBulkLine) interface{} { return nicPin(nicShipping(l).Pin) }},
+	{"Shipping State", func(l *nicBulkLine) interface{} { return nicShipping(l).Stcd }},
+
+	{"Sl. No", func(l *nicBulkLine) interface{} { return l.item.SlNo }},
+	{"Product Description", func(l *nicBulkLine) interface{} { return l.item.PrdDesc }},
+	{"Is the item a GOOD (G) or SERVICE (S)", func(l *nicBulkLine) interface{} {
+		if l.item.IsServc == "Y" {
+			return "S"
+		}
+		return "G"
+	}},
+	{"HSN code", func(l *nicBulkLine) interface{} { return l.item.HsnCd }},
+	{"Batch Name", func(l *nicBulkLine) interface{} {
+		if l.item.BchDtls == nil {
+			return ""
+		}
+		return l.item.BchDtls.Nm
+	}},
+	{"Quantity", func(l *nicBulkLine) interface{} { return l.item.Qty }},
+	{"Free Quantity", func(l *nicBulkLine) interface{} { return l.item.FreeQty }},
+	{"Unit", func(l *nicBulkLine) interface{} { return l.item.Unit }},
+	{"Unit Price", func(l *nicBulkLine) interface{} { return l.item.UnitPrice }},
+	{"Gross Amount", func(l *nicBulkLine) interface{} { return nicAmount(l.item.TotAmt) }},
+	{"Discount", func(l *nicBulkLine) interface{} { return 0.0 }},
+	{"Pre Tax Value", func(l *nicBulkLine) interface{} { return nicAmount(l.item.AssAmt) }},
+	{"Taxable Value", func(l *nicBulkLine) interface{} { return nicAmount(l.item.AssAmt) }},
+	{"GST Rate (%)", func(l *nicBulkLine) interface{} { return l.item.GstRt }},
+	{"IGST Amount", func(l *nicBulkLine) interface{} { return l.igst }},
+	{"CGST Amount", func(l *nicBulkLine) interface{} { return l.cgst }},
+	{"SGST/UTGST Amount", func(l *nicBulkLine) interface{} { return l.sgst }},
+	{"Cess Rate (%)", func(l *nicBulkLine) interface{} { return 0.0 }},
+	{"Cess Amount", func(l *nicBulkLine) interface{} { return 0.0 }},
+	{"Item Total", func(l *nicBulkLine) interface{} { return nicAmount(l.item.TotItemVal) }},
+
+	{"Total Taxable Value", func(l *nicBulkLine) interface{} { return nicAmount(l.invoice.ValDtls.AssVal) }},
+	{"Total Invoice Value", func(l *nicBulkLine) interface{} { return nicAmount(l.invoice.ValDtls.TotInvVal) }},
+
+	{"Shipping Bill No", func(l *nicBulkLine) interface{} { return l.invoice.ExpDtls.ShipBNo }},
+	{"Shipping Bill Date", func(l *nicBulkLine) interface{} { return l.invoice.ExpDtls.ShipBDt }},
+	{"Port Code", func(l *nicBulkLine) interface{} { return l.invoice.ExpDtls.Port }},
+	{"Foreign Currency", func(l *nicBulkLine) interface{} { return nicOptional(l.invoice.ExpDtls.ForCur) }},
+	{"Country Code", func(l *nicBulkLine) interface{} { return nicOptional(l.invoice.ExpDtls.CntCode) }},
+	{"Export Duty", func(l *nicBulkLine) interface{} { return nicAmount(l.invoice.ExpDtls.ExpDuty) }},
+
+	{"Transporter ID", func(l *nicBulkLine) interface{} { return nicEwb(l).TransId }},
+	{"Transporter Name", func(l *nicBulkLine) interface{} { return nicEwb(l).TransName }},
+	{"Trans Mode", func(l *nicBulkLine) interface{} { return nicEwb(l).TransMode }},
+	{"Distance (km)", func(l *nicBulkLine) interface{} {
+		if l.invoice.EwbDtls == nil {
+			return ""
+		}
+		return l.invoice.EwbDtls.Distance
+	}},
+	{"Transport Doc No", func(l *nicBulkLine) interface{} { return nicEwb(l).TransDocNo }},
+	{"Transport Doc Date", func(l *nicBulkLine) interface{} { return nicEwb(l).TransDocDt }},
+	{"Vehicle No", func(l *nicBulkLine) interface{} { return nicEwb(l).VehNo }},
+	{"Vehicle Type", func(l *nicBulkLine) interface{} { return nicEwb(l).VehType }},
+}
+
+// nicFlag writes a Y/N flag the way the utility expects, with blank as N
+func nicFlag(flag string) string {
+	if flag == "Y" {
+		return "Y"
+	}
+	return "N"
+}
+
+// nicPin leaves PIN codes that are not set blank rather than 0
+func nicPin(pin int) interface{} {
+	if pin == 0 {
+		return ""
+	}
+	return pin
+}
+
+// nicAmount rounds a value to the amount precision of the schema
+func nicAmount(value float64) float64 {
+	return models.Round(value, models.AmountDecimals)
+}
+
+// nicOptional writes the free-form export fields, which may be null
+func nicOptional(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// nicDispatch, nicShipping and nicEwb return empty sections for invoices
+// without them, so their columns are left blank
+func nicDispatch(l *nicBulkLine) *models.DispDtls {
+	if l.invoice.DispDtls == nil {
+		return &models.DispDtls{}
+	}
+	return l.invoice.DispDtls
+}
+
+func nicShipping(l *nicBulkLine) *models.ShipDtls {
+	if l.invoice.ShipDtls == nil {
+		return &models.ShipDtls{}
+	}
+	return l.invoice.ShipDtls
+}
+
+func nicEwb(l *nicBulkLine) *models.EwbDtls {
+	if l.invoice.EwbDtls == nil {
+		return &models.EwbDtls{}
+	}
+	return l.invoice.EwbDtls
+}
+
+// nicBulkWorkbook writes invoices in the layout of the bulk generation
+// offline utility: one sheet per supply type, one row per line item
+type nicBulkWorkbook struct {
+	file *excelize.File
+	rows map[string]int
+}
+
+// newNICBulkWorkbook creates a workbook with an empty sheet for each supply type
+func newNICBulkWorkbook() *nicBulkWorkbook {
+	f := excelize.NewFile()
+	w := &nicBulkWorkbook{file: f, rows: make(map[string]int)}
+	for i, supplyType := range models.SupplyTypes {
+		if i == 0 {
+			f.SetSheetName("Sheet1", supplyType)
+		} else {
+			f.NewSheet(supplyType)
+		}
+		headers := make([]interface{}, len(nicBulkColumns))
+		for j, column := range nicBulkColumns {
+			headers[j] = column.header
+		}
+		f.SetSheetRow(supplyType, "A1", &headers)
+		w.rows[supplyType] = 2
+	}
+	return w
+}
+
+// add writes a row for each line item of the invoice on its supply type's
+// sheet, with the item's tax by head as stored on the invoice.
+func (w *nicBulkWorkbook) add(invoice *models.EInvoice) {
+	sheet := invoice.TranDtls.SupTyp
+	if _, ok := w.rows[sheet]; !ok {
+		sheet = models.SupplyTypes[0]
+	}
+
+	for i := range invoice.ItemList {
+		item := &invoice.ItemList[i]
+		igst, cgst, sgst := invoice.ItemTax(item)
+		line := &nicBulkLine{invoice: invoice, item: item, igst: nicAmount(igst), cgst: nicAmount(cgst), sgst: nicAmount(sgst)}
+		values := make([]interface{}, len(nicBulkColumns))
+		for j, column := range nicBulkColumns {
+			values[j] = column.value(line)
+		}
+		cell, _ := excelize.CoordinatesToCellName(1, w.rows[sheet])
+		w.file.SetSheetRow(sheet, cell, &values)
+		w.rows[sheet]++
+	}
+}
+
+// exportNICBulkWorkbook exports the finalized invoices matching the filter in
+// the layout of the e-invoice portal's bulk generation offline utility, so
+// they can be registered without API access. The utility works for one
+// seller GSTIN at a time, so the invoices must all be from one seller.
+// Sandbox invoices are never included.
+func exportNICBulkWorkbook(c *gin.Context, userID int, filter *exportFilter) {
+	rows, err := dbPool.Query(exportContext(c),
+		`SELECT invoice_json FROM invoices
+		WHERE user_id = $1 AND NOT quarantined AND status = $2 AND NOT sandbox AND `+exportFilterSQL+`
+		ORDER BY created_at`,
+		append([]interface{}{userID, models.InvoiceStatusFinalized}, filter.args()...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	wb := newNICBulkWorkbook()
+	defer wb.file.Close()
+
+	sellerGSTIN := ""
+	for rows.Next() {
+		var invoiceJSON []byte
+		if err := rows.Scan(&invoiceJSON); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+			return
+		}
+
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+			continue
+		}
+		if !filter.matchesDate(invoice.DocDtls.Dt) {
+			continue
+		}
+		if sellerGSTIN != "" && invoice.SellerDtls.Gstin != sellerGSTIN {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invoices are from more than one seller; pass seller_gstin to export one seller's invoices"})
+			return
+		}
+		sellerGSTIN = invoice.SellerDtls.Gstin
+
+		wb.add(&invoice)
+	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
+		return
+	}
+
+	buf, err := wb.file.WriteToBuffer()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate Excel file"})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filter.filename("einvoice-bulk-upload", "xlsx")))
+	serveConditional(c, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", time.Time{}, buf.Bytes())
+}
@@ -0,0 +1,733 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"text/template"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// jobTypeSendSMS is the job type that sends a queued SMS notification
+const jobTypeSendSMS = "notification.sms"
+
+// notifierBatchSize is the number of events read per transaction when
+// queueing notifications
+const notifierBatchSize = 500
+
+// notifyMaxAge is how old an event may be and still be notified; older
+// ones, left while no SMS provider was configured, are skipped
+const notifyMaxAge = 24 * time.Hour
+
+// defaultSMSTemplates are the message texts used when a rule has no template
+// of its own, by event and recipient
+var defaultSMSTemplates = map[string]string{
+	models.NotifyInvoiceIssued + ":" + models.NotifyBuyer: "Invoice {{.InvoiceNo}} dated {{.Date}} for Rs. {{.Amount}} from {{.SellerName}}." +
+		"{{if .PaymentLink}} Pay online: {{.PaymentLink}}{{end}}",
+	models.NotifyInvoiceIssued + ":" + models.NotifyOwner: "Invoice {{.InvoiceNo}} for Rs. {{.Amount}} was issued to {{.BuyerName}}.",
+	models.NotifyPaymentReceived + ":" + models.NotifyBuyer: "{{.SellerName}} received your payment of Rs. {{.Amount}} against invoice {{.InvoiceNo}}." +
+		"{{if ne .Outstanding \"0.00\"}} Rs. {{.Outstanding}} is outstanding.{{end}}",
+	models.NotifyPaymentReceived + ":" + models.NotifyOwner: "Payment of Rs. {{.Amount}} received from {{.BuyerName}} against invoice {{.InvoiceNo}}.",
+	models.NotifyPaymentOverdue + ":" + models.NotifyBuyer: "Reminder: Rs. {{.Outstanding}} on invoice {{.InvoiceNo}} from {{.SellerName}} was due on {{.DueDate}}." +
+		"{{if .PaymentLink}} Pay online: {{.PaymentLink}}{{end}}",
+	models.NotifyPaymentOverdue + ":" + models.NotifyOwner: "Rs. {{.Outstanding}} on invoice {{.InvoiceNo}} to {{.BuyerName}} is overdue since {{.DueDate}}.",
+}
+
+// smsTemplateData is the data notification templates are rendered with.
+// Amount is the payment for payment_received and the invoice total otherwise.
+type smsTemplateData struct {
+	InvoiceNo   string
+	Date        string
+	Amount      string
+	Outstanding string
+	DueDate     string
+	SellerName  string
+	BuyerName   string
+	PaymentLink string
+}
+
+// vars returns the data as the variables of a template registered with the provider
+func (d *smsTemplateData) vars() map[string]string {
+	return map[string]string{
+		"invoice_no":  d.InvoiceNo,
+		"date":        d.Date,
+		"amount":      d.Amount,
+		"outstanding": d.Outstanding,
+		"due_date":    d.DueDate,
+		"seller":      d.SellerName,
+		"buyer":       d.BuyerName,
+		"link":        d.PaymentLink,
+	}
+}
+
+// createNotificationTables creates the per-user notification rules, the log
+// of SMS messages and the checkpoint of the events already notified
+func createNotificationTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS notification_rules (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			event VARCHAR(30) NOT NULL,
+			recipient VARCHAR(10) NOT NULL,
+			overdue_days INTEGER NOT NULL DEFAULT 0,
+			template TEXT NOT NULL DEFAULT '',
+			template_id VARCHAR(100) NOT NULL DEFAULT '',
+			enabled BOOLEAN NOT NULL DEFAULT TRUE,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, event, recipient)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create notification_rules table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS sms_messages (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			rule_id INTEGER REFERENCES notification_rules(id) ON DELETE SET NULL,
+			event VARCHAR(30) NOT NULL,
+			recipient VARCHAR(10) NOT NULL,
+			phone VARCHAR(20) NOT NULL,
+			body TEXT NOT NULL,
+			template_id VARCHAR(100) NOT NULL DEFAULT '',
+			vars JSONB NOT NULL DEFAULT '{}'::jsonb,
+			status VARCHAR(20) NOT NULL DEFAULT 'queued',
+			provider VARCHAR(20),
+			provider_message_id VARCHAR(150),
+			error TEXT,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			job_id INTEGER REFERENCES jobs(id) ON DELETE SET NULL,
+			dedupe_key VARCHAR(100) NOT NULL UNIQUE,
+			sent_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create sms_messages table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_sms_messages_invoice ON sms_messages (invoice_id, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create sms_messages index: %v", err)
+	}
+
+	// The checkpoint starts at the end of the log, so events from before
+	// notifications existed are never sent
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS notification_checkpoint (
+			id BOOLEAN PRIMARY KEY DEFAULT TRUE CHECK (id),
+			last_tx_id BIGINT NOT NULL DEFAULT 0,
+			last_event_id BIGINT NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create notification_checkpoint table: %v", err)
+	}
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE notification_checkpoint ADD COLUMN IF NOT EXISTS last_tx_id BIGINT NOT NULL DEFAULT 0")
+	if err != nil {
+		log.Fatalf("Failed to add last_tx_id column to notification_checkpoint table: %v", err)
+	}
+	_, err = dbPool.Exec(context.Background(), `
+		INSERT INTO notification_checkpoint (last_tx_id, last_event_id)
+		SELECT COALESCE(MAX(tx_id), 0), COALESCE(MAX(id), 0) FROM events
+		ON CONFLICT (id) DO NOTHING
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create notification checkpoint: %v", err)
+	}
+
+	registerJobHandler(jobTypeSendSMS, runSendSMSJob)
+}
+
+// startNotifier queues SMS notifications for new invoice events, and
+// reminders for overdue invoices every hour, until the context is cancelled
+func startNotifier(ctx context.Context) {
+	if smsProvider == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		var sweptAt time.Time
+		for {
+			for {
+				n, err := queueEventNotifications(ctx)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Error queueing notifications: %v", err)
+				}
+				if err != nil || n < notifierBatchSize {
+					break
+				}
+			}
+
+			if time.Since(sweptAt) > time.Hour {
+				if err := queueOverdueNotifications(ctx); err != nil && ctx.Err() == nil {
+					log.Printf("Error queueing overdue reminders: %v", err)
+				}
+				sweptAt = time.Now()
+			}
+
+			if err := dispatchQueuedSMS(ctx); err != nil && ctx.Err() == nil {
+				log.Printf("Error dispatching SMS: %v", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// queueEventNotifications queues the notifications of the next batch of
+// settled events and advances the checkpoint in the same transaction, so
+// each event is notified once. It returns the number of events read.
+func queueEventNotifications(ctx context.Context) (int, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var cursor eventCursor
+	err = tx.QueryRow(ctx,
+		"SELECT last_tx_id, last_event_id FROM notification_checkpoint FOR UPDATE").Scan(&cursor.txID, &cursor.eventID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock checkpoint: %w", err)
+	}
+
+	events, err := readSettledEvents(ctx, tx, cursor, notifierBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	for _, e := range events {
+		if err := queueEventNotification(ctx, tx, e); err != nil {
+			return 0, fmt.Errorf("event %d (%s): %w", e.ID, e.Type, err)
+		}
+	}
+
+	cursor = cursorAfter(events[len(events)-1])
+	_, err = tx.Exec(ctx,
+		"UPDATE notification_checkpoint SET last_tx_id = $1, last_event_id = $2, updated_at = NOW()",
+		cursor.txID, cursor.eventID)
+	if err != nil {
+		return 0, err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// queueEventNotification queues the notifications an event triggers:
+// invoice_issued when an invoice is finalized, and payment_received when a
+// payment is recorded
+func queueEventNotification(ctx context.Context, tx pgx.Tx, e *models.Event) error {
+	if e.UserID == nil || time.Since(e.CreatedAt) > notifyMaxAge {
+		return nil
+	}
+
+	var notifyEvent string
+	var invoiceID int
+	var payment *float64
+	switch e.Type {
+	case eventInvoiceCreated, eventInvoiceFinalized:
+		var snapshot invoiceSnapshot
+		if err := json.Unmarshal(e.Payload, &snapshot); err != nil {
+			return fmt.Errorf("invalid invoice snapshot: %w", err)
+		}
+		// Drafts are created unissued and notified when finalized
+		if e.Type == eventInvoiceCreated && snapshot.Status != models.InvoiceStatusFinalized {
+			return nil
+		}
+		notifyEvent, invoiceID = models.NotifyInvoiceIssued, e.EntityID
+	case eventPaymentRecorded:
+		var p models.Payment
+		if err := json.Unmarshal(e.Payload, &p); err != nil {
+			return fmt.Errorf("invalid payment: %w", err)
+		}
+		notifyEvent, invoiceID, payment = models.NotifyPaymentReceived, p.InvoiceID, &p.Amount
+	default:
+		return nil
+	}
+
+	// Rules only apply to events after they were set up
+	rules, err := loadNotificationRules(ctx, tx,
+		"user_id = $1 AND event = $2 AND enabled AND created_at <= $3", *e.UserID, notifyEvent, e.CreatedAt)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	return queueInvoiceSMS(ctx, tx, rules, invoiceID, payment, func(recipient string) string {
+		return fmt.Sprintf("event:%d:%s", e.ID, recipient)
+	})
+}
+
+// queueOverdueNotifications queues one reminder per rule for each issued
+// invoice still unpaid the rule's overdue_days after its due date
+func queueOverdueNotifications(ctx context.Context) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rules, err := loadNotificationRules(ctx, tx, "event = $1 AND enabled", models.NotifyPaymentOverdue)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+
+	for _, rule := range rules {
+		rows, err := tx.Query(ctx, `
+			SELECT id FROM invoices
+			WHERE user_id = $1 AND status = $2 AND NOT sandbox
+				AND `+dueDateSQL+` + $3::int < CURRENT_DATE
+				AND `+outstandingSQL+` > 0.005
+				AND NOT EXISTS (
+					SELECT 1 FROM sms_messages
+					WHERE dedupe_key = 'overdue:' || invoices.id || ':' || $4::text
+				)
+			ORDER BY id
+		`, rule.userID, models.InvoiceStatusFinalized, rule.OverdueDays, rule.Recipient)
+		if err != nil {
+			return err
+		}
+		invoiceIDs := make([]int, 0)
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			invoiceIDs = append(invoiceIDs, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range invoiceIDs {
+			err := queueInvoiceSMS(ctx, tx, []userNotificationRule{rule}, id, nil, func(recipient string) string {
+				return fmt.Sprintf("overdue:%d:%s", id, recipient)
+			})
+			if err != nil {
+				return fmt.Errorf("invoice %d: %w", id, err)
+			}
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// userNotificationRule is a notification rule with the account it belongs to
+type userNotificationRule struct {
+	models.NotificationRule
+	userID int
+}
+
+// loadNotificationRules reads the rules matching a condition on notification_rules
+func loadNotificationRules(ctx context.Context, tx pgx.Tx, where string, args ...interface{}) ([]userNotificationRule, error) {
+	rows, err := tx.Query(ctx, `
+		SELECT id, user_id, event, recipient, overdue_days, template, template_id, enabled, created_at, updated_at
+		FROM notification_rules WHERE `+where+` ORDER BY id`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rules := make([]userNotificationRule, 0)
+	for rows.Next() {
+		var r userNotificationRule
+		if err := rows.Scan(&r.ID, &r.userID, &r.Event, &r.Recipient, &r.OverdueDays, &r.Template,
+			&r.TemplateID, &r.Enabled, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// queueInvoiceSMS queues an SMS per rule about an invoice. Sandbox invoices
+// are skipped, as are recipients without a valid phone number. The dedupe
+// key keeps a notification from being queued twice.
+func queueInvoiceSMS(ctx context.Context, tx pgx.Tx, rules []userNotificationRule, invoiceID int, payment *float64, dedupeKey func(recipient string) string) error {
+	var userID int
+	var invoiceJSON []byte
+	var sandbox bool
+	var paid float64
+	var ownerPhone string
+	err := tx.QueryRow(ctx, `
+		SELECT i.user_id, i.invoice_json, i.sandbox, `+paidAmountSQL+`, u.phone
+		FROM invoices i JOIN users u ON u.id = i.user_id
+		WHERE i.id = $1
+	`, invoiceID).Scan(&userID, &invoiceJSON, &sandbox, &paid, &ownerPhone)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Deleted since the event was recorded
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if sandbox {
+		return nil
+	}
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		return fmt.Errorf("unreadable invoice data: %w", err)
+	}
+
+	total := invoice.AmountPayable()
+	data := smsTemplateData{
+		InvoiceNo:   invoice.DocDtls.No,
+		Date:        invoice.DocDtls.Dt,
+		Amount:      fmt.Sprintf("%.2f", total),
+		Outstanding: fmt.Sprintf("%.2f", models.Rupees(models.Paise(total)-models.Paise(paid))),
+		SellerName:  firstNonEmpty(invoice.SellerDtls.TrdNm, invoice.SellerDtls.LglNm),
+		BuyerName:   firstNonEmpty(invoice.BuyerDtls.TrdNm, invoice.BuyerDtls.LglNm),
+		PaymentLink: activePaymentLinkURL(ctx, invoiceID),
+	}
+	if payment != nil {
+		data.Amount = fmt.Sprintf("%.2f", *payment)
+	}
+	if due, ok := invoiceDueDate(&invoice); ok {
+		data.DueDate = due.Format("02/01/2006")
+	}
+	vars, err := json.Marshal(data.vars())
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		if rule.userID != userID {
+			continue
+		}
+		phone := invoice.BuyerDtls.Ph
+		if rule.Recipient == models.NotifyOwner {
+			phone = ownerPhone
+		}
+		if phone == "" {
+			continue
+		}
+		phone, err := normalizeMobileNumber(phone)
+		if err != nil {
+			continue
+		}
+
+		text := firstNonEmpty(rule.Template, defaultSMSTemplates[rule.Event+":"+rule.Recipient])
+		body, err := renderSMSTemplate(text, &data)
+		if err != nil {
+			log.Printf("Error rendering SMS template of notification rule %d: %v", rule.ID, err)
+			continue
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO sms_messages (user_id, invoice_id, rule_id, event, recipient, phone, body, template_id, vars, status, dedupe_key)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+			ON CONFLICT (dedupe_key) DO NOTHING
+		`, userID, invoiceID, rule.ID, rule.Event, rule.Recipient, phone, body, rule.TemplateID, vars,
+			models.SMSQueued, dedupeKey(rule.Recipient))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// renderSMSTemplate fills in a notification template
+func renderSMSTemplate(text string, data *smsTemplateData) (string, error) {
+	tmpl, err := template.New("sms").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// dispatchQueuedSMS queues a send job for each queued message that has none
+func dispatchQueuedSMS(ctx context.Context) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `
+		SELECT id, user_id FROM sms_messages
+		WHERE status = $1 AND job_id IS NULL
+		ORDER BY id
+		LIMIT 500
+		FOR UPDATE SKIP LOCKED
+	`, models.SMSQueued)
+	if err != nil {
+		return err
+	}
+	type queued struct{ id, userID int }
+	batch := make([]queued, 0)
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.userID); err != nil {
+			rows.Close()
+			return err
+		}
+		batch = append(batch, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, q := range batch {
+		jobID, err := enqueueJob(ctx, q.userID, jobTypeSendSMS, gin.H{"message_id": q.id})
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "UPDATE sms_messages SET job_id = $1 WHERE id = $2", jobID, q.id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit(ctx)
+}
+
+// runSendSMSJob sends a queued SMS notification through the configured provider
+func runSendSMSJob(ctx context.Context, job *models.Job) error {
+	var payload struct {
+		MessageID int `json:"message_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	var msg smsMessage
+	var status string
+	var vars []byte
+	err := dbPool.QueryRow(ctx,
+		"SELECT phone, body, template_id, vars, status FROM sms_messages WHERE id = $1",
+		payload.MessageID).Scan(&msg.phone, &msg.body, &msg.templateID, &vars, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return permanentError(fmt.Errorf("SMS message %d not found", payload.MessageID))
+	}
+	if err != nil {
+		return err
+	}
+	// A retry after the provider accepted the message must not send it again
+	if status == models.SMSSent {
+		return nil
+	}
+	if err := json.Unmarshal(vars, &msg.vars); err != nil {
+		return permanentError(fmt.Errorf("invalid template variables: %w", err))
+	}
+
+	if smsProvider == nil {
+		err = permanentError(errors.New("SMS notifications are not configured"))
+	} else {
+		var providerID string
+		providerID, err = smsProvider.send(ctx, &msg)
+		if err == nil {
+			_, err = dbPool.Exec(ctx, `
+				UPDATE sms_messages
+				SET status = $1, provider = $2, provider_message_id = NULLIF($3, ''), error = NULL,
+					attempts = attempts + 1, sent_at = NOW()
+				WHERE id = $4
+			`, models.SMSSent, smsProvider.name(), providerID, payload.MessageID)
+			return err
+		}
+	}
+
+	if _, dbErr := dbPool.Exec(ctx,
+		"UPDATE sms_messages SET status = $1, error = $2, attempts = attempts + 1 WHERE id = $3",
+		models.SMSFailed, err.Error(), payload.MessageID); dbErr != nil {
+		log.Printf("Error recording failure of SMS message %d: %v", payload.MessageID, dbErr)
+	}
+	return err
+}
+
+// handleGetNotificationRules returns the account's notification rules and the
+// SMS provider messages are sent through
+func handleGetNotificationRules(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, event, recipient, overdue_days, template, template_id, enabled, created_at, updated_at
+		FROM notification_rules WHERE user_id = $1
+		ORDER BY event, recipient
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch notification rules"})
+		return
+	}
+	defer rows.Close()
+
+	rules := make([]models.NotificationRule, 0)
+	for rows.Next() {
+		var r models.NotificationRule
+		if err := rows.Scan(&r.ID, &r.Event, &r.Recipient, &r.OverdueDays, &r.Template, &r.TemplateID,
+			&r.Enabled, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read notification rule data"})
+			return
+		}
+		rules = append(rules, r)
+	}
+	if rowsFailed(c, rows, "Failed to fetch notification rules") {
+		return
+	}
+
+	provider := ""
+	if smsProvider != nil {
+		provider = smsProvider.name()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"rules":    rules,
+		"provider": provider,
+		"events":   models.NotificationEvents,
+	})
+}
+
+// handleSaveNotificationRule creates or replaces the account's rule for an
+// event and recipient
+func handleSaveNotificationRule(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Event       string `json:"event"`
+		Recipient   string `json:"recipient"`
+		OverdueDays int    `json:"overdue_days"`
+		Template    string `json:"template"`
+		TemplateID  string `json:"template_id"`
+		Enabled     *bool  `json:"enabled"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	rule := models.NotificationRule{
+		Event:       req.Event,
+		Recipient:   req.Recipient,
+		OverdueDays: req.OverdueDays,
+		Template:    req.Template,
+		TemplateID:  req.TemplateID,
+		Enabled:     req.Enabled == nil || *req.Enabled,
+	}
+	if err := rule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if rule.Template != "" {
+		if _, err := renderSMSTemplate(rule.Template, &smsTemplateData{}); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid template: " + err.Error()})
+			return
+		}
+	}
+
+	err := dbPool.QueryRow(c.Request.Context(), `
+		INSERT INTO notification_rules (user_id, event, recipient, overdue_days, template, template_id, enabled)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (user_id, event, recipient) DO UPDATE
+		SET overdue_days = $4, template = $5, template_id = $6, enabled = $7, updated_at = NOW()
+		RETURNING id, created_at, updated_at
+	`, userID, rule.Event, rule.Recipient, rule.OverdueDays, rule.Template, rule.TemplateID, rule.Enabled).
+		Scan(&rule.ID, &rule.CreatedAt, &rule.UpdatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save notification rule"})
+		return
+	}
+
+	c.JSON(http.StatusOK, rule)
+}
+
+// handleDeleteNotificationRule removes one of the account's notification rules
+func handleDeleteNotificationRule(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid notification rule ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM notification_rules WHERE id = $1 AND user_id = $2", id, c.GetInt("userID"))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete notification rule"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Notification rule not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Notification rule deleted"})
+}
+
+// handleGetInvoiceSMSMessages returns the SMS notifications sent about an
+// invoice, newest first
+func handleGetInvoiceSMSMessages(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var exists bool
+	err = dbPool.QueryRow(c.Request.Context(),
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, invoice_id, rule_id, event, recipient, phone, body, status, attempts,
+			COALESCE(error, ''), sent_at, created_at
+		FROM sms_messages
+		WHERE invoice_id = $1
+		ORDER BY created_at DESC
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	defer rows.Close()
+
+	messages := make([]models.SMSMessage, 0)
+	for rows.Next() {
+		var m models.SMSMessage
+		if err := rows.Scan(&m.ID, &m.InvoiceID, &m.RuleID, &m.Event, &m.Recipient, &m.Phone, &m.Body,
+			&m.Status, &m.Attempts, &m.Error, &m.SentAt, &m.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read message data"})
+			return
+		}
+		messages = append(messages, m)
+	}
+	if rowsFailed(c, rows, "Failed to fetch messages") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
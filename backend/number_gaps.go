@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// invoiceNumberRegex splits an invoice number into its series prefix and
+// the running number at its end, as in INV-24-25/00042
+var invoiceNumberRegex = regexp.MustCompile(`^(.*?)([0-9]{1,18})$`)
+
+// numberGap is a run of numbers missing from a series
+type numberGap struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int64  `json:"count"`
+}
+
+// outOfOrderInvoice is an invoice numbered below an invoice dated before it
+type outOfOrderInvoice struct {
+	ID        int    `json:"id"`
+	InvoiceNo string `json:"invoice_no"`
+	Date      string `json:"date"`
+	// After is the higher numbered invoice dated before this one
+	AfterInvoiceNo string `json:"after_invoice_no"`
+	AfterDate      string `json:"after_date"`
+}
+
+// numberSeries is the numbering check of one series in the period
+type numberSeries struct {
+	Prefix     string               `json:"prefix"`
+	First      string               `json:"first"`
+	Last       string               `json:"last"`
+	Invoices   int                  `json:"invoices"`
+	Missing    int64                `json:"missing"`
+	Gaps       []numberGap          `json:"gaps"`
+	OutOfOrder []*outOfOrderInvoice `json:"out_of_order"`
+}
+
+// seriesInvoice is an issued invoice placed in its series
+type seriesInvoice struct {
+	id     int
+	no     string
+	date   time.Time
+	number int64
+	width  int
+}
+
+// checkSeries finds the gaps and out-of-order numbers of a series, given
+// its invoices in date order
+func checkSeries(prefix string, invoices []*seriesInvoice) *numberSeries {
+	s := &numberSeries{
+		Prefix:     prefix,
+		Invoices:   len(invoices),
+		Gaps:       make([]numberGap, 0),
+		OutOfOrder: make([]*outOfOrderInvoice, 0),
+	}
+
+	// An invoice numbered below one issued on an earlier day is out of order
+	var highest *seriesInvoice
+	for _, inv := range invoices {
+		if highest != nil && inv.number < highest.number && inv.date.After(highest.date) {
+			s.OutOfOrder = append(s.OutOfOrder, &outOfOrderInvoice{
+				ID:             inv.id,
+				InvoiceNo:      inv.no,
+				Date:           inv.date.Format(invoiceDateLayout),
+				AfterInvoiceNo: highest.no,
+				AfterDate:      highest.date.Format(invoiceDateLayout),
+			})
+		}
+		if highest == nil || inv.number > highest.number {
+			highest = inv
+		}
+	}
+
+	// Numbers between the first and last of the period that no invoice has
+	// are gaps; numbers written with different padding count once
+	byNumber := make([]*seriesInvoice, len(invoices))
+	copy(byNumber, invoices)
+	sort.Slice(byNumber, func(i, j int) bool { return byNumber[i].number < byNumber[j].number })
+	s.First, s.Last = byNumber[0].no, byNumber[len(byNumber)-1].no
+	for i := 1; i < len(byNumber); i++ {
+		prev, next := byNumber[i-1], byNumber[i]
+		if next.number-prev.number <= 1 {
+			continue
+		}
+		gap := numberGap{
+			From:  fmt.Sprintf("%s%0*d", prefix, prev.width, prev.number+1),
+			To:    fmt.Sprintf("%s%0*d", prefix, prev.width, next.number-1),
+			Count: next.number - prev.number - 1,
+		}
+		s.Gaps = append(s.Gaps, gap)
+		s.Missing += gap.Count
+	}
+	return s
+}
+
+// handleNumberGapsReport checks each invoice numbering series of a period
+// for missing numbers and for invoices numbered out of date order. Series
+// are told apart by the prefix before the running number at the end of the
+// invoice number. Finalized and cancelled invoices count; drafts and
+// invoices awaiting approval have no final number yet.
+func handleNumberGapsReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, invoice_no, invoice_date FROM invoices
+		WHERE user_id = $1 AND status IN ('finalized', 'cancelled') AND (NOT sandbox OR $2) AND `+exportFilterSQL+`
+		ORDER BY invoice_date, id`,
+		append([]interface{}{userID, includeSandbox(c)}, filter.args()...)...)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	bySeries := make(map[string][]*seriesInvoice)
+	unsequenced := make([]string, 0)
+	for rows.Next() {
+		inv := &seriesInvoice{}
+		if err := rows.Scan(&inv.id, &inv.no, &inv.date); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+			return
+		}
+		m := invoiceNumberRegex.FindStringSubmatch(inv.no)
+		if m == nil {
+			unsequenced = append(unsequenced, inv.no)
+			continue
+		}
+		inv.number, _ = strconv.ParseInt(m[2], 10, 64)
+		inv.width = len(m[2])
+		bySeries[m[1]] = append(bySeries[m[1]], inv)
+	}
+	if rowsFailed(c, rows, "Failed to fetch invoices") {
+		return
+	}
+
+	series := make([]*numberSeries, 0, len(bySeries))
+	var missing int64
+	outOfOrder := 0
+	for prefix, invoices := range bySeries {
+		s := checkSeries(prefix, invoices)
+		series = append(series, s)
+		missing += s.Missing
+		outOfOrder += len(s.OutOfOrder)
+	}
+	sort.Slice(series, func(i, j int) bool { return series[i].Prefix < series[j].Prefix })
+
+	c.JSON(http.StatusOK, gin.H{
+		"series":       series,
+		"missing":      missing,
+		"out_of_order": outOfOrder,
+		"unsequenced":  unsequenced,
+	})
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// draftNumberPrefix marks placeholder numbers given to drafts created without one
+const draftNumberPrefix = "DRAFT-"
+
+// queryRower is satisfied by both the pool and transactions
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...interface{}) pgx.Row
+}
+
+// createNumberingTables creates the invoice number series table
+func createNumberingTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS invoice_series (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			prefix VARCHAR(20) NOT NULL,
+			next_number INTEGER NOT NULL DEFAULT 1,
+			UNIQUE(user_id, prefix)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_series table: %v", err)
+	}
+}
+
+// nextInvoiceNumber allocates the next unused number in the user's series.
+// Numbers the user has already taken with manually numbered invoices are
+// skipped; other accounts' invoices do not affect the series.
+func nextInvoiceNumber(ctx context.Context, q queryRower, userID int, prefix string) (string, error) {
+	for attempt := 0; attempt < 100; attempt++ {
+		var n int
+		err := q.QueryRow(ctx, `
+			INSERT INTO invoice_series (user_id, prefix, next_number)
+			VALUES ($1, $2, 2)
+			ON CONFLICT (user_id, prefix) DO UPDATE
+			SET next_number = invoice_series.next_number + 1
+			RETURNING next_number - 1
+		`, userID, prefix).Scan(&n)
+		if err != nil {
+			return "", fmt.Errorf("failed to allocate invoice number: %w", err)
+		}
+
+		number := fmt.Sprintf("%s%05d", prefix, n)
+
+		var taken bool
+		err = q.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM invoice_keys WHERE user_id = $1 AND invoice_no = $2)", userID, number).Scan(&taken)
+		if err != nil {
+			return "", fmt.Errorf("failed to check invoice number: %w", err)
+		}
+		if !taken {
+			return number, nil
+		}
+	}
+	return "", fmt.Errorf("no free invoice number found in series %q", prefix)
+}
+
+// invoiceSeriesPrefix returns the prefix of the series an invoice dated date
+// (DD/MM/YYYY) is numbered in. Series that reset each financial year carry
+// the year after the prefix, as in INV-24-25/00001, so each year counts from
+// 1 again; invoices without a readable date are numbered in today's year.
+func invoiceSeriesPrefix(settings *models.UserSettings, date string) string {
+	if settings.SeriesReset != models.SeriesResetFiscalYear {
+		return settings.InvoicePrefix
+	}
+	t, err := time.ParseInLocation(invoiceDateLayout, date, istLocation)
+	if err != nil {
+		t = time.Now().In(istLocation)
+	}
+	return settings.InvoicePrefix + models.FiscalYearOf(t).Short() + "/"
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jackc/pgx/v5"
+)
+
+// Google OAuth 2.0 endpoints
+const (
+	googleAuthURL  = "https://accounts.google.com/o/oauth2/v2/auth"
+	googleTokenURL = "https://oauth2.googleapis.com/token"
+)
+
+// googleStateCookie holds the state parameter between the redirect to Google and the callback
+const googleStateCookie = "google_oauth_state"
+
+// googleStateTTL is how long a user has to complete the Google consent screen
+const googleStateTTL = 10 * time.Minute
+
+// errGoogleDisabled is returned when Google sign-in is not configured
+var errGoogleDisabled = errors.New("Google login is not configured")
+
+// googleHTTPClient is used for all calls to Google's OAuth endpoints
+var googleHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+// googleConfig holds the OAuth client registered in the Google Cloud console
+type googleConfig struct {
+	clientID       string
+	clientSecret   string
+	redirectURL    string
+	allowedDomains []string
+}
+
+// getGoogleConfig reads the Google OAuth client from the environment.
+// GOOGLE_ALLOWED_DOMAINS optionally restricts sign-in to Workspace domains.
+func getGoogleConfig() (*googleConfig, error) {
+	cfg := &googleConfig{
+		clientID:     os.Getenv("GOOGLE_CLIENT_ID"),
+		clientSecret: os.Getenv("GOOGLE_CLIENT_SECRET"),
+		redirectURL:  os.Getenv("GOOGLE_REDIRECT_URL"),
+	}
+	if cfg.clientID == "" || cfg.clientSecret == "" || cfg.redirectURL == "" {
+		return nil, errGoogleDisabled
+	}
+	for _, domain := range strings.Split(os.Getenv("GOOGLE_ALLOWED_DOMAINS"), ",") {
+		if domain = strings.ToLower(strings.TrimSpace(domain)); domain != "" {
+			cfg.allowedDomains = append(cfg.allowedDomains, domain)
+		}
+	}
+	return cfg, nil
+}
+
+// getGoogleLoginRedirect returns the frontend page the callback sends users
+// back to, with the token or error in the URL fragment
+func getGoogleLoginRedirect() string {
+	return getEnvWithDefault("GOOGLE_LOGIN_REDIRECT_URL", strings.TrimRight(os.Getenv("FRONTEND_ORIGIN"), "/")+"/login")
+}
+
+// googleIDClaims are the claims of a Google ID token used to identify the user
+type googleIDClaims struct {
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	HostedDomain  string `json:"hd"`
+	jwt.RegisteredClaims
+}
+
+// exchangeGoogleCode exchanges an authorization code for the user's ID token claims.
+// The ID token comes straight from Google's token endpoint over TLS, so per
+// OpenID Connect its issuer, audience and expiry are checked but not its signature.
+func exchangeGoogleCode(ctx context.Context, cfg *googleConfig, code string) (*googleIDClaims, error) {
+	form := url.Values{}
+	form.Set("code", code)
+	form.Set("client_id", cfg.clientID)
+	form.Set("client_secret", cfg.clientSecret)
+	form.Set("redirect_uri", cfg.redirectURL)
+	form.Set("grant_type", "authorization_code")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, googleTokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := googleHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach Google: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Google rejected the authorization code (HTTP %d)", resp.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil || token.IDToken == "" {
+		return nil, errors.New("Google returned no ID token")
+	}
+
+	claims := &googleIDClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(token.IDToken, claims); err != nil {
+		return nil, fmt.Errorf("invalid ID token: %w", err)
+	}
+	if claims.Issuer != "accounts.google.com" && claims.Issuer != "https://accounts.google.com" {
+		return nil, errors.New("ID token was not issued by Google")
+	}
+	if !slices.Contains(claims.Audience, cfg.clientID) {
+		return nil, errors.New("ID token was issued to another client")
+	}
+	if claims.ExpiresAt == nil || claims.ExpiresAt.Before(time.Now()) {
+		return nil, errors.New("ID token has expired")
+	}
+	if claims.Subject == "" || claims.Email == "" {
+		return nil, errors.New("ID token has no account or email")
+	}
+	return claims, nil
+}
+
+// linkGoogleUser returns the user signed in with a Google account. Accounts
+// already linked are matched on Google's subject ID; otherwise the user with
+// the same verified email is linked, or a new user without a password is created.
+func linkGoogleUser(ctx context.Context, claims *googleIDClaims) (int, string, error) {
+	var userID int
+	var email string
+	err := dbPool.QueryRow(ctx,
+		"SELECT id, email FROM users WHERE google_sub = $1", claims.Subject).Scan(&userID, &email)
+	if err == nil {
+		return userID, email, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", err
+	}
+
+	err = dbPool.QueryRow(ctx, `
+		UPDATE users SET google_sub = $1
+		WHERE LOWER(email) = LOWER($2) AND google_sub IS NULL
+		RETURNING id, email
+	`, claims.Subject, claims.Email).Scan(&userID, &email)
+	if err == nil {
+		return userID, email, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return 0, "", err
+	}
+
+	// The empty password hash never matches, so these users can only sign in with Google
+	err = dbPool.QueryRow(ctx,
+		"INSERT INTO users (email, password, google_sub) VALUES ($1, '', $2) RETURNING id, email",
+		strings.ToLower(claims.Email), claims.Subject).Scan(&userID, &email)
+	return userID, email, err
+}
+
+// handleGoogleLogin starts Google sign-in by redirecting to Google's consent screen
+func handleGoogleLogin(c *gin.Context) {
+	cfg, err := getGoogleConfig()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	state := make([]byte, 16)
+	if _, err := rand.Read(state); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start Google login"})
+		return
+	}
+	stateHex := hex.EncodeToString(state)
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(googleStateCookie, stateHex, int(googleStateTTL.Seconds()), "/api/auth/google",
+		"", os.Getenv("APP_ENV") == "production", true)
+
+	params := url.Values{}
+	params.Set("client_id", cfg.clientID)
+	params.Set("redirect_uri", cfg.redirectURL)
+	params.Set("response_type", "code")
+	params.Set("scope", "openid email")
+	params.Set("state", stateHex)
+	params.Set("prompt", "select_account")
+	if len(cfg.allowedDomains) == 1 {
+		params.Set("hd", cfg.allowedDomains[0])
+	}
+	c.Redirect(http.StatusFound, googleAuthURL+"?"+params.Encode())
+}
+
+// handleGoogleCallback completes Google sign-in and redirects back to the
+// frontend with the same JWT a password login returns
+func handleGoogleCallback(c *gin.Context) {
+	redirect := func(fragment url.Values) {
+		c.Redirect(http.StatusFound, getGoogleLoginRedirect()+"#"+fragment.Encode())
+	}
+	fail := func(message string) {
+		redirect(url.Values{"error": {message}})
+	}
+
+	cfg, err := getGoogleConfig()
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The state must match the cookie set when the login started
+	state, _ := c.Cookie(googleStateCookie)
+	c.SetCookie(googleStateCookie, "", -1, "/api/auth/google", "", os.Getenv("APP_ENV") == "production", true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(c.Query("state"))) != 1 {
+		fail("Google login expired; please try again")
+		return
+	}
+	if errParam := c.Query("error"); errParam != "" {
+		fail("Google login was cancelled")
+		return
+	}
+	code := c.Query("code")
+	if code == "" {
+		fail("Google returned no authorization code")
+		return
+	}
+
+	claims, err := exchangeGoogleCode(c.Request.Context(), cfg, code)
+	if err != nil {
+		log.Printf("Google login failed: %v", err)
+		fail("Google login failed")
+		return
+	}
+	if !claims.EmailVerified {
+		fail("Your Google email address is not verified")
+		return
+	}
+	if len(cfg.allowedDomains) > 0 && !slices.Contains(cfg.allowedDomains, strings.ToLower(claims.HostedDomain)) {
+		fail("Your Google account's domain is not allowed to sign in")
+		return
+	}
+
+	ctx := c.Request.Context()
+	userID, email, err := linkGoogleUser(ctx, claims)
+	if err != nil {
+		log.Printf("Error linking Google account %s: %v", claims.Email, err)
+		fail("Google login failed")
+		return
+	}
+	recordLoginAttempt(ctx, userID, normalizeLoginEmail(email), c.ClientIP(), true)
+
+	token, err := issueToken(ctx, userID)
+	if errors.Is(err, errAccountDisabled) {
+		fail("Account is disabled")
+		return
+	}
+	if err != nil {
+		fail("Failed to generate token")
+		return
+	}
+	redirect(url.Values{"token": {token}})
+}
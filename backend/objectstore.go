@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// s3Store keeps blobs in a bucket of S3-compatible object storage, such as
+// AWS S3, MinIO or Cloudflare R2. Requests are signed with AWS Signature
+// Version 4.
+type s3Store struct {
+	endpoint  *url.URL
+	bucket    string
+	region    string
+	accessKey string
+	secretKey string
+	// pathStyle addresses objects as endpoint/bucket/key instead of bucket.endpoint/key
+	pathStyle bool
+	// urlExpiry is how long signed URLs stay valid
+	urlExpiry time.Duration
+}
+
+// objectStore is the configured object storage, nil when OBJECT_STORAGE_BUCKET is unset
+var objectStore *s3Store
+
+// objectStoreHTTPClient is used for all calls to object storage
+var objectStoreHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// newS3Store reads OBJECT_STORAGE_ENDPOINT, OBJECT_STORAGE_BUCKET,
+// OBJECT_STORAGE_REGION, OBJECT_STORAGE_ACCESS_KEY, OBJECT_STORAGE_SECRET_KEY,
+// OBJECT_STORAGE_PATH_STYLE and OBJECT_STORAGE_URL_EXPIRY. It returns nil
+// when no bucket is set.
+func newS3Store() (*s3Store, error) {
+	bucket := strings.TrimSpace(os.Getenv("OBJECT_STORAGE_BUCKET"))
+	if bucket == "" {
+		return nil, nil
+	}
+	region := getEnvWithDefault("OBJECT_STORAGE_REGION", "us-east-1")
+	endpoint, err := url.Parse(getEnvWithDefault("OBJECT_STORAGE_ENDPOINT", "https://s3."+region+".amazonaws.com"))
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, errors.New("OBJECT_STORAGE_ENDPOINT must be an http:// or https:// URL")
+	}
+	endpoint.Path = strings.TrimRight(endpoint.Path, "/")
+
+	accessKey, secretKey := os.Getenv("OBJECT_STORAGE_ACCESS_KEY"), os.Getenv("OBJECT_STORAGE_SECRET_KEY")
+	if accessKey == "" || secretKey == "" {
+		return nil, errors.New("OBJECT_STORAGE_ACCESS_KEY and OBJECT_STORAGE_SECRET_KEY are required with OBJECT_STORAGE_BUCKET")
+	}
+
+	// Signed URLs may be valid for at most 7 days
+	seconds, err := strconv.Atoi(getEnvWithDefault("OBJECT_STORAGE_URL_EXPIRY", "900"))
+	if err != nil || seconds < 1 || seconds > 7*24*3600 {
+		log.Printf("Invalid OBJECT_STORAGE_URL_EXPIRY value, defaulting to 900")
+		seconds = 900
+	}
+
+	return &s3Store{
+		endpoint:  endpoint,
+		bucket:    bucket,
+		region:    region,
+		accessKey: accessKey,
+		secretKey: secretKey,
+		pathStyle: getEnvWithDefault("OBJECT_STORAGE_PATH_STYLE", "true") == "true",
+		urlExpiry: time.Duration(seconds) * time.Second,
+	}, nil
+}
+
+// initObjectStore configures the object storage signed QR code URLs point
+// to. Without OBJECT_STORAGE_BUCKET, QR codes are only served by the API.
+func initObjectStore() {
+	s, err := newS3Store()
+	if err != nil {
+		log.Fatalf("Failed to configure object storage: %v", err)
+	}
+	if s == nil {
+		return
+	}
+	objectStore = s
+	log.Printf("Serving QR codes from bucket %s at %s", s.bucket, s.endpoint.Host)
+}
+
+// objectURL returns the unsigned URL of an object
+func (s *s3Store) objectURL(key string) *url.URL {
+	u := *s.endpoint
+	path, escaped := "/"+key, "/"+s3Escape(key)
+	if s.pathStyle {
+		path, escaped = "/"+s.bucket+path, "/"+s3Escape(s.bucket)+escaped
+	} else {
+		u.Host = s.bucket + "." + u.Host
+	}
+	u.Path = s.endpoint.Path + path
+	u.RawPath = s.endpoint.EscapedPath() + escaped
+	return &u
+}
+
+// put stores an object, replacing any object with the same key
+func (s *s3Store) put(ctx context.Context, key, contentType string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key).String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, sha256Hex(data), time.Now())
+
+	resp, err := objectStoreHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach object storage: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("object storage returned HTTP %d storing %s: %s", resp.StatusCode, key, s3ErrorCode(resp.Body))
+	}
+	return nil
+}
+
+// signedURL returns a URL from which the object can be downloaded without
+// credentials until the returned expiry
+func (s *s3Store) signedURL(key string, now time.Time) (string, time.Time) {
+	u := s.objectURL(key)
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	query.Set("X-Amz-Credential", s.accessKey+"/"+scope)
+	query.Set("X-Amz-Date", amzDate)
+	query.Set("X-Amz-Expires", strconv.Itoa(int(s.urlExpiry.Seconds())))
+	query.Set("X-Amz-SignedHeaders", "host")
+	canonicalQuery := s3CanonicalQuery(query)
+
+	canonicalRequest := strings.Join([]string{
+		http.MethodGet, u.EscapedPath(), canonicalQuery, "host:" + u.Host + "\n", "host", "UNSIGNED-PAYLOAD",
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+
+	u.RawQuery = canonicalQuery + "&X-Amz-Signature=" + signature
+	return u.String(), now.Add(s.urlExpiry)
+}
+
+// sign adds a Signature Version 4 Authorization header to a request whose
+// body has the given SHA-256 hex digest
+func (s *s3Store) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/" + s.region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// Host, Content-Type and every x-amz-* header are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, req.URL.EscapedPath(), s3CanonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(), signedHeaders, payloadHash,
+	}, "\n")
+	signature := s.signature(now, amzDate, scope, canonicalRequest)
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, signature))
+}
+
+// signature signs a canonical request with a key derived for the request's day and region
+func (s *s3Store) signature(now time.Time, amzDate, scope, canonicalRequest string) string {
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// s3Escape percent-encodes an object key as Signature Version 4 requires,
+// keeping the slashes between its segments
+func s3Escape(key string) string {
+	var b strings.Builder
+	for _, c := range []byte(key) {
+		switch {
+		case c >= 'A' && c <= 'Z', c >= 'a' && c <= 'z', c >= '0' && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/':
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CanonicalQuery encodes query parameters sorted by name, with spaces as %20
+func s3CanonicalQuery(query url.Values) string {
+	return strings.ReplaceAll(query.Encode(), "+", "%20")
+}
+
+// s3ErrorCode extracts the error code from an S3 XML error response
+func s3ErrorCode(body io.Reader) string {
+	data, _ := io.ReadAll(io.LimitReader(body, 1<<16))
+	text := string(data)
+	start := strings.Index(text, "<Code>")
+	end := strings.Index(text, "</Code>")
+	if start < 0 || end < start {
+		return strings.TrimSpace(text)
+	}
+	return text[start+len("<Code>") : end]
+}
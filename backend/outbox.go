@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultPublishedEventTypes are published when EVENT_PUBLISH_TYPES is unset
+var defaultPublishedEventTypes = []string{eventInvoiceCreated, eventInvoiceIRNGenerated, eventPaymentRecorded}
+
+// outboxBatch is the most events the relay publishes per transaction
+const outboxBatch = 100
+
+// outboxRetention is how long published events stay in the outbox
+const outboxRetention = 7 * 24 * time.Hour
+
+// outboxLockKey is the advisory lock held by the relay publishing events, so
+// that with several app instances events still leave in order
+const outboxLockKey = 7415001
+
+// eventPublisher delivers outbox events to a message broker. The relay
+// calls it from a single goroutine.
+type eventPublisher interface {
+	// publish returns once the broker has accepted the event
+	publish(ctx context.Context, msg *outboxMessage) error
+	close()
+}
+
+// publisher is the configured broker, nil when EVENT_BROKER is unset
+var publisher eventPublisher
+
+// eventBroker names the configured broker
+var eventBroker string
+
+// outboxEventTypes are the event types queued for publishing, "*" for all;
+// empty when no broker is configured
+var outboxEventTypes = []string{}
+
+// outboxMessage is the envelope events are published in. Consumers can drop
+// duplicates by id, as an event is published at least once.
+type outboxMessage struct {
+	ID         int64           `json:"id"`
+	Type       string          `json:"type"`
+	EntityType string          `json:"entity_type"`
+	EntityID   int             `json:"entity_id"`
+	UserID     *int            `json:"user_id"`
+	Payload    json.RawMessage `json:"payload"`
+	OccurredAt time.Time       `json:"occurred_at"`
+}
+
+// createOutboxTables creates the outbox of events waiting to be published.
+// Events are queued in the transaction that records them.
+func createOutboxTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS event_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			event_id BIGINT NOT NULL UNIQUE REFERENCES events(id) ON DELETE CASCADE,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			last_error TEXT,
+			next_attempt_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			published_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create event_outbox table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_event_outbox_pending ON event_outbox (id) WHERE published_at IS NULL")
+	if err != nil {
+		log.Fatalf("Failed to create event_outbox index: %v", err)
+	}
+}
+
+// newEventPublisher returns the broker named by EVENT_BROKER, or nil when it is unset
+func newEventPublisher(broker string) (eventPublisher, error) {
+	switch broker {
+	case "":
+		return nil, nil
+	case "nats":
+		return newNATSPublisher()
+	case "kafka":
+		return newKafkaPublisher()
+	}
+	return nil, fmt.Errorf("unknown EVENT_BROKER %q; use nats or kafka", broker)
+}
+
+// initEventPublisher configures the broker events are published to. Without
+// EVENT_BROKER, events are only kept in the event log.
+func initEventPublisher() {
+	broker := strings.ToLower(strings.TrimSpace(os.Getenv("EVENT_BROKER")))
+	p, err := newEventPublisher(broker)
+	if err != nil {
+		log.Fatalf("Failed to configure event publishing: %v", err)
+	}
+	if p == nil {
+		return
+	}
+
+	types := make([]string, 0)
+	for _, t := range strings.Split(os.Getenv("EVENT_PUBLISH_TYPES"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	if len(types) == 0 {
+		types = defaultPublishedEventTypes
+	}
+
+	publisher, eventBroker, outboxEventTypes = p, broker, types
+	log.Printf("Publishing %s events to %s", strings.Join(types, ", "), broker)
+}
+
+// startOutboxRelay publishes queued events until the context is cancelled,
+// and clears out published ones after outboxRetention
+func startOutboxRelay(ctx context.Context) {
+	if publisher == nil {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		defer publisher.close()
+
+		var purgedAt time.Time
+		for {
+			// Drain all due events before waiting for the next tick
+			for {
+				n, err := relayOutbox(ctx)
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Error publishing events: %v", err)
+				}
+				if err != nil || n < outboxBatch {
+					break
+				}
+			}
+
+			if time.Since(purgedAt) > time.Hour {
+				_, err := dbPool.Exec(ctx,
+					"DELETE FROM event_outbox WHERE published_at < NOW() - $1 * INTERVAL '1 second'",
+					int64(outboxRetention.Seconds()))
+				if err != nil && ctx.Err() == nil {
+					log.Printf("Error purging published events: %v", err)
+				}
+				purgedAt = time.Now()
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// outboxBackoff returns how long a failed event waits before it is retried
+func outboxBackoff(attempts int) time.Duration {
+	backoff := time.Duration(attempts*attempts) * 5 * time.Second
+	if backoff > 5*time.Minute {
+		backoff = 5 * time.Minute
+	}
+	return backoff
+}
+
+// relayOutbox publishes a batch of due events in order and returns how many
+// it read. A failed event ends the batch and is retried with backoff, so no
+// later event overtakes it. Events are never dropped; they wait for the
+// broker to come back.
+func relayOutbox(ctx context.Context) (int, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var locked bool
+	if err := tx.QueryRow(ctx, "SELECT pg_try_advisory_xact_lock($1)", outboxLockKey).Scan(&locked); err != nil {
+		return 0, err
+	}
+	if !locked {
+		// Another instance is publishing
+		return 0, nil
+	}
+
+	rows, err := tx.Query(ctx, `
+		SELECT o.id, o.attempts, e.id, e.type, e.entity_type, e.entity_id, e.user_id, e.payload, e.created_at
+		FROM event_outbox o JOIN events e ON e.id = o.event_id
+		WHERE o.published_at IS NULL AND o.next_attempt_at <= NOW()
+		ORDER BY o.id
+		LIMIT $1
+	`, outboxBatch)
+	if err != nil {
+		return 0, err
+	}
+	type queued struct {
+		id       int64
+		attempts int
+		msg      outboxMessage
+	}
+	batch := make([]queued, 0)
+	for rows.Next() {
+		var q queued
+		if err := rows.Scan(&q.id, &q.attempts, &q.msg.ID, &q.msg.Type, &q.msg.EntityType, &q.msg.EntityID,
+			&q.msg.UserID, &q.msg.Payload, &q.msg.OccurredAt); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, q)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	published := make([]int64, 0, len(batch))
+	var publishErr error
+	for _, q := range batch {
+		if err := publisher.publish(ctx, &q.msg); err != nil {
+			publishErr = fmt.Errorf("event %d (%s): %w", q.msg.ID, q.msg.Type, err)
+			_, err = tx.Exec(ctx, `
+				UPDATE event_outbox SET attempts = attempts + 1, last_error = $1,
+					next_attempt_at = NOW() + $2 * INTERVAL '1 second'
+				WHERE id = $3
+			`, err.Error(), int64(outboxBackoff(q.attempts+1).Seconds()), q.id)
+			if err != nil {
+				return 0, err
+			}
+			break
+		}
+		published = append(published, q.id)
+	}
+	if len(published) > 0 {
+		_, err := tx.Exec(ctx, `
+			UPDATE event_outbox SET published_at = NOW(), attempts = attempts + 1, last_error = NULL
+			WHERE id = ANY($1)
+		`, published)
+		if err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(batch), publishErr
+}
+
+// handleAdminGetOutbox reports the broker events are published to and the
+// events still waiting to be published
+func handleAdminGetOutbox(c *gin.Context) {
+	var pending, published int64
+	var oldestPending *time.Time
+	var lastError string
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT COUNT(*) FILTER (WHERE published_at IS NULL),
+			COUNT(*) FILTER (WHERE published_at IS NOT NULL),
+			MIN(created_at) FILTER (WHERE published_at IS NULL),
+			COALESCE((
+				SELECT last_error FROM event_outbox
+				WHERE published_at IS NULL AND last_error IS NOT NULL
+				ORDER BY id LIMIT 1
+			), '')
+		FROM event_outbox
+	`).Scan(&pending, &published, &oldestPending, &lastError)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch outbox"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"broker":            eventBroker,
+		"event_types":       outboxEventTypes,
+		"pending":           pending,
+		"published":         published,
+		"oldest_pending_at": oldestPending,
+		"last_error":        lastError,
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maxPageLimit caps the page size of list endpoints
+const maxPageLimit = 200
+
+// sortField is a column a list endpoint can be sorted by, with the SQL type
+// its cursor value is cast back to
+type sortField struct {
+	column string
+	cast   string
+}
+
+// Sort fields shared by list endpoints
+var (
+	sortByCreatedAt = sortField{column: "created_at", cast: "timestamp"}
+	sortByName      = sortField{column: "name", cast: "text"}
+)
+
+// Sortable fields of each list endpoint
+var (
+	invoiceSortFields = map[string]sortField{
+		"created_at":   sortByCreatedAt,
+		"invoice_no":   {column: "invoice_no", cast: "text"},
+		"invoice_date": {column: "invoice_date", cast: "date"},
+		"total_value":  {column: "total_value", cast: "numeric"},
+	}
+	partySortFields = map[string]sortField{"created_at": sortByCreatedAt, "name": sortByName}
+	eventSortFields = map[string]sortField{"created_at": sortByCreatedAt}
+	userSortFields  = map[string]sortField{"created_at": sortByCreatedAt, "email": {column: "email", cast: "text"}}
+)
+
+// pageCursor marks the last row of a page. It records the sort it was issued
+// for, the row's sort value and its ID, which breaks ties between equal values.
+type pageCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// encode returns the cursor as an opaque URL-safe token
+func (c pageCursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// decodePageCursor reads a cursor token returned as next_cursor
+func decodePageCursor(token string) (*pageCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, err
+	}
+	var c pageCursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// pageRequest is keyset pagination over a list. Rows are ordered by the sort
+// column and then by ID in the same direction, and each page starts after the
+// row the cursor points at, so rows inserted or deleted meanwhile never cause
+// duplicates or skipped rows. Without a limit the whole list is returned.
+type pageRequest struct {
+	sort  string
+	field sortField
+	desc  bool
+	limit int
+	after *pageCursor
+
+	rows    int
+	lastKey string
+	lastID  int64
+	more    bool
+}
+
+// parsePageRequest reads the sort, limit and cursor query parameters. sort is
+// a field name, prefixed with "-" for descending order. On failure it returns
+// the message to respond with.
+func parsePageRequest(c *gin.Context, fields map[string]sortField, defaultSort string) (*pageRequest, string) {
+	p := &pageRequest{sort: c.DefaultQuery("sort", defaultSort)}
+	name := strings.TrimPrefix(p.sort, "-")
+	p.desc = name != p.sort
+	field, ok := fields[name]
+	if !ok {
+		names := make([]string, 0, len(fields))
+		for n := range fields {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Sprintf("Sort must be one of %s, optionally prefixed with -", strings.Join(names, ", "))
+	}
+	p.field = field
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			return nil, "Limit must be a positive number"
+		}
+		if limit > maxPageLimit {
+			limit = maxPageLimit
+		}
+		p.limit = limit
+	}
+
+	if token := c.Query("cursor"); token != "" {
+		cursor, err := decodePageCursor(token)
+		if err != nil {
+			return nil, "Invalid cursor"
+		}
+		if cursor.Sort != p.sort {
+			return nil, "Cursor was issued for a different sort"
+		}
+		p.after = cursor
+	}
+	return p, ""
+}
+
+// keySQL selects the sort value of a row, to be scanned as a string and
+// passed to next
+func (p *pageRequest) keySQL(alias string) string {
+	return fmt.Sprintf("(%s%s)::text", alias, p.field.column)
+}
+
+// whereSQL returns the condition selecting rows after the cursor, adding its
+// arguments to args. alias qualifies the columns, e.g. "i.".
+func (p *pageRequest) whereSQL(alias string, args *[]interface{}) string {
+	if p.after == nil {
+		return ""
+	}
+	op := ">"
+	if p.desc {
+		op = "<"
+	}
+	*args = append(*args, p.after.Value, p.after.ID)
+	return fmt.Sprintf(" AND (%s%s, %sid) %s ($%d::%s, $%d)",
+		alias, p.field.column, alias, op, len(*args)-1, p.field.cast, len(*args))
+}
+
+// orderSQL returns the ORDER BY and LIMIT clauses of the page. One row more
+// than the limit is fetched to tell whether another page follows.
+func (p *pageRequest) orderSQL(alias string) string {
+	dir := "ASC"
+	if p.desc {
+		dir = "DESC"
+	}
+	sql := fmt.Sprintf(" ORDER BY %s%s %s, %sid %s", alias, p.field.column, dir, alias, dir)
+	if p.limit > 0 {
+		sql += fmt.Sprintf(" LIMIT %d", p.limit+1)
+	}
+	return sql
+}
+
+// next records a fetched row and reports whether it belongs on the page.
+// Rows dropped by filters applied after the query must still be passed to
+// next, so the cursor moves past them.
+func (p *pageRequest) next(key string, id int64) bool {
+	if p.limit > 0 && p.rows == p.limit {
+		p.more = true
+		return false
+	}
+	p.rows++
+	p.lastKey, p.lastID = key, id
+	return true
+}
+
+// respond adds the pagination details to a list response
+func (p *pageRequest) respond(body gin.H) gin.H {
+	var nextCursor interface{}
+	if p.more {
+		nextCursor = pageCursor{Sort: p.sort, Value: p.lastKey, ID: p.lastID}.encode()
+	}
+	body["sort"] = p.sort
+	body["has_more"] = p.more
+	body["next_cursor"] = nextCursor
+	return body
+}
+
+// createPageIndexes adds indexes matching the keyset order of the list endpoints
+func createPageIndexes() {
+	indexes := map[string]string{
+		"idx_invoices_user_created":  "invoices (user_id, created_at, id)",
+		"idx_invoices_user_date":     "invoices (user_id, invoice_date, id)",
+		"idx_invoices_user_total":    "invoices (user_id, total_value, id)",
+		"idx_customers_user_name":    "customers (user_id, name, id)",
+		"idx_customers_user_created": "customers (user_id, created_at, id)",
+		"idx_suppliers_user_created": "suppliers (user_id, created_at, id)",
+		"idx_suppliers_user_name":    "suppliers (user_id, name, id)",
+		"idx_events_user_created":    "events (user_id, created_at, id)",
+	}
+	for name, def := range indexes {
+		_, err := dbPool.Exec(context.Background(), "CREATE INDEX IF NOT EXISTS "+name+" ON "+def)
+		if err != nil {
+			log.Fatalf("Failed to create index %s: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// partyImportField is a column of a supplier or customer import. Headers are
+// matched ignoring case, spaces and punctuation against the name and aliases,
+// which cover the ledger exports of Tally and Busy.
+type partyImportField struct {
+	name     string
+	label    string
+	required bool
+	aliases  []string
+}
+
+// partyImportFields are the columns read from a party import, in template order
+var partyImportFields = []partyImportField{
+	{name: "name", label: "Name", required: true, aliases: []string{"party name", "ledger name", "account name", "ledger", "party"}},
+	{name: "gstin", label: "GSTIN", aliases: []string{"gstin/uin", "gst no", "gst number", "gstin no", "gst registration no"}},
+	{name: "address", label: "Address", aliases: []string{"address 1", "address line 1", "mailing address"}},
+	{name: "city", label: "City", aliases: []string{"location", "town", "district"}},
+	{name: "state", label: "State", aliases: []string{"state name", "state code"}},
+	{name: "pincode", label: "PIN Code", aliases: []string{"pin", "pincode", "postal code", "zip"}},
+	{name: "phone", label: "Phone", aliases: []string{"mobile", "mobile no", "phone no", "contact no", "telephone"}},
+	{name: "email", label: "Email", aliases: []string{"e-mail", "email id", "e-mail id"}},
+}
+
+// partyKind is a party master that can be imported or merged
+type partyKind struct {
+	table  string
+	label  string
+	title  string
+	entity string
+	// recordEvents is set for masters whose changes feed projections
+	recordEvents bool
+}
+
+var (
+	supplierParties = partyKind{table: "suppliers", label: "supplier", title: "Suppliers", entity: entitySupplier}
+	customerParties = partyKind{table: "customers", label: "customer", title: "Customers", entity: entityCustomer, recordEvents: true}
+)
+
+// normalizeHeader reduces a header to lower-case letters and digits for matching
+func normalizeHeader(header string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(header) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// resolvePartyColumns finds the column of each field in the header row. The
+// name column is required; other fields may be absent.
+func resolvePartyColumns(header []string) (map[string]int, error) {
+	columns := make(map[string]int)
+	for i, h := range header {
+		key := normalizeHeader(h)
+		if key == "" {
+			continue
+		}
+		for _, f := range partyImportFields {
+			if _, found := columns[f.name]; found {
+				continue
+			}
+			match := key == normalizeHeader(f.name) || key == normalizeHeader(f.label)
+			for _, alias := range f.aliases {
+				match = match || key == normalizeHeader(alias)
+			}
+			if match {
+				columns[f.name] = i
+				break
+			}
+		}
+	}
+	for _, f := range partyImportFields {
+		if _, found := columns[f.name]; f.required && !found {
+			return nil, fmt.Errorf("no %q column found in the header row", f.label)
+		}
+	}
+	return columns, nil
+}
+
+// readPartySheet returns the rows of an uploaded .xlsx or .csv file. Excel
+// files are read from their first sheet.
+func readPartySheet(filename string, data []byte) ([][]string, error) {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".csv":
+		// Spreadsheet programs often save CSV files with a byte order mark
+		data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+		reader := csv.NewReader(bytes.NewReader(data))
+		reader.FieldsPerRecord = -1
+		reader.LazyQuotes = true
+		rows, err := reader.ReadAll()
+		if err != nil {
+			return nil, newImportError(http.StatusBadRequest, "Failed to parse CSV file: %v", err)
+		}
+		return rows, nil
+	case ".xlsx":
+		xlsx, err := excelize.OpenReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, newImportError(http.StatusBadRequest, "Failed to parse Excel file")
+		}
+		defer xlsx.Close()
+		sheets := xlsx.GetSheetList()
+		if len(sheets) == 0 {
+			return nil, newImportError(http.StatusBadRequest, "No sheets found in Excel file")
+		}
+		rows, err := xlsx.GetRows(sheets[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sheet: %w", err)
+		}
+		return rows, nil
+	default:
+		return nil, newImportError(http.StatusBadRequest, "Only Excel (.xlsx) and CSV (.csv) files are supported")
+	}
+}
+
+// parsePartyRow reads and validates a party from a data row, recording
+// problems on the report. The state is stored by name; when it is left blank
+// it is taken from the GSTIN.
+func parsePartyRow(report *uploadReport, cells []string, columns map[string]int, rowNum int) (*models.CustomerDetails, bool) {
+	get := func(field string) string {
+		col, found := columns[field]
+		if !found || col >= len(cells) {
+			return ""
+		}
+		return strings.TrimSpace(cells[col])
+	}
+	column := func(field string) int {
+		if col, found := columns[field]; found {
+			return col
+		}
+		return -1
+	}
+	ok := true
+	fail := func(field, message string) {
+		report.add(rowNum, "", column(field), message)
+		ok = false
+	}
+
+	party := &models.CustomerDetails{
+		Name:    get("name"),
+		GSTIN:   strings.ToUpper(get("gstin")),
+		Address: get("address"),
+		City:    get("city"),
+		Phone:   get("phone"),
+		Email:   get("email"),
+	}
+
+	if party.Name == "" {
+		fail("name", "name is required")
+	} else if len([]rune(party.Name)) > 255 {
+		fail("name", "name must be at most 255 characters")
+	}
+	if party.GSTIN != "" && !models.IsValidGSTIN(party.GSTIN) {
+		fail("gstin", fmt.Sprintf("%q is not a valid GSTIN", party.GSTIN))
+	}
+	if len([]rune(party.City)) > 100 {
+		fail("city", "city must be at most 100 characters")
+	}
+	if len(party.Phone) > 20 {
+		fail("phone", "phone must be at most 20 characters")
+	}
+	if party.Email != "" {
+		if _, err := mail.ParseAddress(party.Email); err != nil || len(party.Email) > 255 {
+			fail("email", fmt.Sprintf("%q is not a valid email address", party.Email))
+		}
+	}
+
+	if pin := strings.ReplaceAll(get("pincode"), " ", ""); pin != "" {
+		n, err := strconv.Atoi(pin)
+		if err != nil || n < 100000 || n > 999999 {
+			fail("pincode", fmt.Sprintf("%q is not a valid PIN code", pin))
+		}
+		party.Pincode = n
+	}
+
+	stateCode := ""
+	if value := get("state"); value != "" {
+		code, found := resolveStateCode(value)
+		if !found {
+			fail("state", fmt.Sprintf("%q is not a known state name or code", value))
+		}
+		stateCode = code
+	} else if len(party.GSTIN) >= 2 {
+		stateCode = party.GSTIN[:2]
+	}
+	if state, found := lookupState(stateCode); found {
+		party.State = state.Name
+	}
+
+	return party, ok
+}
+
+// partyKey identifies a party for de-duplication: registered parties by
+// GSTIN, unregistered ones by name
+func partyKey(name, gstin string) string {
+	if gstin != "" {
+		return "gstin:" + gstin
+	}
+	return "name:" + strings.ToLower(strings.Join(strings.Fields(name), " "))
+}
+
+// partyImport is the result of importing a party spreadsheet
+type partyImport struct {
+	created int
+	updated int
+	skipped int
+	report  *uploadReport
+	rows    [][]string
+}
+
+// summary counts the parties created, updated and skipped and the rows that failed
+func (r *partyImport) summary() gin.H {
+	return gin.H{
+		"created":     r.created,
+		"updated":     r.updated,
+		"skipped":     r.skipped,
+		"failed_rows": len(r.report.failed),
+	}
+}
+
+// importParties stores the valid rows of a party spreadsheet. Parties that
+// already exist, or appear earlier in the file, are matched by GSTIN (or by
+// name when they have none) and are skipped, or updated when update is set.
+// The rows are stored in one transaction.
+func importParties(ctx context.Context, userID int, kind partyKind, rows [][]string, update bool) (*partyImport, error) {
+	if len(rows) < 2 {
+		return nil, newImportError(http.StatusBadRequest, "File does not contain any %s", kind.table)
+	}
+	columns, err := resolvePartyColumns(rows[0])
+	if err != nil {
+		return nil, newImportError(http.StatusBadRequest, "Header row does not match the template: %v", err)
+	}
+	result := &partyImport{report: newUploadReport(rows[0], 1), rows: rows}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	existing := make(map[string]int)
+	existingRows, err := tx.Query(ctx,
+		"SELECT id, name, COALESCE(gstin, '') FROM "+kind.table+" WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	for existingRows.Next() {
+		var id int
+		var name, gstin string
+		if err := existingRows.Scan(&id, &name, &gstin); err != nil {
+			existingRows.Close()
+			return nil, err
+		}
+		if _, found := existing[partyKey(name, gstin)]; !found {
+			existing[partyKey(name, gstin)] = id
+		}
+	}
+	existingRows.Close()
+	if err := existingRows.Err(); err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]int)
+	for i, cells := range rows[1:] {
+		rowNum := i + 2
+		if isBlankRow(cells) {
+			continue
+		}
+		party, ok := parsePartyRow(result.report, cells, columns, rowNum)
+		if !ok {
+			continue
+		}
+		autoFillFromPincode(ctx, party.Pincode, &party.City, &party.State)
+
+		key := partyKey(party.Name, party.GSTIN)
+		if first, dup := seen[key]; dup {
+			message := fmt.Sprintf("duplicate of row %d", first)
+			if party.GSTIN != "" {
+				message = fmt.Sprintf("GSTIN %s is already in row %d", party.GSTIN, first)
+			}
+			result.report.add(rowNum, "", -1, message)
+			continue
+		}
+		seen[key] = rowNum
+
+		id, found := existing[key]
+		eventType := eventCustomerCreated
+		switch {
+		case found && !update:
+			result.skipped++
+			continue
+		case found:
+			_, err = tx.Exec(ctx, `
+				UPDATE `+kind.table+`
+				SET name = $1, address = $2, city = $3, state = $4, pincode = $5, phone = $6, email = $7
+				WHERE id = $8 AND user_id = $9
+			`, party.Name, party.Address, party.City, party.State, party.Pincode, party.Phone, party.Email,
+				id, userID)
+			eventType = eventCustomerUpdated
+			result.updated++
+		default:
+			// Suppliers keep a blank GSTIN; customers leave it NULL so that
+			// it stays out of their unique index
+			gstinSQL := "$3"
+			if kind.recordEvents {
+				gstinSQL = "NULLIF($3, '')"
+			}
+			err = tx.QueryRow(ctx, `
+				INSERT INTO `+kind.table+` (user_id, name, gstin, address, city, state, pincode, phone, email)
+				VALUES ($1, $2, `+gstinSQL+`, $4, $5, $6, $7, $8, $9)
+				RETURNING id
+			`, userID, party.Name, party.GSTIN, party.Address, party.City, party.State, party.Pincode,
+				party.Phone, party.Email).Scan(&id)
+			result.created++
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to store row %d: %w", rowNum, err)
+		}
+
+		if kind.recordEvents {
+			party.ID = id
+			party.UserID = userID
+			recordEvent(ctx, tx, userID, eventType, entityCustomer, id, party)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// handleImportParties returns a handler importing suppliers or customers from
+// an uploaded .xlsx or .csv file. Existing parties are skipped unless
+// mode=update is given. With error_report=xlsx, the file is returned with
+// the failed rows highlighted.
+func handleImportParties(kind partyKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+
+		mode := c.DefaultPostForm("mode", c.DefaultQuery("mode", "skip"))
+		if mode != "skip" && mode != "update" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Mode must be skip or update"})
+			return
+		}
+
+		file, data, ok := readUploadedFile(c, "file")
+		if !ok {
+			return
+		}
+		rows, err := readPartySheet(file.Filename, data)
+		if err == nil {
+			var result *partyImport
+			result, err = importParties(c.Request.Context(), userID, kind, rows, mode == "update")
+			if err == nil {
+				respondPartyImport(c, kind, result)
+				return
+			}
+		}
+
+		var ie *importError
+		if errors.As(err, &ie) {
+			c.JSON(ie.status, gin.H{"error": ie.message})
+			return
+		}
+		log.Printf("Error importing %s: %v", kind.table, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import " + kind.table})
+	}
+}
+
+// respondPartyImport writes the outcome of a party import
+func respondPartyImport(c *gin.Context, kind partyKind, result *partyImport) {
+	rowErrors := result.report.sorted()
+	summary := result.summary()
+
+	if len(rowErrors) > 0 && c.Query("error_report") == "xlsx" {
+		wb, err := result.report.errorWorkbook(result.rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build error report"})
+			return
+		}
+		defer wb.Close()
+
+		c.Header("Content-Disposition", "attachment; filename="+kind.table+"-import-errors.xlsx")
+		c.Header("X-Created", strconv.Itoa(result.created))
+		c.Header("X-Updated", strconv.Itoa(result.updated))
+		c.Header("X-Failed-Rows", strconv.Itoa(len(result.report.failed)))
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+		if err := wb.Write(c.Writer); err != nil {
+			log.Printf("Error writing %s import error report: %v", kind.label, err)
+		}
+		return
+	}
+
+	if result.created+result.updated+result.skipped == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":   fmt.Sprintf("No %s were imported; see errors for details", kind.table),
+			"errors":  rowErrors,
+			"summary": summary,
+		})
+		return
+	}
+
+	message := kind.title + " imported successfully"
+	if len(rowErrors) > 0 {
+		message = kind.title + " imported with errors"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"errors":  rowErrors,
+		"summary": summary,
+	})
+}
+
+// handleDownloadPartyTemplate returns a handler serving the spreadsheet
+// template for supplier or customer imports
+func handleDownloadPartyTemplate(kind partyKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		f := excelize.NewFile()
+		defer f.Close()
+
+		sheet := kind.title
+		f.SetSheetName("Sheet1", sheet)
+		samples := [][]interface{}{
+			{"Sample Traders Pvt Ltd", "27AADCS0472N1Z1", "12 Market Road", "Mumbai", "Maharashtra", 400001, "9876543210", "accounts@example.com"},
+			{"Local Walk-in Party", "", "", "Delhi", "07", 110001, "", ""},
+		}
+		headers := make([]interface{}, len(partyImportFields))
+		for i, field := range partyImportFields {
+			headers[i] = field.label
+		}
+		f.SetSheetRow(sheet, "A1", &headers)
+		for i, sample := range samples {
+			f.SetSheetRow(sheet, "A"+strconv.Itoa(i+2), &sample)
+		}
+
+		f.NewSheet("Instructions")
+		instructions := []string{
+			"Instructions for importing " + kind.table + ":",
+			"1. Each row is one " + kind.label + "; only Name is required",
+			"2. The first row must be the header; columns may be in any order and extra columns are ignored",
+			"3. Ledger exports from Tally or Busy can be uploaded as they are: headers such as 'Ledger Name', 'GSTIN/UIN' and 'Pincode' are recognised",
+			"4. State may be a state name or a GST state code; when blank it is taken from the GSTIN",
+			"5. Rows with a GSTIN that is already saved, or that appears earlier in the file, are skipped; parties without a GSTIN are matched by name",
+			"6. Upload with mode=update to update the saved " + kind.table + " instead of skipping them",
+			"7. Save the file as Excel (.xlsx) or CSV (.csv)",
+		}
+		for i, text := range instructions {
+			f.SetCellValue("Instructions", "A"+strconv.Itoa(i+1), text)
+		}
+
+		buf, err := f.WriteToBuffer()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate template"})
+			return
+		}
+		c.Header("Content-Disposition", "attachment; filename="+kind.label+"_template.xlsx")
+		c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+	}
+}
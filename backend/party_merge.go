@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// partyMergeRequest names the party kept by a merge and the duplicate merged into it
+type partyMergeRequest struct {
+	KeepID  int  `json:"keep_id" binding:"required"`
+	MergeID int  `json:"merge_id" binding:"required"`
+	DryRun  bool `json:"dry_run"`
+}
+
+// loadPartyForUpdate reads a supplier or customer and locks it until the
+// transaction ends
+func loadPartyForUpdate(ctx context.Context, tx pgx.Tx, kind partyKind, userID, id int) (*models.CustomerDetails, error) {
+	p := &models.CustomerDetails{ID: id, UserID: userID}
+	err := tx.QueryRow(ctx, `
+		SELECT name, COALESCE(gstin, ''), COALESCE(address, ''), COALESCE(city, ''),
+			COALESCE(state, ''), COALESCE(pincode, 0), COALESCE(phone, ''), COALESCE(email, ''), created_at
+		FROM `+kind.table+`
+		WHERE id = $1 AND user_id = $2
+		FOR UPDATE
+	`, id, userID).Scan(&p.Name, &p.GSTIN, &p.Address, &p.City, &p.State, &p.Pincode, &p.Phone, &p.Email, &p.CreatedAt)
+	return p, err
+}
+
+// mergePartyDetails fills the blank details of keep from dup. It returns the
+// fields filled and, for fields both parties have with different values,
+// the values of dup that were dropped.
+func mergePartyDetails(keep, dup *models.CustomerDetails) (filled []string, dropped map[string]interface{}) {
+	filled = make([]string, 0)
+	dropped = make(map[string]interface{})
+	text := func(field string, kept *string, other string) {
+		switch {
+		case other == "" || strings.EqualFold(*kept, other):
+		case *kept == "":
+			*kept = other
+			filled = append(filled, field)
+		default:
+			dropped[field] = other
+		}
+	}
+	text("gstin", &keep.GSTIN, dup.GSTIN)
+	text("address", &keep.Address, dup.Address)
+	text("city", &keep.City, dup.City)
+	text("state", &keep.State, dup.State)
+	switch {
+	case dup.Pincode == 0 || keep.Pincode == dup.Pincode:
+	case keep.Pincode == 0:
+		keep.Pincode = dup.Pincode
+		filled = append(filled, "pincode")
+	default:
+		dropped["pincode"] = dup.Pincode
+	}
+	text("phone", &keep.Phone, dup.Phone)
+	text("email", &keep.Email, dup.Email)
+	if !strings.EqualFold(keep.Name, dup.Name) {
+		dropped["name"] = dup.Name
+	}
+	return filled, dropped
+}
+
+// movePartyReferences points the rows of table referencing the duplicate
+// party mergeID through column at the kept party keepID, and returns their
+// IDs. On a dry run the rows are only listed.
+func movePartyReferences(ctx context.Context, tx pgx.Tx, table, column string, userID, keepID, mergeID int, dryRun bool) ([]int, error) {
+	rows, err := tx.Query(ctx,
+		"SELECT id FROM "+table+" WHERE user_id = $1 AND "+column+" = $2 ORDER BY id",
+		userID, mergeID)
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		_, err = tx.Exec(ctx,
+			"UPDATE "+table+" SET "+column+" = $1 WHERE user_id = $2 AND "+column+" = $3",
+			keepID, userID, mergeID)
+	}
+	return ids, err
+}
+
+// handleMergeParties returns a handler merging a duplicate supplier or
+// customer into the one kept. Blank details of the kept party are filled
+// from the duplicate, references to the duplicate are moved to the kept
+// party and the duplicate is deleted, all recorded in the audit log. Parties
+// with different GSTINs are separate registrations and are never merged.
+//
+// Suppliers' purchase invoices are moved to the kept supplier, as are all
+// invoices made out to a duplicate customer. Invoices also carry the buyer
+// details themselves, so drafts made out to the duplicate get the kept
+// customer's details, as with replace-buyer; the JSON of issued invoices
+// stays as it was filed. With dry_run, nothing is saved and the response
+// previews the merge.
+func handleMergeParties(kind partyKind) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID := c.GetInt("userID")
+		ctx := c.Request.Context()
+
+		var req partyMergeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+		if req.KeepID == req.MergeID {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "keep_id and merge_id must be different " + kind.label + "s"})
+			return
+		}
+
+		title := strings.ToUpper(kind.label[:1]) + kind.label[1:]
+
+		tx, err := dbPool.Begin(ctx)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		defer tx.Rollback(ctx)
+
+		// Lock in ID order so that concurrent merges of the same pair cannot deadlock
+		parties := make(map[int]*models.CustomerDetails, 2)
+		ids := []int{req.KeepID, req.MergeID}
+		if ids[0] > ids[1] {
+			ids[0], ids[1] = ids[1], ids[0]
+		}
+		for _, id := range ids {
+			p, err := loadPartyForUpdate(ctx, tx, kind, userID, id)
+			if errors.Is(err, pgx.ErrNoRows) {
+				c.JSON(http.StatusNotFound, gin.H{
+					"error": title + " " + strconv.Itoa(id) + " not found or not authorized", "id": id,
+				})
+				return
+			}
+			if err != nil {
+				log.Printf("Error loading %s %d for merge: %v", kind.label, id, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch " + kind.label + "s"})
+				return
+			}
+			parties[id] = p
+		}
+		keep, dup := parties[req.KeepID], parties[req.MergeID]
+
+		if keep.GSTIN != "" && dup.GSTIN != "" && !strings.EqualFold(keep.GSTIN, dup.GSTIN) {
+			c.JSON(http.StatusConflict, gin.H{
+				"error": "The " + kind.label + "s have different GSTINs (" + keep.GSTIN + ", " + dup.GSTIN +
+					"); clear the wrong one before merging",
+			})
+			return
+		}
+		filled, dropped := mergePartyDetails(keep, dup)
+
+		response := gin.H{
+			"keep_id":  req.KeepID,
+			"merge_id": req.MergeID,
+			"dry_run":  req.DryRun,
+			kind.label: keep,
+			"filled":   filled,
+			"dropped":  dropped,
+		}
+		payload := gin.H{
+			"merged_id": req.MergeID,
+			"merged":    dup,
+			kind.label:  keep,
+			"filled":    filled,
+			"dropped":   dropped,
+		}
+
+		// Move references to the duplicate before deleting it
+		switch kind.entity {
+		case entitySupplier:
+			purchaseIDs, err := movePartyReferences(ctx, tx, "purchase_invoices", "supplier_id",
+				userID, req.KeepID, req.MergeID, req.DryRun)
+			if err != nil {
+				log.Printf("Error moving purchase invoices of supplier %d: %v", req.MergeID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to move purchase invoices"})
+				return
+			}
+			response["purchase_invoice_ids"] = purchaseIDs
+			payload["purchase_invoice_ids"] = purchaseIDs
+		case entityCustomer:
+			fields := make(map[string]bool, len(buyerReplaceFields))
+			for _, f := range buyerReplaceFields {
+				fields[f] = true
+			}
+			drafts, err := replaceBuyerOnDrafts(ctx, tx, userID, buyerFromParty(keep),
+				strings.ToUpper(dup.GSTIN), dup.Name, fields, req.DryRun)
+			if err != nil {
+				log.Printf("Error moving drafts of customer %d: %v", req.MergeID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoices"})
+				return
+			}
+			response["invoices"] = drafts.invoices
+			payload["draft_ids"] = drafts.updated
+
+			// After the drafts, which may have been linked to the duplicate by its GSTIN
+			invoiceIDs, err := movePartyReferences(ctx, tx, "invoices", "customer_id",
+				userID, req.KeepID, req.MergeID, req.DryRun)
+			if err != nil {
+				log.Printf("Error moving invoices of customer %d: %v", req.MergeID, err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoices"})
+				return
+			}
+			response["invoice_ids"] = invoiceIDs
+			payload["invoice_ids"] = invoiceIDs
+		}
+
+		if req.DryRun {
+			c.JSON(http.StatusOK, response)
+			return
+		}
+
+		// The duplicate goes first, as the kept party may take over its GSTIN
+		_, err = tx.Exec(ctx, "DELETE FROM "+kind.table+" WHERE id = $1 AND user_id = $2", req.MergeID, userID)
+		if err == nil {
+			// Suppliers keep a blank GSTIN; customers leave it NULL so that
+			// it stays out of their unique index
+			gstinSQL := "$2"
+			if kind.entity == entityCustomer {
+				gstinSQL = "NULLIF($2, '')"
+			}
+			_, err = tx.Exec(ctx, `
+				UPDATE `+kind.table+`
+				SET gstin = `+gstinSQL+`, address = $3, city = $4, state = $5, pincode = $6, phone = $7, email = $8
+				WHERE id = $1 AND user_id = $9
+			`, req.KeepID, keep.GSTIN, keep.Address, keep.City, keep.State, keep.Pincode, keep.Phone, keep.Email, userID)
+		}
+		if err != nil {
+			log.Printf("Error merging %s %d into %d: %v", kind.label, req.MergeID, req.KeepID, err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge " + kind.label + "s"})
+			return
+		}
+
+		switch kind.entity {
+		case entitySupplier:
+			recordEvent(ctx, tx, userID, eventSupplierMerged, entitySupplier, req.KeepID, payload)
+			recordEvent(ctx, tx, userID, eventSupplierDeleted, entitySupplier, req.MergeID, gin.H{"merged_into": req.KeepID})
+		case entityCustomer:
+			recordEvent(ctx, tx, userID, eventCustomerMerged, entityCustomer, req.KeepID, payload)
+			recordEvent(ctx, tx, userID, eventCustomerDeleted, entityCustomer, req.MergeID, gin.H{"merged_into": req.KeepID})
+		}
+		if err := tx.Commit(ctx); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to merge " + kind.label + "s"})
+			return
+		}
+
+		response["message"] = title + "s merged successfully"
+		c.JSON(http.StatusOK, response)
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+)
+
+// razorpayGateway returns Razorpay. RAZORPAY_API_URL points it at another
+// API, such as a mock in development.
+func razorpayGateway() *paymentGateway {
+	return &paymentGateway{
+		name:         models.PaymentGatewayRazorpay,
+		label:        "Razorpay",
+		apiURL:       strings.TrimRight(getEnvWithDefault("RAZORPAY_API_URL", "https://api.razorpay.com/v1"), "/"),
+		basicAuth:    true,
+		createLink:   razorpayCreateLink,
+		cancelLink:   razorpayCancelLink,
+		parseWebhook: razorpayParseWebhook,
+		errorMessage: razorpayErrorMessage,
+	}
+}
+
+// razorpayErrorMessage reads the message of a Razorpay error response
+func razorpayErrorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Description string `json:"description"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Error.Description != "" {
+		return resp.Error.Description
+	}
+	return "unexpected response"
+}
+
+// razorpayCreateLink creates a Razorpay payment link. Razorpay does not
+// notify the buyer; the link is sent with the invoice instead.
+func razorpayCreateLink(ctx context.Context, a *gatewayAccount, req *paymentLinkRequest) (string, string, error) {
+	customer := map[string]string{}
+	if req.buyerName != "" {
+		customer["name"] = truncate(req.buyerName, 100)
+	}
+	if req.buyerEmail != "" {
+		customer["email"] = req.buyerEmail
+	}
+	if req.buyerPhone != "" {
+		customer["contact"] = req.buyerPhone
+	}
+	body := map[string]interface{}{
+		"amount":          req.amountPaise,
+		"currency":        "INR",
+		"accept_partial":  false,
+		"description":     truncate(req.description, 2048),
+		"reference_id":    truncate(req.invoiceNo, 40),
+		"notify":          map[string]bool{"sms": false, "email": false},
+		"reminder_enable": false,
+		"notes": map[string]string{
+			"invoice_id": strconv.Itoa(req.invoiceID),
+			"invoice_no": req.invoiceNo,
+		},
+	}
+	if len(customer) > 0 {
+		body["customer"] = customer
+	}
+
+	var resp struct {
+		ID       string `json:"id"`
+		ShortURL string `json:"short_url"`
+	}
+	if err := a.call(ctx, "/payment_links", body, &resp); err != nil {
+		return "", "", err
+	}
+	if resp.ID == "" || resp.ShortURL == "" {
+		return "", "", errors.New("Razorpay returned no payment link")
+	}
+	return resp.ID, resp.ShortURL, nil
+}
+
+// razorpayCancelLink cancels a Razorpay payment link
+func razorpayCancelLink(ctx context.Context, a *gatewayAccount, id string) error {
+	return a.call(ctx, "/payment_links/"+id+"/cancel", map[string]interface{}{}, nil)
+}
+
+// razorpayParseWebhook verifies the X-Razorpay-Signature of a webhook, the
+// hex HMAC-SHA256 of its body, and reads the payment of payment_link.paid
+// events
+func razorpayParseWebhook(header http.Header, body []byte, secret string) (*gatewayPayment, error) {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	signature, err := hex.DecodeString(header.Get("X-Razorpay-Signature"))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, errWebhookSignature
+	}
+
+	var event struct {
+		Event   string `json:"event"`
+		Payload struct {
+			PaymentLink struct {
+				Entity struct {
+					ID string `json:"id"`
+				} `json:"entity"`
+			} `json:"payment_link"`
+			Payment struct {
+				Entity struct {
+					ID     string `json:"id"`
+					Amount int64  `json:"amount"`
+				} `json:"entity"`
+			} `json:"payment"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	if event.Event != "payment_link.paid" {
+		return nil, nil
+	}
+	payment := event.Payload.Payment.Entity
+	return &gatewayPayment{
+		linkID:      event.Payload.PaymentLink.Entity.ID,
+		paymentID:   payment.ID,
+		amountPaise: payment.Amount,
+	}, nil
+}
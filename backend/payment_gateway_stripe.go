@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+)
+
+// stripeWebhookTolerance is how old a Stripe webhook's signature timestamp
+// may be, to stop replays
+const stripeWebhookTolerance = 5 * time.Minute
+
+// stripeGateway returns Stripe. STRIPE_API_URL points it at another API,
+// such as a mock in development.
+func stripeGateway() *paymentGateway {
+	return &paymentGateway{
+		name:         models.PaymentGatewayStripe,
+		label:        "Stripe",
+		apiURL:       strings.TrimRight(getEnvWithDefault("STRIPE_API_URL", "https://api.stripe.com/v1"), "/"),
+		createLink:   stripeCreateLink,
+		cancelLink:   stripeCancelLink,
+		parseWebhook: stripeParseWebhook,
+		errorMessage: stripeErrorMessage,
+	}
+}
+
+// stripeErrorMessage reads the message of a Stripe error response
+func stripeErrorMessage(body []byte) string {
+	var resp struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &resp) == nil && resp.Error.Message != "" {
+		return resp.Error.Message
+	}
+	return "unexpected response"
+}
+
+// stripeCreateLink creates a Stripe payment link for a one-off price of the
+// invoice's amount. The link accepts a single payment.
+func stripeCreateLink(ctx context.Context, a *gatewayAccount, req *paymentLinkRequest) (string, string, error) {
+	var price struct {
+		ID string `json:"id"`
+	}
+	err := a.call(ctx, "/prices", url.Values{
+		"currency":           {"inr"},
+		"unit_amount":        {strconv.FormatInt(req.amountPaise, 10)},
+		"product_data[name]": {truncate(req.description, 250)},
+	}, &price)
+	if err != nil {
+		return "", "", err
+	}
+
+	var link struct {
+		ID  string `json:"id"`
+		URL string `json:"url"`
+	}
+	err = a.call(ctx, "/payment_links", url.Values{
+		"line_items[0][price]":                    {price.ID},
+		"line_items[0][quantity]":                 {"1"},
+		"metadata[invoice_id]":                    {strconv.Itoa(req.invoiceID)},
+		"metadata[invoice_no]":                    {req.invoiceNo},
+		"restrictions[completed_sessions][limit]": {"1"},
+	}, &link)
+	if err != nil {
+		return "", "", err
+	}
+	if link.ID == "" || link.URL == "" {
+		return "", "", errors.New("Stripe returned no payment link")
+	}
+	return link.ID, link.URL, nil
+}
+
+// stripeCancelLink deactivates a Stripe payment link
+func stripeCancelLink(ctx context.Context, a *gatewayAccount, id string) error {
+	return a.call(ctx, "/payment_links/"+id, url.Values{"active": {"false"}}, nil)
+}
+
+// stripeParseWebhook verifies the Stripe-Signature of a webhook, an HMAC of
+// its timestamp and body, and reads the payment of a completed checkout of a
+// payment link
+func stripeParseWebhook(header http.Header, body []byte, secret string) (*gatewayPayment, error) {
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header.Get("Stripe-Signature"), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, errWebhookSignature
+	}
+	if age := time.Since(time.Unix(unix, 0)); age > stripeWebhookTolerance || age < -stripeWebhookTolerance {
+		return nil, errWebhookSignature
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	valid := false
+	for _, sig := range signatures {
+		valid = valid || hmac.Equal(sig, expected)
+	}
+	if !valid {
+		return nil, errWebhookSignature
+	}
+
+	var event struct {
+		Type string `json:"type"`
+		Data struct {
+			Object struct {
+				PaymentLink   string `json:"payment_link"`
+				PaymentIntent string `json:"payment_intent"`
+				PaymentStatus string `json:"payment_status"`
+				AmountTotal   int64  `json:"amount_total"`
+			} `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &event); err != nil {
+		return nil, err
+	}
+	// Delayed payment methods complete the session before the payment succeeds
+	session := event.Data.Object
+	if event.Type != "checkout.session.completed" && event.Type != "checkout.session.async_payment_succeeded" {
+		return nil, nil
+	}
+	if session.PaymentLink == "" || session.PaymentStatus != "paid" {
+		return nil, nil
+	}
+	return &gatewayPayment{
+		linkID:      session.PaymentLink,
+		paymentID:   session.PaymentIntent,
+		amountPaise: session.AmountTotal,
+	}, nil
+}
@@ -0,0 +1,903 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// errUnknownPaymentGateway is returned for gateways other than Razorpay and Stripe
+var errUnknownPaymentGateway = errors.New("Unknown payment gateway; use razorpay or stripe")
+
+// errWebhookSignature is returned for webhooks whose signature does not match
+// the gateway's webhook secret
+var errWebhookSignature = errors.New("invalid webhook signature")
+
+// paymentGatewayHTTPClient is used for all calls to payment gateways
+var paymentGatewayHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// paymentGateway is a payment gateway that hosts pages where buyers pay
+// invoices, and notifies payments with signed webhooks
+type paymentGateway struct {
+	name   string
+	label  string
+	apiURL string
+	// basicAuth authenticates API calls with the key ID and secret instead of
+	// the secret as a bearer token
+	basicAuth bool
+	// createLink creates a payment page for an invoice and returns its ID and URL
+	createLink func(ctx context.Context, a *gatewayAccount, req *paymentLinkRequest) (string, string, error)
+	// cancelLink stops a payment page from accepting payments
+	cancelLink func(ctx context.Context, a *gatewayAccount, id string) error
+	// parseWebhook verifies a webhook with the webhook secret and returns the
+	// payment of a link it notifies, or nil for other events
+	parseWebhook func(header http.Header, body []byte, secret string) (*gatewayPayment, error)
+	// errorMessage reads the message of an API error response
+	errorMessage func(body []byte) string
+}
+
+// newPaymentGateway returns the gateway with the given name
+func newPaymentGateway(name string) (*paymentGateway, bool) {
+	switch name {
+	case models.PaymentGatewayRazorpay:
+		return razorpayGateway(), true
+	case models.PaymentGatewayStripe:
+		return stripeGateway(), true
+	}
+	return nil, false
+}
+
+// gatewaySecrets are the secrets of a gateway account; they are encrypted at rest
+type gatewaySecrets struct {
+	KeySecret     string `json:"key_secret"`
+	WebhookSecret string `json:"webhook_secret"`
+}
+
+// gatewayAccount is a user's gateway account with its decrypted secrets
+type gatewayAccount struct {
+	id      int
+	userID  int
+	gateway *paymentGateway
+	keyID   string
+	secrets gatewaySecrets
+}
+
+// paymentLinkRequest is what a payment page is created for
+type paymentLinkRequest struct {
+	invoiceID   int
+	invoiceNo   string
+	description string
+	amountPaise int64
+	buyerName   string
+	buyerEmail  string
+	buyerPhone  string
+}
+
+// gatewayPayment is a payment of a link notified by a gateway's webhook
+type gatewayPayment struct {
+	linkID      string
+	paymentID   string
+	amountPaise int64
+}
+
+// paymentGatewayAAD binds stored secrets to their user and gateway
+func paymentGatewayAAD(userID int, gateway string) []byte {
+	return []byte(fmt.Sprintf("payment_gateways:%d:%s", userID, gateway))
+}
+
+// createPaymentLinkTables creates users' payment gateway accounts and the
+// payment links of invoices
+func createPaymentLinkTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS payment_gateways (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+			gateway VARCHAR(20) NOT NULL,
+			key_id VARCHAR(100) NOT NULL DEFAULT '',
+			master_key_id VARCHAR(100) NOT NULL,
+			wrapped_key BYTEA NOT NULL,
+			ciphertext BYTEA NOT NULL,
+			webhook_token VARCHAR(64) NOT NULL UNIQUE,
+			webhook_configured BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, gateway)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create payment_gateways table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS payment_links (
+			id SERIAL PRIMARY KEY,
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			gateway VARCHAR(20) NOT NULL,
+			gateway_id VARCHAR(100) NOT NULL,
+			url TEXT NOT NULL,
+			amount DECIMAL(14,2) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'active',
+			payment_id INTEGER REFERENCES payments(id) ON DELETE SET NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			paid_at TIMESTAMP,
+			UNIQUE(gateway, gateway_id)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create payment_links table: %v", err)
+	}
+
+	// An invoice has at most one link that can be paid
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_payment_links_active
+		ON payment_links (invoice_id) WHERE status = 'active'
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create payment_links index: %v", err)
+	}
+
+	// Payments received through a gateway are recorded once, however often
+	// the gateway notifies them
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE payments ADD COLUMN IF NOT EXISTS gateway_reference VARCHAR(150) UNIQUE")
+	if err != nil {
+		log.Fatalf("Failed to add gateway_reference column to payments table: %v", err)
+	}
+}
+
+// paymentWebhookURL returns where a gateway account's webhooks are sent.
+// PUBLIC_API_URL is the address the API is reachable on from the internet;
+// without it only the path is returned.
+func paymentWebhookURL(gateway, token string) string {
+	return strings.TrimRight(os.Getenv("PUBLIC_API_URL"), "/") + "/api/webhooks/payments/" + gateway + "/" + token
+}
+
+// loadGatewayAccount returns a user's account with a gateway and its secrets,
+// or pgx.ErrNoRows when the gateway is not set up
+func loadGatewayAccount(ctx context.Context, userID int, g *paymentGateway) (*gatewayAccount, error) {
+	if vault == nil {
+		return nil, errVaultDisabled
+	}
+	a := &gatewayAccount{userID: userID, gateway: g}
+	var sealed sealedSecret
+	err := dbPool.QueryRow(ctx, `
+		SELECT id, key_id, master_key_id, wrapped_key, ciphertext
+		FROM payment_gateways WHERE user_id = $1 AND gateway = $2
+	`, userID, g.name).Scan(&a.id, &a.keyID, &sealed.KeyID, &sealed.WrappedKey, &sealed.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := vault.open(&sealed, paymentGatewayAAD(userID, g.name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt payment gateway secrets: %w", err)
+	}
+	if err := json.Unmarshal(plaintext, &a.secrets); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// call sends a request to the gateway's API and decodes the response into
+// out. Form values are sent form-encoded and other bodies as JSON.
+func (a *gatewayAccount) call(ctx context.Context, path string, body, out interface{}) error {
+	var reader io.Reader
+	contentType := "application/json"
+	if form, ok := body.(url.Values); ok {
+		reader = strings.NewReader(form.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	} else {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.gateway.apiURL+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+	if a.gateway.basicAuth {
+		req.SetBasicAuth(a.keyID, a.secrets.KeySecret)
+	} else {
+		req.Header.Set("Authorization", "Bearer "+a.secrets.KeySecret)
+	}
+
+	resp, err := paymentGatewayHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", a.gateway.label, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned HTTP %d: %s", a.gateway.label, resp.StatusCode, a.gateway.errorMessage(respBody))
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			return fmt.Errorf("invalid response from %s: %w", a.gateway.label, err)
+		}
+	}
+	return nil
+}
+
+// paymentLinkColumns are selected by scanPaymentLink
+const paymentLinkColumns = "id, invoice_id, gateway, gateway_id, url, amount::float8, status, payment_id, created_at, paid_at"
+
+// scanPaymentLink reads a row of paymentLinkColumns
+func scanPaymentLink(row pgx.Row) (*models.PaymentLink, error) {
+	var l models.PaymentLink
+	err := row.Scan(&l.ID, &l.InvoiceID, &l.Gateway, &l.GatewayID, &l.URL, &l.Amount, &l.Status,
+		&l.PaymentID, &l.CreatedAt, &l.PaidAt)
+	if err != nil {
+		return nil, err
+	}
+	return &l, nil
+}
+
+// activePaymentLinkURL returns the URL of the invoice's link that can be
+// paid, or "" when it has none
+func activePaymentLinkURL(ctx context.Context, invoiceID int) string {
+	var linkURL string
+	err := dbPool.QueryRow(ctx,
+		"SELECT url FROM payment_links WHERE invoice_id = $1 AND status = $2",
+		invoiceID, models.PaymentLinkActive).Scan(&linkURL)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Error fetching payment link of invoice %d: %v", invoiceID, err)
+	}
+	return linkURL
+}
+
+// createPaymentLink creates a link on the gateway for the outstanding amount
+// of a finalized invoice. The gateway may be left empty when the user has
+// set up only one. A link the invoice already has is cancelled, so buyers
+// cannot pay twice.
+func createPaymentLink(ctx context.Context, userID, invoiceID int, gatewayName string) (*models.PaymentLink, error) {
+	if vault == nil {
+		return nil, &importError{status: http.StatusServiceUnavailable, message: errVaultDisabled.Error()}
+	}
+
+	var status string
+	var invoiceJSON []byte
+	var paid float64
+	err := dbPool.QueryRow(ctx,
+		"SELECT status, invoice_json, "+paidAmountSQL+" FROM invoices WHERE id = $1 AND user_id = $2",
+		invoiceID, userID).Scan(&status, &invoiceJSON, &paid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, newImportError(http.StatusNotFound, "Invoice not found or not authorized")
+	}
+	if err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Database error")
+	}
+	if status != models.InvoiceStatusFinalized {
+		return nil, newImportError(http.StatusConflict, "Payment links can only be created for finalized invoices")
+	}
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Failed to parse invoice data")
+	}
+	if invoice.DocDtls.Typ == "CRN" {
+		return nil, newImportError(http.StatusConflict, "Credit notes cannot be paid by link")
+	}
+	summary := models.NewPaymentSummary(invoice.AmountPayable(), paid)
+	if summary.Outstanding <= 0 {
+		return nil, newImportError(http.StatusConflict, "Invoice is already paid")
+	}
+
+	if gatewayName == "" {
+		names := make([]string, 0)
+		rows, err := dbPool.Query(ctx, "SELECT gateway FROM payment_gateways WHERE user_id = $1", userID)
+		if err != nil {
+			return nil, newImportError(http.StatusInternalServerError, "Database error")
+		}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				rows.Close()
+				return nil, newImportError(http.StatusInternalServerError, "Database error")
+			}
+			names = append(names, name)
+		}
+		rows.Close()
+		switch len(names) {
+		case 0:
+			return nil, newImportError(http.StatusConflict, "No payment gateway is set up")
+		case 1:
+			gatewayName = names[0]
+		default:
+			return nil, newImportError(http.StatusBadRequest, "Choose the gateway: %s", strings.Join(names, " or "))
+		}
+	}
+	g, ok := newPaymentGateway(gatewayName)
+	if !ok {
+		return nil, &importError{status: http.StatusBadRequest, message: errUnknownPaymentGateway.Error()}
+	}
+	account, err := loadGatewayAccount(ctx, userID, g)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, newImportError(http.StatusConflict, "%s is not set up", g.label)
+	}
+	if err != nil {
+		log.Printf("Error loading %s account of user %d: %v", g.label, userID, err)
+		return nil, newImportError(http.StatusInternalServerError, "Failed to load payment gateway")
+	}
+
+	buyer := invoice.BuyerDtls
+	gatewayID, linkURL, err := g.createLink(ctx, account, &paymentLinkRequest{
+		invoiceID:   invoiceID,
+		invoiceNo:   invoice.DocDtls.No,
+		description: fmt.Sprintf("Invoice %s from %s", invoice.DocDtls.No, firstNonEmpty(invoice.SellerDtls.TrdNm, invoice.SellerDtls.LglNm)),
+		amountPaise: models.Paise(summary.Outstanding),
+		buyerName:   firstNonEmpty(buyer.TrdNm, buyer.LglNm),
+		buyerEmail:  buyer.Em,
+		buyerPhone:  buyer.Ph,
+	})
+	if err != nil {
+		log.Printf("Error creating %s payment link for invoice %d: %v", g.label, invoiceID, err)
+		return nil, newImportError(http.StatusBadGateway, "Failed to create payment link: %v", err)
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Database error")
+	}
+	defer tx.Rollback(ctx)
+
+	replaced := make([]*models.PaymentLink, 0)
+	rows, err := tx.Query(ctx, `
+		UPDATE payment_links SET status = $1, updated_at = NOW()
+		WHERE invoice_id = $2 AND status = $3
+		RETURNING `+paymentLinkColumns,
+		models.PaymentLinkCancelled, invoiceID, models.PaymentLinkActive)
+	if err != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Failed to store payment link")
+	}
+	for rows.Next() {
+		l, err := scanPaymentLink(rows)
+		if err != nil {
+			rows.Close()
+			return nil, newImportError(http.StatusInternalServerError, "Failed to store payment link")
+		}
+		replaced = append(replaced, l)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, newImportError(http.StatusInternalServerError, "Failed to store payment link")
+	}
+
+	link, err := scanPaymentLink(tx.QueryRow(ctx, `
+		INSERT INTO payment_links (invoice_id, user_id, gateway, gateway_id, url, amount)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+paymentLinkColumns,
+		invoiceID, userID, g.name, gatewayID, linkURL, summary.Outstanding))
+	if err == nil {
+		err = tx.Commit(ctx)
+	}
+	if err != nil {
+		// Another request created a link at the same time; withdraw this one
+		cancelGatewayLinks(ctx, userID, []*models.PaymentLink{{Gateway: g.name, GatewayID: gatewayID}})
+		if isUniqueViolation(err) {
+			return nil, newImportError(http.StatusConflict, "A payment link is already being created for this invoice")
+		}
+		return nil, newImportError(http.StatusInternalServerError, "Failed to store payment link")
+	}
+
+	cancelGatewayLinks(ctx, userID, replaced)
+	return link, nil
+}
+
+// cancelGatewayLinks withdraws links on their gateways after they were
+// cancelled here. Failures are logged: a payment of such a link is still
+// recorded when its webhook arrives.
+func cancelGatewayLinks(ctx context.Context, userID int, links []*models.PaymentLink) {
+	for _, l := range links {
+		g, ok := newPaymentGateway(l.Gateway)
+		if !ok {
+			continue
+		}
+		account, err := loadGatewayAccount(ctx, userID, g)
+		if err == nil {
+			err = g.cancelLink(ctx, account, l.GatewayID)
+		}
+		if err != nil {
+			log.Printf("Error cancelling %s payment link %s: %v", g.label, l.GatewayID, err)
+		}
+	}
+}
+
+// recordGatewayPayment records the payment of a link against its invoice
+// and marks the link paid. Payments already recorded are skipped. The
+// payment is recorded as received even when it exceeds the outstanding
+// balance or the invoice was cancelled meanwhile, as the buyer has paid.
+func recordGatewayPayment(ctx context.Context, userID int, g *paymentGateway, p *gatewayPayment) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	link, err := scanPaymentLink(tx.QueryRow(ctx,
+		"SELECT "+paymentLinkColumns+" FROM payment_links WHERE gateway = $1 AND gateway_id = $2 AND user_id = $3 FOR UPDATE",
+		g.name, p.linkID, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		log.Printf("Ignoring %s payment %s of unknown link %s", g.label, p.paymentID, p.linkID)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if p.amountPaise <= 0 {
+		p.amountPaise = models.Paise(link.Amount)
+	}
+
+	payment := models.Payment{
+		InvoiceID:   link.InvoiceID,
+		UserID:      userID,
+		Amount:      models.Rupees(p.amountPaise),
+		PaymentDate: todayInvoiceDate(),
+		Mode:        models.PaymentModeOnline,
+		Reference:   truncate(p.paymentID, 100),
+	}
+	err = tx.QueryRow(ctx, `
+		INSERT INTO payments (invoice_id, user_id, amount, payment_date, mode, reference, gateway_reference)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (gateway_reference) DO NOTHING
+		RETURNING id, created_at
+	`, payment.InvoiceID, userID, payment.Amount, payment.PaymentDate, payment.Mode, payment.Reference,
+		g.name+":"+firstNonEmpty(p.paymentID, p.linkID)).Scan(&payment.ID, &payment.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Gateways retry webhooks until they are acknowledged
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `
+		UPDATE payment_links SET status = $1, payment_id = $2, paid_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`, models.PaymentLinkPaid, payment.ID, link.ID)
+	if err != nil {
+		return err
+	}
+	recordEvent(ctx, tx, userID, eventPaymentRecorded, entityPayment, payment.ID, payment)
+
+	return tx.Commit(ctx)
+}
+
+// paymentGatewayParam reads the gateway of a request, responding with an
+// error when it is unknown
+func paymentGatewayParam(c *gin.Context) (*paymentGateway, bool) {
+	g, ok := newPaymentGateway(c.Param("gateway"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": errUnknownPaymentGateway.Error()})
+		return nil, false
+	}
+	return g, true
+}
+
+// handleGetPaymentGateways lists the supported payment gateways with the
+// authenticated user's account on each
+func handleGetPaymentGateways(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, gateway, key_id, webhook_token, webhook_configured, created_at, updated_at
+		FROM payment_gateways WHERE user_id = $1
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment gateways"})
+		return
+	}
+	defer rows.Close()
+
+	accounts := make(map[string]*models.PaymentGateway)
+	for rows.Next() {
+		var a models.PaymentGateway
+		var token string
+		if err := rows.Scan(&a.ID, &a.Gateway, &a.KeyID, &token, &a.WebhookConfigured, &a.CreatedAt, &a.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payment gateway"})
+			return
+		}
+		a.WebhookURL = paymentWebhookURL(a.Gateway, token)
+		accounts[a.Gateway] = &a
+	}
+	if rowsFailed(c, rows, "Failed to fetch payment gateways") {
+		return
+	}
+
+	gateways := make([]gin.H, 0, len(models.PaymentGateways))
+	for _, name := range models.PaymentGateways {
+		g, _ := newPaymentGateway(name)
+		gateways = append(gateways, gin.H{
+			"gateway":    name,
+			"label":      g.label,
+			"configured": vault != nil,
+			"account":    accounts[name],
+		})
+	}
+	c.JSON(http.StatusOK, gateways)
+}
+
+// handleSavePaymentGateway sets up or updates the user's account with a
+// gateway. Secrets left empty keep their stored values, so the webhook
+// secret can be added once the webhook is created on the gateway.
+func handleSavePaymentGateway(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	g, ok := paymentGatewayParam(c)
+	if !ok {
+		return
+	}
+	if vault == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errVaultDisabled.Error()})
+		return
+	}
+
+	var req struct {
+		KeyID         string `json:"key_id"`
+		KeySecret     string `json:"key_secret"`
+		WebhookSecret string `json:"webhook_secret"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	req.KeyID = strings.TrimSpace(req.KeyID)
+	req.KeySecret = strings.TrimSpace(req.KeySecret)
+	req.WebhookSecret = strings.TrimSpace(req.WebhookSecret)
+
+	account, err := loadGatewayAccount(ctx, userID, g)
+	if errors.Is(err, pgx.ErrNoRows) {
+		account, err = &gatewayAccount{userID: userID, gateway: g}, nil
+	}
+	if err != nil {
+		log.Printf("Error loading %s account of user %d: %v", g.label, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payment gateway"})
+		return
+	}
+	account.keyID = firstNonEmpty(req.KeyID, account.keyID)
+	account.secrets.KeySecret = firstNonEmpty(req.KeySecret, account.secrets.KeySecret)
+	account.secrets.WebhookSecret = firstNonEmpty(req.WebhookSecret, account.secrets.WebhookSecret)
+	if account.secrets.KeySecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key_secret is required"})
+		return
+	}
+	if g.basicAuth && account.keyID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key_id is required for " + g.label})
+		return
+	}
+	if len(account.keyID) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "key_id must be at most 100 characters"})
+		return
+	}
+
+	plaintext, err := json.Marshal(account.secrets)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store payment gateway"})
+		return
+	}
+	sealed, err := vault.seal(plaintext, paymentGatewayAAD(userID, g.name))
+	if err != nil {
+		log.Printf("Error encrypting %s secrets: %v", g.label, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store payment gateway"})
+		return
+	}
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store payment gateway"})
+		return
+	}
+
+	// The webhook token is kept on updates, so the webhook set up on the
+	// gateway keeps working
+	var a models.PaymentGateway
+	var webhookToken string
+	err = dbPool.QueryRow(ctx, `
+		INSERT INTO payment_gateways (user_id, gateway, key_id, master_key_id, wrapped_key, ciphertext, webhook_token, webhook_configured)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		ON CONFLICT (user_id, gateway) DO UPDATE SET
+			key_id = EXCLUDED.key_id,
+			master_key_id = EXCLUDED.master_key_id,
+			wrapped_key = EXCLUDED.wrapped_key,
+			ciphertext = EXCLUDED.ciphertext,
+			webhook_configured = EXCLUDED.webhook_configured,
+			updated_at = NOW()
+		RETURNING id, gateway, key_id, webhook_token, webhook_configured, created_at, updated_at
+	`, userID, g.name, account.keyID, sealed.KeyID, sealed.WrappedKey, sealed.Ciphertext,
+		hex.EncodeToString(token), account.secrets.WebhookSecret != "").Scan(
+		&a.ID, &a.Gateway, &a.KeyID, &webhookToken, &a.WebhookConfigured, &a.CreatedAt, &a.UpdatedAt)
+	if err != nil {
+		log.Printf("Error storing %s account of user %d: %v", g.label, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store payment gateway"})
+		return
+	}
+	a.WebhookURL = paymentWebhookURL(a.Gateway, webhookToken)
+
+	c.JSON(http.StatusOK, a)
+}
+
+// handleDeletePaymentGateway removes the user's account with a gateway. Its
+// active links are cancelled first, as their payments could no longer be
+// reconciled.
+func handleDeletePaymentGateway(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	g, ok := paymentGatewayParam(c)
+	if !ok {
+		return
+	}
+
+	links := make([]*models.PaymentLink, 0)
+	rows, err := dbPool.Query(ctx, `
+		UPDATE payment_links SET status = $1, updated_at = NOW()
+		WHERE user_id = $2 AND gateway = $3 AND status = $4
+		RETURNING `+paymentLinkColumns,
+		models.PaymentLinkCancelled, userID, g.name, models.PaymentLinkActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel payment links"})
+		return
+	}
+	for rows.Next() {
+		l, err := scanPaymentLink(rows)
+		if err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel payment links"})
+			return
+		}
+		links = append(links, l)
+	}
+	rows.Close()
+	if rowsFailed(c, rows, "Failed to cancel payment links") {
+		return
+	}
+	if vault != nil {
+		cancelGatewayLinks(ctx, userID, links)
+	}
+
+	result, err := dbPool.Exec(ctx,
+		"DELETE FROM payment_gateways WHERE user_id = $1 AND gateway = $2", userID, g.name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to remove payment gateway"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment gateway not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":         g.label + " removed",
+		"gateway":         g.name,
+		"links_cancelled": len(links),
+	})
+}
+
+// handleGetPaymentLinks lists the payment links of an invoice, newest first
+func handleGetPaymentLinks(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var exists bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx,
+		"SELECT "+paymentLinkColumns+" FROM payment_links WHERE invoice_id = $1 ORDER BY created_at DESC", id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payment links"})
+		return
+	}
+	defer rows.Close()
+
+	links := make([]*models.PaymentLink, 0)
+	for rows.Next() {
+		l, err := scanPaymentLink(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read payment link"})
+			return
+		}
+		links = append(links, l)
+	}
+	if rowsFailed(c, rows, "Failed to fetch payment links") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"payment_links": links})
+}
+
+// handleCreatePaymentLink creates a payment link for the outstanding amount
+// of an invoice, replacing its active link
+func handleCreatePaymentLink(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		Gateway string `json:"gateway"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	link, err := createPaymentLink(c.Request.Context(), c.GetInt("userID"), id, strings.ToLower(strings.TrimSpace(req.Gateway)))
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, link)
+}
+
+// handleCancelPaymentLink withdraws an active payment link on its gateway
+func handleCancelPaymentLink(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+	linkID, err := strconv.Atoi(c.Param("linkId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment link ID"})
+		return
+	}
+
+	link, err := scanPaymentLink(dbPool.QueryRow(ctx,
+		"SELECT "+paymentLinkColumns+" FROM payment_links WHERE id = $1 AND invoice_id = $2 AND user_id = $3",
+		linkID, id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment link not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if link.Status != models.PaymentLinkActive {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment link is already " + link.Status})
+		return
+	}
+
+	// Withdraw the link on the gateway first, so a link that could still be
+	// paid is never shown as cancelled
+	if g, ok := newPaymentGateway(link.Gateway); ok {
+		account, err := loadGatewayAccount(ctx, userID, g)
+		if err == nil {
+			err = g.cancelLink(ctx, account, link.GatewayID)
+		}
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error cancelling %s payment link %s: %v", g.label, link.GatewayID, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to cancel payment link on " + g.label})
+			return
+		}
+	}
+
+	result, err := dbPool.Exec(ctx,
+		"UPDATE payment_links SET status = $1, updated_at = NOW() WHERE id = $2 AND status = $3",
+		models.PaymentLinkCancelled, link.ID, models.PaymentLinkActive)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel payment link"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payment link was paid meanwhile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment link cancelled", "payment_link_id": link.ID})
+}
+
+// handlePaymentWebhook receives the payment notifications of a gateway
+// account, identified by the token in its webhook URL, and records paid
+// links as payments of their invoices. Events other than payments are
+// acknowledged and ignored.
+func handlePaymentWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	g, ok := paymentGatewayParam(c)
+	if !ok {
+		return
+	}
+	if vault == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": errVaultDisabled.Error()})
+		return
+	}
+
+	var userID int
+	err := dbPool.QueryRow(ctx,
+		"SELECT user_id FROM payment_gateways WHERE gateway = $1 AND webhook_token = $2",
+		g.name, c.Param("token")).Scan(&userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Unknown webhook"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	account, err := loadGatewayAccount(ctx, userID, g)
+	if err != nil {
+		log.Printf("Error loading %s account of user %d: %v", g.label, userID, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load payment gateway"})
+		return
+	}
+	if account.secrets.WebhookSecret == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": "Webhook secret is not set up"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook"})
+		return
+	}
+	payment, err := g.parseWebhook(c.Request.Header, body, account.secrets.WebhookSecret)
+	if errors.Is(err, errWebhookSignature) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+	if payment == nil {
+		c.JSON(http.StatusOK, gin.H{"message": "Event ignored"})
+		return
+	}
+
+	if err := recordGatewayPayment(ctx, userID, g, payment); err != nil {
+		log.Printf("Error recording %s payment %s: %v", g.label, payment.paymentID, err)
+		// The gateway retries the webhook
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Payment recorded"})
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// paidAmountSQL totals the payments of the invoices row in a query
+const paidAmountSQL = "COALESCE((SELECT SUM(p.amount) FROM payments p WHERE p.invoice_id = invoices.id), 0)::float8"
+
+// payableSQL is the amount the buyer owes for the invoices row in a query, as
+// models.EInvoice.AmountPayable computes it: reverse charge tax is paid to the
+// government, not the seller
+const payableSQL = "(total_value - CASE WHEN invoice_json->'TranDtls'->>'RegRev' = 'Y' THEN tax_amount ELSE 0 END)::float8"
+
+// outstandingSQL is the unpaid amount of the invoices row in a query
+const outstandingSQL = "(" + payableSQL + " - " + paidAmountSQL + ")"
+
+// paymentStatusSQL is the payment status of the invoices row in a query, as
+// models.NewPaymentSummary computes it
+const paymentStatusSQL = `(CASE WHEN ROUND(` + outstandingSQL + `::numeric, 2) <= 0 THEN 'paid'
+	WHEN ROUND(` + paidAmountSQL + `::numeric, 2) > 0 THEN 'partial' ELSE 'unpaid' END)`
+
+// defaultCreditDays is the credit period assumed when an invoice's payment
+// terms give none
+const defaultCreditDays = 30
+
+// dueDateSQL is the payment due date of the invoices row in a query, as
+// invoiceDueDate computes it
+var dueDateSQL = fmt.Sprintf(`(invoice_date + COALESCE((invoice_json->'PayDtls'->>'CrDay')::int, %d))`,
+	defaultCreditDays)
+
+// invoiceDueDate returns the date payment of an invoice is due: its date
+// plus its credit days, or defaultCreditDays
+func invoiceDueDate(invoice *models.EInvoice) (time.Time, bool) {
+	date, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+	if err != nil {
+		return time.Time{}, false
+	}
+	days := defaultCreditDays
+	if invoice.PayDtls != nil && invoice.PayDtls.CrDay > 0 {
+		days = invoice.PayDtls.CrDay
+	}
+	return date.AddDate(0, 0, days), true
+}
+
+// createPaymentTables creates the table of payments received against invoices
+func createPaymentTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS payments (
+			id SERIAL PRIMARY KEY,
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			amount DECIMAL(14,2) NOT NULL CHECK (amount > 0),
+			payment_date VARCHAR(10) NOT NULL,
+			mode VARCHAR(20) NOT NULL,
+			reference VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create payments table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_payments_invoice ON payments (invoice_id)")
+	if err != nil {
+		log.Fatalf("Failed to create payments index: %v", err)
+	}
+}
+
+// parsePaymentStatusFilter reads the payment_status query parameter
+func parsePaymentStatusFilter(c *gin.Context) (string, bool) {
+	status := strings.ToLower(c.Query("payment_status"))
+	switch status {
+	case "", models.PaymentStatusUnpaid, models.PaymentStatusPartial, models.PaymentStatusPaid:
+		return status, true
+	}
+	return "", false
+}
+
+// loadInvoicePayments returns the invoice's status and payment summary with
+// the payments recorded against it, or pgx.ErrNoRows when the invoice is not the user's
+func loadInvoicePayments(ctx context.Context, q queryRower, invoiceID, userID int) (string, models.PaymentSummary, []models.Payment, error) {
+	var status string
+	var invoiceJSON []byte
+	var paid float64
+	err := q.QueryRow(ctx,
+		"SELECT status, invoice_json, "+paidAmountSQL+" FROM invoices WHERE id = $1 AND user_id = $2",
+		invoiceID, userID).Scan(&status, &invoiceJSON, &paid)
+	if err != nil {
+		return "", models.PaymentSummary{}, nil, err
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		return "", models.PaymentSummary{}, nil, err
+	}
+	summary := models.NewPaymentSummary(invoice.AmountPayable(), paid)
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, amount::float8, payment_date, mode, reference, created_at
+		FROM payments
+		WHERE invoice_id = $1
+		ORDER BY created_at
+	`, invoiceID)
+	if err != nil {
+		return "", summary, nil, err
+	}
+	defer rows.Close()
+
+	payments := make([]models.Payment, 0)
+	for rows.Next() {
+		p := models.Payment{InvoiceID: invoiceID, UserID: userID}
+		if err := rows.Scan(&p.ID, &p.Amount, &p.PaymentDate, &p.Mode, &p.Reference, &p.CreatedAt); err != nil {
+			return "", summary, nil, err
+		}
+		payments = append(payments, p)
+	}
+	return status, summary, payments, rows.Err()
+}
+
+// handleGetInvoicePayments lists the payments of an invoice with its outstanding balance
+func handleGetInvoicePayments(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	_, summary, payments, err := loadInvoicePayments(c.Request.Context(), dbPool, id, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading payments of invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"payments": payments,
+		"summary":  summary,
+	})
+}
+
+// handleCreatePayment records a full or partial payment against an invoice.
+// Payments cannot exceed the outstanding balance or be recorded on cancelled invoices.
+func handleCreatePayment(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var payment models.Payment
+	if err := c.ShouldBindJSON(&payment); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment data: " + err.Error()})
+		return
+	}
+	payment.Mode = strings.ToLower(strings.TrimSpace(payment.Mode))
+	payment.Reference = strings.TrimSpace(payment.Reference)
+	if err := payment.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	// Lock the invoice so concurrent payments cannot both pass the balance check
+	if _, err := tx.Exec(ctx, "SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2 FOR UPDATE", id, userID); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	status, summary, _, err := loadInvoicePayments(ctx, tx, id, userID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error loading payments of invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch payments"})
+		return
+	}
+	if status == models.InvoiceStatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Payments cannot be recorded on a cancelled invoice"})
+		return
+	}
+	if payment.Amount > summary.Outstanding {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error":       "Payment exceeds the outstanding balance",
+			"outstanding": summary.Outstanding,
+		})
+		return
+	}
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO payments (invoice_id, user_id, amount, payment_date, mode, reference)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id, created_at
+	`, id, userID, payment.Amount, payment.PaymentDate, payment.Mode, payment.Reference).Scan(&payment.ID, &payment.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+	payment.InvoiceID, payment.UserID = id, userID
+	recordEvent(ctx, tx, userID, eventPaymentRecorded, entityPayment, payment.ID, payment)
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record payment"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Payment recorded successfully",
+		"payment_id": payment.ID,
+		"summary":    models.NewPaymentSummary(summary.Total, summary.Paid+payment.Amount),
+	})
+}
+
+// handleDeletePayment removes a payment recorded in error
+func handleDeletePayment(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+	paymentID, err := strconv.Atoi(c.Param("paymentId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid payment ID"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	var payment models.Payment
+	err = dbPool.QueryRow(ctx, `
+		DELETE FROM payments WHERE id = $1 AND invoice_id = $2 AND user_id = $3
+		RETURNING id, invoice_id, user_id, amount::float8, payment_date, mode, reference, created_at
+	`, paymentID, id, userID).Scan(&payment.ID, &payment.InvoiceID, &payment.UserID, &payment.Amount,
+		&payment.PaymentDate, &payment.Mode, &payment.Reference, &payment.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Payment not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete payment"})
+		return
+	}
+	recordEvent(ctx, dbPool, userID, eventPaymentDeleted, entityPayment, payment.ID, payment)
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Payment deleted successfully",
+		"payment_id": paymentID,
+	})
+}
@@ -0,0 +1,400 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+	"github.com/skip2/go-qrcode"
+)
+
+// A4 page size and margin in points
+const (
+	pdfPageWidth  = 595.0
+	pdfPageHeight = 842.0
+	pdfMargin     = 40.0
+)
+
+// pdfDocument builds a simple text PDF using the standard Helvetica fonts,
+// which every PDF reader provides, so no fonts need to be embedded
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+// newPDFDocument creates a document with one empty page
+func newPDFDocument() *pdfDocument {
+	d := &pdfDocument{}
+	d.newPage()
+	return d
+}
+
+// newPage starts a new page with the cursor at the top margin
+func (d *pdfDocument) newPage() {
+	d.page = &bytes.Buffer{}
+	d.pages = append(d.pages, d.page)
+	d.y = pdfPageHeight - pdfMargin
+}
+
+// ensureSpace starts a new page when less than height points are left
+func (d *pdfDocument) ensureSpace(height float64) {
+	if d.y-height < pdfMargin {
+		d.newPage()
+	}
+}
+
+// pdfEscape makes text safe for a PDF string literal. The standard fonts only
+// cover Latin-1, so other characters are replaced.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '₹':
+			b.WriteString("Rs.")
+		case r < 32:
+			b.WriteRune(' ')
+		case r > 255:
+			b.WriteRune('?')
+		default:
+			b.WriteByte(byte(r))
+		}
+	}
+	return b.String()
+}
+
+// textWidth approximates the width of text in Helvetica, which is close
+// enough to right-align figures
+func textWidth(s string, size float64) float64 {
+	return float64(len(s)) * 0.556 * size
+}
+
+// text writes a line of text with its baseline at y
+func (d *pdfDocument) text(x, y, size float64, bold bool, s string) {
+	font := "F1"
+	if bold {
+		font = "F2"
+	}
+	fmt.Fprintf(d.page, "BT /%s %.1f Tf %.2f %.2f Td (%s) Tj ET\n", font, size, x, y, pdfEscape(s))
+}
+
+// textRight writes text ending at x
+func (d *pdfDocument) textRight(x, y, size float64, bold bool, s string) {
+	d.text(x-textWidth(s, size), y, size, bold, s)
+}
+
+// line draws a thin horizontal rule across the page at y
+func (d *pdfDocument) line(y float64) {
+	fmt.Fprintf(d.page, "0.5 w %.2f %.2f m %.2f %.2f l S\n", pdfMargin, y, pdfPageWidth-pdfMargin, y)
+}
+
+// qr draws a QR code as filled squares with its top left corner at x, y
+func (d *pdfDocument) qr(x, y, size float64, content string) error {
+	code, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return err
+	}
+	bitmap := code.Bitmap()
+	module := size / float64(len(bitmap))
+	for row, cells := range bitmap {
+		for col, dark := range cells {
+			if dark {
+				fmt.Fprintf(d.page, "%.2f %.2f %.2f %.2f re\n",
+					x+float64(col)*module, y-float64(row+1)*module, module, module)
+			}
+		}
+	}
+	d.page.WriteString("f\n")
+	return nil
+}
+
+// bytes assembles the pages into a PDF file
+func (d *pdfDocument) bytes() []byte {
+	var out bytes.Buffer
+	var offsets []int
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n")
+
+	// Objects 1-4 are the catalog, page tree and fonts; each page then
+	// takes two objects, the page and its content stream
+	kids := make([]string, len(d.pages))
+	for i := range d.pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(d.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	for i, page := range d.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.0f %.0f] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 6+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+	return out.Bytes()
+}
+
+// pdfDocumentTitles are the headings of each e-invoice document type
+var pdfDocumentTitles = map[string]string{
+	"INV": "TAX INVOICE",
+	"CRN": "CREDIT NOTE",
+	"DBN": "DEBIT NOTE",
+}
+
+// pdfItemColumns are the item table columns with their x positions;
+// numeric columns are right-aligned at their position
+var pdfItemColumns = []struct {
+	title string
+	x     float64
+	right bool
+}{
+	{"#", pdfMargin, false},
+	{"Description", 60, false},
+	{"HSN", 230, false},
+	{"Qty", 315, true},
+	{"Unit", 322, false},
+	{"Rate", 400, true},
+	{"Taxable", 460, true},
+	{"GST %", 500, true},
+	{"Total", pdfPageWidth - pdfMargin, true},
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "~"
+}
+
+// lutDeclaration is the declaration an export or SEZ supply without payment
+// of tax is printed with, or "" for other supplies
+func lutDeclaration(invoice *models.EInvoice) string {
+	if !invoice.IsWithoutPayment() {
+		return ""
+	}
+	supply := "export"
+	if invoice.TranDtls.SupTyp == models.SupplySEZWOP {
+		supply = "SEZ unit or developer for authorised operations"
+	}
+	return fmt.Sprintf("Supply meant for %s under LUT No. %s without payment of IGST", supply, invoice.LUTNo())
+}
+
+// renderInvoicePDF renders a printable copy of an invoice with its QR code
+// and, when paymentURL is set, the link and QR code of its online payment page
+func renderInvoicePDF(invoice *models.EInvoice, irn, paymentURL string) ([]byte, error) {
+	d := newPDFDocument()
+	amount := func(v float64) string { return fmt.Sprintf("%.2f", v) }
+
+	title, ok := pdfDocumentTitles[invoice.DocDtls.Typ]
+	if !ok {
+		title = "TAX INVOICE"
+	}
+	top := d.y
+	d.text(pdfMargin, top-14, 16, true, title)
+	d.text(pdfMargin, top-34, 10, false, "No: "+invoice.DocDtls.No)
+	d.text(pdfMargin, top-48, 10, false, "Date: "+invoice.DocDtls.Dt)
+	supply := "Supply type: " + invoice.TranDtls.SupTyp
+	if invoice.IsReverseCharge() {
+		supply += "  |  Tax payable on reverse charge"
+	}
+	d.text(pdfMargin, top-62, 10, false, supply)
+	if irn != "" {
+		d.text(pdfMargin, top-76, 8, false, "IRN: "+irn)
+	}
+
+	qrContent := invoiceQRContent(invoice.DocDtls.No, invoice.ValDtls.TotInvVal)
+	if err := d.qr(pdfPageWidth-pdfMargin-90, top, 90, qrContent); err != nil {
+		return nil, err
+	}
+	d.y = top - 100
+
+	// Seller and buyer side by side
+	party := func(x float64, heading string, lines []string) float64 {
+		y := d.y
+		d.text(x, y, 9, true, heading)
+		for _, l := range lines {
+			if strings.TrimSpace(l) == "" {
+				continue
+			}
+			y -= 12
+			d.text(x, y, 9, false, truncate(l, 50))
+		}
+		return y
+	}
+	s, b := invoice.SellerDtls, invoice.BuyerDtls
+	sellerEnd := party(pdfMargin, "Seller", []string{
+		s.LglNm, s.Addr1, s.Addr2, fmt.Sprintf("%s %d", s.Loc, s.Pin),
+		"GSTIN: " + s.Gstin, "State code: " + s.Stcd,
+	})
+	buyerGSTIN := b.Gstin
+	if buyerGSTIN == "" {
+		buyerGSTIN = "URP"
+	}
+	buyerEnd := party(pdfPageWidth/2, "Buyer", []string{
+		b.LglNm, b.Addr1, b.Addr2, fmt.Sprintf("%s %d", b.Loc, b.Pin),
+		"GSTIN: " + buyerGSTIN, "State code: " + b.Stcd + "   Place of supply: " + b.Pos,
+	})
+	d.y = min(sellerEnd, buyerEnd) - 20
+
+	header := func() {
+		for _, col := range pdfItemColumns {
+			if col.right {
+				d.textRight(col.x, d.y, 9, true, col.title)
+			} else {
+				d.text(col.x, d.y, 9, true, col.title)
+			}
+		}
+		d.line(d.y - 4)
+		d.y -= 16
+	}
+	header()
+
+	for _, item := range invoice.ItemList {
+		if d.y-14 < pdfMargin {
+			d.newPage()
+			header()
+		}
+		rate := fmt.Sprintf("%g", item.GstRt)
+		if item.IsExempt() {
+			rate = item.ExemptionLabel()
+		}
+		values := []string{
+			item.SlNo, truncate(item.PrdDesc, 30), item.HsnCd, fmt.Sprintf("%g", item.Qty), item.Unit,
+			fmt.Sprintf("%g", item.UnitPrice), amount(item.AssAmt), rate,
+			amount(item.TotItemVal),
+		}
+		for i, col := range pdfItemColumns {
+			if col.right {
+				d.textRight(col.x, d.y, 9, false, values[i])
+			} else {
+				d.text(col.x, d.y, 9, false, values[i])
+			}
+		}
+		d.y -= 14
+	}
+	d.line(d.y + 8)
+
+	heads := invoiceTaxHeads(invoice)
+	totals := [][2]string{{"Taxable value", amount(heads.TaxableValue)}}
+	if exempt := invoice.ExemptValue(); exempt != 0 {
+		totals = append(totals, [2]string{"Nil-rated, exempt and non-GST value", amount(exempt)})
+	}
+	if heads.IGST != 0 || heads.CGST == 0 {
+		totals = append(totals, [2]string{"IGST", amount(heads.IGST)})
+	} else {
+		totals = append(totals,
+			[2]string{"CGST", amount(heads.CGST)},
+			[2]string{"SGST", amount(heads.SGST)})
+	}
+	if invoice.ValDtls.RndOffAmt != 0 {
+		totals = append(totals, [2]string{"Round off", amount(invoice.ValDtls.RndOffAmt)})
+	}
+	totals = append(totals, [2]string{"Invoice value", amount(invoice.ValDtls.TotInvVal)})
+	if invoice.IsReverseCharge() {
+		totals = append(totals, [2]string{"Amount payable (excl. reverse charge tax)", amount(invoice.AmountPayable())})
+	}
+
+	d.ensureSpace(float64(len(totals))*14 + 60)
+	d.y -= 6
+	for i, t := range totals {
+		bold := i == len(totals)-1
+		d.textRight(460, d.y, 10, bold, t[0])
+		d.textRight(pdfPageWidth-pdfMargin, d.y, 10, bold, t[1])
+		d.y -= 14
+	}
+	d.y -= 4
+	d.text(pdfMargin, d.y, 9, false, truncate(models.AmountInWords(invoice.ValDtls.TotInvVal, models.WordsLangEnglish), 110))
+	d.y -= 14
+	if declaration := lutDeclaration(invoice); declaration != "" {
+		d.text(pdfMargin, d.y, 9, true, truncate(declaration, 110))
+		d.y -= 14
+	}
+
+	if invoice.RefDtls != nil && invoice.RefDtls.InvRm != "" {
+		d.y -= 10
+		d.text(pdfMargin, d.y, 9, false, "Remarks: "+truncate(invoice.RefDtls.InvRm, 100))
+	}
+	if paymentURL != "" {
+		d.ensureSpace(110)
+		d.y -= 16
+		d.text(pdfMargin, d.y, 10, true, "Pay online")
+		d.text(pdfMargin, d.y-14, 9, false, truncate(paymentURL, 100))
+		if err := d.qr(pdfMargin, d.y-20, 80, paymentURL); err != nil {
+			return nil, err
+		}
+		d.y -= 110
+	}
+	d.text(pdfMargin, pdfMargin-16, 8, false, "This is a computer generated document.")
+
+	return d.bytes(), nil
+}
+
+// handleGetInvoicePDF downloads the PDF copy of an invoice, the one attached
+// to e-mails and WhatsApp messages
+func handleGetInvoicePDF(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var invoiceJSON []byte
+	var irn string
+	var updatedAt time.Time
+	err = dbPool.QueryRow(ctx,
+		"SELECT invoice_json, COALESCE(irn, ''), updated_at FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceJSON, &irn, &updatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error fetching invoice %d for PDF: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+		return
+	}
+	pdf, err := renderInvoicePDF(&invoice, irn, activePaymentLinkURL(ctx, id))
+	if err != nil {
+		log.Printf("Error rendering PDF of invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to render PDF"})
+		return
+	}
+
+	c.Header("Content-Disposition", "attachment; filename="+sanitizeFilename(invoice.DocDtls.No)+".pdf")
+	serveConditional(c, "application/pdf", updatedAt, pdf)
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Period lock event types, recorded against the user who locked or unlocked
+// the period or, for overrides, the owner who overrode it; the payload is a periodLockEvent
+// or, for overrides, a lockOverride
+const (
+	eventUserPeriodLocked         = "user.period_locked"
+	eventUserPeriodUnlocked       = "user.period_unlocked"
+	eventUserPeriodLockOverridden = "user.period_lock_overridden"
+)
+
+// periodLockEvent is the payload of period lock and unlock events
+type periodLockEvent struct {
+	Period  string `json:"period"`
+	Note    string `json:"note,omitempty"`
+	Reason  string `json:"reason,omitempty"`
+	ActorID int    `json:"actor_id"`
+}
+
+// createPeriodLockTables creates the table of locked periods
+func createPeriodLockTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS period_locks (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			period CHAR(7) NOT NULL,
+			note VARCHAR(255) NOT NULL DEFAULT '',
+			locked_by INTEGER NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, period)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create period_locks table: %v", err)
+	}
+}
+
+// periodLocks is the set of an account's locked periods, YYYY-MM
+type periodLocks map[string]bool
+
+// getPeriodLocks returns the account's locked periods
+func getPeriodLocks(ctx context.Context, userID int) (periodLocks, error) {
+	rows, err := dbPool.Query(ctx, "SELECT period FROM period_locks WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	locks := make(periodLocks)
+	for rows.Next() {
+		var period string
+		if err := rows.Scan(&period); err != nil {
+			return nil, err
+		}
+		locks[period] = true
+	}
+	return locks, rows.Err()
+}
+
+// find returns the locked period the first of dates falls in, or "" when
+// none does. Zero dates are skipped.
+func (l periodLocks) find(dates ...time.Time) string {
+	for _, date := range dates {
+		if date.IsZero() {
+			continue
+		}
+		if period := date.Format(models.PeriodLayout); l[period] {
+			return period
+		}
+	}
+	return ""
+}
+
+// invoiceDocDate returns the date of an invoice, or the zero time when it
+// has no readable date
+func invoiceDocDate(invoice *models.EInvoice) time.Time {
+	date, _ := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+	return date
+}
+
+// lockedPeriodError explains why invoices of a locked period cannot be changed
+func lockedPeriodError(period string) string {
+	return fmt.Sprintf("Invoices dated in %s can no longer be created, changed or deleted because the period is locked", period)
+}
+
+// periodLockOverrideReason returns the reason given in the override_reason
+// query parameter to change invoices of a locked period. Only the account
+// owner may override the lock; for anyone else, or without a reason, the lock is
+// answered on c and ok is false.
+func periodLockOverrideReason(c *gin.Context, period string) (reason string, ok bool) {
+	reason = strings.TrimSpace(c.Query("override_reason"))
+	if reason == "" {
+		c.JSON(http.StatusConflict, gin.H{"error": lockedPeriodError(period), "period_locked": true, "period": period})
+		return "", false
+	}
+	if !isAccountOwner(c) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the account owner can override the period lock", "code": errCodeOwnerRequired})
+		return "", false
+	}
+	return reason, true
+}
+
+// checkPeriodLock checks the dates of an invoice change against the
+// account's locked periods. It returns the owner's override reason when one
+// is needed and given; when the change is refused, or the locks cannot be
+// read, it is answered on c and ok is false.
+func checkPeriodLock(c *gin.Context, userID int, dates ...time.Time) (override lockOverride, ok bool) {
+	locks, err := getPeriodLocks(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check locked periods"})
+		return lockOverride{}, false
+	}
+	period := locks.find(dates...)
+	if period == "" {
+		return lockOverride{}, true
+	}
+	reason, ok := periodLockOverrideReason(c, period)
+	if !ok {
+		return lockOverride{}, false
+	}
+	return lockOverride{Period: period, Reason: reason, AdminID: c.GetInt("actorID")}, true
+}
+
+// recordPeriodLockOverride audits an override of a period lock against the
+// owner; overrides without a period were not needed and are not recorded
+func recordPeriodLockOverride(ctx context.Context, db execer, userID int, override lockOverride) {
+	if override.Period == "" {
+		return
+	}
+	recordEvent(ctx, db, userID, eventUserPeriodLockOverridden, entityUser, override.AdminID, override)
+}
+
+// handleGetPeriodLocks returns the account's locked periods, latest first
+func handleGetPeriodLocks(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT id, user_id, period, note, locked_by, created_at FROM period_locks WHERE user_id = $1 ORDER BY period DESC",
+		userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch locked periods"})
+		return
+	}
+	defer rows.Close()
+
+	locks := make([]models.PeriodLock, 0)
+	for rows.Next() {
+		var l models.PeriodLock
+		if err := rows.Scan(&l.ID, &l.UserID, &l.Period, &l.Note, &l.LockedBy, &l.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read locked periods"})
+			return
+		}
+		locks = append(locks, l)
+	}
+	if rowsFailed(c, rows, "Failed to fetch locked periods") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"period_locks": locks})
+}
+
+// handleLockPeriod locks a month once its returns are filed
+func handleLockPeriod(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	var lock models.PeriodLock
+	if err := c.ShouldBindJSON(&lock); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+	lock.Period = strings.TrimSpace(lock.Period)
+	lock.Note = strings.TrimSpace(lock.Note)
+	if err := lock.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	lock.UserID = userID
+	lock.LockedBy = c.GetInt("actorID")
+	err := dbPool.QueryRow(ctx, `
+		INSERT INTO period_locks (user_id, period, note, locked_by)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id, created_at
+	`, userID, lock.Period, lock.Note, lock.LockedBy).Scan(&lock.ID, &lock.CreatedAt)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Period %s is already locked", lock.Period)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to lock period"})
+		return
+	}
+	recordEvent(ctx, dbPool, userID, eventUserPeriodLocked, entityUser, lock.LockedBy, periodLockEvent{
+		Period: lock.Period, Note: lock.Note, ActorID: lock.LockedBy,
+	})
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":     fmt.Sprintf("Period %s locked", lock.Period),
+		"period_lock": lock,
+	})
+}
+
+// handleUnlockPeriod unlocks a month, for instance to file an amendment.
+// Only the account owner may unlock a period, giving a reason, which is
+// audited.
+func handleUnlockPeriod(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+	period := c.Param("period")
+
+	reason := strings.TrimSpace(c.Query("reason"))
+	if reason == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "A reason is required to unlock a period"})
+		return
+	}
+
+	result, err := dbPool.Exec(ctx, "DELETE FROM period_locks WHERE user_id = $1 AND period = $2", userID, period)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to unlock period"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("Period %s is not locked", period)})
+		return
+	}
+	actorID := c.GetInt("actorID")
+	recordEvent(ctx, dbPool, userID, eventUserPeriodUnlocked, entityUser, actorID, periodLockEvent{
+		Period: period, Reason: reason, ActorID: actorID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": fmt.Sprintf("Period %s unlocked", period)})
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// pincodeMasterCSV is the bundled PIN code directory of the head post offices
+// of major cities, used to seed the pincodes table. Set PINCODE_MASTER_FILE
+// to load the full India Post directory instead.
+//
+//go:embed data/pincode_master.csv
+var pincodeMasterCSV []byte
+
+// pincodeColumns maps the columns of a PIN code directory, by normalized
+// header, to the fields read. Both the bundled list and the India Post "All
+// India Pincode Directory" are understood.
+var pincodeColumns = map[string]string{
+	"pincode":    "pincode",
+	"pin":        "pincode",
+	"city":       "city",
+	"officename": "city",
+	"district":   "district",
+	"statecode":  "state",
+	"state":      "state",
+	"statename":  "state",
+	"officetype": "office_type",
+}
+
+// pincodeOfficeRank orders the offices of a PIN code: the city of a PIN code
+// is taken from its head office, otherwise from a sub office
+var pincodeOfficeRank = map[string]int{"ho": 0, "gpo": 0, "so": 1, "bo": 2}
+
+// createPincodeTables creates the PIN code directory and loads it
+func createPincodeTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS pincodes (
+			pincode INTEGER PRIMARY KEY,
+			city VARCHAR(100) NOT NULL DEFAULT '',
+			district VARCHAR(100) NOT NULL DEFAULT '',
+			state_code VARCHAR(2) NOT NULL
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create pincodes table: %v", err)
+	}
+
+	if err := seedPincodes(); err != nil {
+		log.Printf("Error seeding PIN code directory: %v", err)
+	}
+}
+
+// seedPincodes loads the PIN code directory from PINCODE_MASTER_FILE or the
+// bundled list at every start, so a directory configured later, or a newer
+// bundled list, updates PIN codes already in the table
+func seedPincodes() error {
+	data := pincodeMasterCSV
+	if path := os.Getenv("PINCODE_MASTER_FILE"); path != "" {
+		fileData, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read PINCODE_MASTER_FILE: %w", err)
+		}
+		data = fileData
+	}
+
+	places, err := parsePincodeCSV(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	if err != nil {
+		return err
+	}
+
+	rows := make([][]interface{}, 0, len(places))
+	for _, p := range places {
+		rows = append(rows, []interface{}{p.Pincode, p.City, p.District, p.StateCode})
+	}
+
+	loaded, err := upsertMasterRows(context.Background(), masterTable{
+		Name:    "pincodes",
+		Key:     []string{"pincode"},
+		Columns: []string{"pincode", "city", "district", "state_code"},
+	}, rows)
+	if err != nil {
+		return fmt.Errorf("failed to load PIN codes: %w", err)
+	}
+
+	if loaded > 0 {
+		log.Printf("Loaded %d new or changed PIN codes", loaded)
+	}
+	return nil
+}
+
+// parsePincodeCSV reads a PIN code directory with a header line. States may
+// be given by code or name. The India Post directory lists every post office,
+// so PIN codes repeat; the head office of each is kept.
+func parsePincodeCSV(r io.Reader) ([]models.Pincode, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("invalid PIN code directory CSV: %w", err)
+	}
+	if len(records) < 2 {
+		return nil, errors.New("PIN code directory CSV has no data rows")
+	}
+
+	columns := make(map[string]int)
+	for i, h := range records[0] {
+		if field, ok := pincodeColumns[normalizeHeader(h)]; ok {
+			if _, seen := columns[field]; !seen {
+				columns[field] = i
+			}
+		}
+	}
+	for _, field := range []string{"pincode", "state"} {
+		if _, ok := columns[field]; !ok {
+			return nil, fmt.Errorf("PIN code directory CSV has no %s column", field)
+		}
+	}
+	get := func(record []string, field string) string {
+		col, ok := columns[field]
+		if !ok || col >= len(record) {
+			return ""
+		}
+		return strings.TrimSpace(record[col])
+	}
+
+	places := make([]models.Pincode, 0, len(records)-1)
+	index := make(map[int]int)
+	ranks := make(map[int]int)
+	skipped := 0
+	for _, record := range records[1:] {
+		pin, err := strconv.Atoi(get(record, "pincode"))
+		state := strings.TrimPrefix(strings.ToUpper(get(record, "state")), "THE ")
+		code, found := resolveStateCode(state)
+		if err != nil || !models.IsValidPincode(pin) || !found {
+			skipped++
+			continue
+		}
+
+		rank, ok := pincodeOfficeRank[normalizeHeader(get(record, "office_type"))]
+		if !ok {
+			rank = len(pincodeOfficeRank)
+		}
+		place := models.Pincode{
+			Pincode:   pin,
+			City:      pincodeOfficeCity(get(record, "city")),
+			District:  get(record, "district"),
+			StateCode: code,
+		}
+		if place.City == "" {
+			place.City = place.District
+		}
+
+		if i, dup := index[pin]; dup {
+			if rank < ranks[pin] {
+				places[i], ranks[pin] = place, rank
+			}
+			continue
+		}
+		index[pin], ranks[pin] = len(places), rank
+		places = append(places, place)
+	}
+	if skipped > 0 {
+		log.Printf("Skipped %d PIN code directory row(s) with an invalid PIN code or unknown state", skipped)
+	}
+
+	return places, nil
+}
+
+// pincodeOfficeCity strips the office type from a post office name, such as
+// "Connaught Place S.O"
+func pincodeOfficeCity(name string) string {
+	fields := strings.Fields(name)
+	if n := len(fields); n > 1 {
+		if _, ok := pincodeOfficeRank[normalizeHeader(fields[n-1])]; ok {
+			fields = fields[:n-1]
+		}
+	}
+	return strings.Join(fields, " ")
+}
+
+// findPincode returns the directory entry of a PIN code
+func findPincode(ctx context.Context, pin int) (*models.Pincode, error) {
+	p := models.Pincode{Pincode: pin, Source: models.PincodeSourceDirectory}
+	err := dbPool.QueryRow(ctx,
+		"SELECT city, district, state_code FROM pincodes WHERE pincode = $1",
+		pin).Scan(&p.City, &p.District, &p.StateCode)
+	if err != nil {
+		return nil, err
+	}
+	if s, ok := lookupState(p.StateCode); ok {
+		p.StateName = s.Name
+	}
+	return &p, nil
+}
+
+// lookupPincode returns the place a PIN code belongs to from the directory.
+// For PIN codes missing from it, the state is taken from the PIN code ranges
+// when exactly one state covers the PIN code; pgx.ErrNoRows is returned
+// otherwise.
+func lookupPincode(ctx context.Context, pin int) (*models.Pincode, error) {
+	p, err := findPincode(ctx, pin)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return p, err
+	}
+
+	states := statesWithPincode(pin)
+	if len(states) != 1 {
+		return nil, pgx.ErrNoRows
+	}
+	return &models.Pincode{
+		Pincode:   pin,
+		StateCode: states[0].Code,
+		StateName: states[0].Name,
+		Source:    models.PincodeSourceRange,
+	}, nil
+}
+
+// pincodePlace is lookupPincode for auto-fill, returning an empty place when
+// the PIN code is unknown
+func pincodePlace(ctx context.Context, pin int) *models.Pincode {
+	if pin == 0 {
+		return &models.Pincode{}
+	}
+	p, err := lookupPincode(ctx, pin)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error looking up PIN code %d: %v", pin, err)
+		}
+		return &models.Pincode{}
+	}
+	return p
+}
+
+// autoFillFromPincode fills a blank city and state of an address from its PIN code
+func autoFillFromPincode(ctx context.Context, pincode int, city, state *string) {
+	if pincode == 0 || (*city != "" && *state != "") {
+		return
+	}
+	place := pincodePlace(ctx, pincode)
+	if *city == "" {
+		*city = place.City
+	}
+	if *state == "" {
+		*state = place.StateName
+	}
+}
+
+// checkPincodes checks the seller and buyer PIN codes found in the directory
+// against the declared state, which the state's PIN code ranges alone cannot
+// tell apart where states share a range
+func checkPincodes(ctx context.Context, invoice *models.EInvoice) error {
+	addresses := []struct {
+		party string
+		pin   int
+		stcd  string
+	}{
+		{"seller", invoice.SellerDtls.Pin, invoice.SellerDtls.Stcd},
+		{"buyer", invoice.BuyerDtls.Pin, invoice.BuyerDtls.Stcd},
+	}
+	for _, a := range addresses {
+		if a.stcd == models.ForeignStateCode {
+			continue
+		}
+		place, err := findPincode(ctx, a.pin)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			log.Printf("Error looking up PIN code %d: %v", a.pin, err)
+			continue
+		}
+		if place.StateCode != a.stcd {
+			return fmt.Errorf("%s PIN code %d belongs to %s (state code %s), not state code %s",
+				a.party, a.pin, place.StateName, place.StateCode, a.stcd)
+		}
+	}
+	return nil
+}
+
+// handleGetPincode returns the city, district and state of a PIN code
+func handleGetPincode(c *gin.Context) {
+	pin, err := strconv.Atoi(c.Param("pin"))
+	if err != nil || len(c.Param("pin")) != 6 || !models.IsValidPincode(pin) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PIN code must be 6 digits"})
+		return
+	}
+
+	place, err := lookupPincode(c.Request.Context(), pin)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "PIN code not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to look up PIN code"})
+		return
+	}
+
+	c.JSON(http.StatusOK, place)
+}
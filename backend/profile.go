@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// eventUserPasswordChanged is recorded when a user changes their password
+const eventUserPasswordChanged = "user.password_changed"
+
+// errTokenRevoked is returned for tokens issued before the user's sessions were invalidated
+var errTokenRevoked = errors.New("token has been revoked")
+
+// profileNameMaxLen and profilePhoneMaxLen match the users table columns
+const (
+	profileNameMaxLen  = 100
+	profilePhoneMaxLen = 20
+)
+
+// UpdateProfileRequest represents the editable profile details
+type UpdateProfileRequest struct {
+	Name  string `json:"name"`
+	Phone string `json:"phone"`
+}
+
+// ChangePasswordRequest represents the change password request body
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+// createProfileColumns adds profile details to accounts, and the token
+// version that is bumped to sign the user out of every existing session
+func createProfileColumns() {
+	_, err := dbPool.Exec(context.Background(), `
+		ALTER TABLE users
+		ADD COLUMN IF NOT EXISTS name VARCHAR(100) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS phone VARCHAR(20) NOT NULL DEFAULT '',
+		ADD COLUMN IF NOT EXISTS token_version INTEGER NOT NULL DEFAULT 0
+	`)
+	if err != nil {
+		log.Fatalf("Failed to add profile columns to users table: %v", err)
+	}
+}
+
+// verifyToken checks a token's signature and expiry, and that it was issued
+// since the user's sessions were last invalidated
+func verifyToken(ctx context.Context, tokenString string) (*TokenClaims, error) {
+	claims := &TokenClaims{}
+	if err := jwtKeys.parse(tokenString, claims); err != nil {
+		return nil, err
+	}
+
+	var version int
+	var disabled bool
+	err := dbPool.QueryRow(ctx,
+		"SELECT token_version, disabled_at IS NOT NULL, password_reset_required FROM users WHERE id = $1",
+		claims.UserID).Scan(&version, &disabled, &claims.PasswordResetRequired)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, errTokenRevoked
+	}
+	if err != nil {
+		return nil, err
+	}
+	if claims.TokenVersion != version {
+		return nil, errTokenRevoked
+	}
+	if disabled {
+		return nil, errAccountDisabled
+	}
+	return claims, nil
+}
+
+// handleGetProfile returns the authenticated user's account details
+func handleGetProfile(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var email, name, phone, password string
+	var isAdmin, isSuperAdmin, resetRequired, sandbox, googleLinked bool
+	var createdAt time.Time
+	// Members work on the account of its owner in the role they were given
+	var ownerEmail, memberRole *string
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT u.email, u.name, u.phone, u.password, u.is_admin, u.is_super_admin, u.password_reset_required,
+			u.sandbox, u.google_sub IS NOT NULL, u.created_at, o.email, m.role
+		FROM users u
+		LEFT JOIN account_members m ON m.member_id = u.id
+		LEFT JOIN users o ON o.id = m.owner_id
+		WHERE u.id = $1
+	`, userID).Scan(&email, &name, &phone, &password, &isAdmin, &isSuperAdmin, &resetRequired,
+		&sandbox, &googleLinked, &createdAt, &ownerEmail, &memberRole)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	profile := gin.H{
+		"id":                      userID,
+		"email":                   email,
+		"name":                    name,
+		"phone":                   phone,
+		"is_admin":                isAdmin,
+		"is_super_admin":          isSuperAdmin,
+		"sandbox":                 sandbox,
+		"has_password":            password != "",
+		"password_reset_required": resetRequired,
+		"google_linked":           googleLinked,
+		"owner_email":             ownerEmail,
+		"member_role":             memberRole,
+		"created_at":              createdAt,
+	}
+	// Support sessions show the super admin running them
+	if impersonatorID := c.GetInt("impersonatorID"); impersonatorID != 0 {
+		profile["impersonator_id"] = impersonatorID
+	}
+	c.JSON(http.StatusOK, profile)
+}
+
+// handleUpdateProfile updates the authenticated user's name and phone number.
+// The email address is the login and cannot be changed here.
+func handleUpdateProfile(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req UpdateProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid profile data: " + err.Error()})
+		return
+	}
+	req.Name = strings.TrimSpace(req.Name)
+	req.Phone = strings.TrimSpace(req.Phone)
+	if len([]rune(req.Name)) > profileNameMaxLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Name must be at most 100 characters"})
+		return
+	}
+	if len(req.Phone) > profilePhoneMaxLen {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Phone must be at most 20 characters"})
+		return
+	}
+
+	_, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE users SET name = $1, phone = $2 WHERE id = $3", req.Name, req.Phone, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Profile updated successfully"})
+}
+
+// handleChangePassword sets a new password after checking the current one,
+// signing the user out of every other session. Accounts created through
+// Google sign-in have no password yet and may set one without it. The
+// response carries a fresh token for the current session.
+func handleChangePassword(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req ChangePasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	user := models.User{ID: userID}
+	err := dbPool.QueryRow(ctx,
+		"SELECT email, password FROM users WHERE id = $1", userID).Scan(&user.Email, &user.Password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if user.Password != "" && !user.CheckPassword(req.CurrentPassword) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Current password is incorrect"})
+		return
+	}
+
+	updated, err := models.NewUser(user.Email, req.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to hash password"})
+		return
+	}
+
+	// Bumping the token version revokes every token issued so far
+	_, err = dbPool.Exec(ctx,
+		"UPDATE users SET password = $1, password_reset_required = FALSE, token_version = token_version + 1 WHERE id = $2",
+		updated.Password, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to change password"})
+		return
+	}
+	recordEvent(ctx, dbPool, userID, eventUserPasswordChanged, entityUser, userID,
+		loginAudit{Email: user.Email, IP: c.ClientIP()})
+
+	token, err := issueToken(ctx, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Password changed successfully",
+		"token":   token,
+	})
+}
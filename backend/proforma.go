@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// createProformaTables creates the table of proforma invoices and quotations.
+// Each kind is numbered in its own series by seq, apart from the invoice
+// series, so offers that are never accepted leave no gaps in GST numbering.
+func createProformaTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS proforma_documents (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			kind VARCHAR(10) NOT NULL,
+			seq INTEGER NOT NULL,
+			doc_no VARCHAR(20) NOT NULL,
+			invoice_json JSONB NOT NULL,
+			invoice_id INTEGER REFERENCES invoice_keys(id) ON DELETE SET NULL,
+			converted_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, kind, seq)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create proforma_documents table: %v", err)
+	}
+}
+
+// proformaColumns are the columns scanned by scanProforma
+const proformaColumns = "id, user_id, kind, doc_no, invoice_json, invoice_id, converted_at, created_at, updated_at"
+
+// scanProforma reads a proforma document selected with proformaColumns
+func scanProforma(row pgx.Row) (*models.ProformaDocument, error) {
+	var doc models.ProformaDocument
+	var invoiceJSON []byte
+	err := row.Scan(&doc.ID, &doc.UserID, &doc.Kind, &doc.DocNo, &invoiceJSON,
+		&doc.InvoiceID, &doc.ConvertedAt, &doc.CreatedAt, &doc.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(invoiceJSON, &doc.Invoice); err != nil {
+		return nil, err
+	}
+	return &doc, nil
+}
+
+// todayInvoiceDate returns today's date in IST in the DD/MM/YYYY format of invoices
+func todayInvoiceDate() string {
+	return time.Now().In(istLocation).Format(invoiceDateLayout)
+}
+
+// bindProformaInvoice parses the lines of a proforma document from the request body
+func bindProformaInvoice(c *gin.Context, userID int) (*models.EInvoice, bool) {
+	var invoice models.EInvoice
+	if err := c.ShouldBindJSON(&invoice); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document data: " + err.Error()})
+		return nil, false
+	}
+	if !prepareDocumentInvoice(c, userID, &invoice, "INV") {
+		return nil, false
+	}
+	return &invoice, true
+}
+
+// prepareDocumentInvoice readies the lines of a document kept outside the
+// invoice series as for a new invoice: the user's defaults and default
+// company are applied, the date defaults to today, the document type to
+// docType, and totals are calculated. Problems are answered on c.
+func prepareDocumentInvoice(c *gin.Context, userID int, invoice *models.EInvoice, docType string) bool {
+	ctx := c.Request.Context()
+
+	settings := getUserSettings(ctx, userID)
+	if invoice.SellerDtls.Gstin == "" {
+		if co := getDefaultCompany(ctx, userID, settings); co != nil {
+			invoice.SellerDtls = sellerFromCompany(co)
+		}
+	}
+	if err := settings.ApplyDefaults(invoice); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if invoice.DocDtls.Dt == "" {
+		invoice.DocDtls.Dt = todayInvoiceDate()
+	}
+	if invoice.DocDtls.Typ == "" {
+		invoice.DocDtls.Typ = docType
+	}
+	if err := invoice.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	invoice.CalculateTotalsWithPrecision(getCompanyPrecision(ctx, userID, invoice.SellerDtls.Gstin))
+	return true
+}
+
+// handleGetProformaDocuments lists the user's proforma invoices and
+// quotations, newest first, optionally only those of one kind
+func handleGetProformaDocuments(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	kind := c.Query("kind")
+	if kind != "" && !models.IsValidProformaKind(kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be PROFORMA or QUOTE"})
+		return
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT "+proformaColumns+` FROM proforma_documents
+		WHERE user_id = $1 AND ($2 = '' OR kind = $2)
+		ORDER BY created_at DESC, id DESC`,
+		userID, kind)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch documents"})
+		return
+	}
+	defer rows.Close()
+
+	docs := make([]*models.ProformaDocument, 0)
+	for rows.Next() {
+		doc, err := scanProforma(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read document data"})
+			return
+		}
+		docs = append(docs, doc)
+	}
+	if rowsFailed(c, rows, "Failed to fetch documents") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"documents": docs})
+}
+
+// handleGetProformaDocument returns a proforma invoice or quotation
+func handleGetProformaDocument(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	doc, err := scanProforma(dbPool.QueryRow(c.Request.Context(),
+		"SELECT "+proformaColumns+" FROM proforma_documents WHERE id = $1 AND user_id = $2",
+		id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch document"})
+		return
+	}
+
+	c.JSON(http.StatusOK, doc)
+}
+
+// handleCreateProformaDocument creates a proforma invoice or, with
+// ?kind=QUOTE, a quotation from invoice JSON. It is numbered next in the
+// kind's own series; a number in the body is ignored.
+func handleCreateProformaDocument(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	kind := c.DefaultQuery("kind", models.ProformaKindProforma)
+	if !models.IsValidProformaKind(kind) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "kind must be PROFORMA or QUOTE"})
+		return
+	}
+
+	invoice, ok := bindProformaInvoice(c, userID)
+	if !ok {
+		return
+	}
+	invoice.DocDtls.No = ""
+	invoiceJSON, err := json.Marshal(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize document"})
+		return
+	}
+
+	// Numbers are taken as the next in the series; a concurrent create
+	// taking the same one fails the unique constraint and is retried
+	var id int
+	var docNo string
+	for attempt := 0; attempt < 3; attempt++ {
+		err = dbPool.QueryRow(ctx, `
+			INSERT INTO proforma_documents (user_id, kind, seq, doc_no, invoice_json)
+			SELECT $1, $2, n, $3 || LPAD(n::text, 5, '0'),
+				jsonb_set($4::jsonb, '{DocDtls,No}', to_jsonb($3 || LPAD(n::text, 5, '0')))
+			FROM (SELECT COALESCE(MAX(seq), 0) + 1 AS n FROM proforma_documents WHERE user_id = $1 AND kind = $2) next
+			RETURNING id, doc_no
+		`, userID, kind, models.ProformaPrefixes[kind], invoiceJSON).Scan(&id, &docNo)
+		if !isUniqueViolation(err) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Error storing %s document: %v", kind, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store document"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Document created successfully",
+		"id":      id,
+		"kind":    kind,
+		"doc_no":  docNo,
+	})
+}
+
+// handleUpdateProformaDocument replaces the lines of a document that has
+// not been converted yet; its kind and number are kept
+func handleUpdateProformaDocument(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	invoice, ok := bindProformaInvoice(c, userID)
+	if !ok {
+		return
+	}
+	invoiceJSON, err := json.Marshal(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize document"})
+		return
+	}
+
+	var docNo string
+	var invoiceID *int
+	err = dbPool.QueryRow(c.Request.Context(), `
+		UPDATE proforma_documents
+		SET invoice_json = CASE WHEN invoice_id IS NULL
+				THEN jsonb_set($1::jsonb, '{DocDtls,No}', to_jsonb(doc_no)) ELSE invoice_json END,
+			updated_at = CASE WHEN invoice_id IS NULL THEN NOW() ELSE updated_at END
+		WHERE id = $2 AND user_id = $3
+		RETURNING doc_no, invoice_id
+	`, invoiceJSON, id, userID).Scan(&docNo, &invoiceID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update document"})
+		return
+	}
+	if invoiceID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Document has been converted to a tax invoice and can no longer be changed", "invoice_id": *invoiceID})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document updated successfully",
+		"id":      id,
+		"doc_no":  docNo,
+	})
+}
+
+// handleDeleteProformaDocument deletes a proforma invoice or quotation; a
+// tax invoice it was converted to is kept
+func handleDeleteProformaDocument(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM proforma_documents WHERE id = $1 AND user_id = $2",
+		id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete document"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Document deleted successfully",
+		"id":      id,
+	})
+}
+
+// handleConvertProformaDocument issues a tax invoice from a proforma invoice
+// or quotation. The lines are copied into an invoice dated today, finalized
+// and numbered next in the invoice series unless ?status=draft is given, and
+// the document is linked to it. A document converts only once, unless
+// the invoice is deleted again.
+func handleConvertProformaDocument(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid document ID"})
+		return
+	}
+
+	status := c.DefaultQuery("status", models.InvoiceStatusFinalized)
+	if !isValidCreateStatus(status) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Status must be draft or finalized"})
+		return
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	doc, err := scanProforma(tx.QueryRow(ctx,
+		"SELECT "+proformaColumns+" FROM proforma_documents WHERE id = $1 AND user_id = $2 FOR UPDATE",
+		id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Document not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch document"})
+		return
+	}
+	if doc.InvoiceID != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("%s has already been converted to a tax invoice", doc.DocNo), "invoice_id": *doc.InvoiceID})
+		return
+	}
+
+	result, ok := issueConvertedInvoice(c, tx, doc.Invoice, status)
+	if !ok {
+		return
+	}
+	invoiceID := result["id"].(int)
+
+	_, err = tx.Exec(ctx,
+		"UPDATE proforma_documents SET invoice_id = $1, converted_at = NOW(), updated_at = NOW() WHERE id = $2",
+		invoiceID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to link document to invoice"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store invoice"})
+		return
+	}
+
+	result["message"] = fmt.Sprintf("%s converted to tax invoice %s", doc.DocNo, result["invoice_no"])
+	result["document_id"] = id
+	c.JSON(http.StatusCreated, result)
+}
+
+// issueConvertedInvoice stores the lines of a proforma document or delivery
+// challan as a new tax invoice dated today, within tx. It is numbered and
+// checked against locked periods and approval mode as invoices created
+// through the API are. Problems are answered on c; on success it returns the
+// new invoice's details for the response.
+func issueConvertedInvoice(c *gin.Context, tx pgx.Tx, invoice models.EInvoice, status string) (gin.H, bool) {
+	caller, ok := callerWithOverride(c)
+	if !ok {
+		return nil, false
+	}
+	ctx := c.Request.Context()
+
+	// The date is given in the user's date format, as for any new invoice
+	settings := getUserSettings(ctx, caller.userID)
+	invoice.DocDtls.Dt = time.Now().In(istLocation).Format(models.DateFormats[settings.DateFormat])
+	invoice.DocDtls.No = ""
+
+	results, err := storeNewInvoices(ctx, tx, caller, []models.EInvoice{invoice}, status)
+	if err != nil {
+		respondImportError(c, err)
+		return nil, false
+	}
+	return results[0], true
+}
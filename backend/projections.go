@@ -0,0 +1,370 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// projection is a read model derived from the event log. Its apply function
+// must be idempotent so that replaying an event never changes the result.
+type projection struct {
+	name  string
+	reset func(ctx context.Context, tx pgx.Tx) error
+	apply func(ctx context.Context, tx pgx.Tx, event *models.Event) error
+}
+
+// projections lists every projection that can be rebuilt from events
+var projections = map[string]*projection{
+	"invoice_report": {
+		name:  "invoice_report",
+		reset: resetInvoiceReport,
+		apply: applyInvoiceReport,
+	},
+	"search_index": {
+		name:  "search_index",
+		reset: resetSearchIndex,
+		apply: applySearchIndex,
+	},
+}
+
+// projectionBatchSize is the number of events applied per transaction
+const projectionBatchSize = 500
+
+// jobTypeRebuildProjection is the job type that replays events into a projection
+const jobTypeRebuildProjection = "projection.rebuild"
+
+// createProjectionTables creates the projection checkpoints and read model tables
+func createProjectionTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS projection_checkpoints (
+			name VARCHAR(100) PRIMARY KEY,
+			last_tx_id BIGINT NOT NULL DEFAULT 0,
+			last_event_id BIGINT NOT NULL DEFAULT 0,
+			rebuilding BOOLEAN NOT NULL DEFAULT FALSE,
+			rebuild_started_at TIMESTAMP,
+			rebuild_finished_at TIMESTAMP,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create projection_checkpoints table: %v", err)
+	}
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE projection_checkpoints ADD COLUMN IF NOT EXISTS last_tx_id BIGINT NOT NULL DEFAULT 0")
+	if err != nil {
+		log.Fatalf("Failed to add last_tx_id column to projection_checkpoints table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS report_invoices (
+			invoice_id INTEGER PRIMARY KEY,
+			user_id INTEGER,
+			invoice_no VARCHAR(50) NOT NULL,
+			seller_gstin VARCHAR(15) NOT NULL DEFAULT '',
+			buyer_gstin VARCHAR(15) NOT NULL DEFAULT '',
+			buyer_name VARCHAR(255) NOT NULL DEFAULT '',
+			invoice_date VARCHAR(10) NOT NULL DEFAULT '',
+			total_value DECIMAL(14,2) NOT NULL DEFAULT 0,
+			status VARCHAR(20) NOT NULL,
+			sandbox BOOLEAN NOT NULL DEFAULT FALSE,
+			last_event_id BIGINT NOT NULL,
+			updated_at TIMESTAMP NOT NULL
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create report_invoices table: %v", err)
+	}
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE report_invoices ADD COLUMN IF NOT EXISTS sandbox BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		log.Fatalf("Failed to add sandbox column to report_invoices table: %v", err)
+	}
+
+	for name := range projections {
+		_, err := dbPool.Exec(context.Background(),
+			"INSERT INTO projection_checkpoints (name) VALUES ($1) ON CONFLICT (name) DO NOTHING", name)
+		if err != nil {
+			log.Fatalf("Failed to create checkpoint for projection %s: %v", name, err)
+		}
+	}
+
+	registerJobHandler(jobTypeRebuildProjection, runRebuildProjectionJob)
+}
+
+// resetInvoiceReport clears the invoice report before a rebuild
+func resetInvoiceReport(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, "TRUNCATE report_invoices")
+	return err
+}
+
+// applyInvoiceReport folds an invoice event into the invoice report
+func applyInvoiceReport(ctx context.Context, tx pgx.Tx, event *models.Event) error {
+	if event.EntityType != entityInvoice {
+		return nil
+	}
+
+	if event.Type == eventInvoiceDeleted {
+		_, err := tx.Exec(ctx, "DELETE FROM report_invoices WHERE invoice_id = $1", event.EntityID)
+		return err
+	}
+
+	var snapshot invoiceSnapshot
+	if err := json.Unmarshal(event.Payload, &snapshot); err != nil {
+		return fmt.Errorf("event %d: invalid invoice snapshot: %w", event.ID, err)
+	}
+
+	// The last_event_id guard keeps replays of older events from overwriting newer state
+	_, err := tx.Exec(ctx, `
+		INSERT INTO report_invoices (
+			invoice_id, user_id, invoice_no, seller_gstin, buyer_gstin, buyer_name,
+			invoice_date, total_value, status, sandbox, last_event_id, updated_at
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)
+		ON CONFLICT (invoice_id) DO UPDATE
+		SET user_id = $2, invoice_no = $3, seller_gstin = $4, buyer_gstin = $5, buyer_name = $6,
+			invoice_date = $7, total_value = $8, status = $9, sandbox = $10, last_event_id = $11, updated_at = $12
+		WHERE report_invoices.last_event_id <= $11
+	`, event.EntityID, event.UserID, snapshot.InvoiceNo, snapshot.SellerGSTIN, snapshot.BuyerGSTIN,
+		snapshot.BuyerName, snapshot.Date, snapshot.TotalValue, snapshot.Status, snapshot.Sandbox, event.ID, event.CreatedAt)
+	return err
+}
+
+// catchUpProjection applies all settled events past the projection's
+// checkpoint in batches, returning the number of events applied
+func catchUpProjection(ctx context.Context, p *projection) (int, error) {
+	applied := 0
+	for {
+		n, err := applyProjectionBatch(ctx, p)
+		applied += n
+		if err != nil || n < projectionBatchSize {
+			return applied, err
+		}
+	}
+}
+
+// applyProjectionBatch applies the next batch of events and advances the
+// checkpoint in the same transaction, so a batch is applied exactly once
+func applyProjectionBatch(ctx context.Context, p *projection) (int, error) {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback(ctx)
+
+	var cursor eventCursor
+	err = tx.QueryRow(ctx,
+		"SELECT last_tx_id, last_event_id FROM projection_checkpoints WHERE name = $1 FOR UPDATE",
+		p.name).Scan(&cursor.txID, &cursor.eventID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to lock checkpoint: %w", err)
+	}
+
+	events, err := readSettledEvents(ctx, tx, cursor, projectionBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	for _, e := range events {
+		if err := p.apply(ctx, tx, e); err != nil {
+			return 0, fmt.Errorf("failed to apply event %d (%s): %w", e.ID, e.Type, err)
+		}
+	}
+
+	cursor = cursorAfter(events[len(events)-1])
+	_, err = tx.Exec(ctx,
+		"UPDATE projection_checkpoints SET last_tx_id = $1, last_event_id = $2, updated_at = NOW() WHERE name = $3",
+		cursor.txID, cursor.eventID, p.name)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// rebuildProjection resets a projection and replays the whole event log into it
+func rebuildProjection(ctx context.Context, p *projection) error {
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		"SELECT 1 FROM projection_checkpoints WHERE name = $1 FOR UPDATE", p.name)
+	if err != nil {
+		return err
+	}
+	if err := p.reset(ctx, tx); err != nil {
+		return fmt.Errorf("failed to reset projection: %w", err)
+	}
+	_, err = tx.Exec(ctx, `
+		UPDATE projection_checkpoints
+		SET last_tx_id = 0, last_event_id = 0, rebuilding = TRUE, rebuild_started_at = NOW(),
+			rebuild_finished_at = NULL, updated_at = NOW()
+		WHERE name = $1
+	`, p.name)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	log.Printf("Rebuilding projection %s", p.name)
+	applied := 0
+	for {
+		n, err := applyProjectionBatch(ctx, p)
+		if err != nil {
+			return err
+		}
+		applied += n
+		if n < projectionBatchSize {
+			break
+		}
+		log.Printf("Projection %s: replayed %d events so far", p.name, applied)
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		UPDATE projection_checkpoints
+		SET rebuilding = FALSE, rebuild_finished_at = NOW(), updated_at = NOW()
+		WHERE name = $1
+	`, p.name)
+	if err != nil {
+		return err
+	}
+	log.Printf("Projection %s rebuilt from %d events", p.name, applied)
+	return nil
+}
+
+// runRebuildProjectionJob processes projection.rebuild jobs. A failed rebuild
+// is retried from scratch, which is safe because rebuilds start with a reset.
+func runRebuildProjectionJob(ctx context.Context, job *models.Job) error {
+	var payload struct {
+		Projection string `json:"projection"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+	p, ok := projections[payload.Projection]
+	if !ok {
+		return permanentError(fmt.Errorf("unknown projection %q", payload.Projection))
+	}
+	return rebuildProjection(ctx, p)
+}
+
+// startProjectionUpdater keeps projections current by applying new events periodically
+func startProjectionUpdater(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+		for {
+			for _, p := range projections {
+				if _, err := catchUpProjection(ctx, p); err != nil && ctx.Err() == nil {
+					log.Printf("Error updating projection %s: %v", p.name, err)
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// handleAdminGetProjections reports how far each projection has replayed the event log
+func handleAdminGetProjections(c *gin.Context) {
+	var total int64
+	err := dbPool.QueryRow(c.Request.Context(), "SELECT COUNT(*) FROM events").Scan(&total)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to count events"})
+		return
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT cp.name, cp.last_event_id,
+			(SELECT COUNT(*) FROM events WHERE (tx_id, id) <= (cp.last_tx_id, cp.last_event_id)),
+			cp.rebuilding, cp.rebuild_started_at, cp.rebuild_finished_at, cp.updated_at
+		FROM projection_checkpoints cp
+	`)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch projections"})
+		return
+	}
+	defer rows.Close()
+
+	statuses := make([]models.ProjectionStatus, 0)
+	for rows.Next() {
+		var s models.ProjectionStatus
+		if err := rows.Scan(&s.Name, &s.LastEventID, &s.Processed, &s.Rebuilding,
+			&s.RebuildStartedAt, &s.RebuildFinishedAt, &s.UpdatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read projection data"})
+			return
+		}
+		if _, ok := projections[s.Name]; !ok {
+			continue
+		}
+		s.Total = total
+		statuses = append(statuses, s)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+
+	c.JSON(http.StatusOK, gin.H{"projections": statuses})
+}
+
+// handleAdminRebuildProjection queues a full replay of the event log into a projection
+func handleAdminRebuildProjection(c *gin.Context) {
+	name := c.Param("name")
+	if _, ok := projections[name]; !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Projection not found"})
+		return
+	}
+
+	// Refuse to queue a second rebuild while one is pending or running
+	var queued bool
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT EXISTS (
+			SELECT 1 FROM jobs
+			WHERE type = $1 AND status IN ('pending', 'running') AND payload->>'projection' = $2
+		)
+	`, jobTypeRebuildProjection, name).Scan(&queued)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if queued {
+		c.JSON(http.StatusConflict, gin.H{"error": "A rebuild of this projection is already queued"})
+		return
+	}
+
+	jobID, err := enqueueJob(c.Request.Context(), c.GetInt("userID"), jobTypeRebuildProjection,
+		gin.H{"projection": name})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue rebuild"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "Projection rebuild queued",
+		"projection": name,
+		"job_id":     jobID,
+	})
+}
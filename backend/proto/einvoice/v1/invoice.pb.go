@@ -0,0 +1,565 @@
+// The gRPC API for ERP systems issuing invoices in volume. It serves on
+// GRPC_PORT and shares the invoice service with the REST API: invoices are
+// numbered, validated, totalled and stored exactly as POST /api/generate-invoice
+// does. Calls authenticate with the same access tokens as the REST API, sent
+// as "authorization: Bearer <token>" metadata.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.6
+// 	protoc        (unknown)
+// source: einvoice/v1/invoice.proto
+
+package einvoicev1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Invoice is a stored invoice. Its content is the e-invoice JSON of the
+// INV-01 schema, as the REST API returns it; the other fields are kept
+// beside it for filtering without parsing the document.
+type Invoice struct {
+	state       protoimpl.MessageState `protogen:"open.v1"`
+	Id          int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	InvoiceNo   string                 `protobuf:"bytes,2,opt,name=invoice_no,json=invoiceNo,proto3" json:"invoice_no,omitempty"`
+	SellerGstin string                 `protobuf:"bytes,3,opt,name=seller_gstin,json=sellerGstin,proto3" json:"seller_gstin,omitempty"`
+	// draft, pending_approval, finalized or cancelled
+	Status string `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	// The IRN once the invoice is registered with the IRP
+	Irn      string `protobuf:"bytes,5,opt,name=irn,proto3" json:"irn,omitempty"`
+	Exported bool   `protobuf:"varint,6,opt,name=exported,proto3" json:"exported,omitempty"`
+	Sandbox  bool   `protobuf:"varint,7,opt,name=sandbox,proto3" json:"sandbox,omitempty"`
+	// RFC 3339 timestamps
+	CreatedAt string `protobuf:"bytes,8,opt,name=created_at,json=createdAt,proto3" json:"created_at,omitempty"`
+	UpdatedAt string `protobuf:"bytes,9,opt,name=updated_at,json=updatedAt,proto3" json:"updated_at,omitempty"`
+	// Path of the invoice's QR code image on the REST API
+	QrUrl         string `protobuf:"bytes,10,opt,name=qr_url,json=qrUrl,proto3" json:"qr_url,omitempty"`
+	InvoiceJson   []byte `protobuf:"bytes,11,opt,name=invoice_json,json=invoiceJson,proto3" json:"invoice_json,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Invoice) Reset() {
+	*x = Invoice{}
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Invoice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Invoice) ProtoMessage() {}
+
+func (x *Invoice) ProtoReflect() protoreflect.Message {
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Invoice.ProtoReflect.Descriptor instead.
+func (*Invoice) Descriptor() ([]byte, []int) {
+	return file_einvoice_v1_invoice_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Invoice) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *Invoice) GetInvoiceNo() string {
+	if x != nil {
+		return x.InvoiceNo
+	}
+	return ""
+}
+
+func (x *Invoice) GetSellerGstin() string {
+	if x != nil {
+		return x.SellerGstin
+	}
+	return ""
+}
+
+func (x *Invoice) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Invoice) GetIrn() string {
+	if x != nil {
+		return x.Irn
+	}
+	return ""
+}
+
+func (x *Invoice) GetExported() bool {
+	if x != nil {
+		return x.Exported
+	}
+	return false
+}
+
+func (x *Invoice) GetSandbox() bool {
+	if x != nil {
+		return x.Sandbox
+	}
+	return false
+}
+
+func (x *Invoice) GetCreatedAt() string {
+	if x != nil {
+		return x.CreatedAt
+	}
+	return ""
+}
+
+func (x *Invoice) GetUpdatedAt() string {
+	if x != nil {
+		return x.UpdatedAt
+	}
+	return ""
+}
+
+func (x *Invoice) GetQrUrl() string {
+	if x != nil {
+		return x.QrUrl
+	}
+	return ""
+}
+
+func (x *Invoice) GetInvoiceJson() []byte {
+	if x != nil {
+		return x.InvoiceJson
+	}
+	return nil
+}
+
+type GenerateInvoiceRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// One e-invoice JSON document per invoice. Invoices without a number are
+	// numbered from the account's series, as over REST.
+	InvoiceJson [][]byte `protobuf:"bytes,1,rep,name=invoice_json,json=invoiceJson,proto3" json:"invoice_json,omitempty"`
+	// Save the invoices as editable drafts instead of finalizing them
+	Draft         bool `protobuf:"varint,2,opt,name=draft,proto3" json:"draft,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateInvoiceRequest) Reset() {
+	*x = GenerateInvoiceRequest{}
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateInvoiceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateInvoiceRequest) ProtoMessage() {}
+
+func (x *GenerateInvoiceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateInvoiceRequest.ProtoReflect.Descriptor instead.
+func (*GenerateInvoiceRequest) Descriptor() ([]byte, []int) {
+	return file_einvoice_v1_invoice_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GenerateInvoiceRequest) GetInvoiceJson() [][]byte {
+	if x != nil {
+		return x.InvoiceJson
+	}
+	return nil
+}
+
+func (x *GenerateInvoiceRequest) GetDraft() bool {
+	if x != nil {
+		return x.Draft
+	}
+	return false
+}
+
+type GeneratedInvoice struct {
+	state     protoimpl.MessageState `protogen:"open.v1"`
+	Id        int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	InvoiceNo string                 `protobuf:"bytes,2,opt,name=invoice_no,json=invoiceNo,proto3" json:"invoice_no,omitempty"`
+	Status    string                 `protobuf:"bytes,3,opt,name=status,proto3" json:"status,omitempty"`
+	QrUrl     string                 `protobuf:"bytes,4,opt,name=qr_url,json=qrUrl,proto3" json:"qr_url,omitempty"`
+	// Master data warnings, such as an unknown HSN code
+	Warnings      []string `protobuf:"bytes,5,rep,name=warnings,proto3" json:"warnings,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GeneratedInvoice) Reset() {
+	*x = GeneratedInvoice{}
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GeneratedInvoice) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GeneratedInvoice) ProtoMessage() {}
+
+func (x *GeneratedInvoice) ProtoReflect() protoreflect.Message {
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GeneratedInvoice.ProtoReflect.Descriptor instead.
+func (*GeneratedInvoice) Descriptor() ([]byte, []int) {
+	return file_einvoice_v1_invoice_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *GeneratedInvoice) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+func (x *GeneratedInvoice) GetInvoiceNo() string {
+	if x != nil {
+		return x.InvoiceNo
+	}
+	return ""
+}
+
+func (x *GeneratedInvoice) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *GeneratedInvoice) GetQrUrl() string {
+	if x != nil {
+		return x.QrUrl
+	}
+	return ""
+}
+
+func (x *GeneratedInvoice) GetWarnings() []string {
+	if x != nil {
+		return x.Warnings
+	}
+	return nil
+}
+
+type GenerateInvoiceResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Invoices      []*GeneratedInvoice    `protobuf:"bytes,1,rep,name=invoices,proto3" json:"invoices,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GenerateInvoiceResponse) Reset() {
+	*x = GenerateInvoiceResponse{}
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GenerateInvoiceResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GenerateInvoiceResponse) ProtoMessage() {}
+
+func (x *GenerateInvoiceResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GenerateInvoiceResponse.ProtoReflect.Descriptor instead.
+func (*GenerateInvoiceResponse) Descriptor() ([]byte, []int) {
+	return file_einvoice_v1_invoice_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GenerateInvoiceResponse) GetInvoices() []*GeneratedInvoice {
+	if x != nil {
+		return x.Invoices
+	}
+	return nil
+}
+
+type GetInvoiceRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            int64                  `protobuf:"varint,1,opt,name=id,proto3" json:"id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetInvoiceRequest) Reset() {
+	*x = GetInvoiceRequest{}
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetInvoiceRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetInvoiceRequest) ProtoMessage() {}
+
+func (x *GetInvoiceRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetInvoiceRequest.ProtoReflect.Descriptor instead.
+func (*GetInvoiceRequest) Descriptor() ([]byte, []int) {
+	return file_einvoice_v1_invoice_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetInvoiceRequest) GetId() int64 {
+	if x != nil {
+		return x.Id
+	}
+	return 0
+}
+
+type ListInvoicesRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Invoice dates as YYYY-MM-DD; either may be left empty
+	From        string `protobuf:"bytes,1,opt,name=from,proto3" json:"from,omitempty"`
+	To          string `protobuf:"bytes,2,opt,name=to,proto3" json:"to,omitempty"`
+	SellerGstin string `protobuf:"bytes,3,opt,name=seller_gstin,json=sellerGstin,proto3" json:"seller_gstin,omitempty"`
+	// A status to list only invoices in it
+	Status string `protobuf:"bytes,4,opt,name=status,proto3" json:"status,omitempty"`
+	// Only invoices with a higher ID are listed
+	AfterId       int64 `protobuf:"varint,5,opt,name=after_id,json=afterId,proto3" json:"after_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListInvoicesRequest) Reset() {
+	*x = ListInvoicesRequest{}
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListInvoicesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListInvoicesRequest) ProtoMessage() {}
+
+func (x *ListInvoicesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_einvoice_v1_invoice_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListInvoicesRequest.ProtoReflect.Descriptor instead.
+func (*ListInvoicesRequest) Descriptor() ([]byte, []int) {
+	return file_einvoice_v1_invoice_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *ListInvoicesRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *ListInvoicesRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *ListInvoicesRequest) GetSellerGstin() string {
+	if x != nil {
+		return x.SellerGstin
+	}
+	return ""
+}
+
+func (x *ListInvoicesRequest) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *ListInvoicesRequest) GetAfterId() int64 {
+	if x != nil {
+		return x.AfterId
+	}
+	return 0
+}
+
+var File_einvoice_v1_invoice_proto protoreflect.FileDescriptor
+
+const file_einvoice_v1_invoice_proto_rawDesc = "" +
+	"\n" +
+	"\x19einvoice/v1/invoice.proto\x12\veinvoice.v1\"\xb3\x02\n" +
+	"\aInvoice\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1d\n" +
+	"\n" +
+	"invoice_no\x18\x02 \x01(\tR\tinvoiceNo\x12!\n" +
+	"\fseller_gstin\x18\x03 \x01(\tR\vsellerGstin\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x10\n" +
+	"\x03irn\x18\x05 \x01(\tR\x03irn\x12\x1a\n" +
+	"\bexported\x18\x06 \x01(\bR\bexported\x12\x18\n" +
+	"\asandbox\x18\a \x01(\bR\asandbox\x12\x1d\n" +
+	"\n" +
+	"created_at\x18\b \x01(\tR\tcreatedAt\x12\x1d\n" +
+	"\n" +
+	"updated_at\x18\t \x01(\tR\tupdatedAt\x12\x15\n" +
+	"\x06qr_url\x18\n" +
+	" \x01(\tR\x05qrUrl\x12!\n" +
+	"\finvoice_json\x18\v \x01(\fR\vinvoiceJson\"Q\n" +
+	"\x16GenerateInvoiceRequest\x12!\n" +
+	"\finvoice_json\x18\x01 \x03(\fR\vinvoiceJson\x12\x14\n" +
+	"\x05draft\x18\x02 \x01(\bR\x05draft\"\x8c\x01\n" +
+	"\x10GeneratedInvoice\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\x12\x1d\n" +
+	"\n" +
+	"invoice_no\x18\x02 \x01(\tR\tinvoiceNo\x12\x16\n" +
+	"\x06status\x18\x03 \x01(\tR\x06status\x12\x15\n" +
+	"\x06qr_url\x18\x04 \x01(\tR\x05qrUrl\x12\x1a\n" +
+	"\bwarnings\x18\x05 \x03(\tR\bwarnings\"T\n" +
+	"\x17GenerateInvoiceResponse\x129\n" +
+	"\binvoices\x18\x01 \x03(\v2\x1d.einvoice.v1.GeneratedInvoiceR\binvoices\"#\n" +
+	"\x11GetInvoiceRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\x03R\x02id\"\x8f\x01\n" +
+	"\x13ListInvoicesRequest\x12\x12\n" +
+	"\x04from\x18\x01 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x02 \x01(\tR\x02to\x12!\n" +
+	"\fseller_gstin\x18\x03 \x01(\tR\vsellerGstin\x12\x16\n" +
+	"\x06status\x18\x04 \x01(\tR\x06status\x12\x19\n" +
+	"\bafter_id\x18\x05 \x01(\x03R\aafterId2\xfc\x01\n" +
+	"\x0eInvoiceService\x12\\\n" +
+	"\x0fGenerateInvoice\x12#.einvoice.v1.GenerateInvoiceRequest\x1a$.einvoice.v1.GenerateInvoiceResponse\x12B\n" +
+	"\n" +
+	"GetInvoice\x12\x1e.einvoice.v1.GetInvoiceRequest\x1a\x14.einvoice.v1.Invoice\x12H\n" +
+	"\fListInvoices\x12 .einvoice.v1.ListInvoicesRequest\x1a\x14.einvoice.v1.Invoice0\x01B+Z)einvoice-app/proto/einvoice/v1;einvoicev1b\x06proto3"
+
+var (
+	file_einvoice_v1_invoice_proto_rawDescOnce sync.Once
+	file_einvoice_v1_invoice_proto_rawDescData []byte
+)
+
+func file_einvoice_v1_invoice_proto_rawDescGZIP() []byte {
+	file_einvoice_v1_invoice_proto_rawDescOnce.Do(func() {
+		file_einvoice_v1_invoice_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_einvoice_v1_invoice_proto_rawDesc), len(file_einvoice_v1_invoice_proto_rawDesc)))
+	})
+	return file_einvoice_v1_invoice_proto_rawDescData
+}
+
+var file_einvoice_v1_invoice_proto_msgTypes = make([]protoimpl.MessageInfo, 6)
+var file_einvoice_v1_invoice_proto_goTypes = []any{
+	(*Invoice)(nil),                 // 0: einvoice.v1.Invoice
+	(*GenerateInvoiceRequest)(nil),  // 1: einvoice.v1.GenerateInvoiceRequest
+	(*GeneratedInvoice)(nil),        // 2: einvoice.v1.GeneratedInvoice
+	(*GenerateInvoiceResponse)(nil), // 3: einvoice.v1.GenerateInvoiceResponse
+	(*GetInvoiceRequest)(nil),       // 4: einvoice.v1.GetInvoiceRequest
+	(*ListInvoicesRequest)(nil),     // 5: einvoice.v1.ListInvoicesRequest
+}
+var file_einvoice_v1_invoice_proto_depIdxs = []int32{
+	2, // 0: einvoice.v1.GenerateInvoiceResponse.invoices:type_name -> einvoice.v1.GeneratedInvoice
+	1, // 1: einvoice.v1.InvoiceService.GenerateInvoice:input_type -> einvoice.v1.GenerateInvoiceRequest
+	4, // 2: einvoice.v1.InvoiceService.GetInvoice:input_type -> einvoice.v1.GetInvoiceRequest
+	5, // 3: einvoice.v1.InvoiceService.ListInvoices:input_type -> einvoice.v1.ListInvoicesRequest
+	3, // 4: einvoice.v1.InvoiceService.GenerateInvoice:output_type -> einvoice.v1.GenerateInvoiceResponse
+	0, // 5: einvoice.v1.InvoiceService.GetInvoice:output_type -> einvoice.v1.Invoice
+	0, // 6: einvoice.v1.InvoiceService.ListInvoices:output_type -> einvoice.v1.Invoice
+	4, // [4:7] is the sub-list for method output_type
+	1, // [1:4] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_einvoice_v1_invoice_proto_init() }
+func file_einvoice_v1_invoice_proto_init() {
+	if File_einvoice_v1_invoice_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_einvoice_v1_invoice_proto_rawDesc), len(file_einvoice_v1_invoice_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   6,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_einvoice_v1_invoice_proto_goTypes,
+		DependencyIndexes: file_einvoice_v1_invoice_proto_depIdxs,
+		MessageInfos:      file_einvoice_v1_invoice_proto_msgTypes,
+	}.Build()
+	File_einvoice_v1_invoice_proto = out.File
+	file_einvoice_v1_invoice_proto_goTypes = nil
+	file_einvoice_v1_invoice_proto_depIdxs = nil
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// poSummaryQuery aggregates invoices by the PO references in their RefDtls and
+// joins them to recorded purchase orders. Invoices referencing a PO that was
+// never recorded are returned with a zero ID. Cancelled invoices do not count
+// towards the invoiced value, and sandbox invoices only count when $2 is true.
+const poSummaryQuery = `
+	WITH inv AS (
+		SELECT DISTINCT i.id,
+			COALESCE(i.invoice_json->'BuyerDtls'->>'Gstin', '') AS buyer_gstin,
+			c->>'PORefr' AS po_number,
+			COALESCE((i.invoice_json->'ValDtls'->>'TotInvVal')::float8, 0) AS total
+		FROM invoices i
+		CROSS JOIN LATERAL jsonb_array_elements(
+			CASE WHEN jsonb_typeof(i.invoice_json->'RefDtls'->'ContrDtls') = 'array'
+			THEN i.invoice_json->'RefDtls'->'ContrDtls' ELSE '[]'::jsonb END
+		) c
+		WHERE i.user_id = $1 AND i.status <> 'cancelled' AND (NOT i.sandbox OR $2) AND COALESCE(c->>'PORefr', '') <> ''
+	), agg AS (
+		SELECT buyer_gstin, po_number, COUNT(*) AS invoice_count, SUM(total) AS invoiced
+		FROM inv
+		GROUP BY buyer_gstin, po_number
+	)
+	SELECT COALESCE(po.id, 0), COALESCE(po.po_number, agg.po_number), COALESCE(po.po_date, ''),
+		COALESCE(po.buyer_gstin, agg.buyer_gstin), COALESCE(po.buyer_name, ''),
+		COALESCE(po.po_value, 0)::float8, COALESCE(po.created_at, NOW()),
+		COALESCE(agg.invoice_count, 0), COALESCE(agg.invoiced, 0)
+	FROM (SELECT * FROM purchase_orders WHERE user_id = $1) po
+	FULL OUTER JOIN agg ON agg.po_number = po.po_number AND agg.buyer_gstin = po.buyer_gstin
+`
+
+// createPurchaseOrderTables creates the purchase orders table
+func createPurchaseOrderTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS purchase_orders (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			po_number VARCHAR(16) NOT NULL,
+			po_date VARCHAR(10) NOT NULL DEFAULT '',
+			buyer_gstin VARCHAR(15) NOT NULL DEFAULT '',
+			buyer_name VARCHAR(255) NOT NULL DEFAULT '',
+			po_value DECIMAL(14,2) NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, buyer_gstin, po_number)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create purchase_orders table: %v", err)
+	}
+}
+
+// queryPOSummaries returns purchase orders with their invoiced and remaining values.
+// When trackedOnly is false, PO references found only on invoices are included too.
+func queryPOSummaries(ctx context.Context, userID int, trackedOnly, withSandbox bool) ([]models.POSummary, error) {
+	query := poSummaryQuery
+	if trackedOnly {
+		query += " WHERE po.id IS NOT NULL"
+	}
+	query += " ORDER BY 2, 4"
+
+	rows, err := dbPool.Query(ctx, query, userID, withSandbox)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	summaries := make([]models.POSummary, 0)
+	for rows.Next() {
+		var s models.POSummary
+		err := rows.Scan(&s.ID, &s.PONumber, &s.PODate, &s.BuyerGSTIN, &s.BuyerName,
+			&s.POValue, &s.CreatedAt, &s.InvoiceCount, &s.InvoicedValue)
+		if err != nil {
+			return nil, err
+		}
+		s.UserID = userID
+		s.InvoicedValue = models.Round(s.InvoicedValue, models.AmountDecimals)
+		s.RemainingValue = models.Round(s.POValue-s.InvoicedValue, models.AmountDecimals)
+		summaries = append(summaries, s)
+	}
+	return summaries, rows.Err()
+}
+
+// bindPurchaseOrder parses and validates a purchase order from the request body
+func bindPurchaseOrder(c *gin.Context) (models.PurchaseOrder, bool) {
+	var po models.PurchaseOrder
+	if err := c.ShouldBindJSON(&po); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase order data: " + err.Error()})
+		return po, false
+	}
+
+	if po.PONumber == "" || len(po.PONumber) > 16 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PO number must be 1 to 16 characters"})
+		return po, false
+	}
+	if po.PODate != "" {
+		if _, err := time.Parse("02/01/2006", po.PODate); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "PO date must be in DD/MM/YYYY format"})
+			return po, false
+		}
+	}
+	if po.BuyerGSTIN != "" && !models.IsValidGSTIN(po.BuyerGSTIN) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid buyer GSTIN format"})
+		return po, false
+	}
+	if po.POValue < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "PO value cannot be negative"})
+		return po, false
+	}
+
+	return po, true
+}
+
+// handleGetPurchaseOrders returns recorded purchase orders with invoiced and remaining values
+func handleGetPurchaseOrders(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	summaries, err := queryPOSummaries(ctx, userID, true, includeSandbox(c))
+	if err != nil {
+		log.Printf("Error fetching purchase orders: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase orders"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purchase_orders": summaries})
+}
+
+// handleCreatePurchaseOrder records a new purchase order
+func handleCreatePurchaseOrder(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	po, ok := bindPurchaseOrder(c)
+	if !ok {
+		return
+	}
+
+	var id int
+	err := dbPool.QueryRow(c.Request.Context(), `
+		INSERT INTO purchase_orders (user_id, po_number, po_date, buyer_gstin, buyer_name, po_value)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, buyer_gstin, po_number) DO NOTHING
+		RETURNING id
+	`, userID, po.PONumber, po.PODate, po.BuyerGSTIN, po.BuyerName, po.POValue).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusConflict, gin.H{"error": "Purchase order already exists for this buyer"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create purchase order: " + err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":           "Purchase order created successfully",
+		"purchase_order_id": id,
+	})
+}
+
+// handleUpdatePurchaseOrder updates an existing purchase order
+func handleUpdatePurchaseOrder(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase order ID"})
+		return
+	}
+
+	po, ok := bindPurchaseOrder(c)
+	if !ok {
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(), `
+		UPDATE purchase_orders
+		SET po_number = $1, po_date = $2, buyer_gstin = $3, buyer_name = $4, po_value = $5
+		WHERE id = $6 AND user_id = $7
+	`, po.PONumber, po.PODate, po.BuyerGSTIN, po.BuyerName, po.POValue, id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase order"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Purchase order updated successfully",
+		"purchase_order_id": id,
+	})
+}
+
+// handleDeletePurchaseOrder deletes a purchase order; invoices keep their PO references
+func handleDeletePurchaseOrder(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase order ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM purchase_orders WHERE id = $1 AND user_id = $2",
+		id, userID,
+	)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete purchase order"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":           "Purchase order deleted successfully",
+		"purchase_order_id": id,
+	})
+}
+
+// handleGetPurchaseOrderInvoices lists the invoices billed against a purchase order
+func handleGetPurchaseOrderInvoices(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase order ID"})
+		return
+	}
+
+	var po models.PurchaseOrder
+	err = dbPool.QueryRow(c.Request.Context(), `
+		SELECT id, po_number, po_date, buyer_gstin, buyer_name, po_value::float8, created_at
+		FROM purchase_orders WHERE id = $1 AND user_id = $2
+	`, id, userID).Scan(&po.ID, &po.PONumber, &po.PODate, &po.BuyerGSTIN, &po.BuyerName, &po.POValue, &po.CreatedAt)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase order not found or not authorized"})
+		return
+	}
+	po.UserID = userID
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, invoice_no, COALESCE(invoice_json->'DocDtls'->>'Dt', ''),
+			COALESCE((invoice_json->'ValDtls'->>'TotInvVal')::float8, 0), status, sandbox
+		FROM invoices
+		WHERE user_id = $1
+			AND invoice_json @> jsonb_build_object('RefDtls', jsonb_build_object('ContrDtls',
+				jsonb_build_array(jsonb_build_object('PORefr', $2::text))))
+			AND COALESCE(invoice_json->'BuyerDtls'->>'Gstin', '') = $3
+		ORDER BY created_at
+	`, userID, po.PONumber, po.BuyerGSTIN)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+	defer rows.Close()
+
+	invoices := make([]gin.H, 0)
+	var invoiced float64
+	for rows.Next() {
+		var invoiceID int
+		var invoiceNo, date, status string
+		var total float64
+		var sandbox bool
+		if err := rows.Scan(&invoiceID, &invoiceNo, &date, &total, &status, &sandbox); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read invoice data"})
+			return
+		}
+		if status != models.InvoiceStatusCancelled && (!sandbox || includeSandbox(c)) {
+			invoiced += total
+		}
+		invoices = append(invoices, gin.H{
+			"id":          invoiceID,
+			"invoice_no":  invoiceNo,
+			"date":        date,
+			"total_value": total,
+			"status":      status,
+			"cancelled":   status == models.InvoiceStatusCancelled,
+			"sandbox":     sandbox,
+		})
+	}
+
+	invoiced = models.Round(invoiced, models.AmountDecimals)
+	c.JSON(http.StatusOK, gin.H{
+		"purchase_order":  po,
+		"invoices":        invoices,
+		"invoiced_value":  invoiced,
+		"remaining_value": models.Round(po.POValue-invoiced, models.AmountDecimals),
+	})
+}
+
+// handlePOTrackingReport reports invoice counts and remaining value for every PO,
+// including PO references on invoices that were never recorded as purchase orders
+func handlePOTrackingReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	summaries, err := queryPOSummaries(ctx, userID, false, includeSandbox(c))
+	if err != nil {
+		log.Printf("Error building PO tracking report: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build PO tracking report"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purchase_orders": summaries})
+}
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// createPurchaseTables creates the purchase bill and line item tables
+func createPurchaseTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS purchase_invoices (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			supplier_id INTEGER REFERENCES suppliers(id) ON DELETE SET NULL,
+			supplier_gstin VARCHAR(15) NOT NULL DEFAULT '',
+			supplier_name VARCHAR(255) NOT NULL,
+			recipient_gstin VARCHAR(15) NOT NULL DEFAULT '',
+			bill_no VARCHAR(16) NOT NULL,
+			bill_date DATE NOT NULL,
+			place_of_supply VARCHAR(2) NOT NULL,
+			reverse_charge BOOLEAN NOT NULL DEFAULT FALSE,
+			taxable_value DECIMAL(14,2) NOT NULL DEFAULT 0,
+			igst DECIMAL(14,2) NOT NULL DEFAULT 0,
+			cgst DECIMAL(14,2) NOT NULL DEFAULT 0,
+			sgst DECIMAL(14,2) NOT NULL DEFAULT 0,
+			cess DECIMAL(14,2) NOT NULL DEFAULT 0,
+			total_value DECIMAL(14,2) NOT NULL DEFAULT 0,
+			itc_available DECIMAL(14,2) NOT NULL DEFAULT 0,
+			notes TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE(user_id, supplier_gstin, bill_no)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create purchase_invoices table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS purchase_invoice_items (
+			id SERIAL PRIMARY KEY,
+			purchase_invoice_id INTEGER NOT NULL REFERENCES purchase_invoices(id) ON DELETE CASCADE,
+			line_no INTEGER NOT NULL,
+			description VARCHAR(300) NOT NULL,
+			hsn_code VARCHAR(8) NOT NULL,
+			qty DECIMAL(15,3) NOT NULL,
+			unit VARCHAR(8) NOT NULL DEFAULT '',
+			unit_price DECIMAL(15,3) NOT NULL,
+			taxable_value DECIMAL(14,2) NOT NULL,
+			gst_rate DECIMAL(6,3) NOT NULL,
+			igst DECIMAL(14,2) NOT NULL DEFAULT 0,
+			cgst DECIMAL(14,2) NOT NULL DEFAULT 0,
+			sgst DECIMAL(14,2) NOT NULL DEFAULT 0,
+			cess DECIMAL(14,2) NOT NULL DEFAULT 0,
+			itc_eligible BOOLEAN NOT NULL DEFAULT TRUE,
+			UNIQUE(purchase_invoice_id, line_no)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create purchase_invoice_items table: %v", err)
+	}
+}
+
+// purchaseColumns are the columns scanned by scanPurchase
+const purchaseColumns = `id, user_id, COALESCE(supplier_id, 0), supplier_gstin, supplier_name, recipient_gstin,
+	bill_no, bill_date, place_of_supply, reverse_charge, taxable_value::float8, igst::float8, cgst::float8,
+	sgst::float8, cess::float8, total_value::float8, itc_available::float8, notes, created_at, updated_at`
+
+// scanPurchase reads a purchase bill selected with purchaseColumns, without its items
+func scanPurchase(row pgx.Row) (*models.PurchaseInvoice, error) {
+	var p models.PurchaseInvoice
+	var billDate time.Time
+	err := row.Scan(&p.ID, &p.UserID, &p.SupplierID, &p.SupplierGSTIN, &p.SupplierName, &p.RecipientGSTIN,
+		&p.BillNo, &billDate, &p.PlaceOfSupply, &p.ReverseCharge, &p.TaxableValue, &p.IGST, &p.CGST,
+		&p.SGST, &p.Cess, &p.TotalValue, &p.ITCAvailable, &p.Notes, &p.CreatedAt, &p.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	p.BillDate = billDate.Format(invoiceDateLayout)
+	return &p, nil
+}
+
+// loadPurchaseItems reads the line items of a purchase bill in order
+func loadPurchaseItems(ctx context.Context, purchaseID int) ([]models.PurchaseItem, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, description, hsn_code, qty::float8, unit, unit_price::float8, taxable_value::float8,
+			gst_rate::float8, igst::float8, cgst::float8, sgst::float8, cess::float8, itc_eligible
+		FROM purchase_invoice_items WHERE purchase_invoice_id = $1
+		ORDER BY line_no
+	`, purchaseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]models.PurchaseItem, 0)
+	for rows.Next() {
+		var item models.PurchaseItem
+		err := rows.Scan(&item.ID, &item.Description, &item.HsnCd, &item.Qty, &item.Unit, &item.UnitPrice,
+			&item.TaxableValue, &item.GstRt, &item.IGST, &item.CGST, &item.SGST, &item.Cess, &item.ITCEligible)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// bindPurchaseInvoice parses a purchase bill from the request body. The
+// supplier is taken from the suppliers master; the recipient GSTIN defaults
+// to the default company's, and the place of supply to its state. Totals
+// and the tax split are calculated here rather than trusted from the body.
+func bindPurchaseInvoice(c *gin.Context, userID int) (*models.PurchaseInvoice, bool) {
+	ctx := c.Request.Context()
+
+	var p models.PurchaseInvoice
+	if err := c.ShouldBindJSON(&p); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase bill data: " + err.Error()})
+		return nil, false
+	}
+	if p.SupplierID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "supplier_id is required"})
+		return nil, false
+	}
+
+	err := dbPool.QueryRow(ctx,
+		"SELECT name, COALESCE(gstin, '') FROM suppliers WHERE id = $1 AND user_id = $2",
+		p.SupplierID, userID).Scan(&p.SupplierName, &p.SupplierGSTIN)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Supplier %d not found", p.SupplierID)})
+		return nil, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load supplier"})
+		return nil, false
+	}
+	p.SupplierGSTIN = strings.ToUpper(p.SupplierGSTIN)
+
+	if p.RecipientGSTIN == "" || p.PlaceOfSupply == "" {
+		if co := getDefaultCompany(ctx, userID, getUserSettings(ctx, userID)); co != nil {
+			if p.RecipientGSTIN == "" {
+				p.RecipientGSTIN = co.GSTIN
+			}
+			if p.PlaceOfSupply == "" {
+				p.PlaceOfSupply = sellerFromCompany(co).Stcd
+			}
+		}
+	}
+	p.RecipientGSTIN = strings.ToUpper(p.RecipientGSTIN)
+
+	if err := p.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	p.CalculateTotals()
+	return &p, true
+}
+
+// savePurchaseItems replaces the line items of a purchase bill
+func savePurchaseItems(ctx context.Context, tx pgx.Tx, purchaseID int, items []models.PurchaseItem) error {
+	if _, err := tx.Exec(ctx, "DELETE FROM purchase_invoice_items WHERE purchase_invoice_id = $1", purchaseID); err != nil {
+		return err
+	}
+	for i, item := range items {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO purchase_invoice_items (purchase_invoice_id, line_no, description, hsn_code, qty, unit,
+				unit_price, taxable_value, gst_rate, igst, cgst, sgst, cess, itc_eligible)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		`, purchaseID, i+1, item.Description, item.HsnCd, item.Qty, item.Unit,
+			item.UnitPrice, item.TaxableValue, item.GstRt, item.IGST, item.CGST, item.SGST, item.Cess, item.ITCEligible)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// handleGetPurchaseInvoices lists the user's purchase bills, newest first,
+// without their items. The from and to filters apply to the bill date, and
+// supplier_id narrows the list to one supplier.
+func handleGetPurchaseInvoices(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	supplierID, _ := strconv.Atoi(c.Query("supplier_id"))
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT "+purchaseColumns+` FROM purchase_invoices
+		WHERE user_id = $1 AND ($2 = 0 OR supplier_id = $2)
+			AND ($3::date IS NULL OR bill_date >= $3) AND ($4::date IS NULL OR bill_date <= $4)
+			AND ($5 = '' OR recipient_gstin = $5)
+		ORDER BY bill_date DESC, id DESC`,
+		userID, supplierID, filter.from, filter.to, filter.sellerGSTIN)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase bills"})
+		return
+	}
+	defer rows.Close()
+
+	purchases := make([]*models.PurchaseInvoice, 0)
+	for rows.Next() {
+		p, err := scanPurchase(rows)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read purchase bill data"})
+			return
+		}
+		purchases = append(purchases, p)
+	}
+	if rowsFailed(c, rows, "Failed to fetch purchase bills") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"purchase_invoices": purchases})
+}
+
+// handleGetPurchaseInvoice returns a purchase bill with its items
+func handleGetPurchaseInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase bill ID"})
+		return
+	}
+
+	p, err := scanPurchase(dbPool.QueryRow(ctx,
+		"SELECT "+purchaseColumns+" FROM purchase_invoices WHERE id = $1 AND user_id = $2",
+		id, userID))
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase bill not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase bill"})
+		return
+	}
+	if p.Items, err = loadPurchaseItems(ctx, id); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch purchase bill items"})
+		return
+	}
+
+	c.JSON(http.StatusOK, p)
+}
+
+// handleCreatePurchaseInvoice records a purchase bill with its items
+func handleCreatePurchaseInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	p, ok := bindPurchaseInvoice(c, userID)
+	if !ok {
+		return
+	}
+	billDate, _ := time.Parse(invoiceDateLayout, p.BillDate)
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var id int
+	err = tx.QueryRow(ctx, `
+		INSERT INTO purchase_invoices (user_id, supplier_id, supplier_gstin, supplier_name, recipient_gstin,
+			bill_no, bill_date, place_of_supply, reverse_charge, taxable_value, igst, cgst, sgst, cess,
+			total_value, itc_available, notes)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17)
+		RETURNING id
+	`, userID, p.SupplierID, p.SupplierGSTIN, p.SupplierName, p.RecipientGSTIN,
+		p.BillNo, billDate, p.PlaceOfSupply, p.ReverseCharge, p.TaxableValue, p.IGST, p.CGST, p.SGST, p.Cess,
+		p.TotalValue, p.ITCAvailable, p.Notes).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Bill %s from this supplier is already recorded", p.BillNo)})
+		return
+	}
+	if err != nil {
+		log.Printf("Error storing purchase bill: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store purchase bill"})
+		return
+	}
+	if err := savePurchaseItems(ctx, tx, id, p.Items); err != nil {
+		log.Printf("Error storing purchase bill items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store purchase bill"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store purchase bill"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":             "Purchase bill recorded successfully",
+		"purchase_invoice_id": id,
+		"total_value":         p.TotalValue,
+		"itc_available":       p.ITCAvailable,
+	})
+}
+
+// handleUpdatePurchaseInvoice replaces a purchase bill and its items
+func handleUpdatePurchaseInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase bill ID"})
+		return
+	}
+
+	p, ok := bindPurchaseInvoice(c, userID)
+	if !ok {
+		return
+	}
+	billDate, _ := time.Parse(invoiceDateLayout, p.BillDate)
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	result, err := tx.Exec(ctx, `
+		UPDATE purchase_invoices
+		SET supplier_id = $1, supplier_gstin = $2, supplier_name = $3, recipient_gstin = $4, bill_no = $5,
+			bill_date = $6, place_of_supply = $7, reverse_charge = $8, taxable_value = $9, igst = $10,
+			cgst = $11, sgst = $12, cess = $13, total_value = $14, itc_available = $15, notes = $16,
+			updated_at = NOW()
+		WHERE id = $17 AND user_id = $18
+	`, p.SupplierID, p.SupplierGSTIN, p.SupplierName, p.RecipientGSTIN, p.BillNo,
+		billDate, p.PlaceOfSupply, p.ReverseCharge, p.TaxableValue, p.IGST,
+		p.CGST, p.SGST, p.Cess, p.TotalValue, p.ITCAvailable, p.Notes, id, userID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Bill %s from this supplier is already recorded", p.BillNo)})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase bill"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase bill not found or not authorized"})
+		return
+	}
+	if err := savePurchaseItems(ctx, tx, id, p.Items); err != nil {
+		log.Printf("Error storing purchase bill items: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase bill"})
+		return
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update purchase bill"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Purchase bill updated successfully",
+		"purchase_invoice_id": id,
+		"total_value":         p.TotalValue,
+		"itc_available":       p.ITCAvailable,
+	})
+}
+
+// handleDeletePurchaseInvoice deletes a purchase bill and its items
+func handleDeletePurchaseInvoice(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid purchase bill ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM purchase_invoices WHERE id = $1 AND user_id = $2",
+		id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete purchase bill"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Purchase bill not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":             "Purchase bill deleted successfully",
+		"purchase_invoice_id": id,
+	})
+}
+
+// itcMonth compares the tax on a month's sales with the input tax credit
+// of its purchases
+type itcMonth struct {
+	Month string `json:"month"`
+	// Output is the tax on finalized sales invoices, net of credit notes
+	Output taxHeads `json:"output_tax"`
+	// ITC is the eligible input tax credit on purchase bills
+	ITC taxHeads `json:"itc"`
+	// ReverseCharge is the tax on purchases payable by the user under reverse charge
+	ReverseCharge float64 `json:"reverse_charge_tax"`
+	// NetPayable is output and reverse charge tax less ITC; negative
+	// amounts are credit carried forward
+	NetPayable float64 `json:"net_payable"`
+	Bills      int     `json:"purchase_bills"`
+}
+
+// handleITCSummaryReport sets the input tax credit of purchase bills against
+// the output tax of sales invoices by month. The from and to filters apply to
+// invoice and bill dates; seller_gstin narrows sales to that seller and
+// purchases to bills received by it.
+func handleITCSummaryReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	byMonth := make(map[string]*itcMonth)
+	month := func(key string) *itcMonth {
+		m, ok := byMonth[key]
+		if !ok {
+			m = &itcMonth{Month: key}
+			byMonth[key] = m
+		}
+		return m
+	}
+
+	totals, err := queryTaxMonths(ctx, userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build ITC summary"})
+		return
+	}
+	// The recipients pay the tax of reverse charge supplies
+	for _, t := range totals {
+		if !t.reverseCharge {
+			month(t.month).Output.add(t.taxHeads, 1)
+		}
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT TO_CHAR(p.bill_date, 'YYYY-MM'), p.reverse_charge,
+			COALESCE(SUM(i.taxable_value) FILTER (WHERE i.itc_eligible), 0)::float8,
+			COALESCE(SUM(i.igst) FILTER (WHERE i.itc_eligible), 0)::float8,
+			COALESCE(SUM(i.cgst) FILTER (WHERE i.itc_eligible), 0)::float8,
+			COALESCE(SUM(i.sgst) FILTER (WHERE i.itc_eligible), 0)::float8,
+			COALESCE(SUM(i.cess) FILTER (WHERE i.itc_eligible), 0)::float8,
+			COALESCE(SUM(i.igst + i.cgst + i.sgst + i.cess), 0)::float8,
+			COUNT(DISTINCT p.id)
+		FROM purchase_invoices p
+		JOIN purchase_invoice_items i ON i.purchase_invoice_id = p.id
+		WHERE p.user_id = $1 AND ($2::date IS NULL OR p.bill_date >= $2) AND ($3::date IS NULL OR p.bill_date <= $3)
+			AND ($4 = '' OR p.recipient_gstin = $4)
+		GROUP BY 1, 2
+	`, userID, filter.from, filter.to, filter.sellerGSTIN)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build ITC summary"})
+		return
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		var reverseCharge bool
+		var itc taxHeads
+		var tax float64
+		var bills int
+		if err := rows.Scan(&key, &reverseCharge, &itc.TaxableValue, &itc.IGST, &itc.CGST, &itc.SGST, &itc.Cess, &tax, &bills); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read purchase bill data"})
+			return
+		}
+		itc.TotalTax = models.Round(itc.IGST+itc.CGST+itc.SGST+itc.Cess, models.AmountDecimals)
+		m := month(key)
+		m.ITC.add(itc, 1)
+		m.Bills += bills
+		if reverseCharge {
+			m.ReverseCharge = models.Round(m.ReverseCharge+tax, models.AmountDecimals)
+		}
+	}
+	if rowsFailed(c, rows, "Failed to build ITC summary") {
+		return
+	}
+
+	months := make([]*itcMonth, 0, len(byMonth))
+	total := &itcMonth{Month: "total"}
+	for _, m := range byMonth {
+		m.NetPayable = models.Round(m.Output.TotalTax+m.ReverseCharge-m.ITC.TotalTax, models.AmountDecimals)
+		total.Output.add(m.Output, 1)
+		total.ITC.add(m.ITC, 1)
+		total.ReverseCharge = models.Round(total.ReverseCharge+m.ReverseCharge, models.AmountDecimals)
+		total.Bills += m.Bills
+		months = append(months, m)
+	}
+	total.NetPayable = models.Round(total.Output.TotalTax+total.ReverseCharge-total.ITC.TotalTax, models.AmountDecimals)
+	sort.Slice(months, func(i, j int) bool { return months[i].Month < months[j].Month })
+
+	c.JSON(http.StatusOK, gin.H{
+		"months": months,
+		"total":  total,
+	})
+}
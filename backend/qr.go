@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/skip2/go-qrcode"
+)
+
+// qrHashLength is the number of hex characters of the QR content hash used in URLs and ETags
+const qrHashLength = 16
+
+// qrHashSQL computes the same hash as invoiceQRHash inside a query, from the
+// invoice number and total kept in columns
+const qrHashSQL = "substr(encode(sha256(convert_to(invoice_no || ':' || to_char(total_value, 'FM9999999999990.00'), 'UTF8')), 'hex'), 1, 16)"
+
+// qrCacheSize is the number of generated QR code PNGs kept in memory
+const qrCacheSize = 1024
+
+// invoiceQRContent returns what an invoice's QR code encodes: its number and total
+func invoiceQRContent(invoiceNo string, total float64) string {
+	return fmt.Sprintf("%s:%.2f", invoiceNo, models.Round(total, models.AmountDecimals))
+}
+
+// qrHash returns a short hash of the content of a QR code
+func qrHash(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])[:qrHashLength]
+}
+
+// invoiceQRHash returns the hash versioning an invoice's QR code URL
+func invoiceQRHash(invoice *models.EInvoice) string {
+	return qrHash(invoiceQRContent(invoice.DocDtls.No, invoice.ValDtls.TotInvVal))
+}
+
+// qrURL returns the URL of an invoice's QR code, versioned by its content hash
+// so clients can cache it indefinitely
+func qrURL(id int, hash string) string {
+	if hash == "" {
+		return fmt.Sprintf("/api/v1/qr/%d", id)
+	}
+	return fmt.Sprintf("/api/v1/qr/%d?v=%s", id, hash)
+}
+
+// qrObjectKey returns the object storage key of the QR code with the given
+// content. Keys are derived from the content, so uploading it again is harmless.
+func qrObjectKey(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return "qr/" + hex.EncodeToString(sum[:]) + ".png"
+}
+
+// encodeQR renders content as a QR code PNG
+func encodeQR(content string) ([]byte, error) {
+	return qrcode.Encode(content, qrcode.Medium, 256)
+}
+
+// qrPNGCache keeps recently generated QR code PNGs by content, dropping the
+// oldest once full
+type qrPNGCache struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	order   []string
+}
+
+// qrCache holds the invoice QR codes served by the API
+var qrCache = &qrPNGCache{entries: make(map[string][]byte)}
+
+// get returns the PNG of content, generating it unless it is cached
+func (c *qrPNGCache) get(content string) ([]byte, error) {
+	c.mu.Lock()
+	png, ok := c.entries[content]
+	c.mu.Unlock()
+	if ok {
+		return png, nil
+	}
+
+	png, err := encodeQR(content)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[content]; !ok {
+		if len(c.order) >= qrCacheSize {
+			delete(c.entries, c.order[0])
+			c.order = c.order[1:]
+		}
+		c.entries[content] = png
+		c.order = append(c.order, content)
+	}
+	return png, nil
+}
+
+// generateInvoiceQR creates the QR code PNG for an invoice (invoice_no + TotInvVal)
+func generateInvoiceQR(invoice *models.EInvoice) ([]byte, error) {
+	return qrCache.get(invoiceQRContent(invoice.DocDtls.No, invoice.ValDtls.TotInvVal))
+}
+
+// handleGetQRCodeURL returns a URL the invoice's QR code can be downloaded
+// from without credentials, uploading the generated PNG to object storage.
+// Without object storage the authenticated API URL is returned with no expiry.
+func handleGetQRCodeURL(c *gin.Context) {
+	userID := c.GetInt("userID")
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var invoiceNo string
+	var total float64
+	err = dbPool.QueryRow(c.Request.Context(),
+		"SELECT invoice_no, total_value FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceNo, &total)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "QR code not found"})
+		return
+	}
+	content := invoiceQRContent(invoiceNo, total)
+	if objectStore == nil {
+		c.JSON(http.StatusOK, gin.H{"url": qrURL(id, qrHash(content)), "signed": false, "expires_at": nil})
+		return
+	}
+
+	png, err := qrCache.get(content)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+	key := qrObjectKey(content)
+	if err := objectStore.put(c.Request.Context(), key, "image/png", png); err != nil {
+		log.Printf("Error uploading QR code of invoice %d: %v", id, err)
+		c.JSON(http.StatusBadGateway, gin.H{"error": "Failed to store QR code"})
+		return
+	}
+	url, expiresAt := objectStore.signedURL(key, time.Now())
+	c.JSON(http.StatusOK, gin.H{"url": url, "signed": true, "expires_at": expiresAt})
+}
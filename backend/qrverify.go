@@ -0,0 +1,162 @@
+package main
+
+import (
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// nicQRKey is a public key the IRP signs QR codes with. NIC names its keys in
+// the JWT header by certificate thumbprint, as a hex kid or a base64url x5t.
+type nicQRKey struct {
+	kid    string
+	x5t    string
+	public *rsa.PublicKey
+}
+
+// nicQRKeys are loaded at startup by loadNICQRKeys
+var nicQRKeys []*nicQRKey
+
+// parseNICQRKeys reads the RSA certificates and public keys in PEM data
+func parseNICQRKeys(data []byte) ([]*nicQRKey, error) {
+	keys := make([]*nicQRKey, 0)
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		key := &nicQRKey{}
+		var parsed interface{}
+		switch block.Type {
+		case "CERTIFICATE":
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			thumbprint := sha1.Sum(block.Bytes)
+			key.kid = strings.ToUpper(hex.EncodeToString(thumbprint[:]))
+			key.x5t = base64.RawURLEncoding.EncodeToString(thumbprint[:])
+			parsed = cert.PublicKey
+		case "PUBLIC KEY":
+			var err error
+			if parsed, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+		}
+		public, ok := parsed.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("unsupported key type %T; NIC signs with RSA", parsed)
+		}
+		key.public = public
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// loadNICQRKeys reads the NIC QR signing certificates from NIC_QR_CERT_FILES,
+// a comma-separated list of PEM files as published on the e-invoice portal.
+// Without them signed QR codes are decoded but cannot be verified.
+func loadNICQRKeys() {
+	for _, path := range strings.Split(os.Getenv("NIC_QR_CERT_FILES"), ",") {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read NIC QR certificate %s: %v", path, err)
+		}
+		keys, err := parseNICQRKeys(data)
+		if err != nil {
+			log.Fatalf("Failed to parse NIC QR certificate %s: %v", path, err)
+		}
+		nicQRKeys = append(nicQRKeys, keys...)
+	}
+	if len(nicQRKeys) == 0 {
+		log.Println("Warning: NIC_QR_CERT_FILES not set; signed QR codes cannot be verified")
+	}
+}
+
+// nicQRCandidates returns the keys that may have signed a token: the one its
+// header names, or every key when the header names none that is known
+func nicQRCandidates(header map[string]interface{}) []*nicQRKey {
+	kid, _ := header["kid"].(string)
+	x5t, _ := header["x5t"].(string)
+	for _, key := range nicQRKeys {
+		if (kid != "" && strings.EqualFold(kid, key.kid)) || (x5t != "" && x5t == key.x5t) {
+			return []*nicQRKey{key}
+		}
+	}
+	return nicQRKeys
+}
+
+// verifyNICQR checks the signature of a signed QR code against the NIC keys
+func verifyNICQR(token string) error {
+	if len(nicQRKeys) == 0 {
+		return errors.New("NIC public keys are not configured")
+	}
+	parser := jwt.NewParser(jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg()}))
+	unverified, _, err := parser.ParseUnverified(token, jwt.MapClaims{})
+	if err != nil {
+		return err
+	}
+	for _, key := range nicQRCandidates(unverified.Header) {
+		_, err = parser.Parse(token, func(*jwt.Token) (interface{}, error) { return key.public, nil })
+		if err == nil {
+			return nil
+		}
+	}
+	return errors.New("signature does not match any NIC public key")
+}
+
+// handleVerifyQR verifies a scanned e-invoice QR code, given as the signed
+// JWT either in a JSON body as {"qr": "..."} or as the plain request body,
+// and returns the invoice summary it carries. The summary is returned even
+// when the signature cannot be verified, with valid set to false.
+func handleVerifyQR(c *gin.Context) {
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+		return
+	}
+	token := strings.TrimSpace(string(body))
+	var req struct {
+		QR string `json:"qr"`
+	}
+	if json.Unmarshal(body, &req) == nil {
+		token = strings.TrimSpace(req.QR)
+	}
+	if token == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "QR code payload is required"})
+		return
+	}
+
+	summary, err := decodeSignedQR(token)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Not a signed e-invoice QR code: " + err.Error()})
+		return
+	}
+
+	result := gin.H{"valid": true, "invoice": summary}
+	if err := verifyNICQR(token); err != nil {
+		result["valid"] = false
+		result["reason"] = err.Error()
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// queryTimeoutKey holds the time limit of each query run with a context
+type queryTimeoutKey struct{}
+
+// queryCancelKey holds the function releasing the timer of a running query
+type queryCancelKey struct{}
+
+// Query time limits, read from the environment at startup
+var (
+	queryTimeout       time.Duration
+	exportQueryTimeout time.Duration
+)
+
+// getTimeoutSeconds reads a duration in seconds from the environment
+func getTimeoutSeconds(key, defaultValue string) time.Duration {
+	seconds, err := strconv.Atoi(getEnvWithDefault(key, defaultValue))
+	if err != nil || seconds < 0 {
+		log.Printf("Invalid %s value, defaulting to %s", key, defaultValue)
+		seconds, _ = strconv.Atoi(defaultValue)
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// loadQueryTimeouts reads DB_QUERY_TIMEOUT and DB_EXPORT_QUERY_TIMEOUT; 0 disables a limit
+func loadQueryTimeouts() {
+	queryTimeout = getTimeoutSeconds("DB_QUERY_TIMEOUT", "15")
+	exportQueryTimeout = getTimeoutSeconds("DB_EXPORT_QUERY_TIMEOUT", "120")
+}
+
+// withQueryTimeout limits every query run with the returned context to d,
+// counted from when the query starts until its rows are closed. Contexts
+// without a limit, such as those used for migrations at startup, run queries
+// until they finish.
+func withQueryTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, queryTimeoutKey{}, d)
+}
+
+// exportContext returns the request context with the longer time limit of
+// exports, whose queries read every matching invoice
+func exportContext(c *gin.Context) context.Context {
+	return withQueryTimeout(c.Request.Context(), exportQueryTimeout)
+}
+
+// queryTimeoutTracer applies the time limit set by withQueryTimeout to each query
+type queryTimeoutTracer struct{}
+
+func (queryTimeoutTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	d, _ := ctx.Value(queryTimeoutKey{}).(time.Duration)
+	if d <= 0 {
+		return ctx
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	return context.WithValue(ctx, queryCancelKey{}, cancel)
+}
+
+func (queryTimeoutTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	if cancel, ok := ctx.Value(queryCancelKey{}).(context.CancelFunc); ok {
+		cancel()
+	}
+}
+
+// queryTimeouts limits each query of a request to DB_QUERY_TIMEOUT. Queries
+// are also cancelled when the client disconnects, as they run with the
+// request context.
+func queryTimeouts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Request = c.Request.WithContext(withQueryTimeout(c.Request.Context(), queryTimeout))
+		c.Next()
+	}
+}
+
+// rowsFailed reports whether reading rows stopped on an error, such as a
+// timeout or the client disconnecting, responding with message unless the
+// client is gone. Long exports call it to stop instead of building a file
+// nobody will receive.
+func rowsFailed(c *gin.Context, rows pgx.Rows, message string) bool {
+	err := rows.Err()
+	if err == nil {
+		return false
+	}
+	if c.Request.Context().Err() == nil {
+		log.Printf("Error reading rows: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": message})
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rateWindow is the length of the fixed window requests are counted in
+const rateWindow = time.Minute
+
+// rateCounter counts one client's requests in the current window
+type rateCounter struct {
+	start time.Time
+	count int
+}
+
+// rateLimiter allows each client IP a fixed number of requests per window
+type rateLimiter struct {
+	limit    int
+	mu       sync.Mutex
+	counters map[string]*rateCounter
+}
+
+// newRateLimiter creates a limiter and starts dropping counters of idle clients
+func newRateLimiter(limit int) *rateLimiter {
+	l := &rateLimiter{limit: limit, counters: make(map[string]*rateCounter)}
+	go func() {
+		ticker := time.NewTicker(10 * rateWindow)
+		defer ticker.Stop()
+		for range ticker.C {
+			l.sweep()
+		}
+	}()
+	return l
+}
+
+// allow counts a request from the client, returning whether it is within the
+// limit, how many requests remain and when the window resets
+func (l *rateLimiter) allow(client string) (bool, int, time.Time) {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	counter, ok := l.counters[client]
+	if !ok || now.Sub(counter.start) >= rateWindow {
+		counter = &rateCounter{start: now}
+		l.counters[client] = counter
+	}
+	counter.count++
+
+	reset := counter.start.Add(rateWindow)
+	if counter.count > l.limit {
+		return false, 0, reset
+	}
+	return true, l.limit - counter.count, reset
+}
+
+// sweep drops the counters of clients whose window has ended
+func (l *rateLimiter) sweep() {
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, counter := range l.counters {
+		if now.Sub(counter.start) >= rateWindow {
+			delete(l.counters, client)
+		}
+	}
+}
+
+// getPublicRateLimit returns the requests per minute allowed per IP on public endpoints
+func getPublicRateLimit() int {
+	limit, err := strconv.Atoi(getEnvWithDefault("PUBLIC_RATE_LIMIT_PER_MINUTE", "60"))
+	if err != nil || limit < 1 {
+		log.Printf("Invalid PUBLIC_RATE_LIMIT_PER_MINUTE value, defaulting to 60")
+		limit = 60
+	}
+	return limit
+}
+
+// rateLimitMiddleware throttles requests per client IP, answering 429 with
+// Retry-After once a client exceeds the limit
+func rateLimitMiddleware(limit int) gin.HandlerFunc {
+	limiter := newRateLimiter(limit)
+	return func(c *gin.Context) {
+		ok, remaining, reset := limiter.allow(c.ClientIP())
+
+		c.Header("X-RateLimit-Limit", strconv.Itoa(limit))
+		c.Header("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		c.Header("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+		if !ok {
+			retryAfter := int(time.Until(reset).Seconds()) + 1
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many requests, please try again later"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,480 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// taxMonthLayout is the YYYY-MM format months are reported and linked in
+const taxMonthLayout = "2006-01"
+
+// taxHeads holds invoice values by tax head
+type taxHeads struct {
+	TaxableValue float64 `json:"taxable_value"`
+	IGST         float64 `json:"igst"`
+	CGST         float64 `json:"cgst"`
+	SGST         float64 `json:"sgst"`
+	Cess         float64 `json:"cess"`
+	TotalTax     float64 `json:"total_tax"`
+}
+
+// add accumulates another set of values, subtracting them when sign is negative
+func (t *taxHeads) add(o taxHeads, sign float64) {
+	t.TaxableValue = models.Round(t.TaxableValue+sign*o.TaxableValue, models.AmountDecimals)
+	t.IGST = models.Round(t.IGST+sign*o.IGST, models.AmountDecimals)
+	t.CGST = models.Round(t.CGST+sign*o.CGST, models.AmountDecimals)
+	t.SGST = models.Round(t.SGST+sign*o.SGST, models.AmountDecimals)
+	t.Cess = models.Round(t.Cess+sign*o.Cess, models.AmountDecimals)
+	t.TotalTax = models.Round(t.TotalTax+sign*o.TotalTax, models.AmountDecimals)
+}
+
+// invoiceTaxHeads returns an invoice's tax by head as stored on it.
+// Nil-rated, exempt and non-GST items are left out of the taxable value.
+// Cess is not captured on invoices yet and is always zero.
+func invoiceTaxHeads(invoice *models.EInvoice) taxHeads {
+	heads := taxHeads{TaxableValue: invoice.TaxableValue(), TotalTax: invoice.TotalTax()}
+	heads.IGST, heads.CGST, heads.SGST = invoice.TaxVals()
+	return heads
+}
+
+// taxInvoicesSQL selects the finalized invoices counted in tax reports; it
+// expects the user ID as $1, whether to include sandbox invoices as $2 and
+// the arguments returned by exportFilter.args as $3 to $8
+const taxInvoicesSQL = `user_id = $1 AND NOT quarantined AND status = 'finalized' AND (NOT sandbox OR $2) AND ` +
+	exportFilterSQL
+
+// taxSignSQL is -1 for credit notes, which reduce the tax, and 1 otherwise
+const taxSignSQL = "(CASE WHEN doc_type = 'CRN' THEN -1 ELSE 1 END)"
+
+// taxHeadsSQL selects the taxable value, IGST, CGST, SGST and total tax of
+// invoices, negative for credit notes, summed over each group when sum is
+// set. They are scanned into taxHeads.dest.
+func taxHeadsSQL(sum bool) string {
+	columns := []string{"taxable_value", "igst_amount", "cgst_amount", "sgst_amount", "tax_amount"}
+	for i, column := range columns {
+		value := taxSignSQL + " * " + column
+		if sum {
+			value = "COALESCE(SUM(" + value + "), 0)"
+		}
+		columns[i] = "(" + value + ")::float8"
+	}
+	return strings.Join(columns, ", ")
+}
+
+// dest returns the scan destinations of the columns of taxHeadsSQL
+func (t *taxHeads) dest() []interface{} {
+	return []interface{}{&t.TaxableValue, &t.IGST, &t.CGST, &t.SGST, &t.TotalTax}
+}
+
+// taxCountsSQL counts the invoices and the credit notes of each group
+const taxCountsSQL = "COUNT(*) FILTER (WHERE doc_type <> 'CRN'), COUNT(*) FILTER (WHERE doc_type = 'CRN')"
+
+// taxInvoice is an invoice counted in the tax summary
+type taxInvoice struct {
+	ID          int    `json:"id"`
+	InvoiceNo   string `json:"invoice_no"`
+	Type        string `json:"type"`
+	Date        string `json:"date"`
+	SellerGSTIN string `json:"seller_gstin"`
+	BuyerGSTIN  string `json:"buyer_gstin"`
+	BuyerName   string `json:"buyer_name"`
+	Sandbox     bool   `json:"sandbox"`
+	// ReverseCharge marks supplies whose tax the recipient pays
+	ReverseCharge bool   `json:"reverse_charge"`
+	Link          string `json:"link"`
+	taxHeads
+}
+
+// queryTaxInvoices returns the finalized invoices of the filter's period with
+// their tax heads; credit notes carry negative values
+func queryTaxInvoices(ctx context.Context, userID int, filter *exportFilter, withSandbox bool) ([]*taxInvoice, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT id, invoice_no, doc_type, invoice_date, seller_gstin, buyer_gstin,
+			COALESCE(invoice_json->'BuyerDtls'->>'LglNm', ''), sandbox, reverse_charge, `+taxHeadsSQL(false)+`
+		FROM invoices
+		WHERE `+taxInvoicesSQL+`
+		ORDER BY id`,
+		append([]interface{}{userID, withSandbox}, filter.args()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invoices := make([]*taxInvoice, 0)
+	for rows.Next() {
+		inv := &taxInvoice{}
+		var date time.Time
+		dest := append([]interface{}{
+			&inv.ID, &inv.InvoiceNo, &inv.Type, &date, &inv.SellerGSTIN, &inv.BuyerGSTIN,
+			&inv.BuyerName, &inv.Sandbox, &inv.ReverseCharge,
+		}, inv.taxHeads.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		inv.Date = date.Format(invoiceDateLayout)
+		invoices = append(invoices, inv)
+	}
+	return invoices, rows.Err()
+}
+
+// taxMonthTotal is the tax of a month's finalized invoices, apart for
+// reverse charge supplies
+type taxMonthTotal struct {
+	month         string
+	reverseCharge bool
+	invoices      int
+	creditNotes   int
+	taxHeads
+}
+
+// queryTaxMonths returns the tax of the filter's period by month, net of
+// credit notes, with reverse charge supplies totalled apart
+func queryTaxMonths(ctx context.Context, userID int, filter *exportFilter, withSandbox bool) ([]taxMonthTotal, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT TO_CHAR(invoice_date, 'YYYY-MM'), reverse_charge, `+taxCountsSQL+`, `+taxHeadsSQL(true)+`
+		FROM invoices
+		WHERE `+taxInvoicesSQL+`
+		GROUP BY 1, 2
+		ORDER BY 1, 2`,
+		append([]interface{}{userID, withSandbox}, filter.args()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	totals := make([]taxMonthTotal, 0)
+	for rows.Next() {
+		var t taxMonthTotal
+		dest := append([]interface{}{&t.month, &t.reverseCharge, &t.invoices, &t.creditNotes}, t.taxHeads.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		totals = append(totals, t)
+	}
+	return totals, rows.Err()
+}
+
+// taxMonth is one month of the tax summary
+type taxMonth struct {
+	Month       string `json:"month"`
+	Invoices    int    `json:"invoices"`
+	CreditNotes int    `json:"credit_notes"`
+	taxHeads
+	// ReverseCharge holds the supplies whose tax the recipients pay, left out
+	// of the seller's liability; the fiscal year summary leaves it empty
+	ReverseCharge *taxHeads `json:"reverse_charge,omitempty"`
+	Links         gin.H     `json:"links"`
+}
+
+// taxMonthLinks returns the drill-down links of a month of the tax summary,
+// keeping the seller the summary was narrowed to
+func taxMonthLinks(c *gin.Context, month, sellerGSTIN string) gin.H {
+	start, _ := time.Parse(taxMonthLayout, month)
+	end := start.AddDate(0, 1, -1)
+	invoicesLink := apiLink(c, "/reports/tax-summary/"+month)
+	exportLink := apiLink(c, fmt.Sprintf("/export-invoices?from=%s&to=%s",
+		start.Format(exportFilterLayout), end.Format(exportFilterLayout)))
+	if sellerGSTIN != "" {
+		seller := "seller_gstin=" + url.QueryEscape(sellerGSTIN)
+		invoicesLink += "?" + seller
+		exportLink += "&" + seller
+	}
+	return gin.H{"invoices": invoicesLink, "export": exportLink}
+}
+
+// handleTaxSummaryReport returns the GST liability of finalized invoices by
+// month and tax head, net of credit notes, for reconciliation against GSTR-3B.
+// Reverse charge supplies are reported apart, as the recipients' liability.
+func handleTaxSummaryReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	totals, err := queryTaxMonths(ctx, userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build tax summary"})
+		return
+	}
+
+	byMonth := make(map[string]*taxMonth)
+	var total, reverseCharge taxHeads
+	for _, t := range totals {
+		m, ok := byMonth[t.month]
+		if !ok {
+			m = &taxMonth{Month: t.month, ReverseCharge: &taxHeads{}}
+			byMonth[t.month] = m
+		}
+		m.Invoices += t.invoices
+		m.CreditNotes += t.creditNotes
+		if t.reverseCharge {
+			m.ReverseCharge.add(t.taxHeads, 1)
+			reverseCharge.add(t.taxHeads, 1)
+			continue
+		}
+		m.add(t.taxHeads, 1)
+		total.add(t.taxHeads, 1)
+	}
+
+	months := make([]*taxMonth, 0, len(byMonth))
+	for _, m := range byMonth {
+		m.Links = taxMonthLinks(c, m.Month, filter.sellerGSTIN)
+		months = append(months, m)
+	}
+	sort.Slice(months, func(i, j int) bool { return months[i].Month < months[j].Month })
+
+	c.JSON(http.StatusOK, gin.H{
+		"months":         months,
+		"total":          total,
+		"reverse_charge": reverseCharge,
+	})
+}
+
+// handleTaxSummaryMonth lists the invoices counted in one month of the tax summary
+func handleTaxSummaryMonth(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	start, err := time.Parse(taxMonthLayout, c.Param("month"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "month must be in YYYY-MM format"})
+		return
+	}
+	end := start.AddDate(0, 1, -1)
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	filter.from, filter.to = &start, &end
+
+	invoices, err := queryTaxInvoices(ctx, userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoices"})
+		return
+	}
+
+	var total, reverseCharge taxHeads
+	for _, inv := range invoices {
+		inv.Link = apiLink(c, fmt.Sprintf("/invoices/%d", inv.ID))
+		if inv.ReverseCharge {
+			reverseCharge.add(inv.taxHeads, 1)
+		} else {
+			total.add(inv.taxHeads, 1)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"month":          start.Format(taxMonthLayout),
+		"invoices":       invoices,
+		"total":          total,
+		"reverse_charge": reverseCharge,
+	})
+}
+
+// handleFiscalYearSummary returns the finalized sales of an Indian financial
+// year (fy=2024-25, by default the current one) for each month from April to
+// March, net of credit notes. Months without invoices are included.
+func handleFiscalYearSummary(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	if c.Query("from") != "" || c.Query("to") != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The financial year summary takes fy, not from or to"})
+		return
+	}
+	fy := models.FiscalYearOf(time.Now().In(istLocation))
+	if value := c.Query("fy"); value != "" {
+		var err error
+		if fy, err = models.ParseFiscalYear(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	start, end := fy.Start(), fy.End()
+	filter.from, filter.to = &start, &end
+
+	totals, err := queryTaxMonths(ctx, userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build financial year summary"})
+		return
+	}
+
+	months := make([]*taxMonth, 0, 12)
+	byMonth := make(map[string]*taxMonth, 12)
+	for month := start; !month.After(end); month = month.AddDate(0, 1, 0) {
+		m := &taxMonth{Month: month.Format(taxMonthLayout)}
+		m.Links = taxMonthLinks(c, m.Month, filter.sellerGSTIN)
+		months = append(months, m)
+		byMonth[m.Month] = m
+	}
+
+	var total taxHeads
+	invoiceCount, creditNoteCount := 0, 0
+	for _, t := range totals {
+		m := byMonth[t.month]
+		m.Invoices += t.invoices
+		m.CreditNotes += t.creditNotes
+		invoiceCount += t.invoices
+		creditNoteCount += t.creditNotes
+		m.add(t.taxHeads, 1)
+		total.add(t.taxHeads, 1)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"fy":           fy.String(),
+		"from":         start.Format(exportFilterLayout),
+		"to":           end.Format(exportFilterLayout),
+		"months":       months,
+		"invoices":     invoiceCount,
+		"credit_notes": creditNoteCount,
+		"total":        total,
+	})
+}
+
+// unregisteredBuyer is the register entry for buyers without a GSTIN
+const unregisteredBuyer = "URP"
+
+// salesRegisterHeaders are the column headers of the sales register export
+var salesRegisterHeaders = []string{
+	"Buyer GSTIN", "Buyer Name", "Invoices", "Credit Notes", "Taxable Value",
+	"IGST", "CGST", "SGST", "Cess", "Total Tax",
+}
+
+// salesRegisterEntry is one buyer's sales in the register
+type salesRegisterEntry struct {
+	BuyerGSTIN  string `json:"buyer_gstin"`
+	BuyerName   string `json:"buyer_name"`
+	Invoices    int    `json:"invoices"`
+	CreditNotes int    `json:"credit_notes"`
+	taxHeads
+}
+
+// querySalesRegister returns the finalized sales of the filter's period by
+// buyer GSTIN, net of credit notes. Buyers are named after their most recent
+// invoice.
+func querySalesRegister(ctx context.Context, userID int, filter *exportFilter, withSandbox bool) ([]*salesRegisterEntry, error) {
+	rows, err := dbPool.Query(ctx,
+		`SELECT b.buyer_gstin,
+			COALESCE((SELECT invoice_json->'BuyerDtls'->>'LglNm' FROM invoices WHERE id = b.last_id), ''),
+			b.invoices, b.credit_notes, b.taxable_value, b.igst, b.cgst, b.sgst, b.total_tax
+		FROM (
+			SELECT buyer_gstin, MAX(id), `+taxCountsSQL+`, `+taxHeadsSQL(true)+`
+			FROM invoices
+			WHERE `+taxInvoicesSQL+`
+			GROUP BY buyer_gstin
+		) AS b (buyer_gstin, last_id, invoices, credit_notes, taxable_value, igst, cgst, sgst, total_tax)`,
+		append([]interface{}{userID, withSandbox}, filter.args()...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	buyers := make([]*salesRegisterEntry, 0)
+	for rows.Next() {
+		e := &salesRegisterEntry{}
+		dest := append([]interface{}{&e.BuyerGSTIN, &e.BuyerName, &e.Invoices, &e.CreditNotes}, e.taxHeads.dest()...)
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		if e.BuyerGSTIN == "" {
+			e.BuyerGSTIN, e.BuyerName = unregisteredBuyer, "Unregistered buyers"
+		}
+		buyers = append(buyers, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(buyers, func(i, j int) bool { return buyers[i].BuyerGSTIN < buyers[j].BuyerGSTIN })
+	return buyers, nil
+}
+
+// handleSalesRegisterReport returns the finalized sales of a period by buyer
+// GSTIN, net of credit notes, as JSON or with format=xlsx as an Excel file
+func handleSalesRegisterReport(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := exportContext(c)
+
+	format := strings.ToLower(c.DefaultQuery("format", "json"))
+	if format != "json" && format != "xlsx" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be json or xlsx"})
+		return
+	}
+
+	filter, err := parseExportFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	buyers, err := querySalesRegister(ctx, userID, filter, includeSandbox(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build sales register"})
+		return
+	}
+
+	totalEntry := salesRegisterEntry{BuyerGSTIN: "Total"}
+	for _, e := range buyers {
+		totalEntry.Invoices += e.Invoices
+		totalEntry.CreditNotes += e.CreditNotes
+		totalEntry.add(e.taxHeads, 1)
+	}
+
+	if format == "json" {
+		c.JSON(http.StatusOK, gin.H{
+			"buyers":       buyers,
+			"total":        totalEntry.taxHeads,
+			"invoices":     totalEntry.Invoices,
+			"credit_notes": totalEntry.CreditNotes,
+		})
+		return
+	}
+
+	f := excelize.NewFile()
+	defer f.Close()
+	rows := make([][]interface{}, 0, len(buyers)+2)
+	header := make([]interface{}, len(salesRegisterHeaders))
+	for i, h := range salesRegisterHeaders {
+		header[i] = h
+	}
+	rows = append(rows, header)
+	for _, e := range append(buyers, &totalEntry) {
+		rows = append(rows, []interface{}{
+			e.BuyerGSTIN, e.BuyerName, e.Invoices, e.CreditNotes, e.TaxableValue,
+			e.IGST, e.CGST, e.SGST, e.Cess, e.TotalTax,
+		})
+	}
+	for i, row := range rows {
+		cell, _ := excelize.CoordinatesToCellName(1, i+1)
+		f.SetSheetRow("Sheet1", cell, &row)
+	}
+
+	buf, err := f.WriteToBuffer()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate Excel file"})
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filter.filename("sales-register", "xlsx"))
+	c.Data(http.StatusOK, "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", buf.Bytes())
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// sandboxFlagSQL stamps a new invoice with the sandbox flag of the account
+// inserting it; it expects the user ID as $1
+const sandboxFlagSQL = "(SELECT sandbox FROM users WHERE id = $1)"
+
+// createSandboxColumns adds the sandbox flag to accounts and invoices
+func createSandboxColumns() {
+	_, err := dbPool.Exec(context.Background(),
+		"ALTER TABLE users ADD COLUMN IF NOT EXISTS sandbox BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		log.Fatalf("Failed to add sandbox column to users table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE invoices ADD COLUMN IF NOT EXISTS sandbox BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		log.Fatalf("Failed to add sandbox column to invoices table: %v", err)
+	}
+}
+
+// includeSandbox reports whether a report or export should include sandbox
+// invoices, which are left out unless ?include_sandbox=true is given
+func includeSandbox(c *gin.Context) bool {
+	return c.Query("include_sandbox") == "true"
+}
+
+// handleGetSandbox returns whether sandbox mode is on and how much test data exists
+func handleGetSandbox(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var enabled bool
+	var count int
+	err := dbPool.QueryRow(c.Request.Context(), `
+		SELECT u.sandbox, (SELECT COUNT(*) FROM invoices WHERE user_id = u.id AND sandbox)
+		FROM users u WHERE u.id = $1
+	`, userID).Scan(&enabled, &count)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":       enabled,
+		"invoice_count": count,
+	})
+}
+
+// handleUpdateSandbox turns sandbox mode on or off. Invoices created while it
+// is on are marked as test data; switching it off keeps them until purged.
+func handleUpdateSandbox(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var req struct {
+		Enabled *bool `json:"enabled" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "enabled is required"})
+		return
+	}
+
+	_, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE users SET sandbox = $1 WHERE id = $2", *req.Enabled, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update sandbox mode"})
+		return
+	}
+
+	message := "Sandbox mode disabled"
+	if *req.Enabled {
+		message = "Sandbox mode enabled"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"enabled": *req.Enabled,
+	})
+}
+
+// handlePurgeSandbox deletes all of the user's sandbox invoices in one action
+func handlePurgeSandbox(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx,
+		"DELETE FROM invoices WHERE user_id = $1 AND sandbox RETURNING id", userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge sandbox invoices: " + err.Error()})
+		return
+	}
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge sandbox invoices"})
+			return
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge sandbox invoices: " + err.Error()})
+		return
+	}
+
+	for _, id := range ids {
+		recordEvent(ctx, tx, userID, eventInvoiceDeleted, entityInvoice, id, gin.H{})
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to purge sandbox invoices"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "Sandbox invoices purged successfully",
+		"deleted_count": len(ids),
+	})
+}
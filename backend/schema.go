@@ -0,0 +1,422 @@
+package main
+
+import (
+	"net/http"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xuri/excelize/v2"
+)
+
+// templateSheetName is the sheet the downloadable template is written to
+const templateSheetName = "Invoice Template"
+
+// templateColumnRule describes the values accepted in a template column
+type templateColumnRule struct {
+	Type             string   `json:"type"`
+	Format           string   `json:"format,omitempty"`
+	Pattern          string   `json:"pattern,omitempty"`
+	Enum             []string `json:"enum,omitempty"`
+	Minimum          *float64 `json:"minimum,omitempty"`
+	ExclusiveMinimum *float64 `json:"exclusive_minimum,omitempty"`
+	Default          string   `json:"default,omitempty"`
+	Description      string   `json:"description,omitempty"`
+}
+
+// zero is the lower bound of non-negative and positive number rules
+var zero = 0.0
+
+// templateColumnRules lists the rules of fields that are not free text
+var templateColumnRules = map[string]templateColumnRule{
+	"invoice_date": {Type: "string", Format: "DD/MM/YYYY", Pattern: `^[0-9]{2}/[0-9]{2}/[0-9]{4}$`},
+	"seller_gstin": {Type: "string", Pattern: models.GSTINPattern},
+	"seller_trade_name": {
+		Type:        "string",
+		Description: "Defaults to the seller legal name",
+	},
+	"seller_legal_name": {
+		Type:        "string",
+		Description: "Seller details may be left blank when a company with the seller GSTIN is saved",
+	},
+	"seller_pin": {Type: "integer", Description: "Must belong to the seller state"},
+	"seller_state": {
+		Type:        "string",
+		Description: "State name or GST state code; defaults to the state of the seller GSTIN",
+	},
+	"buyer_gstin": {Type: "string", Pattern: models.GSTINPattern},
+	"buyer_trade_name": {
+		Type:        "string",
+		Description: "Defaults to the buyer legal name",
+	},
+	"buyer_pin": {
+		Type:        "integer",
+		Default:     "999999",
+		Description: "Must belong to the buyer state; 999999 for buyers outside India",
+	},
+	"buyer_state_code": {
+		Type:        "string",
+		Default:     models.ForeignStateCode,
+		Description: "State name or GST state code; 96 for buyers outside India",
+	},
+	"place_of_supply": {
+		Type:        "string",
+		Description: "State name or GST state code; defaults to the buyer state",
+	},
+	"item_no":    {Type: "string", Description: "Defaults to the position of the item within the invoice"},
+	"hsn_code":   {Type: "string", Pattern: models.HSNPattern},
+	"quantity":   {Type: "number", Minimum: &zero},
+	"unit_price": {Type: "number", ExclusiveMinimum: &zero},
+	"gst_rate":   {Type: "number", Minimum: &zero, Description: "Percentage, e.g. 18 for 18%"},
+	"is_service": {Type: "string", Enum: []string{"Y", "N"}, Default: "N"},
+	"po_date": {
+		Type:        "string",
+		Format:      "DD/MM/YYYY",
+		Pattern:     `^[0-9]{2}/[0-9]{2}/[0-9]{4}$`,
+		Description: "Date of the buyer's purchase order; requires a PO Number",
+	},
+}
+
+// templateColumn describes one column of the import template
+type templateColumn struct {
+	Column       string `json:"column,omitempty"`
+	Header       string `json:"header"`
+	Field        string `json:"field"`
+	Required     bool   `json:"required"`
+	InvoiceLevel bool   `json:"invoice_level"`
+	templateColumnRule
+}
+
+// templateSchema describes the import template for tools that generate upload files
+func templateSchema() gin.H {
+	rule := func(field string) templateColumnRule {
+		if r, ok := templateColumnRules[field]; ok {
+			return r
+		}
+		return templateColumnRule{Type: "string"}
+	}
+
+	inTemplate := make(map[string]string, len(excelTemplateLayout))
+	for i, field := range excelTemplateLayout {
+		inTemplate[field], _ = excelize.ColumnNumberToName(i + 1)
+	}
+
+	columns := make([]templateColumn, 0, len(excelTemplateLayout))
+	extra := make([]templateColumn, 0)
+	for _, f := range models.ExcelUploadFields {
+		col := templateColumn{
+			Column:             inTemplate[f.Name],
+			Header:             f.Label,
+			Field:              f.Name,
+			Required:           f.Required,
+			InvoiceLevel:       f.InvoiceLevel,
+			templateColumnRule: rule(f.Name),
+		}
+		if col.Column == "" {
+			extra = append(extra, col)
+		} else {
+			columns = append(columns, col)
+		}
+	}
+
+	return gin.H{
+		"format":     "xlsx",
+		"sheet":      templateSheetName,
+		"header_row": defaultExcelMapping.HeaderRow,
+		"columns":    columns,
+		// Fields only available through column mapping profiles
+		"mapping_only_fields": extra,
+		"rules": []string{
+			"Each row is one invoice line item; rows with the same Invoice No form one invoice",
+			"Invoice-level columns are read from the first row of each invoice and may be left blank on the following rows",
+			"An invoice is only imported when all of its rows are valid",
+		},
+	}
+}
+
+// einvoiceJSONSchema is the JSON Schema of invoices accepted by the JSON import
+func einvoiceJSONSchema() gin.H {
+	str := func(maxLength int) gin.H {
+		if maxLength == 0 {
+			return gin.H{"type": "string"}
+		}
+		return gin.H{"type": "string", "maxLength": maxLength}
+	}
+	date := gin.H{"type": "string", "pattern": `^[0-9]{2}/[0-9]{2}/[0-9]{4}$`, "description": "DD/MM/YYYY"}
+	gstin := gin.H{"type": "string", "pattern": models.GSTINPattern}
+	pin := gin.H{"type": "integer", "minimum": 100000, "maximum": 999999}
+	stateCode := gin.H{"type": "string", "pattern": `^[0-9]{2}$`, "description": "GST state code"}
+	amount := gin.H{"type": "number", "description": "Calculated on import"}
+
+	return gin.H{
+		"$schema":     "https://json-schema.org/draft/2020-12/schema",
+		"title":       "EInvoice",
+		"description": "GST e-invoice accepted by POST /api/import-json, either as a single object or an array",
+		"type":        "object",
+		"required":    []string{"Version", "TranDtls", "DocDtls", "SellerDtls", "BuyerDtls", "ItemList"},
+		"properties": gin.H{
+			"Version": gin.H{"type": "string", "const": "1.1"},
+			"TranDtls": gin.H{
+				"type":     "object",
+				"required": []string{"TaxSch", "SupTyp"},
+				"properties": gin.H{
+					"TaxSch":      gin.H{"type": "string", "const": "GST"},
+					"SupTyp":      gin.H{"type": "string", "enum": []string{"B2B", "SEZWP", "SEZWOP", "EXPWP", "EXPWOP", "DEXP"}},
+					"RegRev":      gin.H{"type": "string", "enum": []string{"Y", "N"}},
+					"EcmGstin":    gstin,
+					"IgstOnIntra": gin.H{"type": "string", "enum": []string{"Y", "N"}},
+				},
+			},
+			"DocDtls": gin.H{
+				"type":     "object",
+				"required": []string{"Typ", "No", "Dt"},
+				"properties": gin.H{
+					"Typ": gin.H{"type": "string", "enum": []string{"INV", "CRN", "DBN"}},
+					"No":  gin.H{"type": "string", "minLength": 1, "maxLength": 16},
+					"Dt":  date,
+				},
+			},
+			"SellerDtls": gin.H{
+				"type":     "object",
+				"required": []string{"Gstin", "LglNm", "Addr1", "Loc", "Pin", "Stcd"},
+				"properties": gin.H{
+					"Gstin": gstin,
+					"LglNm": str(100),
+					"TrdNm": str(100),
+					"Addr1": str(100),
+					"Addr2": str(100),
+					"Loc":   str(50),
+					"Pin":   pin,
+					"Stcd":  stateCode,
+					"Ph":    str(12),
+					"Em":    str(100),
+				},
+			},
+			"BuyerDtls": gin.H{
+				"type":     "object",
+				"required": []string{"LglNm", "Pos", "Stcd"},
+				"properties": gin.H{
+					"Gstin": str(15),
+					"LglNm": str(100),
+					"TrdNm": str(100),
+					"Pos":   stateCode,
+					"Addr1": str(100),
+					"Addr2": str(100),
+					"Loc":   str(50),
+					"Pin":   pin,
+					"Stcd":  stateCode,
+					"Ph":    str(12),
+					"Em":    str(100),
+				},
+			},
+			"ItemList": gin.H{
+				"type":     "array",
+				"minItems": 1,
+				"items": gin.H{
+					"type":     "object",
+					"required": []string{"SlNo", "PrdDesc", "IsServc", "HsnCd", "Qty", "UnitPrice", "GstRt"},
+					"properties": gin.H{
+						"SlNo":        str(6),
+						"PrdDesc":     str(300),
+						"IsServc":     gin.H{"type": "string", "enum": []string{"Y", "N"}},
+						"HsnCd":       gin.H{"type": "string", "pattern": models.HSNPattern},
+						"Qty":         gin.H{"type": "number", "minimum": 0},
+						"Unit":        str(8),
+						"UnitPrice":   gin.H{"type": "number", "exclusiveMinimum": 0},
+						"UnitPriceFc": gin.H{"type": "number", "exclusiveMinimum": 0, "description": "Unit price in the FxDtls currency"},
+						"TotAmt":      amount,
+						"AssAmt":      amount,
+						"GstRt":       gin.H{"type": "number", "minimum": 0},
+						"Exemption":   gin.H{"type": "string", "enum": models.Exemptions, "description": "Nil-rated, exempt or non-GST item"},
+						"IgstAmt":     amount,
+						"CgstAmt":     amount,
+						"SgstAmt":     amount,
+						"TotItemVal":  amount,
+						"PrdSlNo":     str(20),
+						"Barcde":      str(30),
+						"FreeQty":     gin.H{"type": "number", "minimum": 0},
+						"OrdLineRef":  str(50),
+						"OrgCntry":    gin.H{"type": "string", "pattern": `^[A-Z]{2}$`},
+						"BchDtls": gin.H{
+							"type":     "object",
+							"required": []string{"Nm"},
+							"properties": gin.H{
+								"Nm":    gin.H{"type": "string", "minLength": 1, "maxLength": 20},
+								"ExpDt": date,
+								"WrDt":  date,
+							},
+						},
+						"AttribDtls": gin.H{
+							"type": "array",
+							"items": gin.H{
+								"type": "object",
+								"properties": gin.H{
+									"Nm":  str(100),
+									"Val": str(100),
+								},
+							},
+						},
+					},
+				},
+			},
+			"ValDtls": gin.H{
+				"type":        "object",
+				"description": "Calculated on import",
+				"properties": gin.H{
+					"AssVal":      amount,
+					"IgstVal":     amount,
+					"CgstVal":     amount,
+					"SgstVal":     amount,
+					"TotInvVal":   amount,
+					"RndOffAmt":   gin.H{"type": "number"},
+					"TotInvValFc": amount,
+				},
+			},
+			"FxDtls": gin.H{
+				"type":        "object",
+				"description": "Foreign currency of the line prices; Rate, RateDt and Source are set on import unless Rate is given",
+				"required":    []string{"Currency"},
+				"properties": gin.H{
+					"Currency": gin.H{"type": "string", "pattern": `^[A-Z]{3}$`},
+					"Rate":     gin.H{"type": "number", "exclusiveMinimum": 0},
+					"RateDt":   date,
+					"Source":   gin.H{"type": "string", "enum": []string{models.FxSourceRBI, models.FxSourceECB, models.FxSourceManual}},
+				},
+			},
+			"ExpDtls": gin.H{
+				"type": "object",
+				"properties": gin.H{
+					"ShipBNo": str(20),
+					"ShipBDt": date,
+					"Port":    str(10),
+					"RefClm":  gin.H{"type": "string", "enum": []string{"Y", "N"}},
+					"ForCur":  gin.H{"type": []string{"string", "null"}},
+					"CntCode": gin.H{"type": []string{"string", "null"}},
+					"ExpDuty": gin.H{"type": "number", "minimum": 0},
+				},
+			},
+			"DispDtls": gin.H{
+				"type":     "object",
+				"required": []string{"Nm", "Addr1", "Loc", "Pin", "Stcd"},
+				"properties": gin.H{
+					"Nm":    str(100),
+					"Addr1": str(100),
+					"Addr2": str(100),
+					"Loc":   str(100),
+					"Pin":   pin,
+					"Stcd":  stateCode,
+				},
+			},
+			"ShipDtls": gin.H{
+				"type":     "object",
+				"required": []string{"LglNm", "Addr1", "Loc", "Pin", "Stcd"},
+				"properties": gin.H{
+					"Gstin": str(15),
+					"LglNm": str(100),
+					"TrdNm": str(100),
+					"Addr1": str(100),
+					"Addr2": str(100),
+					"Loc":   str(100),
+					"Pin":   pin,
+					"Stcd":  stateCode,
+				},
+			},
+			"PayDtls": gin.H{
+				"type": "object",
+				"properties": gin.H{
+					"Nm":       str(100),
+					"AccDet":   str(18),
+					"Mode":     str(18),
+					"FinInsBr": str(11),
+					"PayTerm":  str(100),
+					"PayInstr": str(100),
+					"CrTrn":    str(100),
+					"DirDr":    str(100),
+					"CrDay":    gin.H{"type": "integer", "minimum": 0, "maximum": 9999},
+					"PaidAmt":  gin.H{"type": "number", "minimum": 0},
+					"PaymtDue": gin.H{"type": "number", "minimum": 0},
+				},
+			},
+			"AddlDocDtls": gin.H{
+				"type": "array",
+				"items": gin.H{
+					"type": "object",
+					"properties": gin.H{
+						"Url":  str(100),
+						"Docs": str(1000),
+						"Info": str(1000),
+					},
+				},
+			},
+			"EwbDtls": gin.H{
+				"type":     "object",
+				"required": []string{"Distance"},
+				"properties": gin.H{
+					"TransId":    str(15),
+					"TransName":  str(100),
+					"TransMode":  gin.H{"type": "string", "enum": models.TransModes, "description": "1 road, 2 rail, 3 air, 4 ship"},
+					"Distance":   gin.H{"type": "integer", "minimum": 0, "maximum": 4000},
+					"TransDocNo": str(15),
+					"TransDocDt": date,
+					"VehNo":      gin.H{"type": "string", "minLength": 4, "maxLength": 20},
+					"VehType":    gin.H{"type": "string", "enum": []string{"R", "O"}},
+				},
+			},
+			"RefDtls": gin.H{
+				"type": "object",
+				"properties": gin.H{
+					"InvRm": str(100),
+					"DocPerdDtls": gin.H{
+						"type":     "object",
+						"required": []string{"InvStDt", "InvEndDt"},
+						"properties": gin.H{
+							"InvStDt":  date,
+							"InvEndDt": date,
+						},
+					},
+					"PrecDocDtls": gin.H{
+						"type": "array",
+						"items": gin.H{
+							"type":     "object",
+							"required": []string{"InvNo", "InvDt"},
+							"properties": gin.H{
+								"InvNo":    gin.H{"type": "string", "minLength": 1, "maxLength": 16},
+								"InvDt":    date,
+								"OthRefNo": str(20),
+							},
+						},
+					},
+					"ContrDtls": gin.H{
+						"type": "array",
+						"items": gin.H{
+							"type": "object",
+							"properties": gin.H{
+								"RecAdvRefr": str(20),
+								"RecAdvDt":   date,
+								"TendRefr":   str(20),
+								"ContrRefr":  str(20),
+								"ExtRefr":    str(20),
+								"ProjRefr":   str(20),
+								"PORefr":     str(16),
+								"PORefDt":    date,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// handlePublicTemplateSchema returns the machine-readable import template schema
+func handlePublicTemplateSchema(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.JSON(http.StatusOK, templateSchema())
+}
+
+// handlePublicEInvoiceSchema returns the JSON Schema of importable invoices
+func handlePublicEInvoiceSchema(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=3600")
+	c.Header("Content-Type", "application/schema+json")
+	c.JSON(http.StatusOK, einvoiceJSONSchema())
+}
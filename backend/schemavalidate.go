@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// einvoiceSchema is the bundled NIC e-invoice JSON Schema that imported and
+// exported invoices are checked against, as served by /api/public/einvoice-schema
+var einvoiceSchema = einvoiceJSONSchema()
+
+// schemaViolation is one place where a JSON document breaks its schema
+type schemaViolation struct {
+	// Path is the JSON pointer (RFC 6901) of the offending value, "" for the document
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+// schemaPatterns caches the compiled pattern keywords of schemas
+var schemaPatterns sync.Map
+
+// schemaPattern returns the compiled form of a pattern keyword
+func schemaPattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := schemaPatterns.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	schemaPatterns.Store(pattern, re)
+	return re, nil
+}
+
+// validateInvoiceJSON checks an invoice, or an array of invoices, against the
+// e-invoice schema. Violations are reported with their JSON pointer from the
+// root of data.
+func validateInvoiceJSON(data []byte) ([]schemaViolation, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc interface{}
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	var violations []schemaViolation
+	if invoices, ok := doc.([]interface{}); ok {
+		for i, invoice := range invoices {
+			violations = validateSchema(einvoiceSchema, invoice, "/"+strconv.Itoa(i), violations)
+		}
+		return violations, nil
+	}
+	return validateSchema(einvoiceSchema, doc, "", violations), nil
+}
+
+// pointerToken escapes a property name for use in a JSON pointer
+func pointerToken(name string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(name)
+}
+
+// validateSchema appends the violations of value against schema, found at path.
+// It supports the keywords used by einvoiceJSONSchema: type, const, enum,
+// pattern, minLength, maxLength, minimum, maximum, exclusiveMinimum, required,
+// properties, items and minItems.
+func validateSchema(schema gin.H, value interface{}, path string, violations []schemaViolation) []schemaViolation {
+	violation := func(format string, args ...interface{}) {
+		violations = append(violations, schemaViolation{Path: path, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		violation("must be of type %s", describeType(t))
+		return violations
+	}
+	if c, ok := schema["const"]; ok && value != c {
+		violation("must be %q", c)
+	}
+	if enum, ok := schema["enum"].([]string); ok {
+		s, _ := value.(string)
+		found := false
+		for _, e := range enum {
+			found = found || s == e
+		}
+		if !found {
+			violation("must be one of %s", strings.Join(enum, ", "))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		length := len([]rune(v))
+		if min, ok := schema["minLength"].(int); ok && length < min {
+			violation("must be at least %d character(s)", min)
+		}
+		if max, ok := schema["maxLength"].(int); ok && length > max {
+			violation("must be at most %d character(s)", max)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := schemaPattern(pattern)
+			if err == nil && !re.MatchString(v) {
+				violation("must match the pattern %s", pattern)
+			}
+		}
+	case json.Number:
+		n, _ := v.Float64()
+		if min, ok := schemaNumber(schema["minimum"]); ok && n < min {
+			violation("must be at least %g", min)
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && n > max {
+			violation("must be at most %g", max)
+		}
+		if min, ok := schemaNumber(schema["exclusiveMinimum"]); ok && n <= min {
+			violation("must be greater than %g", min)
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]string); ok {
+			for _, name := range required {
+				if _, present := v[name]; !present {
+					violations = append(violations, schemaViolation{
+						Path:    path + "/" + pointerToken(name),
+						Message: "is required",
+					})
+				}
+			}
+		}
+		if properties, ok := schema["properties"].(gin.H); ok {
+			// Visit properties in a stable order so responses are reproducible
+			names := make([]string, 0, len(v))
+			for name := range v {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				if sub, ok := properties[name].(gin.H); ok {
+					violations = validateSchema(sub, v[name], path+"/"+pointerToken(name), violations)
+				}
+			}
+		}
+	case []interface{}:
+		if min, ok := schema["minItems"].(int); ok && len(v) < min {
+			violation("must have at least %d item(s)", min)
+		}
+		if items, ok := schema["items"].(gin.H); ok {
+			for i, item := range v {
+				violations = validateSchema(items, item, path+"/"+strconv.Itoa(i), violations)
+			}
+		}
+	}
+	return violations
+}
+
+// schemaNumber reads a numeric keyword of a schema
+func schemaNumber(keyword interface{}) (float64, bool) {
+	switch n := keyword.(type) {
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+// matchesType reports whether value is of the type, or one of the types, t
+func matchesType(t interface{}, value interface{}) bool {
+	switch t := t.(type) {
+	case string:
+		return matchesSingleType(t, value)
+	case []string:
+		for _, name := range t {
+			if matchesSingleType(name, value) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+func matchesSingleType(t string, value interface{}) bool {
+	switch t {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(json.Number)
+		return ok
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			return false
+		}
+		f, err := n.Float64()
+		return err == nil && f == math.Trunc(f)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// describeType names a type keyword in a violation message
+func describeType(t interface{}) string {
+	if types, ok := t.([]string); ok {
+		return strings.Join(types, " or ")
+	}
+	return fmt.Sprint(t)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// searchTrigram reports whether pg_trgm is available for typo-tolerant matching
+var searchTrigram bool
+
+// searchEntityTypes are the entity types covered by the search index
+var searchEntityTypes = map[string]bool{
+	entityInvoice:  true,
+	entityCustomer: true,
+	entityItem:     true,
+}
+
+// createSearchTables creates the search index and backfills events for
+// customers and items so the index can be built from the event log
+func createSearchTables() {
+	// pg_trgm powers typo tolerance; without it search falls back to full-text matching only
+	_, err := dbPool.Exec(context.Background(), "CREATE EXTENSION IF NOT EXISTS pg_trgm")
+	if err != nil {
+		log.Printf("pg_trgm extension unavailable, search will not tolerate typos: %v", err)
+	} else {
+		searchTrigram = true
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS search_documents (
+			entity_type VARCHAR(50) NOT NULL,
+			entity_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			title VARCHAR(255) NOT NULL,
+			subtitle TEXT NOT NULL DEFAULT '',
+			search_text TEXT NOT NULL DEFAULT '',
+			tsv TSVECTOR NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (entity_type, entity_id)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create search_documents table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_search_documents_tsv ON search_documents USING GIN (tsv)")
+	if err != nil {
+		log.Fatalf("Failed to create search index: %v", err)
+	}
+	if searchTrigram {
+		_, err = dbPool.Exec(context.Background(),
+			"CREATE INDEX IF NOT EXISTS idx_search_documents_trgm ON search_documents USING GIN (search_text gin_trgm_ops)")
+		if err != nil {
+			log.Fatalf("Failed to create search trigram index: %v", err)
+		}
+	}
+
+	// Customers and items have no event history before this point; record a
+	// snapshot event for each so the index backfills them
+	for entityType, table := range map[string]string{entityCustomer: "customers", entityItem: "items"} {
+		result, err := dbPool.Exec(context.Background(), `
+			INSERT INTO events (user_id, type, entity_type, entity_id, created_at)
+			SELECT user_id, $1, $2, id, created_at
+			FROM `+table+` t
+			WHERE NOT EXISTS (
+				SELECT 1 FROM events WHERE entity_type = $2 AND entity_id = t.id
+			)
+			ORDER BY id
+		`, entityType+".saved", entityType)
+		if err != nil {
+			log.Fatalf("Failed to backfill %s events: %v", entityType, err)
+		}
+		if result.RowsAffected() > 0 {
+			log.Printf("Backfilled events for %d existing %s(s)", result.RowsAffected(), entityType)
+		}
+	}
+}
+
+// resetSearchIndex clears the search index before a rebuild
+func resetSearchIndex(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, "TRUNCATE search_documents")
+	return err
+}
+
+// applySearchIndex re-indexes the entity an event refers to from its current
+// row, removing it from the index when the row no longer exists
+func applySearchIndex(ctx context.Context, tx pgx.Tx, event *models.Event) error {
+	if !searchEntityTypes[event.EntityType] {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx,
+		"DELETE FROM search_documents WHERE entity_type = $1 AND entity_id = $2",
+		event.EntityType, event.EntityID)
+	if err != nil {
+		return err
+	}
+
+	var query string
+	switch event.EntityType {
+	case entityInvoice:
+		query = `
+			INSERT INTO search_documents (entity_type, entity_id, user_id, title, subtitle, search_text, tsv)
+			SELECT $1, i.id, i.user_id, i.invoice_no,
+				concat_ws(' · ', p.b->>'LglNm', i.invoice_json->'DocDtls'->>'Dt', i.status),
+				concat_ws(' ', i.invoice_no, p.b->>'LglNm', p.b->>'Gstin'),
+				setweight(to_tsvector('simple', i.invoice_no), 'A') ||
+				setweight(to_tsvector('simple', concat_ws(' ', p.b->>'LglNm', p.b->>'TrdNm', p.b->>'Gstin', i.seller_gstin)), 'B') ||
+				setweight(to_tsvector('simple', concat_ws(' ', p.b->>'Loc', (
+					SELECT string_agg(concat_ws(' ', it->>'PrdDesc', it->>'HsnCd'), ' ')
+					FROM jsonb_array_elements(CASE WHEN jsonb_typeof(i.invoice_json->'ItemList') = 'array'
+						THEN i.invoice_json->'ItemList' ELSE '[]'::jsonb END) it
+				))), 'C')
+			FROM invoices i
+			CROSS JOIN LATERAL (SELECT COALESCE(i.invoice_json->'BuyerDtls', '{}'::jsonb) AS b) p
+			WHERE i.id = $2
+		`
+	case entityCustomer:
+		query = `
+			INSERT INTO search_documents (entity_type, entity_id, user_id, title, subtitle, search_text, tsv)
+			SELECT $1, id, user_id, name,
+				concat_ws(' · ', gstin, city),
+				concat_ws(' ', name, gstin),
+				setweight(to_tsvector('simple', name), 'A') ||
+				setweight(to_tsvector('simple', COALESCE(gstin, '')), 'B') ||
+				setweight(to_tsvector('simple', concat_ws(' ', address, city, state, email, phone)), 'C')
+			FROM customers WHERE id = $2
+		`
+	case entityItem:
+		query = `
+			INSERT INTO search_documents (entity_type, entity_id, user_id, title, subtitle, search_text, tsv)
+			SELECT $1, id, user_id, name,
+				concat_ws(' · ', 'HSN ' || hsn_code, unit),
+				concat_ws(' ', name, hsn_code),
+				setweight(to_tsvector('simple', name), 'A') ||
+				setweight(to_tsvector('simple', hsn_code), 'B') ||
+				setweight(to_tsvector('simple', COALESCE(description, '')), 'C')
+			FROM items WHERE id = $2
+		`
+	}
+
+	_, err = tx.Exec(ctx, query, event.EntityType, event.EntityID)
+	return err
+}
+
+// searchPrefixQuery turns free text into a tsquery matching every word as a prefix,
+// e.g. "acme tra" becomes "acme:* & tra:*"
+func searchPrefixQuery(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + ":*"
+	}
+	return strings.Join(words, " & ")
+}
+
+// handleSearch searches the user's invoices, customers and items, ranking
+// full-text matches and tolerating typos through trigram similarity
+func handleSearch(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	query := strings.TrimSpace(c.Query("q"))
+	if len(query) < 2 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query must be at least 2 characters"})
+		return
+	}
+	tsQuery := searchPrefixQuery(query)
+	if tsQuery == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Search query must contain letters or digits"})
+		return
+	}
+
+	entityType := c.Query("type")
+	if entityType != "" && !searchEntityTypes[entityType] {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid search type %q", entityType)})
+		return
+	}
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+	if err != nil || limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	args := []interface{}{userID, tsQuery, entityType, limit}
+	match := "tsv @@ to_tsquery('simple', $2)"
+	rank := "ts_rank(tsv, to_tsquery('simple', $2))"
+	if searchTrigram {
+		args = append(args, query)
+		match = "(" + match + " OR $5 <% search_text)"
+		rank += " + word_similarity($5, search_text)"
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT entity_type, entity_id, title, subtitle, (`+rank+`)::float8 AS rank
+		FROM search_documents
+		WHERE user_id = $1 AND `+match+` AND ($3 = '' OR entity_type = $3)
+		ORDER BY rank DESC, updated_at DESC
+		LIMIT $4
+	`, args...)
+	if err != nil {
+		log.Printf("Error searching: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search"})
+		return
+	}
+	defer rows.Close()
+
+	results := make([]gin.H, 0)
+	for rows.Next() {
+		var resultType, title, subtitle string
+		var id int
+		var score float64
+		if err := rows.Scan(&resultType, &id, &title, &subtitle, &score); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read search results"})
+			return
+		}
+		results = append(results, gin.H{
+			"type":     resultType,
+			"id":       id,
+			"title":    title,
+			"subtitle": subtitle,
+			"score":    score,
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sort"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// createSettingsTables creates the table of per-user defaults for new invoices
+func createSettingsTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS user_settings (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL UNIQUE REFERENCES users(id),
+			invoice_prefix VARCHAR(20) NOT NULL,
+			supply_type VARCHAR(10) NOT NULL,
+			gst_treatment VARCHAR(20) NOT NULL,
+			rounding_mode VARCHAR(10) NOT NULL,
+			date_format VARCHAR(10) NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create user_settings table: %v", err)
+	}
+
+	// Approval mode for accountant members
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS approval_required BOOLEAN NOT NULL DEFAULT FALSE")
+	if err != nil {
+		log.Fatalf("Failed to add approval_required column to user_settings table: %v", err)
+	}
+
+	// Invoice series that restart each financial year
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS series_reset VARCHAR(20) NOT NULL DEFAULT 'never'")
+	if err != nil {
+		log.Fatalf("Failed to add series_reset column to user_settings table: %v", err)
+	}
+
+	// How often the summary email is sent
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE user_settings ADD COLUMN IF NOT EXISTS email_digest VARCHAR(10) NOT NULL DEFAULT 'off'")
+	if err != nil {
+		log.Fatalf("Failed to add email_digest column to user_settings table: %v", err)
+	}
+}
+
+// loadUserSettings returns the user's settings, or the defaults for the
+// fields they have not saved, together with their default company
+func loadUserSettings(ctx context.Context, userID int) (*models.UserSettings, error) {
+	s := models.DefaultUserSettings
+	err := dbPool.QueryRow(ctx, `
+		SELECT invoice_prefix, supply_type, gst_treatment, rounding_mode, date_format, approval_required,
+			series_reset, email_digest, updated_at
+		FROM user_settings WHERE user_id = $1
+	`, userID).Scan(&s.InvoicePrefix, &s.SupplyType, &s.GSTTreatment, &s.RoundingMode, &s.DateFormat,
+		&s.ApprovalRequired, &s.SeriesReset, &s.EmailDigest, &s.UpdatedAt)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+
+	var companyID int
+	err = dbPool.QueryRow(ctx,
+		"SELECT id FROM companies WHERE user_id = $1 AND is_default LIMIT 1", userID).Scan(&companyID)
+	if err == nil {
+		s.DefaultCompanyID = &companyID
+	} else if !errors.Is(err, pgx.ErrNoRows) {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// getUserSettings is loadUserSettings for callers that carry on with the
+// defaults when the settings cannot be read
+func getUserSettings(ctx context.Context, userID int) *models.UserSettings {
+	s, err := loadUserSettings(ctx, userID)
+	if err != nil {
+		log.Printf("Error loading settings for user %d: %v", userID, err)
+		defaults := models.DefaultUserSettings
+		return &defaults
+	}
+	return s
+}
+
+// getDefaultCompany returns the company new invoices are issued from when they
+// name no seller, or nil when the user has no default company
+func getDefaultCompany(ctx context.Context, userID int, settings *models.UserSettings) *models.CompanyDetails {
+	if settings.DefaultCompanyID == nil {
+		return nil
+	}
+	co, err := scanCompany(dbPool.QueryRow(ctx,
+		"SELECT "+companyColumns+" FROM companies WHERE id = $1 AND user_id = $2",
+		*settings.DefaultCompanyID, userID))
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error loading default company: %v", err)
+		}
+		return nil
+	}
+	return co
+}
+
+// sellerFromCompany fills in the seller details of an invoice from a saved company
+func sellerFromCompany(co *models.CompanyDetails) models.SellerDtls {
+	// A GSTIN starts with the state code of its registration
+	state, found := resolveStateCode(co.State)
+	if !found && len(co.GSTIN) >= 2 {
+		state = co.GSTIN[:2]
+	}
+	return models.SellerDtls{
+		Gstin: co.GSTIN,
+		LglNm: co.Name,
+		TrdNm: co.Name,
+		Addr1: co.Address,
+		Loc:   co.City,
+		Pin:   co.Pincode,
+		Stcd:  state,
+		Ph:    co.Phone,
+		Em:    co.Email,
+	}
+}
+
+// handleGetSettings returns the authenticated user's settings and the supported values
+func handleGetSettings(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	settings, err := loadUserSettings(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load settings"})
+		return
+	}
+
+	dateFormats := make([]string, 0, len(models.DateFormats))
+	for format := range models.DateFormats {
+		dateFormats = append(dateFormats, format)
+	}
+	sort.Strings(dateFormats)
+
+	c.JSON(http.StatusOK, gin.H{
+		"settings": settings,
+		"options": gin.H{
+			"supply_types":     models.SupplyTypes,
+			"gst_treatments":   []string{models.GSTTreatmentRegular, models.GSTTreatmentReverseCharge},
+			"rounding_modes":   []string{models.RoundingLine, models.RoundingInvoice},
+			"rounding_methods": []string{models.RoundHalfUp, models.RoundHalfEven},
+			"date_formats":     dateFormats,
+			"series_resets":    []string{models.SeriesResetNever, models.SeriesResetFiscalYear},
+			"email_digests":    []string{models.DigestOff, models.DigestDaily, models.DigestWeekly},
+		},
+	})
+}
+
+// handleUpdateSettings saves the authenticated user's settings. Setting the
+// default company marks that company as the default; null clears it.
+func handleUpdateSettings(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	var settings models.UserSettings
+	if err := c.ShouldBindJSON(&settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid settings: " + err.Error()})
+		return
+	}
+	if err := settings.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	if settings.DefaultCompanyID != nil {
+		var exists bool
+		err := tx.QueryRow(ctx,
+			"SELECT EXISTS (SELECT 1 FROM companies WHERE id = $1 AND user_id = $2)",
+			*settings.DefaultCompanyID, userID).Scan(&exists)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			return
+		}
+		if !exists {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Company not found or not authorized"})
+			return
+		}
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE companies SET is_default = COALESCE(id = $2, FALSE)
+		WHERE user_id = $1 AND is_default <> COALESCE(id = $2, FALSE)`,
+		userID, settings.DefaultCompanyID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update default company"})
+		return
+	}
+
+	if err := saveUserSettings(ctx, tx, userID, &settings); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings"})
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save settings"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":  "Settings saved successfully",
+		"settings": settings,
+	})
+}
+
+// saveUserSettings stores the user's settings other than the default company
+func saveUserSettings(ctx context.Context, db execer, userID int, s *models.UserSettings) error {
+	_, err := db.Exec(ctx, `
+		INSERT INTO user_settings (user_id, invoice_prefix, supply_type, gst_treatment, rounding_mode, date_format,
+			approval_required, series_reset, email_digest)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (user_id) DO UPDATE
+		SET invoice_prefix = $2, supply_type = $3, gst_treatment = $4, rounding_mode = $5,
+			date_format = $6, approval_required = $7, series_reset = $8, email_digest = $9, updated_at = NOW()
+	`, userID, s.InvoicePrefix, s.SupplyType, s.GSTTreatment, s.RoundingMode, s.DateFormat, s.ApprovalRequired,
+		s.SeriesReset, s.EmailDigest)
+	return err
+}
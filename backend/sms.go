@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// smsMessage is a text message handed to an SMS provider
+type smsMessage struct {
+	// phone is digits only with the country code, as returned by normalizeMobileNumber
+	phone string
+	body  string
+	// templateID is the provider's ID of a registered template, if any
+	templateID string
+	// vars fill in the registered template for providers that send templates
+	vars map[string]string
+}
+
+// smsSender delivers text messages through an SMS provider
+type smsSender interface {
+	name() string
+	// send returns the provider's ID of the accepted message
+	send(ctx context.Context, msg *smsMessage) (string, error)
+}
+
+// smsProvider is the configured SMS provider, nil when SMS_PROVIDER is unset
+var smsProvider smsSender
+
+// smsHTTPClient is used for all calls to SMS providers
+var smsHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// newSMSSender returns the provider named by SMS_PROVIDER, or nil when it is unset
+func newSMSSender(provider string) (smsSender, error) {
+	switch provider {
+	case "":
+		return nil, nil
+	case "msg91":
+		return newMSG91Sender()
+	case "twilio":
+		return newTwilioSender()
+	}
+	return nil, fmt.Errorf("unknown SMS_PROVIDER %q; use msg91 or twilio", provider)
+}
+
+// initSMSProvider configures the provider SMS notifications are sent
+// through. Without SMS_PROVIDER, notification rules are kept but no SMS is sent.
+func initSMSProvider() {
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv("SMS_PROVIDER")))
+	s, err := newSMSSender(provider)
+	if err != nil {
+		log.Fatalf("Failed to configure SMS notifications: %v", err)
+	}
+	if s == nil {
+		return
+	}
+	smsProvider = s
+	log.Printf("Sending SMS notifications through %s", provider)
+}
+
+// normalizeMobileNumber returns a phone number in international format,
+// digits only with the country code, as SMS and WhatsApp providers expect.
+// Ten-digit numbers, with or without a leading 0, are taken to be Indian.
+func normalizeMobileNumber(phone string) (string, error) {
+	digits := strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' {
+			return r
+		}
+		return -1
+	}, phone)
+	digits = strings.TrimPrefix(digits, "00")
+	if len(digits) == 11 && digits[0] == '0' {
+		digits = digits[1:]
+	}
+	if len(digits) == 10 {
+		digits = "91" + digits
+	}
+	if len(digits) < 11 || len(digits) > 15 {
+		return "", fmt.Errorf("invalid phone number %q; include the country code", phone)
+	}
+	return digits, nil
+}
+
+// smsRequest posts a request to an SMS provider and decodes the response
+// into out. Rejected requests fail permanently, as retrying them fails the
+// same way.
+func smsRequest(req *http.Request, provider string, errorMessage func([]byte) string, out interface{}) error {
+	resp, err := smsHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach %s: %w", provider, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		err := fmt.Errorf("%s returned HTTP %d: %s", provider, resp.StatusCode, errorMessage(respBody))
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			return permanentError(err)
+		}
+		return err
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// msg91Sender sends SMS through the MSG91 Flow API. Indian DLT rules only
+// allow registered templates, so messages are sent by template ID with the
+// invoice details as variables rather than as text.
+type msg91Sender struct {
+	apiURL  string
+	authKey string
+}
+
+// newMSG91Sender reads MSG91_AUTH_KEY, and MSG91_API_URL to point it at
+// another API such as a mock in development
+func newMSG91Sender() (*msg91Sender, error) {
+	s := &msg91Sender{
+		apiURL:  strings.TrimRight(getEnvWithDefault("MSG91_API_URL", "https://control.msg91.com/api/v5"), "/"),
+		authKey: os.Getenv("MSG91_AUTH_KEY"),
+	}
+	if s.authKey == "" {
+		return nil, fmt.Errorf("MSG91_AUTH_KEY is required for MSG91")
+	}
+	return s, nil
+}
+
+func (s *msg91Sender) name() string { return "msg91" }
+
+func (s *msg91Sender) send(ctx context.Context, msg *smsMessage) (string, error) {
+	if msg.templateID == "" {
+		return "", permanentError(fmt.Errorf("MSG91 needs a template_id on the notification rule"))
+	}
+	recipient := map[string]string{"mobiles": msg.phone}
+	for k, v := range msg.vars {
+		recipient[k] = v
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"template_id": msg.templateID,
+		"recipients":  []map[string]string{recipient},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.apiURL+"/flow", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("authkey", s.authKey)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	var resp struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	}
+	err = smsRequest(req, "MSG91", func(b []byte) string {
+		var r struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &r) == nil && r.Message != "" {
+			return r.Message
+		}
+		return "unexpected response"
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	// MSG91 reports some failures with HTTP 200
+	if resp.Type != "success" {
+		return "", permanentError(fmt.Errorf("MSG91 rejected the message: %s", firstNonEmpty(resp.Message, "unexpected response")))
+	}
+	// On success the message is the request ID
+	return resp.Message, nil
+}
+
+// twilioSender sends SMS through the Twilio Messages API
+type twilioSender struct {
+	apiURL     string
+	accountSID string
+	authToken  string
+	// from is a sender number, or a messaging service SID starting with MG
+	from string
+}
+
+// newTwilioSender reads TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM,
+// and TWILIO_API_URL to point it at another API such as a mock in development
+func newTwilioSender() (*twilioSender, error) {
+	s := &twilioSender{
+		apiURL:     strings.TrimRight(getEnvWithDefault("TWILIO_API_URL", "https://api.twilio.com/2010-04-01"), "/"),
+		accountSID: os.Getenv("TWILIO_ACCOUNT_SID"),
+		authToken:  os.Getenv("TWILIO_AUTH_TOKEN"),
+		from:       os.Getenv("TWILIO_FROM"),
+	}
+	if s.accountSID == "" || s.authToken == "" || s.from == "" {
+		return nil, fmt.Errorf("TWILIO_ACCOUNT_SID, TWILIO_AUTH_TOKEN and TWILIO_FROM are required for Twilio")
+	}
+	return s, nil
+}
+
+func (s *twilioSender) name() string { return "twilio" }
+
+func (s *twilioSender) send(ctx context.Context, msg *smsMessage) (string, error) {
+	form := url.Values{"To": {"+" + msg.phone}, "Body": {msg.body}}
+	if strings.HasPrefix(s.from, "MG") {
+		form.Set("MessagingServiceSid", s.from)
+	} else {
+		form.Set("From", s.from)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		s.apiURL+"/Accounts/"+url.PathEscape(s.accountSID)+"/Messages.json", strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.SetBasicAuth(s.accountSID, s.authToken)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	var resp struct {
+		SID string `json:"sid"`
+	}
+	err = smsRequest(req, "Twilio", func(b []byte) string {
+		var r struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(b, &r) == nil && r.Message != "" {
+			return r.Message
+		}
+		return "unexpected response"
+	}, &resp)
+	if err != nil {
+		return "", err
+	}
+	return resp.SID, nil
+}
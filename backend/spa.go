@@ -0,0 +1,79 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// webFiles holds the frontend build copied into web/build before the binary
+// is built. Without one the frontend must be hosted separately.
+//
+//go:embed all:web
+var webFiles embed.FS
+
+// spaFiles returns the embedded frontend build, nil when the binary has none
+func spaFiles() fs.FS {
+	files, err := fs.Sub(webFiles, "web/build")
+	if err != nil {
+		return nil
+	}
+	if _, err := fs.Stat(files, "index.html"); err != nil {
+		return nil
+	}
+	return files
+}
+
+// serveSPA serves the embedded frontend for the paths no route matches:
+// files of the build as they are and every other page path as index.html,
+// leaving client-side routing to the app. Hashed assets under static/ are
+// cached for good; everything else is revalidated so deploys show at once.
+// Unknown /api paths still get a JSON 404.
+func serveSPA(router *gin.Engine) {
+	files := spaFiles()
+	if files == nil {
+		log.Printf("No embedded frontend build; serve the frontend separately")
+		return
+	}
+	index, err := fs.ReadFile(files, "index.html")
+	if err != nil {
+		log.Fatalf("Failed to read embedded index.html: %v", err)
+	}
+	fileServer := http.FileServer(http.FS(files))
+	log.Printf("Serving the embedded frontend")
+
+	router.NoRoute(func(c *gin.Context) {
+		urlPath := c.Request.URL.Path
+		if urlPath == "/api" || strings.HasPrefix(urlPath, "/api/") || strings.HasPrefix(urlPath, "/.well-known/") ||
+			(c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Not found"})
+			return
+		}
+
+		name := strings.TrimPrefix(path.Clean(urlPath), "/")
+		if name != "" && name != "index.html" && !strings.HasPrefix(path.Base(name), ".") {
+			if info, err := fs.Stat(files, name); err == nil && !info.IsDir() {
+				if strings.HasPrefix(name, "static/") {
+					c.Header("Cache-Control", "public, max-age=31536000, immutable")
+				} else {
+					c.Header("Cache-Control", "no-cache")
+				}
+				fileServer.ServeHTTP(c.Writer, c.Request)
+				return
+			}
+			// A missing asset is an error, not a page of the app
+			if path.Ext(name) != "" {
+				c.String(http.StatusNotFound, "Not found")
+				return
+			}
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Data(http.StatusOK, "text/html; charset=utf-8", index)
+	})
+}
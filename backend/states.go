@@ -0,0 +1,257 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// stateSeed is the GST state code list with the India Post PIN code ranges of each state
+var stateSeed = []models.State{
+	{Code: "01", Name: "Jammu and Kashmir", Ranges: []models.PincodeRange{{From: 180000, To: 194999}}},
+	{Code: "02", Name: "Himachal Pradesh", Ranges: []models.PincodeRange{{From: 171000, To: 177999}}},
+	{Code: "03", Name: "Punjab", Ranges: []models.PincodeRange{{From: 140000, To: 160999}}},
+	{Code: "04", Name: "Chandigarh", Ranges: []models.PincodeRange{{From: 160000, To: 160999}}},
+	{Code: "05", Name: "Uttarakhand", Ranges: []models.PincodeRange{{From: 244000, To: 263999}}},
+	{Code: "06", Name: "Haryana", Ranges: []models.PincodeRange{{From: 121000, To: 136999}}},
+	{Code: "07", Name: "Delhi", Ranges: []models.PincodeRange{{From: 110000, To: 110999}}},
+	{Code: "08", Name: "Rajasthan", Ranges: []models.PincodeRange{{From: 301000, To: 345999}}},
+	{Code: "09", Name: "Uttar Pradesh", Ranges: []models.PincodeRange{{From: 201000, To: 285999}}},
+	{Code: "10", Name: "Bihar", Ranges: []models.PincodeRange{{From: 800000, To: 855999}}},
+	{Code: "11", Name: "Sikkim", Ranges: []models.PincodeRange{{From: 737000, To: 737999}}},
+	{Code: "12", Name: "Arunachal Pradesh", Ranges: []models.PincodeRange{{From: 790000, To: 792999}}},
+	{Code: "13", Name: "Nagaland", Ranges: []models.PincodeRange{{From: 797000, To: 798999}}},
+	{Code: "14", Name: "Manipur", Ranges: []models.PincodeRange{{From: 795000, To: 795999}}},
+	{Code: "15", Name: "Mizoram", Ranges: []models.PincodeRange{{From: 796000, To: 796999}}},
+	{Code: "16", Name: "Tripura", Ranges: []models.PincodeRange{{From: 799000, To: 799999}}},
+	{Code: "17", Name: "Meghalaya", Ranges: []models.PincodeRange{{From: 793000, To: 794999}}},
+	{Code: "18", Name: "Assam", Ranges: []models.PincodeRange{{From: 781000, To: 788999}}},
+	{Code: "19", Name: "West Bengal", Ranges: []models.PincodeRange{{From: 700000, To: 743999}}},
+	{Code: "20", Name: "Jharkhand", Ranges: []models.PincodeRange{{From: 813000, To: 835999}}},
+	{Code: "21", Name: "Odisha", Ranges: []models.PincodeRange{{From: 751000, To: 770999}}},
+	{Code: "22", Name: "Chhattisgarh", Ranges: []models.PincodeRange{{From: 490000, To: 497999}}},
+	{Code: "23", Name: "Madhya Pradesh", Ranges: []models.PincodeRange{{From: 450000, To: 488999}}},
+	{Code: "24", Name: "Gujarat", Ranges: []models.PincodeRange{{From: 360000, To: 396999}}},
+	{Code: "25", Name: "Daman and Diu", Ranges: []models.PincodeRange{{From: 362500, To: 362599}, {From: 396200, To: 396239}}},
+	{Code: "26", Name: "Dadra and Nagar Haveli and Daman and Diu", Ranges: []models.PincodeRange{{From: 362500, To: 362599}, {From: 396000, To: 396999}}},
+	{Code: "27", Name: "Maharashtra", Ranges: []models.PincodeRange{{From: 400000, To: 445999}}},
+	{Code: "29", Name: "Karnataka", Ranges: []models.PincodeRange{{From: 560000, To: 591999}}},
+	{Code: "30", Name: "Goa", Ranges: []models.PincodeRange{{From: 403000, To: 403999}}},
+	{Code: "31", Name: "Lakshadweep", Ranges: []models.PincodeRange{{From: 682550, To: 682559}}},
+	{Code: "32", Name: "Kerala", Ranges: []models.PincodeRange{{From: 670000, To: 695999}}},
+	{Code: "33", Name: "Tamil Nadu", Ranges: []models.PincodeRange{{From: 600000, To: 643999}}},
+	{Code: "34", Name: "Puducherry", Ranges: []models.PincodeRange{
+		{From: 533460, To: 533469}, {From: 605000, To: 605999}, {From: 609600, To: 609699}, {From: 673310, To: 673319},
+	}},
+	{Code: "35", Name: "Andaman and Nicobar Islands", Ranges: []models.PincodeRange{{From: 744000, To: 744999}}},
+	{Code: "36", Name: "Telangana", Ranges: []models.PincodeRange{{From: 500000, To: 509999}}},
+	{Code: "37", Name: "Andhra Pradesh", Ranges: []models.PincodeRange{{From: 507000, To: 535999}}},
+	{Code: "38", Name: "Ladakh", Ranges: []models.PincodeRange{{From: 194000, To: 194999}}},
+	{Code: "96", Name: "Other Country", Ranges: []models.PincodeRange{{From: models.ForeignPincode, To: models.ForeignPincode}}},
+	{Code: "97", Name: "Other Territory"},
+}
+
+// stateMaster caches the states table keyed by state code
+var (
+	stateMaster   map[string]*models.State
+	stateMasterMu sync.RWMutex
+)
+
+// createStateTables creates and seeds the state code master and PIN code ranges
+func createStateTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS states (
+			code VARCHAR(2) PRIMARY KEY,
+			name VARCHAR(100) NOT NULL
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create states table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS state_pincode_ranges (
+			id SERIAL PRIMARY KEY,
+			state_code VARCHAR(2) NOT NULL REFERENCES states(code) ON DELETE CASCADE,
+			pin_from INTEGER NOT NULL,
+			pin_to INTEGER NOT NULL,
+			UNIQUE(state_code, pin_from, pin_to)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create state_pincode_ranges table: %v", err)
+	}
+
+	// Seed missing states and ranges; existing rows are left untouched
+	for _, state := range stateSeed {
+		_, err := dbPool.Exec(context.Background(),
+			"INSERT INTO states (code, name) VALUES ($1, $2) ON CONFLICT (code) DO NOTHING",
+			state.Code, state.Name)
+		if err != nil {
+			log.Fatalf("Failed to seed state %s: %v", state.Code, err)
+		}
+		for _, r := range state.Ranges {
+			_, err := dbPool.Exec(context.Background(), `
+				INSERT INTO state_pincode_ranges (state_code, pin_from, pin_to)
+				VALUES ($1, $2, $3)
+				ON CONFLICT (state_code, pin_from, pin_to) DO NOTHING
+			`, state.Code, r.From, r.To)
+			if err != nil {
+				log.Fatalf("Failed to seed PIN code range for state %s: %v", state.Code, err)
+			}
+		}
+	}
+
+	if err := loadStateMaster(); err != nil {
+		log.Fatalf("Failed to load state master: %v", err)
+	}
+}
+
+// loadStateMaster reads the states and PIN code ranges into memory
+func loadStateMaster() error {
+	states := make(map[string]*models.State)
+
+	rows, err := dbPool.Query(context.Background(), "SELECT code, name FROM states")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var s models.State
+		if err := rows.Scan(&s.Code, &s.Name); err != nil {
+			rows.Close()
+			return err
+		}
+		states[s.Code] = &s
+	}
+	rows.Close()
+
+	rows, err = dbPool.Query(context.Background(),
+		"SELECT state_code, pin_from, pin_to FROM state_pincode_ranges ORDER BY pin_from")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var code string
+		var r models.PincodeRange
+		if err := rows.Scan(&code, &r.From, &r.To); err != nil {
+			return err
+		}
+		if s, ok := states[code]; ok {
+			s.Ranges = append(s.Ranges, r)
+		}
+	}
+
+	stateMasterMu.Lock()
+	stateMaster = states
+	stateMasterMu.Unlock()
+	return nil
+}
+
+// lookupState returns the state with the given GST state code
+func lookupState(code string) (*models.State, bool) {
+	stateMasterMu.RLock()
+	defer stateMasterMu.RUnlock()
+	s, ok := stateMaster[code]
+	return s, ok
+}
+
+// statesWithPincode returns the states whose PIN code ranges cover pin
+func statesWithPincode(pin int) []*models.State {
+	stateMasterMu.RLock()
+	defer stateMasterMu.RUnlock()
+	states := make([]*models.State, 0, 1)
+	for _, s := range stateMaster {
+		if len(s.Ranges) > 0 && s.HasPincode(pin) {
+			states = append(states, s)
+		}
+	}
+	return states
+}
+
+// resolveStateCode returns the GST state code for a value that is either a
+// state code (spreadsheets may drop the leading zero) or a state name
+func resolveStateCode(value string) (string, bool) {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		value = fmt.Sprintf("%02d", n)
+		_, ok := lookupState(value)
+		return value, ok
+	}
+
+	stateMasterMu.RLock()
+	defer stateMasterMu.RUnlock()
+	for _, s := range stateMaster {
+		if strings.EqualFold(s.Name, value) {
+			return s.Code, true
+		}
+	}
+	return "", false
+}
+
+// stateName returns the state name for a value that is either a GST state
+// code or a state name, as verification APIs give either. Names missing from
+// the master are kept; unknown codes give an empty name.
+func stateName(value string) string {
+	code, ok := resolveStateCode(value)
+	if !ok {
+		if _, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			return ""
+		}
+		return strings.TrimSpace(value)
+	}
+	s, _ := lookupState(code)
+	return s.Name
+}
+
+// validateStateCodes checks seller and buyer state codes, place of supply,
+// and that each PIN code belongs to the declared state
+func validateStateCodes(invoice *models.EInvoice) error {
+	seller, ok := lookupState(invoice.SellerDtls.Stcd)
+	if !ok || seller.Code == models.ForeignStateCode {
+		return fmt.Errorf("invalid seller state code %q", invoice.SellerDtls.Stcd)
+	}
+	if !seller.HasPincode(invoice.SellerDtls.Pin) {
+		return fmt.Errorf("seller PIN code %d does not belong to %s", invoice.SellerDtls.Pin, seller.Name)
+	}
+
+	buyer, ok := lookupState(invoice.BuyerDtls.Stcd)
+	if !ok {
+		return fmt.Errorf("invalid buyer state code %q", invoice.BuyerDtls.Stcd)
+	}
+	if !buyer.HasPincode(invoice.BuyerDtls.Pin) {
+		if buyer.Code == models.ForeignStateCode {
+			return fmt.Errorf("buyer PIN code must be %d for buyers outside India", models.ForeignPincode)
+		}
+		return fmt.Errorf("buyer PIN code %d does not belong to %s", invoice.BuyerDtls.Pin, buyer.Name)
+	}
+
+	if _, ok := lookupState(invoice.BuyerDtls.Pos); !ok {
+		return fmt.Errorf("invalid place of supply %q", invoice.BuyerDtls.Pos)
+	}
+
+	return nil
+}
+
+// handleGetStates returns the GST state code master with PIN code ranges
+func handleGetStates(c *gin.Context) {
+	stateMasterMu.RLock()
+	states := make([]*models.State, 0, len(stateMaster))
+	for _, s := range stateMaster {
+		states = append(states, s)
+	}
+	stateMasterMu.RUnlock()
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Code < states[j].Code })
+
+	c.JSON(http.StatusOK, gin.H{"states": states})
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// maxInvoiceTags is the most tags one invoice can carry
+const maxInvoiceTags = 20
+
+// invoiceTagNamesSQL selects the names of an invoice's tags, in name order,
+// in a query over the invoices table
+const invoiceTagNamesSQL = `COALESCE((SELECT array_agg(t.name ORDER BY t.name)
+	FROM invoice_tags it JOIN tags t ON t.id = it.tag_id WHERE it.invoice_id = invoices.id), '{}')`
+
+// invoiceTagListSQL is invoiceTagNamesSQL as one comma-separated string, for exports
+const invoiceTagListSQL = `COALESCE((SELECT string_agg(t.name, ', ' ORDER BY t.name)
+	FROM invoice_tags it JOIN tags t ON t.id = it.tag_id WHERE it.invoice_id = invoices.id), '')`
+
+// createTagTables creates the account's tags and the tags put on invoices
+func createTagTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS tags (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			name VARCHAR(50) NOT NULL,
+			color VARCHAR(7) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create tags table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE UNIQUE INDEX IF NOT EXISTS idx_tags_user_name ON tags (user_id, LOWER(name))")
+	if err != nil {
+		log.Fatalf("Failed to create tags index: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS invoice_tags (
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			tag_id INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (invoice_id, tag_id)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_tags table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_invoice_tags_tag ON invoice_tags (tag_id)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice_tags index: %v", err)
+	}
+}
+
+// parseTagFilter reads the tag query parameter, repeated or comma-separated,
+// as lowercase names without repeats
+func parseTagFilter(c *gin.Context) []string {
+	tags := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, value := range c.QueryArray("tag") {
+		for _, name := range strings.Split(value, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name != "" && !seen[name] {
+				seen[name] = true
+				tags = append(tags, name)
+			}
+		}
+	}
+	return tags
+}
+
+// handleGetTags returns the account's tags with the number of invoices carrying each
+func handleGetTags(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT t.id, t.user_id, t.name, t.color, t.created_at,
+			(SELECT COUNT(*) FROM invoice_tags it WHERE it.tag_id = t.id)
+		FROM tags t WHERE t.user_id = $1 ORDER BY LOWER(t.name)
+	`, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	defer rows.Close()
+
+	tags := make([]models.Tag, 0)
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Color, &t.CreatedAt, &t.InvoiceCount); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read tag data"})
+			return
+		}
+		tags = append(tags, t)
+	}
+	if rowsFailed(c, rows, "Failed to fetch tags") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"tags": tags})
+}
+
+// bindTag parses and validates a tag from the request body
+func bindTag(c *gin.Context) (*models.Tag, bool) {
+	var t models.Tag
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag data: " + err.Error()})
+		return nil, false
+	}
+	if err := t.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "field": "name"})
+		return nil, false
+	}
+	return &t, true
+}
+
+// handleCreateTag creates a tag
+func handleCreateTag(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	t, ok := bindTag(c)
+	if !ok {
+		return
+	}
+
+	var id int
+	err := dbPool.QueryRow(c.Request.Context(),
+		"INSERT INTO tags (user_id, name, color) VALUES ($1, $2, $3) RETURNING id",
+		userID, t.Name, t.Color).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A tag with this name already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating tag: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create tag"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Tag created successfully",
+		"tag_id":  id,
+	})
+}
+
+// handleUpdateTag renames or recolours a tag; invoices keep carrying it
+func handleUpdateTag(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return
+	}
+	t, ok := bindTag(c)
+	if !ok {
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE tags SET name = $1, color = $2 WHERE id = $3 AND user_id = $4",
+		t.Name, t.Color, id, userID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A tag with this name already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating tag %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tag"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tag updated successfully",
+		"tag_id":  id,
+	})
+}
+
+// handleDeleteTag deletes a tag, removing it from every invoice
+func handleDeleteTag(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM tags WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete tag"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tag deleted successfully",
+		"tag_id":  id,
+	})
+}
+
+// invoiceTags returns the tags of an invoice in name order
+func invoiceTags(ctx context.Context, invoiceID int) ([]models.Tag, error) {
+	rows, err := dbPool.Query(ctx, `
+		SELECT t.id, t.user_id, t.name, t.color, t.created_at
+		FROM invoice_tags it JOIN tags t ON t.id = it.tag_id
+		WHERE it.invoice_id = $1 ORDER BY LOWER(t.name)
+	`, invoiceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make([]models.Tag, 0)
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Color, &t.CreatedAt); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+	return tags, rows.Err()
+}
+
+// invoiceExists reports whether the account has the invoice
+func invoiceExists(ctx context.Context, userID, invoiceID int) (bool, error) {
+	var exists bool
+	err := dbPool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", invoiceID, userID).Scan(&exists)
+	return exists, err
+}
+
+// handleGetInvoiceTags returns the tags of an invoice
+func handleGetInvoiceTags(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+	exists, err := invoiceExists(ctx, userID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	tags, err := invoiceTags(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"invoice_id": id, "tags": tags})
+}
+
+// handleSetInvoiceTags replaces the tags of an invoice with the named ones,
+// creating tags that do not exist yet. Tags are bookkeeping rather than part
+// of the invoice, so exported and registered invoices can be tagged too.
+func handleSetInvoiceTags(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+	var req struct {
+		Tags []string `json:"tags"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	names := make([]string, 0, len(req.Tags))
+	seen := make(map[string]bool)
+	for _, name := range req.Tags {
+		t := models.Tag{Name: name}
+		if err := t.Validate(); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "field": "tags"})
+			return
+		}
+		if key := strings.ToLower(t.Name); !seen[key] {
+			seen[key] = true
+			names = append(names, t.Name)
+		}
+	}
+	if len(names) > maxInvoiceTags {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An invoice can carry at most 20 tags", "field": "tags"})
+		return
+	}
+
+	exists, err := invoiceExists(ctx, userID, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	err = pgx.BeginFunc(ctx, dbPool, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			INSERT INTO tags (user_id, name)
+			SELECT $1, n FROM unnest($2::text[]) n
+			ON CONFLICT (user_id, LOWER(name)) DO NOTHING
+		`, userID, names)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, "DELETE FROM invoice_tags WHERE invoice_id = $1", id); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `
+			INSERT INTO invoice_tags (invoice_id, tag_id)
+			SELECT $1, t.id FROM tags t
+			WHERE t.user_id = $2 AND LOWER(t.name) IN (SELECT LOWER(n) FROM unnest($3::text[]) n)
+		`, id, userID, names)
+		return err
+	})
+	if err != nil {
+		log.Printf("Error tagging invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
+
+	tags, err := invoiceTags(ctx, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch tags"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Tags updated successfully",
+		"invoice_id": id,
+		"tags":       tags,
+	})
+}
+
+// handleTagInvoices puts a tag on many invoices at once, or with remove
+// takes it off them. Invoices of other accounts are skipped.
+func handleTagInvoices(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag ID"})
+		return
+	}
+	var req struct {
+		InvoiceIDs []int `json:"invoice_ids" binding:"required"`
+		Remove     bool  `json:"remove"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invoice_ids is required"})
+		return
+	}
+	if len(req.InvoiceIDs) > maxSelectedExport {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "At most 1000 invoices can be tagged at once"})
+		return
+	}
+
+	var exists bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT EXISTS (SELECT 1 FROM tags WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag not found or not authorized"})
+		return
+	}
+
+	var sql string
+	if req.Remove {
+		sql = `DELETE FROM invoice_tags it USING invoices i
+			WHERE it.tag_id = $1 AND it.invoice_id = i.id AND i.user_id = $2 AND i.id = ANY($3)`
+	} else {
+		sql = `INSERT INTO invoice_tags (invoice_id, tag_id)
+			SELECT i.id, $1 FROM invoices i WHERE i.user_id = $2 AND i.id = ANY($3)
+			ON CONFLICT DO NOTHING`
+	}
+	result, err := dbPool.Exec(ctx, sql, id, userID, req.InvoiceIDs)
+	if err != nil {
+		log.Printf("Error tagging invoices with tag %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update tags"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Tags updated successfully",
+		"tag_id":  id,
+		"changed": result.RowsAffected(),
+	})
+}
@@ -0,0 +1,874 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// eventInvoiceEwbVehicleUpdated is recorded when the vehicle in Part-B of an
+// invoice's e-way bill is changed
+const eventInvoiceEwbVehicleUpdated = "invoice.ewb_vehicle_updated"
+
+// ewbNoRegex matches e-way bill numbers
+var ewbNoRegex = regexp.MustCompile(`^[0-9]{12}$`)
+
+// ewbValidUptoLayout is the layout of the validity returned by the e-way bill API
+const ewbValidUptoLayout = "02/01/2006 03:04:05 PM"
+
+// errEwbAPIDisabled is returned when no e-way bill API is configured
+var errEwbAPIDisabled = errors.New("e-way bill API is not configured")
+
+// ewbHTTPClient is used for all calls to the e-way bill API
+var ewbHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// createTransportTables creates the transporter and vehicle masters and the
+// e-way bill vehicle updates
+func createTransportTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS transporters (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			transporter_id VARCHAR(15) NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, transporter_id)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create transporters table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS vehicles (
+			id SERIAL PRIMARY KEY,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			vehicle_no VARCHAR(20) NOT NULL,
+			vehicle_type VARCHAR(1) NOT NULL DEFAULT 'R',
+			description VARCHAR(255) NOT NULL DEFAULT '',
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			UNIQUE (user_id, vehicle_no)
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create vehicles table: %v", err)
+	}
+
+	// The e-way bill number is not part of the e-invoice schema
+	_, err = dbPool.Exec(context.Background(),
+		"ALTER TABLE invoices ADD COLUMN IF NOT EXISTS ewb_no VARCHAR(12)")
+	if err != nil {
+		log.Fatalf("Failed to add ewb_no column: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS ewb_vehicle_updates (
+			id SERIAL PRIMARY KEY,
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			ewb_no VARCHAR(12) NOT NULL,
+			vehicle_no VARCHAR(20) NOT NULL DEFAULT '',
+			vehicle_type VARCHAR(1) NOT NULL DEFAULT 'R',
+			from_place VARCHAR(50) NOT NULL,
+			from_state VARCHAR(2) NOT NULL,
+			reason_code INTEGER NOT NULL,
+			reason_remarks VARCHAR(50) NOT NULL,
+			trans_mode VARCHAR(1) NOT NULL,
+			trans_doc_no VARCHAR(15) NOT NULL DEFAULT '',
+			trans_doc_dt VARCHAR(10) NOT NULL DEFAULT '',
+			submitted BOOLEAN NOT NULL DEFAULT FALSE,
+			valid_until TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create ewb_vehicle_updates table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_ewb_vehicle_updates_invoice ON ewb_vehicle_updates (invoice_id, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create ewb_vehicle_updates index: %v", err)
+	}
+}
+
+// handleGetTransporters returns the account's transporters
+func handleGetTransporters(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT id, user_id, transporter_id, name, created_at FROM transporters WHERE user_id = $1 ORDER BY LOWER(name)",
+		userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transporters"})
+		return
+	}
+	defer rows.Close()
+
+	transporters := make([]models.Transporter, 0)
+	for rows.Next() {
+		var t models.Transporter
+		if err := rows.Scan(&t.ID, &t.UserID, &t.TransporterID, &t.Name, &t.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read transporter data"})
+			return
+		}
+		transporters = append(transporters, t)
+	}
+	if rowsFailed(c, rows, "Failed to fetch transporters") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"transporters": transporters})
+}
+
+// bindTransporter parses and validates a transporter from the request body
+func bindTransporter(c *gin.Context) (*models.Transporter, bool) {
+	var t models.Transporter
+	if err := c.ShouldBindJSON(&t); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transporter data: " + err.Error()})
+		return nil, false
+	}
+	if err := t.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &t, true
+}
+
+// handleCreateTransporter creates a transporter
+func handleCreateTransporter(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	t, ok := bindTransporter(c)
+	if !ok {
+		return
+	}
+
+	var id int
+	err := dbPool.QueryRow(c.Request.Context(),
+		"INSERT INTO transporters (user_id, transporter_id, name) VALUES ($1, $2, $3) RETURNING id",
+		userID, t.TransporterID, t.Name).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A transporter with this transporter ID already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating transporter: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create transporter"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message": "Transporter created successfully",
+		"id":      id,
+	})
+}
+
+// handleUpdateTransporter updates a transporter; e-way bill details already
+// on invoices keep the values they were made with
+func handleUpdateTransporter(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transporter ID"})
+		return
+	}
+	t, ok := bindTransporter(c)
+	if !ok {
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE transporters SET transporter_id = $1, name = $2 WHERE id = $3 AND user_id = $4",
+		t.TransporterID, t.Name, id, userID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A transporter with this transporter ID already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating transporter %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update transporter"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transporter not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transporter updated successfully",
+		"id":      id,
+	})
+}
+
+// handleDeleteTransporter deletes a transporter
+func handleDeleteTransporter(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid transporter ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM transporters WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete transporter"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Transporter not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "Transporter deleted successfully",
+		"id":      id,
+	})
+}
+
+// handleGetVehicles returns the account's vehicles
+func handleGetVehicles(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	rows, err := dbPool.Query(c.Request.Context(),
+		"SELECT id, user_id, vehicle_no, vehicle_type, description, created_at FROM vehicles WHERE user_id = $1 ORDER BY vehicle_no",
+		userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicles"})
+		return
+	}
+	defer rows.Close()
+
+	vehicles := make([]models.Vehicle, 0)
+	for rows.Next() {
+		var v models.Vehicle
+		if err := rows.Scan(&v.ID, &v.UserID, &v.VehicleNo, &v.VehicleType, &v.Description, &v.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read vehicle data"})
+			return
+		}
+		vehicles = append(vehicles, v)
+	}
+	if rowsFailed(c, rows, "Failed to fetch vehicles") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"vehicles": vehicles})
+}
+
+// bindVehicle parses and validates a vehicle from the request body
+func bindVehicle(c *gin.Context) (*models.Vehicle, bool) {
+	var v models.Vehicle
+	if err := c.ShouldBindJSON(&v); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vehicle data: " + err.Error()})
+		return nil, false
+	}
+	if err := v.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	return &v, true
+}
+
+// handleCreateVehicle creates a vehicle
+func handleCreateVehicle(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	v, ok := bindVehicle(c)
+	if !ok {
+		return
+	}
+
+	var id int
+	err := dbPool.QueryRow(c.Request.Context(),
+		"INSERT INTO vehicles (user_id, vehicle_no, vehicle_type, description) VALUES ($1, $2, $3, $4) RETURNING id",
+		userID, v.VehicleNo, v.VehicleType, v.Description).Scan(&id)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A vehicle with this number already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error creating vehicle: %v", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create vehicle"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"message":    "Vehicle created successfully",
+		"vehicle_id": id,
+		"vehicle_no": v.VehicleNo,
+	})
+}
+
+// handleUpdateVehicle updates a vehicle
+func handleUpdateVehicle(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vehicle ID"})
+		return
+	}
+	v, ok := bindVehicle(c)
+	if !ok {
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"UPDATE vehicles SET vehicle_no = $1, vehicle_type = $2, description = $3 WHERE id = $4 AND user_id = $5",
+		v.VehicleNo, v.VehicleType, v.Description, id, userID)
+	if isUniqueViolation(err) {
+		c.JSON(http.StatusConflict, gin.H{"error": "A vehicle with this number already exists"})
+		return
+	}
+	if err != nil {
+		log.Printf("Error updating vehicle %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vehicle"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vehicle not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Vehicle updated successfully",
+		"vehicle_id": id,
+		"vehicle_no": v.VehicleNo,
+	})
+}
+
+// handleDeleteVehicle deletes a vehicle
+func handleDeleteVehicle(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vehicle ID"})
+		return
+	}
+
+	result, err := dbPool.Exec(c.Request.Context(),
+		"DELETE FROM vehicles WHERE id = $1 AND user_id = $2", id, userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete vehicle"})
+		return
+	}
+	if result.RowsAffected() == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Vehicle not found or not authorized"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":    "Vehicle deleted successfully",
+		"vehicle_id": id,
+	})
+}
+
+// findTransporter returns one of the account's transporters
+func findTransporter(ctx context.Context, userID, id int) (*models.Transporter, error) {
+	t := models.Transporter{ID: id, UserID: userID}
+	err := dbPool.QueryRow(ctx,
+		"SELECT transporter_id, name, created_at FROM transporters WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&t.TransporterID, &t.Name, &t.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+// findVehicle returns one of the account's vehicles
+func findVehicle(ctx context.Context, userID, id int) (*models.Vehicle, error) {
+	v := models.Vehicle{ID: id, UserID: userID}
+	err := dbPool.QueryRow(ctx,
+		"SELECT vehicle_no, vehicle_type, description, created_at FROM vehicles WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&v.VehicleNo, &v.VehicleType, &v.Description, &v.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// ewbDetailsRequest selects the transporter and vehicle of an invoice's
+// e-way bill from the masters
+type ewbDetailsRequest struct {
+	// Transporter and Vehicle are the IDs of master records; 0 leaves them out
+	Transporter int    `json:"transporter"`
+	Vehicle     int    `json:"vehicle"`
+	TransMode   string `json:"trans_mode"`
+	// Distance in km; 0 takes the PIN-to-PIN distance
+	Distance   int    `json:"distance"`
+	TransDocNo string `json:"trans_doc_no"`
+	TransDocDt string `json:"trans_doc_dt"`
+}
+
+// handleSetInvoiceEwbDetails sets the e-way bill details of a draft invoice
+// from the transporter and vehicle masters, so that the e-way bill is
+// generated with the IRN
+func handleSetInvoiceEwbDetails(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+	var req ewbDetailsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	ewb := models.EwbDtls{
+		TransMode:  firstNonEmpty(strings.TrimSpace(req.TransMode), "1"),
+		Distance:   req.Distance,
+		TransDocNo: strings.TrimSpace(req.TransDocNo),
+		TransDocDt: strings.TrimSpace(req.TransDocDt),
+	}
+	if req.Transporter != 0 {
+		t, err := findTransporter(ctx, userID, req.Transporter)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Transporter not found or not authorized", "field": "transporter"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch transporter"})
+			return
+		}
+		ewb.TransId, ewb.TransName = t.TransporterID, t.Name
+	}
+	if req.Vehicle != 0 {
+		v, err := findVehicle(ctx, userID, req.Vehicle)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vehicle not found or not authorized", "field": "vehicle"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicle"})
+			return
+		}
+		ewb.VehNo, ewb.VehType = v.VehicleNo, v.VehicleType
+	}
+	if ewb.TransId == "" && ewb.VehNo == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "An e-way bill needs a transporter or a vehicle"})
+		return
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	var invoiceJSON []byte
+	var status, irn, invoiceNo string
+	var exported bool
+	var invoiceDate time.Time
+	err = tx.QueryRow(ctx,
+		"SELECT invoice_json, status, exported, COALESCE(irn, ''), invoice_no, invoice_date FROM invoices WHERE id = $1 AND user_id = $2 FOR UPDATE",
+		id, userID).Scan(&invoiceJSON, &status, &exported, &irn, &invoiceNo, &invoiceDate)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
+		return
+	}
+	if isInvoiceLocked(exported, irn) {
+		c.JSON(http.StatusConflict, gin.H{"error": lockedInvoiceError, "locked": true})
+		return
+	}
+	if status != models.InvoiceStatusDraft {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Only draft invoices can be edited; invoice is %s", status)})
+		return
+	}
+	// Drafts dated in a locked period can only be changed with an admin override
+	periodOverride, ok := checkPeriodLock(c, userID, invoiceDate)
+	if !ok {
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+	invoice.EwbDtls = &ewb
+	// Fills a distance of 0 from the PIN-to-PIN distances
+	warnings, err := validateInvoice(ctx, userID, &invoice, status)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice data: " + err.Error()})
+		return
+	}
+
+	invoiceJSON, err = json.Marshal(invoice)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to serialize invoice"})
+		return
+	}
+	_, err = tx.Exec(ctx,
+		"UPDATE invoices SET invoice_json = $1, qr_code = NULL, qr_object_key = NULL, updated_at = NOW() WHERE id = $2",
+		invoiceJSON, id)
+	if err != nil {
+		log.Printf("Error setting e-way bill details of invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice"})
+		return
+	}
+	recordInvoiceEvent(ctx, tx, eventInvoiceUpdated, id)
+	periodOverride.InvoiceID, periodOverride.InvoiceNo, periodOverride.Action = id, invoiceNo, "update"
+	recordPeriodLockOverride(ctx, tx, userID, periodOverride)
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update invoice"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":       "E-way bill details updated successfully",
+		"invoice_id":    id,
+		"ewb":           invoice.EwbDtls,
+		"validity_days": ewbValidityDays(invoice.EwbDtls.Distance, invoice.EwbDtls.VehType == "O"),
+		"warnings":      warnings,
+	})
+}
+
+// ewbVehicleRequest is a change of vehicle in Part-B of an e-way bill
+type ewbVehicleRequest struct {
+	// EwbNo defaults to the e-way bill number stored on the invoice
+	EwbNo string `json:"ewb_no"`
+	// Vehicle is the ID of a vehicle master record; otherwise VehicleNo and
+	// VehicleType give the vehicle
+	Vehicle     int    `json:"vehicle"`
+	VehicleNo   string `json:"vehicle_no"`
+	VehicleType string `json:"vehicle_type"`
+	FromPlace   string `json:"from_place"`
+	// FromState is the state the goods are in, by code or name
+	FromState     string `json:"from_state"`
+	ReasonCode    int    `json:"reason_code"`
+	ReasonRemarks string `json:"reason_remarks"`
+	TransMode     string `json:"trans_mode"`
+	TransDocNo    string `json:"trans_doc_no"`
+	TransDocDt    string `json:"trans_doc_dt"`
+}
+
+// ewbVehicleAPIRequest is the e-way bill API's VEHEWB request
+type ewbVehicleAPIRequest struct {
+	EwbNo        int64  `json:"ewbNo"`
+	VehicleNo    string `json:"vehicleNo,omitempty"`
+	FromPlace    string `json:"fromPlace"`
+	FromState    int    `json:"fromState"`
+	ReasonCode   string `json:"reasonCode"`
+	ReasonRem    string `json:"reasonRem"`
+	TransDocNo   string `json:"transDocNo,omitempty"`
+	TransDocDate string `json:"transDocDate,omitempty"`
+	TransMode    string `json:"transMode"`
+	VehicleType  string `json:"vehicleType,omitempty"`
+}
+
+// submitEwbVehicle updates Part-B of an e-way bill through the e-way bill
+// API and returns the validity it reports. EWB_API_URL is the base URL of the
+// API, e.g. https://gsp.example.com/ewaybillapi/v1.03/ewayapi
+func submitEwbVehicle(ctx context.Context, u *models.EwbVehicleUpdate) (*time.Time, error) {
+	apiURL := os.Getenv("EWB_API_URL")
+	if apiURL == "" {
+		return nil, errEwbAPIDisabled
+	}
+
+	ewbNo, _ := strconv.ParseInt(u.EwbNo, 10, 64)
+	fromState, _ := strconv.Atoi(u.FromState)
+	body, err := json.Marshal(ewbVehicleAPIRequest{
+		EwbNo:        ewbNo,
+		VehicleNo:    u.VehicleNo,
+		FromPlace:    u.FromPlace,
+		FromState:    fromState,
+		ReasonCode:   strconv.Itoa(u.ReasonCode),
+		ReasonRem:    u.ReasonRemarks,
+		TransDocNo:   u.TransDocNo,
+		TransDocDate: u.TransDocDt,
+		TransMode:    u.TransMode,
+		VehicleType:  u.VehicleType,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost,
+		strings.TrimSuffix(apiURL, "/")+"?action=VEHEWB", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if apiKey := os.Getenv("EWB_API_KEY"); apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+
+	resp, err := ewbHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("e-way bill API request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read e-way bill API response: %w", err)
+	}
+
+	var result struct {
+		ValidUpto string `json:"validUpto"`
+		Error     struct {
+			ErrorCodes string `json:"errorCodes"`
+			Message    string `json:"message"`
+		} `json:"error"`
+	}
+	parseErr := json.Unmarshal(respBody, &result)
+	if resp.StatusCode != http.StatusOK {
+		if parseErr == nil && (result.Error.Message != "" || result.Error.ErrorCodes != "") {
+			return nil, fmt.Errorf("e-way bill API rejected the vehicle update (%s): %s",
+				result.Error.ErrorCodes, result.Error.Message)
+		}
+		return nil, fmt.Errorf("e-way bill API returned status %d", resp.StatusCode)
+	}
+	if parseErr != nil {
+		return nil, fmt.Errorf("failed to parse e-way bill API response: %w", parseErr)
+	}
+
+	// The update stands even when the validity cannot be read
+	validUntil, err := time.ParseInLocation(ewbValidUptoLayout, result.ValidUpto, istLocation)
+	if err != nil {
+		return nil, nil
+	}
+	return &validUntil, nil
+}
+
+// isInvoiceDate reports whether value is a date in the DD/MM/YYYY format of invoices
+func isInvoiceDate(value string) bool {
+	_, err := time.Parse(invoiceDateLayout, value)
+	return err == nil
+}
+
+// handleUpdateEwbVehicle records a change of vehicle in Part-B of an
+// invoice's e-way bill while the goods are in transit, such as after a
+// breakdown or transhipment. With EWB_API_URL set, the change is submitted
+// to the e-way bill system first, except for sandbox invoices; otherwise it
+// is recorded for updating on the portal.
+func handleUpdateEwbVehicle(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+	var req ewbVehicleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return
+	}
+
+	var status, storedEwbNo string
+	var sandbox bool
+	err = dbPool.QueryRow(ctx,
+		"SELECT status, COALESCE(ewb_no, ''), sandbox FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&status, &storedEwbNo, &sandbox)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
+		return
+	}
+	if status == models.InvoiceStatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Invoice is cancelled"})
+		return
+	}
+
+	u := models.EwbVehicleUpdate{
+		InvoiceID:     id,
+		EwbNo:         firstNonEmpty(strings.TrimSpace(req.EwbNo), storedEwbNo),
+		VehicleNo:     models.NormalizeVehicleNo(req.VehicleNo),
+		VehicleType:   strings.ToUpper(strings.TrimSpace(req.VehicleType)),
+		FromPlace:     strings.TrimSpace(req.FromPlace),
+		ReasonCode:    req.ReasonCode,
+		ReasonRemarks: strings.TrimSpace(req.ReasonRemarks),
+		TransMode:     firstNonEmpty(strings.TrimSpace(req.TransMode), "1"),
+		TransDocNo:    strings.TrimSpace(req.TransDocNo),
+		TransDocDt:    strings.TrimSpace(req.TransDocDt),
+	}
+	if req.Vehicle != 0 {
+		v, err := findVehicle(ctx, userID, req.Vehicle)
+		if errors.Is(err, pgx.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Vehicle not found or not authorized", "field": "vehicle"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicle"})
+			return
+		}
+		u.VehicleNo, u.VehicleType = v.VehicleNo, v.VehicleType
+	}
+	if u.VehicleType == "" {
+		u.VehicleType = "R"
+	}
+	fromState, found := resolveStateCode(req.FromState)
+
+	field, message := "", ""
+	switch {
+	case !ewbNoRegex.MatchString(u.EwbNo):
+		field, message = "ewb_no", "e-way bill number must be 12 digits"
+	case u.TransMode != "1" && u.TransMode != "2" && u.TransMode != "3" && u.TransMode != "4":
+		field, message = "trans_mode", "transport mode must be 1 (road), 2 (rail), 3 (air) or 4 (ship)"
+	case u.TransMode == "1" && u.VehicleNo == "":
+		field, message = "vehicle_no", "a vehicle is required for road transport"
+	case u.VehicleNo != "" && !models.IsValidVehicleNo(u.VehicleNo):
+		field, message = "vehicle_no", "vehicle number must be in a format the e-way bill system accepts, such as KA12AB1234 or 22BH1234AA"
+	case u.VehicleType != "R" && u.VehicleType != "O":
+		field, message = "vehicle_type", "vehicle type must be R (regular) or O (over-dimensional cargo)"
+	case u.TransMode != "1" && (u.TransDocNo == "" || u.TransDocDt == ""):
+		field, message = "trans_doc_no", "a transport document number and date are required for rail, air and ship"
+	case len(u.TransDocNo) > 15:
+		field, message = "trans_doc_no", "transport document number must be at most 15 characters"
+	case u.TransDocDt != "" && !isInvoiceDate(u.TransDocDt):
+		field, message = "trans_doc_dt", "transport document date must be DD/MM/YYYY"
+	case u.FromPlace == "" || utf8.RuneCountInString(u.FromPlace) > 50:
+		field, message = "from_place", "from place must be 1 to 50 characters"
+	case !found || fromState == models.ForeignStateCode:
+		field, message = "from_state", "from state must be a known state code or name"
+	case models.EwbVehicleReasons[u.ReasonCode] == "":
+		field, message = "reason_code", "reason code must be 1 (breakdown), 2 (transhipment), 3 (others) or 4 (first time)"
+	case u.ReasonRemarks == "" || utf8.RuneCountInString(u.ReasonRemarks) > 50:
+		field, message = "reason_remarks", "reason remarks must be 1 to 50 characters"
+	}
+	if message != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": message, "field": field})
+		return
+	}
+	u.FromState = fromState
+
+	if !sandbox {
+		validUntil, err := submitEwbVehicle(ctx, &u)
+		switch {
+		case err == nil:
+			u.Submitted, u.ValidUntil = true, validUntil
+		case !errors.Is(err, errEwbAPIDisabled):
+			log.Printf("Error updating e-way bill %s vehicle: %v", u.EwbNo, err)
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tx, err := dbPool.Begin(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	err = tx.QueryRow(ctx, `
+		INSERT INTO ewb_vehicle_updates (invoice_id, ewb_no, vehicle_no, vehicle_type, from_place, from_state,
+			reason_code, reason_remarks, trans_mode, trans_doc_no, trans_doc_dt, submitted, valid_until)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at
+	`, id, u.EwbNo, u.VehicleNo, u.VehicleType, u.FromPlace, u.FromState, u.ReasonCode, u.ReasonRemarks,
+		u.TransMode, u.TransDocNo, u.TransDocDt, u.Submitted, u.ValidUntil).Scan(&u.ID, &u.CreatedAt)
+	if err == nil {
+		_, err = tx.Exec(ctx, "UPDATE invoices SET ewb_no = $1 WHERE id = $2", u.EwbNo, id)
+	}
+	if err != nil {
+		log.Printf("Error recording e-way bill vehicle update of invoice %d: %v", id, err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record vehicle update"})
+		return
+	}
+	recordInvoiceEvent(ctx, tx, eventInvoiceEwbVehicleUpdated, id)
+	if err := tx.Commit(ctx); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record vehicle update"})
+		return
+	}
+
+	message = "Vehicle update recorded; update Part-B on the e-way bill portal"
+	if u.Submitted {
+		message = "Vehicle updated on the e-way bill"
+	}
+	c.JSON(http.StatusCreated, gin.H{
+		"message": message,
+		"update":  u,
+	})
+}
+
+// handleGetEwbVehicleUpdates returns the vehicle updates of an invoice's
+// e-way bill, newest first
+func handleGetEwbVehicleUpdates(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var ewbNo string
+	err = dbPool.QueryRow(ctx,
+		"SELECT COALESCE(ewb_no, '') FROM invoices WHERE id = $1 AND user_id = $2", id, userID).Scan(&ewbNo)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch invoice"})
+		return
+	}
+
+	rows, err := dbPool.Query(ctx, `
+		SELECT id, invoice_id, ewb_no, vehicle_no, vehicle_type, from_place, from_state, reason_code,
+			reason_remarks, trans_mode, trans_doc_no, trans_doc_dt, submitted, valid_until, created_at
+		FROM ewb_vehicle_updates WHERE invoice_id = $1 ORDER BY created_at DESC, id DESC
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicle updates"})
+		return
+	}
+	defer rows.Close()
+
+	updates := make([]models.EwbVehicleUpdate, 0)
+	for rows.Next() {
+		var u models.EwbVehicleUpdate
+		if err := rows.Scan(&u.ID, &u.InvoiceID, &u.EwbNo, &u.VehicleNo, &u.VehicleType, &u.FromPlace,
+			&u.FromState, &u.ReasonCode, &u.ReasonRemarks, &u.TransMode, &u.TransDocNo, &u.TransDocDt,
+			&u.Submitted, &u.ValidUntil, &u.CreatedAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read vehicle update data"})
+			return
+		}
+		updates = append(updates, u)
+	}
+	if rowsFailed(c, rows, "Failed to fetch vehicle updates") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"invoice_id": id,
+		"ewb_no":     ewbNo,
+		"updates":    updates,
+	})
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// upiRefMaxLen is the longest transaction reference UPI apps accept
+const upiRefMaxLen = 35
+
+// upiQRURI builds the UPI payment link encoded in the dynamic QR code of B2C
+// invoices. Besides the payee and amount it carries the supplier GSTIN,
+// invoice reference and GST breakup required on B2C invoice QR codes.
+func upiQRURI(invoice *models.EInvoice, company *models.CompanyDetails) string {
+	heads := invoiceTaxHeads(invoice)
+	amount := func(v float64) string { return fmt.Sprintf("%.2f", v) }
+
+	ref := invoice.DocDtls.No
+	if len(ref) > upiRefMaxLen {
+		ref = ref[:upiRefMaxLen]
+	}
+
+	params := url.Values{}
+	params.Set("pa", company.UPIVPA)
+	params.Set("pn", firstNonEmpty(company.UPIPayeeName, company.Name))
+	params.Set("am", amount(invoice.AmountPayable()))
+	params.Set("cu", "INR")
+	params.Set("tr", ref)
+	params.Set("tn", "Invoice "+invoice.DocDtls.No)
+	params.Set("gstIn", invoice.SellerDtls.Gstin)
+	params.Set("invoiceNo", invoice.DocDtls.No)
+	params.Set("invoiceDate", invoice.DocDtls.Dt)
+	params.Set("gstBrkUp", fmt.Sprintf("GST:%s|CGST:%s|SGST:%s|IGST:%s|CESS:%s",
+		amount(heads.TotalTax), amount(heads.CGST), amount(heads.SGST), amount(heads.IGST), amount(heads.Cess)))
+
+	// UPI apps expect %20 rather than + for spaces
+	return "upi://pay?" + strings.ReplaceAll(params.Encode(), "+", "%20")
+}
+
+// serveUPIQRCode generates the UPI dynamic QR code of a B2C invoice from the
+// seller company's VPA. It is built on each request so VPA changes apply at once.
+func serveUPIQRCode(c *gin.Context, id, userID int) {
+	ctx := c.Request.Context()
+	var invoiceJSON []byte
+	var status string
+	err := dbPool.QueryRow(ctx,
+		"SELECT invoice_json, status FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceJSON, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if status == models.InvoiceStatusCancelled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Cancelled invoices have no UPI QR code"})
+		return
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+		return
+	}
+	if !invoice.IsB2C() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "UPI QR codes are only generated for B2C invoices"})
+		return
+	}
+
+	company, err := getCompanyByGSTIN(ctx, userID, invoice.SellerDtls.Gstin)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if company == nil || company.UPIVPA == "" {
+		c.JSON(http.StatusNotFound, gin.H{"error": "No UPI VPA is configured for the seller company"})
+		return
+	}
+
+	uri := upiQRURI(&invoice, company)
+	png, err := encodeQR(uri)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate QR code"})
+		return
+	}
+
+	// Always revalidated, since the VPA can change without the invoice changing
+	c.Header("ETag", `"`+qrHash(uri)+`"`)
+	c.Header("Cache-Control", "private, no-cache")
+	c.Header("Content-Type", "image/png")
+	http.ServeContent(c.Writer, c.Request, "", time.Time{}, bytes.NewReader(png))
+}
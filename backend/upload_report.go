@@ -0,0 +1,171 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// uploadRowError describes why a row of an uploaded spreadsheet was not imported
+type uploadRowError struct {
+	Row       int    `json:"row"`
+	InvoiceNo string `json:"invoice_no,omitempty"`
+	Column    string `json:"column,omitempty"`
+	Error     string `json:"error"`
+}
+
+// uploadReport collects row-level errors while a spreadsheet is imported
+type uploadReport struct {
+	header    []string
+	headerRow int
+	errors    []uploadRowError
+	failed    map[int]bool
+}
+
+// newUploadReport creates a report for a sheet whose header is at the given 1-based row
+func newUploadReport(header []string, headerRow int) *uploadReport {
+	return &uploadReport{
+		header:    header,
+		headerRow: headerRow,
+		errors:    make([]uploadRowError, 0),
+		failed:    make(map[int]bool),
+	}
+}
+
+// columnLabel names a zero-based column by its letter and header, e.g. "H (Quantity)"
+func (r *uploadReport) columnLabel(col int) string {
+	name, err := excelize.ColumnNumberToName(col + 1)
+	if err != nil {
+		return ""
+	}
+	if col < len(r.header) && strings.TrimSpace(r.header[col]) != "" {
+		return fmt.Sprintf("%s (%s)", name, strings.TrimSpace(r.header[col]))
+	}
+	return name
+}
+
+// add records an error for a spreadsheet row; col is zero-based or -1 for the whole row
+func (r *uploadReport) add(row int, invoiceNo string, col int, message string) {
+	e := uploadRowError{Row: row, InvoiceNo: invoiceNo, Error: message}
+	if col >= 0 {
+		e.Column = r.columnLabel(col)
+	}
+	r.errors = append(r.errors, e)
+	r.failed[row] = true
+}
+
+// addInvoice records the same error against every row of a failed invoice
+func (r *uploadReport) addInvoice(rows []int, invoiceNo, message string) {
+	for _, row := range rows {
+		r.add(row, invoiceNo, -1, message)
+	}
+}
+
+// parseNumber parses a numeric cell, recording an error when it is missing or invalid
+func (r *uploadReport) parseNumber(cells []string, row int, invoiceNo string, col int) (float64, bool) {
+	value := ""
+	if col >= 0 && col < len(cells) {
+		value = strings.TrimSpace(cells[col])
+	}
+	if value == "" {
+		r.add(row, invoiceNo, col, "value is required")
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		r.add(row, invoiceNo, col, fmt.Sprintf("%q is not a number", value))
+		return 0, false
+	}
+	return n, true
+}
+
+// sorted returns the collected errors ordered by row
+func (r *uploadReport) sorted() []uploadRowError {
+	sort.SliceStable(r.errors, func(i, j int) bool { return r.errors[i].Row < r.errors[j].Row })
+	return r.errors
+}
+
+// isBlankRow reports whether every cell of a spreadsheet row is empty
+func isBlankRow(cells []string) bool {
+	for _, cell := range cells {
+		if strings.TrimSpace(cell) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// errorWorkbook returns a copy of the uploaded rows with an Errors column
+// and the rows that failed to import highlighted
+func (r *uploadReport) errorWorkbook(rows [][]string) (*excelize.File, error) {
+	f := excelize.NewFile()
+	sheet := "Upload Errors"
+	if err := f.SetSheetName("Sheet1", sheet); err != nil {
+		return nil, err
+	}
+
+	width := len(r.header)
+	for _, row := range rows {
+		if len(row) > width {
+			width = len(row)
+		}
+	}
+
+	messages := make(map[int][]string)
+	for _, e := range r.errors {
+		msg := e.Error
+		if e.Column != "" {
+			msg = e.Column + ": " + msg
+		}
+		messages[e.Row] = append(messages[e.Row], msg)
+	}
+
+	headerStyle, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+	if err != nil {
+		return nil, err
+	}
+	failedStyle, err := f.NewStyle(&excelize.Style{
+		Fill: excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{"FFC7CE"}},
+		Font: &excelize.Font{Color: "9C0006"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, row := range rows {
+		rowNum := i + 1
+		values := make([]interface{}, width+1)
+		for j, cell := range row {
+			values[j] = cell
+		}
+		if rowNum == r.headerRow {
+			values[width] = "Errors"
+		} else {
+			values[width] = strings.Join(messages[rowNum], "; ")
+		}
+
+		start, _ := excelize.CoordinatesToCellName(1, rowNum)
+		if err := f.SetSheetRow(sheet, start, &values); err != nil {
+			return nil, err
+		}
+
+		style := 0
+		switch {
+		case rowNum == r.headerRow:
+			style = headerStyle
+		case r.failed[rowNum]:
+			style = failedStyle
+		}
+		if style != 0 {
+			end, _ := excelize.CoordinatesToCellName(width+1, rowNum)
+			if err := f.SetCellStyle(sheet, start, end, style); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return f, nil
+}
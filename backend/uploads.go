@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// multipartOverhead allows for the multipart boundaries and other form fields
+// on top of the file itself when capping the request body
+const multipartOverhead = 1 << 20
+
+// getMaxUploadBytes returns the largest file accepted by upload endpoints
+func getMaxUploadBytes() int64 {
+	mb, err := strconv.Atoi(getEnvWithDefault("MAX_UPLOAD_SIZE_MB", "10"))
+	if err != nil || mb < 1 {
+		log.Printf("Invalid MAX_UPLOAD_SIZE_MB value, defaulting to 10")
+		mb = 10
+	}
+	return int64(mb) << 20
+}
+
+// readUploadedFile streams the named multipart file into memory, rejecting
+// files over the upload limit. On failure it writes the error response and
+// returns false.
+func readUploadedFile(c *gin.Context, field string) (*multipart.FileHeader, []byte, bool) {
+	limit := getMaxUploadBytes()
+	tooLarge := fmt.Sprintf("File exceeds the %d MB upload limit", limit>>20)
+
+	// Cap the request body so an oversized upload is cut off while it streams in
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, limit+multipartOverhead)
+
+	file, err := c.FormFile(field)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+			return nil, nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "No file uploaded"})
+		return nil, nil, false
+	}
+	if file.Size > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+		return nil, nil, false
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to open file"})
+		return nil, nil, false
+	}
+	defer src.Close()
+
+	var buf bytes.Buffer
+	buf.Grow(int(file.Size))
+	if _, err := io.Copy(&buf, io.LimitReader(src, limit+1)); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read file"})
+		return nil, nil, false
+	}
+	if int64(buf.Len()) > limit {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": tooLarge})
+		return nil, nil, false
+	}
+
+	return file, buf.Bytes(), true
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// checkMasterData validates an invoice against the state, PIN code and HSN
+// masters and the distance matrix, returning an error for invalid references
+// and warnings for suspicious values. A blank e-way bill distance is filled
+// in from the matrix.
+func checkMasterData(ctx context.Context, invoice *models.EInvoice) ([]string, error) {
+	if err := validateStateCodes(invoice); err != nil {
+		return nil, err
+	}
+	if err := checkPincodes(ctx, invoice); err != nil {
+		return nil, err
+	}
+	warnings, err := checkHSNCodes(ctx, invoice)
+	if err != nil {
+		return nil, err
+	}
+	return append(warnings, checkEwbDistance(ctx, invoice)...), nil
+}
+
+// validateInvoice validates an invoice stored with the given status and checks
+// it against the masters, returning the warnings it raised. Drafts are
+// validated under their seller company's validation profile, so a lenient
+// company can save incomplete drafts; every other status is validated strictly.
+func validateInvoice(ctx context.Context, userID int, invoice *models.EInvoice, status string) ([]string, error) {
+	applyCompanyLUT(ctx, userID, invoice)
+	profile := models.ValidationStrict
+	if status == models.InvoiceStatusDraft {
+		profile = getCompanyValidationProfile(ctx, userID, invoice.SellerDtls.Gstin)
+	}
+	warnings, err := invoice.ValidateProfile(profile)
+	if err != nil {
+		return nil, err
+	}
+	masterWarnings, err := checkMasterData(ctx, invoice)
+	if err != nil {
+		if profile != models.ValidationLenient {
+			return nil, err
+		}
+		masterWarnings = []string{err.Error()}
+	}
+	return append(warnings, masterWarnings...), nil
+}
+
+// validateForFinalizing checks that an invoice is compliant before it is
+// finalized or sent for approval, whatever profile its draft was saved under
+func validateForFinalizing(ctx context.Context, userID int, invoice *models.EInvoice) error {
+	applyCompanyLUT(ctx, userID, invoice)
+	if err := invoice.Validate(); err != nil {
+		return err
+	}
+	_, err := checkMasterData(ctx, invoice)
+	return err
+}
+
+// applyCompanyLUT records the seller company's LUT on a supply without payment
+// of tax that names none, when the LUT covers the financial year of the
+// invoice date
+func applyCompanyLUT(ctx context.Context, userID int, invoice *models.EInvoice) {
+	if !invoice.IsWithoutPayment() || invoice.LUTNo() != "" {
+		return
+	}
+	date, err := time.Parse(invoiceDateLayout, invoice.DocDtls.Dt)
+	if err != nil {
+		return
+	}
+	co, err := getCompanyByGSTIN(ctx, userID, invoice.SellerDtls.Gstin)
+	if err != nil {
+		if !errors.Is(err, pgx.ErrNoRows) {
+			log.Printf("Error loading company LUT: %v", err)
+		}
+		return
+	}
+	if co.LUTNo != "" && co.LUTFiscalYear == models.FiscalYearOf(date).String() {
+		invoice.SetLUTNo(co.LUTNo)
+	}
+}
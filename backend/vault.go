@@ -0,0 +1,193 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// errVaultDisabled is returned when no master key is configured
+var errVaultDisabled = errors.New("credential storage is not configured; set CREDENTIAL_MASTER_KEYS")
+
+// credentialVault encrypts secrets at rest with envelope encryption: each
+// secret has its own random data key, and the data key is stored wrapped by a
+// master key from the environment, as with a KMS. Rotating the master key
+// means adding a new key, making it active and rewrapping the stored data
+// keys, which leaves the secrets themselves untouched.
+type credentialVault struct {
+	keys   map[string]cipher.AEAD
+	active string
+}
+
+// sealedSecret is a secret encrypted by the vault. WrappedKey and Ciphertext
+// each start with their GCM nonce.
+type sealedSecret struct {
+	KeyID      string
+	WrappedKey []byte
+	Ciphertext []byte
+}
+
+// vault is loaded at startup by initCredentialVault; nil when not configured
+var vault *credentialVault
+
+// newAEAD returns AES-256-GCM with the given key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("key must be 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadCredentialVault reads CREDENTIAL_MASTER_KEYS, a comma-separated list of
+// <key id>:<base64 32-byte key>. CREDENTIAL_MASTER_KEY_ID picks the key new
+// secrets are wrapped with; it defaults to the highest ID.
+func loadCredentialVault() (*credentialVault, error) {
+	v := &credentialVault{keys: make(map[string]cipher.AEAD)}
+	for _, entry := range strings.Split(os.Getenv("CREDENTIAL_MASTER_KEYS"), ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		id, encoded, ok := strings.Cut(entry, ":")
+		if !ok || id == "" {
+			return nil, fmt.Errorf("master key %q must be given as <id>:<base64 key>", entry)
+		}
+		if _, dup := v.keys[id]; dup {
+			return nil, fmt.Errorf("duplicate master key ID %q", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, fmt.Errorf("master key %q: %w", id, err)
+		}
+		v.keys[id] = aead
+	}
+	if len(v.keys) == 0 {
+		return nil, errVaultDisabled
+	}
+
+	v.active = os.Getenv("CREDENTIAL_MASTER_KEY_ID")
+	if v.active == "" {
+		ids := make([]string, 0, len(v.keys))
+		for id := range v.keys {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+		v.active = ids[len(ids)-1]
+	}
+	if _, ok := v.keys[v.active]; !ok {
+		return nil, fmt.Errorf("CREDENTIAL_MASTER_KEY_ID %q is not a configured master key", v.active)
+	}
+	return v, nil
+}
+
+// initCredentialVault loads the master keys. Without them credentials cannot
+// be stored, which is refused in production.
+func initCredentialVault() {
+	v, err := loadCredentialVault()
+	if errors.Is(err, errVaultDisabled) && os.Getenv("APP_ENV") != "production" {
+		log.Println("Warning: CREDENTIAL_MASTER_KEYS not set; storing GSTN/IRP credentials is disabled")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Failed to load credential master keys: %v", err)
+	}
+	vault = v
+	log.Printf("Loaded %d credential master key(s); wrapping with %q", len(v.keys), v.active)
+}
+
+// encrypt seals plaintext with aead under a fresh nonce, which it prepends
+func encrypt(aead cipher.AEAD, plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+// decrypt opens data sealed by encrypt
+func decrypt(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+// wrap encrypts a data key with the active master key. The key ID is bound
+// as additional data so a wrapped key cannot be relabelled.
+func (v *credentialVault) wrap(dataKey []byte) (string, []byte, error) {
+	wrapped, err := encrypt(v.keys[v.active], dataKey, []byte(v.active))
+	return v.active, wrapped, err
+}
+
+// unwrap decrypts the data key of a sealed secret
+func (v *credentialVault) unwrap(s *sealedSecret) ([]byte, error) {
+	master, ok := v.keys[s.KeyID]
+	if !ok {
+		return nil, fmt.Errorf("master key %q is not configured", s.KeyID)
+	}
+	return decrypt(master, s.WrappedKey, []byte(s.KeyID))
+}
+
+// seal encrypts plaintext under a new data key. aad binds the secret to
+// where it is stored, so it cannot be copied to another record.
+func (v *credentialVault) seal(plaintext, aad []byte) (*sealedSecret, error) {
+	dataKey := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encrypt(aead, plaintext, aad)
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := v.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedSecret{KeyID: keyID, WrappedKey: wrapped, Ciphertext: ciphertext}, nil
+}
+
+// open decrypts a sealed secret
+func (v *credentialVault) open(s *sealedSecret, aad []byte) ([]byte, error) {
+	dataKey, err := v.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return decrypt(aead, s.Ciphertext, aad)
+}
+
+// rewrap wraps the data key of a sealed secret with the active master key
+func (v *credentialVault) rewrap(s *sealedSecret) (*sealedSecret, error) {
+	dataKey, err := v.unwrap(s)
+	if err != nil {
+		return nil, err
+	}
+	keyID, wrapped, err := v.wrap(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return &sealedSecret{KeyID: keyID, WrappedKey: wrapped, Ciphertext: s.Ciphertext}, nil
+}
@@ -0,0 +1,576 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"einvoice-app/models"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5"
+)
+
+// errWhatsAppDisabled is returned when the WhatsApp Business API is not configured
+var errWhatsAppDisabled = errors.New("WhatsApp delivery is not configured")
+
+// jobTypeSendInvoiceWhatsApp is the job type that sends an invoice to its
+// buyer on WhatsApp
+const jobTypeSendInvoiceWhatsApp = "invoice.whatsapp"
+
+// WhatsApp message statuses. Messages move forward through them as the
+// WhatsApp Business API reports delivery.
+const (
+	whatsappQueued    = "queued"
+	whatsappSent      = "sent"
+	whatsappDelivered = "delivered"
+	whatsappRead      = "read"
+	whatsappFailed    = "failed"
+)
+
+// whatsappEarlierStatuses are the statuses a message can move to each
+// status from; reports arriving out of order never move a message back
+var whatsappEarlierStatuses = map[string][]string{
+	whatsappSent:      {whatsappQueued},
+	whatsappDelivered: {whatsappQueued, whatsappSent},
+	whatsappRead:      {whatsappQueued, whatsappSent, whatsappDelivered},
+	whatsappFailed:    {whatsappQueued, whatsappSent},
+}
+
+// whatsappHTTPClient is used for all calls to the WhatsApp Business API
+var whatsappHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// whatsappConfig holds the WhatsApp Business API settings read from the environment
+type whatsappConfig struct {
+	apiURL        string
+	phoneNumberID string
+	accessToken   string
+	appSecret     string
+	verifyToken   string
+	// template is the approved message template invoices are sent with;
+	// without one the invoice is sent as a plain document, which WhatsApp
+	// only delivers within 24 hours of the buyer's last message
+	template         string
+	templateLanguage string
+}
+
+// getWhatsAppConfig reads WHATSAPP_PHONE_NUMBER_ID, WHATSAPP_ACCESS_TOKEN,
+// WHATSAPP_APP_SECRET, WHATSAPP_VERIFY_TOKEN, WHATSAPP_TEMPLATE,
+// WHATSAPP_TEMPLATE_LANGUAGE and WHATSAPP_API_URL
+func getWhatsAppConfig() (*whatsappConfig, error) {
+	cfg := &whatsappConfig{
+		apiURL:           strings.TrimRight(getEnvWithDefault("WHATSAPP_API_URL", "https://graph.facebook.com/v21.0"), "/"),
+		phoneNumberID:    os.Getenv("WHATSAPP_PHONE_NUMBER_ID"),
+		accessToken:      os.Getenv("WHATSAPP_ACCESS_TOKEN"),
+		appSecret:        os.Getenv("WHATSAPP_APP_SECRET"),
+		verifyToken:      os.Getenv("WHATSAPP_VERIFY_TOKEN"),
+		template:         os.Getenv("WHATSAPP_TEMPLATE"),
+		templateLanguage: getEnvWithDefault("WHATSAPP_TEMPLATE_LANGUAGE", "en"),
+	}
+	if cfg.phoneNumberID == "" || cfg.accessToken == "" {
+		return nil, errWhatsAppDisabled
+	}
+	return cfg, nil
+}
+
+// createWhatsAppTables creates the per-invoice log of WhatsApp messages sent
+// to buyers
+func createWhatsAppTables() {
+	_, err := dbPool.Exec(context.Background(), `
+		CREATE TABLE IF NOT EXISTS invoice_whatsapp_messages (
+			id SERIAL PRIMARY KEY,
+			invoice_id INTEGER NOT NULL REFERENCES invoice_keys(id) ON DELETE CASCADE,
+			user_id INTEGER NOT NULL REFERENCES users(id),
+			phone VARCHAR(20) NOT NULL,
+			status VARCHAR(20) NOT NULL DEFAULT 'queued',
+			provider_message_id VARCHAR(150) UNIQUE,
+			error TEXT,
+			job_id INTEGER REFERENCES jobs(id) ON DELETE SET NULL,
+			sent_at TIMESTAMP,
+			delivered_at TIMESTAMP,
+			read_at TIMESTAMP,
+			created_at TIMESTAMP NOT NULL DEFAULT NOW(),
+			updated_at TIMESTAMP NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatalf("Failed to create invoice_whatsapp_messages table: %v", err)
+	}
+
+	_, err = dbPool.Exec(context.Background(),
+		"CREATE INDEX IF NOT EXISTS idx_invoice_whatsapp_invoice ON invoice_whatsapp_messages (invoice_id, created_at)")
+	if err != nil {
+		log.Fatalf("Failed to create invoice_whatsapp_messages index: %v", err)
+	}
+
+	registerJobHandler(jobTypeSendInvoiceWhatsApp, runSendInvoiceWhatsAppJob)
+}
+
+// call sends a request to the WhatsApp Business API and decodes the response into out
+func (cfg *whatsappConfig) call(ctx context.Context, path, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.apiURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.accessToken)
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := whatsappHTTPClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach WhatsApp: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		message := "unexpected response"
+		if json.Unmarshal(respBody, &apiErr) == nil && apiErr.Error.Message != "" {
+			message = apiErr.Error.Message
+		}
+		err := fmt.Errorf("WhatsApp returned HTTP %d: %s", resp.StatusCode, message)
+		// Rejected requests fail the same way when retried
+		if resp.StatusCode == http.StatusBadRequest || resp.StatusCode == http.StatusUnauthorized ||
+			resp.StatusCode == http.StatusForbidden {
+			return permanentError(err)
+		}
+		return err
+	}
+	return json.Unmarshal(respBody, out)
+}
+
+// uploadMedia uploads a PDF and returns its media ID
+func (cfg *whatsappConfig) uploadMedia(ctx context.Context, filename string, pdf []byte) (string, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	w.WriteField("messaging_product", "whatsapp")
+	w.WriteField("type", "application/pdf")
+	part, err := w.CreatePart(textproto.MIMEHeader{
+		"Content-Disposition": {fmt.Sprintf(`form-data; name="file"; filename=%q`, filename)},
+		"Content-Type":        {"application/pdf"},
+	})
+	if err != nil {
+		return "", err
+	}
+	part.Write(pdf)
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	var resp struct {
+		ID string `json:"id"`
+	}
+	if err := cfg.call(ctx, "/"+cfg.phoneNumberID+"/media", w.FormDataContentType(), &buf, &resp); err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// sendInvoice sends an uploaded invoice PDF to a phone number and returns
+// the message ID. With a template, its body is given the buyer's name, the
+// invoice number, the amount and the payment link, in that order.
+func (cfg *whatsappConfig) sendInvoice(ctx context.Context, phone, mediaID, filename string, data *invoiceEmailData) (string, error) {
+	message := map[string]interface{}{
+		"messaging_product": "whatsapp",
+		"to":                phone,
+	}
+	document := map[string]string{"id": mediaID, "filename": filename}
+	if cfg.template != "" {
+		text := func(s string) map[string]string {
+			return map[string]string{"type": "text", "text": firstNonEmpty(s, "-")}
+		}
+		message["type"] = "template"
+		message["template"] = map[string]interface{}{
+			"name":     cfg.template,
+			"language": map[string]string{"code": cfg.templateLanguage},
+			"components": []map[string]interface{}{
+				{"type": "header", "parameters": []map[string]interface{}{{"type": "document", "document": document}}},
+				{"type": "body", "parameters": []map[string]string{
+					text(data.BuyerName), text(data.InvoiceNo), text(fmt.Sprintf("Rs. %.2f", data.Total)), text(data.PaymentLink),
+				}},
+			},
+		}
+	} else {
+		caption := fmt.Sprintf("Invoice %s dated %s for Rs. %.2f from %s", data.InvoiceNo, data.Date, data.Total, data.SellerName)
+		if data.PaymentLink != "" {
+			caption += "\nPay online: " + data.PaymentLink
+		}
+		document["caption"] = caption
+		message["type"] = "document"
+		message["document"] = document
+	}
+
+	body, err := json.Marshal(message)
+	if err != nil {
+		return "", err
+	}
+	var resp struct {
+		Messages []struct {
+			ID string `json:"id"`
+		} `json:"messages"`
+	}
+	if err := cfg.call(ctx, "/"+cfg.phoneNumberID+"/messages", "application/json", bytes.NewReader(body), &resp); err != nil {
+		return "", err
+	}
+	if len(resp.Messages) == 0 || resp.Messages[0].ID == "" {
+		return "", errors.New("WhatsApp returned no message ID")
+	}
+	return resp.Messages[0].ID, nil
+}
+
+// runSendInvoiceWhatsAppJob sends a logged WhatsApp message with the invoice PDF
+func runSendInvoiceWhatsAppJob(ctx context.Context, job *models.Job) error {
+	var payload struct {
+		MessageID int `json:"message_id"`
+	}
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return permanentError(fmt.Errorf("invalid payload: %w", err))
+	}
+
+	var phone, status, irn string
+	var invoiceID int
+	var invoiceJSON []byte
+	err := dbPool.QueryRow(ctx, `
+		SELECT m.phone, m.status, i.id, i.invoice_json, COALESCE(i.irn, '')
+		FROM invoice_whatsapp_messages m
+		JOIN invoices i ON i.id = m.invoice_id
+		WHERE m.id = $1
+	`, payload.MessageID).Scan(&phone, &status, &invoiceID, &invoiceJSON, &irn)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return permanentError(fmt.Errorf("WhatsApp message %d not found", payload.MessageID))
+	}
+	if err != nil {
+		return err
+	}
+	// A retry after the message was accepted must not send it again
+	if status != whatsappQueued && status != whatsappFailed {
+		return nil
+	}
+
+	fail := func(err error) error {
+		if _, dbErr := dbPool.Exec(ctx,
+			"UPDATE invoice_whatsapp_messages SET status = $1, error = $2, updated_at = NOW() WHERE id = $3",
+			whatsappFailed, err.Error(), payload.MessageID); dbErr != nil {
+			log.Printf("Error recording failure of WhatsApp message %d: %v", payload.MessageID, dbErr)
+		}
+		return err
+	}
+
+	cfg, err := getWhatsAppConfig()
+	if err != nil {
+		return fail(permanentError(err))
+	}
+
+	var invoice models.EInvoice
+	if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+		return fail(permanentError(fmt.Errorf("unreadable invoice data: %w", err)))
+	}
+	paymentLink := activePaymentLinkURL(ctx, invoiceID)
+	pdf, err := renderInvoicePDF(&invoice, irn, paymentLink)
+	if err != nil {
+		return fail(permanentError(fmt.Errorf("failed to render PDF: %w", err)))
+	}
+
+	filename := sanitizeFilename(invoice.DocDtls.No) + ".pdf"
+	mediaID, err := cfg.uploadMedia(ctx, filename, pdf)
+	if err != nil {
+		return fail(err)
+	}
+	providerID, err := cfg.sendInvoice(ctx, phone, mediaID, filename, &invoiceEmailData{
+		InvoiceNo:   invoice.DocDtls.No,
+		Date:        invoice.DocDtls.Dt,
+		Total:       invoice.ValDtls.TotInvVal,
+		SellerName:  firstNonEmpty(invoice.SellerDtls.TrdNm, invoice.SellerDtls.LglNm),
+		BuyerName:   firstNonEmpty(invoice.BuyerDtls.TrdNm, invoice.BuyerDtls.LglNm),
+		PaymentLink: paymentLink,
+	})
+	if err != nil {
+		return fail(err)
+	}
+
+	_, err = dbPool.Exec(ctx, `
+		UPDATE invoice_whatsapp_messages
+		SET status = $1, provider_message_id = $2, error = NULL, sent_at = NOW(), updated_at = NOW()
+		WHERE id = $3
+	`, whatsappSent, providerID, payload.MessageID)
+	return err
+}
+
+// handleSendInvoiceWhatsApp queues the invoice PDF to be sent to the buyer on
+// WhatsApp. The phone number defaults to the buyer's on the invoice.
+func handleSendInvoiceWhatsApp(c *gin.Context) {
+	userID := c.GetInt("userID")
+	ctx := c.Request.Context()
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var req struct {
+		Phone string `json:"phone"`
+	}
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	if _, err := getWhatsAppConfig(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WhatsApp delivery is not configured"})
+		return
+	}
+
+	var invoiceJSON []byte
+	var status string
+	err = dbPool.QueryRow(ctx,
+		"SELECT invoice_json, status FROM invoices WHERE id = $1 AND user_id = $2",
+		id, userID).Scan(&invoiceJSON, &status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if status == models.InvoiceStatusDraft || status == models.InvoiceStatusPendingApproval {
+		c.JSON(http.StatusConflict, gin.H{"error": "Draft invoices cannot be sent; finalize the invoice first"})
+		return
+	}
+
+	if req.Phone == "" {
+		var invoice models.EInvoice
+		if err := json.Unmarshal(invoiceJSON, &invoice); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse invoice data"})
+			return
+		}
+		req.Phone = invoice.BuyerDtls.Ph
+	}
+	if strings.TrimSpace(req.Phone) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The buyer has no phone number; provide one in phone"})
+		return
+	}
+	phone, err := normalizeMobileNumber(req.Phone)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var messageID int
+	err = dbPool.QueryRow(ctx, `
+		INSERT INTO invoice_whatsapp_messages (invoice_id, user_id, phone, status)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id
+	`, id, userID, phone, whatsappQueued).Scan(&messageID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record message"})
+		return
+	}
+
+	jobID, err := enqueueJob(ctx, userID, jobTypeSendInvoiceWhatsApp, gin.H{"message_id": messageID})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to queue message"})
+		return
+	}
+	if _, err := dbPool.Exec(ctx,
+		"UPDATE invoice_whatsapp_messages SET job_id = $1 WHERE id = $2", jobID, messageID); err != nil {
+		log.Printf("Error linking WhatsApp message %d to job %d: %v", messageID, jobID, err)
+	}
+
+	c.JSON(http.StatusAccepted, gin.H{
+		"message":    "WhatsApp message queued",
+		"message_id": messageID,
+		"job_id":     jobID,
+		"phone":      phone,
+	})
+}
+
+// handleGetInvoiceWhatsAppMessages returns the log of WhatsApp messages sent
+// for an invoice with their delivery status, newest first
+func handleGetInvoiceWhatsAppMessages(c *gin.Context) {
+	userID := c.GetInt("userID")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid invoice ID"})
+		return
+	}
+
+	var exists bool
+	err = dbPool.QueryRow(c.Request.Context(),
+		"SELECT EXISTS (SELECT 1 FROM invoices WHERE id = $1 AND user_id = $2)", id, userID).Scan(&exists)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		return
+	}
+	if !exists {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Invoice not found or not authorized"})
+		return
+	}
+
+	rows, err := dbPool.Query(c.Request.Context(), `
+		SELECT id, phone, status, COALESCE(error, ''), job_id, sent_at, delivered_at, read_at, created_at
+		FROM invoice_whatsapp_messages
+		WHERE invoice_id = $1
+		ORDER BY created_at DESC
+	`, id)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	defer rows.Close()
+
+	messages := make([]gin.H, 0)
+	for rows.Next() {
+		var messageID int
+		var phone, status, messageErr string
+		var jobID *int
+		var sentAt, deliveredAt, readAt *time.Time
+		var createdAt time.Time
+		if err := rows.Scan(&messageID, &phone, &status, &messageErr, &jobID, &sentAt, &deliveredAt, &readAt, &createdAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to read message data"})
+			return
+		}
+		message := gin.H{
+			"id":           messageID,
+			"phone":        phone,
+			"status":       status,
+			"job_id":       jobID,
+			"sent_at":      sentAt,
+			"delivered_at": deliveredAt,
+			"read_at":      readAt,
+			"created_at":   createdAt,
+		}
+		if messageErr != "" {
+			message["error"] = messageErr
+		}
+		messages = append(messages, message)
+	}
+	if rowsFailed(c, rows, "Failed to fetch messages") {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"messages": messages})
+}
+
+// handleWhatsAppWebhookVerify answers the verification request Meta sends
+// when the webhook is set up, echoing the challenge if the verify token matches
+func handleWhatsAppWebhookVerify(c *gin.Context) {
+	cfg, err := getWhatsAppConfig()
+	if err != nil || cfg.verifyToken == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WhatsApp webhook is not configured"})
+		return
+	}
+	if c.Query("hub.mode") != "subscribe" ||
+		!hmac.Equal([]byte(c.Query("hub.verify_token")), []byte(cfg.verifyToken)) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Invalid verify token"})
+		return
+	}
+	c.String(http.StatusOK, c.Query("hub.challenge"))
+}
+
+// handleWhatsAppWebhook records the delivery status of sent messages. The
+// body is signed with the app secret in X-Hub-Signature-256.
+func handleWhatsAppWebhook(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	cfg, err := getWhatsAppConfig()
+	if err != nil || cfg.appSecret == "" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "WhatsApp webhook is not configured"})
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to read webhook"})
+		return
+	}
+	mac := hmac.New(sha256.New, []byte(cfg.appSecret))
+	mac.Write(body)
+	signature, err := hex.DecodeString(strings.TrimPrefix(c.GetHeader("X-Hub-Signature-256"), "sha256="))
+	if err != nil || !hmac.Equal(signature, mac.Sum(nil)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook signature"})
+		return
+	}
+
+	var notification struct {
+		Entry []struct {
+			Changes []struct {
+				Value struct {
+					Statuses []struct {
+						ID     string `json:"id"`
+						Status string `json:"status"`
+						Errors []struct {
+							Code      int    `json:"code"`
+							Title     string `json:"title"`
+							ErrorData struct {
+								Details string `json:"details"`
+							} `json:"error_data"`
+						} `json:"errors"`
+					} `json:"statuses"`
+				} `json:"value"`
+			} `json:"changes"`
+		} `json:"entry"`
+	}
+	if err := json.Unmarshal(body, &notification); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid webhook payload"})
+		return
+	}
+
+	updated := 0
+	for _, entry := range notification.Entry {
+		for _, change := range entry.Changes {
+			for _, s := range change.Value.Statuses {
+				earlier, ok := whatsappEarlierStatuses[s.Status]
+				if !ok || s.ID == "" {
+					continue
+				}
+				messageErr := ""
+				if len(s.Errors) > 0 {
+					e := s.Errors[0]
+					messageErr = fmt.Sprintf("%d: %s", e.Code, firstNonEmpty(e.ErrorData.Details, e.Title))
+				}
+				result, err := dbPool.Exec(ctx, `
+					UPDATE invoice_whatsapp_messages SET
+						status = $1,
+						error = NULLIF($2, ''),
+						delivered_at = CASE WHEN $1 IN ('delivered', 'read') THEN COALESCE(delivered_at, NOW()) ELSE delivered_at END,
+						read_at = CASE WHEN $1 = 'read' THEN NOW() ELSE read_at END,
+						updated_at = NOW()
+					WHERE provider_message_id = $3 AND status = ANY($4)
+				`, s.Status, messageErr, s.ID, earlier)
+				if err != nil {
+					log.Printf("Error recording status of WhatsApp message %s: %v", s.ID, err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record status"})
+					return
+				}
+				updated += int(result.RowsAffected())
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"updated": updated})
+}
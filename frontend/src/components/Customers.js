@@ -1,6 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import { Link, useNavigate } from 'react-router-dom';
 import { removeToken, getUserInfo } from '../utils/auth';
+import { lookupPincode } from '../utils/api';
 
 // Material UI components
 import {
@@ -156,6 +157,22 @@ function Customers() {
     });
   };
 
+  // Fill a blank city and state once a full PIN code is entered
+  const handleCustomerPincodeBlur = async () => {
+    const pin = String(editingCustomer?.pincode || '');
+    if (pin.length !== 6 || (editingCustomer.city && editingCustomer.state)) return;
+    try {
+      const { data } = await lookupPincode(pin);
+      setEditingCustomer((customer) => ({
+        ...customer,
+        city: customer.city || data.city,
+        state: customer.state || data.state_name
+      }));
+    } catch (err) {
+      // Unknown PIN codes are left for the user to complete
+    }
+  };
+
   const handleConfirmDelete = () => {
     setCustomers(customers.filter(customer => customer.id !== customerToDelete.id));
     setDeleteConfirmOpen(false);
@@ -332,6 +349,7 @@ function Customers() {
                 label="PIN Code"
                 value={editingCustomer?.pincode || ''}
                 onChange={(e) => handleCustomerChange('pincode', e.target.value)}
+                onBlur={handleCustomerPincodeBlur}
               />
             </Grid>
             <Grid item xs={12} md={6}>
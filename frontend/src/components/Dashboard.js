@@ -182,7 +182,7 @@ function Dashboard() {
         return;
       }
 
-      const response = await fetch(`/api/invoices/${id}`, {
+      const response = await fetch(`/api/v1/invoices/${id}`, {
         method: 'DELETE',
         headers: {
           'Authorization': `Bearer ${token}`,
@@ -217,7 +217,7 @@ function Dashboard() {
         return;
       }
 
-      const response = await fetch(`/api/export-json/${id}`, {
+      const response = await fetch(`/api/v1/export-json/${id}`, {
         headers: {
           'Authorization': `Bearer ${token}`
         }
@@ -228,6 +228,19 @@ function EditInvoice() {
     }
   };
 
+  const handleRefChange = (field, value) => {
+    if (invoice) {
+      const refDtls = invoice.RefDtls || {};
+      const contract = (refDtls.ContrDtls && refDtls.ContrDtls[0]) || {};
+      setInvoice({
+        ...invoice,
+        RefDtls: field === 'InvRm'
+          ? { ...refDtls, InvRm: value }
+          : { ...refDtls, ContrDtls: [{ ...contract, [field]: value }] }
+      });
+    }
+  };
+
   const calculateTotals = () => {
     if (invoice) {
       let totalAssVal = 0;
@@ -384,6 +397,35 @@ function EditInvoice() {
                 </Select>
               </FormControl>
             </Grid>
+            <Grid item xs={12} md={4}>
+              <TextField
+                label="PO Number"
+                fullWidth
+                value={invoice.RefDtls?.ContrDtls?.[0]?.PORefr || ''}
+                onChange={(e) => handleRefChange('PORefr', e.target.value)}
+                margin="normal"
+              />
+            </Grid>
+            <Grid item xs={12} md={4}>
+              <TextField
+                label="PO Date"
+                fullWidth
+                value={invoice.RefDtls?.ContrDtls?.[0]?.PORefDt || ''}
+                onChange={(e) => handleRefChange('PORefDt', e.target.value)}
+                margin="normal"
+                placeholder="DD/MM/YYYY"
+              />
+            </Grid>
+            <Grid item xs={12} md={4}>
+              <TextField
+                label="Remarks"
+                fullWidth
+                multiline
+                value={invoice.RefDtls?.InvRm || ''}
+                onChange={(e) => handleRefChange('InvRm', e.target.value)}
+                margin="normal"
+              />
+            </Grid>
           </Grid>
         </Paper>
         
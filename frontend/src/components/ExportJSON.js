@@ -39,7 +39,7 @@ const ExportJSON = () => {
         return;
       }
 
-      const response = await fetch('/api/invoices', {
+      const response = await fetch('/api/v1/invoices', {
         headers: {
           'Authorization': `Bearer ${token}`
         }
@@ -81,7 +81,7 @@ const ExportJSON = () => {
         return;
       }
 
-      const response = await fetch('/api/export-all-json', {
+      const response = await fetch('/api/v1/export-all-json', {
         headers: {
           'Authorization': `Bearer ${token}`
         }
@@ -116,7 +116,7 @@ const ExportJSON = () => {
         return;
       }
 
-      const response = await fetch(`/api/export-json/${id}`, {
+      const response = await fetch(`/api/v1/export-json/${id}`, {
         headers: {
           'Authorization': `Bearer ${token}`
         }
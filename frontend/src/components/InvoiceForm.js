@@ -98,6 +98,9 @@ function InvoiceForm() {
       
       invoiceNo: '',
       invoiceDate: formatDate(),
+      remarks: '',
+      poNumber: '',
+      poDate: '',
       
       buyerGstin: 'URP', // Default for unregistered person
       buyerName: '',
@@ -217,6 +220,14 @@ function InvoiceForm() {
           CntCode: null
         }
       }];
+
+      // Remarks and the buyer's purchase order travel in the reference details
+      if (data.remarks || data.poNumber) {
+        invoiceData[0].RefDtls = {
+          InvRm: data.remarks || '',
+          ContrDtls: data.poNumber ? [{ PORefr: data.poNumber, PORefDt: data.poDate || '' }] : []
+        };
+      }
       
       const response = await generateInvoice(invoiceData);
       setInvoiceResult(response.data);
@@ -449,6 +460,35 @@ function InvoiceForm() {
                   disabled={isLoading}
                 />
               </Grid>
+              <Grid item xs={12} md={6}>
+                <TextField
+                  label="PO Number"
+                  fullWidth
+                  margin="normal"
+                  {...register('poNumber')}
+                  disabled={isLoading}
+                />
+              </Grid>
+              <Grid item xs={12} md={6}>
+                <TextField
+                  label="PO Date"
+                  fullWidth
+                  margin="normal"
+                  placeholder="DD/MM/YYYY"
+                  {...register('poDate')}
+                  disabled={isLoading}
+                />
+              </Grid>
+              <Grid item xs={12}>
+                <TextField
+                  label="Remarks"
+                  fullWidth
+                  multiline
+                  margin="normal"
+                  {...register('remarks')}
+                  disabled={isLoading}
+                />
+              </Grid>
             </Grid>
 
             <Divider sx={{ my: 3 }} />
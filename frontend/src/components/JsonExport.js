@@ -93,11 +93,11 @@ function JsonExport() {
   };
 
   const handleExportSingleJson = (id) => {
-    window.open(`/api/export-json/${id}`, '_blank');
+    window.open(`/api/v1/export-json/${id}`, '_blank');
   };
 
   const handleExportAllJson = () => {
-    window.open('/api/export-all-json', '_blank');
+    window.open('/api/v1/export-all-json', '_blank');
   };
 
   return (
@@ -42,7 +42,7 @@ function QRCodeView() {
           return;
         }
 
-        const response = await fetch(`/api/qr/${id}`, {
+        const response = await fetch(`/api/v1/qr/${id}`, {
           headers: {
             'Authorization': `Bearer ${token}`
           }
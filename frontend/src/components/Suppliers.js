@@ -1,7 +1,7 @@
 import React, { useState, useEffect } from 'react';
 import { Link, useNavigate } from 'react-router-dom';
 import { removeToken, getUserInfo } from '../utils/auth';
-import { getSuppliers, createSupplier, updateSupplier, deleteSupplier } from '../utils/api';
+import { getSuppliers, createSupplier, updateSupplier, deleteSupplier, lookupPincode } from '../utils/api';
 
 // Material UI components
 import {
@@ -152,6 +152,22 @@ function Suppliers() {
     });
   };
 
+  // Fill a blank city and state once a full PIN code is entered
+  const handleSupplierPincodeBlur = async () => {
+    const pin = String(editingSupplier?.pincode || '');
+    if (pin.length !== 6 || (editingSupplier.city && editingSupplier.state)) return;
+    try {
+      const { data } = await lookupPincode(pin);
+      setEditingSupplier((supplier) => ({
+        ...supplier,
+        city: supplier.city || data.city,
+        state: supplier.state || data.state_name
+      }));
+    } catch (err) {
+      // Unknown PIN codes are left for the user to complete
+    }
+  };
+
   const handleConfirmDelete = async () => {
     try {
       await deleteSupplier(supplierToDelete.id);
@@ -333,6 +349,7 @@ function Suppliers() {
                 label="Pincode"
                 value={editingSupplier?.pincode || ''}
                 onChange={(e) => handleSupplierChange('pincode', e.target.value)}
+                onBlur={handleSupplierPincodeBlur}
               />
             </Grid>
             <Grid item xs={12} md={6}>
@@ -1,9 +1,10 @@
 import axios from 'axios';
 import { getToken, removeToken } from './auth';
 
-// Get API URL from environment variable or use default
-const API_BASE_URL = process.env.REACT_APP_API_BASE_URL || 'http://localhost:8080';
-const API_URL = `${API_BASE_URL}/api`;
+// Get API URL from environment variable; by default the API is on the same
+// origin, as when the backend serves the app or the dev server proxies to it
+const API_BASE_URL = process.env.REACT_APP_API_BASE_URL || '';
+const API_URL = `${API_BASE_URL}/api/v1`;
 
 // Create axios instance with baseURL
 const api = axios.create({
@@ -72,7 +73,7 @@ export const downloadInvoices = () => {
 
 export const getQRCode = (id) => {
   // Update to use environment variable for API URL
-  return `${API_BASE_URL}/api/qr/${id}`;
+  return `${API_URL}/qr/${id}`;
 };
 
 // New JSON Import/Export endpoints
@@ -147,11 +148,21 @@ export const deleteSupplier = (id) => {
   return api.delete(`/suppliers/${id}`);
 };
 
+// PIN code directory: city, district and state of a PIN code
+export const lookupPincode = (pin) => {
+  return api.get(`/pincode/${pin}`);
+};
+
+// E-way bill distance between two PIN codes
+export const getEwbDistance = (from, to) => {
+  return api.get('/ewb/distance', { params: { from, to } });
+};
+
 // Template download
 export const downloadExcelTemplate = () => {
   // Create a temporary link element
   const link = document.createElement('a');
-  link.href = `${API_BASE_URL}/api/download-template`;
+  link.href = `${API_URL}/download-template`;
   link.setAttribute('download', 'invoice_template.xlsx');
   
   // Add the auth token if needed